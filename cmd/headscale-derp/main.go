@@ -0,0 +1,223 @@
+// headscale-derp is a standalone DERP relay running the DERP server
+// embedded in headscale. It registers itself with headscale, which adds
+// it to the DERPMap as its own region while it sends heartbeats.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/derp/server"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/types/key"
+)
+
+var (
+	headscaleURL   = flag.String("headscale", "", "URL of the headscale server to register with, like https://headscale.example.com")
+	apiKeyFile     = flag.String("api-key-file", "", "file holding the headscale API key limited to the region, defaults to the HEADSCALE_DERP_API_KEY environment variable")
+	hostname       = flag.String("hostname", "", "public hostname of the relay, its TLS certificate must be valid for it")
+	regionID       = flag.Int("region-id", 0, "DERP region ID of the relay, unique among the regions of the DERPMap")
+	regionCode     = flag.String("region-code", "", "short code of the region, like fra")
+	regionName     = flag.String("region-name", "", "name of the region, like Frankfurt")
+	listenAddr     = flag.String("listen-addr", ":443", "address to serve DERP on")
+	stunListenAddr = flag.String("stun-listen-addr", ":3478", "UDP address to serve STUN on")
+	derpPort       = flag.Int("derp-port", 0, "advertised DERP port, if it differs from the port of -listen-addr")
+	stunPort       = flag.Int("stun-port", 0, "advertised STUN port, if it differs from the port of -stun-listen-addr")
+	ipv4           = flag.String("ipv4", "", "public IPv4 address of the relay, skips DNS lookups of the clients")
+	ipv6           = flag.String("ipv6", "", "public IPv6 address of the relay, skips DNS lookups of the clients")
+	latitude       = flag.Float64("latitude", 0, "latitude of the relay")
+	longitude      = flag.Float64("longitude", 0, "longitude of the relay")
+	privateKeyPath = flag.String("private-key-path", "derp_server_private.key", "file the DERP private key is read from, created if missing")
+	tlsCertPath    = flag.String("tls-cert", "", "TLS certificate, serves plain HTTP without it for a TLS terminating proxy in front")
+	tlsKeyPath     = flag.String("tls-key", "", "TLS private key")
+	heartbeat      = flag.Duration("heartbeat", time.Minute, "interval of the heartbeats, below derp.relays.expiry of headscale")
+	logLevel       = flag.String("log-level", "info", "log level")
+)
+
+func main() {
+	flag.Parse()
+
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	log.Logger = log.Output(util.NewRedactingWriter(zerolog.ConsoleWriter{
+		Out:        os.Stderr,
+		TimeFormat: time.RFC3339,
+	}))
+	level, err := zerolog.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid -log-level")
+	}
+	zerolog.SetGlobalLevel(level)
+
+	if err := run(); err != nil {
+		log.Fatal().Err(err).Msg("headscale-derp failed")
+	}
+}
+
+func run() error {
+	if *headscaleURL == "" || *hostname == "" || *regionID <= 0 {
+		return errors.New("-headscale, -hostname and -region-id are required")
+	}
+	if (*tlsCertPath == "") != (*tlsKeyPath == "") {
+		return errors.New("-tls-cert and -tls-key must be set together")
+	}
+
+	apiKey, err := readAPIKey()
+	if err != nil {
+		return err
+	}
+
+	derpKey, err := readOrCreateDERPKey(*privateKeyPath)
+	if err != nil {
+		return err
+	}
+
+	port := *derpPort
+	if port == 0 {
+		_, portStr, err := net.SplitHostPort(*listenAddr)
+		if err != nil {
+			return fmt.Errorf("parsing -listen-addr: %w", err)
+		}
+		if port, err = strconv.Atoi(portStr); err != nil {
+			return fmt.Errorf("parsing -listen-addr: %w", err)
+		}
+	}
+
+	cfg := &types.DERPConfig{
+		ServerEnabled:    true,
+		ServerRegionID:   *regionID,
+		ServerRegionCode: *regionCode,
+		ServerRegionName: *regionName,
+		STUNAddr:         *stunListenAddr,
+		IPv4:             *ipv4,
+		IPv6:             *ipv6,
+		ServerHostname:   *hostname,
+		ServerDERPPort:   port,
+		ServerSTUNPort:   *stunPort,
+		Latitude:         *latitude,
+		Longitude:        *longitude,
+	}
+
+	derpServer, err := server.NewDERPServer("https://"+net.JoinHostPort(*hostname, strconv.Itoa(port)), derpKey, cfg)
+	if err != nil {
+		return fmt.Errorf("creating DERP server: %w", err)
+	}
+
+	region, err := derpServer.GenerateRegion()
+	if err != nil {
+		return fmt.Errorf("generating DERP region: %w", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// A relay that cannot register, because of a wrong API key or a
+	// region ID already in use, exits rather than serving nobody.
+	registrar := newRegistrar(*headscaleURL, apiKey)
+	relay := relayFromRegion(region)
+	if _, err := registrar.register(ctx, relay); err != nil {
+		return err
+	}
+	log.Info().
+		Int("region_id", region.RegionID).
+		Str("headscale", *headscaleURL).
+		Msg("Registered with headscale")
+
+	go registrar.heartbeat(ctx, relay, *heartbeat)
+	go derpServer.ServeSTUN()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/derp", derpServer.DERPHandler)
+	mux.HandleFunc("/derp/probe", server.DERPProbeHandler)
+	mux.HandleFunc("/derp/latency-check", server.DERPProbeHandler)
+	mux.HandleFunc("/generate_204", func(writer http.ResponseWriter, req *http.Request) {
+		writer.WriteHeader(http.StatusNoContent)
+	})
+
+	httpServer := &http.Server{
+		Addr:              *listenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: types.HTTPTimeout,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), types.HTTPTimeout)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+
+	log.Info().Str("addr", *listenAddr).Msg("Serving DERP")
+	if *tlsCertPath != "" {
+		err = httpServer.ListenAndServeTLS(*tlsCertPath, *tlsKeyPath)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+func readAPIKey() (string, error) {
+	if *apiKeyFile == "" {
+		apiKey := os.Getenv("HEADSCALE_DERP_API_KEY")
+		if apiKey == "" {
+			return "", errors.New("-api-key-file or HEADSCALE_DERP_API_KEY is required")
+		}
+
+		return apiKey, nil
+	}
+
+	data, err := os.ReadFile(*apiKeyFile)
+	if err != nil {
+		return "", fmt.Errorf("reading API key: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// readOrCreateDERPKey returns the private key of the DERP server, so
+// the relay keeps its identity across restarts. It is stored in the
+// format of derp.server.private_key_path of headscale.
+func readOrCreateDERPKey(path string) (key.NodePrivate, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		derpKey := key.NewMachine()
+		text, err := derpKey.MarshalText()
+		if err != nil {
+			return key.NodePrivate{}, err
+		}
+		if err := util.EnsureDir(filepath.Dir(path)); err != nil {
+			return key.NodePrivate{}, err
+		}
+		if err := os.WriteFile(path, text, 0o600); err != nil {
+			return key.NodePrivate{}, fmt.Errorf("saving DERP private key: %w", err)
+		}
+		log.Info().Str("path", path).Msg("Created DERP private key")
+
+		return key.NodePrivate(derpKey), nil
+	} else if err != nil {
+		return key.NodePrivate{}, fmt.Errorf("reading DERP private key: %w", err)
+	}
+
+	var derpKey key.MachinePrivate
+	if err := derpKey.UnmarshalText([]byte(strings.TrimSpace(string(data)))); err != nil {
+		return key.NodePrivate{}, fmt.Errorf("parsing DERP private key: %w", err)
+	}
+
+	return key.NodePrivate(derpKey), nil
+}
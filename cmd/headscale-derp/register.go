@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/encoding/protojson"
+	"tailscale.com/tailcfg"
+)
+
+const registerPath = "/api/v1/derp/relays"
+
+// registrar registers the relay with headscale, over the API
+// authenticated with an API key.
+type registrar struct {
+	headscaleURL string
+	apiKey       string
+	client       *http.Client
+}
+
+func newRegistrar(headscaleURL, apiKey string) *registrar {
+	return &registrar{
+		headscaleURL: strings.TrimSuffix(headscaleURL, "/"),
+		apiKey:       apiKey,
+		client:       &http.Client{Timeout: types.HTTPTimeout},
+	}
+}
+
+// relayFromRegion returns the registration of the region served by the
+// relay, it only has one node.
+func relayFromRegion(region tailcfg.DERPRegion) *v1.DERPRelay {
+	node := region.Nodes[0]
+
+	return &v1.DERPRelay{
+		RegionId:   int32(region.RegionID),
+		RegionCode: region.RegionCode,
+		RegionName: region.RegionName,
+		Hostname:   node.HostName,
+		Ipv4:       node.IPv4,
+		Ipv6:       node.IPv6,
+		DerpPort:   int32(node.DERPPort),
+		StunPort:   int32(node.STUNPort),
+		Latitude:   region.Latitude,
+		Longitude:  region.Longitude,
+	}
+}
+
+// register registers the relay, headscale counts every registration as
+// a heartbeat.
+func (r *registrar) register(ctx context.Context, relay *v1.DERPRelay) (*v1.DERPRelay, error) {
+	body, err := protojson.Marshal(&v1.RegisterDERPRelayRequest{Relay: relay})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.headscaleURL+registerPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.apiKey)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registering with headscale: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var response v1.RegisterDERPRelayResponse
+	if err := protojson.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("parsing registration response: %w", err)
+	}
+
+	return response.GetRelay(), nil
+}
+
+// heartbeat registers the relay every interval until ctx is done. The
+// relay keeps serving when headscale cannot be reached, it is removed
+// from the DERPMap once the registration expires.
+func (r *registrar) heartbeat(ctx context.Context, relay *v1.DERPRelay, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.register(ctx, relay); err != nil {
+				log.Error().Err(err).Msg("Failed to send heartbeat to headscale")
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestRegister(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != registerPath || req.Method != http.MethodPost {
+			t.Errorf("request = %s %s, want POST %s", req.Method, req.URL.Path, registerPath)
+		}
+		if auth := req.Header.Get("Authorization"); auth != "Bearer hskey" {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %s", err)
+		}
+		_, _ = writer.Write([]byte(`{"relay": {"regionId": 900, "online": true}}`))
+	}))
+	defer srv.Close()
+
+	relay := relayFromRegion(tailcfg.DERPRegion{
+		RegionID:   900,
+		RegionCode: "fra",
+		Nodes: []*tailcfg.DERPNode{
+			{HostName: "fra.derp.example.com", DERPPort: 443, STUNPort: 3478},
+		},
+	})
+
+	if _, err := newRegistrar(srv.URL, "wrong").register(context.Background(), relay); err == nil {
+		t.Errorf("register() with a wrong API key succeeded")
+	}
+
+	registered, err := newRegistrar(srv.URL+"/", "hskey").register(context.Background(), relay)
+	if err != nil {
+		t.Fatalf("register() error = %s", err)
+	}
+	if !registered.GetOnline() {
+		t.Errorf("registered relay is not online")
+	}
+
+	sent, _ := got["relay"].(map[string]any)
+	if sent["hostname"] != "fra.derp.example.com" || sent["regionCode"] != "fra" {
+		t.Errorf("registered relay = %v, want fra.derp.example.com in fra", sent)
+	}
+}
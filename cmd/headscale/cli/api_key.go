@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
@@ -25,6 +26,10 @@ func init() {
 
 	createAPIKeyCmd.Flags().
 		StringP("expiration", "e", DefaultAPIKeyExpiry, "Human-readable expiration of the key (e.g. 30m, 24h)")
+	createAPIKeyCmd.Flags().
+		StringSlice("tags", []string{}, "Only allow the key to add and remove these tags on nodes")
+	createAPIKeyCmd.Flags().
+		Int("derp-region", 0, "Only allow the key to register the DERP relay of this region, for headscale-derp")
 
 	apiKeysCmd.AddCommand(createAPIKeyCmd)
 
@@ -74,7 +79,7 @@ var listAPIKeys = &cobra.Command{
 		}
 
 		tableData := pterm.TableData{
-			{"ID", "Prefix", "Expiration", "Created"},
+			{"ID", "Prefix", "Expiration", "Created", "Tags", "DERP region"},
 		}
 		for _, key := range response.GetApiKeys() {
 			expiration := "-"
@@ -83,11 +88,18 @@ var listAPIKeys = &cobra.Command{
 				expiration = ColourTime(key.GetExpiration().AsTime())
 			}
 
+			derpRegion := "-"
+			if key.GetDerpRegionId() != 0 {
+				derpRegion = strconv.Itoa(int(key.GetDerpRegionId()))
+			}
+
 			tableData = append(tableData, []string{
 				strconv.FormatUint(key.GetId(), util.Base10),
 				key.GetPrefix(),
 				expiration,
 				key.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+				strings.Join(key.GetTags(), ", "),
+				derpRegion,
 			})
 
 		}
@@ -129,6 +141,9 @@ If you loose a key, create a new one and revoke (expire) the old one.`,
 		expiration := time.Now().UTC().Add(time.Duration(duration))
 
 		request.Expiration = timestamppb.New(expiration)
+		request.Tags, _ = cmd.Flags().GetStringSlice("tags")
+		derpRegion, _ := cmd.Flags().GetInt("derp-region")
+		request.DerpRegionId = int32(derpRegion)
 
 		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
 		defer cancel()
@@ -0,0 +1,294 @@
+package cli
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	configCheckOK      = "ok"
+	configCheckWarning = "warning"
+	configCheckError   = "error"
+	configCheckSkipped = "skipped"
+
+	// certificateExpiryWarning is how long before a certificate expires
+	// the validation warns about it.
+	certificateExpiryWarning = 14 * 24 * time.Hour
+
+	oidcDiscoveryTimeout = 10 * time.Second
+)
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(validateConfigCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the headscale configuration",
+}
+
+// configCheck is the result of one check of the configuration.
+type configCheck struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+}
+
+var validateConfigCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the configuration",
+	Long: `
+Fully parse the configuration, load the policy file, check the TLS
+certificates and that the OIDC issuers are reachable, and list
+configuration keys headscale does not know. Exits with status 1 if a
+check fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		checks := validateConfig(cmd.Context())
+
+		failed := false
+		for _, check := range checks {
+			if check.Status == configCheckError {
+				failed = true
+			}
+		}
+
+		if output != "" {
+			if failed {
+				fmt.Fprintln(os.Stderr, configChecksOutput(checks, output))
+				os.Exit(1)
+			}
+			SuccessOutput(checks, "", output)
+		}
+
+		tableData := pterm.TableData{{"Check", "Status", "Details"}}
+		for _, check := range checks {
+			status := check.Status
+			switch check.Status {
+			case configCheckOK:
+				status = pterm.LightGreen(status)
+			case configCheckWarning:
+				status = pterm.LightYellow(status)
+			case configCheckError:
+				status = pterm.LightRed(status)
+			}
+
+			tableData = append(tableData, []string{check.Check, status, check.Details})
+		}
+
+		if err := pterm.DefaultTable.WithHasHeader().WithData(tableData).Render(); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Failed to render pterm table: %s", err), output)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// configChecksOutput formats the checks for machine readable output,
+// the output flag of the command shadows output.
+func configChecksOutput(checks []configCheck, outputFormat string) string {
+	return output(checks, "", outputFormat)
+}
+
+// validateConfig runs all checks of the configuration. The checks that
+// need the parsed configuration are skipped if it cannot be parsed.
+func validateConfig(ctx context.Context) []configCheck {
+	var checks []configCheck
+
+	unknown, err := types.UnknownConfigKeys()
+	switch {
+	case err != nil:
+		checks = append(checks, configCheck{"unknown keys", configCheckError, err.Error()})
+	case len(unknown) > 0:
+		status := configCheckWarning
+		if viper.GetBool("strict_config") {
+			status = configCheckError
+		}
+		checks = append(checks, configCheck{"unknown keys", status, strings.Join(unknown, ", ")})
+	default:
+		checks = append(checks, configCheck{"unknown keys", configCheckOK, ""})
+	}
+
+	cfg, err := types.LoadServerConfig()
+	if err != nil {
+		return append(checks, configCheck{"configuration", configCheckError, err.Error()})
+	}
+	checks = append(checks, configCheck{"configuration", configCheckOK, viper.ConfigFileUsed()})
+
+	checks = append(checks, validatePolicyConfig(cfg))
+	checks = append(checks, validateTLSConfig(cfg, time.Now())...)
+
+	for _, provider := range cfg.OIDC.Providers() {
+		checks = append(checks, validateOIDCIssuer(ctx, provider))
+	}
+
+	return checks
+}
+
+func validatePolicyConfig(cfg *types.Config) configCheck {
+	check := configCheck{Check: "policy"}
+
+	switch {
+	case cfg.Policy.Mode == types.PolicyModeDB:
+		check.Status = configCheckSkipped
+		check.Details = "the policy is stored in the database, use `headscale policy check`"
+	case cfg.Policy.Path == "":
+		check.Status = configCheckSkipped
+		check.Details = "no policy.path, all nodes can reach each other"
+	default:
+		pol, err := policy.LoadACLPolicyFromPath(cfg.Policy.Path)
+		if err == nil {
+			err = pol.CheckDisallowed(cfg.Policy.Disallow)
+		}
+		if err == nil && cfg.Policy.Strict {
+			err = pol.CheckDeprecations(true)
+		}
+
+		switch deprecations := pol.Deprecations(); {
+		case err != nil:
+			check.Status = configCheckError
+			check.Details = err.Error()
+		case len(deprecations) > 0:
+			details := make([]string, len(deprecations))
+			for index, deprecation := range deprecations {
+				details[index] = deprecation.String()
+			}
+			check.Status = configCheckWarning
+			check.Details = strings.Join(details, ", ")
+		default:
+			check.Status = configCheckOK
+			check.Details = cfg.Policy.Path
+		}
+	}
+
+	return check
+}
+
+// validateTLSConfig loads the certificates of the server and of the
+// listeners, and checks that they are not expired.
+func validateTLSConfig(cfg *types.Config, now time.Time) []configCheck {
+	if cfg.TLS.LetsEncrypt.Hostname != "" {
+		return []configCheck{{
+			Check:   "tls",
+			Status:  configCheckOK,
+			Details: "certificates for " + cfg.TLS.LetsEncrypt.Hostname + " are requested from Let's Encrypt",
+		}}
+	}
+
+	type pair struct{ name, cert, key string }
+
+	pairs := []pair{{"tls", cfg.TLS.CertPath, cfg.TLS.KeyPath}}
+	for index, listener := range cfg.Listeners {
+		if listener.TLSCertPath != "" {
+			pairs = append(pairs, pair{
+				fmt.Sprintf("tls listeners[%d]", index),
+				listener.TLSCertPath,
+				listener.TLSKeyPath,
+			})
+		}
+	}
+
+	var checks []configCheck
+	for _, pair := range pairs {
+		if pair.cert == "" && pair.key == "" {
+			checks = append(checks, configCheck{pair.name, configCheckSkipped, "TLS is not configured"})
+
+			continue
+		}
+
+		checks = append(checks, validateCertificate(pair.name, pair.cert, pair.key, now))
+	}
+
+	return checks
+}
+
+func validateCertificate(name, certPath, keyPath string, now time.Time) configCheck {
+	keyPair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return configCheck{name, configCheckError, err.Error()}
+	}
+
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return configCheck{name, configCheckError, err.Error()}
+	}
+
+	switch {
+	case now.After(leaf.NotAfter):
+		return configCheck{name, configCheckError, fmt.Sprintf("certificate expired on %s", leaf.NotAfter.Format(time.DateOnly))}
+	case leaf.NotAfter.Sub(now) < certificateExpiryWarning:
+		return configCheck{name, configCheckWarning, fmt.Sprintf("certificate expires on %s", leaf.NotAfter.Format(time.DateOnly))}
+	}
+
+	return configCheck{name, configCheckOK, fmt.Sprintf("certificate valid until %s", leaf.NotAfter.Format(time.DateOnly))}
+}
+
+// validateOIDCIssuer fetches the discovery document of the issuer.
+func validateOIDCIssuer(ctx context.Context, provider types.OIDCProviderConfig) configCheck {
+	check := configCheck{Check: "oidc " + provider.Issuer}
+	if provider.Name != "" {
+		check.Check = "oidc " + provider.Name
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, oidcDiscoveryTimeout)
+	defer cancel()
+
+	url := strings.TrimSuffix(provider.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		check.Status, check.Details = configCheckError, err.Error()
+
+		return check
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		check.Status, check.Details = configCheckError, err.Error()
+
+		return check
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		check.Status, check.Details = configCheckError, fmt.Sprintf("%s returned %s", url, resp.Status)
+
+		return check
+	}
+
+	var discovery struct {
+		Issuer string `json:"issuer"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&discovery); err != nil {
+		check.Status, check.Details = configCheckError, fmt.Sprintf("decoding discovery document: %s", err)
+
+		return check
+	}
+
+	if strings.TrimSuffix(discovery.Issuer, "/") != strings.TrimSuffix(provider.Issuer, "/") {
+		check.Status = configCheckError
+		check.Details = fmt.Sprintf("discovery document is for issuer %q", discovery.Issuer)
+
+		return check
+	}
+
+	check.Status, check.Details = configCheckOK, provider.Issuer
+
+	return check
+}
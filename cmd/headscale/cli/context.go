@@ -0,0 +1,305 @@
+package cli
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
+)
+
+// pinTimeout limits fetching the certificate of a server to pin it.
+const pinTimeout = 10 * time.Second
+
+func init() {
+	rootCmd.AddCommand(contextCmd)
+	contextCmd.AddCommand(listContextsCmd)
+	contextCmd.AddCommand(useContextCmd)
+	contextCmd.AddCommand(unsetContextCmd)
+	contextCmd.AddCommand(removeContextCmd)
+
+	addContextCmd.Flags().String("address", "", "Address of the gRPC API, like headscale.example.com:50443")
+	addContextCmd.Flags().String("api-key", "", "API key, or a ${VAR} or file: reference")
+	addContextCmd.Flags().String("tls-fingerprint", "", "SHA-256 fingerprint of the server certificate to trust")
+	addContextCmd.Flags().Bool("pin", false, "Trust the certificate the server presents now")
+	addContextCmd.Flags().Bool("insecure", false, "Do not verify the server certificate")
+	addContextCmd.Flags().Bool("plaintext", false, "Connect without TLS, like to the tailnet admin node")
+	addContextCmd.Flags().String("timeout", "", "Timeout of commands, like 10s")
+	addContextCmd.Flags().Bool("use", false, "Switch to the context")
+	addContextCmd.MarkFlagRequired("address")
+	addContextCmd.MarkFlagRequired("api-key")
+	addContextCmd.MarkFlagsMutuallyExclusive("tls-fingerprint", "pin", "insecure", "plaintext")
+	contextCmd.AddCommand(addContextCmd)
+}
+
+var contextCmd = &cobra.Command{
+	Use:   "context",
+	Short: "Manage the headscale servers the CLI connects to",
+	Long: `
+Contexts are headscale servers the CLI connects to remotely, with their
+address, API key and pinned certificate. They are kept in
+~/.config/headscale/contexts.yaml. The current context is used unless
+--context or cli.address select another server.`,
+	Aliases: []string{"contexts", "ctx"},
+}
+
+func loadContexts() (string, *types.CLIContexts, error) {
+	path, err := types.CLIContextsPath()
+	if err != nil {
+		return "", nil, err
+	}
+
+	contexts, err := types.LoadCLIContexts(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return path, contexts, nil
+}
+
+// contextListItem is a context as shown by context list, without its
+// API key.
+type contextListItem struct {
+	Name           string `json:"name"`
+	Current        bool   `json:"current"`
+	Address        string `json:"address"`
+	TLSFingerprint string `json:"tls_fingerprint,omitempty"`
+	Insecure       bool   `json:"insecure,omitempty"`
+	Plaintext      bool   `json:"plaintext,omitempty"`
+}
+
+var listContextsCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the contexts",
+	Aliases: []string{"ls", "show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		_, contexts, err := loadContexts()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading contexts: %s", err), output)
+		}
+
+		names := maps.Keys(contexts.Contexts)
+		slices.Sort(names)
+
+		items := make([]contextListItem, 0, len(names))
+		for _, name := range names {
+			cliContext := contexts.Contexts[name]
+			items = append(items, contextListItem{
+				Name:           name,
+				Current:        name == contexts.Current,
+				Address:        cliContext.Address,
+				TLSFingerprint: cliContext.TLSFingerprint,
+				Insecure:       cliContext.Insecure,
+				Plaintext:      cliContext.Plaintext,
+			})
+		}
+
+		if output != "" {
+			SuccessOutput(items, "", output)
+		}
+
+		tableData := pterm.TableData{{"", "Name", "Address", "TLS"}}
+		for _, item := range items {
+			current := ""
+			if item.Current {
+				current = "*"
+			}
+
+			tlsMode := "verified"
+			switch {
+			case item.Plaintext:
+				tlsMode = "none"
+			case item.Insecure:
+				tlsMode = "insecure"
+			case item.TLSFingerprint != "":
+				tlsMode = "pinned"
+			}
+
+			tableData = append(tableData, []string{current, item.Name, item.Address, tlsMode})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Failed to render pterm table: %s", err), output)
+		}
+	},
+}
+
+var addContextCmd = &cobra.Command{
+	Use:   "add NAME",
+	Short: "Add or replace a context",
+	Long: `
+Add a context for a headscale server. Use --pin to trust the certificate
+the server presents now, for servers with self signed certificates, or
+--tls-fingerprint with the output of
+openssl x509 -noout -fingerprint -sha256 -in cert.pem`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		name := args[0]
+
+		cliContext := &types.CLIContext{}
+		cliContext.Address, _ = cmd.Flags().GetString("address")
+		cliContext.APIKey, _ = cmd.Flags().GetString("api-key")
+		cliContext.Insecure, _ = cmd.Flags().GetBool("insecure")
+		cliContext.Plaintext, _ = cmd.Flags().GetBool("plaintext")
+		cliContext.Timeout, _ = cmd.Flags().GetString("timeout")
+
+		if cliContext.Timeout != "" {
+			if _, err := time.ParseDuration(cliContext.Timeout); err != nil {
+				ErrorOutput(err, fmt.Sprintf("Invalid timeout: %s", err), output)
+			}
+		}
+
+		fingerprint, _ := cmd.Flags().GetString("tls-fingerprint")
+		if pin, _ := cmd.Flags().GetBool("pin"); pin {
+			var err error
+			fingerprint, err = fetchCertificateFingerprint(cliContext.Address)
+			if err != nil {
+				ErrorOutput(err, fmt.Sprintf("Error fetching the server certificate: %s", err), output)
+			}
+		}
+
+		if fingerprint != "" {
+			var err error
+			cliContext.TLSFingerprint, err = types.NormalizeFingerprint(fingerprint)
+			if err != nil {
+				ErrorOutput(err, err.Error(), output)
+			}
+		}
+
+		path, contexts, err := loadContexts()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading contexts: %s", err), output)
+		}
+
+		contexts.Contexts[name] = cliContext
+		if use, _ := cmd.Flags().GetBool("use"); use {
+			contexts.Current = name
+		}
+
+		if err := contexts.Save(path); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error saving contexts: %s", err), output)
+		}
+
+		message := fmt.Sprintf("Context %s added", name)
+		if cliContext.TLSFingerprint != "" {
+			message += fmt.Sprintf(", trusting certificate %s", cliContext.TLSFingerprint)
+		}
+
+		SuccessOutput(contextListItem{
+			Name:           name,
+			Current:        contexts.Current == name,
+			Address:        cliContext.Address,
+			TLSFingerprint: cliContext.TLSFingerprint,
+			Insecure:       cliContext.Insecure,
+			Plaintext:      cliContext.Plaintext,
+		}, message, output)
+	},
+}
+
+// fetchCertificateFingerprint returns the fingerprint of the
+// certificate the server at address presents.
+func fetchCertificateFingerprint(address string) (string, error) {
+	dialer := &net.Dialer{Timeout: pinTimeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", address, &tls.Config{
+		// The certificate is only read to be pinned.
+		//nolint:gosec
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errNoServerCertificate
+	}
+
+	return types.CertificateFingerprint(certs[0].Raw), nil
+}
+
+var useContextCmd = &cobra.Command{
+	Use:   "use NAME",
+	Short: "Switch to a context",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		path, contexts, err := loadContexts()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading contexts: %s", err), output)
+		}
+
+		if _, ok := contexts.Contexts[args[0]]; !ok {
+			err := fmt.Errorf("%w: %q", types.ErrCLIContextNotFound, args[0])
+			ErrorOutput(err, err.Error(), output)
+		}
+
+		contexts.Current = args[0]
+		if err := contexts.Save(path); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error saving contexts: %s", err), output)
+		}
+
+		SuccessOutput(contexts.Current, fmt.Sprintf("Switched to context %s", args[0]), output)
+	},
+}
+
+var unsetContextCmd = &cobra.Command{
+	Use:   "unset",
+	Short: "Stop using a context, connect to the local server again",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		path, contexts, err := loadContexts()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading contexts: %s", err), output)
+		}
+
+		contexts.Current = ""
+		if err := contexts.Save(path); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error saving contexts: %s", err), output)
+		}
+
+		SuccessOutput("", "Not using a context", output)
+	},
+}
+
+var removeContextCmd = &cobra.Command{
+	Use:     "remove NAME",
+	Short:   "Remove a context",
+	Aliases: []string{"rm", "delete"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		path, contexts, err := loadContexts()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading contexts: %s", err), output)
+		}
+
+		if _, ok := contexts.Contexts[args[0]]; !ok {
+			err := fmt.Errorf("%w: %q", types.ErrCLIContextNotFound, args[0])
+			ErrorOutput(err, err.Error(), output)
+		}
+
+		delete(contexts.Contexts, args[0])
+		if contexts.Current == args[0] {
+			contexts.Current = ""
+		}
+
+		if err := contexts.Save(path); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error saving contexts: %s", err), output)
+		}
+
+		SuccessOutput("", fmt.Sprintf("Context %s removed", args[0]), output)
+	},
+}
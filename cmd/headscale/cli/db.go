@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbSnapshotCmd)
+	dbCmd.AddCommand(dbGCCmd)
+}
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Manage the headscale database",
+}
+
+var dbSnapshotCmd = &cobra.Command{
+	Use:   "snapshot PATH",
+	Short: "Write a consistent copy of the SQLite database",
+	Long: `Write a consistent copy of the SQLite database to PATH.
+
+The snapshot only reads the database and can be taken while headscale
+is running, the copy is safe to back up.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		cfg, err := types.LoadServerConfig()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error loading configuration: %s", err), output)
+		}
+
+		if err := db.Snapshot(cfg.Database, args[0]); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error writing snapshot: %s", err), output)
+		}
+
+		SuccessOutput(
+			map[string]string{"path": args[0]},
+			fmt.Sprintf("Snapshot written to %s", args[0]),
+			output,
+		)
+	},
+}
+
+var dbGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove orphaned and expired records from the database",
+	Long: `Remove the rows left behind by deleted nodes, ephemeral nodes that
+stayed offline, and pre auth keys, API keys and netcheck reports kept
+beyond the retention configured in database.gc.
+
+headscale runs the cleanup every database.gc.interval.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.DatabaseGC(ctx, &v1.DatabaseGCRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error cleaning up the database: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response, "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"Records", "Removed"},
+			{"ephemeral nodes", strconv.FormatInt(response.GetEphemeralNodes(), 10)},
+			{"orphaned routes", strconv.FormatInt(response.GetOrphanedRoutes(), 10)},
+			{"deleted routes", strconv.FormatInt(response.GetDeletedRoutes(), 10)},
+			{"orphaned pre auth key tags", strconv.FormatInt(response.GetOrphanedPreAuthTags(), 10)},
+			{"pre auth keys", strconv.FormatInt(response.GetPreAuthKeys(), 10)},
+			{"API keys", strconv.FormatInt(response.GetApiKeys(), 10)},
+			{"netcheck reports", strconv.FormatInt(response.GetNetcheckReports(), 10)},
+			{"user aliases", strconv.FormatInt(response.GetUserAliases(), 10)},
+			{"route history events", strconv.FormatInt(response.GetRouteEvents(), 10)},
+			{"pending registrations", strconv.FormatInt(response.GetPendingRegistrations(), 10)},
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
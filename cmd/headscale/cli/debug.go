@@ -1,17 +1,33 @@
 package cli
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/mapsign"
+	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"tailscale.com/types/key"
 )
 
 const (
 	errPreAuthKeyMalformed = Error("key is malformed. expected 64 hex characters with `nodekey` prefix")
+
+	// maxProfileSize is the largest profile the CLI accepts, traces of
+	// a busy server can be large.
+	maxProfileSize = 256 << 20
 )
 
 // Error is used to compare errors as per https://dave.cheney.net/2016/04/07/constant-errors
@@ -47,6 +63,23 @@ func init() {
 		StringSliceP("route", "r", []string{}, "List (or repeated flags) of routes to advertise")
 
 	debugCmd.AddCommand(createNodeCmd)
+
+	matrixCmd.Flags().
+		UintSliceP("identifier", "i", []uint{}, "List (or repeated flags) of node identifiers (ID) to check, all nodes if empty")
+	matrixCmd.Flags().Bool("problems", false, "Only show pairs of nodes with problems")
+	debugCmd.AddCommand(matrixCmd)
+
+	profileCmd.Flags().Duration("cpu", 0, "Record a CPU profile for the given duration")
+	profileCmd.Flags().Duration("trace", 0, "Record an execution trace for the given duration")
+	profileCmd.Flags().String("profile", "", "Collect a runtime profile: heap, allocs, goroutine, block, mutex or threadcreate")
+	profileCmd.MarkFlagsOneRequired("cpu", "trace", "profile")
+	profileCmd.MarkFlagsMutuallyExclusive("cpu", "trace", "profile")
+	profileCmd.Flags().StringP("file", "f", "", "File to write the profile to, defaults to headscale-<profile>-<time>.pprof")
+	debugCmd.AddCommand(profileCmd)
+
+	verifyMapCmd.Flags().StringP("file", "f", "-", "Network map of the node, as printed by \"tailscale debug netmap\", - reads it from stdin")
+	verifyMapCmd.Flags().String("key", "", "Pinned public key the map has to be signed with, like ed25519:...")
+	debugCmd.AddCommand(verifyMapCmd)
 }
 
 var debugCmd = &cobra.Command{
@@ -126,3 +159,217 @@ var createNodeCmd = &cobra.Command{
 		SuccessOutput(response.GetNode(), "Node created", output)
 	},
 }
+
+var matrixCmd = &cobra.Command{
+	Use:   "matrix",
+	Short: "Check if nodes are expected to be able to reach each other",
+	Long: `
+Check every pair of the given nodes: whether the policy allows the
+first to reach the second, whether both are connected and whether they
+share a DERP region according to their netcheck reports. The problems
+column lists the likely reasons why the connection is broken or relayed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifiers, err := cmd.Flags().GetUintSlice("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error getting identifiers from flag: %s", err),
+				output,
+			)
+		}
+		onlyProblems, _ := cmd.Flags().GetBool("problems")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.DebugConnectivityMatrixRequest{}
+		for _, id := range identifiers {
+			request.NodeIds = append(request.NodeIds, uint64(id))
+		}
+
+		response, err := client.DebugConnectivityMatrix(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot check connectivity: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		checks := response.GetChecks()
+		if onlyProblems {
+			checks = slices.DeleteFunc(checks, func(check *v1.ConnectivityCheck) bool {
+				return len(check.GetProblems()) == 0
+			})
+		}
+
+		if output != "" {
+			SuccessOutput(checks, "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"Source", "Destination", "ACL allowed", "Allowed by", "Online", "Shared DERP", "Problems"},
+		}
+		for _, check := range checks {
+			tableData = append(tableData, []string{
+				fmt.Sprintf("%s (%d)", check.GetSourceName(), check.GetSourceId()),
+				fmt.Sprintf("%s (%d)", check.GetDestinationName(), check.GetDestinationId()),
+				strconv.FormatBool(check.GetAclAllowed()),
+				valueOrDash(strings.Join(check.GetAllowedBy(), "; ")),
+				strconv.FormatBool(check.GetOnline()),
+				strconv.FormatBool(check.GetSharedDerp()),
+				strings.Join(check.GetProblems(), "; "),
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Collect a profile from the running headscale",
+	Long: `
+Collect a CPU profile, an execution trace or a runtime profile from the
+running headscale and write it to a file for "go tool pprof" or
+"go tool trace". Profiling has to be enabled with debug.profiling_enabled.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		cpu, _ := cmd.Flags().GetDuration("cpu")
+		traceDuration, _ := cmd.Flags().GetDuration("trace")
+		profile, _ := cmd.Flags().GetString("profile")
+
+		request := &v1.DebugProfileRequest{Profile: profile}
+		switch {
+		case cpu > 0:
+			request.Profile = "cpu"
+			request.Duration = durationpb.New(cpu)
+		case traceDuration > 0:
+			request.Profile = "trace"
+			request.Duration = durationpb.New(traceDuration)
+		}
+
+		file, _ := cmd.Flags().GetString("file")
+		if file == "" {
+			extension := "pprof"
+			if request.GetProfile() == "trace" {
+				extension = "trace"
+			}
+			file = fmt.Sprintf(
+				"headscale-%s-%s.%s",
+				request.GetProfile(),
+				time.Now().Format("20060102-150405"),
+				extension,
+			)
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		// The CPU profile and the trace take longer than the CLI timeout.
+		ctx, cancelProfile := context.WithTimeout(
+			context.WithoutCancel(ctx),
+			request.GetDuration().AsDuration()+time.Minute,
+		)
+		defer cancelProfile()
+
+		response, err := client.DebugProfile(
+			ctx,
+			request,
+			grpc.MaxCallRecvMsgSize(maxProfileSize),
+		)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot collect profile: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if err := os.WriteFile(file, response.GetData(), 0o600); err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot write profile: %s", err),
+				output,
+			)
+		}
+
+		SuccessOutput(map[string]string{"file": file}, "Profile written to "+file, output)
+	},
+}
+
+var verifyMapCmd = &cobra.Command{
+	Use:   "verify-map",
+	Short: "Verify the signature of the DERPMap and DNS configuration of a node",
+	Long: `
+Verify the signature of the DERPMap and the DNS configuration a node
+received, with map_signing enabled. Pass the output of "tailscale debug
+netmap" of the node, and the public key logged by headscale at startup
+to pin it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		file, _ := cmd.Flags().GetString("file")
+		pinned, _ := cmd.Flags().GetString("key")
+
+		var data []byte
+		var err error
+		if file == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot read network map: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		var netMap mapsign.NetMap
+		if err := json.Unmarshal(data, &netMap); err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot parse network map: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		signingKey, err := mapsign.VerifyNetMap(&netMap, pinned)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Map of %s is not verified: %s", netMap.SelfNode.Name, err),
+				output,
+			)
+
+			return
+		}
+
+		message := fmt.Sprintf("Map of %s is signed with %s", netMap.SelfNode.Name, signingKey)
+		if pinned == "" {
+			message += ", pass it with --key to pin it"
+		}
+
+		SuccessOutput(map[string]string{
+			"node":        netMap.SelfNode.Name,
+			"signing_key": signingKey,
+		}, message, output)
+	},
+}
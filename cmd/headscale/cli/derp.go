@@ -0,0 +1,179 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(derpCmd)
+	derpCmd.AddCommand(listDERPClientsCmd)
+	derpCmd.AddCommand(derpRelaysCmd)
+	derpRelaysCmd.AddCommand(listDERPRelaysCmd)
+	derpRelaysCmd.AddCommand(deleteDERPRelayCmd)
+}
+
+var derpCmd = &cobra.Command{
+	Use:   "derp",
+	Short: "Manage the embedded DERP server and the DERP relays",
+}
+
+var derpRelaysCmd = &cobra.Command{
+	Use:     "relays",
+	Short:   "Manage the standalone DERP relays registered with headscale",
+	Aliases: []string{"relay"},
+}
+
+var listDERPClientsCmd = &cobra.Command{
+	Use:     "clients",
+	Short:   "List the clients connected to the embedded DERP server",
+	Aliases: []string{"ls"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListDERPClients(ctx, &v1.ListDERPClientsRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot list DERP clients: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if !isTableOutput(output) {
+			SuccessOutput(response.GetClients(), "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "Name", "NodeKey", "Remote address", "Connected", "Received (bytes)", "Sent (bytes)"},
+		}
+		for _, derpClient := range response.GetClients() {
+			id := ""
+			if derpClient.GetNodeId() != 0 {
+				id = strconv.FormatUint(derpClient.GetNodeId(), util.Base10)
+			}
+
+			tableData = append(tableData, []string{
+				id,
+				derpClient.GetName(),
+				derpClient.GetNodeKey(),
+				derpClient.GetRemoteAddr(),
+				time.Since(derpClient.GetConnectedAt().AsTime()).Round(time.Second).String(),
+				strconv.FormatUint(derpClient.GetRxBytes(), util.Base10),
+				strconv.FormatUint(derpClient.GetTxBytes(), util.Base10),
+			})
+		}
+
+		if err := renderTable(tableData, output); err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var listDERPRelaysCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the registered DERP relays",
+	Aliases: []string{"ls", "show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListDERPRelays(ctx, &v1.ListDERPRelaysRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot list DERP relays: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if !isTableOutput(output) {
+			SuccessOutput(response.GetRelays(), "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"Region", "Code", "Name", "Hostname", "DERP port", "STUN port", "Last seen", "Online"},
+		}
+		for _, relay := range response.GetRelays() {
+			online := pterm.LightRed("offline")
+			if relay.GetOnline() {
+				online = pterm.LightGreen("online")
+			}
+
+			tableData = append(tableData, []string{
+				strconv.Itoa(int(relay.GetRegionId())),
+				relay.GetRegionCode(),
+				relay.GetRegionName(),
+				relay.GetHostname(),
+				strconv.Itoa(int(relay.GetDerpPort())),
+				strconv.Itoa(int(relay.GetStunPort())),
+				relay.GetLastSeen().AsTime().Format(HeadscaleDateTimeFormat),
+				online,
+			})
+		}
+
+		if err := renderTable(tableData, output); err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var deleteDERPRelayCmd = &cobra.Command{
+	Use:     "delete REGION",
+	Short:   "Remove a DERP relay from the DERPMap, until it registers again",
+	Aliases: []string{"remove", "rm", "del"},
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		region, err := strconv.ParseInt(args[0], util.Base10, 32)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting region ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.DeleteDERPRelay(ctx, &v1.DeleteDERPRelayRequest{RegionId: int32(region)})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot delete DERP relay: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response, "DERP relay deleted", output)
+	},
+}
@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/juanfont/headscale/hscontrol"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check headscale works end to end",
+	Long: `
+Check the TLS certificate chain, that server_url and the STUN server of the
+embedded DERP server are reachable, the latency of the database and the
+clock of the host, and print how to fix the checks that do not pass. Run it
+on the headscale host while headscale is running. Exits with status 1 if a
+check fails.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		cfg, err := types.LoadServerConfig()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error loading configuration: %s", err), output)
+		}
+
+		checks := hscontrol.Doctor(cmd.Context(), cfg)
+
+		failed := false
+		for _, check := range checks {
+			if check.Status == "fail" {
+				failed = true
+			}
+		}
+
+		if output != "" {
+			if failed {
+				fmt.Fprintln(os.Stderr, doctorChecksOutput(checks, output))
+				os.Exit(1)
+			}
+			SuccessOutput(checks, "", output)
+		}
+
+		tableData := pterm.TableData{{"Check", "Status", "Details", "Fix"}}
+		for _, check := range checks {
+			status := check.Status
+			switch check.Status {
+			case "pass":
+				status = pterm.LightGreen(status)
+			case "warn":
+				status = pterm.LightYellow(status)
+			case "fail":
+				status = pterm.LightRed(status)
+			}
+
+			tableData = append(tableData, []string{check.Check, status, check.Details, check.Fix})
+		}
+
+		if err := pterm.DefaultTable.WithHasHeader().WithData(tableData).Render(); err != nil {
+			ErrorOutput(err, fmt.Sprintf("Failed to render pterm table: %s", err), output)
+		}
+
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// doctorChecksOutput formats the checks for machine readable output,
+// the output flag of the command shadows output.
+func doctorChecksOutput(checks []hscontrol.DoctorCheck, outputFormat string) string {
+	return output(checks, "", outputFormat)
+}
@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/tsimport"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importTailscaleCmd.Flags().String("api-key", "", "Tailscale API access token, read from TS_API_KEY if not set")
+	importTailscaleCmd.Flags().String("tailnet", "-", "Tailnet to import, - for the tailnet of the API key")
+	importTailscaleCmd.Flags().String("api-url", tsimport.DefaultBaseURL, "Address of the Tailscale admin API")
+	importTailscaleCmd.Flags().Bool("strip-email-domain", true, "Name users after their login name without its domain, set it like oidc.strip_email_domain")
+	importTailscaleCmd.Flags().Bool("skip-policy", false, "Do not import the policy of the tailnet")
+	importTailscaleCmd.Flags().Bool("dry-run", false, "Only show what would be imported")
+	importCmd.AddCommand(importTailscaleCmd)
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import the state of another control server",
+}
+
+var importTailscaleCmd = &cobra.Command{
+	Use:   "tailscale",
+	Short: "Import the users, devices, routes and policy of a Tailscale tailnet",
+	Long: `
+Read the users, devices, tags, routes and the policy of a tailnet from the
+Tailscale admin API and create them in headscale.
+
+The devices keep their name, tags and, if they are free and within the
+prefixes of headscale, their addresses. They are expired until they log in to
+headscale, with "tailscale up --login-server". Users and devices shared from
+other tailnets are left out. The policy is stored with the users renamed to
+their headscale names, which requires policy.mode to be database, otherwise
+import with --skip-policy.
+
+Importing again skips the users and devices already imported.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		apiKey, _ := cmd.Flags().GetString("api-key")
+		tailnetName, _ := cmd.Flags().GetString("tailnet")
+		apiURL, _ := cmd.Flags().GetString("api-url")
+		stripEmailDomain, _ := cmd.Flags().GetBool("strip-email-domain")
+		skipPolicy, _ := cmd.Flags().GetBool("skip-policy")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		if apiKey == "" {
+			apiKey = os.Getenv("TS_API_KEY")
+		}
+		if apiKey == "" {
+			ErrorOutput(errMissingParameter, "Missing the Tailscale API access token, set --api-key or TS_API_KEY", output)
+		}
+
+		tailnet, err := tsimport.NewClient(apiURL, apiKey, tailnetName).Fetch(context.Background())
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Cannot read the tailnet from Tailscale: %s", err), output)
+		}
+
+		request, left, err := tailnet.Request(stripEmailDomain)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Cannot import the tailnet: %s", err), output)
+		}
+		if skipPolicy {
+			request.Policy = ""
+		}
+
+		if dryRun {
+			if output != "" {
+				SuccessOutput(request, "", output)
+			}
+
+			renderImportedNodes(request.GetNodes(), output)
+			printLeftOut(left)
+			SuccessOutput(nil, fmt.Sprintf(
+				"Would import %d users and %d devices.",
+				len(request.GetUsers()),
+				len(request.GetNodes()),
+			), "")
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ImportTailnet(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot import the tailnet: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response, "", output)
+		}
+
+		if len(response.GetCreatedNodes()) > 0 {
+			tableData, err := nodesToPtables("", true, false, response.GetCreatedNodes())
+			if err != nil {
+				ErrorOutput(err, fmt.Sprintf("Error converting to table: %s", err), output)
+			}
+
+			err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+			if err != nil {
+				ErrorOutput(err, fmt.Sprintf("Failed to render pterm table: %s", err), output)
+			}
+		}
+
+		for _, skipped := range response.GetSkipped() {
+			fmt.Printf("Skipped %s\n", skipped)
+		}
+		printLeftOut(left)
+
+		message := fmt.Sprintf(
+			"Imported %d users and %d devices, they join the tailnet once they log in to headscale.",
+			len(response.GetCreatedUsers()),
+			len(response.GetCreatedNodes()),
+		)
+		if response.GetPolicyVersion() != 0 {
+			message += fmt.Sprintf(" Policy stored as version %d.", response.GetPolicyVersion())
+		}
+		SuccessOutput(nil, message, "")
+	},
+}
+
+func renderImportedNodes(nodes []*v1.ImportedNode, output string) {
+	tableData := pterm.TableData{
+		{"Name", "Hostname", "User", "IP addresses", "Tags", "Routes"},
+	}
+	for _, node := range nodes {
+		tableData = append(tableData, []string{
+			node.GetGivenName(),
+			node.GetHostname(),
+			node.GetUser(),
+			strings.Join(node.GetIpAddresses(), ", "),
+			valueOrDash(strings.Join(node.GetForcedTags(), ",")),
+			valueOrDash(strings.Join(node.GetAdvertisedRoutes(), ",")),
+		})
+	}
+
+	if err := pterm.DefaultTable.WithHasHeader().WithData(tableData).Render(); err != nil {
+		ErrorOutput(err, fmt.Sprintf("Failed to render pterm table: %s", err), output)
+	}
+}
+
+func printLeftOut(left []string) {
+	for _, reason := range left {
+		fmt.Printf("Left out %s\n", reason)
+	}
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(logCmd)
+	logCmd.AddCommand(logLevelsCmd)
+	logCmd.AddCommand(logSetLevelCmd)
+
+	logSetLevelCmd.Flags().
+		StringP("subsystem", "s", "", "Subsystem to set the level of: policy, mapper, derp, oidc or db, the default level if empty")
+}
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Manage the logging of the headscale server",
+}
+
+var logLevelsCmd = &cobra.Command{
+	Use:   "levels",
+	Short: "List the log levels of the subsystems",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.GetLogLevels(ctx, &v1.GetLogLevelsRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error getting the log levels: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetLevels(), "", output)
+		}
+
+		renderLogLevels(response.GetLevels(), output)
+	},
+}
+
+var logSetLevelCmd = &cobra.Command{
+	Use:   "set-level LEVEL",
+	Short: "Change a log level of the running server",
+	Long: `Change the default log level, or the level of a subsystem with
+--subsystem, until headscale restarts.
+
+LEVEL is trace, debug, info, warn, error, fatal, panic or disabled.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		subsystem, _ := cmd.Flags().GetString("subsystem")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.SetLogLevel(ctx, &v1.SetLogLevelRequest{
+			Subsystem: subsystem,
+			Level:     args[0],
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error setting the log level: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetLevels(), "", output)
+		}
+
+		renderLogLevels(response.GetLevels(), output)
+	},
+}
+
+func renderLogLevels(levels []*v1.LogLevel, output string) {
+	tableData := pterm.TableData{{"Subsystem", "Level"}}
+	for _, level := range levels {
+		subsystem := level.GetSubsystem()
+		if subsystem == "" {
+			subsystem = "default"
+		}
+		tableData = append(tableData, []string{subsystem, level.GetLevel()})
+	}
+
+	err := pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+	if err != nil {
+		ErrorOutput(
+			err,
+			fmt.Sprintf("Failed to render pterm table: %s", err),
+			output,
+		)
+	}
+}
@@ -1,9 +1,14 @@
 package cli
 
 import (
+	"bufio"
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/netip"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
@@ -22,6 +27,9 @@ func init() {
 	rootCmd.AddCommand(nodeCmd)
 	listNodesCmd.Flags().StringP("user", "u", "", "Filter by user")
 	listNodesCmd.Flags().BoolP("tags", "t", false, "Show tags")
+	listNodesCmd.Flags().String("tag", "", "Only list nodes with this tag")
+	listNodesCmd.Flags().Bool("online", false, "Only list connected nodes, or disconnected nodes with --online=false")
+	listNodesCmd.Flags().String("client-version-older-than", "", "Only list nodes running an older Tailscale client, like 1.66")
 
 	listNodesCmd.Flags().StringP("namespace", "n", "", "User")
 	listNodesNamespaceFlag := listNodesCmd.Flags().Lookup("namespace")
@@ -41,7 +49,7 @@ func init() {
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
-	registerNodeCmd.Flags().StringP("key", "k", "", "Key")
+	registerNodeCmd.Flags().StringSliceP("key", "k", nil, "Key, repeat it or separate keys with commas to register several nodes, - reads them from stdin")
 	err = registerNodeCmd.MarkFlagRequired("key")
 	if err != nil {
 		log.Fatalf(err.Error())
@@ -62,6 +70,34 @@ func init() {
 	}
 	nodeCmd.AddCommand(renameNodeCmd)
 
+	rotateNodeKeyCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	rotateNodeKeyCmd.Flags().Bool("machine-key", false, "Also revoke the machine key, for lost or stolen devices")
+	nodeCmd.AddCommand(rotateNodeKeyCmd)
+
+	revokeNodeCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	nodeCmd.AddCommand(revokeNodeCmd)
+
+	noteNodeCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	err = noteNodeCmd.MarkFlagRequired("identifier")
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	nodeCmd.AddCommand(noteNodeCmd)
+
+	derpHomeNodeCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	err = derpHomeNodeCmd.MarkFlagRequired("identifier")
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	nodeCmd.AddCommand(derpHomeNodeCmd)
+
+	serviceNodeCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	err = serviceNodeCmd.MarkFlagRequired("identifier")
+	if err != nil {
+		log.Fatalf(err.Error())
+	}
+	nodeCmd.AddCommand(serviceNodeCmd)
+
 	deleteNodeCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
 	err = deleteNodeCmd.MarkFlagRequired("identifier")
 	if err != nil {
@@ -97,9 +133,38 @@ func init() {
 	}
 	tagCmd.Flags().
 		StringSliceP("tags", "t", []string{}, "List of tags to add to the node")
+	tagCmd.Flags().StringSlice("add", []string{}, "Tags to add, keeping the other tags of the node")
+	tagCmd.Flags().StringSlice("remove", []string{}, "Tags to remove, keeping the other tags of the node")
+	tagCmd.Flags().Bool("force", false, "Set tags the user of the node does not own in the policy")
 	nodeCmd.AddCommand(tagCmd)
 
 	nodeCmd.AddCommand(backfillNodeIPsCmd)
+
+	nodeStatsCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	nodeCmd.AddCommand(nodeStatsCmd)
+
+	nodeNetcheckCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
+	err = nodeNetcheckCmd.MarkFlagRequired("identifier")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	nodeCmd.AddCommand(nodeNetcheckCmd)
+
+	nodeCmd.AddCommand(describeNodeCmd)
+
+	outdatedNodesCmd.Flags().String("min-version", "", "Oldest Tailscale version that is not outdated, like 1.66")
+	err = outdatedNodesCmd.MarkFlagRequired("min-version")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	nodeCmd.AddCommand(outdatedNodesCmd)
+
+	c2nNodeCmd.Flags().StringP("method", "X", "GET", "HTTP method of the request")
+	c2nNodeCmd.Flags().StringP("data", "d", "", "Body of the request")
+	nodeCmd.AddCommand(c2nNodeCmd)
+
+	previewFQDNCmd.Flags().StringP("user", "u", "", "User the node would belong to")
+	nodeCmd.AddCommand(previewFQDNCmd)
 }
 
 var nodeCmd = &cobra.Command{
@@ -111,6 +176,11 @@ var nodeCmd = &cobra.Command{
 var registerNodeCmd = &cobra.Command{
 	Use:   "register",
 	Short: "Registers a node to your network",
+	Long: `
+Register the nodes waiting for approval with the keys shown on their
+registration page. Several keys register several nodes, for onboarding
+events the keys can be scanned from the QR codes of the registration pages
+into a file, one per line, and read from stdin with --key -.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
 		user, err := cmd.Flags().GetString("user")
@@ -118,11 +188,7 @@ var registerNodeCmd = &cobra.Command{
 			ErrorOutput(err, fmt.Sprintf("Error getting user: %s", err), output)
 		}
 
-		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
-		defer cancel()
-		defer conn.Close()
-
-		machineKey, err := cmd.Flags().GetString("key")
+		keys, err := cmd.Flags().GetStringSlice("key")
 		if err != nil {
 			ErrorOutput(
 				err,
@@ -131,29 +197,132 @@ var registerNodeCmd = &cobra.Command{
 			)
 		}
 
-		request := &v1.RegisterNodeRequest{
-			Key:  machineKey,
-			User: user,
+		keys, err = registerKeys(keys, os.Stdin)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading node keys: %s", err), output)
 		}
 
-		response, err := client.RegisterNode(ctx, request)
-		if err != nil {
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		if len(keys) == 1 {
+			request := &v1.RegisterNodeRequest{
+				Key:  keys[0],
+				User: user,
+			}
+
+			response, err := client.RegisterNode(ctx, request)
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf(
+						"Cannot register node: %s\n",
+						status.Convert(err).Message(),
+					),
+					output,
+				)
+			}
+
+			SuccessOutput(
+				response.GetNode(),
+				fmt.Sprintf("Node %s registered", response.GetNode().GetGivenName()), output)
+		}
+
+		// With several keys every node is tried, the failures are
+		// reported at the end.
+		results := make([]registerResult, 0, len(keys))
+		failed := 0
+		for _, key := range keys {
+			response, err := client.RegisterNode(ctx, &v1.RegisterNodeRequest{
+				Key:  key,
+				User: user,
+			})
+			if err != nil {
+				failed++
+				results = append(results, registerResult{Key: key, Error: status.Convert(err).Message()})
+
+				continue
+			}
+
+			results = append(results, registerResult{Key: key, Node: response.GetNode()})
+		}
+
+		if !isTableOutput(output) {
+			if failed > 0 {
+				fmt.Fprintln(os.Stderr, registerResultsOutput(results, output))
+				os.Exit(1)
+			}
+			SuccessOutput(results, "", output)
+		}
+
+		tableData := pterm.TableData{{"Key", "Node", "Result"}}
+		for _, result := range results {
+			if result.Error != "" {
+				tableData = append(tableData, []string{result.Key, "", result.Error})
+
+				continue
+			}
+			tableData = append(tableData, []string{result.Key, result.Node.GetGivenName(), "registered"})
+		}
+
+		if err := renderTable(tableData, output); err != nil {
 			ErrorOutput(
 				err,
-				fmt.Sprintf(
-					"Cannot register node: %s\n",
-					status.Convert(err).Message(),
-				),
+				fmt.Sprintf("Failed to render pterm table: %s", err),
 				output,
 			)
 		}
 
-		SuccessOutput(
-			response.GetNode(),
-			fmt.Sprintf("Node %s registered", response.GetNode().GetGivenName()), output)
+		if failed > 0 {
+			os.Exit(1)
+		}
 	},
 }
 
+// registerResult is the outcome of registering the node of a key.
+type registerResult struct {
+	Key   string   `json:"key"`
+	Node  *v1.Node `json:"node,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+func registerResultsOutput(results []registerResult, outputFormat string) string {
+	return output(results, "", outputFormat)
+}
+
+// registerKeys returns the keys to register, reading them one per line
+// from stdin in place of "-". Blank lines are skipped.
+func registerKeys(keys []string, stdin io.Reader) ([]string, error) {
+	var result []string
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key != "-" {
+			if key != "" {
+				result = append(result, key)
+			}
+
+			continue
+		}
+
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				result = append(result, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, errNoNodeKeys
+	}
+
+	return result, nil
+}
+
 var listNodesCmd = &cobra.Command{
 	Use:     "list",
 	Short:   "List nodes",
@@ -173,8 +342,18 @@ var listNodesCmd = &cobra.Command{
 		defer cancel()
 		defer conn.Close()
 
+		tag, _ := cmd.Flags().GetString("tag")
+		olderThan, _ := cmd.Flags().GetString("client-version-older-than")
+
 		request := &v1.ListNodesRequest{
-			User: user,
+			User:                   user,
+			Tag:                    tag,
+			ClientVersionOlderThan: olderThan,
+		}
+
+		if cmd.Flags().Changed("online") {
+			online, _ := cmd.Flags().GetBool("online")
+			request.Online = &online
 		}
 
 		response, err := client.ListNodes(ctx, request)
@@ -186,16 +365,20 @@ var listNodesCmd = &cobra.Command{
 			)
 		}
 
-		if output != "" {
+		if !isTableOutput(output) {
 			SuccessOutput(response.GetNodes(), "", output)
 		}
 
-		tableData, err := nodesToPtables(user, showTags, response.GetNodes())
+		// "wide" is a human-readable table with additional columns,
+		// exports have all of them and the tags.
+		export := output == outputCSV || output == outputMarkdown
+		wide := output == outputWide || export
+		tableData, err := nodesToPtables(user, showTags || export, wide, response.GetNodes())
 		if err != nil {
 			ErrorOutput(err, fmt.Sprintf("Error converting to table: %s", err), output)
 		}
 
-		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		err = renderTable(tableData, output)
 		if err != nil {
 			ErrorOutput(
 				err,
@@ -299,33 +482,79 @@ var renameNodeCmd = &cobra.Command{
 	},
 }
 
-var deleteNodeCmd = &cobra.Command{
-	Use:     "delete",
-	Short:   "Delete a node",
-	Aliases: []string{"del"},
+var rotateNodeKeyCmd = &cobra.Command{
+	Use:   "rotate-key [ID]",
+	Short: "Expire a node and revoke its node key",
+	Long: `
+Expire a node and revoke its node key. The node has to log in again and
+comes back with a new node key, the old one is refused from now on.
+
+With --machine-key the machine key is revoked as well, for lost or stolen
+devices: the device is refused entirely and can only register as a new node
+once its state is reset.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
+		machineKey, _ := cmd.Flags().GetBool("machine-key")
 
-		identifier, err := cmd.Flags().GetUint64("identifier")
+		identifier, err := nodeIDFromArgs(cmd, args)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error getting node ID: %s", err), output)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.RotateNodeKeyRequest{
+			NodeId:     identifier,
+			MachineKey: machineKey,
+		}
+
+		response, err := client.RotateNodeKey(ctx, request)
 		if err != nil {
 			ErrorOutput(
 				err,
-				fmt.Sprintf("Error converting ID to integer: %s", err),
+				fmt.Sprintf(
+					"Cannot rotate node key: %s\n",
+					status.Convert(err).Message(),
+				),
 				output,
 			)
 
 			return
 		}
 
+		SuccessOutput(response.GetNode(), "Node key revoked, the node must log in again", output)
+	},
+}
+
+var revokeNodeCmd = &cobra.Command{
+	Use:   "revoke [ID]",
+	Short: "Revoke a lost or stolen node",
+	Long: `
+Revoke a lost or stolen node at once: expire it, remove it from the maps of
+its peers, refuse its machine key so it cannot register again, and ban its
+node key from the embedded DERP server. The node stays in the database until
+it is deleted.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := nodeIDFromArgs(cmd, args)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error getting node ID: %s", err), output)
+
+			return
+		}
+
 		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
 		defer cancel()
 		defer conn.Close()
 
-		getRequest := &v1.GetNodeRequest{
-			NodeId: identifier,
-		}
-
-		getResponse, err := client.GetNode(ctx, getRequest)
+		getResponse, err := client.GetNode(ctx, &v1.GetNodeRequest{NodeId: identifier})
 		if err != nil {
 			ErrorOutput(
 				err,
@@ -339,16 +568,12 @@ var deleteNodeCmd = &cobra.Command{
 			return
 		}
 
-		deleteRequest := &v1.DeleteNodeRequest{
-			NodeId: identifier,
-		}
-
 		confirm := false
 		force, _ := cmd.Flags().GetBool("force")
 		if !force {
 			prompt := &survey.Confirm{
 				Message: fmt.Sprintf(
-					"Do you want to remove the node %s?",
+					"Do you want to revoke the node %s? It can not register again.",
 					getResponse.GetNode().GetName(),
 				),
 			}
@@ -358,40 +583,54 @@ var deleteNodeCmd = &cobra.Command{
 			}
 		}
 
-		if confirm || force {
-			response, err := client.DeleteNode(ctx, deleteRequest)
-			if output != "" {
-				SuccessOutput(response, "", output)
+		if !confirm && !force {
+			SuccessOutput(map[string]string{"Result": "Node not revoked"}, "Node not revoked", output)
 
-				return
-			}
-			if err != nil {
-				ErrorOutput(
-					err,
-					fmt.Sprintf(
-						"Error deleting node: %s",
-						status.Convert(err).Message(),
-					),
-					output,
-				)
+			return
+		}
 
-				return
-			}
-			SuccessOutput(
-				map[string]string{"Result": "Node deleted"},
-				"Node deleted",
+		response, err := client.RevokeNode(ctx, &v1.RevokeNodeRequest{NodeId: identifier})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot revoke node: %s\n",
+					status.Convert(err).Message(),
+				),
 				output,
 			)
-		} else {
-			SuccessOutput(map[string]string{"Result": "Node not deleted"}, "Node not deleted", output)
+
+			return
 		}
+
+		SuccessOutput(response.GetNode(), "Node revoked", output)
 	},
 }
 
-var moveNodeCmd = &cobra.Command{
-	Use:     "move",
-	Short:   "Move node to another user",
-	Aliases: []string{"mv"},
+// nodeIDFromArgs returns the node ID given as the argument of the
+// command or with --identifier.
+func nodeIDFromArgs(cmd *cobra.Command, args []string) (uint64, error) {
+	if len(args) > 0 {
+		return strconv.ParseUint(args[0], util.Base10, 64)
+	}
+
+	identifier, err := cmd.Flags().GetUint64("identifier")
+	if err != nil {
+		return 0, err
+	}
+	if identifier == 0 {
+		return 0, fmt.Errorf("%w: give the node ID as argument or with --identifier", errMissingParameter)
+	}
+
+	return identifier, nil
+}
+
+var noteNodeCmd = &cobra.Command{
+	Use:   "note [NOTE]",
+	Short: "Set the note of a node, without a note removes it",
+	Long: `
+Record free text context about a node, like "loaner laptop, return by May".
+Notes are shown when listing nodes and found by headscale search.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
 
@@ -406,31 +645,84 @@ var moveNodeCmd = &cobra.Command{
 			return
 		}
 
-		user, err := cmd.Flags().GetString("user")
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetNodeNoteRequest{
+			NodeId: identifier,
+			Note:   strings.Join(args, " "),
+		}
+
+		response, err := client.SetNodeNote(ctx, request)
 		if err != nil {
 			ErrorOutput(
 				err,
-				fmt.Sprintf("Error getting user: %s", err),
+				fmt.Sprintf(
+					"Cannot set node note: %s\n",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(response.GetNode(), "Node note set", output)
+	},
+}
+
+var derpHomeNodeCmd = &cobra.Command{
+	Use:   "derp-home [REGION]",
+	Short: "Pin a node to a DERP region as its home, without a region removes the pin",
+	Long: `
+Pin a node to a DERP region, like CI runners to the relay in their datacenter.
+The other regions of its DERP map are marked to be avoided. The pin takes
+precedence over the derpHomes of the policy and client_tuning.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
 				output,
 			)
 
 			return
 		}
 
+		var region int64
+		if len(args) > 0 {
+			region, err = strconv.ParseInt(args[0], util.Base10, 32)
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Error converting region ID to integer: %s", err),
+					output,
+				)
+
+				return
+			}
+		}
+
 		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
 		defer cancel()
 		defer conn.Close()
 
-		getRequest := &v1.GetNodeRequest{
-			NodeId: identifier,
+		request := &v1.SetNodeDERPHomeRequest{
+			NodeId:   identifier,
+			RegionId: int32(region),
 		}
 
-		_, err = client.GetNode(ctx, getRequest)
+		response, err := client.SetNodeDERPHome(ctx, request)
 		if err != nil {
 			ErrorOutput(
 				err,
 				fmt.Sprintf(
-					"Error getting node: %s",
+					"Cannot set node DERP home: %s\n",
 					status.Convert(err).Message(),
 				),
 				output,
@@ -439,17 +731,46 @@ var moveNodeCmd = &cobra.Command{
 			return
 		}
 
-		moveRequest := &v1.MoveNodeRequest{
-			NodeId: identifier,
-			User:   user,
+		SuccessOutput(response.GetNode(), "Node DERP home set", output)
+	},
+}
+
+var serviceNodeCmd = &cobra.Command{
+	Use:   "service [SERVICE]",
+	Short: "Attribute a tagged node to a service account, without a service attributes it back to its user",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
 		}
 
-		moveResponse, err := client.MoveNode(ctx, moveRequest)
+		var service string
+		if len(args) > 0 {
+			service = args[0]
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.SetNodeService(ctx, &v1.SetNodeServiceRequest{
+			NodeId:  identifier,
+			Service: service,
+		})
 		if err != nil {
 			ErrorOutput(
 				err,
 				fmt.Sprintf(
-					"Error moving node: %s",
+					"Cannot set node service: %s\n",
 					status.Convert(err).Message(),
 				),
 				output,
@@ -458,11 +779,174 @@ var moveNodeCmd = &cobra.Command{
 			return
 		}
 
-		SuccessOutput(moveResponse.GetNode(), "Node moved to another user", output)
+		SuccessOutput(response.GetNode(), "Node service set", output)
 	},
 }
 
-var backfillNodeIPsCmd = &cobra.Command{
+var deleteNodeCmd = &cobra.Command{
+	Use:     "delete",
+	Short:   "Delete a node",
+	Aliases: []string{"del"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		getRequest := &v1.GetNodeRequest{
+			NodeId: identifier,
+		}
+
+		getResponse, err := client.GetNode(ctx, getRequest)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Error getting node node: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		deleteRequest := &v1.DeleteNodeRequest{
+			NodeId: identifier,
+		}
+
+		confirm := false
+		force, _ := cmd.Flags().GetBool("force")
+		if !force {
+			prompt := &survey.Confirm{
+				Message: fmt.Sprintf(
+					"Do you want to remove the node %s?",
+					getResponse.GetNode().GetName(),
+				),
+			}
+			err = survey.AskOne(prompt, &confirm)
+			if err != nil {
+				return
+			}
+		}
+
+		if confirm || force {
+			response, err := client.DeleteNode(ctx, deleteRequest)
+			if output != "" {
+				SuccessOutput(response, "", output)
+
+				return
+			}
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf(
+						"Error deleting node: %s",
+						status.Convert(err).Message(),
+					),
+					output,
+				)
+
+				return
+			}
+			SuccessOutput(
+				map[string]string{"Result": "Node deleted"},
+				"Node deleted",
+				output,
+			)
+		} else {
+			SuccessOutput(map[string]string{"Result": "Node not deleted"}, "Node not deleted", output)
+		}
+	},
+}
+
+var moveNodeCmd = &cobra.Command{
+	Use:     "move",
+	Short:   "Move node to another user",
+	Aliases: []string{"mv"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		user, err := cmd.Flags().GetString("user")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error getting user: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		getRequest := &v1.GetNodeRequest{
+			NodeId: identifier,
+		}
+
+		_, err = client.GetNode(ctx, getRequest)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Error getting node: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		moveRequest := &v1.MoveNodeRequest{
+			NodeId: identifier,
+			User:   user,
+		}
+
+		moveResponse, err := client.MoveNode(ctx, moveRequest)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Error moving node: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+
+			return
+		}
+
+		SuccessOutput(moveResponse.GetNode(), "Node moved to another user", output)
+	},
+}
+
+var backfillNodeIPsCmd = &cobra.Command{
 	Use:   "backfillips",
 	Short: "Backfill IPs missing from nodes",
 	Long: `
@@ -513,9 +997,418 @@ be assigned to nodes.`,
 	},
 }
 
+var nodeStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show traffic relayed by the embedded DERP server per node",
+	Long: `
+Show the number of bytes the embedded DERP server has received from
+and sent to each node since headscale was started, heaviest users first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.ListNodeStatsRequest{
+			NodeId: identifier,
+		}
+
+		response, err := client.ListNodeStats(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get node stats: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetNodeStats(), "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "Name", "NodeKey", "DERP received (bytes)", "DERP sent (bytes)"},
+		}
+		for _, stats := range response.GetNodeStats() {
+			var nodeKey key.NodePublic
+			err := nodeKey.UnmarshalText([]byte(stats.GetNodeKey()))
+			if err != nil {
+				nodeKey = key.NodePublic{}
+			}
+
+			tableData = append(
+				tableData,
+				[]string{
+					strconv.FormatUint(stats.GetNodeId(), util.Base10),
+					stats.GetName(),
+					nodeKey.ShortString(),
+					strconv.FormatUint(stats.GetDerpRxBytes(), util.Base10),
+					strconv.FormatUint(stats.GetDerpTxBytes(), util.Base10),
+				},
+			)
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var nodeNetcheckCmd = &cobra.Command{
+	Use:   "netcheck",
+	Short: "Show the netcheck reports sent by a node",
+	Long: `
+Show the network conditions a node reported, newest first, and the
+latency to each DERP region of the last report. Nodes send a report
+when they connect and whenever their network changes.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := cmd.Flags().GetUint64("identifier")
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.GetNodeNetcheck(ctx, &v1.GetNodeNetcheckRequest{
+			NodeId: identifier,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get netcheck reports: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetReports(), "", output)
+		}
+
+		if len(response.GetReports()) == 0 {
+			SuccessOutput(nil, "The node has not sent a netcheck report", "")
+		}
+
+		tableData := pterm.TableData{
+			{"Reported", "Preferred DERP", "NAT", "UDP", "IPv6", "Hairpinning", "Port mapping"},
+		}
+		for _, report := range response.GetReports() {
+			var portMapping []string
+			for name, value := range map[string]string{
+				"UPnP":    report.GetUpnp(),
+				"NAT-PMP": report.GetPmp(),
+				"PCP":     report.GetPcp(),
+			} {
+				if value == "true" {
+					portMapping = append(portMapping, name)
+				}
+			}
+			slices.Sort(portMapping)
+
+			tableData = append(
+				tableData,
+				[]string{
+					report.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+					strconv.Itoa(int(report.GetPreferredDerp())),
+					valueOrDash(report.GetNatType()),
+					valueOrDash(report.GetWorkingUdp()),
+					valueOrDash(report.GetWorkingIpv6()),
+					valueOrDash(report.GetHairPinning()),
+					valueOrDash(strings.Join(portMapping, ", ")),
+				},
+			)
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		latest := response.GetReports()[0]
+		regions := make([]string, 0, len(latest.GetDerpLatency()))
+		for region := range latest.GetDerpLatency() {
+			regions = append(regions, region)
+		}
+		slices.SortFunc(regions, func(a, b string) int {
+			return cmp.Compare(latest.GetDerpLatency()[a], latest.GetDerpLatency()[b])
+		})
+
+		latencyData := pterm.TableData{{"DERP region", "Latency"}}
+		for _, region := range regions {
+			latency := time.Duration(latest.GetDerpLatency()[region] * float64(time.Second))
+			latencyData = append(latencyData, []string{region, latency.Round(time.Millisecond / 10).String()})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(latencyData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var describeNodeCmd = &cobra.Command{
+	Use:     "describe ID",
+	Short:   "Show everything known about a node",
+	Aliases: []string{"show", "info"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		identifier, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		nodeResponse, err := client.GetNode(ctx, &v1.GetNodeRequest{NodeId: identifier})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get node: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		routesResponse, err := client.GetNodeRoutes(ctx, &v1.GetNodeRoutesRequest{NodeId: identifier})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get routes of node: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		node := nodeResponse.GetNode()
+		routes := routesResponse.GetRoutes()
+
+		if output != "" {
+			SuccessOutput(struct {
+				Node   *v1.Node    `json:"node"`
+				Routes []*v1.Route `json:"routes"`
+			}{node, routes}, "", output)
+		}
+
+		err = pterm.DefaultTable.WithData(describeNodeTable(node)).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		if len(routes) == 0 {
+			return
+		}
+
+		fmt.Println()
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(routesToPtables(routes)).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var outdatedNodesCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "List nodes running a Tailscale client older than a version",
+	Long: `
+List the nodes whose Tailscale client is older than --min-version,
+according to the version they reported when they last connected.
+Nodes that never reported a version are not listed.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		minVersion, _ := cmd.Flags().GetString("min-version")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListNodes(ctx, &v1.ListNodesRequest{
+			ClientVersionOlderThan: minVersion,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get nodes: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if !isTableOutput(output) {
+			SuccessOutput(response.GetNodes(), "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "Name", "User", "OS", "Client version", "Last seen"},
+		}
+		for _, node := range response.GetNodes() {
+			lastSeen := "-"
+			if node.GetLastSeen() != nil {
+				lastSeen = node.GetLastSeen().AsTime().Format(HeadscaleDateTimeFormat)
+			}
+
+			tableData = append(tableData, []string{
+				strconv.FormatUint(node.GetId(), 10),
+				node.GetGivenName(),
+				node.GetUser().GetName(),
+				valueOrDash(node.GetHostinfo().GetOs()),
+				node.GetHostinfo().GetClientVersion(),
+				lastSeen,
+			})
+		}
+
+		err = renderTable(tableData, output)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+// describeNodeTable lists the details of a node as rows of a field
+// name and its value.
+func describeNodeTable(node *v1.Node) pterm.TableData {
+	formatTime := func(ts interface{ AsTime() time.Time }, set bool) string {
+		if !set {
+			return "-"
+		}
+
+		return ts.AsTime().Format(HeadscaleDateTimeFormat)
+	}
+
+	derpRegion := "-"
+	if node.GetDerpRegion() != 0 {
+		derpRegion = strconv.Itoa(int(node.GetDerpRegion()))
+	}
+
+	hostinfo := node.GetHostinfo()
+	osVersion := strings.TrimSpace(hostinfo.GetOs() + " " + hostinfo.GetOsVersion())
+	distro := strings.TrimSpace(hostinfo.GetDistro() + " " + hostinfo.GetDistroVersion())
+
+	preAuthKey := "-"
+	if key := node.GetPreAuthKey(); key != nil {
+		preAuthKey = fmt.Sprintf("%s (user %s", key.GetId(), key.GetUser())
+		if key.GetEphemeral() {
+			preAuthKey += ", ephemeral"
+		}
+		if key.GetReusable() {
+			preAuthKey += ", reusable"
+		}
+		preAuthKey += ")"
+	}
+
+	return pterm.TableData{
+		{"ID", strconv.FormatUint(node.GetId(), 10)},
+		{"Name", node.GetGivenName()},
+		{"Hostname", node.GetName()},
+		{"User", node.GetUser().GetName()},
+		{"Service", valueOrDash(node.GetService())},
+		{"Registered with", strings.TrimPrefix(node.GetRegisterMethod().String(), "REGISTER_METHOD_")},
+		{"Pre-auth key", preAuthKey},
+		{"IP addresses", valueOrDash(strings.Join(node.GetIpAddresses(), ", "))},
+		{"Endpoints", valueOrDash(strings.Join(node.GetEndpoints(), ", "))},
+		{"DERP region", derpRegion},
+		{"OS", valueOrDash(osVersion)},
+		{"Distribution", valueOrDash(distro)},
+		{"Device model", valueOrDash(hostinfo.GetDeviceModel())},
+		{"Client version", valueOrDash(hostinfo.GetClientVersion())},
+		{"Forced tags", valueOrDash(strings.Join(node.GetForcedTags(), ", "))},
+		{"Valid tags", valueOrDash(strings.Join(node.GetValidTags(), ", "))},
+		{"Invalid tags", valueOrDash(strings.Join(node.GetInvalidTags(), ", "))},
+		{"Online", strconv.FormatBool(node.GetOnline())},
+		{"Last seen", formatTime(node.GetLastSeen(), node.GetLastSeen() != nil)},
+		{"Last address", valueOrDash(node.GetLastSeenAddr())},
+		{"Location", valueOrDash(node.GetLastSeenLocation())},
+		{"Expiry", formatTime(node.GetExpiry(), node.GetExpiry() != nil)},
+		{"Created", formatTime(node.GetCreatedAt(), node.GetCreatedAt() != nil)},
+		{"Machine key", node.GetMachineKey()},
+		{"Node key", node.GetNodeKey()},
+	}
+}
+
+// valueOrDash returns value, or a dash if it is empty.
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
+
 func nodesToPtables(
 	currentUser string,
 	showTags bool,
+	wide bool,
 	nodes []*v1.Node,
 ) (pterm.TableData, error) {
 	tableHeader := []string{
@@ -531,6 +1424,7 @@ func nodesToPtables(
 		"Expiration",
 		"Connected",
 		"Expired",
+		"Note",
 	}
 	if showTags {
 		tableHeader = append(tableHeader, []string{
@@ -539,6 +1433,13 @@ func nodesToPtables(
 			"ValidTags",
 		}...)
 	}
+	if wide {
+		tableHeader = append(tableHeader, []string{
+			"Last address",
+			"Location",
+			"Endpoints",
+		}...)
+	}
 	tableData := pterm.TableData{tableHeader}
 
 	for _, node := range nodes {
@@ -614,7 +1515,10 @@ func nodesToPtables(
 		validTags = strings.TrimLeft(validTags, ",")
 
 		var user string
-		if currentUser == "" || (currentUser == node.GetUser().GetName()) {
+		if node.GetService() != "" {
+			// Attributed to a service by its pre auth key
+			user = pterm.LightCyan("svc:" + node.GetService())
+		} else if currentUser == "" || (currentUser == node.GetUser().GetName()) {
 			user = pterm.LightMagenta(node.GetUser().GetName())
 		} else {
 			// Shared into this user
@@ -644,10 +1548,18 @@ func nodesToPtables(
 			expiryTime,
 			online,
 			expired,
+			node.GetNote(),
 		}
 		if showTags {
 			nodeData = append(nodeData, []string{forcedTags, invalidTags, validTags}...)
 		}
+		if wide {
+			nodeData = append(nodeData, []string{
+				node.GetLastSeenAddr(),
+				node.GetLastSeenLocation(),
+				strings.Join(node.GetEndpoints(), ", "),
+			}...)
+		}
 		tableData = append(
 			tableData,
 			nodeData,
@@ -689,10 +1601,51 @@ var tagCmd = &cobra.Command{
 			return
 		}
 
+		force, _ := cmd.Flags().GetBool("force")
+		tagsToAdd, _ := cmd.Flags().GetStringSlice("add")
+		tagsToRemove, _ := cmd.Flags().GetStringSlice("remove")
+
+		if len(tagsToAdd) > 0 || len(tagsToRemove) > 0 {
+			var node *v1.Node
+			for _, tag := range tagsToAdd {
+				resp, err := client.AddTag(ctx, &v1.AddTagRequest{
+					NodeId: identifier,
+					Tag:    tag,
+					Force:  force,
+				})
+				if err != nil {
+					ErrorOutput(
+						err,
+						fmt.Sprintf("Error while adding tag %s: %s", tag, status.Convert(err).Message()),
+						output,
+					)
+				}
+				node = resp.GetNode()
+			}
+
+			for _, tag := range tagsToRemove {
+				resp, err := client.RemoveTag(ctx, &v1.RemoveTagRequest{
+					NodeId: identifier,
+					Tag:    tag,
+				})
+				if err != nil {
+					ErrorOutput(
+						err,
+						fmt.Sprintf("Error while removing tag %s: %s", tag, status.Convert(err).Message()),
+						output,
+					)
+				}
+				node = resp.GetNode()
+			}
+
+			SuccessOutput(node, "Node updated", output)
+		}
+
 		// Sending tags to node
 		request := &v1.SetTagsRequest{
 			NodeId: identifier,
 			Tags:   tagsToSet,
+			Force:  force,
 		}
 		resp, err := client.SetTags(ctx, request)
 		if err != nil {
@@ -714,3 +1667,129 @@ var tagCmd = &cobra.Command{
 		}
 	},
 }
+
+var (
+	errC2NStatus  = errors.New("node answered with an error")
+	errNoNodeKeys = errors.New("no node key given")
+)
+
+var c2nNodeCmd = &cobra.Command{
+	Use:   "c2n ID ENDPOINT",
+	Short: "Send a request to the Tailscale client of a connected node",
+	Long: `Send a control-to-node request to the Tailscale client of a connected node,
+for example to read its preferences with /debug/prefs or to start an update
+with "-X POST /update". The response body is printed as is.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		method, _ := cmd.Flags().GetString("method")
+		data, _ := cmd.Flags().GetString("data")
+
+		identifier, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Error converting ID to integer: %s", err),
+				output,
+			)
+
+			return
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.NodeC2N(ctx, &v1.NodeC2NRequest{
+			NodeId: identifier,
+			Method: strings.ToUpper(method),
+			Path:   args[1],
+			Body:   []byte(data),
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot send request to node: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response, "", output)
+
+			return
+		}
+
+		if response.GetStatusCode() >= 400 {
+			ErrorOutput(
+				errC2NStatus,
+				fmt.Sprintf("Node answered %d: %s", response.GetStatusCode(), strings.TrimSpace(string(response.GetBody()))),
+				output,
+			)
+
+			return
+		}
+
+		fmt.Print(string(response.GetBody()))
+	},
+}
+
+var previewFQDNCmd = &cobra.Command{
+	Use:   "preview-fqdn HOSTNAME",
+	Short: "Show the FQDN a node registering with this hostname would receive",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		user, _ := cmd.Flags().GetString("user")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.PreviewNodeFQDN(ctx, &v1.PreviewNodeFQDNRequest{
+			User:     user,
+			Hostname: args[0],
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot preview FQDN: %s", status.Convert(err).Message()),
+				output,
+			)
+
+			return
+		}
+
+		if output != "" {
+			SuccessOutput(response, "", output)
+
+			return
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(pterm.TableData{
+			{"Given name", "FQDN", "Collision"},
+			{response.GetGivenName(), response.GetFqdn(), valueOrDash(response.GetCollision())},
+		}).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
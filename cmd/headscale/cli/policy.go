@@ -4,10 +4,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 func init() {
@@ -18,7 +25,51 @@ func init() {
 	if err := setPolicy.MarkFlagRequired("file"); err != nil {
 		log.Fatal().Err(err).Msg("")
 	}
+	setPolicy.Flags().Uint64("expected-version", 0, "Only update the policy if it still has this version")
 	policyCmd.AddCommand(setPolicy)
+
+	for _, cmd := range []*cobra.Command{
+		addPolicyGroupMembersCmd,
+		removePolicyGroupMembersCmd,
+		setPolicyHostCmd,
+		deletePolicyHostCmd,
+	} {
+		cmd.Flags().Uint64("expected-version", 0, "Only update the policy if it still has this version")
+	}
+
+	policyGroupsCmd.AddCommand(addPolicyGroupMembersCmd)
+	policyGroupsCmd.AddCommand(removePolicyGroupMembersCmd)
+	policyCmd.AddCommand(policyGroupsCmd)
+
+	policyHostsCmd.AddCommand(setPolicyHostCmd)
+	policyHostsCmd.AddCommand(deletePolicyHostCmd)
+	policyCmd.AddCommand(policyHostsCmd)
+
+	policyStatsCmd.Flags().Bool("unused", false, "Only list the rules that do not allow any node to connect")
+	policyCmd.AddCommand(policyStatsCmd)
+
+	policyCmd.AddCommand(policyDiffCmd)
+
+	policyCmd.AddCommand(policySchemaCmd)
+
+	startPolicyRolloutCmd.Flags().StringP("file", "f", "", "Path to a policy file in HuJSON format")
+	if err := startPolicyRolloutCmd.MarkFlagRequired("file"); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	startPolicyRolloutCmd.Flags().String("canary-tag", "", "Tag of the nodes the policy is applied to first")
+	if err := startPolicyRolloutCmd.MarkFlagRequired("canary-tag"); err != nil {
+		log.Fatal().Err(err).Msg("")
+	}
+	startPolicyRolloutCmd.Flags().Duration("duration", time.Hour, "How long the canary nodes get the policy before it is promoted")
+	startPolicyRolloutCmd.Flags().StringSlice("probe", nil, "Name or tailnet address of a node the canary nodes must keep reaching, can be repeated")
+	startPolicyRolloutCmd.Flags().Uint64("expected-version", 0, "Only start the rollout if the policy still has this version")
+	rollbackPolicyRolloutCmd.Flags().String("reason", "", "Why the rollout is rolled back")
+
+	policyRolloutCmd.AddCommand(startPolicyRolloutCmd)
+	policyRolloutCmd.AddCommand(policyRolloutStatusCmd)
+	policyRolloutCmd.AddCommand(promotePolicyRolloutCmd)
+	policyRolloutCmd.AddCommand(rollbackPolicyRolloutCmd)
+	policyCmd.AddCommand(policyRolloutCmd)
 }
 
 var policyCmd = &cobra.Command{
@@ -72,7 +123,12 @@ var setPolicy = &cobra.Command{
 			ErrorOutput(err, fmt.Sprintf("Error reading the policy file: %s", err), output)
 		}
 
-		request := &v1.SetPolicyRequest{Policy: string(policyBytes)}
+		expectedVersion, _ := cmd.Flags().GetUint64("expected-version")
+
+		request := &v1.SetPolicyRequest{
+			Policy:          string(policyBytes),
+			ExpectedVersion: expectedVersion,
+		}
 
 		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
 		defer cancel()
@@ -85,3 +141,500 @@ var setPolicy = &cobra.Command{
 		SuccessOutput(nil, "Policy updated.", "")
 	},
 }
+
+var policyGroupsCmd = &cobra.Command{
+	Use:   "groups",
+	Short: "Edit the groups of the ACL Policy",
+}
+
+var addPolicyGroupMembersCmd = &cobra.Command{
+	Use:   "add GROUP MEMBER...",
+	Short: "Adds members to a group, creating it if needed",
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 2
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		expectedVersion, _ := cmd.Flags().GetUint64("expected-version")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.AddPolicyGroupMembers(ctx, &v1.AddPolicyGroupMembersRequest{
+			Group:           args[0],
+			Members:         args[1:],
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot add group members: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf("Policy updated, version %d.", response.GetVersion()),
+			output,
+		)
+	},
+}
+
+var removePolicyGroupMembersCmd = &cobra.Command{
+	Use:     "remove GROUP MEMBER...",
+	Short:   "Removes members from a group",
+	Aliases: []string{"rm"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 2
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		expectedVersion, _ := cmd.Flags().GetUint64("expected-version")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.RemovePolicyGroupMembers(ctx, &v1.RemovePolicyGroupMembersRequest{
+			Group:           args[0],
+			Members:         args[1:],
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot remove group members: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf("Policy updated, version %d.", response.GetVersion()),
+			output,
+		)
+	},
+}
+
+var policyHostsCmd = &cobra.Command{
+	Use:   "hosts",
+	Short: "Edit the hosts of the ACL Policy",
+}
+
+var setPolicyHostCmd = &cobra.Command{
+	Use:   "set NAME ADDRESS",
+	Short: "Adds a host or changes its address or prefix",
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 2
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		expectedVersion, _ := cmd.Flags().GetUint64("expected-version")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.SetPolicyHost(ctx, &v1.SetPolicyHostRequest{
+			Name:            args[0],
+			Address:         args[1],
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot set host: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf("Policy updated, version %d.", response.GetVersion()),
+			output,
+		)
+	},
+}
+
+var deletePolicyHostCmd = &cobra.Command{
+	Use:     "delete NAME",
+	Short:   "Removes a host",
+	Aliases: []string{"del", "rm"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		expectedArguments := 1
+		if len(args) < expectedArguments {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		expectedVersion, _ := cmd.Flags().GetUint64("expected-version")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.DeletePolicyHost(ctx, &v1.DeletePolicyHostRequest{
+			Name:            args[0],
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot delete host: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf("Policy updated, version %d.", response.GetVersion()),
+			output,
+		)
+	},
+}
+
+var policyStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Shows how many nodes each ACL rule applies to",
+	Long: `
+	Shows how many nodes the sources and destinations of each ACL rule expand to,
+	and how many pairs of nodes the rule allows to connect. Rules without pairs
+	do not do anything and can likely be removed, the time they have been unused
+	for is tracked while headscale runs.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		unused, _ := cmd.Flags().GetBool("unused")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.GetPolicyStats(ctx, &v1.GetPolicyStatsRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get policy stats: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		rules := response.GetRules()
+		if unused {
+			rules = nil
+			for _, rule := range response.GetRules() {
+				if rule.GetPairs() == 0 {
+					rules = append(rules, rule)
+				}
+			}
+		}
+
+		if output != "" {
+			SuccessOutput(rules, "", output)
+		}
+
+		tableData := pterm.TableData{
+			{"Index", "Sources", "Destinations", "Source nodes", "Destination nodes", "Pairs", "Unused since", "Description"},
+		}
+		for _, rule := range rules {
+			unusedSince := "-"
+			if rule.GetUnusedSince() != nil {
+				unusedSince = rule.GetUnusedSince().AsTime().Format("2006-01-02 15:04:05")
+			}
+
+			tableData = append(tableData, []string{
+				strconv.FormatUint(uint64(rule.GetIndex()), 10),
+				strings.Join(rule.GetSources(), ","),
+				strings.Join(rule.GetDestinations(), ","),
+				strconv.FormatUint(rule.GetSourceNodes(), 10),
+				strconv.FormatUint(rule.GetDestinationNodes(), 10),
+				strconv.FormatUint(rule.GetPairs(), 10),
+				unusedSince,
+				valueOrDash(rule.GetDescription()),
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var policyDiffCmd = &cobra.Command{
+	Use:   "diff OLD NEW",
+	Short: "Shows which connections a new policy allows or denies compared to an old one",
+	Long: `
+	Compiles both policy files against the current nodes and lists, for every pair
+	of nodes, the ports the source can newly reach on the destination and the ports
+	it cannot reach anymore. Pairs the policies treat the same are left out, so
+	reordering rules or renaming groups shows no difference.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		var policies [2]string
+		for i, path := range args {
+			policyBytes, err := os.ReadFile(path)
+			if err != nil {
+				ErrorOutput(err, fmt.Sprintf("Error reading the policy file: %s", err), output)
+			}
+			policies[i] = string(policyBytes)
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.DiffPolicy(ctx, &v1.DiffPolicyRequest{
+			OldPolicy: policies[0],
+			NewPolicy: policies[1],
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot diff the policies: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetPairs(), "", output)
+		}
+
+		if len(response.GetPairs()) == 0 {
+			SuccessOutput(nil, "The policies allow the same connections.", "")
+
+			return
+		}
+
+		tableData := pterm.TableData{
+			{"Change", "Source", "Destination", "Added ports", "Removed ports"},
+		}
+		for _, pair := range response.GetPairs() {
+			tableData = append(tableData, []string{
+				pair.GetChange(),
+				pair.GetSourceName(),
+				pair.GetDestinationName(),
+				valueOrDash(strings.Join(pair.GetAddedPorts(), ",")),
+				valueOrDash(strings.Join(pair.GetRemovedPorts(), ",")),
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var policySchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the policy format",
+	Long: `
+Print the JSON Schema of the policy format accepted by this version of
+headscale, including its extensions, for editors and CI to validate policies.
+The server also serves it at /policy/schema.json.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		schema, err := policy.Schema()
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Cannot generate the policy schema: %s", err), output)
+		}
+
+		fmt.Print(string(schema))
+	},
+}
+
+var policyRolloutCmd = &cobra.Command{
+	Use:   "rollout",
+	Short: "Roll out a policy to canary nodes before the whole tailnet",
+	Long: `
+	Applies a new policy to the nodes with a canary tag only. The policy is promoted
+	to the whole tailnet once the duration of the rollout passed, and rolled back as
+	soon as a canary node reports a new health warning or fails to ping one of the
+	probes several times in a row. Only works when policy.mode is "database".`,
+}
+
+var startPolicyRolloutCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start rolling out a policy to the canary nodes",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		policyPath, _ := cmd.Flags().GetString("file")
+		canaryTag, _ := cmd.Flags().GetString("canary-tag")
+		duration, _ := cmd.Flags().GetDuration("duration")
+		probes, _ := cmd.Flags().GetStringSlice("probe")
+		expectedVersion, _ := cmd.Flags().GetUint64("expected-version")
+
+		policyBytes, err := os.ReadFile(policyPath)
+		if err != nil {
+			ErrorOutput(err, fmt.Sprintf("Error reading the policy file: %s", err), output)
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.StartPolicyRollout(ctx, &v1.StartPolicyRolloutRequest{
+			Policy:          string(policyBytes),
+			CanaryTag:       canaryTag,
+			Duration:        durationpb.New(duration),
+			Probes:          probes,
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot start the policy rollout: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		rollout := response.GetRollout()
+		SuccessOutput(
+			rollout,
+			fmt.Sprintf(
+				"Rolling out the policy to %d canary nodes with %s, promoting it at %s.",
+				len(rollout.GetCanaryNodes()),
+				rollout.GetCanaryTag(),
+				rollout.GetPromoteAt().AsTime().Local().Format("2006-01-02 15:04:05"),
+			),
+			output,
+		)
+	},
+}
+
+var policyRolloutStatusCmd = &cobra.Command{
+	Use:     "status",
+	Short:   "Show the policy rollout in progress, or the last one",
+	Aliases: []string{"show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.GetPolicyRollout(ctx, &v1.GetPolicyRolloutRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get the policy rollout: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		rollout := response.GetRollout()
+		if output != "" {
+			SuccessOutput(rollout, "", output)
+		}
+
+		canaryNodes := make([]string, 0, len(rollout.GetCanaryNodes()))
+		for _, id := range rollout.GetCanaryNodes() {
+			canaryNodes = append(canaryNodes, strconv.FormatUint(id, 10))
+		}
+
+		tableData := pterm.TableData{
+			{"ID", strconv.FormatUint(rollout.GetId(), 10)},
+			{"State", rollout.GetState()},
+			{"Canary tag", rollout.GetCanaryTag()},
+			{"Canary nodes", valueOrDash(strings.Join(canaryNodes, ","))},
+			{"Probes", valueOrDash(strings.Join(rollout.GetProbes(), ","))},
+			{"Base version", strconv.FormatUint(rollout.GetBaseVersion(), 10)},
+			{"Started", rollout.GetCreatedAt().AsTime().Local().Format("2006-01-02 15:04:05")},
+			{"Promote at", rollout.GetPromoteAt().AsTime().Local().Format("2006-01-02 15:04:05")},
+			{"Reason", valueOrDash(rollout.GetReason())},
+		}
+
+		err = pterm.DefaultTable.WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var promotePolicyRolloutCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote the policy of the rollout to the whole tailnet now",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.PromotePolicyRollout(ctx, &v1.PromotePolicyRolloutRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot promote the policy rollout: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf("Policy promoted, version %d.", response.GetVersion()),
+			output,
+		)
+	},
+}
+
+var rollbackPolicyRolloutCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Give the canary nodes the policy of the tailnet back",
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		reason, _ := cmd.Flags().GetString("reason")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.RollbackPolicyRollout(ctx, &v1.RollbackPolicyRolloutRequest{
+			Reason: reason,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot roll back the policy rollout: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(response.GetRollout(), "Policy rollout rolled back.", output)
+	},
+}
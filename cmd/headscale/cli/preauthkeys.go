@@ -11,6 +11,7 @@ import (
 	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -42,6 +43,14 @@ func init() {
 		StringP("expiration", "e", DefaultPreAuthKeyExpiry, "Human-readable expiration of the key (e.g. 30m, 24h)")
 	createPreAuthKeyCmd.Flags().
 		StringSlice("tags", []string{}, "Tags to automatically assign to node")
+	createPreAuthKeyCmd.Flags().
+		String("node-name-prefix", "", "Prefix of the names given to the nodes registered with the key")
+	createPreAuthKeyCmd.Flags().
+		String("node-expiry", "", "Human-readable expiry of the nodes registered with the key (e.g. 90d)")
+	createPreAuthKeyCmd.Flags().
+		StringSlice("approve-routes", []string{}, "Routes the nodes registered with the key may advertise without approval")
+	createPreAuthKeyCmd.Flags().
+		String("service", "", "Service account the nodes registered with the tagged key are attributed to, svc:<service> in the policy")
 }
 
 var preauthkeysCmd = &cobra.Command{
@@ -95,6 +104,7 @@ var listPreAuthKeys = &cobra.Command{
 				"Expiration",
 				"Created",
 				"Tags",
+				"Service",
 			},
 		}
 		for _, key := range response.GetPreAuthKeys() {
@@ -120,6 +130,7 @@ var listPreAuthKeys = &cobra.Command{
 				expiration,
 				key.GetCreatedAt().AsTime().Format("2006-01-02 15:04:05"),
 				aclTags,
+				key.GetService(),
 			})
 
 		}
@@ -176,6 +187,23 @@ var createPreAuthKeyCmd = &cobra.Command{
 
 		request.Expiration = timestamppb.New(expiration)
 
+		request.NodeNamePrefix, _ = cmd.Flags().GetString("node-name-prefix")
+		request.ApprovedRoutes, _ = cmd.Flags().GetStringSlice("approve-routes")
+		request.Service, _ = cmd.Flags().GetString("service")
+
+		if nodeExpiryStr, _ := cmd.Flags().GetString("node-expiry"); nodeExpiryStr != "" {
+			nodeExpiry, err := model.ParseDuration(nodeExpiryStr)
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Could not parse node expiry: %s\n", err),
+					output,
+				)
+			}
+
+			request.NodeExpiry = durationpb.New(time.Duration(nodeExpiry))
+		}
+
 		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
 		defer cancel()
 		defer conn.Close()
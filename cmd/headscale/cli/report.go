@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(inventoryReportCmd)
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate reports about the tailnet",
+}
+
+// inventoryEntry is a node in the inventory report.
+type inventoryEntry struct {
+	ID            uint64     `json:"id"`
+	Name          string     `json:"name"`
+	Hostname      string     `json:"hostname"`
+	User          string     `json:"user"`
+	OS            string     `json:"os"`
+	ClientVersion string     `json:"client_version"`
+	IPAddresses   []string   `json:"ip_addresses"`
+	Online        bool       `json:"online"`
+	LastSeen      *time.Time `json:"last_seen,omitempty"`
+	Expiry        *time.Time `json:"expiry,omitempty"`
+	Tags          []string   `json:"tags"`
+}
+
+func newInventoryEntry(node *v1.Node) inventoryEntry {
+	hostinfo := node.GetHostinfo()
+
+	tags := slices.Concat(node.GetForcedTags(), node.GetValidTags())
+	slices.Sort(tags)
+
+	entry := inventoryEntry{
+		ID:            node.GetId(),
+		Name:          node.GetGivenName(),
+		Hostname:      node.GetName(),
+		User:          node.GetUser().GetName(),
+		OS:            strings.TrimSpace(hostinfo.GetOs() + " " + hostinfo.GetOsVersion()),
+		ClientVersion: hostinfo.GetClientVersion(),
+		IPAddresses:   node.GetIpAddresses(),
+		Online:        node.GetOnline(),
+		Tags:          slices.Compact(tags),
+	}
+
+	if node.GetLastSeen() != nil {
+		lastSeen := node.GetLastSeen().AsTime()
+		entry.LastSeen = &lastSeen
+	}
+
+	if node.GetExpiry() != nil {
+		expiry := node.GetExpiry().AsTime()
+		entry.Expiry = &expiry
+	}
+
+	return entry
+}
+
+var inventoryReportCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "List every node with its user, OS, client version, last seen time and tags",
+	Long: `
+List every node with its user, OS, client version, last seen time and tags,
+as an asset report. Use --output csv or --output markdown to export it.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListNodes(ctx, &v1.ListNodesRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get nodes: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		entries := make([]inventoryEntry, 0, len(response.GetNodes()))
+		for _, node := range response.GetNodes() {
+			entries = append(entries, newInventoryEntry(node))
+		}
+
+		if !isTableOutput(output) {
+			SuccessOutput(entries, "", output)
+		}
+
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+
+			return t.Format(HeadscaleDateTimeFormat)
+		}
+
+		tableData := pterm.TableData{
+			{"ID", "Name", "Hostname", "User", "OS", "Client version", "IP addresses", "Online", "Last seen", "Expiry", "Tags"},
+		}
+		for _, entry := range entries {
+			tableData = append(tableData, []string{
+				strconv.FormatUint(entry.ID, 10),
+				entry.Name,
+				entry.Hostname,
+				entry.User,
+				entry.OS,
+				entry.ClientVersion,
+				strings.Join(entry.IPAddresses, ", "),
+				strconv.FormatBool(entry.Online),
+				formatTime(entry.LastSeen),
+				formatTime(entry.Expiry),
+				strings.Join(entry.Tags, ", "),
+			})
+		}
+
+		err = renderTable(tableData, output)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render table: %s", err),
+				output,
+			)
+		}
+	},
+}
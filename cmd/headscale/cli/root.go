@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"runtime"
@@ -29,9 +30,12 @@ func init() {
 	rootCmd.PersistentFlags().
 		StringVarP(&cfgFile, "config", "c", "", "config file (default is /etc/headscale/config.yaml)")
 	rootCmd.PersistentFlags().
-		StringP("output", "o", "", "Output format. Empty for human-readable, 'json', 'json-line' or 'yaml'")
+		StringP("output", "o", "", "Output format. Empty for human-readable, 'json', 'json-line' or 'yaml'. 'wide' shows more columns in node lists, 'csv' and 'markdown' export lists as tables")
 	rootCmd.PersistentFlags().
 		Bool("force", false, "Disable prompts and forces the execution")
+	rootCmd.PersistentFlags().
+		String("context", "", "Context to connect with, see 'headscale context'")
+	viper.BindPFlag("cli.context", rootCmd.PersistentFlags().Lookup("context"))
 }
 
 func initConfig() {
@@ -44,8 +48,10 @@ func initConfig() {
 			log.Fatal().Caller().Err(err).Msgf("Error loading config file %s", cfgFile)
 		}
 	} else {
+		// The CLI can connect to remote servers without a configuration
+		// file, using contexts or the environment.
 		err := types.LoadConfig("", false)
-		if err != nil {
+		if err != nil && !errors.As(err, &viper.ConfigFileNotFoundError{}) {
 			log.Fatal().Caller().Err(err).Msgf("Error loading config")
 		}
 	}
@@ -58,11 +64,6 @@ func initConfig() {
 		zerolog.SetGlobalLevel(zerolog.Disabled)
 	}
 
-	// logFormat := viper.GetString("log.format")
-	// if logFormat == types.JSONLogFormat {
-	// 	log.Logger = log.Output(os.Stdout)
-	// }
-
 	disableUpdateCheck := viper.GetBool("disable_check_updates")
 	if !disableUpdateCheck && !machineOutput {
 		if (runtime.GOOS == "linux" || runtime.GOOS == "darwin") &&
@@ -5,12 +5,15 @@ import (
 	"log"
 	"net/netip"
 	"strconv"
+	"strings"
+	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/pterm/pterm"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
@@ -22,26 +25,32 @@ func init() {
 	listRoutesCmd.Flags().Uint64P("identifier", "i", 0, "Node identifier (ID)")
 	routesCmd.AddCommand(listRoutesCmd)
 
-	enableRouteCmd.Flags().Uint64P("route", "r", 0, "Route identifier (ID)")
-	err := enableRouteCmd.MarkFlagRequired("route")
-	if err != nil {
-		log.Fatalf(err.Error())
+	for _, cmd := range []*cobra.Command{enableRouteCmd, disableRouteCmd} {
+		cmd.Flags().Uint64P("route", "r", 0, "Route identifier (ID)")
+		cmd.Flags().StringP("prefix", "p", "", "Prefix to change on all nodes advertising it")
+		cmd.Flags().String("tag", "", "Only change the prefix on nodes with this tag")
+		cmd.Flags().String("user", "", "Only change the prefix on nodes of this user")
+		cmd.MarkFlagsOneRequired("route", "prefix")
+		cmd.MarkFlagsMutuallyExclusive("route", "prefix")
+		cmd.MarkFlagsMutuallyExclusive("route", "tag")
+		cmd.MarkFlagsMutuallyExclusive("route", "user")
 	}
 	routesCmd.AddCommand(enableRouteCmd)
-
-	disableRouteCmd.Flags().Uint64P("route", "r", 0, "Route identifier (ID)")
-	err = disableRouteCmd.MarkFlagRequired("route")
-	if err != nil {
-		log.Fatalf(err.Error())
-	}
 	routesCmd.AddCommand(disableRouteCmd)
 
 	deleteRouteCmd.Flags().Uint64P("route", "r", 0, "Route identifier (ID)")
-	err = deleteRouteCmd.MarkFlagRequired("route")
+	err := deleteRouteCmd.MarkFlagRequired("route")
 	if err != nil {
 		log.Fatalf(err.Error())
 	}
 	routesCmd.AddCommand(deleteRouteCmd)
+
+	routesCmd.AddCommand(effectiveRoutesCmd)
+
+	routeHistoryCmd.Flags().StringP("prefix", "p", "", "Only show the events of routes within this prefix")
+	routeHistoryCmd.Flags().Uint64P("identifier", "i", 0, "Only show the events of this node (ID)")
+	routeHistoryCmd.Flags().Duration("since", 0, "Only show the events of this last duration")
+	routesCmd.AddCommand(routeHistoryCmd)
 }
 
 var routesCmd = &cobra.Command{
@@ -122,13 +131,182 @@ var listRoutesCmd = &cobra.Command{
 	},
 }
 
+var effectiveRoutesCmd = &cobra.Command{
+	Use:   "effective",
+	Short: "Show which node serves each prefix",
+	Long: `
+Shows the nodes advertising each prefix, the primary route currently
+serving it and why it became primary, to debug subnet routers in high
+availability setups.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.GetEffectiveRoutes(ctx, &v1.GetEffectiveRoutesRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get effective routes: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetRoutes(), "", output)
+		}
+
+		tableData := pterm.TableData{{"Prefix", "Primary", "Advertised by", "Primary since", "Reason"}}
+		for _, route := range response.GetRoutes() {
+			var advertisers []string
+			for _, advertiser := range route.GetAdvertisers() {
+				name := advertiser.GetNode().GetGivenName()
+
+				var state []string
+				if !advertiser.GetEnabled() {
+					state = append(state, "disabled")
+				}
+				if !advertiser.GetNode().GetOnline() {
+					state = append(state, "offline")
+				}
+				if len(state) > 0 {
+					name += " (" + strings.Join(state, ", ") + ")"
+				}
+
+				advertisers = append(advertisers, name)
+			}
+
+			primary, since, reason := "-", "-", ""
+			switch {
+			case route.GetExitRoute():
+				primary = "all enabled"
+			case route.GetPrimary() != nil:
+				primary = route.GetPrimary().GetNode().GetGivenName()
+				reason = route.GetPrimary().GetPrimaryReason()
+				if route.GetPrimary().GetPrimaryChangedAt() != nil {
+					since = route.GetPrimary().GetPrimaryChangedAt().AsTime().Format(HeadscaleDateTimeFormat)
+				}
+			}
+
+			tableData = append(tableData, []string{
+				route.GetPrefix(),
+				primary,
+				strings.Join(advertisers, ", "),
+				since,
+				reason,
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var routeHistoryCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show when routes were advertised, approved and withdrawn",
+	Long: `
+Shows the recorded route changes, oldest first, with who made them: the
+node, headscale, the auto approvers, or the API key or local user that
+ran the command.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		prefix, _ := cmd.Flags().GetString("prefix")
+		nodeID, _ := cmd.Flags().GetUint64("identifier")
+		since, _ := cmd.Flags().GetDuration("since")
+
+		request := &v1.GetRouteHistoryRequest{
+			Prefix: prefix,
+			NodeId: nodeID,
+		}
+		if since > 0 {
+			request.Since = timestamppb.New(time.Now().Add(-since))
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.GetRouteHistory(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot get route history: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetEvents(), "", output)
+		}
+
+		tableData := pterm.TableData{{"Time", "Node", "Prefix", "Action", "Actor", "Reason"}}
+		for _, event := range response.GetEvents() {
+			tableData = append(tableData, []string{
+				event.GetCreatedAt().AsTime().Format(HeadscaleDateTimeFormat),
+				event.GetHostname(),
+				event.GetPrefix(),
+				event.GetAction(),
+				event.GetActor(),
+				event.GetReason(),
+			})
+		}
+
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
 var enableRouteCmd = &cobra.Command{
 	Use:   "enable",
 	Short: "Set a route as enabled",
-	Long:  `This command will make as enabled a given route.`,
+	Long: `This command will make as enabled a given route.
+With --prefix, the prefix is enabled on all nodes advertising it, or only
+on those matching --tag and --user.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
 
+		if prefix, _ := cmd.Flags().GetString("prefix"); prefix != "" {
+			tag, _ := cmd.Flags().GetString("tag")
+			user, _ := cmd.Flags().GetString("user")
+
+			ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+			defer cancel()
+			defer conn.Close()
+
+			response, err := client.EnablePrefixRoutes(ctx, &v1.EnablePrefixRoutesRequest{
+				Prefix: prefix,
+				Tag:    tag,
+				User:   user,
+			})
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Cannot enable prefix %s: %s", prefix, status.Convert(err).Message()),
+					output,
+				)
+			}
+
+			renderChangedRoutes(response.GetRoutes(), output)
+
+			return
+		}
+
 		routeID, err := cmd.Flags().GetUint64("route")
 		if err != nil {
 			ErrorOutput(
@@ -162,10 +340,38 @@ var enableRouteCmd = &cobra.Command{
 var disableRouteCmd = &cobra.Command{
 	Use:   "disable",
 	Short: "Set as disabled a given route",
-	Long:  `This command will make as disabled a given route.`,
+	Long: `This command will make as disabled a given route.
+With --prefix, the prefix is disabled on all nodes advertising it, or only
+on those matching --tag and --user.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		output, _ := cmd.Flags().GetString("output")
 
+		if prefix, _ := cmd.Flags().GetString("prefix"); prefix != "" {
+			tag, _ := cmd.Flags().GetString("tag")
+			user, _ := cmd.Flags().GetString("user")
+
+			ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+			defer cancel()
+			defer conn.Close()
+
+			response, err := client.DisablePrefixRoutes(ctx, &v1.DisablePrefixRoutesRequest{
+				Prefix: prefix,
+				Tag:    tag,
+				User:   user,
+			})
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Cannot disable prefix %s: %s", prefix, status.Convert(err).Message()),
+					output,
+				)
+			}
+
+			renderChangedRoutes(response.GetRoutes(), output)
+
+			return
+		}
+
 		routeID, err := cmd.Flags().GetUint64("route")
 		if err != nil {
 			ErrorOutput(
@@ -234,8 +440,29 @@ var deleteRouteCmd = &cobra.Command{
 }
 
 // routesToPtables converts the list of routes to a nice table.
+// renderChangedRoutes prints the routes changed by enabling or disabling
+// a prefix.
+func renderChangedRoutes(routes []*v1.Route, output string) {
+	if output != "" {
+		SuccessOutput(routes, "", output)
+	}
+
+	if len(routes) == 0 {
+		SuccessOutput(nil, "No routes changed", "")
+	}
+
+	err := pterm.DefaultTable.WithHasHeader().WithData(routesToPtables(routes)).Render()
+	if err != nil {
+		ErrorOutput(
+			err,
+			fmt.Sprintf("Failed to render pterm table: %s", err),
+			output,
+		)
+	}
+}
+
 func routesToPtables(routes []*v1.Route) pterm.TableData {
-	tableData := pterm.TableData{{"ID", "Node", "Prefix", "Advertised", "Enabled", "Primary"}}
+	tableData := pterm.TableData{{"ID", "Node", "Prefix", "Advertised", "Enabled", "Primary", "Warnings"}}
 
 	for _, route := range routes {
 		var isPrimaryStr string
@@ -259,6 +486,7 @@ func routesToPtables(routes []*v1.Route) pterm.TableData {
 				strconv.FormatBool(route.GetAdvertised()),
 				strconv.FormatBool(route.GetEnabled()),
 				isPrimaryStr,
+				strings.Join(route.GetHealthWarnings(), "; "),
 			})
 	}
 
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+	searchCmd.Flags().Int32P("limit", "l", 0, "Maximum number of results (default 20, at most 100)")
+}
+
+var searchCmd = &cobra.Command{
+	Use:   "search QUERY",
+	Short: "Search nodes and users",
+	Long: `
+Search the hostnames, names, IP addresses, tags, notes and keys of nodes and
+the names of users, best matches first. Address prefixes like 100.64.0.0/24
+find the nodes within them, and keys are found by their first characters.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		limit, _ := cmd.Flags().GetInt32("limit")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.Search(ctx, &v1.SearchRequest{
+			Query: strings.Join(args, " "),
+			Limit: limit,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot search: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if !isTableOutput(output) {
+			SuccessOutput(response.GetResults(), "", output)
+		}
+
+		tableData := pterm.TableData{{"Kind", "ID", "Name", "User", "IP addresses", "Match", "Score"}}
+		for _, result := range response.GetResults() {
+			match := result.GetField() + ": " + result.GetValue()
+			score := strconv.Itoa(int(result.GetScore()))
+
+			if node := result.GetNode(); node != nil {
+				tableData = append(tableData, []string{
+					"node",
+					strconv.FormatUint(node.GetId(), 10),
+					node.GetGivenName(),
+					node.GetUser().GetName(),
+					strings.Join(node.GetIpAddresses(), ", "),
+					match,
+					score,
+				})
+
+				continue
+			}
+
+			user := result.GetUser()
+			tableData = append(tableData, []string{"user", user.GetId(), user.GetName(), "", "", match, score})
+		}
+
+		if err := renderTable(tableData, output); err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
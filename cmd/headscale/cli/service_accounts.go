@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"fmt"
+	"strconv"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/pterm/pterm"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/status"
+)
+
+func init() {
+	rootCmd.AddCommand(serviceAccountsCmd)
+	createServiceAccountCmd.Flags().StringP("description", "d", "", "Description of the service account")
+	serviceAccountsCmd.AddCommand(createServiceAccountCmd)
+	serviceAccountsCmd.AddCommand(listServiceAccountsCmd)
+	serviceAccountsCmd.AddCommand(deleteServiceAccountCmd)
+}
+
+var serviceAccountsCmd = &cobra.Command{
+	Use:     "serviceaccounts",
+	Short:   "Manage the service accounts of Headscale",
+	Aliases: []string{"serviceaccount", "services", "svc"},
+	Long: `
+Service accounts own nodes not owned by a human, like fleets of workload
+nodes. They cannot log in, tagged nodes are attributed to them with
+"headscale preauthkeys create --service" or "headscale nodes service".
+The policy refers to them as svc:<name>.`,
+}
+
+var createServiceAccountCmd = &cobra.Command{
+	Use:     "create NAME",
+	Short:   "Creates a new service account",
+	Aliases: []string{"c", "new"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		description, _ := cmd.Flags().GetString("description")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.CreateServiceAccount(ctx, &v1.CreateServiceAccountRequest{
+			Name:        args[0],
+			Description: description,
+		})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot create service account: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(response.GetServiceAccount(), "Service account created", output)
+	},
+}
+
+var listServiceAccountsCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the service accounts",
+	Aliases: []string{"ls", "show"},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.ListServiceAccounts(ctx, &v1.ListServiceAccountsRequest{})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot list service accounts: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		if !isTableOutput(output) {
+			SuccessOutput(response.GetServiceAccounts(), "", output)
+		}
+
+		tableData := pterm.TableData{{"ID", "Name", "Description", "Created"}}
+		for _, account := range response.GetServiceAccounts() {
+			tableData = append(tableData, []string{
+				strconv.FormatUint(account.GetId(), util.Base10),
+				account.GetName(),
+				account.GetDescription(),
+				account.GetCreatedAt().AsTime().Format("2006-01-02 15:04:05"),
+			})
+		}
+
+		if err := renderTable(tableData, output); err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var deleteServiceAccountCmd = &cobra.Command{
+	Use:     "delete NAME",
+	Short:   "Deletes a service account no node or pre-auth key is attributed to",
+	Aliases: []string{"del", "rm", "destroy"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		response, err := client.DeleteServiceAccount(ctx, &v1.DeleteServiceAccountRequest{Name: args[0]})
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Cannot delete service account: %s", status.Convert(err).Message()),
+				output,
+			)
+		}
+
+		SuccessOutput(response, "Service account deleted", output)
+	},
+}
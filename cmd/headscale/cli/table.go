@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pterm/pterm"
+)
+
+const (
+	outputWide     = "wide"
+	outputCSV      = "csv"
+	outputMarkdown = "markdown"
+)
+
+// isTableOutput reports whether the output format is a table, rendered
+// from the same rows as the human-readable output.
+func isTableOutput(outputFormat string) bool {
+	switch outputFormat {
+	case "", outputWide, outputCSV, outputMarkdown:
+		return true
+	}
+
+	return false
+}
+
+// renderTable prints a table with a header row in the output format,
+// as CSV, as a Markdown table or for humans.
+func renderTable(tableData pterm.TableData, outputFormat string) error {
+	switch outputFormat {
+	case outputCSV:
+		return writeCSV(os.Stdout, tableData)
+	case outputMarkdown:
+		return writeMarkdown(os.Stdout, tableData)
+	}
+
+	return pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+}
+
+func writeCSV(w io.Writer, tableData pterm.TableData) error {
+	writer := csv.NewWriter(w)
+	for _, row := range tableData {
+		if err := writer.Write(plainRow(row)); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func writeMarkdown(w io.Writer, tableData pterm.TableData) error {
+	escape := strings.NewReplacer("|", `\|`, "\n", " ")
+
+	for index, row := range tableData {
+		cells := plainRow(row)
+		for i, cell := range cells {
+			cells[i] = escape.Replace(cell)
+		}
+
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | ")); err != nil {
+			return err
+		}
+
+		if index == 0 {
+			if _, err := fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(cells))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// plainRow removes the colours of the cells, which are only meant for
+// terminals.
+func plainRow(row []string) []string {
+	plain := make([]string, len(row))
+	for i, cell := range row {
+		plain[i] = pterm.RemoveColorFromString(cell)
+	}
+
+	return plain
+}
@@ -1,8 +1,11 @@
 package cli
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"os"
+	"strings"
 
 	survey "github.com/AlecAivazis/survey/v2"
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
@@ -18,6 +21,16 @@ func init() {
 	userCmd.AddCommand(listUsersCmd)
 	userCmd.AddCommand(destroyUserCmd)
 	userCmd.AddCommand(renameUserCmd)
+	userCmd.AddCommand(suspendUserCmd)
+	userCmd.AddCommand(resumeUserCmd)
+	userCmd.AddCommand(userHistoryCmd)
+
+	setUserPasswordCmd.Flags().Bool("password-stdin", false, "Read the password from stdin")
+	setUserPasswordCmd.Flags().Bool("clear", false, "Remove the password of the user")
+	userCmd.AddCommand(setUserPasswordCmd)
+
+	userTOTPCmd.Flags().Bool("disable", false, "Disable TOTP for the user")
+	userCmd.AddCommand(userTOTPCmd)
 }
 
 var errMissingParameter = errors.New("missing parameters")
@@ -160,22 +173,28 @@ var listUsersCmd = &cobra.Command{
 			)
 		}
 
-		if output != "" {
+		if !isTableOutput(output) {
 			SuccessOutput(response.GetUsers(), "", output)
 		}
 
-		tableData := pterm.TableData{{"ID", "Name", "Created"}}
+		tableData := pterm.TableData{{"ID", "Name", "Created", "Suspended"}}
 		for _, user := range response.GetUsers() {
+			suspended := ""
+			if user.GetSuspendedAt() != nil {
+				suspended = user.GetSuspendedAt().AsTime().Format("2006-01-02 15:04:05")
+			}
+
 			tableData = append(
 				tableData,
 				[]string{
 					user.GetId(),
 					user.GetName(),
 					user.GetCreatedAt().AsTime().Format("2006-01-02 15:04:05"),
+					suspended,
 				},
 			)
 		}
-		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		err = renderTable(tableData, output)
 		if err != nil {
 			ErrorOutput(
 				err,
@@ -225,3 +244,253 @@ var renameUserCmd = &cobra.Command{
 		SuccessOutput(response.GetUser(), "User renamed", output)
 	},
 }
+
+var suspendUserCmd = &cobra.Command{
+	Use:   "suspend NAME",
+	Short: "Suspends a user, expiring all of its nodes and blocking new registrations",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SuspendUserRequest{Name: args[0]}
+
+		response, err := client.SuspendUser(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot suspend user: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+		}
+
+		SuccessOutput(response.GetUser(), "User suspended", output)
+	},
+}
+
+var resumeUserCmd = &cobra.Command{
+	Use:   "resume NAME",
+	Short: "Lifts the suspension of a user, its nodes need to authenticate again",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.ResumeUserRequest{Name: args[0]}
+
+		response, err := client.ResumeUser(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot resume user: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+		}
+
+		SuccessOutput(response.GetUser(), "User resumed", output)
+	},
+}
+
+var userHistoryCmd = &cobra.Command{
+	Use:     "history NAME",
+	Short:   "Lists the previous names of a user and until when they resolve in the policy",
+	Aliases: []string{"aliases"},
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.ListUserAliasesRequest{Name: args[0]}
+
+		response, err := client.ListUserAliases(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot get user history: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+		}
+
+		if output != "" {
+			SuccessOutput(response.GetAliases(), "", output)
+		}
+
+		tableData := pterm.TableData{{"Old name", "Renamed", "Alias expires"}}
+		for _, alias := range response.GetAliases() {
+			tableData = append(
+				tableData,
+				[]string{
+					alias.GetName(),
+					alias.GetCreatedAt().AsTime().Format("2006-01-02 15:04:05"),
+					alias.GetExpiresAt().AsTime().Format("2006-01-02 15:04:05"),
+				},
+			)
+		}
+		err = pterm.DefaultTable.WithHasHeader().WithData(tableData).Render()
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf("Failed to render pterm table: %s", err),
+				output,
+			)
+		}
+	},
+}
+
+var setUserPasswordCmd = &cobra.Command{
+	Use:   "set-password NAME",
+	Short: "Sets the password of a user for the built-in authentication",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		fromStdin, _ := cmd.Flags().GetBool("password-stdin")
+		remove, _ := cmd.Flags().GetBool("clear")
+
+		var password string
+		switch {
+		case remove:
+		case fromStdin:
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Cannot read password from stdin: %s", err),
+					output,
+				)
+			}
+			password = strings.TrimRight(line, "\r\n")
+		default:
+			prompt := &survey.Password{
+				Message: fmt.Sprintf("Password for user %q:", args[0]),
+			}
+			err := survey.AskOne(prompt, &password, survey.WithValidator(survey.Required))
+			if err != nil {
+				ErrorOutput(
+					err,
+					fmt.Sprintf("Cannot read password: %s", err),
+					output,
+				)
+			}
+		}
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetUserPasswordRequest{
+			Name:     args[0],
+			Password: password,
+		}
+
+		response, err := client.SetUserPassword(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set password: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+		}
+
+		if remove {
+			SuccessOutput(response.GetUser(), "Password removed", output)
+		}
+
+		SuccessOutput(response.GetUser(), "Password set", output)
+	},
+}
+
+var userTOTPCmd = &cobra.Command{
+	Use:   "totp NAME",
+	Short: "Enables TOTP for the built-in authentication of a user, replacing any previous secret",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errMissingParameter
+		}
+
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		output, _ := cmd.Flags().GetString("output")
+		disable, _ := cmd.Flags().GetBool("disable")
+
+		ctx, client, conn, cancel := newHeadscaleCLIWithConfig()
+		defer cancel()
+		defer conn.Close()
+
+		request := &v1.SetUserTOTPRequest{
+			Name:    args[0],
+			Enabled: !disable,
+		}
+
+		response, err := client.SetUserTOTP(ctx, request)
+		if err != nil {
+			ErrorOutput(
+				err,
+				fmt.Sprintf(
+					"Cannot set TOTP: %s",
+					status.Convert(err).Message(),
+				),
+				output,
+			)
+		}
+
+		if disable {
+			SuccessOutput(response.GetUser(), "TOTP disabled", output)
+		}
+
+		SuccessOutput(
+			response,
+			fmt.Sprintf(
+				"TOTP enabled, add the secret to an authenticator app:\n\nSecret: %s\nURI:    %s",
+				response.GetSecret(),
+				response.GetUri(),
+			),
+			output,
+		)
+	},
+}
@@ -3,7 +3,9 @@ package cli
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 
@@ -18,6 +20,11 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+var (
+	errNoServerCertificate  = errors.New("server did not present a certificate")
+	errCertificateNotPinned = errors.New("server certificate does not match the pinned fingerprint")
+)
+
 const (
 	HeadscaleDateTimeFormat = "2006-01-02 15:04:05"
 	SocketWritePermissions  = 0o666
@@ -96,11 +103,20 @@ func newHeadscaleCLIWithConfig() (context.Context, v1.HeadscaleServiceClient, *g
 		}
 		grpcOptions = append(grpcOptions,
 			grpc.WithPerRPCCredentials(tokenAuth{
-				token: apiKey,
+				token:     apiKey,
+				plaintext: cfg.CLI.Plaintext,
 			}),
 		)
 
-		if cfg.CLI.Insecure {
+		if cfg.CLI.Plaintext {
+			grpcOptions = append(grpcOptions,
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			)
+		} else if cfg.CLI.TLSFingerprint != "" {
+			grpcOptions = append(grpcOptions,
+				grpc.WithTransportCredentials(credentials.NewTLS(pinnedTLSConfig(cfg.CLI.TLSFingerprint))),
+			)
+		} else if cfg.CLI.Insecure {
 			tlsConfig := &tls.Config{
 				// turn of gosec as we are intentionally setting
 				// insecure.
@@ -175,7 +191,7 @@ func ErrorOutput(errResult error, override string, outputFormat string) {
 
 func HasMachineOutputFlag() bool {
 	for _, arg := range os.Args {
-		if arg == "json" || arg == "json-line" || arg == "yaml" {
+		if arg == "json" || arg == "json-line" || arg == "yaml" || arg == outputCSV || arg == outputMarkdown {
 			return true
 		}
 	}
@@ -183,8 +199,32 @@ func HasMachineOutputFlag() bool {
 	return false
 }
 
+// pinnedTLSConfig trusts only the certificate with the fingerprint,
+// which can be self signed.
+func pinnedTLSConfig(fingerprint string) *tls.Config {
+	return &tls.Config{
+		// The certificate is verified by its fingerprint instead.
+		//nolint:gosec
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errNoServerCertificate
+			}
+
+			if got := types.CertificateFingerprint(rawCerts[0]); got != fingerprint {
+				return fmt.Errorf("%w: got %s, want %s", errCertificateNotPinned, got, fingerprint)
+			}
+
+			return nil
+		},
+	}
+}
+
 type tokenAuth struct {
 	token string
+
+	// plaintext allows sending the token without TLS.
+	plaintext bool
 }
 
 // Return value is mapped to request headers.
@@ -197,6 +237,6 @@ func (t tokenAuth) GetRequestMetadata(
 	}, nil
 }
 
-func (tokenAuth) RequireTransportSecurity() bool {
-	return true
+func (t tokenAuth) RequireTransportSecurity() bool {
+	return !t.plaintext
 }
@@ -0,0 +1,293 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: headscale/v1/database.proto
+
+package v1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type DatabaseGCRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *DatabaseGCRequest) Reset() {
+	*x = DatabaseGCRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_database_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DatabaseGCRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DatabaseGCRequest) ProtoMessage() {}
+
+func (x *DatabaseGCRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_database_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DatabaseGCRequest.ProtoReflect.Descriptor instead.
+func (*DatabaseGCRequest) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_database_proto_rawDescGZIP(), []int{0}
+}
+
+type DatabaseGCResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	OrphanedRoutes       int64 `protobuf:"varint,1,opt,name=orphaned_routes,json=orphanedRoutes,proto3" json:"orphaned_routes,omitempty"`
+	DeletedRoutes        int64 `protobuf:"varint,2,opt,name=deleted_routes,json=deletedRoutes,proto3" json:"deleted_routes,omitempty"`
+	OrphanedPreAuthTags  int64 `protobuf:"varint,3,opt,name=orphaned_pre_auth_tags,json=orphanedPreAuthTags,proto3" json:"orphaned_pre_auth_tags,omitempty"`
+	EphemeralNodes       int64 `protobuf:"varint,4,opt,name=ephemeral_nodes,json=ephemeralNodes,proto3" json:"ephemeral_nodes,omitempty"`
+	PreAuthKeys          int64 `protobuf:"varint,5,opt,name=pre_auth_keys,json=preAuthKeys,proto3" json:"pre_auth_keys,omitempty"`
+	ApiKeys              int64 `protobuf:"varint,6,opt,name=api_keys,json=apiKeys,proto3" json:"api_keys,omitempty"`
+	NetcheckReports      int64 `protobuf:"varint,7,opt,name=netcheck_reports,json=netcheckReports,proto3" json:"netcheck_reports,omitempty"`
+	UserAliases          int64 `protobuf:"varint,8,opt,name=user_aliases,json=userAliases,proto3" json:"user_aliases,omitempty"`
+	RouteEvents          int64 `protobuf:"varint,9,opt,name=route_events,json=routeEvents,proto3" json:"route_events,omitempty"`
+	PendingRegistrations int64 `protobuf:"varint,10,opt,name=pending_registrations,json=pendingRegistrations,proto3" json:"pending_registrations,omitempty"`
+}
+
+func (x *DatabaseGCResponse) Reset() {
+	*x = DatabaseGCResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_database_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DatabaseGCResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DatabaseGCResponse) ProtoMessage() {}
+
+func (x *DatabaseGCResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_database_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DatabaseGCResponse.ProtoReflect.Descriptor instead.
+func (*DatabaseGCResponse) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_database_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DatabaseGCResponse) GetOrphanedRoutes() int64 {
+	if x != nil {
+		return x.OrphanedRoutes
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetDeletedRoutes() int64 {
+	if x != nil {
+		return x.DeletedRoutes
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetOrphanedPreAuthTags() int64 {
+	if x != nil {
+		return x.OrphanedPreAuthTags
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetEphemeralNodes() int64 {
+	if x != nil {
+		return x.EphemeralNodes
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetPreAuthKeys() int64 {
+	if x != nil {
+		return x.PreAuthKeys
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetApiKeys() int64 {
+	if x != nil {
+		return x.ApiKeys
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetNetcheckReports() int64 {
+	if x != nil {
+		return x.NetcheckReports
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetUserAliases() int64 {
+	if x != nil {
+		return x.UserAliases
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetRouteEvents() int64 {
+	if x != nil {
+		return x.RouteEvents
+	}
+	return 0
+}
+
+func (x *DatabaseGCResponse) GetPendingRegistrations() int64 {
+	if x != nil {
+		return x.PendingRegistrations
+	}
+	return 0
+}
+
+var File_headscale_v1_database_proto protoreflect.FileDescriptor
+
+var file_headscale_v1_database_proto_rawDesc = []byte{
+	0x0a, 0x1b, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2f, 0x76, 0x31, 0x2f, 0x64,
+	0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0c, 0x68,
+	0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2e, 0x76, 0x31, 0x22, 0x13, 0x0a, 0x11, 0x44,
+	0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65, 0x47, 0x43, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x22, 0xa7, 0x03, 0x0a, 0x12, 0x44, 0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65, 0x47, 0x43, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x6f, 0x72, 0x70, 0x68, 0x61,
+	0x6e, 0x65, 0x64, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0e, 0x6f, 0x72, 0x70, 0x68, 0x61, 0x6e, 0x65, 0x64, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x73,
+	0x12, 0x25, 0x0a, 0x0e, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x64, 0x5f, 0x72, 0x6f, 0x75, 0x74,
+	0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x64, 0x65, 0x6c, 0x65, 0x74, 0x65,
+	0x64, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x73, 0x12, 0x33, 0x0a, 0x16, 0x6f, 0x72, 0x70, 0x68, 0x61,
+	0x6e, 0x65, 0x64, 0x5f, 0x70, 0x72, 0x65, 0x5f, 0x61, 0x75, 0x74, 0x68, 0x5f, 0x74, 0x61, 0x67,
+	0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x13, 0x6f, 0x72, 0x70, 0x68, 0x61, 0x6e, 0x65,
+	0x64, 0x50, 0x72, 0x65, 0x41, 0x75, 0x74, 0x68, 0x54, 0x61, 0x67, 0x73, 0x12, 0x27, 0x0a, 0x0f,
+	0x65, 0x70, 0x68, 0x65, 0x6d, 0x65, 0x72, 0x61, 0x6c, 0x5f, 0x6e, 0x6f, 0x64, 0x65, 0x73, 0x18,
+	0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0e, 0x65, 0x70, 0x68, 0x65, 0x6d, 0x65, 0x72, 0x61, 0x6c,
+	0x4e, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x22, 0x0a, 0x0d, 0x70, 0x72, 0x65, 0x5f, 0x61, 0x75, 0x74,
+	0x68, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x70, 0x72,
+	0x65, 0x41, 0x75, 0x74, 0x68, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x61, 0x70, 0x69,
+	0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x61, 0x70, 0x69,
+	0x4b, 0x65, 0x79, 0x73, 0x12, 0x29, 0x0a, 0x10, 0x6e, 0x65, 0x74, 0x63, 0x68, 0x65, 0x63, 0x6b,
+	0x5f, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f,
+	0x6e, 0x65, 0x74, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x12,
+	0x21, 0x0a, 0x0c, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x61, 0x6c, 0x69, 0x61, 0x73, 0x65, 0x73, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x75, 0x73, 0x65, 0x72, 0x41, 0x6c, 0x69, 0x61, 0x73,
+	0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x5f, 0x65, 0x76, 0x65, 0x6e,
+	0x74, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x45,
+	0x76, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x33, 0x0a, 0x15, 0x70, 0x65, 0x6e, 0x64, 0x69, 0x6e, 0x67,
+	0x5f, 0x72, 0x65, 0x67, 0x69, 0x73, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x0a,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x14, 0x70, 0x65, 0x6e, 0x64, 0x69, 0x6e, 0x67, 0x52, 0x65, 0x67,
+	0x69, 0x73, 0x74, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x42, 0x29, 0x5a, 0x27, 0x67, 0x69,
+	0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6a, 0x75, 0x61, 0x6e, 0x66, 0x6f, 0x6e,
+	0x74, 0x2f, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2f, 0x67, 0x65, 0x6e, 0x2f,
+	0x67, 0x6f, 0x2f, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_headscale_v1_database_proto_rawDescOnce sync.Once
+	file_headscale_v1_database_proto_rawDescData = file_headscale_v1_database_proto_rawDesc
+)
+
+func file_headscale_v1_database_proto_rawDescGZIP() []byte {
+	file_headscale_v1_database_proto_rawDescOnce.Do(func() {
+		file_headscale_v1_database_proto_rawDescData = protoimpl.X.CompressGZIP(file_headscale_v1_database_proto_rawDescData)
+	})
+	return file_headscale_v1_database_proto_rawDescData
+}
+
+var file_headscale_v1_database_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_headscale_v1_database_proto_goTypes = []any{
+	(*DatabaseGCRequest)(nil),  // 0: headscale.v1.DatabaseGCRequest
+	(*DatabaseGCResponse)(nil), // 1: headscale.v1.DatabaseGCResponse
+}
+var file_headscale_v1_database_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_headscale_v1_database_proto_init() }
+func file_headscale_v1_database_proto_init() {
+	if File_headscale_v1_database_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_headscale_v1_database_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*DatabaseGCRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_database_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*DatabaseGCResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_headscale_v1_database_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_headscale_v1_database_proto_goTypes,
+		DependencyIndexes: file_headscale_v1_database_proto_depIdxs,
+		MessageInfos:      file_headscale_v1_database_proto_msgTypes,
+	}.Build()
+	File_headscale_v1_database_proto = out.File
+	file_headscale_v1_database_proto_rawDesc = nil
+	file_headscale_v1_database_proto_goTypes = nil
+	file_headscale_v1_database_proto_depIdxs = nil
+}
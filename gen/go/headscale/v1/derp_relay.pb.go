@@ -0,0 +1,629 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: headscale/v1/derp_relay.proto
+
+package v1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// DERPRelay is a standalone DERP server, like headscale-derp, that
+// registered itself to be added to the DERPMap as its own region.
+type DERPRelay struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RegionId   int32                  `protobuf:"varint,1,opt,name=region_id,json=regionId,proto3" json:"region_id,omitempty"`
+	RegionCode string                 `protobuf:"bytes,2,opt,name=region_code,json=regionCode,proto3" json:"region_code,omitempty"`
+	RegionName string                 `protobuf:"bytes,3,opt,name=region_name,json=regionName,proto3" json:"region_name,omitempty"`
+	Hostname   string                 `protobuf:"bytes,4,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	Ipv4       string                 `protobuf:"bytes,5,opt,name=ipv4,proto3" json:"ipv4,omitempty"`
+	Ipv6       string                 `protobuf:"bytes,6,opt,name=ipv6,proto3" json:"ipv6,omitempty"`
+	DerpPort   int32                  `protobuf:"varint,7,opt,name=derp_port,json=derpPort,proto3" json:"derp_port,omitempty"`
+	StunPort   int32                  `protobuf:"varint,8,opt,name=stun_port,json=stunPort,proto3" json:"stun_port,omitempty"`
+	Latitude   float64                `protobuf:"fixed64,9,opt,name=latitude,proto3" json:"latitude,omitempty"`
+	Longitude  float64                `protobuf:"fixed64,10,opt,name=longitude,proto3" json:"longitude,omitempty"`
+	LastSeen   *timestamppb.Timestamp `protobuf:"bytes,11,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	// Online is false once the relay missed its heartbeats for
+	// derp.relays.expiry, it is left out of the DERPMap.
+	Online bool `protobuf:"varint,12,opt,name=online,proto3" json:"online,omitempty"`
+}
+
+func (x *DERPRelay) Reset() {
+	*x = DERPRelay{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DERPRelay) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DERPRelay) ProtoMessage() {}
+
+func (x *DERPRelay) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DERPRelay.ProtoReflect.Descriptor instead.
+func (*DERPRelay) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DERPRelay) GetRegionId() int32 {
+	if x != nil {
+		return x.RegionId
+	}
+	return 0
+}
+
+func (x *DERPRelay) GetRegionCode() string {
+	if x != nil {
+		return x.RegionCode
+	}
+	return ""
+}
+
+func (x *DERPRelay) GetRegionName() string {
+	if x != nil {
+		return x.RegionName
+	}
+	return ""
+}
+
+func (x *DERPRelay) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *DERPRelay) GetIpv4() string {
+	if x != nil {
+		return x.Ipv4
+	}
+	return ""
+}
+
+func (x *DERPRelay) GetIpv6() string {
+	if x != nil {
+		return x.Ipv6
+	}
+	return ""
+}
+
+func (x *DERPRelay) GetDerpPort() int32 {
+	if x != nil {
+		return x.DerpPort
+	}
+	return 0
+}
+
+func (x *DERPRelay) GetStunPort() int32 {
+	if x != nil {
+		return x.StunPort
+	}
+	return 0
+}
+
+func (x *DERPRelay) GetLatitude() float64 {
+	if x != nil {
+		return x.Latitude
+	}
+	return 0
+}
+
+func (x *DERPRelay) GetLongitude() float64 {
+	if x != nil {
+		return x.Longitude
+	}
+	return 0
+}
+
+func (x *DERPRelay) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+func (x *DERPRelay) GetOnline() bool {
+	if x != nil {
+		return x.Online
+	}
+	return false
+}
+
+type RegisterDERPRelayRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Relay *DERPRelay `protobuf:"bytes,1,opt,name=relay,proto3" json:"relay,omitempty"`
+}
+
+func (x *RegisterDERPRelayRequest) Reset() {
+	*x = RegisterDERPRelayRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegisterDERPRelayRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterDERPRelayRequest) ProtoMessage() {}
+
+func (x *RegisterDERPRelayRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterDERPRelayRequest.ProtoReflect.Descriptor instead.
+func (*RegisterDERPRelayRequest) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RegisterDERPRelayRequest) GetRelay() *DERPRelay {
+	if x != nil {
+		return x.Relay
+	}
+	return nil
+}
+
+type RegisterDERPRelayResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Relay *DERPRelay `protobuf:"bytes,1,opt,name=relay,proto3" json:"relay,omitempty"`
+}
+
+func (x *RegisterDERPRelayResponse) Reset() {
+	*x = RegisterDERPRelayResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RegisterDERPRelayResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterDERPRelayResponse) ProtoMessage() {}
+
+func (x *RegisterDERPRelayResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterDERPRelayResponse.ProtoReflect.Descriptor instead.
+func (*RegisterDERPRelayResponse) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RegisterDERPRelayResponse) GetRelay() *DERPRelay {
+	if x != nil {
+		return x.Relay
+	}
+	return nil
+}
+
+type ListDERPRelaysRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *ListDERPRelaysRequest) Reset() {
+	*x = ListDERPRelaysRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDERPRelaysRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDERPRelaysRequest) ProtoMessage() {}
+
+func (x *ListDERPRelaysRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDERPRelaysRequest.ProtoReflect.Descriptor instead.
+func (*ListDERPRelaysRequest) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{3}
+}
+
+type ListDERPRelaysResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Relays []*DERPRelay `protobuf:"bytes,1,rep,name=relays,proto3" json:"relays,omitempty"`
+}
+
+func (x *ListDERPRelaysResponse) Reset() {
+	*x = ListDERPRelaysResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ListDERPRelaysResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDERPRelaysResponse) ProtoMessage() {}
+
+func (x *ListDERPRelaysResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDERPRelaysResponse.ProtoReflect.Descriptor instead.
+func (*ListDERPRelaysResponse) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ListDERPRelaysResponse) GetRelays() []*DERPRelay {
+	if x != nil {
+		return x.Relays
+	}
+	return nil
+}
+
+type DeleteDERPRelayRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RegionId int32 `protobuf:"varint,1,opt,name=region_id,json=regionId,proto3" json:"region_id,omitempty"`
+}
+
+func (x *DeleteDERPRelayRequest) Reset() {
+	*x = DeleteDERPRelayRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeleteDERPRelayRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDERPRelayRequest) ProtoMessage() {}
+
+func (x *DeleteDERPRelayRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDERPRelayRequest.ProtoReflect.Descriptor instead.
+func (*DeleteDERPRelayRequest) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteDERPRelayRequest) GetRegionId() int32 {
+	if x != nil {
+		return x.RegionId
+	}
+	return 0
+}
+
+type DeleteDERPRelayResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *DeleteDERPRelayResponse) Reset() {
+	*x = DeleteDERPRelayResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_headscale_v1_derp_relay_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeleteDERPRelayResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteDERPRelayResponse) ProtoMessage() {}
+
+func (x *DeleteDERPRelayResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_headscale_v1_derp_relay_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteDERPRelayResponse.ProtoReflect.Descriptor instead.
+func (*DeleteDERPRelayResponse) Descriptor() ([]byte, []int) {
+	return file_headscale_v1_derp_relay_proto_rawDescGZIP(), []int{6}
+}
+
+var File_headscale_v1_derp_relay_proto protoreflect.FileDescriptor
+
+var file_headscale_v1_derp_relay_proto_rawDesc = []byte{
+	0x0a, 0x1d, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2f, 0x76, 0x31, 0x2f, 0x64,
+	0x65, 0x72, 0x70, 0x5f, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x0c, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xf3,
+	0x02, 0x0a, 0x09, 0x44, 0x45, 0x52, 0x50, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x12, 0x1b, 0x0a, 0x09,
+	0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x08, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x65, 0x67,
+	0x69, 0x6f, 0x6e, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a,
+	0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0a, 0x72, 0x65, 0x67, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x68,
+	0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x68,
+	0x6f, 0x73, 0x74, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x69, 0x70, 0x76, 0x34, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x69, 0x70, 0x76, 0x34, 0x12, 0x12, 0x0a, 0x04, 0x69,
+	0x70, 0x76, 0x36, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x69, 0x70, 0x76, 0x36, 0x12,
+	0x1b, 0x0a, 0x09, 0x64, 0x65, 0x72, 0x70, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x08, 0x64, 0x65, 0x72, 0x70, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1b, 0x0a, 0x09,
+	0x73, 0x74, 0x75, 0x6e, 0x5f, 0x70, 0x6f, 0x72, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x08, 0x73, 0x74, 0x75, 0x6e, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x61, 0x74,
+	0x69, 0x74, 0x75, 0x64, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x01, 0x52, 0x08, 0x6c, 0x61, 0x74,
+	0x69, 0x74, 0x75, 0x64, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x6c, 0x6f, 0x6e, 0x67, 0x69, 0x74, 0x75,
+	0x64, 0x65, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x01, 0x52, 0x09, 0x6c, 0x6f, 0x6e, 0x67, 0x69, 0x74,
+	0x75, 0x64, 0x65, 0x12, 0x37, 0x0a, 0x09, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x73, 0x65, 0x65, 0x6e,
+	0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x52, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x53, 0x65, 0x65, 0x6e, 0x12, 0x16, 0x0a, 0x06,
+	0x6f, 0x6e, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x6f, 0x6e,
+	0x6c, 0x69, 0x6e, 0x65, 0x22, 0x49, 0x0a, 0x18, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72,
+	0x44, 0x45, 0x52, 0x50, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x12, 0x2d, 0x0a, 0x05, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x17, 0x2e, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x44,
+	0x45, 0x52, 0x50, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x52, 0x05, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x22,
+	0x4a, 0x0a, 0x19, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x44, 0x45, 0x52, 0x50, 0x52,
+	0x65, 0x6c, 0x61, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2d, 0x0a, 0x05,
+	0x72, 0x65, 0x6c, 0x61, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x68, 0x65,
+	0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x45, 0x52, 0x50, 0x52,
+	0x65, 0x6c, 0x61, 0x79, 0x52, 0x05, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x22, 0x17, 0x0a, 0x15, 0x4c,
+	0x69, 0x73, 0x74, 0x44, 0x45, 0x52, 0x50, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x73, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x22, 0x49, 0x0a, 0x16, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x45, 0x52, 0x50,
+	0x52, 0x65, 0x6c, 0x61, 0x79, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2f,
+	0x0a, 0x06, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x17,
+	0x2e, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61, 0x6c, 0x65, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x45,
+	0x52, 0x50, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x52, 0x06, 0x72, 0x65, 0x6c, 0x61, 0x79, 0x73, 0x22,
+	0x35, 0x0a, 0x16, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x44, 0x45, 0x52, 0x50, 0x52, 0x65, 0x6c,
+	0x61, 0x79, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x72, 0x65, 0x67,
+	0x69, 0x6f, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x72, 0x65,
+	0x67, 0x69, 0x6f, 0x6e, 0x49, 0x64, 0x22, 0x19, 0x0a, 0x17, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65,
+	0x44, 0x45, 0x52, 0x50, 0x52, 0x65, 0x6c, 0x61, 0x79, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x42, 0x29, 0x5a, 0x27, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x6a, 0x75, 0x61, 0x6e, 0x66, 0x6f, 0x6e, 0x74, 0x2f, 0x68, 0x65, 0x61, 0x64, 0x73, 0x63, 0x61,
+	0x6c, 0x65, 0x2f, 0x67, 0x65, 0x6e, 0x2f, 0x67, 0x6f, 0x2f, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_headscale_v1_derp_relay_proto_rawDescOnce sync.Once
+	file_headscale_v1_derp_relay_proto_rawDescData = file_headscale_v1_derp_relay_proto_rawDesc
+)
+
+func file_headscale_v1_derp_relay_proto_rawDescGZIP() []byte {
+	file_headscale_v1_derp_relay_proto_rawDescOnce.Do(func() {
+		file_headscale_v1_derp_relay_proto_rawDescData = protoimpl.X.CompressGZIP(file_headscale_v1_derp_relay_proto_rawDescData)
+	})
+	return file_headscale_v1_derp_relay_proto_rawDescData
+}
+
+var file_headscale_v1_derp_relay_proto_msgTypes = make([]protoimpl.MessageInfo, 7)
+var file_headscale_v1_derp_relay_proto_goTypes = []any{
+	(*DERPRelay)(nil),                 // 0: headscale.v1.DERPRelay
+	(*RegisterDERPRelayRequest)(nil),  // 1: headscale.v1.RegisterDERPRelayRequest
+	(*RegisterDERPRelayResponse)(nil), // 2: headscale.v1.RegisterDERPRelayResponse
+	(*ListDERPRelaysRequest)(nil),     // 3: headscale.v1.ListDERPRelaysRequest
+	(*ListDERPRelaysResponse)(nil),    // 4: headscale.v1.ListDERPRelaysResponse
+	(*DeleteDERPRelayRequest)(nil),    // 5: headscale.v1.DeleteDERPRelayRequest
+	(*DeleteDERPRelayResponse)(nil),   // 6: headscale.v1.DeleteDERPRelayResponse
+	(*timestamppb.Timestamp)(nil),     // 7: google.protobuf.Timestamp
+}
+var file_headscale_v1_derp_relay_proto_depIdxs = []int32{
+	7, // 0: headscale.v1.DERPRelay.last_seen:type_name -> google.protobuf.Timestamp
+	0, // 1: headscale.v1.RegisterDERPRelayRequest.relay:type_name -> headscale.v1.DERPRelay
+	0, // 2: headscale.v1.RegisterDERPRelayResponse.relay:type_name -> headscale.v1.DERPRelay
+	0, // 3: headscale.v1.ListDERPRelaysResponse.relays:type_name -> headscale.v1.DERPRelay
+	4, // [4:4] is the sub-list for method output_type
+	4, // [4:4] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_headscale_v1_derp_relay_proto_init() }
+func file_headscale_v1_derp_relay_proto_init() {
+	if File_headscale_v1_derp_relay_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_headscale_v1_derp_relay_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*DERPRelay); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_derp_relay_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*RegisterDERPRelayRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_derp_relay_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*RegisterDERPRelayResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_derp_relay_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*ListDERPRelaysRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_derp_relay_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*ListDERPRelaysResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_derp_relay_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*DeleteDERPRelayRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_headscale_v1_derp_relay_proto_msgTypes[6].Exporter = func(v any, i int) any {
+			switch v := v.(*DeleteDERPRelayResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_headscale_v1_derp_relay_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   7,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_headscale_v1_derp_relay_proto_goTypes,
+		DependencyIndexes: file_headscale_v1_derp_relay_proto_depIdxs,
+		MessageInfos:      file_headscale_v1_derp_relay_proto_msgTypes,
+	}.Build()
+	File_headscale_v1_derp_relay_proto = out.File
+	file_headscale_v1_derp_relay_proto_rawDesc = nil
+	file_headscale_v1_derp_relay_proto_goTypes = nil
+	file_headscale_v1_derp_relay_proto_depIdxs = nil
+}
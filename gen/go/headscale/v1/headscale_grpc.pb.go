@@ -19,35 +19,79 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	HeadscaleService_GetUser_FullMethodName          = "/headscale.v1.HeadscaleService/GetUser"
-	HeadscaleService_CreateUser_FullMethodName       = "/headscale.v1.HeadscaleService/CreateUser"
-	HeadscaleService_RenameUser_FullMethodName       = "/headscale.v1.HeadscaleService/RenameUser"
-	HeadscaleService_DeleteUser_FullMethodName       = "/headscale.v1.HeadscaleService/DeleteUser"
-	HeadscaleService_ListUsers_FullMethodName        = "/headscale.v1.HeadscaleService/ListUsers"
-	HeadscaleService_CreatePreAuthKey_FullMethodName = "/headscale.v1.HeadscaleService/CreatePreAuthKey"
-	HeadscaleService_ExpirePreAuthKey_FullMethodName = "/headscale.v1.HeadscaleService/ExpirePreAuthKey"
-	HeadscaleService_ListPreAuthKeys_FullMethodName  = "/headscale.v1.HeadscaleService/ListPreAuthKeys"
-	HeadscaleService_DebugCreateNode_FullMethodName  = "/headscale.v1.HeadscaleService/DebugCreateNode"
-	HeadscaleService_GetNode_FullMethodName          = "/headscale.v1.HeadscaleService/GetNode"
-	HeadscaleService_SetTags_FullMethodName          = "/headscale.v1.HeadscaleService/SetTags"
-	HeadscaleService_RegisterNode_FullMethodName     = "/headscale.v1.HeadscaleService/RegisterNode"
-	HeadscaleService_DeleteNode_FullMethodName       = "/headscale.v1.HeadscaleService/DeleteNode"
-	HeadscaleService_ExpireNode_FullMethodName       = "/headscale.v1.HeadscaleService/ExpireNode"
-	HeadscaleService_RenameNode_FullMethodName       = "/headscale.v1.HeadscaleService/RenameNode"
-	HeadscaleService_ListNodes_FullMethodName        = "/headscale.v1.HeadscaleService/ListNodes"
-	HeadscaleService_MoveNode_FullMethodName         = "/headscale.v1.HeadscaleService/MoveNode"
-	HeadscaleService_BackfillNodeIPs_FullMethodName  = "/headscale.v1.HeadscaleService/BackfillNodeIPs"
-	HeadscaleService_GetRoutes_FullMethodName        = "/headscale.v1.HeadscaleService/GetRoutes"
-	HeadscaleService_EnableRoute_FullMethodName      = "/headscale.v1.HeadscaleService/EnableRoute"
-	HeadscaleService_DisableRoute_FullMethodName     = "/headscale.v1.HeadscaleService/DisableRoute"
-	HeadscaleService_GetNodeRoutes_FullMethodName    = "/headscale.v1.HeadscaleService/GetNodeRoutes"
-	HeadscaleService_DeleteRoute_FullMethodName      = "/headscale.v1.HeadscaleService/DeleteRoute"
-	HeadscaleService_CreateApiKey_FullMethodName     = "/headscale.v1.HeadscaleService/CreateApiKey"
-	HeadscaleService_ExpireApiKey_FullMethodName     = "/headscale.v1.HeadscaleService/ExpireApiKey"
-	HeadscaleService_ListApiKeys_FullMethodName      = "/headscale.v1.HeadscaleService/ListApiKeys"
-	HeadscaleService_DeleteApiKey_FullMethodName     = "/headscale.v1.HeadscaleService/DeleteApiKey"
-	HeadscaleService_GetPolicy_FullMethodName        = "/headscale.v1.HeadscaleService/GetPolicy"
-	HeadscaleService_SetPolicy_FullMethodName        = "/headscale.v1.HeadscaleService/SetPolicy"
+	HeadscaleService_GetUser_FullMethodName                  = "/headscale.v1.HeadscaleService/GetUser"
+	HeadscaleService_CreateUser_FullMethodName               = "/headscale.v1.HeadscaleService/CreateUser"
+	HeadscaleService_RenameUser_FullMethodName               = "/headscale.v1.HeadscaleService/RenameUser"
+	HeadscaleService_DeleteUser_FullMethodName               = "/headscale.v1.HeadscaleService/DeleteUser"
+	HeadscaleService_ListUsers_FullMethodName                = "/headscale.v1.HeadscaleService/ListUsers"
+	HeadscaleService_SuspendUser_FullMethodName              = "/headscale.v1.HeadscaleService/SuspendUser"
+	HeadscaleService_ResumeUser_FullMethodName               = "/headscale.v1.HeadscaleService/ResumeUser"
+	HeadscaleService_ListUserAliases_FullMethodName          = "/headscale.v1.HeadscaleService/ListUserAliases"
+	HeadscaleService_SetUserPassword_FullMethodName          = "/headscale.v1.HeadscaleService/SetUserPassword"
+	HeadscaleService_SetUserTOTP_FullMethodName              = "/headscale.v1.HeadscaleService/SetUserTOTP"
+	HeadscaleService_CreatePreAuthKey_FullMethodName         = "/headscale.v1.HeadscaleService/CreatePreAuthKey"
+	HeadscaleService_ExpirePreAuthKey_FullMethodName         = "/headscale.v1.HeadscaleService/ExpirePreAuthKey"
+	HeadscaleService_ListPreAuthKeys_FullMethodName          = "/headscale.v1.HeadscaleService/ListPreAuthKeys"
+	HeadscaleService_DebugCreateNode_FullMethodName          = "/headscale.v1.HeadscaleService/DebugCreateNode"
+	HeadscaleService_DebugConnectivityMatrix_FullMethodName  = "/headscale.v1.HeadscaleService/DebugConnectivityMatrix"
+	HeadscaleService_DebugProfile_FullMethodName             = "/headscale.v1.HeadscaleService/DebugProfile"
+	HeadscaleService_GetNode_FullMethodName                  = "/headscale.v1.HeadscaleService/GetNode"
+	HeadscaleService_SetTags_FullMethodName                  = "/headscale.v1.HeadscaleService/SetTags"
+	HeadscaleService_AddTag_FullMethodName                   = "/headscale.v1.HeadscaleService/AddTag"
+	HeadscaleService_RemoveTag_FullMethodName                = "/headscale.v1.HeadscaleService/RemoveTag"
+	HeadscaleService_RegisterNode_FullMethodName             = "/headscale.v1.HeadscaleService/RegisterNode"
+	HeadscaleService_DeleteNode_FullMethodName               = "/headscale.v1.HeadscaleService/DeleteNode"
+	HeadscaleService_ExpireNode_FullMethodName               = "/headscale.v1.HeadscaleService/ExpireNode"
+	HeadscaleService_RenameNode_FullMethodName               = "/headscale.v1.HeadscaleService/RenameNode"
+	HeadscaleService_RotateNodeKey_FullMethodName            = "/headscale.v1.HeadscaleService/RotateNodeKey"
+	HeadscaleService_RevokeNode_FullMethodName               = "/headscale.v1.HeadscaleService/RevokeNode"
+	HeadscaleService_SetNodeNote_FullMethodName              = "/headscale.v1.HeadscaleService/SetNodeNote"
+	HeadscaleService_SetNodeDERPHome_FullMethodName          = "/headscale.v1.HeadscaleService/SetNodeDERPHome"
+	HeadscaleService_SetNodeService_FullMethodName           = "/headscale.v1.HeadscaleService/SetNodeService"
+	HeadscaleService_ListNodes_FullMethodName                = "/headscale.v1.HeadscaleService/ListNodes"
+	HeadscaleService_MoveNode_FullMethodName                 = "/headscale.v1.HeadscaleService/MoveNode"
+	HeadscaleService_BackfillNodeIPs_FullMethodName          = "/headscale.v1.HeadscaleService/BackfillNodeIPs"
+	HeadscaleService_ListNodeStats_FullMethodName            = "/headscale.v1.HeadscaleService/ListNodeStats"
+	HeadscaleService_GetNodeNetcheck_FullMethodName          = "/headscale.v1.HeadscaleService/GetNodeNetcheck"
+	HeadscaleService_NodeC2N_FullMethodName                  = "/headscale.v1.HeadscaleService/NodeC2N"
+	HeadscaleService_PreviewNodeFQDN_FullMethodName          = "/headscale.v1.HeadscaleService/PreviewNodeFQDN"
+	HeadscaleService_GetRoutes_FullMethodName                = "/headscale.v1.HeadscaleService/GetRoutes"
+	HeadscaleService_EnableRoute_FullMethodName              = "/headscale.v1.HeadscaleService/EnableRoute"
+	HeadscaleService_DisableRoute_FullMethodName             = "/headscale.v1.HeadscaleService/DisableRoute"
+	HeadscaleService_GetNodeRoutes_FullMethodName            = "/headscale.v1.HeadscaleService/GetNodeRoutes"
+	HeadscaleService_DeleteRoute_FullMethodName              = "/headscale.v1.HeadscaleService/DeleteRoute"
+	HeadscaleService_GetEffectiveRoutes_FullMethodName       = "/headscale.v1.HeadscaleService/GetEffectiveRoutes"
+	HeadscaleService_EnablePrefixRoutes_FullMethodName       = "/headscale.v1.HeadscaleService/EnablePrefixRoutes"
+	HeadscaleService_DisablePrefixRoutes_FullMethodName      = "/headscale.v1.HeadscaleService/DisablePrefixRoutes"
+	HeadscaleService_GetRouteHistory_FullMethodName          = "/headscale.v1.HeadscaleService/GetRouteHistory"
+	HeadscaleService_CreateApiKey_FullMethodName             = "/headscale.v1.HeadscaleService/CreateApiKey"
+	HeadscaleService_ExpireApiKey_FullMethodName             = "/headscale.v1.HeadscaleService/ExpireApiKey"
+	HeadscaleService_ListApiKeys_FullMethodName              = "/headscale.v1.HeadscaleService/ListApiKeys"
+	HeadscaleService_DeleteApiKey_FullMethodName             = "/headscale.v1.HeadscaleService/DeleteApiKey"
+	HeadscaleService_GetPolicy_FullMethodName                = "/headscale.v1.HeadscaleService/GetPolicy"
+	HeadscaleService_SetPolicy_FullMethodName                = "/headscale.v1.HeadscaleService/SetPolicy"
+	HeadscaleService_AddPolicyGroupMembers_FullMethodName    = "/headscale.v1.HeadscaleService/AddPolicyGroupMembers"
+	HeadscaleService_RemovePolicyGroupMembers_FullMethodName = "/headscale.v1.HeadscaleService/RemovePolicyGroupMembers"
+	HeadscaleService_SetPolicyHost_FullMethodName            = "/headscale.v1.HeadscaleService/SetPolicyHost"
+	HeadscaleService_DeletePolicyHost_FullMethodName         = "/headscale.v1.HeadscaleService/DeletePolicyHost"
+	HeadscaleService_GetPolicyStats_FullMethodName           = "/headscale.v1.HeadscaleService/GetPolicyStats"
+	HeadscaleService_DiffPolicy_FullMethodName               = "/headscale.v1.HeadscaleService/DiffPolicy"
+	HeadscaleService_StartPolicyRollout_FullMethodName       = "/headscale.v1.HeadscaleService/StartPolicyRollout"
+	HeadscaleService_GetPolicyRollout_FullMethodName         = "/headscale.v1.HeadscaleService/GetPolicyRollout"
+	HeadscaleService_PromotePolicyRollout_FullMethodName     = "/headscale.v1.HeadscaleService/PromotePolicyRollout"
+	HeadscaleService_RollbackPolicyRollout_FullMethodName    = "/headscale.v1.HeadscaleService/RollbackPolicyRollout"
+	HeadscaleService_DatabaseGC_FullMethodName               = "/headscale.v1.HeadscaleService/DatabaseGC"
+	HeadscaleService_GetLogLevels_FullMethodName             = "/headscale.v1.HeadscaleService/GetLogLevels"
+	HeadscaleService_SetLogLevel_FullMethodName              = "/headscale.v1.HeadscaleService/SetLogLevel"
+	HeadscaleService_Search_FullMethodName                   = "/headscale.v1.HeadscaleService/Search"
+	HeadscaleService_ListDERPClients_FullMethodName          = "/headscale.v1.HeadscaleService/ListDERPClients"
+	HeadscaleService_RegisterDERPRelay_FullMethodName        = "/headscale.v1.HeadscaleService/RegisterDERPRelay"
+	HeadscaleService_ListDERPRelays_FullMethodName           = "/headscale.v1.HeadscaleService/ListDERPRelays"
+	HeadscaleService_DeleteDERPRelay_FullMethodName          = "/headscale.v1.HeadscaleService/DeleteDERPRelay"
+	HeadscaleService_CreateServiceAccount_FullMethodName     = "/headscale.v1.HeadscaleService/CreateServiceAccount"
+	HeadscaleService_ListServiceAccounts_FullMethodName      = "/headscale.v1.HeadscaleService/ListServiceAccounts"
+	HeadscaleService_DeleteServiceAccount_FullMethodName     = "/headscale.v1.HeadscaleService/DeleteServiceAccount"
+	HeadscaleService_ImportTailnet_FullMethodName            = "/headscale.v1.HeadscaleService/ImportTailnet"
 )
 
 // HeadscaleServiceClient is the client API for HeadscaleService service.
@@ -60,27 +104,49 @@ type HeadscaleServiceClient interface {
 	RenameUser(ctx context.Context, in *RenameUserRequest, opts ...grpc.CallOption) (*RenameUserResponse, error)
 	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*DeleteUserResponse, error)
 	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	SuspendUser(ctx context.Context, in *SuspendUserRequest, opts ...grpc.CallOption) (*SuspendUserResponse, error)
+	ResumeUser(ctx context.Context, in *ResumeUserRequest, opts ...grpc.CallOption) (*ResumeUserResponse, error)
+	ListUserAliases(ctx context.Context, in *ListUserAliasesRequest, opts ...grpc.CallOption) (*ListUserAliasesResponse, error)
+	SetUserPassword(ctx context.Context, in *SetUserPasswordRequest, opts ...grpc.CallOption) (*SetUserPasswordResponse, error)
+	SetUserTOTP(ctx context.Context, in *SetUserTOTPRequest, opts ...grpc.CallOption) (*SetUserTOTPResponse, error)
 	// --- PreAuthKeys start ---
 	CreatePreAuthKey(ctx context.Context, in *CreatePreAuthKeyRequest, opts ...grpc.CallOption) (*CreatePreAuthKeyResponse, error)
 	ExpirePreAuthKey(ctx context.Context, in *ExpirePreAuthKeyRequest, opts ...grpc.CallOption) (*ExpirePreAuthKeyResponse, error)
 	ListPreAuthKeys(ctx context.Context, in *ListPreAuthKeysRequest, opts ...grpc.CallOption) (*ListPreAuthKeysResponse, error)
 	// --- Node start ---
 	DebugCreateNode(ctx context.Context, in *DebugCreateNodeRequest, opts ...grpc.CallOption) (*DebugCreateNodeResponse, error)
+	DebugConnectivityMatrix(ctx context.Context, in *DebugConnectivityMatrixRequest, opts ...grpc.CallOption) (*DebugConnectivityMatrixResponse, error)
+	DebugProfile(ctx context.Context, in *DebugProfileRequest, opts ...grpc.CallOption) (*DebugProfileResponse, error)
 	GetNode(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*GetNodeResponse, error)
 	SetTags(ctx context.Context, in *SetTagsRequest, opts ...grpc.CallOption) (*SetTagsResponse, error)
+	AddTag(ctx context.Context, in *AddTagRequest, opts ...grpc.CallOption) (*AddTagResponse, error)
+	RemoveTag(ctx context.Context, in *RemoveTagRequest, opts ...grpc.CallOption) (*RemoveTagResponse, error)
 	RegisterNode(ctx context.Context, in *RegisterNodeRequest, opts ...grpc.CallOption) (*RegisterNodeResponse, error)
 	DeleteNode(ctx context.Context, in *DeleteNodeRequest, opts ...grpc.CallOption) (*DeleteNodeResponse, error)
 	ExpireNode(ctx context.Context, in *ExpireNodeRequest, opts ...grpc.CallOption) (*ExpireNodeResponse, error)
 	RenameNode(ctx context.Context, in *RenameNodeRequest, opts ...grpc.CallOption) (*RenameNodeResponse, error)
+	RotateNodeKey(ctx context.Context, in *RotateNodeKeyRequest, opts ...grpc.CallOption) (*RotateNodeKeyResponse, error)
+	RevokeNode(ctx context.Context, in *RevokeNodeRequest, opts ...grpc.CallOption) (*RevokeNodeResponse, error)
+	SetNodeNote(ctx context.Context, in *SetNodeNoteRequest, opts ...grpc.CallOption) (*SetNodeNoteResponse, error)
+	SetNodeDERPHome(ctx context.Context, in *SetNodeDERPHomeRequest, opts ...grpc.CallOption) (*SetNodeDERPHomeResponse, error)
+	SetNodeService(ctx context.Context, in *SetNodeServiceRequest, opts ...grpc.CallOption) (*SetNodeServiceResponse, error)
 	ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error)
 	MoveNode(ctx context.Context, in *MoveNodeRequest, opts ...grpc.CallOption) (*MoveNodeResponse, error)
 	BackfillNodeIPs(ctx context.Context, in *BackfillNodeIPsRequest, opts ...grpc.CallOption) (*BackfillNodeIPsResponse, error)
+	ListNodeStats(ctx context.Context, in *ListNodeStatsRequest, opts ...grpc.CallOption) (*ListNodeStatsResponse, error)
+	GetNodeNetcheck(ctx context.Context, in *GetNodeNetcheckRequest, opts ...grpc.CallOption) (*GetNodeNetcheckResponse, error)
+	NodeC2N(ctx context.Context, in *NodeC2NRequest, opts ...grpc.CallOption) (*NodeC2NResponse, error)
+	PreviewNodeFQDN(ctx context.Context, in *PreviewNodeFQDNRequest, opts ...grpc.CallOption) (*PreviewNodeFQDNResponse, error)
 	// --- Route start ---
 	GetRoutes(ctx context.Context, in *GetRoutesRequest, opts ...grpc.CallOption) (*GetRoutesResponse, error)
 	EnableRoute(ctx context.Context, in *EnableRouteRequest, opts ...grpc.CallOption) (*EnableRouteResponse, error)
 	DisableRoute(ctx context.Context, in *DisableRouteRequest, opts ...grpc.CallOption) (*DisableRouteResponse, error)
 	GetNodeRoutes(ctx context.Context, in *GetNodeRoutesRequest, opts ...grpc.CallOption) (*GetNodeRoutesResponse, error)
 	DeleteRoute(ctx context.Context, in *DeleteRouteRequest, opts ...grpc.CallOption) (*DeleteRouteResponse, error)
+	GetEffectiveRoutes(ctx context.Context, in *GetEffectiveRoutesRequest, opts ...grpc.CallOption) (*GetEffectiveRoutesResponse, error)
+	EnablePrefixRoutes(ctx context.Context, in *EnablePrefixRoutesRequest, opts ...grpc.CallOption) (*EnablePrefixRoutesResponse, error)
+	DisablePrefixRoutes(ctx context.Context, in *DisablePrefixRoutesRequest, opts ...grpc.CallOption) (*DisablePrefixRoutesResponse, error)
+	GetRouteHistory(ctx context.Context, in *GetRouteHistoryRequest, opts ...grpc.CallOption) (*GetRouteHistoryResponse, error)
 	// --- ApiKeys start ---
 	CreateApiKey(ctx context.Context, in *CreateApiKeyRequest, opts ...grpc.CallOption) (*CreateApiKeyResponse, error)
 	ExpireApiKey(ctx context.Context, in *ExpireApiKeyRequest, opts ...grpc.CallOption) (*ExpireApiKeyResponse, error)
@@ -89,6 +155,34 @@ type HeadscaleServiceClient interface {
 	// --- Policy start ---
 	GetPolicy(ctx context.Context, in *GetPolicyRequest, opts ...grpc.CallOption) (*GetPolicyResponse, error)
 	SetPolicy(ctx context.Context, in *SetPolicyRequest, opts ...grpc.CallOption) (*SetPolicyResponse, error)
+	AddPolicyGroupMembers(ctx context.Context, in *AddPolicyGroupMembersRequest, opts ...grpc.CallOption) (*AddPolicyGroupMembersResponse, error)
+	RemovePolicyGroupMembers(ctx context.Context, in *RemovePolicyGroupMembersRequest, opts ...grpc.CallOption) (*RemovePolicyGroupMembersResponse, error)
+	SetPolicyHost(ctx context.Context, in *SetPolicyHostRequest, opts ...grpc.CallOption) (*SetPolicyHostResponse, error)
+	DeletePolicyHost(ctx context.Context, in *DeletePolicyHostRequest, opts ...grpc.CallOption) (*DeletePolicyHostResponse, error)
+	GetPolicyStats(ctx context.Context, in *GetPolicyStatsRequest, opts ...grpc.CallOption) (*GetPolicyStatsResponse, error)
+	DiffPolicy(ctx context.Context, in *DiffPolicyRequest, opts ...grpc.CallOption) (*DiffPolicyResponse, error)
+	StartPolicyRollout(ctx context.Context, in *StartPolicyRolloutRequest, opts ...grpc.CallOption) (*StartPolicyRolloutResponse, error)
+	GetPolicyRollout(ctx context.Context, in *GetPolicyRolloutRequest, opts ...grpc.CallOption) (*GetPolicyRolloutResponse, error)
+	PromotePolicyRollout(ctx context.Context, in *PromotePolicyRolloutRequest, opts ...grpc.CallOption) (*PromotePolicyRolloutResponse, error)
+	RollbackPolicyRollout(ctx context.Context, in *RollbackPolicyRolloutRequest, opts ...grpc.CallOption) (*RollbackPolicyRolloutResponse, error)
+	// --- Database start ---
+	DatabaseGC(ctx context.Context, in *DatabaseGCRequest, opts ...grpc.CallOption) (*DatabaseGCResponse, error)
+	// --- Log start ---
+	GetLogLevels(ctx context.Context, in *GetLogLevelsRequest, opts ...grpc.CallOption) (*GetLogLevelsResponse, error)
+	SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*SetLogLevelResponse, error)
+	// --- Search start ---
+	Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error)
+	// --- DERP start ---
+	ListDERPClients(ctx context.Context, in *ListDERPClientsRequest, opts ...grpc.CallOption) (*ListDERPClientsResponse, error)
+	RegisterDERPRelay(ctx context.Context, in *RegisterDERPRelayRequest, opts ...grpc.CallOption) (*RegisterDERPRelayResponse, error)
+	ListDERPRelays(ctx context.Context, in *ListDERPRelaysRequest, opts ...grpc.CallOption) (*ListDERPRelaysResponse, error)
+	DeleteDERPRelay(ctx context.Context, in *DeleteDERPRelayRequest, opts ...grpc.CallOption) (*DeleteDERPRelayResponse, error)
+	// --- ServiceAccount start ---
+	CreateServiceAccount(ctx context.Context, in *CreateServiceAccountRequest, opts ...grpc.CallOption) (*CreateServiceAccountResponse, error)
+	ListServiceAccounts(ctx context.Context, in *ListServiceAccountsRequest, opts ...grpc.CallOption) (*ListServiceAccountsResponse, error)
+	DeleteServiceAccount(ctx context.Context, in *DeleteServiceAccountRequest, opts ...grpc.CallOption) (*DeleteServiceAccountResponse, error)
+	// --- Import start ---
+	ImportTailnet(ctx context.Context, in *ImportTailnetRequest, opts ...grpc.CallOption) (*ImportTailnetResponse, error)
 }
 
 type headscaleServiceClient struct {
@@ -144,6 +238,51 @@ func (c *headscaleServiceClient) ListUsers(ctx context.Context, in *ListUsersReq
 	return out, nil
 }
 
+func (c *headscaleServiceClient) SuspendUser(ctx context.Context, in *SuspendUserRequest, opts ...grpc.CallOption) (*SuspendUserResponse, error) {
+	out := new(SuspendUserResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SuspendUser_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) ResumeUser(ctx context.Context, in *ResumeUserRequest, opts ...grpc.CallOption) (*ResumeUserResponse, error) {
+	out := new(ResumeUserResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ResumeUser_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) ListUserAliases(ctx context.Context, in *ListUserAliasesRequest, opts ...grpc.CallOption) (*ListUserAliasesResponse, error) {
+	out := new(ListUserAliasesResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ListUserAliases_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) SetUserPassword(ctx context.Context, in *SetUserPasswordRequest, opts ...grpc.CallOption) (*SetUserPasswordResponse, error) {
+	out := new(SetUserPasswordResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetUserPassword_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) SetUserTOTP(ctx context.Context, in *SetUserTOTPRequest, opts ...grpc.CallOption) (*SetUserTOTPResponse, error) {
+	out := new(SetUserTOTPResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetUserTOTP_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *headscaleServiceClient) CreatePreAuthKey(ctx context.Context, in *CreatePreAuthKeyRequest, opts ...grpc.CallOption) (*CreatePreAuthKeyResponse, error) {
 	out := new(CreatePreAuthKeyResponse)
 	err := c.cc.Invoke(ctx, HeadscaleService_CreatePreAuthKey_FullMethodName, in, out, opts...)
@@ -180,6 +319,24 @@ func (c *headscaleServiceClient) DebugCreateNode(ctx context.Context, in *DebugC
 	return out, nil
 }
 
+func (c *headscaleServiceClient) DebugConnectivityMatrix(ctx context.Context, in *DebugConnectivityMatrixRequest, opts ...grpc.CallOption) (*DebugConnectivityMatrixResponse, error) {
+	out := new(DebugConnectivityMatrixResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DebugConnectivityMatrix_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) DebugProfile(ctx context.Context, in *DebugProfileRequest, opts ...grpc.CallOption) (*DebugProfileResponse, error) {
+	out := new(DebugProfileResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DebugProfile_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *headscaleServiceClient) GetNode(ctx context.Context, in *GetNodeRequest, opts ...grpc.CallOption) (*GetNodeResponse, error) {
 	out := new(GetNodeResponse)
 	err := c.cc.Invoke(ctx, HeadscaleService_GetNode_FullMethodName, in, out, opts...)
@@ -198,6 +355,24 @@ func (c *headscaleServiceClient) SetTags(ctx context.Context, in *SetTagsRequest
 	return out, nil
 }
 
+func (c *headscaleServiceClient) AddTag(ctx context.Context, in *AddTagRequest, opts ...grpc.CallOption) (*AddTagResponse, error) {
+	out := new(AddTagResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_AddTag_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) RemoveTag(ctx context.Context, in *RemoveTagRequest, opts ...grpc.CallOption) (*RemoveTagResponse, error) {
+	out := new(RemoveTagResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_RemoveTag_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *headscaleServiceClient) RegisterNode(ctx context.Context, in *RegisterNodeRequest, opts ...grpc.CallOption) (*RegisterNodeResponse, error) {
 	out := new(RegisterNodeResponse)
 	err := c.cc.Invoke(ctx, HeadscaleService_RegisterNode_FullMethodName, in, out, opts...)
@@ -234,6 +409,51 @@ func (c *headscaleServiceClient) RenameNode(ctx context.Context, in *RenameNodeR
 	return out, nil
 }
 
+func (c *headscaleServiceClient) RotateNodeKey(ctx context.Context, in *RotateNodeKeyRequest, opts ...grpc.CallOption) (*RotateNodeKeyResponse, error) {
+	out := new(RotateNodeKeyResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_RotateNodeKey_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) RevokeNode(ctx context.Context, in *RevokeNodeRequest, opts ...grpc.CallOption) (*RevokeNodeResponse, error) {
+	out := new(RevokeNodeResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_RevokeNode_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) SetNodeNote(ctx context.Context, in *SetNodeNoteRequest, opts ...grpc.CallOption) (*SetNodeNoteResponse, error) {
+	out := new(SetNodeNoteResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetNodeNote_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) SetNodeDERPHome(ctx context.Context, in *SetNodeDERPHomeRequest, opts ...grpc.CallOption) (*SetNodeDERPHomeResponse, error) {
+	out := new(SetNodeDERPHomeResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetNodeDERPHome_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) SetNodeService(ctx context.Context, in *SetNodeServiceRequest, opts ...grpc.CallOption) (*SetNodeServiceResponse, error) {
+	out := new(SetNodeServiceResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetNodeService_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *headscaleServiceClient) ListNodes(ctx context.Context, in *ListNodesRequest, opts ...grpc.CallOption) (*ListNodesResponse, error) {
 	out := new(ListNodesResponse)
 	err := c.cc.Invoke(ctx, HeadscaleService_ListNodes_FullMethodName, in, out, opts...)
@@ -261,6 +481,42 @@ func (c *headscaleServiceClient) BackfillNodeIPs(ctx context.Context, in *Backfi
 	return out, nil
 }
 
+func (c *headscaleServiceClient) ListNodeStats(ctx context.Context, in *ListNodeStatsRequest, opts ...grpc.CallOption) (*ListNodeStatsResponse, error) {
+	out := new(ListNodeStatsResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ListNodeStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) GetNodeNetcheck(ctx context.Context, in *GetNodeNetcheckRequest, opts ...grpc.CallOption) (*GetNodeNetcheckResponse, error) {
+	out := new(GetNodeNetcheckResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_GetNodeNetcheck_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) NodeC2N(ctx context.Context, in *NodeC2NRequest, opts ...grpc.CallOption) (*NodeC2NResponse, error) {
+	out := new(NodeC2NResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_NodeC2N_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) PreviewNodeFQDN(ctx context.Context, in *PreviewNodeFQDNRequest, opts ...grpc.CallOption) (*PreviewNodeFQDNResponse, error) {
+	out := new(PreviewNodeFQDNResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_PreviewNodeFQDN_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *headscaleServiceClient) GetRoutes(ctx context.Context, in *GetRoutesRequest, opts ...grpc.CallOption) (*GetRoutesResponse, error) {
 	out := new(GetRoutesResponse)
 	err := c.cc.Invoke(ctx, HeadscaleService_GetRoutes_FullMethodName, in, out, opts...)
@@ -306,6 +562,42 @@ func (c *headscaleServiceClient) DeleteRoute(ctx context.Context, in *DeleteRout
 	return out, nil
 }
 
+func (c *headscaleServiceClient) GetEffectiveRoutes(ctx context.Context, in *GetEffectiveRoutesRequest, opts ...grpc.CallOption) (*GetEffectiveRoutesResponse, error) {
+	out := new(GetEffectiveRoutesResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_GetEffectiveRoutes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) EnablePrefixRoutes(ctx context.Context, in *EnablePrefixRoutesRequest, opts ...grpc.CallOption) (*EnablePrefixRoutesResponse, error) {
+	out := new(EnablePrefixRoutesResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_EnablePrefixRoutes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) DisablePrefixRoutes(ctx context.Context, in *DisablePrefixRoutesRequest, opts ...grpc.CallOption) (*DisablePrefixRoutesResponse, error) {
+	out := new(DisablePrefixRoutesResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DisablePrefixRoutes_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) GetRouteHistory(ctx context.Context, in *GetRouteHistoryRequest, opts ...grpc.CallOption) (*GetRouteHistoryResponse, error) {
+	out := new(GetRouteHistoryResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_GetRouteHistory_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *headscaleServiceClient) CreateApiKey(ctx context.Context, in *CreateApiKeyRequest, opts ...grpc.CallOption) (*CreateApiKeyResponse, error) {
 	out := new(CreateApiKeyResponse)
 	err := c.cc.Invoke(ctx, HeadscaleService_CreateApiKey_FullMethodName, in, out, opts...)
@@ -360,87 +652,362 @@ func (c *headscaleServiceClient) SetPolicy(ctx context.Context, in *SetPolicyReq
 	return out, nil
 }
 
-// HeadscaleServiceServer is the server API for HeadscaleService service.
-// All implementations must embed UnimplementedHeadscaleServiceServer
-// for forward compatibility
-type HeadscaleServiceServer interface {
-	// --- User start ---
-	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
-	CreateUser(context.Context, *CreateUserRequest) (*CreateUserResponse, error)
-	RenameUser(context.Context, *RenameUserRequest) (*RenameUserResponse, error)
-	DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserResponse, error)
-	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
-	// --- PreAuthKeys start ---
-	CreatePreAuthKey(context.Context, *CreatePreAuthKeyRequest) (*CreatePreAuthKeyResponse, error)
-	ExpirePreAuthKey(context.Context, *ExpirePreAuthKeyRequest) (*ExpirePreAuthKeyResponse, error)
-	ListPreAuthKeys(context.Context, *ListPreAuthKeysRequest) (*ListPreAuthKeysResponse, error)
-	// --- Node start ---
-	DebugCreateNode(context.Context, *DebugCreateNodeRequest) (*DebugCreateNodeResponse, error)
-	GetNode(context.Context, *GetNodeRequest) (*GetNodeResponse, error)
-	SetTags(context.Context, *SetTagsRequest) (*SetTagsResponse, error)
-	RegisterNode(context.Context, *RegisterNodeRequest) (*RegisterNodeResponse, error)
-	DeleteNode(context.Context, *DeleteNodeRequest) (*DeleteNodeResponse, error)
-	ExpireNode(context.Context, *ExpireNodeRequest) (*ExpireNodeResponse, error)
-	RenameNode(context.Context, *RenameNodeRequest) (*RenameNodeResponse, error)
-	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
-	MoveNode(context.Context, *MoveNodeRequest) (*MoveNodeResponse, error)
-	BackfillNodeIPs(context.Context, *BackfillNodeIPsRequest) (*BackfillNodeIPsResponse, error)
-	// --- Route start ---
-	GetRoutes(context.Context, *GetRoutesRequest) (*GetRoutesResponse, error)
-	EnableRoute(context.Context, *EnableRouteRequest) (*EnableRouteResponse, error)
-	DisableRoute(context.Context, *DisableRouteRequest) (*DisableRouteResponse, error)
-	GetNodeRoutes(context.Context, *GetNodeRoutesRequest) (*GetNodeRoutesResponse, error)
-	DeleteRoute(context.Context, *DeleteRouteRequest) (*DeleteRouteResponse, error)
-	// --- ApiKeys start ---
-	CreateApiKey(context.Context, *CreateApiKeyRequest) (*CreateApiKeyResponse, error)
-	ExpireApiKey(context.Context, *ExpireApiKeyRequest) (*ExpireApiKeyResponse, error)
-	ListApiKeys(context.Context, *ListApiKeysRequest) (*ListApiKeysResponse, error)
-	DeleteApiKey(context.Context, *DeleteApiKeyRequest) (*DeleteApiKeyResponse, error)
-	// --- Policy start ---
-	GetPolicy(context.Context, *GetPolicyRequest) (*GetPolicyResponse, error)
-	SetPolicy(context.Context, *SetPolicyRequest) (*SetPolicyResponse, error)
-	mustEmbedUnimplementedHeadscaleServiceServer()
+func (c *headscaleServiceClient) AddPolicyGroupMembers(ctx context.Context, in *AddPolicyGroupMembersRequest, opts ...grpc.CallOption) (*AddPolicyGroupMembersResponse, error) {
+	out := new(AddPolicyGroupMembersResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_AddPolicyGroupMembers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-// UnimplementedHeadscaleServiceServer must be embedded to have forward compatible implementations.
-type UnimplementedHeadscaleServiceServer struct {
+func (c *headscaleServiceClient) RemovePolicyGroupMembers(ctx context.Context, in *RemovePolicyGroupMembersRequest, opts ...grpc.CallOption) (*RemovePolicyGroupMembersResponse, error) {
+	out := new(RemovePolicyGroupMembersResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_RemovePolicyGroupMembers_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
-func (UnimplementedHeadscaleServiceServer) GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
-}
-func (UnimplementedHeadscaleServiceServer) CreateUser(context.Context, *CreateUserRequest) (*CreateUserResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
+func (c *headscaleServiceClient) SetPolicyHost(ctx context.Context, in *SetPolicyHostRequest, opts ...grpc.CallOption) (*SetPolicyHostResponse, error) {
+	out := new(SetPolicyHostResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetPolicyHost_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) RenameUser(context.Context, *RenameUserRequest) (*RenameUserResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method RenameUser not implemented")
+
+func (c *headscaleServiceClient) DeletePolicyHost(ctx context.Context, in *DeletePolicyHostRequest, opts ...grpc.CallOption) (*DeletePolicyHostResponse, error) {
+	out := new(DeletePolicyHostResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DeletePolicyHost_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+
+func (c *headscaleServiceClient) GetPolicyStats(ctx context.Context, in *GetPolicyStatsRequest, opts ...grpc.CallOption) (*GetPolicyStatsResponse, error) {
+	out := new(GetPolicyStatsResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_GetPolicyStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+
+func (c *headscaleServiceClient) DiffPolicy(ctx context.Context, in *DiffPolicyRequest, opts ...grpc.CallOption) (*DiffPolicyResponse, error) {
+	out := new(DiffPolicyResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DiffPolicy_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) CreatePreAuthKey(context.Context, *CreatePreAuthKeyRequest) (*CreatePreAuthKeyResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method CreatePreAuthKey not implemented")
+
+func (c *headscaleServiceClient) StartPolicyRollout(ctx context.Context, in *StartPolicyRolloutRequest, opts ...grpc.CallOption) (*StartPolicyRolloutResponse, error) {
+	out := new(StartPolicyRolloutResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_StartPolicyRollout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) ExpirePreAuthKey(context.Context, *ExpirePreAuthKeyRequest) (*ExpirePreAuthKeyResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method ExpirePreAuthKey not implemented")
+
+func (c *headscaleServiceClient) GetPolicyRollout(ctx context.Context, in *GetPolicyRolloutRequest, opts ...grpc.CallOption) (*GetPolicyRolloutResponse, error) {
+	out := new(GetPolicyRolloutResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_GetPolicyRollout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) ListPreAuthKeys(context.Context, *ListPreAuthKeysRequest) (*ListPreAuthKeysResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method ListPreAuthKeys not implemented")
+
+func (c *headscaleServiceClient) PromotePolicyRollout(ctx context.Context, in *PromotePolicyRolloutRequest, opts ...grpc.CallOption) (*PromotePolicyRolloutResponse, error) {
+	out := new(PromotePolicyRolloutResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_PromotePolicyRollout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) DebugCreateNode(context.Context, *DebugCreateNodeRequest) (*DebugCreateNodeResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method DebugCreateNode not implemented")
+
+func (c *headscaleServiceClient) RollbackPolicyRollout(ctx context.Context, in *RollbackPolicyRolloutRequest, opts ...grpc.CallOption) (*RollbackPolicyRolloutResponse, error) {
+	out := new(RollbackPolicyRolloutResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_RollbackPolicyRollout_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) GetNode(context.Context, *GetNodeRequest) (*GetNodeResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method GetNode not implemented")
+
+func (c *headscaleServiceClient) DatabaseGC(ctx context.Context, in *DatabaseGCRequest, opts ...grpc.CallOption) (*DatabaseGCResponse, error) {
+	out := new(DatabaseGCResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DatabaseGC_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) SetTags(context.Context, *SetTagsRequest) (*SetTagsResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method SetTags not implemented")
+
+func (c *headscaleServiceClient) GetLogLevels(ctx context.Context, in *GetLogLevelsRequest, opts ...grpc.CallOption) (*GetLogLevelsResponse, error) {
+	out := new(GetLogLevelsResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_GetLogLevels_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
 }
-func (UnimplementedHeadscaleServiceServer) RegisterNode(context.Context, *RegisterNodeRequest) (*RegisterNodeResponse, error) {
-	return nil, status.Errorf(codes.Unimplemented, "method RegisterNode not implemented")
+
+func (c *headscaleServiceClient) SetLogLevel(ctx context.Context, in *SetLogLevelRequest, opts ...grpc.CallOption) (*SetLogLevelResponse, error) {
+	out := new(SetLogLevelResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_SetLogLevel_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) Search(ctx context.Context, in *SearchRequest, opts ...grpc.CallOption) (*SearchResponse, error) {
+	out := new(SearchResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_Search_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) ListDERPClients(ctx context.Context, in *ListDERPClientsRequest, opts ...grpc.CallOption) (*ListDERPClientsResponse, error) {
+	out := new(ListDERPClientsResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ListDERPClients_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) RegisterDERPRelay(ctx context.Context, in *RegisterDERPRelayRequest, opts ...grpc.CallOption) (*RegisterDERPRelayResponse, error) {
+	out := new(RegisterDERPRelayResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_RegisterDERPRelay_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) ListDERPRelays(ctx context.Context, in *ListDERPRelaysRequest, opts ...grpc.CallOption) (*ListDERPRelaysResponse, error) {
+	out := new(ListDERPRelaysResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ListDERPRelays_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) DeleteDERPRelay(ctx context.Context, in *DeleteDERPRelayRequest, opts ...grpc.CallOption) (*DeleteDERPRelayResponse, error) {
+	out := new(DeleteDERPRelayResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DeleteDERPRelay_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) CreateServiceAccount(ctx context.Context, in *CreateServiceAccountRequest, opts ...grpc.CallOption) (*CreateServiceAccountResponse, error) {
+	out := new(CreateServiceAccountResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_CreateServiceAccount_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) ListServiceAccounts(ctx context.Context, in *ListServiceAccountsRequest, opts ...grpc.CallOption) (*ListServiceAccountsResponse, error) {
+	out := new(ListServiceAccountsResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ListServiceAccounts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) DeleteServiceAccount(ctx context.Context, in *DeleteServiceAccountRequest, opts ...grpc.CallOption) (*DeleteServiceAccountResponse, error) {
+	out := new(DeleteServiceAccountResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_DeleteServiceAccount_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *headscaleServiceClient) ImportTailnet(ctx context.Context, in *ImportTailnetRequest, opts ...grpc.CallOption) (*ImportTailnetResponse, error) {
+	out := new(ImportTailnetResponse)
+	err := c.cc.Invoke(ctx, HeadscaleService_ImportTailnet_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HeadscaleServiceServer is the server API for HeadscaleService service.
+// All implementations must embed UnimplementedHeadscaleServiceServer
+// for forward compatibility
+type HeadscaleServiceServer interface {
+	// --- User start ---
+	GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error)
+	CreateUser(context.Context, *CreateUserRequest) (*CreateUserResponse, error)
+	RenameUser(context.Context, *RenameUserRequest) (*RenameUserResponse, error)
+	DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserResponse, error)
+	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	SuspendUser(context.Context, *SuspendUserRequest) (*SuspendUserResponse, error)
+	ResumeUser(context.Context, *ResumeUserRequest) (*ResumeUserResponse, error)
+	ListUserAliases(context.Context, *ListUserAliasesRequest) (*ListUserAliasesResponse, error)
+	SetUserPassword(context.Context, *SetUserPasswordRequest) (*SetUserPasswordResponse, error)
+	SetUserTOTP(context.Context, *SetUserTOTPRequest) (*SetUserTOTPResponse, error)
+	// --- PreAuthKeys start ---
+	CreatePreAuthKey(context.Context, *CreatePreAuthKeyRequest) (*CreatePreAuthKeyResponse, error)
+	ExpirePreAuthKey(context.Context, *ExpirePreAuthKeyRequest) (*ExpirePreAuthKeyResponse, error)
+	ListPreAuthKeys(context.Context, *ListPreAuthKeysRequest) (*ListPreAuthKeysResponse, error)
+	// --- Node start ---
+	DebugCreateNode(context.Context, *DebugCreateNodeRequest) (*DebugCreateNodeResponse, error)
+	DebugConnectivityMatrix(context.Context, *DebugConnectivityMatrixRequest) (*DebugConnectivityMatrixResponse, error)
+	DebugProfile(context.Context, *DebugProfileRequest) (*DebugProfileResponse, error)
+	GetNode(context.Context, *GetNodeRequest) (*GetNodeResponse, error)
+	SetTags(context.Context, *SetTagsRequest) (*SetTagsResponse, error)
+	AddTag(context.Context, *AddTagRequest) (*AddTagResponse, error)
+	RemoveTag(context.Context, *RemoveTagRequest) (*RemoveTagResponse, error)
+	RegisterNode(context.Context, *RegisterNodeRequest) (*RegisterNodeResponse, error)
+	DeleteNode(context.Context, *DeleteNodeRequest) (*DeleteNodeResponse, error)
+	ExpireNode(context.Context, *ExpireNodeRequest) (*ExpireNodeResponse, error)
+	RenameNode(context.Context, *RenameNodeRequest) (*RenameNodeResponse, error)
+	RotateNodeKey(context.Context, *RotateNodeKeyRequest) (*RotateNodeKeyResponse, error)
+	RevokeNode(context.Context, *RevokeNodeRequest) (*RevokeNodeResponse, error)
+	SetNodeNote(context.Context, *SetNodeNoteRequest) (*SetNodeNoteResponse, error)
+	SetNodeDERPHome(context.Context, *SetNodeDERPHomeRequest) (*SetNodeDERPHomeResponse, error)
+	SetNodeService(context.Context, *SetNodeServiceRequest) (*SetNodeServiceResponse, error)
+	ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error)
+	MoveNode(context.Context, *MoveNodeRequest) (*MoveNodeResponse, error)
+	BackfillNodeIPs(context.Context, *BackfillNodeIPsRequest) (*BackfillNodeIPsResponse, error)
+	ListNodeStats(context.Context, *ListNodeStatsRequest) (*ListNodeStatsResponse, error)
+	GetNodeNetcheck(context.Context, *GetNodeNetcheckRequest) (*GetNodeNetcheckResponse, error)
+	NodeC2N(context.Context, *NodeC2NRequest) (*NodeC2NResponse, error)
+	PreviewNodeFQDN(context.Context, *PreviewNodeFQDNRequest) (*PreviewNodeFQDNResponse, error)
+	// --- Route start ---
+	GetRoutes(context.Context, *GetRoutesRequest) (*GetRoutesResponse, error)
+	EnableRoute(context.Context, *EnableRouteRequest) (*EnableRouteResponse, error)
+	DisableRoute(context.Context, *DisableRouteRequest) (*DisableRouteResponse, error)
+	GetNodeRoutes(context.Context, *GetNodeRoutesRequest) (*GetNodeRoutesResponse, error)
+	DeleteRoute(context.Context, *DeleteRouteRequest) (*DeleteRouteResponse, error)
+	GetEffectiveRoutes(context.Context, *GetEffectiveRoutesRequest) (*GetEffectiveRoutesResponse, error)
+	EnablePrefixRoutes(context.Context, *EnablePrefixRoutesRequest) (*EnablePrefixRoutesResponse, error)
+	DisablePrefixRoutes(context.Context, *DisablePrefixRoutesRequest) (*DisablePrefixRoutesResponse, error)
+	GetRouteHistory(context.Context, *GetRouteHistoryRequest) (*GetRouteHistoryResponse, error)
+	// --- ApiKeys start ---
+	CreateApiKey(context.Context, *CreateApiKeyRequest) (*CreateApiKeyResponse, error)
+	ExpireApiKey(context.Context, *ExpireApiKeyRequest) (*ExpireApiKeyResponse, error)
+	ListApiKeys(context.Context, *ListApiKeysRequest) (*ListApiKeysResponse, error)
+	DeleteApiKey(context.Context, *DeleteApiKeyRequest) (*DeleteApiKeyResponse, error)
+	// --- Policy start ---
+	GetPolicy(context.Context, *GetPolicyRequest) (*GetPolicyResponse, error)
+	SetPolicy(context.Context, *SetPolicyRequest) (*SetPolicyResponse, error)
+	AddPolicyGroupMembers(context.Context, *AddPolicyGroupMembersRequest) (*AddPolicyGroupMembersResponse, error)
+	RemovePolicyGroupMembers(context.Context, *RemovePolicyGroupMembersRequest) (*RemovePolicyGroupMembersResponse, error)
+	SetPolicyHost(context.Context, *SetPolicyHostRequest) (*SetPolicyHostResponse, error)
+	DeletePolicyHost(context.Context, *DeletePolicyHostRequest) (*DeletePolicyHostResponse, error)
+	GetPolicyStats(context.Context, *GetPolicyStatsRequest) (*GetPolicyStatsResponse, error)
+	DiffPolicy(context.Context, *DiffPolicyRequest) (*DiffPolicyResponse, error)
+	StartPolicyRollout(context.Context, *StartPolicyRolloutRequest) (*StartPolicyRolloutResponse, error)
+	GetPolicyRollout(context.Context, *GetPolicyRolloutRequest) (*GetPolicyRolloutResponse, error)
+	PromotePolicyRollout(context.Context, *PromotePolicyRolloutRequest) (*PromotePolicyRolloutResponse, error)
+	RollbackPolicyRollout(context.Context, *RollbackPolicyRolloutRequest) (*RollbackPolicyRolloutResponse, error)
+	// --- Database start ---
+	DatabaseGC(context.Context, *DatabaseGCRequest) (*DatabaseGCResponse, error)
+	// --- Log start ---
+	GetLogLevels(context.Context, *GetLogLevelsRequest) (*GetLogLevelsResponse, error)
+	SetLogLevel(context.Context, *SetLogLevelRequest) (*SetLogLevelResponse, error)
+	// --- Search start ---
+	Search(context.Context, *SearchRequest) (*SearchResponse, error)
+	// --- DERP start ---
+	ListDERPClients(context.Context, *ListDERPClientsRequest) (*ListDERPClientsResponse, error)
+	RegisterDERPRelay(context.Context, *RegisterDERPRelayRequest) (*RegisterDERPRelayResponse, error)
+	ListDERPRelays(context.Context, *ListDERPRelaysRequest) (*ListDERPRelaysResponse, error)
+	DeleteDERPRelay(context.Context, *DeleteDERPRelayRequest) (*DeleteDERPRelayResponse, error)
+	// --- ServiceAccount start ---
+	CreateServiceAccount(context.Context, *CreateServiceAccountRequest) (*CreateServiceAccountResponse, error)
+	ListServiceAccounts(context.Context, *ListServiceAccountsRequest) (*ListServiceAccountsResponse, error)
+	DeleteServiceAccount(context.Context, *DeleteServiceAccountRequest) (*DeleteServiceAccountResponse, error)
+	// --- Import start ---
+	ImportTailnet(context.Context, *ImportTailnetRequest) (*ImportTailnetResponse, error)
+	mustEmbedUnimplementedHeadscaleServiceServer()
+}
+
+// UnimplementedHeadscaleServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedHeadscaleServiceServer struct {
+}
+
+func (UnimplementedHeadscaleServiceServer) GetUser(context.Context, *GetUserRequest) (*GetUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) CreateUser(context.Context, *CreateUserRequest) (*CreateUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RenameUser(context.Context, *RenameUserRequest) (*RenameUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenameUser not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DeleteUser(context.Context, *DeleteUserRequest) (*DeleteUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SuspendUser(context.Context, *SuspendUserRequest) (*SuspendUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SuspendUser not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ResumeUser(context.Context, *ResumeUserRequest) (*ResumeUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeUser not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ListUserAliases(context.Context, *ListUserAliasesRequest) (*ListUserAliasesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserAliases not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetUserPassword(context.Context, *SetUserPasswordRequest) (*SetUserPasswordResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUserPassword not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetUserTOTP(context.Context, *SetUserTOTPRequest) (*SetUserTOTPResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetUserTOTP not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) CreatePreAuthKey(context.Context, *CreatePreAuthKeyRequest) (*CreatePreAuthKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreatePreAuthKey not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ExpirePreAuthKey(context.Context, *ExpirePreAuthKeyRequest) (*ExpirePreAuthKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExpirePreAuthKey not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ListPreAuthKeys(context.Context, *ListPreAuthKeysRequest) (*ListPreAuthKeysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPreAuthKeys not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DebugCreateNode(context.Context, *DebugCreateNodeRequest) (*DebugCreateNodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DebugCreateNode not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DebugConnectivityMatrix(context.Context, *DebugConnectivityMatrixRequest) (*DebugConnectivityMatrixResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DebugConnectivityMatrix not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DebugProfile(context.Context, *DebugProfileRequest) (*DebugProfileResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DebugProfile not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) GetNode(context.Context, *GetNodeRequest) (*GetNodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNode not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetTags(context.Context, *SetTagsRequest) (*SetTagsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetTags not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) AddTag(context.Context, *AddTagRequest) (*AddTagResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddTag not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RemoveTag(context.Context, *RemoveTagRequest) (*RemoveTagResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveTag not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RegisterNode(context.Context, *RegisterNodeRequest) (*RegisterNodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterNode not implemented")
 }
 func (UnimplementedHeadscaleServiceServer) DeleteNode(context.Context, *DeleteNodeRequest) (*DeleteNodeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteNode not implemented")
@@ -451,6 +1018,21 @@ func (UnimplementedHeadscaleServiceServer) ExpireNode(context.Context, *ExpireNo
 func (UnimplementedHeadscaleServiceServer) RenameNode(context.Context, *RenameNodeRequest) (*RenameNodeResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RenameNode not implemented")
 }
+func (UnimplementedHeadscaleServiceServer) RotateNodeKey(context.Context, *RotateNodeKeyRequest) (*RotateNodeKeyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RotateNodeKey not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RevokeNode(context.Context, *RevokeNodeRequest) (*RevokeNodeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RevokeNode not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetNodeNote(context.Context, *SetNodeNoteRequest) (*SetNodeNoteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetNodeNote not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetNodeDERPHome(context.Context, *SetNodeDERPHomeRequest) (*SetNodeDERPHomeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetNodeDERPHome not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetNodeService(context.Context, *SetNodeServiceRequest) (*SetNodeServiceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetNodeService not implemented")
+}
 func (UnimplementedHeadscaleServiceServer) ListNodes(context.Context, *ListNodesRequest) (*ListNodesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListNodes not implemented")
 }
@@ -460,6 +1042,18 @@ func (UnimplementedHeadscaleServiceServer) MoveNode(context.Context, *MoveNodeRe
 func (UnimplementedHeadscaleServiceServer) BackfillNodeIPs(context.Context, *BackfillNodeIPsRequest) (*BackfillNodeIPsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method BackfillNodeIPs not implemented")
 }
+func (UnimplementedHeadscaleServiceServer) ListNodeStats(context.Context, *ListNodeStatsRequest) (*ListNodeStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNodeStats not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) GetNodeNetcheck(context.Context, *GetNodeNetcheckRequest) (*GetNodeNetcheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNodeNetcheck not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) NodeC2N(context.Context, *NodeC2NRequest) (*NodeC2NResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method NodeC2N not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) PreviewNodeFQDN(context.Context, *PreviewNodeFQDNRequest) (*PreviewNodeFQDNResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PreviewNodeFQDN not implemented")
+}
 func (UnimplementedHeadscaleServiceServer) GetRoutes(context.Context, *GetRoutesRequest) (*GetRoutesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetRoutes not implemented")
 }
@@ -475,6 +1069,18 @@ func (UnimplementedHeadscaleServiceServer) GetNodeRoutes(context.Context, *GetNo
 func (UnimplementedHeadscaleServiceServer) DeleteRoute(context.Context, *DeleteRouteRequest) (*DeleteRouteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteRoute not implemented")
 }
+func (UnimplementedHeadscaleServiceServer) GetEffectiveRoutes(context.Context, *GetEffectiveRoutesRequest) (*GetEffectiveRoutesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetEffectiveRoutes not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) EnablePrefixRoutes(context.Context, *EnablePrefixRoutesRequest) (*EnablePrefixRoutesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EnablePrefixRoutes not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DisablePrefixRoutes(context.Context, *DisablePrefixRoutesRequest) (*DisablePrefixRoutesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DisablePrefixRoutes not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) GetRouteHistory(context.Context, *GetRouteHistoryRequest) (*GetRouteHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRouteHistory not implemented")
+}
 func (UnimplementedHeadscaleServiceServer) CreateApiKey(context.Context, *CreateApiKeyRequest) (*CreateApiKeyResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateApiKey not implemented")
 }
@@ -493,6 +1099,72 @@ func (UnimplementedHeadscaleServiceServer) GetPolicy(context.Context, *GetPolicy
 func (UnimplementedHeadscaleServiceServer) SetPolicy(context.Context, *SetPolicyRequest) (*SetPolicyResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetPolicy not implemented")
 }
+func (UnimplementedHeadscaleServiceServer) AddPolicyGroupMembers(context.Context, *AddPolicyGroupMembersRequest) (*AddPolicyGroupMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPolicyGroupMembers not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RemovePolicyGroupMembers(context.Context, *RemovePolicyGroupMembersRequest) (*RemovePolicyGroupMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePolicyGroupMembers not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetPolicyHost(context.Context, *SetPolicyHostRequest) (*SetPolicyHostResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetPolicyHost not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DeletePolicyHost(context.Context, *DeletePolicyHostRequest) (*DeletePolicyHostResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeletePolicyHost not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) GetPolicyStats(context.Context, *GetPolicyStatsRequest) (*GetPolicyStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPolicyStats not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DiffPolicy(context.Context, *DiffPolicyRequest) (*DiffPolicyResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DiffPolicy not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) StartPolicyRollout(context.Context, *StartPolicyRolloutRequest) (*StartPolicyRolloutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartPolicyRollout not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) GetPolicyRollout(context.Context, *GetPolicyRolloutRequest) (*GetPolicyRolloutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPolicyRollout not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) PromotePolicyRollout(context.Context, *PromotePolicyRolloutRequest) (*PromotePolicyRolloutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PromotePolicyRollout not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RollbackPolicyRollout(context.Context, *RollbackPolicyRolloutRequest) (*RollbackPolicyRolloutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RollbackPolicyRollout not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DatabaseGC(context.Context, *DatabaseGCRequest) (*DatabaseGCResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DatabaseGC not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) GetLogLevels(context.Context, *GetLogLevelsRequest) (*GetLogLevelsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetLogLevels not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) SetLogLevel(context.Context, *SetLogLevelRequest) (*SetLogLevelResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLogLevel not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) Search(context.Context, *SearchRequest) (*SearchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Search not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ListDERPClients(context.Context, *ListDERPClientsRequest) (*ListDERPClientsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDERPClients not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) RegisterDERPRelay(context.Context, *RegisterDERPRelayRequest) (*RegisterDERPRelayResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterDERPRelay not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ListDERPRelays(context.Context, *ListDERPRelaysRequest) (*ListDERPRelaysResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDERPRelays not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DeleteDERPRelay(context.Context, *DeleteDERPRelayRequest) (*DeleteDERPRelayResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteDERPRelay not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) CreateServiceAccount(context.Context, *CreateServiceAccountRequest) (*CreateServiceAccountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateServiceAccount not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ListServiceAccounts(context.Context, *ListServiceAccountsRequest) (*ListServiceAccountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListServiceAccounts not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) DeleteServiceAccount(context.Context, *DeleteServiceAccountRequest) (*DeleteServiceAccountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteServiceAccount not implemented")
+}
+func (UnimplementedHeadscaleServiceServer) ImportTailnet(context.Context, *ImportTailnetRequest) (*ImportTailnetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ImportTailnet not implemented")
+}
 func (UnimplementedHeadscaleServiceServer) mustEmbedUnimplementedHeadscaleServiceServer() {}
 
 // UnsafeHeadscaleServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -530,500 +1202,1292 @@ func _HeadscaleService_CreateUser_Handler(srv interface{}, ctx context.Context,
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).CreateUser(ctx, in)
+		return srv.(HeadscaleServiceServer).CreateUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_CreateUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).CreateUser(ctx, req.(*CreateUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_RenameUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).RenameUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_RenameUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).RenameUser(ctx, req.(*RenameUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DeleteUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DeleteUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ListUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ListUsers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ListUsers(ctx, req.(*ListUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SuspendUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SuspendUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SuspendUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SuspendUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SuspendUser(ctx, req.(*SuspendUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ResumeUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ResumeUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ResumeUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ResumeUser(ctx, req.(*ResumeUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ListUserAliases_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserAliasesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ListUserAliases(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ListUserAliases_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ListUserAliases(ctx, req.(*ListUserAliasesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SetUserPassword_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetUserPasswordRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SetUserPassword(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SetUserPassword_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SetUserPassword(ctx, req.(*SetUserPasswordRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SetUserTOTP_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetUserTOTPRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SetUserTOTP(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SetUserTOTP_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SetUserTOTP(ctx, req.(*SetUserTOTPRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_CreatePreAuthKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreatePreAuthKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).CreatePreAuthKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_CreatePreAuthKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).CreatePreAuthKey(ctx, req.(*CreatePreAuthKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ExpirePreAuthKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpirePreAuthKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ExpirePreAuthKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ExpirePreAuthKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ExpirePreAuthKey(ctx, req.(*ExpirePreAuthKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ListPreAuthKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPreAuthKeysRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ListPreAuthKeys(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ListPreAuthKeys_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ListPreAuthKeys(ctx, req.(*ListPreAuthKeysRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DebugCreateNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DebugCreateNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DebugCreateNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DebugCreateNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DebugCreateNode(ctx, req.(*DebugCreateNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DebugConnectivityMatrix_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DebugConnectivityMatrixRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DebugConnectivityMatrix(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DebugConnectivityMatrix_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DebugConnectivityMatrix(ctx, req.(*DebugConnectivityMatrixRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DebugProfile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DebugProfileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DebugProfile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DebugProfile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DebugProfile(ctx, req.(*DebugProfileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_GetNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).GetNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_GetNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).GetNode(ctx, req.(*GetNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SetTags_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTagsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SetTags(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SetTags_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SetTags(ctx, req.(*SetTagsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_AddTag_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddTagRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).AddTag(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_AddTag_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).AddTag(ctx, req.(*AddTagRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_RemoveTag_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveTagRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).RemoveTag(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_RemoveTag_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).RemoveTag(ctx, req.(*RemoveTagRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_RegisterNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).RegisterNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_RegisterNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).RegisterNode(ctx, req.(*RegisterNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DeleteNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DeleteNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DeleteNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DeleteNode(ctx, req.(*DeleteNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ExpireNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpireNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ExpireNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ExpireNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ExpireNode(ctx, req.(*ExpireNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_RenameNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).RenameNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_RenameNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).RenameNode(ctx, req.(*RenameNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_RotateNodeKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RotateNodeKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).RotateNodeKey(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_RotateNodeKey_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).RotateNodeKey(ctx, req.(*RotateNodeKeyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_RevokeNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).RevokeNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_RevokeNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).RevokeNode(ctx, req.(*RevokeNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SetNodeNote_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetNodeNoteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SetNodeNote(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SetNodeNote_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SetNodeNote(ctx, req.(*SetNodeNoteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SetNodeDERPHome_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetNodeDERPHomeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SetNodeDERPHome(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SetNodeDERPHome_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SetNodeDERPHome(ctx, req.(*SetNodeDERPHomeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_SetNodeService_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetNodeServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).SetNodeService(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_SetNodeService_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).SetNodeService(ctx, req.(*SetNodeServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ListNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNodesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ListNodes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ListNodes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ListNodes(ctx, req.(*ListNodesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_MoveNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MoveNodeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).MoveNode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_MoveNode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).MoveNode(ctx, req.(*MoveNodeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_BackfillNodeIPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BackfillNodeIPsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).BackfillNodeIPs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_BackfillNodeIPs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).BackfillNodeIPs(ctx, req.(*BackfillNodeIPsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_ListNodeStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNodeStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).ListNodeStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_ListNodeStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).ListNodeStats(ctx, req.(*ListNodeStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_GetNodeNetcheck_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeNetcheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).GetNodeNetcheck(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_GetNodeNetcheck_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).GetNodeNetcheck(ctx, req.(*GetNodeNetcheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_NodeC2N_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(NodeC2NRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).NodeC2N(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_NodeC2N_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).NodeC2N(ctx, req.(*NodeC2NRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_PreviewNodeFQDN_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PreviewNodeFQDNRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).PreviewNodeFQDN(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_PreviewNodeFQDN_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).PreviewNodeFQDN(ctx, req.(*PreviewNodeFQDNRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_GetRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRoutesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).GetRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_GetRoutes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).GetRoutes(ctx, req.(*GetRoutesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_EnableRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnableRouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).EnableRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_EnableRoute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).EnableRoute(ctx, req.(*EnableRouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DisableRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisableRouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DisableRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DisableRoute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DisableRoute(ctx, req.(*DisableRouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_GetNodeRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeRoutesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).GetNodeRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_GetNodeRoutes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).GetNodeRoutes(ctx, req.(*GetNodeRoutesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DeleteRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRouteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DeleteRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DeleteRoute_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DeleteRoute(ctx, req.(*DeleteRouteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_GetEffectiveRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetEffectiveRoutesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).GetEffectiveRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_GetEffectiveRoutes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).GetEffectiveRoutes(ctx, req.(*GetEffectiveRoutesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_EnablePrefixRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EnablePrefixRoutesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).EnablePrefixRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_EnablePrefixRoutes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).EnablePrefixRoutes(ctx, req.(*EnablePrefixRoutesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_DisablePrefixRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DisablePrefixRoutesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).DisablePrefixRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_DisablePrefixRoutes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).DisablePrefixRoutes(ctx, req.(*DisablePrefixRoutesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_GetRouteHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRouteHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).GetRouteHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: HeadscaleService_GetRouteHistory_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HeadscaleServiceServer).GetRouteHistory(ctx, req.(*GetRouteHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _HeadscaleService_CreateApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateApiKeyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HeadscaleServiceServer).CreateApiKey(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_CreateUser_FullMethodName,
+		FullMethod: HeadscaleService_CreateApiKey_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).CreateUser(ctx, req.(*CreateUserRequest))
+		return srv.(HeadscaleServiceServer).CreateApiKey(ctx, req.(*CreateApiKeyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_RenameUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(RenameUserRequest)
+func _HeadscaleService_ExpireApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExpireApiKeyRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).RenameUser(ctx, in)
+		return srv.(HeadscaleServiceServer).ExpireApiKey(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_RenameUser_FullMethodName,
+		FullMethod: HeadscaleService_ExpireApiKey_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).RenameUser(ctx, req.(*RenameUserRequest))
+		return srv.(HeadscaleServiceServer).ExpireApiKey(ctx, req.(*ExpireApiKeyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_DeleteUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DeleteUserRequest)
+func _HeadscaleService_ListApiKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListApiKeysRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).DeleteUser(ctx, in)
+		return srv.(HeadscaleServiceServer).ListApiKeys(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_DeleteUser_FullMethodName,
+		FullMethod: HeadscaleService_ListApiKeys_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).DeleteUser(ctx, req.(*DeleteUserRequest))
+		return srv.(HeadscaleServiceServer).ListApiKeys(ctx, req.(*ListApiKeysRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ListUsersRequest)
+func _HeadscaleService_DeleteApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteApiKeyRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ListUsers(ctx, in)
+		return srv.(HeadscaleServiceServer).DeleteApiKey(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ListUsers_FullMethodName,
+		FullMethod: HeadscaleService_DeleteApiKey_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ListUsers(ctx, req.(*ListUsersRequest))
+		return srv.(HeadscaleServiceServer).DeleteApiKey(ctx, req.(*DeleteApiKeyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_CreatePreAuthKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(CreatePreAuthKeyRequest)
+func _HeadscaleService_GetPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPolicyRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).CreatePreAuthKey(ctx, in)
+		return srv.(HeadscaleServiceServer).GetPolicy(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_CreatePreAuthKey_FullMethodName,
+		FullMethod: HeadscaleService_GetPolicy_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).CreatePreAuthKey(ctx, req.(*CreatePreAuthKeyRequest))
+		return srv.(HeadscaleServiceServer).GetPolicy(ctx, req.(*GetPolicyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ExpirePreAuthKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ExpirePreAuthKeyRequest)
+func _HeadscaleService_SetPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPolicyRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ExpirePreAuthKey(ctx, in)
+		return srv.(HeadscaleServiceServer).SetPolicy(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ExpirePreAuthKey_FullMethodName,
+		FullMethod: HeadscaleService_SetPolicy_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ExpirePreAuthKey(ctx, req.(*ExpirePreAuthKeyRequest))
+		return srv.(HeadscaleServiceServer).SetPolicy(ctx, req.(*SetPolicyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ListPreAuthKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ListPreAuthKeysRequest)
+func _HeadscaleService_AddPolicyGroupMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPolicyGroupMembersRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ListPreAuthKeys(ctx, in)
+		return srv.(HeadscaleServiceServer).AddPolicyGroupMembers(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ListPreAuthKeys_FullMethodName,
+		FullMethod: HeadscaleService_AddPolicyGroupMembers_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ListPreAuthKeys(ctx, req.(*ListPreAuthKeysRequest))
+		return srv.(HeadscaleServiceServer).AddPolicyGroupMembers(ctx, req.(*AddPolicyGroupMembersRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_DebugCreateNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DebugCreateNodeRequest)
+func _HeadscaleService_RemovePolicyGroupMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemovePolicyGroupMembersRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).DebugCreateNode(ctx, in)
+		return srv.(HeadscaleServiceServer).RemovePolicyGroupMembers(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_DebugCreateNode_FullMethodName,
+		FullMethod: HeadscaleService_RemovePolicyGroupMembers_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).DebugCreateNode(ctx, req.(*DebugCreateNodeRequest))
+		return srv.(HeadscaleServiceServer).RemovePolicyGroupMembers(ctx, req.(*RemovePolicyGroupMembersRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_GetNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetNodeRequest)
+func _HeadscaleService_SetPolicyHost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetPolicyHostRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).GetNode(ctx, in)
+		return srv.(HeadscaleServiceServer).SetPolicyHost(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_GetNode_FullMethodName,
+		FullMethod: HeadscaleService_SetPolicyHost_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).GetNode(ctx, req.(*GetNodeRequest))
+		return srv.(HeadscaleServiceServer).SetPolicyHost(ctx, req.(*SetPolicyHostRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_SetTags_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(SetTagsRequest)
+func _HeadscaleService_DeletePolicyHost_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeletePolicyHostRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).SetTags(ctx, in)
+		return srv.(HeadscaleServiceServer).DeletePolicyHost(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_SetTags_FullMethodName,
+		FullMethod: HeadscaleService_DeletePolicyHost_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).SetTags(ctx, req.(*SetTagsRequest))
+		return srv.(HeadscaleServiceServer).DeletePolicyHost(ctx, req.(*DeletePolicyHostRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_RegisterNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(RegisterNodeRequest)
+func _HeadscaleService_GetPolicyStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPolicyStatsRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).RegisterNode(ctx, in)
+		return srv.(HeadscaleServiceServer).GetPolicyStats(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_RegisterNode_FullMethodName,
+		FullMethod: HeadscaleService_GetPolicyStats_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).RegisterNode(ctx, req.(*RegisterNodeRequest))
+		return srv.(HeadscaleServiceServer).GetPolicyStats(ctx, req.(*GetPolicyStatsRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_DeleteNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DeleteNodeRequest)
+func _HeadscaleService_DiffPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DiffPolicyRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).DeleteNode(ctx, in)
+		return srv.(HeadscaleServiceServer).DiffPolicy(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_DeleteNode_FullMethodName,
+		FullMethod: HeadscaleService_DiffPolicy_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).DeleteNode(ctx, req.(*DeleteNodeRequest))
+		return srv.(HeadscaleServiceServer).DiffPolicy(ctx, req.(*DiffPolicyRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ExpireNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ExpireNodeRequest)
+func _HeadscaleService_StartPolicyRollout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartPolicyRolloutRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ExpireNode(ctx, in)
+		return srv.(HeadscaleServiceServer).StartPolicyRollout(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ExpireNode_FullMethodName,
+		FullMethod: HeadscaleService_StartPolicyRollout_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ExpireNode(ctx, req.(*ExpireNodeRequest))
+		return srv.(HeadscaleServiceServer).StartPolicyRollout(ctx, req.(*StartPolicyRolloutRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_RenameNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(RenameNodeRequest)
+func _HeadscaleService_GetPolicyRollout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPolicyRolloutRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).RenameNode(ctx, in)
+		return srv.(HeadscaleServiceServer).GetPolicyRollout(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_RenameNode_FullMethodName,
+		FullMethod: HeadscaleService_GetPolicyRollout_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).RenameNode(ctx, req.(*RenameNodeRequest))
+		return srv.(HeadscaleServiceServer).GetPolicyRollout(ctx, req.(*GetPolicyRolloutRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ListNodes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ListNodesRequest)
+func _HeadscaleService_PromotePolicyRollout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PromotePolicyRolloutRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ListNodes(ctx, in)
+		return srv.(HeadscaleServiceServer).PromotePolicyRollout(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ListNodes_FullMethodName,
+		FullMethod: HeadscaleService_PromotePolicyRollout_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ListNodes(ctx, req.(*ListNodesRequest))
+		return srv.(HeadscaleServiceServer).PromotePolicyRollout(ctx, req.(*PromotePolicyRolloutRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_MoveNode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(MoveNodeRequest)
+func _HeadscaleService_RollbackPolicyRollout_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RollbackPolicyRolloutRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).MoveNode(ctx, in)
+		return srv.(HeadscaleServiceServer).RollbackPolicyRollout(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_MoveNode_FullMethodName,
+		FullMethod: HeadscaleService_RollbackPolicyRollout_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).MoveNode(ctx, req.(*MoveNodeRequest))
+		return srv.(HeadscaleServiceServer).RollbackPolicyRollout(ctx, req.(*RollbackPolicyRolloutRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_BackfillNodeIPs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(BackfillNodeIPsRequest)
+func _HeadscaleService_DatabaseGC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DatabaseGCRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).BackfillNodeIPs(ctx, in)
+		return srv.(HeadscaleServiceServer).DatabaseGC(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_BackfillNodeIPs_FullMethodName,
+		FullMethod: HeadscaleService_DatabaseGC_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).BackfillNodeIPs(ctx, req.(*BackfillNodeIPsRequest))
+		return srv.(HeadscaleServiceServer).DatabaseGC(ctx, req.(*DatabaseGCRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_GetRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetRoutesRequest)
+func _HeadscaleService_GetLogLevels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetLogLevelsRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).GetRoutes(ctx, in)
+		return srv.(HeadscaleServiceServer).GetLogLevels(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_GetRoutes_FullMethodName,
+		FullMethod: HeadscaleService_GetLogLevels_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).GetRoutes(ctx, req.(*GetRoutesRequest))
+		return srv.(HeadscaleServiceServer).GetLogLevels(ctx, req.(*GetLogLevelsRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_EnableRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(EnableRouteRequest)
+func _HeadscaleService_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetLogLevelRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).EnableRoute(ctx, in)
+		return srv.(HeadscaleServiceServer).SetLogLevel(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_EnableRoute_FullMethodName,
+		FullMethod: HeadscaleService_SetLogLevel_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).EnableRoute(ctx, req.(*EnableRouteRequest))
+		return srv.(HeadscaleServiceServer).SetLogLevel(ctx, req.(*SetLogLevelRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_DisableRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DisableRouteRequest)
+func _HeadscaleService_Search_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).DisableRoute(ctx, in)
+		return srv.(HeadscaleServiceServer).Search(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_DisableRoute_FullMethodName,
+		FullMethod: HeadscaleService_Search_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).DisableRoute(ctx, req.(*DisableRouteRequest))
+		return srv.(HeadscaleServiceServer).Search(ctx, req.(*SearchRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_GetNodeRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetNodeRoutesRequest)
+func _HeadscaleService_ListDERPClients_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDERPClientsRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).GetNodeRoutes(ctx, in)
+		return srv.(HeadscaleServiceServer).ListDERPClients(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_GetNodeRoutes_FullMethodName,
+		FullMethod: HeadscaleService_ListDERPClients_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).GetNodeRoutes(ctx, req.(*GetNodeRoutesRequest))
+		return srv.(HeadscaleServiceServer).ListDERPClients(ctx, req.(*ListDERPClientsRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_DeleteRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DeleteRouteRequest)
+func _HeadscaleService_RegisterDERPRelay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterDERPRelayRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).DeleteRoute(ctx, in)
+		return srv.(HeadscaleServiceServer).RegisterDERPRelay(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_DeleteRoute_FullMethodName,
+		FullMethod: HeadscaleService_RegisterDERPRelay_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).DeleteRoute(ctx, req.(*DeleteRouteRequest))
+		return srv.(HeadscaleServiceServer).RegisterDERPRelay(ctx, req.(*RegisterDERPRelayRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_CreateApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(CreateApiKeyRequest)
+func _HeadscaleService_ListDERPRelays_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDERPRelaysRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).CreateApiKey(ctx, in)
+		return srv.(HeadscaleServiceServer).ListDERPRelays(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_CreateApiKey_FullMethodName,
+		FullMethod: HeadscaleService_ListDERPRelays_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).CreateApiKey(ctx, req.(*CreateApiKeyRequest))
+		return srv.(HeadscaleServiceServer).ListDERPRelays(ctx, req.(*ListDERPRelaysRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ExpireApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ExpireApiKeyRequest)
+func _HeadscaleService_DeleteDERPRelay_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDERPRelayRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ExpireApiKey(ctx, in)
+		return srv.(HeadscaleServiceServer).DeleteDERPRelay(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ExpireApiKey_FullMethodName,
+		FullMethod: HeadscaleService_DeleteDERPRelay_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ExpireApiKey(ctx, req.(*ExpireApiKeyRequest))
+		return srv.(HeadscaleServiceServer).DeleteDERPRelay(ctx, req.(*DeleteDERPRelayRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_ListApiKeys_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(ListApiKeysRequest)
+func _HeadscaleService_CreateServiceAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateServiceAccountRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).ListApiKeys(ctx, in)
+		return srv.(HeadscaleServiceServer).CreateServiceAccount(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_ListApiKeys_FullMethodName,
+		FullMethod: HeadscaleService_CreateServiceAccount_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).ListApiKeys(ctx, req.(*ListApiKeysRequest))
+		return srv.(HeadscaleServiceServer).CreateServiceAccount(ctx, req.(*CreateServiceAccountRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_DeleteApiKey_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(DeleteApiKeyRequest)
+func _HeadscaleService_ListServiceAccounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListServiceAccountsRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).DeleteApiKey(ctx, in)
+		return srv.(HeadscaleServiceServer).ListServiceAccounts(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_DeleteApiKey_FullMethodName,
+		FullMethod: HeadscaleService_ListServiceAccounts_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).DeleteApiKey(ctx, req.(*DeleteApiKeyRequest))
+		return srv.(HeadscaleServiceServer).ListServiceAccounts(ctx, req.(*ListServiceAccountsRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_GetPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(GetPolicyRequest)
+func _HeadscaleService_DeleteServiceAccount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteServiceAccountRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).GetPolicy(ctx, in)
+		return srv.(HeadscaleServiceServer).DeleteServiceAccount(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_GetPolicy_FullMethodName,
+		FullMethod: HeadscaleService_DeleteServiceAccount_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).GetPolicy(ctx, req.(*GetPolicyRequest))
+		return srv.(HeadscaleServiceServer).DeleteServiceAccount(ctx, req.(*DeleteServiceAccountRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
 
-func _HeadscaleService_SetPolicy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
-	in := new(SetPolicyRequest)
+func _HeadscaleService_ImportTailnet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ImportTailnetRequest)
 	if err := dec(in); err != nil {
 		return nil, err
 	}
 	if interceptor == nil {
-		return srv.(HeadscaleServiceServer).SetPolicy(ctx, in)
+		return srv.(HeadscaleServiceServer).ImportTailnet(ctx, in)
 	}
 	info := &grpc.UnaryServerInfo{
 		Server:     srv,
-		FullMethod: HeadscaleService_SetPolicy_FullMethodName,
+		FullMethod: HeadscaleService_ImportTailnet_FullMethodName,
 	}
 	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
-		return srv.(HeadscaleServiceServer).SetPolicy(ctx, req.(*SetPolicyRequest))
+		return srv.(HeadscaleServiceServer).ImportTailnet(ctx, req.(*ImportTailnetRequest))
 	}
 	return interceptor(ctx, in, info, handler)
 }
@@ -1055,6 +2519,26 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "ListUsers",
 			Handler:    _HeadscaleService_ListUsers_Handler,
 		},
+		{
+			MethodName: "SuspendUser",
+			Handler:    _HeadscaleService_SuspendUser_Handler,
+		},
+		{
+			MethodName: "ResumeUser",
+			Handler:    _HeadscaleService_ResumeUser_Handler,
+		},
+		{
+			MethodName: "ListUserAliases",
+			Handler:    _HeadscaleService_ListUserAliases_Handler,
+		},
+		{
+			MethodName: "SetUserPassword",
+			Handler:    _HeadscaleService_SetUserPassword_Handler,
+		},
+		{
+			MethodName: "SetUserTOTP",
+			Handler:    _HeadscaleService_SetUserTOTP_Handler,
+		},
 		{
 			MethodName: "CreatePreAuthKey",
 			Handler:    _HeadscaleService_CreatePreAuthKey_Handler,
@@ -1071,6 +2555,14 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DebugCreateNode",
 			Handler:    _HeadscaleService_DebugCreateNode_Handler,
 		},
+		{
+			MethodName: "DebugConnectivityMatrix",
+			Handler:    _HeadscaleService_DebugConnectivityMatrix_Handler,
+		},
+		{
+			MethodName: "DebugProfile",
+			Handler:    _HeadscaleService_DebugProfile_Handler,
+		},
 		{
 			MethodName: "GetNode",
 			Handler:    _HeadscaleService_GetNode_Handler,
@@ -1079,6 +2571,14 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SetTags",
 			Handler:    _HeadscaleService_SetTags_Handler,
 		},
+		{
+			MethodName: "AddTag",
+			Handler:    _HeadscaleService_AddTag_Handler,
+		},
+		{
+			MethodName: "RemoveTag",
+			Handler:    _HeadscaleService_RemoveTag_Handler,
+		},
 		{
 			MethodName: "RegisterNode",
 			Handler:    _HeadscaleService_RegisterNode_Handler,
@@ -1095,6 +2595,26 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RenameNode",
 			Handler:    _HeadscaleService_RenameNode_Handler,
 		},
+		{
+			MethodName: "RotateNodeKey",
+			Handler:    _HeadscaleService_RotateNodeKey_Handler,
+		},
+		{
+			MethodName: "RevokeNode",
+			Handler:    _HeadscaleService_RevokeNode_Handler,
+		},
+		{
+			MethodName: "SetNodeNote",
+			Handler:    _HeadscaleService_SetNodeNote_Handler,
+		},
+		{
+			MethodName: "SetNodeDERPHome",
+			Handler:    _HeadscaleService_SetNodeDERPHome_Handler,
+		},
+		{
+			MethodName: "SetNodeService",
+			Handler:    _HeadscaleService_SetNodeService_Handler,
+		},
 		{
 			MethodName: "ListNodes",
 			Handler:    _HeadscaleService_ListNodes_Handler,
@@ -1107,6 +2627,22 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "BackfillNodeIPs",
 			Handler:    _HeadscaleService_BackfillNodeIPs_Handler,
 		},
+		{
+			MethodName: "ListNodeStats",
+			Handler:    _HeadscaleService_ListNodeStats_Handler,
+		},
+		{
+			MethodName: "GetNodeNetcheck",
+			Handler:    _HeadscaleService_GetNodeNetcheck_Handler,
+		},
+		{
+			MethodName: "NodeC2N",
+			Handler:    _HeadscaleService_NodeC2N_Handler,
+		},
+		{
+			MethodName: "PreviewNodeFQDN",
+			Handler:    _HeadscaleService_PreviewNodeFQDN_Handler,
+		},
 		{
 			MethodName: "GetRoutes",
 			Handler:    _HeadscaleService_GetRoutes_Handler,
@@ -1127,6 +2663,22 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteRoute",
 			Handler:    _HeadscaleService_DeleteRoute_Handler,
 		},
+		{
+			MethodName: "GetEffectiveRoutes",
+			Handler:    _HeadscaleService_GetEffectiveRoutes_Handler,
+		},
+		{
+			MethodName: "EnablePrefixRoutes",
+			Handler:    _HeadscaleService_EnablePrefixRoutes_Handler,
+		},
+		{
+			MethodName: "DisablePrefixRoutes",
+			Handler:    _HeadscaleService_DisablePrefixRoutes_Handler,
+		},
+		{
+			MethodName: "GetRouteHistory",
+			Handler:    _HeadscaleService_GetRouteHistory_Handler,
+		},
 		{
 			MethodName: "CreateApiKey",
 			Handler:    _HeadscaleService_CreateApiKey_Handler,
@@ -1151,6 +2703,94 @@ var HeadscaleService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "SetPolicy",
 			Handler:    _HeadscaleService_SetPolicy_Handler,
 		},
+		{
+			MethodName: "AddPolicyGroupMembers",
+			Handler:    _HeadscaleService_AddPolicyGroupMembers_Handler,
+		},
+		{
+			MethodName: "RemovePolicyGroupMembers",
+			Handler:    _HeadscaleService_RemovePolicyGroupMembers_Handler,
+		},
+		{
+			MethodName: "SetPolicyHost",
+			Handler:    _HeadscaleService_SetPolicyHost_Handler,
+		},
+		{
+			MethodName: "DeletePolicyHost",
+			Handler:    _HeadscaleService_DeletePolicyHost_Handler,
+		},
+		{
+			MethodName: "GetPolicyStats",
+			Handler:    _HeadscaleService_GetPolicyStats_Handler,
+		},
+		{
+			MethodName: "DiffPolicy",
+			Handler:    _HeadscaleService_DiffPolicy_Handler,
+		},
+		{
+			MethodName: "StartPolicyRollout",
+			Handler:    _HeadscaleService_StartPolicyRollout_Handler,
+		},
+		{
+			MethodName: "GetPolicyRollout",
+			Handler:    _HeadscaleService_GetPolicyRollout_Handler,
+		},
+		{
+			MethodName: "PromotePolicyRollout",
+			Handler:    _HeadscaleService_PromotePolicyRollout_Handler,
+		},
+		{
+			MethodName: "RollbackPolicyRollout",
+			Handler:    _HeadscaleService_RollbackPolicyRollout_Handler,
+		},
+		{
+			MethodName: "DatabaseGC",
+			Handler:    _HeadscaleService_DatabaseGC_Handler,
+		},
+		{
+			MethodName: "GetLogLevels",
+			Handler:    _HeadscaleService_GetLogLevels_Handler,
+		},
+		{
+			MethodName: "SetLogLevel",
+			Handler:    _HeadscaleService_SetLogLevel_Handler,
+		},
+		{
+			MethodName: "Search",
+			Handler:    _HeadscaleService_Search_Handler,
+		},
+		{
+			MethodName: "ListDERPClients",
+			Handler:    _HeadscaleService_ListDERPClients_Handler,
+		},
+		{
+			MethodName: "RegisterDERPRelay",
+			Handler:    _HeadscaleService_RegisterDERPRelay_Handler,
+		},
+		{
+			MethodName: "ListDERPRelays",
+			Handler:    _HeadscaleService_ListDERPRelays_Handler,
+		},
+		{
+			MethodName: "DeleteDERPRelay",
+			Handler:    _HeadscaleService_DeleteDERPRelay_Handler,
+		},
+		{
+			MethodName: "CreateServiceAccount",
+			Handler:    _HeadscaleService_CreateServiceAccount_Handler,
+		},
+		{
+			MethodName: "ListServiceAccounts",
+			Handler:    _HeadscaleService_ListServiceAccounts_Handler,
+		},
+		{
+			MethodName: "DeleteServiceAccount",
+			Handler:    _HeadscaleService_DeleteServiceAccount_Handler,
+		},
+		{
+			MethodName: "ImportTailnet",
+			Handler:    _HeadscaleService_ImportTailnet_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "headscale/v1/headscale.proto",
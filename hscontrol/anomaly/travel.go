@@ -0,0 +1,141 @@
+// Package anomaly detects suspicious changes in how nodes connect to
+// headscale, like a node appearing in two distant places faster than
+// it is possible to travel between them.
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/geoip"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+const earthRadiusKm = 6371.0
+
+// Observation is a public address a node was seen at, and when.
+type Observation struct {
+	Addr     netip.Addr
+	Location geoip.Location
+	Time     time.Time
+}
+
+// TravelAlert describes a node that moved between two observations
+// faster than the configured maximum speed.
+type TravelAlert struct {
+	Type       string    `json:"type"`
+	NodeID     uint64    `json:"node_id"`
+	Hostname   string    `json:"hostname"`
+	User       string    `json:"user"`
+	From       string    `json:"from"`
+	FromAddr   string    `json:"from_addr"`
+	To         string    `json:"to"`
+	ToAddr     string    `json:"to_addr"`
+	DistanceKm float64   `json:"distance_km"`
+	Elapsed    string    `json:"elapsed"`
+	SpeedKmh   float64   `json:"speed_kmh"`
+	Quarantine bool      `json:"quarantine"`
+	Time       time.Time `json:"time"`
+}
+
+// DistanceKm returns the great-circle distance between two locations
+// in kilometres.
+func DistanceKm(a, b geoip.Location) float64 {
+	lat1 := a.Latitude * math.Pi / 180
+	lat2 := b.Latitude * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// CheckTravel compares two consecutive observations of a node and
+// returns an alert if the node would have needed to travel faster than
+// cfg allows. Observations without coordinates, or closer than
+// cfg.MinDistanceKm, never raise an alert, as geolocation of nearby
+// addresses is too imprecise.
+func CheckTravel(
+	cfg types.AnomalyDetectionConfig,
+	node *types.Node,
+	prev, cur Observation,
+) (*TravelAlert, bool) {
+	if !prev.Location.HasCoordinates || !cur.Location.HasCoordinates {
+		return nil, false
+	}
+
+	distance := DistanceKm(prev.Location, cur.Location)
+	if distance < cfg.MinDistanceKm {
+		return nil, false
+	}
+
+	elapsed := cur.Time.Sub(prev.Time)
+	if elapsed < 0 {
+		return nil, false
+	}
+
+	// Treat anything faster than a minute as a minute, reconnects are
+	// not instant and it avoids dividing by zero.
+	hours := max(elapsed, time.Minute).Hours()
+	speed := distance / hours
+	if speed <= cfg.MaxSpeedKmh {
+		return nil, false
+	}
+
+	return &TravelAlert{
+		Type:       "impossible_travel",
+		NodeID:     node.ID.Uint64(),
+		Hostname:   node.Hostname,
+		User:       node.User.Name,
+		From:       prev.Location.String(),
+		FromAddr:   prev.Addr.String(),
+		To:         cur.Location.String(),
+		ToAddr:     cur.Addr.String(),
+		DistanceKm: math.Round(distance),
+		Elapsed:    elapsed.Round(time.Second).String(),
+		SpeedKmh:   math.Round(speed),
+		Quarantine: cfg.Quarantine,
+		Time:       cur.Time,
+	}, true
+}
+
+// SendWebhook posts the alert as JSON to url.
+func SendWebhook(ctx context.Context, url string, alert *TravelAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, types.HTTPTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := http.Client{
+		Timeout: types.HTTPTimeout,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("anomaly webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package anomaly
+
+import (
+	"math"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/geoip"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+var (
+	oslo = geoip.Location{
+		City: "Oslo", Country: "Norway",
+		Latitude: 59.91, Longitude: 10.75, HasCoordinates: true,
+	}
+	bergen = geoip.Location{
+		City: "Bergen", Country: "Norway",
+		Latitude: 60.39, Longitude: 5.32, HasCoordinates: true,
+	}
+	sydney = geoip.Location{
+		City: "Sydney", Country: "Australia",
+		Latitude: -33.87, Longitude: 151.21, HasCoordinates: true,
+	}
+)
+
+func TestDistanceKm(t *testing.T) {
+	got := DistanceKm(oslo, sydney)
+	if math.Abs(got-15950) > 100 {
+		t.Errorf("DistanceKm(oslo, sydney) = %f, want about 15950", got)
+	}
+
+	if got := DistanceKm(oslo, oslo); got != 0 {
+		t.Errorf("DistanceKm(oslo, oslo) = %f, want 0", got)
+	}
+}
+
+func TestCheckTravel(t *testing.T) {
+	cfg := types.AnomalyDetectionConfig{
+		Enabled:       true,
+		MaxSpeedKmh:   1000,
+		MinDistanceKm: 500,
+	}
+	node := &types.Node{ID: 1, Hostname: "laptop"}
+	now := time.Now()
+
+	obs := func(loc geoip.Location, at time.Time) Observation {
+		return Observation{
+			Addr:     netip.MustParseAddr("192.0.2.1"),
+			Location: loc,
+			Time:     at,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		prev, cur Observation
+		wantAlert bool
+	}{
+		{
+			name:      "oslo-sydney-one-hour",
+			prev:      obs(oslo, now.Add(-time.Hour)),
+			cur:       obs(sydney, now),
+			wantAlert: true,
+		},
+		{
+			name: "oslo-sydney-two-days",
+			prev: obs(oslo, now.Add(-48*time.Hour)),
+			cur:  obs(sydney, now),
+		},
+		{
+			name: "oslo-bergen-below-min-distance",
+			prev: obs(oslo, now.Add(-time.Second)),
+			cur:  obs(bergen, now),
+		},
+		{
+			name: "no-coordinates",
+			prev: obs(geoip.Location{CountryCode: "NO"}, now.Add(-time.Second)),
+			cur:  obs(sydney, now),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alert, ok := CheckTravel(cfg, node, tt.prev, tt.cur)
+			if ok != tt.wantAlert {
+				t.Fatalf("CheckTravel() = %t, want %t", ok, tt.wantAlert)
+			}
+
+			if ok && (alert.NodeID != 1 || alert.To != "Sydney, Australia") {
+				t.Errorf("unexpected alert %+v", alert)
+			}
+		})
+	}
+}
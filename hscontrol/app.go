@@ -4,31 +4,40 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	_ "net/http/pprof" // nolint
+	"net/netip"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/gorilla/mux"
 	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpcRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/juanfont/headscale"
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/anomaly"
+	"github.com/juanfont/headscale/hscontrol/backup"
 	"github.com/juanfont/headscale/hscontrol/db"
 	"github.com/juanfont/headscale/hscontrol/derp"
 	derpServer "github.com/juanfont/headscale/hscontrol/derp/server"
+	"github.com/juanfont/headscale/hscontrol/geoip"
+	"github.com/juanfont/headscale/hscontrol/ldapauth"
 	"github.com/juanfont/headscale/hscontrol/mapper"
+	"github.com/juanfont/headscale/hscontrol/mapsign"
 	"github.com/juanfont/headscale/hscontrol/notifier"
 	"github.com/juanfont/headscale/hscontrol/policy"
 	"github.com/juanfont/headscale/hscontrol/types"
@@ -41,15 +50,13 @@ import (
 	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
-	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
-	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 	"gorm.io/gorm"
 	"tailscale.com/envknob"
@@ -87,20 +94,73 @@ type Headscale struct {
 	noisePrivateKey *key.MachinePrivate
 	ephemeralGC     *db.EphemeralGarbageCollector
 
-	DERPMap    *tailcfg.DERPMap
+	// derpMap is the DERPMap sent to the nodes, replaced as a whole
+	// when it is updated.
+	derpMap    atomic.Pointer[tailcfg.DERPMap]
 	DERPServer *derpServer.DERPServer
 
+	// derpMapMu serialises the updates of derpMap, so an update built
+	// from older relays or sources does not replace a newer one.
+	derpMapMu sync.Mutex
+
+	// reloadMu serialises the changes to the settings that can be
+	// reloaded, see types.ReloadableConfig.
+	reloadMu sync.Mutex
+
 	ACLPolicy *policy.ACLPolicy
 
+	// loadedPolicy describes ACLPolicy for the health checks.
+	loadedPolicy atomic.Pointer[loadedPolicy]
+
+	// policyUpdateMu serialises changes to the policy stored in the
+	// database, so edits are applied to the latest version.
+	policyUpdateMu sync.Mutex
+
+	// policyRollout is the policy rolled out to canary nodes, nil when
+	// no rollout is in progress.
+	policyRollout atomic.Pointer[policyRollout]
+
+	policyRuleUsage policyRuleUsage
+
+	// warnedUserAliases holds the old user names the policy has been
+	// warned about, see policy.ACLPolicy.WarnedUserAliases.
+	warnedUserAliases sync.Map
+
+	c2nRequests c2nRequests
+	dnsHealth   dnsHealth
+	nodeHealth  nodeHealth
+	oidcHealth  oidcHealth
+	readiness   readiness
+	grpcHealth  *health.Server
+
 	mapper       *mapper.Mapper
 	nodeNotifier *notifier.Notifier
 
-	oidcProvider *oidc.Provider
-	oauth2Config *oauth2.Config
+	oidcProviders   []*oidcProvider
+	oidcDeviceFlows oidcDeviceFlows
 
 	registrationCache *cache.Cache
 
+	// geoip is nil if no geoip database is configured.
+	geoip *geoip.Locator
+
+	// backup is nil if scheduled backups are disabled.
+	backup *backup.Backuper
+
+	// replica is nil unless headscale runs as a disaster recovery
+	// replica of another headscale.
+	replica *replica
+
+	// ldap is nil if no LDAP server is configured.
+	ldap              *ldapauth.Client
+	directoryGroups   policy.Groups
+	directoryGroupsMu sync.RWMutex
+
 	pollNetMapStreamWG sync.WaitGroup
+
+	// activatedListeners are the sockets passed by systemd socket
+	// activation that have not been taken by a configured listener.
+	activatedListeners []net.Listener
 }
 
 var (
@@ -136,14 +196,32 @@ func NewHeadscale(cfg *types.Config) (*Headscale, error) {
 		nodeNotifier:       notifier.NewNotifier(cfg),
 	}
 
+	if cfg.GRPCHealth {
+		app.grpcHealth = newGRPCHealthServer()
+	}
+
+	if cfg.Replica.Enabled {
+		app.replica = newReplica(cfg.Replica)
+	}
+
 	app.db, err = db.NewHeadscaleDatabase(
 		cfg.Database,
 		cfg.BaseDomain)
 	if err != nil {
 		return nil, err
 	}
+	app.readiness.complete(startupDatabase)
 
-	app.ipAlloc, err = db.NewIPAllocator(app.db, cfg.PrefixV4, cfg.PrefixV6, cfg.IPAllocation)
+	if err := app.restorePendingRegistrations(); err != nil {
+		return nil, fmt.Errorf("restoring pending registrations: %w", err)
+	}
+
+	app.ipAlloc, err = db.NewIPAllocator(
+		app.db,
+		cfg.IPFamilies.Enabled(cfg.PrefixV4),
+		cfg.IPFamilies.Enabled(cfg.PrefixV6),
+		cfg.IPAllocation,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -154,7 +232,7 @@ func NewHeadscale(cfg *types.Config) (*Headscale, error) {
 		}
 	})
 
-	if cfg.OIDC.Issuer != "" {
+	if len(cfg.OIDC.Providers()) > 0 {
 		err = app.initOIDC()
 		if err != nil {
 			if cfg.OIDC.OnlyStartIfOIDCIsAvailable {
@@ -165,23 +243,23 @@ func NewHeadscale(cfg *types.Config) (*Headscale, error) {
 		}
 	}
 
-	if app.cfg.DNSConfig != nil && app.cfg.DNSConfig.Proxied { // if MagicDNS
-		// TODO(kradalby): revisit why this takes a list.
+	if cfg.LDAP.Enabled() {
+		app.ldap = ldapauth.New(cfg.LDAP)
+	}
 
-		var magicDNSDomains []dnsname.FQDN
-		if cfg.PrefixV4 != nil {
-			magicDNSDomains = append(magicDNSDomains, util.GenerateIPv4DNSRootDomain(*cfg.PrefixV4)...)
-		}
-		if cfg.PrefixV6 != nil {
-			magicDNSDomains = append(magicDNSDomains, util.GenerateIPv6DNSRootDomain(*cfg.PrefixV6)...)
+	if cfg.Backup.Enabled() {
+		app.backup, err = backup.New(context.Background(), cfg.Backup, cfg.Database)
+		if err != nil {
+			return nil, fmt.Errorf("setting up backups: %w", err)
 		}
+	}
 
-		// we might have routes already from Split DNS
-		if app.cfg.DNSConfig.Routes == nil {
-			app.cfg.DNSConfig.Routes = make(map[string][]*dnstype.Resolver)
-		}
-		for _, d := range magicDNSDomains {
-			app.cfg.DNSConfig.Routes[d.WithoutTrailingDot()] = nil
+	addMagicDNSRoutes(app.cfg)
+
+	if cfg.GeoIP.DatabasePath != "" {
+		app.geoip, err = geoip.Open(cfg.GeoIP.DatabasePath)
+		if err != nil {
+			return nil, err
 		}
 	}
 
@@ -207,11 +285,122 @@ func NewHeadscale(cfg *types.Config) (*Headscale, error) {
 			return nil, err
 		}
 		app.DERPServer = embeddedDERPServer
+
+		if len(cfg.DERP.RateLimits) > 0 {
+			embeddedDERPServer.SetRateLimitFunc(app.derpRateLimit)
+		}
+		embeddedDERPServer.SetBanFunc(app.derpBanned)
 	}
 
 	return &app, nil
 }
 
+// addMagicDNSRoutes routes the reverse DNS zones of the tailnet prefixes
+// to MagicDNS when it is enabled.
+func addMagicDNSRoutes(cfg *types.Config) {
+	if cfg.DNSConfig == nil || !cfg.DNSConfig.Proxied { // if MagicDNS
+		return
+	}
+
+	// TODO(kradalby): revisit why this takes a list.
+
+	var magicDNSDomains []dnsname.FQDN
+	if prefix := cfg.IPFamilies.Enabled(cfg.PrefixV4); prefix != nil {
+		magicDNSDomains = append(magicDNSDomains, util.GenerateIPv4DNSRootDomain(*prefix)...)
+	}
+	if prefix := cfg.IPFamilies.Enabled(cfg.PrefixV6); prefix != nil {
+		magicDNSDomains = append(magicDNSDomains, util.GenerateIPv6DNSRootDomain(*prefix)...)
+	}
+
+	// we might have routes already from Split DNS
+	if cfg.DNSConfig.Routes == nil {
+		cfg.DNSConfig.Routes = make(map[string][]*dnstype.Resolver)
+	}
+	for _, d := range magicDNSDomains {
+		cfg.DNSConfig.Routes[d.WithoutTrailingDot()] = nil
+	}
+}
+
+// derpRateLimit looks up the node connecting to the embedded DERP server
+// and returns the first configured rate limit matching it.
+func (h *Headscale) derpRateLimit(nodeKey key.NodePublic) *types.DERPRateLimit {
+	node, err := h.db.GetNodeByNodeKey(nodeKey)
+	if err != nil {
+		log.Trace().
+			Caller().
+			Err(err).
+			Str("node_key", nodeKey.ShortString()).
+			Msg("DERP client is not a known node, not rate limiting")
+
+		return nil
+	}
+
+	limit := h.cfg.DERP.RateLimitFor(node, h.nodeTags(node))
+	if limit != nil {
+		log.Debug().
+			Caller().
+			Uint64("node.id", node.ID.Uint64()).
+			Int("bits_per_second", limit.BitsPerSecond).
+			Msg("Rate limiting DERP connection")
+	}
+
+	return limit
+}
+
+// derpBanned returns whether the node key connecting to the embedded
+// DERP server has been revoked, or belongs to a node the
+// derpRestrictions of the policy do not allow to relay through it.
+func (h *Headscale) derpBanned(nodeKey key.NodePublic) bool {
+	revoked, err := h.db.IsKeyRevoked(nodeKey.String())
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("node_key", nodeKey.ShortString()).
+			Msg("Cannot check whether the DERP client is banned")
+
+		return false
+	}
+	if revoked {
+		return true
+	}
+
+	pol := h.ACLPolicy
+	if pol == nil || len(pol.DERPRestrictions) == 0 {
+		return false
+	}
+
+	node, err := h.db.GetNodeByNodeKey(nodeKey)
+	if err != nil {
+		return false
+	}
+
+	regions, restricted, err := pol.DERPRegions(node)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("node_key", nodeKey.ShortString()).
+			Msg("Cannot check whether the DERP client may relay through the embedded DERP server")
+
+		return false
+	}
+
+	return restricted && !slices.Contains(regions, h.cfg.DERP.ServerRegionID)
+}
+
+// nodeTags returns the forced tags of the node and the requested tags
+// the policy allows it to have.
+func (h *Headscale) nodeTags(node *types.Node) []string {
+	tags := slices.Clone(node.ForcedTags)
+	if h.ACLPolicy != nil {
+		validTags, _ := h.ACLPolicy.TagsOfNode(node)
+		tags = append(tags, validTags...)
+	}
+
+	return tags
+}
+
 // Redirect to our TLS url.
 func (h *Headscale) redirect(w http.ResponseWriter, req *http.Request) {
 	target := h.cfg.ServerURL + req.URL.RequestURI()
@@ -252,6 +441,76 @@ func (h *Headscale) expireExpiredNodes(ctx context.Context, every time.Duration)
 	}
 }
 
+// garbageCollect cleans up the database and tells the peers of the
+// ephemeral nodes it removed.
+func (h *Headscale) garbageCollect() (*db.GCResult, error) {
+	result, err := h.db.GarbageCollect(
+		h.cfg.Database.GC,
+		h.cfg.EphemeralNodeInactivityTimeout,
+		h.nodeNotifier.IsConnected,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.EphemeralNodes) > 0 {
+		ctx := types.NotifyCtx(context.Background(), "db-gc", "na")
+		h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type:    types.StatePeerRemoved,
+			Removed: result.EphemeralNodes,
+		})
+	}
+
+	log.Info().
+		Int("ephemeral_nodes", len(result.EphemeralNodes)).
+		Int64("orphaned_routes", result.OrphanedRoutes).
+		Int64("deleted_routes", result.DeletedRoutes).
+		Int64("orphaned_pre_auth_tags", result.OrphanedPreAuthTags).
+		Int64("pre_auth_keys", result.PreAuthKeys).
+		Int64("api_keys", result.APIKeys).
+		Int64("netcheck_reports", result.NetcheckReports).
+		Int64("user_aliases", result.UserAliases).
+		Int64("route_events", result.RouteEvents).
+		Int64("pending_registrations", result.PendingRegistrations).
+		Msg("Database garbage collection completed")
+
+	return result, nil
+}
+
+func (h *Headscale) scheduledGarbageCollect(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.garbageCollect(); err != nil {
+				log.Error().Err(err).Msg("failed to garbage collect database")
+			}
+		}
+	}
+}
+
+// checkpointDatabase checkpoints the SQLite WAL at the given interval,
+// so it does not grow while wal_autocheckpoint is disabled.
+func (h *Headscale) checkpointDatabase(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.db.Checkpoint(h.cfg.Database.Sqlite.CheckpointMode); err != nil {
+				log.Error().Err(err).Msg("failed to checkpoint database")
+			}
+		}
+	}
+}
+
 // scheduledDERPMapUpdateWorker refreshes the DERPMap stored on the global object
 // at a set interval.
 func (h *Headscale) scheduledDERPMapUpdateWorker(cancelChan <-chan struct{}) {
@@ -267,26 +526,78 @@ func (h *Headscale) scheduledDERPMapUpdateWorker(cancelChan <-chan struct{}) {
 
 		case <-ticker.C:
 			log.Info().Msg("Fetching DERPMap updates")
-			h.DERPMap = derp.GetDERPMap(h.cfg.DERP)
-			if h.cfg.DERP.ServerEnabled && h.cfg.DERP.AutomaticallyAddEmbeddedDerpRegion {
-				region, _ := h.DERPServer.GenerateRegion()
-				h.DERPMap.Regions[region.RegionID] = &region
-			}
-
-			ctx := types.NotifyCtx(context.Background(), "derpmap-update", "na")
-			h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
-				Type:    types.StateDERPUpdated,
-				DERPMap: h.DERPMap,
-			})
+			h.updateDERPMap("derpmap-update")
 		}
 	}
 }
 
+// updateDERPMap fetches the DERPMap from the configured sources, adds
+// the online DERP relays and sends it to all connected nodes. It is
+// called by the scheduled update, reloads, the relay expiry and the
+// API, one at a time.
+func (h *Headscale) updateDERPMap(origin string) {
+	h.derpMapMu.Lock()
+	defer h.derpMapMu.Unlock()
+
+	reloadable := h.cfg.Reloadable()
+	derpCfg := h.cfg.DERP
+	derpCfg.URLs = reloadable.DERPURLs
+	derpCfg.Paths = reloadable.DERPPaths
+
+	derpMap := derp.GetDERPMap(derpCfg)
+	if h.cfg.DERP.ServerEnabled && h.cfg.DERP.AutomaticallyAddEmbeddedDerpRegion {
+		region, _ := h.DERPServer.GenerateRegion()
+		derpMap.Regions[region.RegionID] = &region
+	}
+	h.addDERPRelays(derpMap)
+
+	if len(derpMap.Regions) == 0 {
+		log.Warn().Msg("DERPMap update is empty, keeping the current DERPMap")
+
+		return
+	}
+	h.derpMap.Store(derpMap)
+
+	ctx := types.NotifyCtx(context.Background(), origin, "na")
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type:    types.StateDERPUpdated,
+		DERPMap: derpMap,
+	})
+}
+
 func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 	req interface{},
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (interface{}, error) {
+	if err := h.authenticateGRPC(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// grpcStreamAuthenticationInterceptor requires an API key for streaming
+// calls, like the reflection service, the same way as for unary calls.
+func (h *Headscale) grpcStreamAuthenticationInterceptor(srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := h.authenticateGRPC(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, stream)
+}
+
+// authenticateGRPC checks the API key of a gRPC call. The health
+// service is the only one answering without.
+func (h *Headscale) authenticateGRPC(ctx context.Context, fullMethod string) error {
+	if isGRPCHealthMethod(fullMethod) {
+		return nil
+	}
+
 	// Check if the request is coming from the on-server client.
 	// This is not secure, but it is to maintain maintainability
 	// with the "legacy" database-based client
@@ -301,7 +612,7 @@ func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 
 	meta, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return ctx, status.Errorf(
+		return status.Errorf(
 			codes.InvalidArgument,
 			"Retrieving metadata is failed",
 		)
@@ -309,7 +620,7 @@ func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 
 	authHeader, ok := meta["authorization"]
 	if !ok {
-		return ctx, status.Errorf(
+		return status.Errorf(
 			codes.Unauthenticated,
 			"Authorization token is not supplied",
 		)
@@ -318,7 +629,7 @@ func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 	token := authHeader[0]
 
 	if !strings.HasPrefix(token, AuthPrefix) {
-		return ctx, status.Error(
+		return status.Error(
 			codes.Unauthenticated,
 			`missing "Bearer " prefix in "Authorization" header`,
 		)
@@ -326,7 +637,7 @@ func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 
 	valid, err := h.db.ValidateAPIKey(strings.TrimPrefix(token, AuthPrefix))
 	if err != nil {
-		return ctx, status.Error(codes.Internal, "failed to validate token")
+		return status.Error(codes.Internal, "failed to validate token")
 	}
 
 	if !valid {
@@ -334,12 +645,115 @@ func (h *Headscale) grpcAuthenticationInterceptor(ctx context.Context,
 			Str("client_address", client.Addr.String()).
 			Msg("invalid token")
 
-		return ctx, status.Error(codes.Unauthenticated, "invalid token")
+		return status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	return h.checkAPIKeyScope(ctx, fullMethod)
+}
+
+// tagScopedMethods are the only gRPC methods API keys limited to tags
+// can call. CreateApiKey lets them rotate themselves, it refuses keys
+// broader than the calling key.
+var tagScopedMethods = []string{
+	v1.HeadscaleService_SetTags_FullMethodName,
+	v1.HeadscaleService_AddTag_FullMethodName,
+	v1.HeadscaleService_RemoveTag_FullMethodName,
+	v1.HeadscaleService_CreateApiKey_FullMethodName,
+}
+
+// checkAPIKeyScope refuses calls of API keys limited to tags to other
+// methods than tagScopedMethods, and of API keys limited to a DERP
+// region to other methods than RegisterDERPRelay.
+func (h *Headscale) checkAPIKeyScope(ctx context.Context, fullMethod string) error {
+	apiKey, err := h.apiKeyFromContext(ctx)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	var scope string
+	switch {
+	case apiKey == nil:
+		return nil
+	case apiKey.DERPRegionID != 0:
+		if fullMethod == v1.HeadscaleService_RegisterDERPRelay_FullMethodName {
+			return nil
+		}
+		scope = "a DERP region"
+	case len(apiKey.Tags) != 0:
+		if slices.Contains(tagScopedMethods, fullMethod) {
+			return nil
+		}
+		scope = "tags"
+	default:
+		return nil
+	}
+
+	log.Info().
+		Str("api_key", apiKey.Prefix).
+		Str("method", fullMethod).
+		Msgf("Denied request of an API key limited to %s", scope)
+
+	return status.Errorf(
+		codes.PermissionDenied,
+		"API keys limited to %s cannot call %s",
+		scope,
+		path.Base(fullMethod),
+	)
+}
+
+// apiKeyScopeInterceptor checks the scope of API keys on the unix
+// socket, which serves the REST API through the gRPC gateway.
+func (h *Headscale) apiKeyScopeInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if isGRPCHealthMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	if err := h.checkAPIKeyScope(ctx, info.FullMethod); err != nil {
+		return nil, err
 	}
 
 	return handler(ctx, req)
 }
 
+// apiKeyFromContext returns the API key a gRPC request was authenticated
+// with, or nil if it has none, like requests from the local socket.
+func (h *Headscale) apiKeyFromContext(ctx context.Context) (*types.APIKey, error) {
+	meta, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+
+	authHeader := meta.Get("authorization")
+	if len(authHeader) == 0 || !strings.HasPrefix(authHeader[0], AuthPrefix) {
+		return nil, nil
+	}
+
+	return h.db.GetAPIKeyFromString(strings.TrimPrefix(authHeader[0], AuthPrefix))
+}
+
+// requestActor describes who made a gRPC request: the API key it was
+// authenticated with, or the user of the process calling over the unix
+// socket.
+func (h *Headscale) requestActor(ctx context.Context) string {
+	apiKey, err := h.apiKeyFromContext(ctx)
+	if err == nil && apiKey != nil {
+		return "api key " + apiKey.Prefix
+	}
+
+	if client, ok := peer.FromContext(ctx); ok {
+		if creds, ok := client.AuthInfo.(peerAuthInfo); ok {
+			return fmt.Sprintf("uid %d", creds.UID)
+		}
+	}
+
+	return "local"
+}
+
 func (h *Headscale) httpAuthenticationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(
 		writer http.ResponseWriter,
@@ -410,6 +824,42 @@ func (h *Headscale) httpAuthenticationMiddleware(next http.Handler) http.Handler
 	})
 }
 
+// requireUnscopedAPIKey refuses requests authenticated with API keys
+// limited to tags or to a DERP region, for the endpoints only full API
+// keys may use. It must run after httpAuthenticationMiddleware.
+func (h *Headscale) requireUnscopedAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(
+		writer http.ResponseWriter,
+		req *http.Request,
+	) {
+		apiKey, err := h.db.GetAPIKeyFromString(
+			strings.TrimPrefix(req.Header.Get("authorization"), AuthPrefix),
+		)
+		if err != nil {
+			log.Error().
+				Caller().
+				Err(err).
+				Str("client_address", req.RemoteAddr).
+				Msg("failed to load API key")
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		if apiKey.DERPRegionID != 0 || len(apiKey.Tags) != 0 {
+			log.Info().
+				Str("api_key", apiKey.Prefix).
+				Str("path", req.URL.Path).
+				Msg("Denied request of a scoped API key")
+			http.Error(writer, "Forbidden", http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(writer, req)
+	})
+}
+
 // ensureUnixSocketIsAbsent will check if the given path for headscales unix socket is clear
 // and will remove it if it is not.
 func (h *Headscale) ensureUnixSocketIsAbsent() error {
@@ -425,39 +875,81 @@ func (h *Headscale) createRouter(grpcMux *grpcRuntime.ServeMux) *mux.Router {
 	router := mux.NewRouter()
 	router.Use(prometheusMiddleware)
 
-	router.HandleFunc(ts2021UpgradePath, h.NoiseUpgradeHandler).Methods(http.MethodPost)
+	router.HandleFunc(ts2021UpgradePath, h.rejectWhileStandby(h.NoiseUpgradeHandler)).Methods(http.MethodPost)
 
 	router.HandleFunc("/health", h.HealthHandler).Methods(http.MethodGet)
+	router.HandleFunc("/ready", h.ReadyHandler).Methods(http.MethodGet)
+	router.HandleFunc("/live", h.LiveHandler).Methods(http.MethodGet)
 	router.HandleFunc("/key", h.KeyHandler).Methods(http.MethodGet)
+	router.HandleFunc("/derpmap/default", h.DERPMapHandler).Methods(http.MethodGet)
+	router.HandleFunc(policy.SchemaPath, h.PolicySchemaHandler).Methods(http.MethodGet)
 	router.HandleFunc("/register/{mkey}", h.RegisterWebAPI).Methods(http.MethodGet)
+	router.HandleFunc("/register/{mkey}", h.RegisterLocalAuth).Methods(http.MethodPost)
 
 	router.HandleFunc("/oidc/register/{mkey}", h.RegisterOIDC).Methods(http.MethodGet)
 	router.HandleFunc("/oidc/callback", h.OIDCCallback).Methods(http.MethodGet)
+	router.HandleFunc("/oidc/device/{id}", h.OIDCDeviceCode).Methods(http.MethodGet)
+	router.HandleFunc("/register/verify/{token}", h.RegistrationVerification).
+		Methods(http.MethodGet, http.MethodPost)
 	router.HandleFunc("/apple", h.AppleConfigMessage).Methods(http.MethodGet)
 	router.HandleFunc("/apple/{platform}", h.ApplePlatformConfig).
 		Methods(http.MethodGet)
 	router.HandleFunc("/windows", h.WindowsConfigMessage).Methods(http.MethodGet)
+	router.HandleFunc(ClientPolicyPath, h.ClientPolicyHandler).Methods(http.MethodGet)
+	router.HandleFunc(ClientPolicyWindowsPath, h.ClientPolicyWindowsHandler).Methods(http.MethodGet)
+
+	if h.cfg.Portal.Enabled {
+		router.HandleFunc("/portal", h.PortalHandler).Methods(http.MethodGet)
+		router.HandleFunc("/portal/login", h.PortalLogin).Methods(http.MethodPost)
+		router.HandleFunc("/portal/oidc", h.PortalOIDC).Methods(http.MethodGet)
+		router.HandleFunc("/portal/logout", h.PortalLogout).Methods(http.MethodPost)
+		router.HandleFunc("/portal/nodes/{id}/rename", h.PortalRenameNode).
+			Methods(http.MethodPost)
+		router.HandleFunc("/portal/nodes/{id}/expire", h.PortalExpireNode).
+			Methods(http.MethodPost)
+		router.HandleFunc("/portal/preauthkeys", h.PortalCreatePreAuthKey).
+			Methods(http.MethodPost)
+	}
 
-	// TODO(kristoffer): move swagger into a package
-	router.HandleFunc("/swagger", headscale.SwaggerUI).Methods(http.MethodGet)
-	router.HandleFunc("/swagger/v1/openapiv2.json", headscale.SwaggerAPIv1).
-		Methods(http.MethodGet)
+	// With an exclusive tailnet admin node the API is only served
+	// over the tailnet.
+	if !h.cfg.TailnetAdmin.Exclusive {
+		h.addAPIRoutes(router, grpcMux)
+	}
 
 	if h.cfg.DERP.ServerEnabled {
-		router.HandleFunc("/derp", h.DERPServer.DERPHandler)
-		router.HandleFunc("/derp/probe", derpServer.DERPProbeHandler)
-		router.HandleFunc("/bootstrap-dns", derpServer.DERPBootstrapDNSHandler(h.DERPMap))
+		if !h.cfg.DERP.STUNOnly {
+			router.HandleFunc("/derp", h.DERPServer.DERPHandler)
+			router.HandleFunc("/derp/probe", derpServer.DERPProbeHandler)
+		}
+		router.HandleFunc("/bootstrap-dns", derpServer.DERPBootstrapDNSHandler(h.derpMap.Load()))
 	}
 
-	apiRouter := router.PathPrefix("/api").Subrouter()
-	apiRouter.Use(h.httpAuthenticationMiddleware)
-	apiRouter.PathPrefix("/v1/").HandlerFunc(grpcMux.ServeHTTP)
+	if h.cfg.Debug.ProfilingEnabled {
+		debugRouter := router.PathPrefix("/debug").Subrouter()
+		debugRouter.Use(h.httpAuthenticationMiddleware, h.requireUnscopedAPIKey)
+		debugRouter.PathPrefix("/pprof/").Handler(http.DefaultServeMux)
+		debugRouter.Handle("/vars", expvar.Handler())
+	}
 
 	router.PathPrefix("/").HandlerFunc(notFoundHandler)
 
 	return router
 }
 
+// addAPIRoutes adds the REST API, served by the gRPC gateway, and its
+// documentation to router.
+func (h *Headscale) addAPIRoutes(router *mux.Router, grpcMux *grpcRuntime.ServeMux) {
+	// TODO(kristoffer): move swagger into a package
+	router.HandleFunc("/swagger", headscale.SwaggerUI).Methods(http.MethodGet)
+	router.HandleFunc("/swagger/v1/openapiv2.json", headscale.SwaggerAPIv1).
+		Methods(http.MethodGet)
+
+	apiRouter := router.PathPrefix("/api").Subrouter()
+	apiRouter.Use(h.httpAuthenticationMiddleware)
+	apiRouter.PathPrefix("/v1/").HandlerFunc(grpcMux.ServeHTTP)
+}
+
 // Serve launches the HTTP and gRPC server service Headscale and the API.
 func (h *Headscale) Serve() error {
 	if profilingEnabled {
@@ -478,14 +970,45 @@ func (h *Headscale) Serve() error {
 	if err = h.loadACLPolicy(); err != nil {
 		return fmt.Errorf("failed to load ACL policy: %w", err)
 	}
+	if err = h.loadPolicyRollout(); err != nil {
+		return err
+	}
+	h.readiness.complete(startupPolicy)
+
+	if err = h.registerInventoryMetrics(); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+
+	shutdownTracing, err := setupTracing(h.cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
 
 	if dumpConfig {
 		spew.Dump(h.cfg)
 	}
 
+	h.activatedListeners, err = activatedListeners()
+	if err != nil {
+		return err
+	}
+
 	// Fetch an initial DERP Map before we start serving
-	h.DERPMap = derp.GetDERPMap(h.cfg.DERP)
-	h.mapper = mapper.NewMapper(h.db, h.cfg, h.DERPMap, h.nodeNotifier)
+	derpMap := derp.GetDERPMap(h.cfg.DERP)
+	h.derpMap.Store(derpMap)
+	h.mapper = mapper.NewMapper(h.db, h.cfg, derpMap, h.nodeNotifier)
+
+	if h.cfg.MapSigning.Enabled {
+		signingKey, err := mapsign.LoadOrCreateKey(h.cfg.MapSigning.PrivateKeyPath)
+		if err != nil {
+			return fmt.Errorf("loading map signing key: %w", err)
+		}
+		signer := mapsign.NewSigner(signingKey)
+		h.mapper.SetSigner(signer)
+		log.Info().
+			Str("public_key", signer.PublicKey()).
+			Msg("Signing the DERPMap and DNS configuration of map responses")
+	}
 
 	if h.cfg.DERP.ServerEnabled {
 		// When embedded DERP is enabled we always need a STUN server
@@ -499,38 +1022,124 @@ func (h *Headscale) Serve() error {
 		}
 
 		if h.cfg.DERP.AutomaticallyAddEmbeddedDerpRegion {
-			h.DERPMap.Regions[region.RegionID] = &region
+			derpMap.Regions[region.RegionID] = &region
 		}
 
 		go h.DERPServer.ServeSTUN()
 	}
 
+	h.addDERPRelays(derpMap)
+	if h.cfg.DERP.RelaysEnabled && !h.isReadOnly() {
+		relayCancelChannel := make(chan struct{})
+		defer func() { relayCancelChannel <- struct{}{} }()
+		go h.derpRelayExpiryWorker(relayCancelChannel)
+	}
+
 	if h.cfg.DERP.AutoUpdate {
 		derpMapCancelChannel := make(chan struct{})
 		defer func() { derpMapCancelChannel <- struct{}{} }()
 		go h.scheduledDERPMapUpdateWorker(derpMapCancelChannel)
 	}
 
-	if len(h.DERPMap.Regions) == 0 {
+	if len(derpMap.Regions) == 0 {
 		return errEmptyInitialDERPMap
 	}
+	h.readiness.complete(startupDERPMap)
 
 	// Start ephemeral node garbage collector and schedule all nodes
 	// that are already in the database and ephemeral. If they are still
 	// around between restarts, they will reconnect and the GC will
-	// be cancelled.
+	// be cancelled. A replica leaves them to the primary.
 	go h.ephemeralGC.Start()
-	ephmNodes, err := h.db.ListEphemeralNodes()
-	if err != nil {
-		return fmt.Errorf("failed to list ephemeral nodes: %w", err)
+	if !h.isReadOnly() {
+		ephmNodes, err := h.db.ListEphemeralNodes()
+		if err != nil {
+			return fmt.Errorf("failed to list ephemeral nodes: %w", err)
+		}
+		for _, node := range ephmNodes {
+			h.ephemeralGC.Schedule(node.ID, h.cfg.EphemeralNodeInactivityTimeout)
+		}
 	}
-	for _, node := range ephmNodes {
-		h.ephemeralGC.Schedule(node.ID, h.cfg.EphemeralNodeInactivityTimeout)
+
+	h.warnFQDNCollisions()
+
+	if h.cfg.DNSHealthCheck.Interval > 0 {
+		dnsHealthCtx, dnsHealthCancel := context.WithCancel(context.Background())
+		defer dnsHealthCancel()
+		go h.checkDNSNameserversEvery(dnsHealthCtx, h.cfg.DNSHealthCheck.Interval)
 	}
 
+	// The workers below write to the database, which a replica only
+	// reads.
+	readOnly := h.isReadOnly()
+
 	expireNodeCtx, expireNodeCancel := context.WithCancel(context.Background())
 	defer expireNodeCancel()
-	go h.expireExpiredNodes(expireNodeCtx, updateInterval)
+	if !readOnly {
+		go h.expireExpiredNodes(expireNodeCtx, updateInterval)
+	}
+
+	if h.cfg.Routes.StaleGracePeriod > 0 && !readOnly {
+		staleRoutesCtx, staleRoutesCancel := context.WithCancel(context.Background())
+		defer staleRoutesCancel()
+		go h.withdrawStaleRoutesEvery(staleRoutesCtx, min(h.cfg.Routes.StaleGracePeriod, staleRoutesInterval))
+	}
+
+	if h.cfg.OfflineNodes.After > 0 && !readOnly {
+		offlineNodesCtx, offlineNodesCancel := context.WithCancel(context.Background())
+		defer offlineNodesCancel()
+		go h.reapOfflineNodesEvery(offlineNodesCtx, h.cfg.OfflineNodes.Interval)
+	}
+
+	if h.cfg.Tuning.LastSeenPersistInterval > 0 && !readOnly {
+		lastSeenCtx, lastSeenCancel := context.WithCancel(context.Background())
+		defer lastSeenCancel()
+		go h.persistLastSeen(lastSeenCtx, h.cfg.Tuning.LastSeenPersistInterval)
+	}
+
+	if h.cfg.Database.Type == types.DatabaseSqlite &&
+		h.cfg.Database.Sqlite.WriteAheadLog &&
+		h.cfg.Database.Sqlite.CheckpointInterval > 0 {
+		checkpointCtx, checkpointCancel := context.WithCancel(context.Background())
+		defer checkpointCancel()
+		go h.checkpointDatabase(checkpointCtx, h.cfg.Database.Sqlite.CheckpointInterval)
+	}
+
+	if h.cfg.Database.GC.Interval > 0 && !readOnly {
+		gcCtx, gcCancel := context.WithCancel(context.Background())
+		defer gcCancel()
+		go h.scheduledGarbageCollect(gcCtx, h.cfg.Database.GC.Interval)
+	}
+
+	if h.backup != nil {
+		backupCtx, backupCancel := context.WithCancel(context.Background())
+		defer backupCancel()
+		go h.backup.Run(backupCtx)
+	}
+
+	if len(h.oidcProviders) > 0 && h.cfg.OIDC.AllowlistCheckInterval > 0 && !readOnly {
+		oidcAllowlistCtx, oidcAllowlistCancel := context.WithCancel(context.Background())
+		defer oidcAllowlistCancel()
+		go h.enforceOIDCAllowlists(oidcAllowlistCtx, h.cfg.OIDC.AllowlistCheckInterval)
+	}
+
+	if h.cfg.Policy.StatsInterval > 0 {
+		policyStatsCtx, policyStatsCancel := context.WithCancel(context.Background())
+		defer policyStatsCancel()
+		go h.trackPolicyRuleUsage(policyStatsCtx, h.cfg.Policy.StatsInterval)
+	}
+
+	if h.cfg.Policy.Mode == types.PolicyModeDB && !readOnly {
+		policyRolloutCtx, policyRolloutCancel := context.WithCancel(context.Background())
+		defer policyRolloutCancel()
+		go h.watchPolicyRollout(policyRolloutCtx, h.cfg.Policy.Rollout.CheckInterval)
+	}
+
+	if h.ldap != nil && h.cfg.LDAP.GroupSyncInterval > 0 && !readOnly {
+		ldapGroupsCtx, ldapGroupsCancel := context.WithCancel(context.Background())
+		defer ldapGroupsCancel()
+		go h.syncLDAPGroups(ldapGroupsCtx, h.cfg.LDAP.GroupSyncInterval)
+	}
 
 	if zl.GlobalLevel() == zl.TraceLevel {
 		zerolog.RespLog = true
@@ -571,6 +1180,10 @@ func (h *Headscale) Serve() error {
 		return fmt.Errorf("failed change permission of gRPC socket: %w", err)
 	}
 
+	if err := h.chownUnixSocket(); err != nil {
+		return fmt.Errorf("failed to change owner of gRPC socket: %w", err)
+	}
+
 	grpcGatewayMux := grpcRuntime.NewServeMux()
 
 	// Make the grpc-gateway connect to grpc over socket
@@ -592,14 +1205,30 @@ func (h *Headscale) Serve() error {
 		return fmt.Errorf("registering Headscale API service to gRPC: %w", err)
 	}
 
-	// Start the local gRPC server without TLS and without authentication
+	// Start the local gRPC server without TLS and without authentication,
+	// unless access rules limit what the connecting processes may call.
+	var socketOptions []grpc.ServerOption
+	socketInterceptors := []grpc.UnaryServerInterceptor{h.apiKeyScopeInterceptor}
+	if len(h.cfg.UnixSocketAccess) > 0 {
+		access, err := newSocketAccess(h.cfg.UnixSocketAccess)
+		if err != nil {
+			return err
+		}
+
+		socketOptions = append(socketOptions, grpc.Creds(peerCredentials{}))
+		socketInterceptors = append(socketInterceptors, access.unixSocketAuthorizationInterceptor)
+	}
+	socketOptions = append(socketOptions,
+		grpc.UnaryInterceptor(grpcMiddleware.ChainUnaryServer(socketInterceptors...)),
+	)
+
 	grpcSocket := grpc.NewServer(
-	// Uncomment to debug grpc communication.
-	// zerolog.UnaryInterceptor(),
+		// Uncomment to debug grpc communication.
+		// zerolog.UnaryInterceptor(),
+		socketOptions...,
 	)
 
-	v1.RegisterHeadscaleServiceServer(grpcSocket, newHeadscaleV1APIServer(h))
-	reflection.Register(grpcSocket)
+	h.registerGRPCServices(grpcSocket)
 
 	errorGroup.Go(func() error { return grpcSocket.Serve(socketListener) })
 
@@ -627,33 +1256,12 @@ func (h *Headscale) Serve() error {
 
 	var grpcServer *grpc.Server
 	var grpcListener net.Listener
-	if tlsConfig != nil || h.cfg.GRPCAllowInsecure {
+	if (tlsConfig != nil || h.cfg.GRPCAllowInsecure) && !h.cfg.TailnetAdmin.Exclusive {
 		log.Info().Msgf("Enabling remote gRPC at %s", h.cfg.GRPCAddr)
 
-		grpcOptions := []grpc.ServerOption{
-			grpc.UnaryInterceptor(
-				grpcMiddleware.ChainUnaryServer(
-					h.grpcAuthenticationInterceptor,
-					// Uncomment to debug grpc communication.
-					// zerolog.NewUnaryServerInterceptor(),
-				),
-			),
-		}
-
-		if tlsConfig != nil {
-			grpcOptions = append(grpcOptions,
-				grpc.Creds(credentials.NewTLS(tlsConfig)),
-			)
-		} else {
-			log.Warn().Msg("gRPC is running without security")
-		}
-
-		grpcServer = grpc.NewServer(grpcOptions...)
+		grpcServer = h.newRemoteGRPCServer(tlsConfig)
 
-		v1.RegisterHeadscaleServiceServer(grpcServer, newHeadscaleV1APIServer(h))
-		reflection.Register(grpcServer)
-
-		grpcListener, err = net.Listen("tcp", h.cfg.GRPCAddr)
+		grpcListener, err = h.listen("tcp", h.cfg.GRPCAddr)
 		if err != nil {
 			return fmt.Errorf("failed to bind to TCP address: %w", err)
 		}
@@ -682,17 +1290,22 @@ func (h *Headscale) Serve() error {
 		WriteTimeout: types.HTTPTimeout,
 	}
 
-	var httpListener net.Listener
-	if tlsConfig != nil {
-		httpServer.TLSConfig = tlsConfig
-		httpListener, err = tls.Listen("tcp", h.cfg.Addr, tlsConfig)
-	} else {
-		httpListener, err = net.Listen("tcp", h.cfg.Addr)
-	}
+	httpListener, err := h.listen("tcp", h.cfg.Addr)
 	if err != nil {
 		return fmt.Errorf("failed to bind to TCP address: %w", err)
 	}
 
+	// The PROXY protocol header is sent before the TLS handshake.
+	httpListener, err = h.proxyProtocolListener(httpListener)
+	if err != nil {
+		return err
+	}
+
+	if tlsConfig != nil {
+		httpServer.TLSConfig = tlsConfig
+		httpListener = tls.NewListener(httpListener, tlsConfig)
+	}
+
 	errorGroup.Go(func() error { return httpServer.Serve(httpListener) })
 
 	log.Info().
@@ -713,15 +1326,37 @@ func (h *Headscale) Serve() error {
 		WriteTimeout: 0,
 	}
 
-	debugHTTPListener, err := net.Listen("tcp", h.cfg.MetricsAddr)
-	if err != nil {
-		return fmt.Errorf("failed to bind to TCP address: %w", err)
+	var debugHTTPListener net.Listener
+	if !h.cfg.TailnetAdmin.Exclusive {
+		debugHTTPListener, err = h.listen("tcp", h.cfg.MetricsAddr)
+		if err != nil {
+			return fmt.Errorf("failed to bind to TCP address: %w", err)
+		}
+
+		errorGroup.Go(func() error { return debugHTTPServer.Serve(debugHTTPListener) })
+
+		log.Info().
+			Msgf("listening and serving debug and metrics on: %s", h.cfg.MetricsAddr)
 	}
 
-	errorGroup.Go(func() error { return debugHTTPServer.Serve(debugHTTPListener) })
+	extraGRPCServers, err := h.serveListeners(
+		errorGroup,
+		tlsConfig,
+		httpServer,
+		debugHTTPServer,
+	)
+	if err != nil {
+		return err
+	}
+	h.closeUnusedActivatedListeners()
 
-	log.Info().
-		Msgf("listening and serving debug and metrics on: %s", h.cfg.MetricsAddr)
+	var tsAdmin *tailnetAdmin
+	if h.cfg.TailnetAdmin.Enabled {
+		tsAdmin, err = h.startTailnetAdmin(ctx, errorGroup, grpcGatewayMux, debugMux)
+		if err != nil {
+			return err
+		}
+	}
 
 	var tailsqlContext context.Context
 	if tailsqlEnabled {
@@ -737,6 +1372,18 @@ func (h *Headscale) Serve() error {
 		go runTailSQLService(ctx, util.TSLogfWrapper(), tailsqlStateDir, h.cfg.Database.Sqlite.Path)
 	}
 
+	if h.cfg.Canary.Enabled {
+		go h.runCanary(ctx)
+	}
+
+	if h.replica != nil {
+		go h.runReplica(ctx)
+	}
+
+	h.readiness.complete(startupListeners)
+	h.setGRPCServing(true)
+	go h.selfTest(ctx)
+
 	// Handle common process-killing signals so we can gracefully shut down:
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc,
@@ -755,7 +1402,10 @@ func (h *Headscale) Serve() error {
 					Str("signal", sig.String()).
 					Msg("Received SIGHUP, reloading ACL and Config")
 
-				// TODO(kradalby): Reload config on SIGHUP
+				if err := h.reloadConfig(); err != nil {
+					log.Error().Err(err).Msg("failed to reload config, keeping the current settings")
+				}
+
 				if err := h.loadACLPolicy(); err != nil {
 					log.Error().Err(err).Msg("failed to reload ACL policy")
 				}
@@ -775,6 +1425,9 @@ func (h *Headscale) Serve() error {
 					Str("signal", sig.String()).
 					Msg("Received signal to stop, shutting down gracefully")
 
+				h.readiness.shuttingDown.Store(true)
+				h.setGRPCServing(false)
+
 				expireNodeCancel()
 				h.ephemeralGC.Close()
 
@@ -807,6 +1460,15 @@ func (h *Headscale) Serve() error {
 					grpcListener.Close()
 				}
 
+				for _, server := range extraGRPCServers {
+					server.GracefulStop()
+				}
+
+				if tsAdmin != nil {
+					info("shutting down tailnet admin node")
+					tsAdmin.Close()
+				}
+
 				if tailsqlContext != nil {
 					info("shutting down tailsql")
 					tailsqlContext.Done()
@@ -814,7 +1476,9 @@ func (h *Headscale) Serve() error {
 
 				// Close network listeners
 				info("closing network listeners")
-				debugHTTPListener.Close()
+				if debugHTTPListener != nil {
+					debugHTTPListener.Close()
+				}
 				httpListener.Close()
 				grpcGatewayConn.Close()
 
@@ -822,6 +1486,11 @@ func (h *Headscale) Serve() error {
 				info("closing socket listener")
 				socketListener.Close()
 
+				info("flushing traces")
+				if err := shutdownTracing(ctx); err != nil {
+					log.Error().Err(err).Msg("failed to flush traces")
+				}
+
 				// Close db connections
 				info("closing database connection")
 				err = h.db.Close()
@@ -926,12 +1595,14 @@ func notFoundHandler(
 ) {
 	body, _ := io.ReadAll(req.Body)
 
-	log.Trace().
+	event := log.Trace().
 		Interface("header", req.Header).
 		Interface("proto", req.Proto).
-		Interface("url", req.URL).
-		Bytes("body", body).
-		Msg("Request did not match")
+		Interface("url", req.URL)
+	if !util.LogRedaction() {
+		event = event.Bytes("body", body)
+	}
+	event.Msg("Request did not match")
 	writer.WriteHeader(http.StatusNotFound)
 }
 
@@ -981,8 +1652,9 @@ func readOrCreatePrivateKey(path string) (*key.MachinePrivate, error) {
 
 func (h *Headscale) loadACLPolicy() error {
 	var (
-		pol *policy.ACLPolicy
-		err error
+		pol     *policy.ACLPolicy
+		version uint
+		err     error
 	)
 
 	switch h.cfg.Policy.Mode {
@@ -1000,6 +1672,23 @@ func (h *Headscale) loadACLPolicy() error {
 			return fmt.Errorf("failed to load ACL policy from file: %w", err)
 		}
 
+		if err := pol.CheckDisallowed(h.cfg.Policy.Disallow); err != nil {
+			return fmt.Errorf("failed to load ACL policy from file: %w", err)
+		}
+
+		if err := h.setUserAliases(pol); err != nil {
+			return err
+		}
+		h.setDirectoryGroups(pol)
+
+		if err := pol.CheckDeprecations(h.cfg.Policy.Strict); err != nil {
+			return fmt.Errorf("failed to load policy from database: %w", err)
+		}
+
+		if err := pol.CheckDeprecations(h.cfg.Policy.Strict); err != nil {
+			return fmt.Errorf("failed to load ACL policy from file: %w", err)
+		}
+
 		// Validate and reject configuration that would error when applied
 		// when creating a map response. This requires nodes, so there is still
 		// a scenario where they might be allowed if the server has no nodes
@@ -1039,6 +1728,16 @@ func (h *Headscale) loadACLPolicy() error {
 		if err != nil {
 			return fmt.Errorf("failed to parse policy: %w", err)
 		}
+		version = p.ID
+
+		if err := pol.CheckDisallowed(h.cfg.Policy.Disallow); err != nil {
+			return fmt.Errorf("failed to load policy from database: %w", err)
+		}
+
+		if err := h.setUserAliases(pol); err != nil {
+			return err
+		}
+		h.setDirectoryGroups(pol)
 	default:
 		log.Fatal().
 			Str("mode", string(h.cfg.Policy.Mode)).
@@ -1046,6 +1745,271 @@ func (h *Headscale) loadACLPolicy() error {
 	}
 
 	h.ACLPolicy = pol
+	h.setLoadedPolicy(pol, version)
+
+	return nil
+}
+
+// updatePolicy replaces the policy stored in the database with the
+// policy edit returns for the current one. The new policy is validated
+// before it is stored and sent to the nodes. If expectedVersion is not
+// zero, the update fails with types.ErrPolicyVersionMismatch when the
+// policy was changed since that version.
+func (h *Headscale) updatePolicy(
+	expectedVersion uint64,
+	edit func(current []byte) ([]byte, error),
+) (*types.Policy, error) {
+	if h.cfg.Policy.Mode != types.PolicyModeDB {
+		return nil, types.ErrPolicyUpdateIsDisabled
+	}
+
+	h.policyUpdateMu.Lock()
+	defer h.policyUpdateMu.Unlock()
+
+	var current []byte
+	p, err := h.db.GetPolicy()
+	switch {
+	case err == nil:
+		current = []byte(p.Data)
+	case !errors.Is(err, types.ErrPolicyNotFound):
+		return nil, fmt.Errorf("loading ACL from database: %w", err)
+	}
+
+	if expectedVersion != 0 && (p == nil || uint64(p.ID) != expectedVersion) {
+		return nil, types.ErrPolicyVersionMismatch
+	}
+
+	data, err := edit(current)
+	if err != nil {
+		return nil, err
+	}
+
+	pol, err := h.compilePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := h.db.SetPolicyIfVersion(string(data), uint(expectedVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	h.ACLPolicy = pol
+	h.setLoadedPolicy(pol, updated.ID)
+
+	ctx := types.NotifyCtx(context.Background(), "acl-update", "na")
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	return updated, nil
+}
+
+// compilePolicy loads a policy to be stored in the database, rejecting
+// it if it could not be applied to the nodes.
+func (h *Headscale) compilePolicy(data []byte) (*policy.ACLPolicy, error) {
+	pol, err := policy.LoadACLPolicyFromBytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("loading ACL policy file: %w", err)
+	}
+
+	if err := pol.CheckDisallowed(h.cfg.Policy.Disallow); err != nil {
+		return nil, err
+	}
+
+	if err := h.setUserAliases(pol); err != nil {
+		return nil, err
+	}
+	h.setDirectoryGroups(pol)
+
+	if err := pol.CheckDeprecations(h.cfg.Policy.Strict); err != nil {
+		return nil, err
+	}
+
+	// Validate and reject configuration that would error when applied
+	// when creating a map response. This requires nodes, so there is still
+	// a scenario where they might be allowed if the server has no nodes
+	// yet, but it should help for the general case and for hot reloading
+	// configurations.
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		return nil, fmt.Errorf("loading nodes from database to validate policy: %w", err)
+	}
+
+	_, err = pol.CompileFilterRules(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("verifying policy rules: %w", err)
+	}
+
+	if len(nodes) > 0 {
+		_, err = pol.CompileSSHPolicy(nodes[0], nodes)
+		if err != nil {
+			return nil, fmt.Errorf("verifying SSH rules: %w", err)
+		}
+	}
+
+	return pol, nil
+}
+
+// setUserAliases fills in the old names of renamed users, so a policy
+// referencing them keeps working until the aliases expire.
+func (h *Headscale) setUserAliases(pol *policy.ACLPolicy) error {
+	aliases, err := h.db.ActiveUserAliases()
+	if err != nil {
+		return fmt.Errorf("loading user aliases: %w", err)
+	}
+
+	pol.UserAliases = aliases
+	pol.WarnedUserAliases = &h.warnedUserAliases
+
+	return nil
+}
+
+// reloadUserAliases updates the user aliases of the current policy
+// after a user has been renamed, or created with the old name of a
+// renamed user.
+func (h *Headscale) reloadUserAliases() error {
+	if h.ACLPolicy == nil {
+		return nil
+	}
+
+	pol := *h.ACLPolicy
+	if err := h.setUserAliases(&pol); err != nil {
+		return err
+	}
+
+	h.ACLPolicy = &pol
 
 	return nil
 }
+
+// createUser creates a user and reloads the user aliases, as the
+// user takes its name over from a renamed user.
+func (h *Headscale) createUser(name string) (*types.User, error) {
+	user, err := h.db.CreateUser(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.reloadUserAliases(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// nodeLocation returns the location of the address the node last
+// connected from, or an empty string if it is unknown or no geoip
+// database is configured.
+func (h *Headscale) nodeLocation(node *types.Node) string {
+	if h.geoip == nil || node.LastSeenAddr == nil {
+		return ""
+	}
+
+	loc, ok := h.geoip.Lookup(*node.LastSeenAddr)
+	if !ok {
+		return ""
+	}
+
+	return loc.String()
+}
+
+// checkImpossibleTravel raises an alert if the node connected from addr
+// too soon after it was last seen at prevAddr, considering the distance
+// between the two locations. The alert is logged, sent to the
+// configured webhook and, if enabled, the node is quarantined by
+// expiring it.
+func (h *Headscale) checkImpossibleTravel(
+	node *types.Node,
+	prevAddr netip.Addr,
+	prevSeen time.Time,
+	addr netip.Addr,
+) {
+	prevLoc, ok := h.geoip.Lookup(prevAddr)
+	if !ok {
+		return
+	}
+
+	loc, ok := h.geoip.Lookup(addr)
+	if !ok {
+		return
+	}
+
+	alert, ok := anomaly.CheckTravel(
+		h.cfg.AnomalyDetection,
+		node,
+		anomaly.Observation{Addr: prevAddr, Location: prevLoc, Time: prevSeen},
+		anomaly.Observation{Addr: addr, Location: loc, Time: time.Now()},
+	)
+	if !ok {
+		return
+	}
+
+	log.Warn().
+		Caller().
+		Str("alert", alert.Type).
+		Uint64("node.id", alert.NodeID).
+		Str("node", alert.Hostname).
+		Str("user", alert.User).
+		Str("from", alert.From).
+		Str("from_addr", alert.FromAddr).
+		Str("to", alert.To).
+		Str("to_addr", alert.ToAddr).
+		Float64("distance_km", alert.DistanceKm).
+		Str("elapsed", alert.Elapsed).
+		Float64("speed_kmh", alert.SpeedKmh).
+		Bool("quarantine", alert.Quarantine).
+		Msg("impossible travel detected")
+
+	go func() {
+		if h.cfg.AnomalyDetection.WebhookURL != "" {
+			err := anomaly.SendWebhook(
+				context.Background(),
+				h.cfg.AnomalyDetection.WebhookURL,
+				alert,
+			)
+			if err != nil {
+				log.Error().
+					Caller().
+					Err(err).
+					Str("node", alert.Hostname).
+					Msg("failed to send anomaly webhook")
+			}
+		}
+
+		if alert.Quarantine {
+			h.quarantineNode(types.NodeID(alert.NodeID), alert.Hostname)
+		}
+	}()
+}
+
+// quarantineNode expires the node, disconnecting it from its peers
+// until it is re-authenticated.
+func (h *Headscale) quarantineNode(nodeID types.NodeID, hostname string) {
+	now := time.Now()
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		return db.NodeSetExpiry(tx, nodeID, now)
+	})
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Str("node", hostname).
+			Msg("failed to quarantine node")
+
+		return
+	}
+
+	ctx := types.NotifyCtx(context.Background(), "anomaly-quarantine-self", hostname)
+	h.nodeNotifier.NotifyByNodeID(
+		ctx,
+		types.StateUpdate{
+			Type:        types.StateSelfUpdate,
+			ChangeNodes: []types.NodeID{nodeID},
+		},
+		nodeID)
+
+	ctx = types.NotifyCtx(context.Background(), "anomaly-quarantine-peers", hostname)
+	h.nodeNotifier.NotifyWithIgnore(ctx, types.StateUpdateExpire(nodeID, now), nodeID)
+}
@@ -0,0 +1,58 @@
+package hscontrol
+
+import (
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// attestedRegisterRequest is a register request with the hardware
+// attestation clients can send next to it.
+type attestedRegisterRequest struct {
+	tailcfg.RegisterRequest
+
+	Attestation *types.AttestationStatement `json:",omitempty"`
+}
+
+// recordAttestation verifies the attestation sent with a register
+// request and records it on the node with the machine and node key,
+// or on the node waiting to be registered. Invalid attestations are
+// logged and dropped, they do not fail the registration.
+func (h *Headscale) recordAttestation(
+	machineKey key.MachinePublic,
+	nodeKey key.NodePublic,
+	stmt types.AttestationStatement,
+) {
+	attestation, err := types.VerifyAttestation(stmt, nodeKey, h.cfg.Attestation.Roots, time.Now())
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("machine_key", machineKey.ShortString()).
+			Str("format", stmt.Format).
+			Msg("Ignoring invalid attestation")
+
+		return
+	}
+
+	node, err := h.db.GetNodeByMachineKey(machineKey)
+	if err == nil && node.NodeKey == nodeKey {
+		if err := h.db.NodeSetAttestation(node.ID, attestation); err != nil {
+			log.Error().
+				Err(err).
+				Str("node", node.Hostname).
+				Msg("Failed to record attestation")
+		}
+
+		return
+	}
+
+	if cached, ok := h.registrationCache.Get(machineKey.String()); ok {
+		if pending, ok := cached.(types.Node); ok && pending.NodeKey == nodeKey {
+			pending.Attestation = attestation
+			h.setPendingRegistration(machineKey, pending)
+		}
+	}
+}
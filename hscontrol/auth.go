@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/juanfont/headscale/hscontrol/db"
@@ -68,6 +67,23 @@ func (h *Headscale) handleRegister(
 ) {
 	logInfo, logTrace, _ := logAuthFunc(regReq, machineKey)
 	now := time.Now().UTC()
+
+	revoked, err := h.db.IsKeyRevoked(regReq.NodeKey.String())
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Cannot check whether the node key is revoked")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	if revoked {
+		h.handleRevokedNodeKey(writer, regReq, machineKey)
+
+		return
+	}
+
 	logTrace("handleRegister called, looking up machine in DB")
 	node, err := h.db.GetNodeByAnyKey(machineKey, regReq.NodeKey, regReq.OldNodeKey)
 	logTrace("handleRegister database lookup has returned")
@@ -92,11 +108,15 @@ func (h *Headscale) handleRegister(
 			if _, ok := h.registrationCache.Get(machineKey.String()); ok {
 				logTrace("Node is waiting for interactive login")
 
+				_, span := util.StartSpan(req.Context(), "headscale.register.wait_interactive_login")
 				select {
 				case <-req.Context().Done():
+					span.End()
+
 					return
 				case <-time.After(registrationHoldoff):
-					h.handleNewNode(writer, regReq, machineKey)
+					span.End()
+					h.handleNewNode(writer, regReq, machineKey, clientAddr(req))
 
 					return
 				}
@@ -122,13 +142,9 @@ func (h *Headscale) handleRegister(
 			newNode.Expiry = &regReq.Expiry
 		}
 
-		h.registrationCache.Set(
-			machineKey.String(),
-			newNode,
-			registerCacheExpiration,
-		)
+		h.setPendingRegistration(machineKey, newNode)
 
-		h.handleNewNode(writer, regReq, machineKey)
+		h.handleNewNode(writer, regReq, machineKey, clientAddr(req))
 
 		return
 	}
@@ -172,14 +188,16 @@ func (h *Headscale) handleRegister(
 			// If node is not expired, and it is register, we have a already accepted this node,
 			// let it proceed with a valid registration
 			if !node.IsExpired() {
-				h.handleNodeWithValidRegistration(writer, *node, machineKey)
+				h.handleNodeWithValidRegistration(writer, regReq, *node, machineKey)
 
 				return
 			}
 		}
 
-		// The NodeKey we have matches OldNodeKey, which means this is a refresh after a key expiration
+		// The NodeKey we have matches OldNodeKey, which means the node is
+		// renewing its key. Only the machine holding the node can do so.
 		if node.NodeKey.String() == regReq.OldNodeKey.String() &&
+			node.MachineKey == machineKey &&
 			!node.IsExpired() {
 			h.handleNodeKeyRefresh(
 				writer,
@@ -213,7 +231,7 @@ func (h *Headscale) handleRegister(
 		}
 
 		// The node has expired or it is logged out
-		h.handleNodeExpiredOrLoggedOut(writer, regReq, *node, machineKey)
+		h.handleNodeExpiredOrLoggedOut(writer, regReq, *node, machineKey, clientAddr(req))
 
 		// TODO(juan): RegisterRequest includes an Expiry time, that we could optionally use
 		node.Expiry = &time.Time{}
@@ -223,11 +241,7 @@ func (h *Headscale) handleRegister(
 		// TODO(juan): What happens when using fast user switching between two
 		// headscale-managed tailnets?
 		node.NodeKey = regReq.NodeKey
-		h.registrationCache.Set(
-			machineKey.String(),
-			*node,
-			registerCacheExpiration,
-		)
+		h.setPendingRegistration(machineKey, *node)
 
 		return
 	}
@@ -297,6 +311,24 @@ func (h *Headscale) handleAuthKey(
 	// exist, then this is a new node and we will move
 	// on to registration.
 	node, _ := h.db.GetNodeByAnyKey(machineKey, registerRequest.NodeKey, registerRequest.OldNodeKey)
+
+	// Check the requested tags as the user and service of the key,
+	// before anything is stored.
+	candidate := types.Node{
+		Hostname:   registerRequest.Hostinfo.Hostname,
+		User:       pak.User,
+		ForcedTags: pak.Proto().GetAclTags(),
+	}
+	if len(pak.ACLTags) > 0 {
+		candidate.Service = pak.Service
+	}
+	if node != nil {
+		candidate.ForcedTags = append(candidate.ForcedTags, node.ForcedTags...)
+	}
+	if h.rejectRequestTags(writer, registerRequest, candidate) {
+		return
+	}
+
 	if node != nil {
 		log.Trace().
 			Caller().
@@ -308,9 +340,12 @@ func (h *Headscale) handleAuthKey(
 			node.AuthKeyID = ptr.To(pak.ID)
 		}
 
-		node.Expiry = &registerRequest.Expiry
+		node.Expiry = pak.NodeExpiryAt(time.Now(), registerRequest.Expiry)
 		node.User = pak.User
 		node.UserID = pak.UserID
+		if len(pak.ACLTags) > 0 {
+			node.Service = pak.Service
+		}
 		err := h.db.DB.Save(node).Error
 		if err != nil {
 			log.Error().
@@ -349,10 +384,11 @@ func (h *Headscale) handleAuthKey(
 			User:           pak.User,
 			MachineKey:     machineKey,
 			RegisterMethod: util.RegisterMethodAuthKey,
-			Expiry:         &registerRequest.Expiry,
+			Expiry:         pak.NodeExpiryAt(time.Now(), registerRequest.Expiry),
 			NodeKey:        nodeKey,
 			LastSeen:       &now,
 			ForcedTags:     pak.Proto().GetAclTags(),
+			Service:        pak.Service,
 		}
 
 		ipv4, ipv6, err := h.ipAlloc.Next()
@@ -439,6 +475,7 @@ func (h *Headscale) handleNewNode(
 	writer http.ResponseWriter,
 	registerRequest tailcfg.RegisterRequest,
 	machineKey key.MachinePublic,
+	clientAddr string,
 ) {
 	logInfo, logTrace, logErr := logAuthFunc(registerRequest, machineKey)
 
@@ -447,17 +484,7 @@ func (h *Headscale) handleNewNode(
 	// The node registration is new, redirect the client to the registration URL
 	logTrace("The node seems to be new, sending auth url")
 
-	if h.oauth2Config != nil {
-		resp.AuthURL = fmt.Sprintf(
-			"%s/oidc/register/%s",
-			strings.TrimSuffix(h.cfg.ServerURL, "/"),
-			machineKey.String(),
-		)
-	} else {
-		resp.AuthURL = fmt.Sprintf("%s/register/%s",
-			strings.TrimSuffix(h.cfg.ServerURL, "/"),
-			machineKey.String())
-	}
+	resp.AuthURL = h.registrationAuthURL(machineKey, clientAddr)
 
 	respBody, err := json.Marshal(resp)
 	if err != nil {
@@ -562,9 +589,14 @@ func (h *Headscale) handleNodeLogOut(
 
 func (h *Headscale) handleNodeWithValidRegistration(
 	writer http.ResponseWriter,
+	regReq tailcfg.RegisterRequest,
 	node types.Node,
 	machineKey key.MachinePublic,
 ) {
+	if h.rejectRequestTags(writer, regReq, node) {
+		return
+	}
+
 	resp := tailcfg.RegisterResponse{}
 
 	// The node registration is valid, respond with redirect to /map
@@ -618,21 +650,42 @@ func (h *Headscale) handleNodeKeyRefresh(
 		Str("node", node.Hostname).
 		Msg("We have the OldNodeKey in the database. This is a key refresh")
 
+	// Nodes with an expiry get a new one on renewal if configured, so
+	// servers renewing their key before it expires keep running.
+	var expiry *time.Time
+	if renewal := h.cfg.NodeKeyRenewal.Expiry; renewal > 0 &&
+		node.Expiry != nil && !node.Expiry.IsZero() {
+		expiry = ptr.To(time.Now().Add(renewal))
+	}
+
 	err := h.db.Write(func(tx *gorm.DB) error {
-		return db.NodeSetNodeKey(tx, &node, registerRequest.NodeKey)
+		return db.RenewNodeKey(tx, &node, registerRequest.NodeKey, expiry)
 	})
 	if err != nil {
 		log.Error().
 			Caller().
 			Err(err).
-			Msg("Failed to update machine key in the database")
+			Msg("Failed to update node key in the database")
 		http.Error(writer, "Internal server error", http.StatusInternalServerError)
 
 		return
 	}
 
+	// Peers only need the new key and expiry of the node.
+	ctx := types.NotifyCtx(context.Background(), "node-key-renewal", node.Hostname)
+	h.nodeNotifier.NotifyWithIgnore(ctx, types.StateUpdate{
+		Type: types.StatePeerChangedPatch,
+		ChangePatches: []*tailcfg.PeerChange{{
+			NodeID:    node.ID.NodeID(),
+			Key:       &node.NodeKey,
+			KeyExpiry: node.Expiry,
+		}},
+	}, node.ID)
+
 	resp.AuthURL = ""
+	resp.MachineAuthorized = true
 	resp.User = *node.User.TailscaleUser()
+	resp.Login = *node.User.TailscaleLogin()
 	respBody, err := json.Marshal(resp)
 	if err != nil {
 		log.Error().
@@ -667,6 +720,7 @@ func (h *Headscale) handleNodeExpiredOrLoggedOut(
 	regReq tailcfg.RegisterRequest,
 	node types.Node,
 	machineKey key.MachinePublic,
+	clientAddr string,
 ) {
 	resp := tailcfg.RegisterResponse{}
 
@@ -685,15 +739,7 @@ func (h *Headscale) handleNodeExpiredOrLoggedOut(
 		Str("node_key_old", regReq.OldNodeKey.ShortString()).
 		Msg("Node registration has expired or logged out. Sending a auth url to register")
 
-	if h.oauth2Config != nil {
-		resp.AuthURL = fmt.Sprintf("%s/oidc/register/%s",
-			strings.TrimSuffix(h.cfg.ServerURL, "/"),
-			machineKey.String())
-	} else {
-		resp.AuthURL = fmt.Sprintf("%s/register/%s",
-			strings.TrimSuffix(h.cfg.ServerURL, "/"),
-			machineKey.String())
-	}
+	resp.AuthURL = h.registrationAuthURL(machineKey, clientAddr)
 
 	respBody, err := json.Marshal(resp)
 	if err != nil {
@@ -724,3 +770,90 @@ func (h *Headscale) handleNodeExpiredOrLoggedOut(
 		Str("node", node.Hostname).
 		Msg("Node logged out. Sent AuthURL for reauthentication")
 }
+
+// handleRevokedNodeKey tells a client registering with a revoked node
+// key that it expired, the client then generates a new node key and
+// registers again with it.
+func (h *Headscale) handleRevokedNodeKey(
+	writer http.ResponseWriter,
+	regReq tailcfg.RegisterRequest,
+	machineKey key.MachinePublic,
+) {
+	log.Info().
+		Caller().
+		Str("machine_key", machineKey.ShortString()).
+		Str("node_key", regReq.NodeKey.ShortString()).
+		Msg("Node key is revoked, asking the client for a new one")
+
+	respBody, err := json.Marshal(tailcfg.RegisterResponse{NodeKeyExpired: true})
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Cannot encode message")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	_, err = writer.Write(respBody)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// rejectRequestTags answers a registration with an error when the node
+// requests tags with --advertise-tags that the policy does not permit
+// it to set, tailscale up shows the error explaining the TagOwners rule
+// rejecting each tag. It reports if the registration was rejected.
+// Without a policy the requested tags are not checked.
+func (h *Headscale) rejectRequestTags(
+	writer http.ResponseWriter,
+	regReq tailcfg.RegisterRequest,
+	node types.Node,
+) bool {
+	if h.ACLPolicy == nil || regReq.Hostinfo == nil {
+		return false
+	}
+
+	node.Hostinfo = regReq.Hostinfo
+	err := h.ACLPolicy.CheckRequestTags(&node)
+	if err == nil {
+		return false
+	}
+
+	log.Info().
+		Caller().
+		Str("node", node.Hostname).
+		Strs("request_tags", regReq.Hostinfo.RequestTags).
+		Err(err).
+		Msg("Rejecting registration requesting tags the node may not set")
+
+	respBody, err := json.Marshal(tailcfg.RegisterResponse{Error: err.Error()})
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Cannot encode message")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return true
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	_, err = writer.Write(respBody)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+
+	return true
+}
@@ -5,8 +5,9 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/juanfont/headscale/hscontrol/util"
 	"github.com/rs/zerolog/log"
-	"tailscale.com/tailcfg"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // // NoiseRegistrationHandler handles the actual registration process of a node.
@@ -21,14 +22,22 @@ func (ns *noiseServer) NoiseRegistrationHandler(
 		return
 	}
 
+	// Registering and logging in store the node, the replica has to
+	// wait for the primary.
+	if ns.headscale.isReadOnly() {
+		http.Error(writer, "replica cannot register nodes, the primary is down", http.StatusServiceUnavailable)
+
+		return
+	}
+
 	log.Trace().
 		Any("headers", req.Header).
 		Caller().
 		Msg("Headers")
 
 	body, _ := io.ReadAll(req.Body)
-	registerRequest := tailcfg.RegisterRequest{}
-	if err := json.Unmarshal(body, &registerRequest); err != nil {
+	var attested attestedRegisterRequest
+	if err := json.Unmarshal(body, &attested); err != nil {
 		log.Error().
 			Caller().
 			Err(err).
@@ -37,6 +46,7 @@ func (ns *noiseServer) NoiseRegistrationHandler(
 
 		return
 	}
+	registerRequest := attested.RegisterRequest
 
 	// Reject unsupported versions
 	if registerRequest.Version < MinimumCapVersion {
@@ -52,5 +62,17 @@ func (ns *noiseServer) NoiseRegistrationHandler(
 
 	ns.nodeKey = registerRequest.NodeKey
 
-	ns.headscale.handleRegister(writer, req, registerRequest, ns.conn.Peer())
+	ctx, span := util.StartSpan(req.Context(), "headscale.register",
+		attribute.String("machine_key", ns.conn.Peer().ShortString()),
+		attribute.String("node_key", registerRequest.NodeKey.ShortString()),
+		attribute.Int("capability_version", int(registerRequest.Version)),
+		attribute.Bool("followup", registerRequest.Followup != ""),
+		attribute.Bool("auth_key", registerRequest.Auth != nil && registerRequest.Auth.AuthKey != ""),
+	)
+	ns.headscale.handleRegister(writer, req.WithContext(ctx), registerRequest, ns.conn.Peer())
+	span.End()
+
+	if attested.Attestation != nil {
+		ns.headscale.recordAttestation(ns.conn.Peer(), registerRequest.NodeKey, *attested.Attestation)
+	}
 }
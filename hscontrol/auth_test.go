@@ -0,0 +1,279 @@
+package hscontrol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestHandleRegisterNodeKeyRenewal(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{
+		ServerURL: "https://headscale.example.com",
+		NodeKeyRenewal: types.NodeKeyRenewalConfig{
+			Expiry: 24 * time.Hour,
+		},
+	})
+	h.registrationCache = cache.New(registerCacheExpiration, registerCacheCleanup)
+
+	machineKey := key.NewMachine().Public()
+	oldNodeKey := key.NewNode().Public()
+	expiry := time.Now().Add(time.Hour)
+
+	node, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		user, err := db.CreateUser(tx, "server")
+		if err != nil {
+			return nil, err
+		}
+
+		node := &types.Node{
+			MachineKey:     machineKey,
+			NodeKey:        oldNodeKey,
+			Hostname:       "server",
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodAuthKey,
+			Expiry:         &expiry,
+		}
+
+		return node, tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	register := func(machineKey key.MachinePublic, nodeKey, oldNodeKey key.NodePublic) tailcfg.RegisterResponse {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.handleRegister(rec, httptest.NewRequest("POST", "/machine/register", nil), tailcfg.RegisterRequest{
+			NodeKey:    nodeKey,
+			OldNodeKey: oldNodeKey,
+			Hostinfo:   &tailcfg.Hostinfo{Hostname: "server"},
+		}, machineKey)
+
+		var resp tailcfg.RegisterResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding register response %q: %s", rec.Body.String(), err)
+		}
+
+		return resp
+	}
+
+	newNodeKey := key.NewNode().Public()
+	if resp := register(machineKey, newNodeKey, oldNodeKey); !resp.MachineAuthorized || resp.AuthURL != "" {
+		t.Errorf("renewal response = %+v, want authorized without auth URL", resp)
+	}
+
+	got, err := h.db.GetNodeByID(node.ID)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if got.NodeKey != newNodeKey {
+		t.Errorf("node key was not renewed")
+	}
+	if got.Expiry == nil || got.Expiry.Before(time.Now().Add(23*time.Hour)) {
+		t.Errorf("expiry = %v, want about 24h from now", got.Expiry)
+	}
+
+	// Another machine cannot take the node over with its node key.
+	if resp := register(key.NewMachine().Public(), key.NewNode().Public(), newNodeKey); resp.MachineAuthorized {
+		t.Errorf("renewal from another machine was authorized")
+	}
+
+	got, err = h.db.GetNodeByID(node.ID)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if got.NodeKey != newNodeKey {
+		t.Errorf("node key was changed by another machine")
+	}
+}
+
+func TestHandleRegisterRequestTags(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{ServerURL: "https://headscale.example.com"})
+	h.registrationCache = cache.New(registerCacheExpiration, registerCacheCleanup)
+	h.ACLPolicy = &policy.ACLPolicy{
+		TagOwners: policy.TagOwners{
+			"tag:web": {"alice"},
+			"tag:db":  {"bob"},
+		},
+	}
+
+	machineKey := key.NewMachine().Public()
+	nodeKey := key.NewNode().Public()
+	expiry := time.Now().Add(time.Hour)
+
+	_, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return nil, err
+		}
+
+		node := &types.Node{
+			MachineKey:     machineKey,
+			NodeKey:        nodeKey,
+			Hostname:       "web",
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodAuthKey,
+			Expiry:         &expiry,
+		}
+
+		return node, tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	register := func(machineKey key.MachinePublic, nodeKey key.NodePublic, authKey string, tags ...string) tailcfg.RegisterResponse {
+		t.Helper()
+
+		regReq := tailcfg.RegisterRequest{
+			NodeKey:  nodeKey,
+			Hostinfo: &tailcfg.Hostinfo{Hostname: "web", RequestTags: tags},
+		}
+		if authKey != "" {
+			regReq.Auth = &tailcfg.RegisterResponseAuth{AuthKey: authKey}
+		}
+
+		rec := httptest.NewRecorder()
+		h.handleRegister(rec, httptest.NewRequest("POST", "/machine/register", nil), regReq, machineKey)
+
+		var resp tailcfg.RegisterResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding register response %q: %s", rec.Body.String(), err)
+		}
+
+		return resp
+	}
+
+	if resp := register(machineKey, nodeKey, "", "tag:web"); !resp.MachineAuthorized || resp.Error != "" {
+		t.Errorf("register with an owned tag = %+v, want authorized", resp)
+	}
+
+	resp := register(machineKey, nodeKey, "", "tag:web", "tag:db")
+	want := `requested tags [tag:db] are invalid or not permitted: tagOwners of tag:db [bob] does not include user "alice"`
+	if resp.MachineAuthorized || resp.Error != want {
+		t.Errorf("register with a tag owned by another user = %+v, want error %q", resp, want)
+	}
+
+	pak, err := h.db.CreatePreAuthKey("alice", false, false, nil, nil)
+	if err != nil {
+		t.Fatalf("CreatePreAuthKey() error = %s", err)
+	}
+
+	resp = register(key.NewMachine().Public(), key.NewNode().Public(), pak.Key, "tag:db")
+	if resp.MachineAuthorized || resp.Error == "" {
+		t.Errorf("register with an auth key and a tag owned by another user = %+v, want an error", resp)
+	}
+
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		t.Fatalf("ListNodes() error = %s", err)
+	}
+	if len(nodes) != 1 {
+		t.Errorf("%d nodes, want the rejected node not to be registered", len(nodes))
+	}
+
+	stored, err := h.db.ValidatePreAuthKey(pak.Key)
+	if err != nil || stored.Used {
+		t.Errorf("ValidatePreAuthKey() = %v, %v, want the key of the rejected node unused", stored, err)
+	}
+}
+
+func TestRotateNodeKey(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{ServerURL: "https://headscale.example.com"})
+	h.registrationCache = cache.New(registerCacheExpiration, registerCacheCleanup)
+
+	machineKey := key.NewMachine().Public()
+	nodeKey := key.NewNode().Public()
+
+	node, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return nil, err
+		}
+
+		node := &types.Node{
+			MachineKey:     machineKey,
+			NodeKey:        nodeKey,
+			Hostname:       "laptop",
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+		}
+
+		return node, tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	resp, err := api.RotateNodeKey(context.Background(), &v1.RotateNodeKeyRequest{NodeId: uint64(node.ID)})
+	if err != nil {
+		t.Fatalf("RotateNodeKey() error = %s", err)
+	}
+	if resp.GetNode().GetExpiry().AsTime().After(time.Now()) {
+		t.Errorf("RotateNodeKey() expiry = %s, want the node expired", resp.GetNode().GetExpiry().AsTime())
+	}
+
+	register := func(nodeKey, oldNodeKey key.NodePublic) tailcfg.RegisterResponse {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.handleRegister(rec, httptest.NewRequest("POST", "/machine/register", nil), tailcfg.RegisterRequest{
+			NodeKey:    nodeKey,
+			OldNodeKey: oldNodeKey,
+			Hostinfo:   &tailcfg.Hostinfo{Hostname: "laptop"},
+		}, machineKey)
+
+		var resp tailcfg.RegisterResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decoding register response %q: %s", rec.Body.String(), err)
+		}
+
+		return resp
+	}
+
+	// The old key is refused, the client is told to make a new one.
+	if resp := register(nodeKey, key.NodePublic{}); !resp.NodeKeyExpired || resp.MachineAuthorized {
+		t.Errorf("register with the revoked node key = %+v, want the key expired", resp)
+	}
+
+	// With a new key the node has to log in again.
+	if resp := register(key.NewNode().Public(), nodeKey); resp.AuthURL == "" || resp.MachineAuthorized {
+		t.Errorf("register with a new node key = %+v, want an auth URL", resp)
+	}
+
+	ns := &noiseServer{headscale: h, machineKey: machineKey}
+	handler := ns.rejectRevokedMachineKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/machine/map", nil))
+
+		return rec.Code
+	}
+
+	if code := serve(); code != http.StatusOK {
+		t.Errorf("request with the machine key kept = %d, want %d", code, http.StatusOK)
+	}
+
+	// Lost devices lose their machine key too.
+	_, err = api.RotateNodeKey(context.Background(), &v1.RotateNodeKeyRequest{NodeId: uint64(node.ID), MachineKey: true})
+	if err != nil {
+		t.Fatalf("RotateNodeKey() with machine key error = %s", err)
+	}
+	if code := serve(); code != http.StatusForbidden {
+		t.Errorf("request with the revoked machine key = %d, want %d", code, http.StatusForbidden)
+	}
+}
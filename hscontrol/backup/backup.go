@@ -0,0 +1,346 @@
+// Package backup takes scheduled backups of the headscale database,
+// keeps them in a local directory and optionally uploads them to an S3
+// compatible bucket.
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	filePrefix     = "headscale-"
+	fileTimeFormat = "20060102T150405Z"
+
+	// checksumSuffix is appended to the name of a backup for the file
+	// holding its SHA-256, in the format of sha256sum.
+	checksumSuffix = ".sha256"
+)
+
+// Backuper writes backups on a schedule.
+type Backuper struct {
+	cfg      types.BackupConfig
+	db       types.DatabaseConfig
+	schedule *util.CronSchedule
+	bucket   *bucket
+}
+
+func New(ctx context.Context, cfg types.BackupConfig, dbCfg types.DatabaseConfig) (*Backuper, error) {
+	schedule, err := util.ParseCronSchedule(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	backuper := &Backuper{
+		cfg:      cfg,
+		db:       dbCfg,
+		schedule: schedule,
+	}
+
+	if cfg.S3.Bucket != "" {
+		backuper.bucket, err = newBucket(ctx, cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return backuper, nil
+}
+
+// Run takes a backup every time the schedule matches until ctx is
+// done.
+func (b *Backuper) Run(ctx context.Context) {
+	for {
+		next := b.schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Error().Str("schedule", b.cfg.Schedule).Msg("Backup schedule never matches, backups are disabled")
+
+			return
+		}
+
+		log.Debug().Time("next", next).Msg("Waiting for next backup")
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return
+		case <-timer.C:
+		}
+
+		if _, err := b.Backup(ctx); err != nil {
+			log.Error().Err(err).Msg("Backup failed")
+		}
+	}
+}
+
+// Backup takes a backup now, verifies it, uploads it if a bucket is
+// configured and removes the backups that are no longer retained. It
+// returns the path of the backup.
+func (b *Backuper) Backup(ctx context.Context) (string, error) {
+	start := time.Now()
+
+	path, size, err := b.backup(ctx, start)
+
+	duration := time.Since(start)
+	backupDuration.Set(duration.Seconds())
+
+	if err != nil {
+		backupsTotal.WithLabelValues("error").Inc()
+
+		return "", err
+	}
+
+	backupsTotal.WithLabelValues("success").Inc()
+	backupLastSuccess.Set(float64(start.Unix()))
+	backupSize.Set(float64(size))
+
+	log.Info().
+		Str("path", path).
+		Int64("size", size).
+		Dur("duration", duration).
+		Bool("uploaded", b.bucket != nil).
+		Msg("Backup completed")
+
+	if err := b.applyRetention(ctx, start); err != nil {
+		log.Error().Err(err).Msg("Failed to remove old backups")
+	}
+
+	return path, nil
+}
+
+func (b *Backuper) backup(ctx context.Context, now time.Time) (string, int64, error) {
+	if err := util.EnsureDir(b.cfg.Directory); err != nil {
+		return "", 0, fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	name := filePrefix + now.UTC().Format(fileTimeFormat) + b.extension()
+	path := filepath.Join(b.cfg.Directory, name)
+
+	if err := b.dumpDatabase(ctx, path); err != nil {
+		os.Remove(path)
+
+		return "", 0, err
+	}
+
+	if err := b.verify(ctx, path); err != nil {
+		os.Remove(path)
+
+		return "", 0, err
+	}
+
+	sum, size, err := fileChecksum(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	checksum := []byte(sum + "  " + name + "\n")
+	if err := os.WriteFile(path+checksumSuffix, checksum, 0o600); err != nil {
+		return "", 0, fmt.Errorf("writing checksum: %w", err)
+	}
+
+	if b.bucket != nil {
+		if err := b.bucket.upload(ctx, path, sum); err != nil {
+			return "", 0, err
+		}
+
+		if err := b.bucket.put(ctx, name+checksumSuffix, checksum); err != nil {
+			return "", 0, err
+		}
+	}
+
+	return path, size, nil
+}
+
+func (b *Backuper) extension() string {
+	if b.db.Type == types.DatabasePostgres {
+		return ".dump"
+	}
+
+	return ".sqlite"
+}
+
+// dumpDatabase writes a consistent copy of the database to path, with
+// a snapshot for SQLite and pg_dump for Postgres.
+func (b *Backuper) dumpDatabase(ctx context.Context, path string) error {
+	if b.db.Type != types.DatabasePostgres {
+		return db.Snapshot(b.db, path)
+	}
+
+	cmd := exec.CommandContext(ctx, "pg_dump", "--format=custom", "--file="+path)
+	cmd.Env = append(os.Environ(), b.postgresEnv()...)
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running pg_dump: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// verify checks that a backup can be restored: the SQLite integrity
+// check for snapshots, reading the table of contents for pg_dump
+// archives.
+func (b *Backuper) verify(ctx context.Context, path string) error {
+	if b.db.Type != types.DatabasePostgres {
+		return db.VerifySnapshot(path)
+	}
+
+	cmd := exec.CommandContext(ctx, "pg_restore", "--list", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("verifying dump with pg_restore: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+func (b *Backuper) postgresEnv() []string {
+	env := []string{
+		"PGHOST=" + b.db.Postgres.Host,
+		"PGDATABASE=" + b.db.Postgres.Name,
+		"PGUSER=" + b.db.Postgres.User,
+	}
+
+	if b.db.Postgres.Port != 0 {
+		env = append(env, "PGPORT="+strconv.Itoa(b.db.Postgres.Port))
+	}
+
+	if b.db.Postgres.Pass != "" {
+		env = append(env, "PGPASSWORD="+b.db.Postgres.Pass)
+	}
+
+	// ssl is a boolean or a libpq sslmode, like when connecting.
+	if sslEnabled, err := strconv.ParseBool(b.db.Postgres.Ssl); err == nil {
+		if !sslEnabled {
+			env = append(env, "PGSSLMODE=disable")
+		}
+	} else if b.db.Postgres.Ssl != "" {
+		env = append(env, "PGSSLMODE="+b.db.Postgres.Ssl)
+	}
+
+	return env
+}
+
+// applyRetention removes the backups beyond the configured count and
+// age, locally and in the bucket.
+func (b *Backuper) applyRetention(ctx context.Context, now time.Time) error {
+	entries, err := os.ReadDir(b.cfg.Directory)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if isBackupName(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	for _, name := range expired(names, b.cfg.Keep, b.cfg.MaxAge, now) {
+		if err := os.Remove(filepath.Join(b.cfg.Directory, name)); err != nil {
+			return err
+		}
+		os.Remove(filepath.Join(b.cfg.Directory, name+checksumSuffix))
+
+		log.Info().Str("name", name).Msg("Removed old backup")
+	}
+
+	if b.bucket == nil {
+		return nil
+	}
+
+	names, err = b.bucket.list(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range expired(names, b.cfg.Keep, b.cfg.MaxAge, now) {
+		if err := b.bucket.remove(ctx, name, name+checksumSuffix); err != nil {
+			return err
+		}
+
+		log.Info().Str("name", name).Msg("Removed old backup from bucket")
+	}
+
+	return nil
+}
+
+func isBackupName(name string) bool {
+	_, ok := backupTime(name)
+
+	return ok
+}
+
+// backupTime returns when a backup was taken from its name.
+func backupTime(name string) (time.Time, bool) {
+	rest, ok := strings.CutPrefix(name, filePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	ext := filepath.Ext(rest)
+	if ext != ".sqlite" && ext != ".dump" {
+		return time.Time{}, false
+	}
+
+	taken, err := time.Parse(fileTimeFormat, strings.TrimSuffix(rest, ext))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return taken, true
+}
+
+// expired returns the backups that are not retained: all but the keep
+// newest, and those older than maxAge. A zero keep or maxAge does not
+// limit.
+func expired(names []string, keep int, maxAge time.Duration, now time.Time) []string {
+	names = slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		return !isBackupName(name)
+	})
+
+	// The names sort by the time the backups were taken.
+	slices.Sort(names)
+	slices.Reverse(names)
+
+	var remove []string
+	for index, name := range names {
+		taken, _ := backupTime(name)
+		if (keep > 0 && index >= keep) || (maxAge > 0 && now.Sub(taken) > maxAge) {
+			remove = append(remove, name)
+		}
+	}
+
+	return remove
+}
+
+func fileChecksum(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("hashing backup: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestBackupSQLite(t *testing.T) {
+	dir := t.TempDir()
+	dbCfg := types.DatabaseConfig{
+		Type: types.DatabaseSqlite,
+		Sqlite: types.SqliteConfig{
+			Path:          filepath.Join(dir, "headscale.db"),
+			WriteAheadLog: true,
+		},
+	}
+
+	if _, err := db.NewHeadscaleDatabase(dbCfg, ""); err != nil {
+		t.Fatalf("setting up database: %s", err)
+	}
+
+	backupDir := filepath.Join(dir, "backups")
+	if err := os.MkdirAll(backupDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	// An old backup beyond the retention.
+	old := filepath.Join(backupDir, "headscale-20200101T000000Z.sqlite")
+	if err := os.WriteFile(old, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	backuper, err := New(context.Background(), types.BackupConfig{
+		Schedule:  "@daily",
+		Directory: backupDir,
+		Keep:      1,
+	}, dbCfg)
+	if err != nil {
+		t.Fatalf("New() error = %s", err)
+	}
+
+	path, err := backuper.Backup(context.Background())
+	if err != nil {
+		t.Fatalf("Backup() error = %s", err)
+	}
+
+	if err := db.VerifySnapshot(path); err != nil {
+		t.Errorf("backup is not a valid database: %s", err)
+	}
+
+	sum, _, err := fileChecksum(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksum, err := os.ReadFile(path + checksumSuffix)
+	if err != nil {
+		t.Fatalf("reading checksum: %s", err)
+	}
+	if want := sum + "  " + filepath.Base(path) + "\n"; string(checksum) != want {
+		t.Errorf("checksum = %q, want %q", checksum, want)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("old backup was not removed by the retention")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Date(2026, time.October, 17, 3, 0, 0, 0, time.UTC)
+	names := []string{
+		"headscale-20261014T030000Z.sqlite",
+		"headscale-20261017T030000Z.sqlite",
+		"headscale-20261016T030000Z.sqlite",
+		"headscale-20261015T030000Z.sqlite",
+		"unrelated.sqlite",
+	}
+
+	tests := []struct {
+		name   string
+		keep   int
+		maxAge time.Duration
+		want   []string
+	}{
+		{
+			name: "keep-all",
+		},
+		{
+			name: "keep-two",
+			keep: 2,
+			want: []string{
+				"headscale-20261015T030000Z.sqlite",
+				"headscale-20261014T030000Z.sqlite",
+			},
+		},
+		{
+			name:   "max-age",
+			maxAge: 48 * time.Hour,
+			want:   []string{"headscale-20261014T030000Z.sqlite"},
+		},
+		{
+			name:   "keep-and-max-age",
+			keep:   3,
+			maxAge: 24 * time.Hour,
+			want: []string{
+				"headscale-20261015T030000Z.sqlite",
+				"headscale-20261014T030000Z.sqlite",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expired(names, tt.keep, tt.maxAge, now)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("expired() unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if !strings.HasPrefix(names[0], "headscale-20261014") {
+		t.Errorf("expired() modified its input")
+	}
+}
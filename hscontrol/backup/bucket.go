@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+// defaultRegion is used when neither the configuration nor the AWS
+// environment sets one, S3 compatible stores usually ignore it.
+const defaultRegion = "us-east-1"
+
+// bucket is an S3 compatible bucket backups are uploaded to.
+type bucket struct {
+	client *s3.Client
+	name   string
+	prefix string
+}
+
+func newBucket(ctx context.Context, cfg types.BackupS3Config) (*bucket, error) {
+	var opts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKeyID != "" {
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("loading S3 configuration: %w", err)
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = defaultRegion
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+		o.UsePathStyle = cfg.UsePathStyle
+	})
+
+	return &bucket{
+		client: client,
+		name:   cfg.Bucket,
+		prefix: cfg.Prefix,
+	}, nil
+}
+
+func (b *bucket) key(name string) string {
+	return path.Join(b.prefix, name)
+}
+
+// upload uploads the file with its SHA-256, the bucket rejects the
+// upload if the content it received does not match.
+func (b *bucket) upload(ctx context.Context, filePath string, hexSum string) error {
+	sum, err := hex.DecodeString(hexSum)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:         aws.String(b.name),
+		Key:            aws.String(b.key(path.Base(filePath))),
+		Body:           file,
+		ChecksumSHA256: aws.String(base64.StdEncoding.EncodeToString(sum)),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading backup to bucket %s: %w", b.name, err)
+	}
+
+	return nil
+}
+
+func (b *bucket) put(ctx context.Context, name string, data []byte) error {
+	_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(b.name),
+		Key:    aws.String(b.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading %s to bucket %s: %w", name, b.name, err)
+	}
+
+	return nil
+}
+
+// list returns the names of the backups in the bucket.
+func (b *bucket) list(ctx context.Context) ([]string, error) {
+	prefix := b.key(filePrefix)
+
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.name),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("listing bucket %s: %w", b.name, err)
+		}
+
+		for _, object := range page.Contents {
+			name := path.Base(aws.ToString(object.Key))
+			if strings.HasPrefix(aws.ToString(object.Key), prefix) && isBackupName(name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names, nil
+}
+
+func (b *bucket) remove(ctx context.Context, names ...string) error {
+	objects := make([]s3types.ObjectIdentifier, 0, len(names))
+	for _, name := range names {
+		objects = append(objects, s3types.ObjectIdentifier{Key: aws.String(b.key(name))})
+	}
+
+	_, err := b.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(b.name),
+		Delete: &s3types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		return fmt.Errorf("removing backups from bucket %s: %w", b.name, err)
+	}
+
+	return nil
+}
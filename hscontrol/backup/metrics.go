@@ -0,0 +1,31 @@
+package backup
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const prometheusNamespace = "headscale"
+
+var (
+	backupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "backups_total",
+		Help:      "total count of database backups by result",
+	}, []string{"status"})
+	backupLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "backup_last_success_timestamp_seconds",
+		Help:      "unix time the last successful database backup was started",
+	})
+	backupDuration = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "backup_last_duration_seconds",
+		Help:      "duration of the last database backup",
+	})
+	backupSize = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "backup_last_size_bytes",
+		Help:      "size of the last successful database backup",
+	})
+)
@@ -0,0 +1,253 @@
+package hscontrol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+const (
+	// c2nTimeout is how long a node has to answer a control-to-node
+	// request.
+	c2nTimeout = 30 * time.Second
+
+	// c2nMaxAnswerSize limits the answers nodes can send, debug
+	// endpoints like goroutines can be large.
+	c2nMaxAnswerSize = 10 << 20
+
+	// pingTimeout is how long a node has to post the result of a ping,
+	// the client gives up on the ping itself after 10 seconds and does
+	// not post a result then.
+	pingTimeout = 15 * time.Second
+)
+
+var (
+	ErrC2NEndpoint    = errors.New("unsupported c2n endpoint")
+	ErrC2NNodeOffline = errors.New("node is not connected")
+)
+
+// c2nEndpoints are the endpoints of the Tailscale client headscale can
+// request, and the methods they accept.
+var c2nEndpoints = map[string][]string{
+	"/echo":                    {http.MethodGet, http.MethodPost},
+	"/debug/component-logging": {http.MethodPost},
+	"/debug/goroutines":        {http.MethodGet},
+	"/debug/logheap":           {http.MethodGet},
+	"/debug/metrics":           {http.MethodGet},
+	"/debug/prefs":             {http.MethodGet},
+	"/logtail/flush":           {http.MethodPost},
+	"/netfilter-kind":          {http.MethodGet},
+	"/sockstats":               {http.MethodGet},
+	"/tls-cert-status":         {http.MethodGet},
+	"/update":                  {http.MethodGet, http.MethodPost},
+}
+
+// c2nRequests are the control-to-node requests waiting for the answer
+// of their node.
+type c2nRequests struct {
+	mu      sync.Mutex
+	pending map[string]pendingC2N
+}
+
+type pendingC2N struct {
+	machineKey key.MachinePublic
+	answer     chan []byte
+}
+
+func (r *c2nRequests) add(id string, machineKey key.MachinePublic) chan []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pending == nil {
+		r.pending = make(map[string]pendingC2N)
+	}
+
+	answer := make(chan []byte, 1)
+	r.pending[id] = pendingC2N{machineKey: machineKey, answer: answer}
+
+	return answer
+}
+
+func (r *c2nRequests) remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, id)
+}
+
+// answer hands the answer to the request, if the request is waiting
+// for an answer from the machine.
+func (r *c2nRequests) answer(id string, machineKey key.MachinePublic, answer []byte) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending, ok := r.pending[id]
+	if !ok || pending.machineKey != machineKey {
+		return false
+	}
+
+	delete(r.pending, id)
+	pending.answer <- answer
+
+	return true
+}
+
+// c2nRequest sends an HTTP request to the c2n handler of a connected
+// node, through a ping request in its map stream, and waits for the
+// node to post the response back over Noise.
+func (h *Headscale) c2nRequest(
+	ctx context.Context,
+	node *types.Node,
+	method, path string,
+	body []byte,
+) (*http.Response, error) {
+	endpoint, _, _ := strings.Cut(path, "?")
+	if methods, ok := c2nEndpoints[endpoint]; !ok || !slices.Contains(methods, method) {
+		return nil, fmt.Errorf("%w: %s %s", ErrC2NEndpoint, method, endpoint)
+	}
+
+	if !h.nodeNotifier.IsConnected(node.ID) {
+		return nil, ErrC2NNodeOffline
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, "http://node"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("C2n-Handler-Timeout", c2nTimeout.String())
+
+	var payload bytes.Buffer
+	if err := req.Write(&payload); err != nil {
+		return nil, err
+	}
+
+	id, err := util.GenerateRandomStringURLSafe(16)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := h.c2nRequests.add(id, node.MachineKey)
+	defer h.c2nRequests.remove(id)
+
+	ctx, cancel := context.WithTimeout(ctx, c2nTimeout)
+	defer cancel()
+
+	h.nodeNotifier.NotifyByNodeID(
+		types.NotifyCtx(ctx, "c2n", node.Hostname),
+		types.StateUpdate{
+			Type: types.StatePingRequest,
+			PingRequest: &tailcfg.PingRequest{
+				URL:        strings.TrimSuffix(h.cfg.ServerURL, "/") + "/machine/c2n/" + id,
+				URLIsNoise: true,
+				Types:      "c2n",
+				Payload:    payload.Bytes(),
+			},
+		},
+		node.ID,
+	)
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for the answer of the node: %w", ctx.Err())
+	case raw := <-answer:
+		return http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), req)
+	}
+}
+
+// pingFromNode asks a connected node to send a TSMP ping, through
+// WireGuard, to ip and waits for the node to post the result back over
+// Noise, like the answers to c2n requests.
+func (h *Headscale) pingFromNode(
+	ctx context.Context,
+	node *types.Node,
+	ip netip.Addr,
+) (*tailcfg.PingResponse, error) {
+	if !h.nodeNotifier.IsConnected(node.ID) {
+		return nil, ErrC2NNodeOffline
+	}
+
+	id, err := util.GenerateRandomStringURLSafe(16)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := h.c2nRequests.add(id, node.MachineKey)
+	defer h.c2nRequests.remove(id)
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	h.nodeNotifier.NotifyByNodeID(
+		types.NotifyCtx(ctx, "ping", node.Hostname),
+		types.StateUpdate{
+			Type: types.StatePingRequest,
+			PingRequest: &tailcfg.PingRequest{
+				URL:        strings.TrimSuffix(h.cfg.ServerURL, "/") + "/machine/c2n/" + id,
+				URLIsNoise: true,
+				Types:      string(tailcfg.PingTSMP),
+				IP:         ip,
+			},
+		},
+		node.ID,
+	)
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for the ping result of the node: %w", ctx.Err())
+	case raw := <-answer:
+		var res tailcfg.PingResponse
+		if err := json.Unmarshal(raw, &res); err != nil {
+			return nil, fmt.Errorf("decoding the ping result: %w", err)
+		}
+		if res.Err != "" {
+			return &res, errors.New(res.Err)
+		}
+
+		return &res, nil
+	}
+}
+
+// NoiseC2NAnswerHandler receives the answers of nodes to control-to-node
+// requests, and the results of pings. Only the node the request was sent
+// to can answer it.
+func (ns *noiseServer) NoiseC2NAnswerHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	id := mux.Vars(req)["id"]
+
+	answer, err := io.ReadAll(io.LimitReader(req.Body, c2nMaxAnswerSize))
+	if err != nil {
+		http.Error(writer, "reading answer", http.StatusBadRequest)
+
+		return
+	}
+
+	if !ns.headscale.c2nRequests.answer(id, ns.machineKey, answer) {
+		log.Debug().
+			Str("machine_key", ns.machineKey.ShortString()).
+			Msg("Received an answer to an unknown c2n request")
+		http.NotFound(writer, req)
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusOK)
+}
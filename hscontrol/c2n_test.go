@@ -0,0 +1,91 @@
+package hscontrol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tailscale.com/types/key"
+)
+
+func TestNodeC2N(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{ServerURL: "https://headscale.example.com"})
+
+	node := createTestNode(t, h, "alice", &types.Node{Hostname: "laptop"})
+
+	request := &v1.NodeC2NRequest{NodeId: uint64(node.ID), Path: "/debug/prefs"}
+
+	if _, err := api.NodeC2N(context.Background(), request); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("NodeC2N() to a disconnected node error = %v, want %s", err, codes.FailedPrecondition)
+	}
+
+	updates := make(chan types.StateUpdate, 1)
+	h.nodeNotifier.AddNode(node.ID, updates)
+
+	// The node runs the request it gets in its map stream and posts
+	// the response back.
+	answer := func(machineKey key.MachinePublic) {
+		update := <-updates
+		if update.Type != types.StatePingRequest || update.PingRequest.Types != "c2n" {
+			t.Errorf("update = %+v, want c2n ping request", update)
+
+			return
+		}
+
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(update.PingRequest.Payload)))
+		if err != nil {
+			t.Errorf("reading c2n request: %s", err)
+
+			return
+		}
+
+		var resp bytes.Buffer
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.WriteString(`{"path":"` + req.URL.Path + `"}`)
+		rec.Result().Write(&resp) //nolint
+
+		id := update.PingRequest.URL[strings.LastIndex(update.PingRequest.URL, "/")+1:]
+		post := mux.SetURLVars(httptest.NewRequest(http.MethodPost, update.PingRequest.URL, &resp), map[string]string{"id": id})
+		ns := &noiseServer{headscale: h, machineKey: machineKey}
+		ns.NoiseC2NAnswerHandler(httptest.NewRecorder(), post)
+	}
+
+	go answer(node.MachineKey)
+
+	resp, err := api.NodeC2N(context.Background(), request)
+	if err != nil {
+		t.Fatalf("NodeC2N() error = %s", err)
+	}
+	if resp.GetStatusCode() != http.StatusOK || resp.GetContentType() != "application/json" ||
+		string(resp.GetBody()) != `{"path":"/debug/prefs"}` {
+		t.Errorf("NodeC2N() = %v", resp)
+	}
+
+	if _, err := api.NodeC2N(context.Background(), &v1.NodeC2NRequest{
+		NodeId: uint64(node.ID),
+		Method: http.MethodPost,
+		Path:   "/debug/prefs",
+	}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("NodeC2N() to an unsupported endpoint error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	// Another machine cannot answer the request.
+	go answer(key.NewMachine().Public())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, err := api.NodeC2N(ctx, request); status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("NodeC2N() answered by another machine error = %v, want %s", err, codes.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,252 @@
+package hscontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+)
+
+const (
+	canaryStepRegister = "register"
+	canaryStepMap      = "map"
+	canaryStepPing     = "ping"
+)
+
+var (
+	canaryRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "canary_runs_total",
+		Help:      "total count of canary runs",
+	}, []string{"result"})
+	canaryStepDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "canary_step_duration_seconds",
+		Help:      "duration of the steps of the last canary run",
+	}, []string{"step"})
+	canaryStepSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "canary_step_success",
+		Help:      "whether the steps of the last canary run succeeded",
+	}, []string{"step"})
+	canaryLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "canary_last_success_timestamp_seconds",
+		Help:      "unix time of the last successful canary run",
+	})
+	canaryPingDERP = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "canary_ping_derp_region",
+		Help:      "DERP region the last canary ping went through, 0 if direct",
+	})
+)
+
+var errCanaryPeerNotFound = errors.New("peer not found in the map")
+
+// canaryRun is the outcome of one canary run.
+type canaryRun struct {
+	steps map[string]time.Duration
+	err   error
+
+	// failed is the step that failed.
+	failed string
+
+	// derpRegion is the DERP region the ping went through.
+	derpRegion int
+}
+
+// runCanary registers a new ephemeral node every interval, waits for
+// its map and pings the configured peer, and reports the results as
+// metrics. It goes through the public control and DERP endpoints like
+// any other node.
+func (h *Headscale) runCanary(ctx context.Context) {
+	cfg := h.cfg.Canary
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+		run := canaryOnce(runCtx, cfg)
+		cancel()
+
+		recordCanaryRun(run, cfg.Peer != "")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func recordCanaryRun(run canaryRun, ping bool) {
+	steps := []string{canaryStepRegister, canaryStepMap}
+	if ping {
+		steps = append(steps, canaryStepPing)
+	}
+
+	for _, step := range steps {
+		duration, done := run.steps[step]
+		canaryStepDuration.WithLabelValues(step).Set(duration.Seconds())
+		if done {
+			canaryStepSuccess.WithLabelValues(step).Set(1)
+		} else {
+			canaryStepSuccess.WithLabelValues(step).Set(0)
+		}
+	}
+
+	if run.err != nil {
+		canaryRuns.WithLabelValues("failure").Inc()
+		log.Warn().Err(run.err).Str("step", run.failed).Msg("Canary run failed")
+
+		return
+	}
+
+	canaryRuns.WithLabelValues("success").Inc()
+	canaryLastSuccess.SetToCurrentTime()
+	if ping {
+		canaryPingDERP.Set(float64(run.derpRegion))
+	}
+}
+
+func canaryOnce(ctx context.Context, cfg types.CanaryConfig) canaryRun {
+	run := canaryRun{steps: make(map[string]time.Duration)}
+	fail := func(step string, err error) canaryRun {
+		run.failed = step
+		run.err = err
+
+		return run
+	}
+
+	// The state is kept in memory so every run registers a new node.
+	node := &tsnet.Server{
+		Dir:        cfg.StateDir,
+		Store:      new(mem.Store),
+		Hostname:   cfg.Hostname,
+		AuthKey:    cfg.AuthKey,
+		ControlURL: cfg.ControlURL,
+		Ephemeral:  true,
+		Logf:       logger.Discard,
+	}
+	defer node.Close()
+
+	start := time.Now()
+	if err := node.Start(); err != nil {
+		return fail(canaryStepRegister, err)
+	}
+
+	client, err := node.LocalClient()
+	if err != nil {
+		return fail(canaryStepRegister, err)
+	}
+
+	// The node is removed right away instead of waiting for the
+	// ephemeral node inactivity timeout.
+	defer func() {
+		logoutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := client.Logout(logoutCtx); err != nil {
+			log.Debug().Err(err).Msg("failed to log out the canary node")
+		}
+	}()
+
+	watcher, err := client.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyInitialNetMap)
+	if err != nil {
+		return fail(canaryStepRegister, err)
+	}
+	defer watcher.Close()
+
+	step := canaryStepRegister
+	var nm *netmap.NetworkMap
+	for nm == nil {
+		notify, err := watcher.Next()
+		if err != nil {
+			return fail(step, err)
+		}
+
+		if notify.ErrMessage != nil {
+			return fail(step, errors.New(*notify.ErrMessage))
+		}
+
+		if step == canaryStepRegister && (notify.NetMap != nil ||
+			notify.State != nil && (*notify.State == ipn.Starting || *notify.State == ipn.Running)) {
+			run.steps[canaryStepRegister] = time.Since(start)
+			step = canaryStepMap
+		}
+
+		nm = notify.NetMap
+	}
+	run.steps[canaryStepMap] = time.Since(start) - run.steps[canaryStepRegister]
+
+	if cfg.Peer == "" {
+		return run
+	}
+
+	addr, err := canaryPeerAddr(nm, cfg.Peer)
+	if err != nil {
+		return fail(canaryStepPing, fmt.Errorf("%s: %w", cfg.Peer, err))
+	}
+
+	// The peer only learns about the canary with its next map update,
+	// the ping is retried until then.
+	pingStart := time.Now()
+	for {
+		var res *ipnstate.PingResult
+		res, err = client.Ping(ctx, addr, tailcfg.PingDisco)
+		if err == nil && res.Err != "" {
+			err = errors.New(res.Err)
+		}
+		if err == nil {
+			run.steps[canaryStepPing] = time.Duration(res.LatencySeconds * float64(time.Second))
+			run.derpRegion = res.DERPRegionID
+
+			return run
+		}
+
+		select {
+		case <-ctx.Done():
+			return fail(canaryStepPing, fmt.Errorf("pinging %s for %s: %w", addr, time.Since(pingStart).Round(time.Second), err))
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// canaryPeerAddr returns the tailnet address of the peer, given by its
+// address, hostname or MagicDNS name.
+func canaryPeerAddr(nm *netmap.NetworkMap, peer string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(peer); err == nil {
+		return addr, nil
+	}
+
+	for _, node := range nm.Peers {
+		name := strings.TrimSuffix(node.Name(), ".")
+		host, _, _ := strings.Cut(name, ".")
+
+		if peer != name && peer != host && peer != node.Hostinfo().Hostname() {
+			continue
+		}
+
+		if node.Addresses().Len() == 0 {
+			break
+		}
+
+		return node.Addresses().At(0).Addr(), nil
+	}
+
+	return netip.Addr{}, errCanaryPeerNotFound
+}
@@ -0,0 +1,46 @@
+package hscontrol
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestCanaryPeerAddr(t *testing.T) {
+	nm := &netmap.NetworkMap{
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				Name:      "monitor.ops.example.com.",
+				Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+				Hostinfo:  (&tailcfg.Hostinfo{Hostname: "monitor-1"}).View(),
+			}).View(),
+		},
+	}
+
+	tests := []struct {
+		peer    string
+		want    netip.Addr
+		wantErr error
+	}{
+		{peer: "monitor", want: netip.MustParseAddr("100.64.0.2")},
+		{peer: "monitor.ops.example.com", want: netip.MustParseAddr("100.64.0.2")},
+		{peer: "monitor-1", want: netip.MustParseAddr("100.64.0.2")},
+		{peer: "100.64.0.9", want: netip.MustParseAddr("100.64.0.9")},
+		{peer: "database", wantErr: errCanaryPeerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.peer, func(t *testing.T) {
+			got, err := canaryPeerAddr(nm, tt.peer)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("canaryPeerAddr() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("canaryPeerAddr() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/exp/maps"
+)
+
+const (
+	// ClientPolicyPath serves the settings recommended for the clients
+	// as system policy keys, for MDM and fleet management tools.
+	ClientPolicyPath = "/client-policy"
+
+	// ClientPolicyWindowsPath serves the settings as a registry file
+	// for Windows, e.g. to import with Group Policy.
+	ClientPolicyWindowsPath = "/client-policy/windows.reg"
+
+	clientPolicyWindowsRegistryKey = `HKEY_LOCAL_MACHINE\SOFTWARE\Policies\Tailscale`
+)
+
+// ClientPolicy is the configuration document of the clients, the
+// system policy keys they read from their MDM or their OS.
+type ClientPolicy struct {
+	ServerURL string                          `json:"server_url"`
+	Settings  map[string]string               `json:"settings"`
+	Platforms map[string]ClientPolicyPlatform `json:"platforms"`
+}
+
+// ClientPolicyPlatform tells where a platform reads the system policy
+// keys from.
+type ClientPolicyPlatform struct {
+	// RegistryKey holds the keys as string values on Windows.
+	RegistryKey string `json:"registry_key,omitempty"`
+
+	// PreferenceDomains are the domains of the configuration profile
+	// payloads on Apple platforms, one per variant of the client.
+	PreferenceDomains []string `json:"preference_domains,omitempty"`
+
+	// ManagedConfiguration is the package the managed configuration is
+	// set for on Android.
+	ManagedConfiguration string `json:"managed_configuration,omitempty"`
+}
+
+var clientPolicyPlatforms = map[string]ClientPolicyPlatform{
+	"windows": {RegistryKey: clientPolicyWindowsRegistryKey},
+	"macos":   {PreferenceDomains: []string{"io.tailscale.ipn.macsys", "io.tailscale.ipn.macos"}},
+	"ios":     {PreferenceDomains: []string{"io.tailscale.ipn.ios"}},
+	"android": {ManagedConfiguration: "com.tailscale.ipn"},
+}
+
+// clientPolicy returns the configuration document of the clients, the
+// server URL is set as LoginURL.
+func (h *Headscale) clientPolicy() ClientPolicy {
+	settings := maps.Clone(h.cfg.ClientPolicy.Settings)
+	if settings == nil {
+		settings = map[string]string{}
+	}
+	settings["LoginURL"] = h.cfg.ServerURL
+
+	return ClientPolicy{
+		ServerURL: h.cfg.ServerURL,
+		Settings:  settings,
+		Platforms: clientPolicyPlatforms,
+	}
+}
+
+// ClientPolicyHandler returns the configuration document of the clients
+// as JSON, for fleet management tools to point devices at headscale.
+// Listens in /client-policy.
+func (h *Headscale) ClientPolicyHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(writer).Encode(h.clientPolicy()); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// ClientPolicyWindowsHandler returns the settings of the clients as a
+// registry file setting the policy keys of the Windows client.
+// Listens in /client-policy/windows.reg.
+func (h *Headscale) ClientPolicyWindowsHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.Header().Set("Content-Disposition", `attachment; filename="headscale.reg"`)
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write([]byte(windowsRegistryFile(h.clientPolicy().Settings))); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
+}
+
+// windowsRegistryFile returns a registry file setting the settings as
+// string values of the policy key of the Windows client.
+func windowsRegistryFile(settings map[string]string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+	var reg strings.Builder
+	reg.WriteString("Windows Registry Editor Version 5.00\r\n\r\n")
+	fmt.Fprintf(&reg, "[%s]\r\n", clientPolicyWindowsRegistryKey)
+
+	keys := maps.Keys(settings)
+	slices.Sort(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&reg, "\"%s\"=\"%s\"\r\n", key, escape.Replace(settings[key]))
+	}
+
+	return reg.String()
+}
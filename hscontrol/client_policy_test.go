@@ -0,0 +1,64 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestClientPolicyHandler(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{
+		ServerURL: "https://headscale.example.com",
+		ClientPolicy: types.ClientPolicyConfig{
+			Settings: map[string]string{
+				"UnattendedMode":            "always",
+				"ManagedByOrganizationName": `Example "IT"`,
+			},
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	h.ClientPolicyHandler(rec, httptest.NewRequest(http.MethodGet, ClientPolicyPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("client policy status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var got ClientPolicy
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding client policy %q: %s", rec.Body.String(), err)
+	}
+
+	want := map[string]string{
+		"LoginURL":                  "https://headscale.example.com",
+		"UnattendedMode":            "always",
+		"ManagedByOrganizationName": `Example "IT"`,
+	}
+	if diff := cmp.Diff(want, got.Settings); diff != "" {
+		t.Errorf("client policy settings unexpected result (-want +got):\n%s", diff)
+	}
+	if got.Platforms["windows"].RegistryKey == "" {
+		t.Errorf("client policy does not tell where Windows reads the settings from")
+	}
+	if _, ok := h.cfg.ClientPolicy.Settings["LoginURL"]; ok {
+		t.Errorf("client policy modified the configured settings")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ClientPolicyWindowsHandler(rec, httptest.NewRequest(http.MethodGet, ClientPolicyWindowsPath, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Windows client policy status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	wantReg := "Windows Registry Editor Version 5.00\r\n\r\n" +
+		"[HKEY_LOCAL_MACHINE\\SOFTWARE\\Policies\\Tailscale]\r\n" +
+		"\"LoginURL\"=\"https://headscale.example.com\"\r\n" +
+		"\"ManagedByOrganizationName\"=\"Example \\\"IT\\\"\"\r\n" +
+		"\"UnattendedMode\"=\"always\"\r\n"
+	if diff := cmp.Diff(wantReg, rec.Body.String()); diff != "" {
+		t.Errorf("Windows registry file unexpected result (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,130 @@
+package hscontrol
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+// connectivityMatrix checks every ordered pair of the given nodes and
+// reports why the source might not be able to reach the destination.
+// It only uses what headscale knows: the packet filter, which nodes are
+// connected and the netcheck reports in the Hostinfo of the nodes.
+// allowedBy names the ACL rules allowing a pair.
+func connectivityMatrix(
+	nodes types.Nodes,
+	filter []tailcfg.FilterRule,
+	allowedBy func(src, dst *types.Node) []string,
+	isOnline func(types.NodeID) bool,
+) []*v1.ConnectivityCheck {
+	var checks []*v1.ConnectivityCheck
+
+	for _, src := range nodes {
+		for _, dst := range nodes {
+			if src.ID == dst.ID {
+				continue
+			}
+
+			check := &v1.ConnectivityCheck{
+				SourceId:        src.ID.Uint64(),
+				SourceName:      src.GivenName,
+				DestinationId:   dst.ID.Uint64(),
+				DestinationName: dst.GivenName,
+				AclAllowed:      src.CanAccess(filter, dst),
+				Online:          isOnline(src.ID) && isOnline(dst.ID),
+			}
+
+			for _, node := range []*types.Node{src, dst} {
+				if !isOnline(node.ID) {
+					check.Problems = append(check.Problems, node.GivenName+" is offline")
+				}
+				if node.IsExpired() {
+					check.Problems = append(check.Problems, "the key of "+node.GivenName+" is expired")
+				}
+			}
+
+			if check.GetAclAllowed() {
+				check.AllowedBy = allowedBy(src, dst)
+			} else {
+				check.Problems = append(check.Problems, fmt.Sprintf(
+					"the policy does not allow %s to reach %s",
+					src.GivenName,
+					dst.GivenName,
+				))
+			}
+
+			srcInfo, dstInfo := netInfo(src), netInfo(dst)
+			if srcInfo == nil || dstInfo == nil {
+				check.Problems = append(check.Problems, "no netcheck report, DERP and NAT were not checked")
+				checks = append(checks, check)
+
+				continue
+			}
+
+			check.SharedDerp = sharesDERPRegion(srcInfo, dstInfo)
+			if !check.GetSharedDerp() {
+				check.Problems = append(check.Problems, "no DERP region is reachable by both nodes")
+			}
+
+			for _, node := range []*types.Node{src, dst} {
+				if working, ok := netInfo(node).WorkingUDP.Get(); ok && !working {
+					check.Problems = append(check.Problems, fmt.Sprintf(
+						"%s cannot use UDP, traffic is relayed through DERP",
+						node.GivenName,
+					))
+				}
+			}
+
+			srcHard, _ := srcInfo.MappingVariesByDestIP.Get()
+			dstHard, _ := dstInfo.MappingVariesByDestIP.Get()
+			if srcHard && dstHard {
+				check.Problems = append(
+					check.Problems,
+					"both nodes are behind a hard NAT, traffic is likely relayed through DERP",
+				)
+			}
+
+			checks = append(checks, check)
+		}
+	}
+
+	return checks
+}
+
+func netInfo(node *types.Node) *tailcfg.NetInfo {
+	if node.Hostinfo == nil {
+		return nil
+	}
+
+	return node.Hostinfo.NetInfo
+}
+
+// sharesDERPRegion reports if there is a DERP region both nodes can
+// reach, according to their netcheck reports. The latencies are keyed
+// by region and address family, like "1-v4".
+func sharesDERPRegion(a, b *tailcfg.NetInfo) bool {
+	regions := func(ni *tailcfg.NetInfo) map[string]bool {
+		ret := map[string]bool{}
+		if ni.PreferredDERP != 0 {
+			ret[fmt.Sprint(ni.PreferredDERP)] = true
+		}
+		for key := range ni.DERPLatency {
+			region, _, _ := strings.Cut(key, "-")
+			ret[region] = true
+		}
+
+		return ret
+	}
+
+	aRegions := regions(a)
+	for region := range regions(b) {
+		if aRegions[region] {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package hscontrol
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+)
+
+func TestConnectivityMatrix(t *testing.T) {
+	ip := func(s string) *netip.Addr {
+		addr := netip.MustParseAddr(s)
+
+		return &addr
+	}
+
+	laptop := &types.Node{
+		ID:        1,
+		GivenName: "laptop",
+		IPv4:      ip("100.64.0.1"),
+		Hostinfo: &tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{
+			PreferredDERP:         1,
+			DERPLatency:           map[string]float64{"1-v4": 0.01},
+			MappingVariesByDestIP: opt.NewBool(true),
+			WorkingUDP:            opt.NewBool(true),
+		}},
+	}
+	server := &types.Node{
+		ID:        2,
+		GivenName: "server",
+		IPv4:      ip("100.64.0.2"),
+		Hostinfo: &tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{
+			PreferredDERP:         2,
+			DERPLatency:           map[string]float64{"2-v4": 0.01},
+			MappingVariesByDestIP: opt.NewBool(true),
+			WorkingUDP:            opt.NewBool(false),
+		}},
+	}
+	printer := &types.Node{
+		ID:        3,
+		GivenName: "printer",
+		IPv4:      ip("100.64.0.3"),
+	}
+
+	// The laptop may reach the server, nothing else is allowed.
+	filter := []tailcfg.FilterRule{
+		{
+			SrcIPs: []string{"100.64.0.1/32"},
+			DstPorts: []tailcfg.NetPortRange{
+				{IP: "100.64.0.2/32", Ports: tailcfg.PortRangeAny},
+			},
+		},
+	}
+
+	online := map[types.NodeID]bool{1: true, 2: true}
+	isOnline := func(id types.NodeID) bool { return online[id] }
+
+	allowedBy := func(src, dst *types.Node) []string { return []string{"laptop to server, ask the infra team"} }
+
+	checks := connectivityMatrix(types.Nodes{laptop, server, printer}, filter, allowedBy, isOnline)
+	if len(checks) != 6 {
+		t.Fatalf("connectivityMatrix() returned %d checks, want 6", len(checks))
+	}
+
+	got := checks[0]
+	if got.GetSourceName() != "laptop" || got.GetDestinationName() != "server" {
+		t.Fatalf("first check is %s -> %s, want laptop -> server", got.GetSourceName(), got.GetDestinationName())
+	}
+
+	if !got.GetAclAllowed() || !got.GetOnline() || got.GetSharedDerp() {
+		t.Errorf(
+			"laptop -> server: acl_allowed = %t, online = %t, shared_derp = %t, want true, true, false",
+			got.GetAclAllowed(),
+			got.GetOnline(),
+			got.GetSharedDerp(),
+		)
+	}
+
+	wantProblems := []string{
+		"no DERP region is reachable by both nodes",
+		"server cannot use UDP, traffic is relayed through DERP",
+		"both nodes are behind a hard NAT, traffic is likely relayed through DERP",
+	}
+	if diff := cmp.Diff(wantProblems, got.GetProblems()); diff != "" {
+		t.Errorf("laptop -> server problems mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"laptop to server, ask the infra team"}, got.GetAllowedBy()); diff != "" {
+		t.Errorf("laptop -> server allowed by mismatch (-want +got):\n%s", diff)
+	}
+
+	got = checks[1]
+	if len(got.GetAllowedBy()) != 0 {
+		t.Errorf("laptop -> printer allowed by = %v, want none", got.GetAllowedBy())
+	}
+
+	wantProblems = []string{
+		"printer is offline",
+		"the policy does not allow laptop to reach printer",
+		"no netcheck report, DERP and NAT were not checked",
+	}
+	if diff := cmp.Diff(wantProblems, got.GetProblems()); diff != "" {
+		t.Errorf("laptop -> printer problems mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -21,6 +21,8 @@ var ErrAPIKeyFailedToParse = errors.New("failed to parse ApiKey")
 // CreateAPIKey creates a new ApiKey in a user, and returns it.
 func (hsdb *HSDatabase) CreateAPIKey(
 	expiration *time.Time,
+	tags []string,
+	derpRegionID int,
 ) (string, *types.APIKey, error) {
 	prefix, err := util.GenerateRandomStringURLSafe(apiPrefixLength)
 	if err != nil {
@@ -41,9 +43,11 @@ func (hsdb *HSDatabase) CreateAPIKey(
 	}
 
 	key := types.APIKey{
-		Prefix:     prefix,
-		Hash:       hash,
-		Expiration: expiration,
+		Prefix:       prefix,
+		Hash:         hash,
+		Expiration:   expiration,
+		Tags:         tags,
+		DERPRegionID: derpRegionID,
 	}
 
 	if err := hsdb.DB.Save(&key).Error; err != nil {
@@ -102,6 +106,17 @@ func (hsdb *HSDatabase) ExpireAPIKey(key *types.APIKey) error {
 	return nil
 }
 
+// GetAPIKeyFromString returns the ApiKey for a full key, without
+// validating it.
+func (hsdb *HSDatabase) GetAPIKeyFromString(keyStr string) (*types.APIKey, error) {
+	prefix, _, found := strings.Cut(keyStr, ".")
+	if !found {
+		return nil, ErrAPIKeyFailedToParse
+	}
+
+	return hsdb.GetAPIKey(prefix)
+}
+
 func (hsdb *HSDatabase) ValidateAPIKey(keyStr string) (bool, error) {
 	prefix, hash, found := strings.Cut(keyStr, ".")
 	if !found {
@@ -15,12 +15,13 @@ import (
 	"github.com/go-gormigrate/gormigrate/v2"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/rs/zerolog/log"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
+var dbLog = util.NewSubsystemLogger(util.LogSubsystemDB)
+
 var errDatabaseNotSupported = errors.New("database type not supported")
 
 // KV is a key-value store in a psql table. For future use...
@@ -47,6 +48,14 @@ func NewHeadscaleDatabase(
 		return nil, err
 	}
 
+	// A replica does not migrate the database, the primary does. The
+	// migrations only read it when it is up to date.
+	if cfg.ReadOnly {
+		if err := rejectWrites(dbConn); err != nil {
+			return nil, fmt.Errorf("making database read-only: %w", err)
+		}
+	}
+
 	migrations := gormigrate.New(
 		dbConn,
 		gormigrate.DefaultOptions,
@@ -95,21 +104,21 @@ func NewHeadscaleDatabase(
 					// find all occourences of "false" and drop them. Then
 					// remove the column.
 					if tx.Migrator().HasColumn(&types.Node{}, "registered") {
-						log.Info().
+						dbLog.Info().
 							Msg(`Database has legacy "registered" column in node, removing...`)
 
 						nodes := types.Nodes{}
 						if err := tx.Not("registered").Find(&nodes).Error; err != nil {
-							log.Error().Err(err).Msg("Error accessing db")
+							dbLog.Error().Err(err).Msg("Error accessing db")
 						}
 
 						for _, node := range nodes {
-							log.Info().
+							dbLog.Info().
 								Str("node", node.Hostname).
 								Str("machine_key", node.MachineKey.ShortString()).
 								Msg("Deleting unregistered node")
 							if err := tx.Delete(&types.Node{}, node.ID).Error; err != nil {
-								log.Error().
+								dbLog.Error().
 									Err(err).
 									Str("node", node.Hostname).
 									Str("machine_key", node.MachineKey.ShortString()).
@@ -119,7 +128,7 @@ func NewHeadscaleDatabase(
 
 						err := tx.Migrator().DropColumn(&types.Node{}, "registered")
 						if err != nil {
-							log.Error().Err(err).Msg("Error dropping registered column")
+							dbLog.Error().Err(err).Msg("Error dropping registered column")
 						}
 					}
 
@@ -184,7 +193,7 @@ func NewHeadscaleDatabase(
 					}
 
 					if tx.Migrator().HasColumn(&types.Node{}, "enabled_routes") {
-						log.Info().
+						dbLog.Info().
 							Msgf("Database has legacy enabled_routes column in node, migrating...")
 
 						type NodeAux struct {
@@ -198,12 +207,12 @@ func NewHeadscaleDatabase(
 							Scan(&nodesAux).
 							Error
 						if err != nil {
-							log.Fatal().Err(err).Msg("Error accessing db")
+							dbLog.Fatal().Err(err).Msg("Error accessing db")
 						}
 						for _, node := range nodesAux {
 							for _, prefix := range node.EnabledRoutes {
 								if err != nil {
-									log.Error().
+									dbLog.Error().
 										Err(err).
 										Str("enabled_route", prefix.String()).
 										Msg("Error parsing enabled_route")
@@ -216,7 +225,7 @@ func NewHeadscaleDatabase(
 									First(&types.Route{}).
 									Error
 								if err == nil {
-									log.Info().
+									dbLog.Info().
 										Str("enabled_route", prefix.String()).
 										Msg("Route already migrated to new table, skipping")
 
@@ -230,9 +239,9 @@ func NewHeadscaleDatabase(
 									Prefix:     types.IPPrefix(prefix),
 								}
 								if err := tx.Create(&route).Error; err != nil {
-									log.Error().Err(err).Msg("Error creating route")
+									dbLog.Error().Err(err).Msg("Error creating route")
 								} else {
-									log.Info().
+									dbLog.Info().
 										Uint64("node_id", route.NodeID).
 										Str("prefix", prefix.String()).
 										Msg("Route migrated")
@@ -242,7 +251,7 @@ func NewHeadscaleDatabase(
 
 						err = tx.Migrator().DropColumn(&types.Node{}, "enabled_routes")
 						if err != nil {
-							log.Error().
+							dbLog.Error().
 								Err(err).
 								Msg("Error dropping enabled_routes column")
 						}
@@ -251,7 +260,7 @@ func NewHeadscaleDatabase(
 					if tx.Migrator().HasColumn(&types.Node{}, "given_name") {
 						nodes := types.Nodes{}
 						if err := tx.Find(&nodes).Error; err != nil {
-							log.Error().Err(err).Msg("Error accessing db")
+							dbLog.Error().Err(err).Msg("Error accessing db")
 						}
 
 						for item, node := range nodes {
@@ -260,7 +269,7 @@ func NewHeadscaleDatabase(
 									node.Hostname,
 								)
 								if err != nil {
-									log.Error().
+									dbLog.Error().
 										Caller().
 										Str("hostname", node.Hostname).
 										Err(err).
@@ -271,7 +280,7 @@ func NewHeadscaleDatabase(
 									GivenName: normalizedHostname,
 								}).Error
 								if err != nil {
-									log.Error().
+									dbLog.Error().
 										Caller().
 										Str("hostname", node.Hostname).
 										Err(err).
@@ -413,11 +422,251 @@ func NewHeadscaleDatabase(
 				},
 				Rollback: func(db *gorm.DB) error { return nil },
 			},
+			{
+				// Add the address a node last connected from.
+				ID: "202610171200",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.Node{}, "last_seen_addr") {
+						return tx.Migrator().AddColumn(&types.Node{}, "last_seen_addr")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add suspension of users.
+				ID: "202610171201",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.User{}, "suspended_at") {
+						return tx.Migrator().AddColumn(&types.User{}, "suspended_at")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the history of user names.
+				ID: "202610171202",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.UserAlias{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add passwords and TOTP secrets for the built-in
+				// authentication.
+				ID: "202610171203",
+				Migrate: func(tx *gorm.DB) error {
+					for _, column := range []string{"password_hash", "totp_secret"} {
+						if !tx.Migrator().HasColumn(&types.User{}, column) {
+							if err := tx.Migrator().AddColumn(&types.User{}, column); err != nil {
+								return err
+							}
+						}
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add tag scopes to API keys.
+				ID: "202610171204",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.APIKey{}, "tags") {
+						return tx.Migrator().AddColumn(&types.APIKey{}, "tags")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Record when and why routes became primary.
+				ID: "202610171205",
+				Migrate: func(tx *gorm.DB) error {
+					for _, column := range []string{"primary_changed_at", "primary_reason"} {
+						if !tx.Migrator().HasColumn(&types.Route{}, column) {
+							if err := tx.Migrator().AddColumn(&types.Route{}, column); err != nil {
+								return err
+							}
+						}
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the netcheck reports sent by nodes.
+				ID: "202610171206",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.NetcheckReport{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Keep the nodes waiting to be registered in the
+				// database, so they survive restarts.
+				ID: "202610171207",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.PendingRegistration{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the node options of pre auth keys.
+				ID: "202610171208",
+				Migrate: func(tx *gorm.DB) error {
+					for _, column := range []string{"node_name_prefix", "node_expiry", "approved_routes"} {
+						if !tx.Migrator().HasColumn(&types.PreAuthKey{}, column) {
+							if err := tx.Migrator().AddColumn(&types.PreAuthKey{}, column); err != nil {
+								return err
+							}
+						}
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Keep the refresh tokens of OIDC logins to check the
+				// allowlists after the login.
+				ID: "202610171209",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.OIDCSession{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Record the hardware attestation of nodes.
+				ID: "202610171210",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.Node{}, "attestation") {
+						return tx.Migrator().AddColumn(&types.Node{}, "attestation")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Record when nodes stopped advertising their routes.
+				ID: "202610171211",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.Route{}, "withdrawn_at") {
+						return tx.Migrator().AddColumn(&types.Route{}, "withdrawn_at")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the route history.
+				ID: "202610171212",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.RouteEvent{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add notes to nodes.
+				ID: "202610171213",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.Node{}, "note") {
+						return tx.Migrator().AddColumn(&types.Node{}, "note")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the revoked node and machine keys.
+				ID: "202610171214",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.RevokedKey{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the DERP home region pinned to nodes.
+				ID: "202610171215",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.Node{}, "derp_home_region") {
+						return tx.Migrator().AddColumn(&types.Node{}, "derp_home_region")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the service pre auth keys attribute their nodes to.
+				ID: "202610171216",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.PreAuthKey{}, "service") {
+						if err := tx.Migrator().AddColumn(&types.PreAuthKey{}, "service"); err != nil {
+							return err
+						}
+					}
+
+					if !tx.Migrator().HasColumn(&types.Node{}, "service") {
+						return tx.Migrator().AddColumn(&types.Node{}, "service")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the service accounts nodes are attributed to.
+				ID: "202610171217",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.ServiceAccount{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the standalone DERP relays registered with headscale.
+				ID: "202610171218",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.DERPRelay{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add the policies rolled out to canary nodes.
+				ID: "202610171219",
+				Migrate: func(tx *gorm.DB) error {
+					return tx.AutoMigrate(&types.PolicyRollout{})
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
+			{
+				// Add DERP region scopes to API keys.
+				ID: "202610171220",
+				Migrate: func(tx *gorm.DB) error {
+					if !tx.Migrator().HasColumn(&types.APIKey{}, "derp_region_id") {
+						return tx.Migrator().AddColumn(&types.APIKey{}, "derp_region_id")
+					}
+
+					return nil
+				},
+				Rollback: func(db *gorm.DB) error { return nil },
+			},
 		},
 	)
 
 	if err := runMigrations(cfg, dbConn, migrations); err != nil {
-		log.Fatal().Err(err).Msgf("Migration failed: %v", err)
+		if errors.Is(err, ErrReadOnly) {
+			dbLog.Fatal().Err(err).Msg("The database has pending migrations, start the primary with this version first")
+		}
+		dbLog.Fatal().Err(err).Msgf("Migration failed: %v", err)
 	}
 
 	db := HSDatabase{
@@ -433,7 +682,8 @@ func openDB(cfg types.DatabaseConfig) (*gorm.DB, error) {
 	// TODO(kradalby): Integrate this with zerolog
 	var dbLogger logger.Interface
 	if cfg.Debug {
-		dbLogger = util.NewDBLogWrapper(&log.Logger, cfg.Gorm.SlowThreshold, cfg.Gorm.SkipErrRecordNotFound, cfg.Gorm.ParameterizedQueries)
+		gormLog := dbLog.Logger()
+		dbLogger = util.NewDBLogWrapper(&gormLog, cfg.Gorm.SlowThreshold, cfg.Gorm.SkipErrRecordNotFound, cfg.Gorm.ParameterizedQueries)
 	} else {
 		dbLogger = logger.Default.LogMode(logger.Silent)
 	}
@@ -446,7 +696,7 @@ func openDB(cfg types.DatabaseConfig) (*gorm.DB, error) {
 			return nil, fmt.Errorf("creating directory for sqlite: %w", err)
 		}
 
-		log.Info().
+		dbLog.Info().
 			Str("database", types.DatabaseSqlite).
 			Str("path", cfg.Sqlite.Path).
 			Msg("Opening database")
@@ -459,21 +709,36 @@ func openDB(cfg types.DatabaseConfig) (*gorm.DB, error) {
 			},
 		)
 
-		if err := db.Exec(`
+		busyTimeout := cfg.Sqlite.BusyTimeout
+		if busyTimeout == 0 {
+			busyTimeout = defaultSQLiteBusyTimeout
+		}
+
+		if err := db.Exec(fmt.Sprintf(`
 			PRAGMA foreign_keys=ON;
-			PRAGMA busy_timeout=10000;
+			PRAGMA busy_timeout=%d;
 			PRAGMA auto_vacuum=INCREMENTAL;
 			PRAGMA synchronous=NORMAL;
-			`).Error; err != nil {
+			`, busyTimeout.Milliseconds())).Error; err != nil {
 			return nil, fmt.Errorf("enabling foreign keys: %w", err)
 		}
 
-		if cfg.Sqlite.WriteAheadLog {
-			if err := db.Exec(`
-				PRAGMA journal_mode=WAL;
-				PRAGMA wal_autocheckpoint=0;
-				`).Error; err != nil {
-				return nil, fmt.Errorf("setting WAL mode: %w", err)
+		journalMode := cfg.Sqlite.JournalMode
+		if journalMode == "" && cfg.Sqlite.WriteAheadLog {
+			journalMode = "wal"
+		}
+
+		if journalMode != "" {
+			if err := db.Exec("PRAGMA journal_mode=" + journalMode).Error; err != nil {
+				return nil, fmt.Errorf("setting journal mode %s: %w", journalMode, err)
+			}
+		}
+
+		if journalMode == "wal" {
+			if err := db.Exec(fmt.Sprintf(
+				"PRAGMA wal_autocheckpoint=%d", cfg.Sqlite.WALAutocheckpoint,
+			)).Error; err != nil {
+				return nil, fmt.Errorf("setting WAL autocheckpoint: %w", err)
 			}
 		}
 
@@ -495,7 +760,7 @@ func openDB(cfg types.DatabaseConfig) (*gorm.DB, error) {
 			cfg.Postgres.User,
 		)
 
-		log.Info().
+		dbLog.Info().
 			Str("database", types.DatabasePostgres).
 			Str("path", dbString).
 			Msg("Opening database")
@@ -516,6 +781,10 @@ func openDB(cfg types.DatabaseConfig) (*gorm.DB, error) {
 			dbString += fmt.Sprintf(" password=%s", cfg.Postgres.Pass)
 		}
 
+		if cfg.ReadOnly {
+			dbString += " default_transaction_read_only=on"
+		}
+
 		db, err := gorm.Open(postgres.Open(dbString), &gorm.Config{
 			Logger: dbLogger,
 		})
@@ -543,8 +812,9 @@ func openDB(cfg types.DatabaseConfig) (*gorm.DB, error) {
 func runMigrations(cfg types.DatabaseConfig, dbConn *gorm.DB, migrations *gormigrate.Gormigrate) error {
 	// Turn off foreign keys for the duration of the migration if using sqllite to
 	// prevent data loss due to the way the GORM migrator handles certain schema
-	// changes.
-	if cfg.Type == types.DatabaseSqlite {
+	// changes. A read-only database is not migrated, it only checks the
+	// migrations have been run.
+	if cfg.Type == types.DatabaseSqlite && !cfg.ReadOnly {
 		var fkEnabled int
 		if err := dbConn.Raw("PRAGMA foreign_keys").Scan(&fkEnabled).Error; err != nil {
 			return fmt.Errorf("checking foreign key status: %w", err)
@@ -563,7 +833,7 @@ func runMigrations(cfg types.DatabaseConfig, dbConn *gorm.DB, migrations *gormig
 
 	// Since we disabled foreign keys for the migration, we need to check for
 	// constraint violations manually at the end of the migration.
-	if cfg.Type == types.DatabaseSqlite {
+	if cfg.Type == types.DatabaseSqlite && !cfg.ReadOnly {
 		type constraintViolation struct {
 			Table           string
 			RowID           int
@@ -590,7 +860,7 @@ func runMigrations(cfg types.DatabaseConfig, dbConn *gorm.DB, migrations *gormig
 
 		if len(violatedConstraints) > 0 {
 			for _, violation := range violatedConstraints {
-				log.Error().
+				dbLog.Error().
 					Str("table", violation.Table).
 					Int("row_id", violation.RowID).
 					Str("parent", violation.Parent).
@@ -615,6 +885,33 @@ func (hsdb *HSDatabase) PingDB(ctx context.Context) error {
 	return sqlDB.PingContext(ctx)
 }
 
+// Latency opens the database without migrating it and measures how long
+// a query takes.
+func Latency(ctx context.Context, cfg types.DatabaseConfig) (time.Duration, error) {
+	dbConn, err := openDB(cfg)
+	if err != nil {
+		return 0, err
+	}
+
+	sqlDB, err := dbConn.DB()
+	if err != nil {
+		return 0, err
+	}
+	defer sqlDB.Close()
+
+	// The first query opens the connection, it is not measured.
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if err := dbConn.WithContext(ctx).Exec("SELECT 1").Error; err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
 func (hsdb *HSDatabase) Close() error {
 	db, err := hsdb.DB.DB()
 	if err != nil {
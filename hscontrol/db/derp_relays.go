@@ -0,0 +1,92 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDERPRelayNotFound = errors.New("DERP relay not found")
+	ErrInvalidDERPRelay  = errors.New("invalid DERP relay")
+)
+
+// RegisterDERPRelay stores the relay by its region ID, a relay
+// registering again for the same region updates it. Every registration
+// counts as a heartbeat of the relay.
+func RegisterDERPRelay(tx *gorm.DB, relay types.DERPRelay) (*types.DERPRelay, error) {
+	if relay.RegionID <= 0 {
+		return nil, fmt.Errorf("%w: region ID must be positive", ErrInvalidDERPRelay)
+	}
+	if relay.Hostname == "" {
+		return nil, fmt.Errorf("%w: hostname is required", ErrInvalidDERPRelay)
+	}
+	if relay.DERPPort < 0 || relay.DERPPort > 65535 || relay.STUNPort < -1 || relay.STUNPort > 65535 {
+		return nil, fmt.Errorf("%w: invalid DERP or STUN port", ErrInvalidDERPRelay)
+	}
+
+	stored, err := GetDERPRelay(tx, relay.RegionID)
+	switch {
+	case errors.Is(err, ErrDERPRelayNotFound):
+		stored = &types.DERPRelay{}
+	case err != nil:
+		return nil, err
+	}
+
+	relay.ID = stored.ID
+	relay.CreatedAt = stored.CreatedAt
+	relay.LastSeen = time.Now()
+	if err := tx.Save(&relay).Error; err != nil {
+		return nil, fmt.Errorf("saving DERP relay: %w", err)
+	}
+
+	return &relay, nil
+}
+
+func (hsdb *HSDatabase) GetDERPRelay(regionID int) (*types.DERPRelay, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) (*types.DERPRelay, error) {
+		return GetDERPRelay(rx, regionID)
+	})
+}
+
+// GetDERPRelay returns the relay registered for the region.
+func GetDERPRelay(tx *gorm.DB, regionID int) (*types.DERPRelay, error) {
+	var relay types.DERPRelay
+	if err := tx.Where("region_id = ?", regionID).First(&relay).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDERPRelayNotFound
+		}
+
+		return nil, err
+	}
+
+	return &relay, nil
+}
+
+func (hsdb *HSDatabase) ListDERPRelays() ([]types.DERPRelay, error) {
+	return Read(hsdb.DB, ListDERPRelays)
+}
+
+// ListDERPRelays returns the registered relays ordered by region ID.
+func ListDERPRelays(tx *gorm.DB) ([]types.DERPRelay, error) {
+	relays := []types.DERPRelay{}
+	if err := tx.Order("region_id").Find(&relays).Error; err != nil {
+		return nil, err
+	}
+
+	return relays, nil
+}
+
+// DeleteDERPRelay removes the relay of the region, it is added back if
+// the relay registers again.
+func DeleteDERPRelay(tx *gorm.DB, regionID int) error {
+	relay, err := GetDERPRelay(tx, regionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Delete(relay).Error
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestDERPRelays(t *testing.T) {
+	adb, err := newTestDB()
+	require.NoError(t, err)
+
+	register := func(relay types.DERPRelay) (*types.DERPRelay, error) {
+		return Write(adb.DB, func(tx *gorm.DB) (*types.DERPRelay, error) {
+			return RegisterDERPRelay(tx, relay)
+		})
+	}
+
+	_, err = register(types.DERPRelay{Hostname: "derp.example.com"})
+	require.ErrorIs(t, err, ErrInvalidDERPRelay)
+
+	_, err = register(types.DERPRelay{RegionID: 900})
+	require.ErrorIs(t, err, ErrInvalidDERPRelay)
+
+	first, err := register(types.DERPRelay{RegionID: 901, RegionCode: "fra", Hostname: "fra.example.com", DERPPort: 443})
+	require.NoError(t, err)
+	_, err = register(types.DERPRelay{RegionID: 900, RegionCode: "sea", Hostname: "sea.example.com", DERPPort: 443})
+	require.NoError(t, err)
+
+	time.Sleep(time.Millisecond)
+	updated, err := register(types.DERPRelay{RegionID: 901, RegionCode: "fra", Hostname: "fra2.example.com", DERPPort: 8443})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, updated.ID)
+	assert.True(t, updated.LastSeen.After(first.LastSeen))
+
+	relays, err := adb.ListDERPRelays()
+	require.NoError(t, err)
+	require.Len(t, relays, 2)
+	assert.Equal(t, 900, relays[0].RegionID)
+	assert.Equal(t, "fra2.example.com", relays[1].Hostname)
+	assert.Equal(t, 8443, relays[1].Region().Nodes[0].DERPPort)
+	assert.True(t, relays[1].Online(time.Minute))
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return DeleteDERPRelay(tx, 901)
+	})
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return DeleteDERPRelay(tx, 901)
+	})
+	require.ErrorIs(t, err, ErrDERPRelayNotFound)
+
+	relays, err = adb.ListDERPRelays()
+	require.NoError(t, err)
+	require.Len(t, relays, 1)
+}
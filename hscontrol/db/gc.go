@@ -0,0 +1,207 @@
+package db
+
+import (
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"gorm.io/gorm"
+)
+
+// GCResult counts the rows removed by GarbageCollect.
+type GCResult struct {
+	OrphanedRoutes       int64
+	DeletedRoutes        int64
+	OrphanedPreAuthTags  int64
+	PreAuthKeys          int64
+	APIKeys              int64
+	NetcheckReports      int64
+	UserAliases          int64
+	RouteEvents          int64
+	PendingRegistrations int64
+
+	// EphemeralNodes are the deleted ephemeral nodes, their peers have
+	// to be told.
+	EphemeralNodes []types.NodeID
+}
+
+// GarbageCollect removes the rows left behind by deleted nodes and the
+// records kept beyond their retention, and reclaims the free pages of
+// SQLite databases afterwards.
+func (hsdb *HSDatabase) GarbageCollect(
+	cfg types.DatabaseGCConfig,
+	ephemeralTimeout time.Duration,
+	isConnected func(types.NodeID) bool,
+) (*GCResult, error) {
+	result, err := Write(hsdb.DB, func(tx *gorm.DB) (*GCResult, error) {
+		return GarbageCollect(tx, cfg, time.Now(), ephemeralTimeout, isConnected)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// auto_vacuum is incremental, free pages are only returned to the
+	// file system when asked for.
+	if hsdb.DB.Dialector.Name() == "sqlite" {
+		if err := hsdb.DB.Exec("PRAGMA incremental_vacuum").Error; err != nil {
+			dbLog.Warn().Err(err).Msg("Failed to vacuum the database")
+		}
+	}
+
+	return result, nil
+}
+
+// GarbageCollect removes:
+//   - ephemeral nodes that are not connected and were last seen before
+//     ephemeralTimeout, like after headscale was stopped while they were
+//     offline,
+//   - routes of deleted nodes and routes that were soft deleted,
+//   - tags of deleted pre auth keys,
+//   - pre auth keys no node was registered with that expired or were
+//     used before the retention,
+//   - API keys that expired before the retention,
+//   - netcheck reports of deleted nodes and those older than the
+//     retention,
+//   - expired user aliases,
+//   - route history events older than the retention,
+//   - expired pending registrations.
+func GarbageCollect(
+	tx *gorm.DB,
+	cfg types.DatabaseGCConfig,
+	now time.Time,
+	ephemeralTimeout time.Duration,
+	isConnected func(types.NodeID) bool,
+) (*GCResult, error) {
+	var result GCResult
+	var err error
+
+	if ephemeralTimeout > 0 {
+		result.EphemeralNodes, err = deleteStaleEphemeralNodes(tx, now.Add(-ephemeralTimeout), isConnected)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nodeIDs := tx.Unscoped().Model(&types.Node{}).Select("id")
+
+	res := tx.Unscoped().Where("deleted_at IS NOT NULL").Delete(&types.Route{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	result.DeletedRoutes = res.RowsAffected
+
+	res = tx.Unscoped().Where("node_id NOT IN (?)", nodeIDs).Delete(&types.Route{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	result.OrphanedRoutes = res.RowsAffected
+
+	if cfg.PreAuthKeyRetention > 0 {
+		cutoff := now.Add(-cfg.PreAuthKeyRetention)
+
+		var keyIDs []uint64
+		err := tx.Model(&types.PreAuthKey{}).
+			Where("id NOT IN (?)", tx.Model(&types.Node{}).Select("auth_key_id").Where("auth_key_id IS NOT NULL")).
+			Where("expiration < ? OR (used = ? AND reusable = ? AND created_at < ?)", cutoff, true, false, cutoff).
+			Pluck("id", &keyIDs).Error
+		if err != nil {
+			return nil, err
+		}
+
+		if len(keyIDs) > 0 {
+			if err := tx.Where("pre_auth_key_id IN ?", keyIDs).Delete(&types.PreAuthKeyACLTag{}).Error; err != nil {
+				return nil, err
+			}
+
+			res = tx.Where("id IN ?", keyIDs).Delete(&types.PreAuthKey{})
+			if res.Error != nil {
+				return nil, res.Error
+			}
+			result.PreAuthKeys = res.RowsAffected
+		}
+	}
+
+	res = tx.
+		Where("pre_auth_key_id NOT IN (?)", tx.Model(&types.PreAuthKey{}).Select("id")).
+		Delete(&types.PreAuthKeyACLTag{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	result.OrphanedPreAuthTags = res.RowsAffected
+
+	if cfg.APIKeyRetention > 0 {
+		res = tx.Where("expiration < ?", now.Add(-cfg.APIKeyRetention)).Delete(&types.APIKey{})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		result.APIKeys = res.RowsAffected
+	}
+
+	reports := tx.Where("node_id NOT IN (?)", nodeIDs)
+	if cfg.NetcheckReportRetention > 0 {
+		reports = reports.Or("created_at < ?", now.Add(-cfg.NetcheckReportRetention))
+	}
+	res = reports.Delete(&types.NetcheckReport{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	result.NetcheckReports = res.RowsAffected
+
+	res = tx.Where("expires_at < ?", now).Delete(&types.UserAlias{})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	result.UserAliases = res.RowsAffected
+
+	if cfg.RouteEventRetention > 0 {
+		res = tx.Where("created_at < ?", now.Add(-cfg.RouteEventRetention)).Delete(&types.RouteEvent{})
+		if res.Error != nil {
+			return nil, res.Error
+		}
+		result.RouteEvents = res.RowsAffected
+	}
+
+	result.PendingRegistrations, err = DeleteExpiredPendingRegistrations(tx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+func deleteStaleEphemeralNodes(
+	tx *gorm.DB,
+	lastSeenBefore time.Time,
+	isConnected func(types.NodeID) bool,
+) ([]types.NodeID, error) {
+	var nodes types.Nodes
+	err := tx.
+		Joins("JOIN pre_auth_keys ON pre_auth_keys.id = nodes.auth_key_id").
+		Where("pre_auth_keys.ephemeral = ?", true).
+		Find(&nodes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []types.NodeID
+	for _, node := range nodes {
+		if isConnected(node.ID) {
+			continue
+		}
+
+		if node.LastSeen != nil && node.LastSeen.After(lastSeenBefore) {
+			continue
+		}
+
+		stale = append(stale, node.ID)
+	}
+
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	if err := tx.Unscoped().Delete(&types.Node{}, stale).Error; err != nil {
+		return nil, err
+	}
+
+	return stale, nil
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/types/key"
+)
+
+func TestGarbageCollect(t *testing.T) {
+	hsdb := dbForTest(t, "gc")
+	now := time.Now()
+	longAgo := now.Add(-60 * 24 * time.Hour)
+	recently := now.Add(-time.Minute)
+
+	user, err := hsdb.CreateUser("gc")
+	require.NoError(t, err)
+
+	ephemeralKey, err := hsdb.CreatePreAuthKey(user.Name, true, true, nil, nil)
+	require.NoError(t, err)
+	usedKey, err := hsdb.CreatePreAuthKey(user.Name, false, false, &longAgo, nil)
+	require.NoError(t, err)
+	staleKey, err := hsdb.CreatePreAuthKey(user.Name, false, false, &longAgo, []string{"tag:gc"})
+	require.NoError(t, err)
+
+	addNode := func(hostname string, authKey *types.PreAuthKey, lastSeen *time.Time) *types.Node {
+		node := &types.Node{
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			Hostname:       hostname,
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodAuthKey,
+			AuthKeyID:      &authKey.ID,
+			LastSeen:       lastSeen,
+		}
+		require.NoError(t, hsdb.DB.Save(node).Error)
+
+		return node
+	}
+
+	offline := addNode("ephemeral-offline", ephemeralKey, &longAgo)
+	connected := addNode("ephemeral-connected", ephemeralKey, &longAgo)
+	justLeft := addNode("ephemeral-just-left", ephemeralKey, &recently)
+	regular := addNode("regular", usedKey, &longAgo)
+
+	route := types.Route{NodeID: regular.ID.Uint64(), Prefix: types.IPPrefix(netip.MustParsePrefix("10.0.0.0/24"))}
+	require.NoError(t, hsdb.DB.Omit("Node").Create(&route).Error)
+	deletedRoute := types.Route{NodeID: regular.ID.Uint64(), Prefix: types.IPPrefix(netip.MustParsePrefix("10.1.0.0/24"))}
+	require.NoError(t, hsdb.DB.Omit("Node").Create(&deletedRoute).Error)
+	require.NoError(t, hsdb.DB.Delete(&deletedRoute).Error)
+
+	// Rows of a node deleted while foreign keys were not enforced.
+	require.NoError(t, hsdb.DB.Exec("PRAGMA foreign_keys=OFF").Error)
+	orphanRoute := types.Route{NodeID: 9999, Prefix: types.IPPrefix(netip.MustParsePrefix("10.2.0.0/24"))}
+	require.NoError(t, hsdb.DB.Omit("Node").Create(&orphanRoute).Error)
+	require.NoError(t, hsdb.DB.Create(&types.NetcheckReport{NodeID: 9999}).Error)
+	require.NoError(t, hsdb.DB.Exec("PRAGMA foreign_keys=ON").Error)
+
+	require.NoError(t, AddNetcheckReport(hsdb.DB, regular.ID, nil))
+
+	_, _, err = hsdb.CreateAPIKey(&longAgo, nil, 0)
+	require.NoError(t, err)
+	_, _, err = hsdb.CreateAPIKey(nil, nil, 0)
+	require.NoError(t, err)
+
+	require.NoError(t, hsdb.DB.Create(&types.UserAlias{Name: "old", UserID: user.ID, ExpiresAt: longAgo}).Error)
+	require.NoError(t, hsdb.DB.Create(&types.UserAlias{Name: "active", UserID: user.ID, ExpiresAt: now.Add(time.Hour)}).Error)
+
+	expiredMachineKey := key.NewMachine().Public()
+	pendingMachineKey := key.NewMachine().Public()
+	require.NoError(t, hsdb.SavePendingRegistration(expiredMachineKey.String(), types.Node{MachineKey: expiredMachineKey}, recently))
+	require.NoError(t, hsdb.SavePendingRegistration(pendingMachineKey.String(), types.Node{MachineKey: pendingMachineKey}, now.Add(time.Hour)))
+
+	result, err := hsdb.GarbageCollect(
+		types.DatabaseGCConfig{
+			PreAuthKeyRetention:     30 * 24 * time.Hour,
+			APIKeyRetention:         30 * 24 * time.Hour,
+			NetcheckReportRetention: 30 * 24 * time.Hour,
+		},
+		time.Hour,
+		func(id types.NodeID) bool { return id == connected.ID },
+	)
+	require.NoError(t, err)
+
+	assert.Equal(t, []types.NodeID{offline.ID}, result.EphemeralNodes)
+	assert.Equal(t, int64(1), result.DeletedRoutes)
+	assert.Equal(t, int64(1), result.OrphanedRoutes)
+	assert.Equal(t, int64(1), result.PreAuthKeys)
+	assert.Equal(t, int64(1), result.APIKeys)
+	assert.Equal(t, int64(1), result.NetcheckReports)
+	assert.Equal(t, int64(1), result.UserAliases)
+	assert.Equal(t, int64(1), result.PendingRegistrations)
+
+	for _, id := range []types.NodeID{connected.ID, justLeft.ID, regular.ID} {
+		_, err := GetNodeByID(hsdb.DB, id)
+		assert.NoError(t, err, "node %d must be kept", id)
+	}
+
+	err = hsdb.DB.First(&types.PreAuthKey{}, usedKey.ID).Error
+	assert.NoError(t, err, "key a node was registered with must be kept")
+	err = hsdb.DB.First(&types.PreAuthKey{}, staleKey.ID).Error
+	assert.Error(t, err, "stale key must be removed")
+
+	var tags int64
+	require.NoError(t, hsdb.DB.Model(&types.PreAuthKeyACLTag{}).Count(&tags).Error)
+	assert.Zero(t, tags)
+
+	var routes []types.Route
+	require.NoError(t, hsdb.DB.Unscoped().Find(&routes).Error)
+	assert.Len(t, routes, 1)
+}
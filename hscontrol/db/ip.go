@@ -11,7 +11,6 @@ import (
 
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/rs/zerolog/log"
 	"go4.org/netipx"
 	"gorm.io/gorm"
 )
@@ -155,6 +154,31 @@ func (i *IPAllocator) Next() (*netip.Addr, *netip.Addr, error) {
 	return ret4, ret6, nil
 }
 
+// Reserve hands out the given address if it is in one of the prefixes
+// and not used yet, returning whether it did. It keeps the addresses of
+// nodes imported from another control server.
+func (i *IPAllocator) Reserve(addr netip.Addr) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	prefix := i.prefix4
+	if addr.Is6() {
+		prefix = i.prefix6
+	}
+	if prefix == nil || !prefix.Contains(addr) {
+		return false
+	}
+
+	set, err := i.usedIPs.IPSet()
+	if err != nil || set.Contains(addr) {
+		return false
+	}
+
+	i.usedIPs.Add(addr)
+
+	return true
+}
+
 var ErrCouldNotAllocateIP = errors.New("failed to allocate IP")
 
 func (i *IPAllocator) nextLocked(prev netip.Addr, prefix *netip.Prefix) (*netip.Addr, error) {
@@ -265,7 +289,7 @@ func (db *HSDatabase) BackfillNodeIPs(i *IPAllocator) ([]string, error) {
 			return errors.New("backfilling IPs: ip allocator was nil")
 		}
 
-		log.Trace().Msgf("starting to backfill IPs")
+		dbLog.Trace().Msgf("starting to backfill IPs")
 
 		nodes, err := ListNodes(tx)
 		if err != nil {
@@ -273,7 +297,7 @@ func (db *HSDatabase) BackfillNodeIPs(i *IPAllocator) ([]string, error) {
 		}
 
 		for _, node := range nodes {
-			log.Trace().Uint64("node.id", node.ID.Uint64()).Msg("checking if need backfill")
+			dbLog.Trace().Uint64("node.id", node.ID.Uint64()).Msg("checking if need backfill")
 
 			changed := false
 			// IPv4 prefix is set, but node ip is missing, alloc
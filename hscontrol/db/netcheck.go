@@ -0,0 +1,59 @@
+package db
+
+import (
+	"github.com/juanfont/headscale/hscontrol/types"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+)
+
+// netcheckReportsPerNode is how many netcheck reports are kept for each
+// node, older reports are deleted when a new one is added.
+const netcheckReportsPerNode = 20
+
+func (hsdb *HSDatabase) AddNetcheckReport(nodeID types.NodeID, netInfo *tailcfg.NetInfo) error {
+	return hsdb.Write(func(tx *gorm.DB) error {
+		return AddNetcheckReport(tx, nodeID, netInfo)
+	})
+}
+
+// AddNetcheckReport stores the netcheck report of a node and deletes
+// its oldest reports beyond netcheckReportsPerNode.
+func AddNetcheckReport(tx *gorm.DB, nodeID types.NodeID, netInfo *tailcfg.NetInfo) error {
+	report := types.NetcheckReport{
+		NodeID:  nodeID,
+		NetInfo: netInfo,
+	}
+	if err := tx.Omit("Node").Create(&report).Error; err != nil {
+		return err
+	}
+
+	keep := tx.Model(&types.NetcheckReport{}).
+		Select("id").
+		Where("node_id = ?", nodeID).
+		Order("id DESC").
+		Limit(netcheckReportsPerNode)
+
+	return tx.
+		Where("node_id = ? AND id NOT IN (?)", nodeID, keep).
+		Delete(&types.NetcheckReport{}).Error
+}
+
+func (hsdb *HSDatabase) ListNetcheckReports(nodeID types.NodeID) ([]types.NetcheckReport, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.NetcheckReport, error) {
+		return ListNetcheckReports(rx, nodeID)
+	})
+}
+
+// ListNetcheckReports returns the netcheck reports of a node, newest
+// first.
+func ListNetcheckReports(tx *gorm.DB, nodeID types.NodeID) ([]types.NetcheckReport, error) {
+	var reports []types.NetcheckReport
+	if err := tx.
+		Where("node_id = ?", nodeID).
+		Order("id DESC").
+		Find(&reports).Error; err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
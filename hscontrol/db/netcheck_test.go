@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+func TestNetcheckReports(t *testing.T) {
+	db := dbForTest(t, "netcheck-reports")
+
+	user := types.User{Name: "test"}
+	if err := db.DB.Save(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	node := types.Node{Hostname: "laptop", UserID: user.ID}
+	if err := db.DB.Save(&node).Error; err != nil {
+		t.Fatalf("failed to create node: %s", err)
+	}
+
+	for derp := 1; derp <= netcheckReportsPerNode+5; derp++ {
+		err := db.AddNetcheckReport(node.ID, &tailcfg.NetInfo{
+			PreferredDERP: derp,
+			DERPLatency:   map[string]float64{"1-v4": 0.01},
+		})
+		if err != nil {
+			t.Fatalf("AddNetcheckReport() error = %s", err)
+		}
+	}
+
+	reports, err := db.ListNetcheckReports(node.ID)
+	if err != nil {
+		t.Fatalf("ListNetcheckReports() error = %s", err)
+	}
+
+	if len(reports) != netcheckReportsPerNode {
+		t.Fatalf("ListNetcheckReports() returned %d reports, want %d", len(reports), netcheckReportsPerNode)
+	}
+
+	if got, want := reports[0].NetInfo.PreferredDERP, netcheckReportsPerNode+5; got != want {
+		t.Errorf("newest report has preferred DERP %d, want %d", got, want)
+	}
+
+	if got, want := reports[len(reports)-1].NetInfo.PreferredDERP, 6; got != want {
+		t.Errorf("oldest report has preferred DERP %d, want %d", got, want)
+	}
+
+	if got := reports[0].NetInfo.DERPLatency["1-v4"]; got != 0.01 {
+		t.Errorf("newest report has DERP latency %f, want 0.01", got)
+	}
+}
@@ -1,18 +1,19 @@
 package db
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/netip"
 	"sort"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
 	"github.com/patrickmn/go-cache"
 	"github.com/puzpuzpuz/xsync/v3"
-	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
@@ -21,10 +22,13 @@ import (
 const (
 	NodeGivenNameHashLength = 8
 	NodeGivenNameTrimSize   = 2
+	MaxNodeNoteLength       = 1024
 )
 
 var (
 	ErrNodeNotFound                  = errors.New("node not found")
+	ErrNodeNoteTooLong               = errors.New("note too long")
+	ErrNodeDERPHomeRegionInvalid     = errors.New("DERP home region must be a DERP region ID")
 	ErrNodeRouteIsNotAvailable       = errors.New("route is not available on node")
 	ErrNodeNotFoundRegistrationCache = errors.New(
 		"node not found in registration cache",
@@ -33,6 +37,7 @@ var (
 	ErrDifferentRegisteredUser      = errors.New(
 		"node was previously registered with a different user",
 	)
+	ErrNodeAlreadyRegistered = errors.New("a node with this machine key is already registered")
 )
 
 func (hsdb *HSDatabase) ListPeers(nodeID types.NodeID) (types.Nodes, error) {
@@ -42,6 +47,7 @@ func (hsdb *HSDatabase) ListPeers(nodeID types.NodeID) (types.Nodes, error) {
 }
 
 // ListPeers returns all peers of node, regardless of any Policy or if the node is expired.
+// Nodes whose machine key is revoked are nobody's peers.
 func ListPeers(tx *gorm.DB, nodeID types.NodeID) (types.Nodes, error) {
 	nodes := types.Nodes{}
 	if err := tx.
@@ -49,8 +55,9 @@ func ListPeers(tx *gorm.DB, nodeID types.NodeID) (types.Nodes, error) {
 		Preload("AuthKey.User").
 		Preload("User").
 		Preload("Routes").
-		Where("id <> ?",
-			nodeID).Find(&nodes).Error; err != nil {
+		Where("id <> ?", nodeID).
+		Where("machine_key NOT IN (?)", tx.Model(&types.RevokedKey{}).Select("key")).
+		Find(&nodes).Error; err != nil {
 		return types.Nodes{}, err
 	}
 
@@ -157,6 +164,30 @@ func GetNodeByMachineKey(
 	return &mach, nil
 }
 
+func (hsdb *HSDatabase) GetNodeByNodeKey(nodeKey key.NodePublic) (*types.Node, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) (*types.Node, error) {
+		return GetNodeByNodeKey(rx, nodeKey)
+	})
+}
+
+// GetNodeByNodeKey finds a Node by its NodeKey and returns the Node struct.
+func GetNodeByNodeKey(
+	tx *gorm.DB,
+	nodeKey key.NodePublic,
+) (*types.Node, error) {
+	node := types.Node{}
+	if result := tx.
+		Preload("AuthKey").
+		Preload("AuthKey.User").
+		Preload("User").
+		Preload("Routes").
+		First(&node, "node_key = ?", nodeKey.String()); result.Error != nil {
+		return nil, result.Error
+	}
+
+	return &node, nil
+}
+
 func (hsdb *HSDatabase) GetNodeByAnyKey(
 	machineKey key.MachinePublic,
 	nodeKey key.NodePublic,
@@ -255,6 +286,37 @@ func RenameNode(tx *gorm.DB,
 	return nil
 }
 
+// SetNodeNote sets the note of a node, an empty note removes it.
+func SetNodeNote(tx *gorm.DB,
+	nodeID types.NodeID, note string,
+) error {
+	if utf8.RuneCountInString(note) > MaxNodeNoteLength {
+		return fmt.Errorf("%w, it must be at most %d characters", ErrNodeNoteTooLong, MaxNodeNoteLength)
+	}
+
+	if err := tx.Model(&types.Node{}).Where("id = ?", nodeID).Update("note", note).Error; err != nil {
+		return fmt.Errorf("failed to set node note in the database: %w", err)
+	}
+
+	return nil
+}
+
+// SetNodeDERPHomeRegion pins the node to a DERP region as its home,
+// region 0 removes the pin.
+func SetNodeDERPHomeRegion(tx *gorm.DB,
+	nodeID types.NodeID, region int,
+) error {
+	if region < 0 {
+		return ErrNodeDERPHomeRegionInvalid
+	}
+
+	if err := tx.Model(&types.Node{}).Where("id = ?", nodeID).Update("derp_home_region", region).Error; err != nil {
+		return fmt.Errorf("failed to set node DERP home region in the database: %w", err)
+	}
+
+	return nil
+}
+
 func (hsdb *HSDatabase) NodeSetExpiry(nodeID types.NodeID, expiry time.Time) error {
 	return hsdb.Write(func(tx *gorm.DB) error {
 		return NodeSetExpiry(tx, nodeID, expiry)
@@ -313,6 +375,11 @@ func SetLastSeen(tx *gorm.DB, nodeID types.NodeID, lastSeen time.Time) error {
 	return tx.Model(&types.Node{}).Where("id = ?", nodeID).Update("last_seen", lastSeen).Error
 }
 
+// SetLastSeenAddr sets the public address a node last connected from.
+func SetLastSeenAddr(tx *gorm.DB, nodeID types.NodeID, addr netip.Addr) error {
+	return tx.Model(&types.Node{}).Where("id = ?", nodeID).Update("last_seen_addr", addr.String()).Error
+}
+
 func RegisterNodeFromAuthCallback(
 	tx *gorm.DB,
 	cache *cache.Cache,
@@ -323,7 +390,7 @@ func RegisterNodeFromAuthCallback(
 	ipv4 *netip.Addr,
 	ipv6 *netip.Addr,
 ) (*types.Node, error) {
-	log.Debug().
+	dbLog.Debug().
 		Str("machine_key", mkey.ShortString()).
 		Str("userName", userName).
 		Str("registrationMethod", registrationMethod).
@@ -340,6 +407,10 @@ func RegisterNodeFromAuthCallback(
 				)
 			}
 
+			if user.IsSuspended() {
+				return nil, ErrUserSuspended
+			}
+
 			// Registration of expired node with different user
 			if registrationNode.ID != 0 &&
 				registrationNode.UserID != user.ID {
@@ -362,6 +433,7 @@ func RegisterNodeFromAuthCallback(
 
 			if err == nil {
 				cache.Delete(mkey.String())
+				err = DeletePendingRegistration(tx, mkey.String())
 			}
 
 			return node, err
@@ -381,7 +453,7 @@ func (hsdb *HSDatabase) RegisterNode(node types.Node, ipv4 *netip.Addr, ipv6 *ne
 
 // RegisterNode is executed from the CLI to register a new Node using its MachineKey.
 func RegisterNode(tx *gorm.DB, node types.Node, ipv4 *netip.Addr, ipv6 *netip.Addr) (*types.Node, error) {
-	log.Debug().
+	dbLog.Debug().
 		Str("node", node.Hostname).
 		Str("machine_key", node.MachineKey.ShortString()).
 		Str("node_key", node.NodeKey.ShortString()).
@@ -396,7 +468,7 @@ func RegisterNode(tx *gorm.DB, node types.Node, ipv4 *netip.Addr, ipv6 *netip.Ad
 			return nil, fmt.Errorf("failed register existing node in the database: %w", err)
 		}
 
-		log.Trace().
+		dbLog.Trace().
 			Caller().
 			Str("node", node.Hostname).
 			Str("machine_key", node.MachineKey.ShortString()).
@@ -411,7 +483,18 @@ func RegisterNode(tx *gorm.DB, node types.Node, ipv4 *netip.Addr, ipv6 *netip.Ad
 	node.IPv6 = ipv6
 
 	if node.GivenName == "" {
-		givenName, err := ensureUniqueGivenName(tx, node.Hostname)
+		name := node.Hostname
+
+		// Nodes registered with a pre auth key are named after its
+		// name prefix.
+		if node.AuthKeyID != nil {
+			var pak types.PreAuthKey
+			if err := tx.First(&pak, *node.AuthKeyID).Error; err == nil {
+				name = pak.NodeGivenName(name)
+			}
+		}
+
+		givenName, err := ensureUniqueGivenName(tx, name)
 		if err != nil {
 			return nil, fmt.Errorf("failed to ensure unique given name: %w", err)
 		}
@@ -423,7 +506,7 @@ func RegisterNode(tx *gorm.DB, node types.Node, ipv4 *netip.Addr, ipv6 *netip.Ad
 		return nil, fmt.Errorf("failed register(save) node in the database: %w", err)
 	}
 
-	log.Trace().
+	dbLog.Trace().
 		Caller().
 		Str("node", node.Hostname).
 		Msg("Node registered with the database")
@@ -431,11 +514,95 @@ func RegisterNode(tx *gorm.DB, node types.Node, ipv4 *netip.Addr, ipv6 *netip.Ad
 	return &node, nil
 }
 
+// ImportNode registers a node imported from another control server and
+// its advertised routes, enabling enabledRoutes. The node is expected to
+// be expired, so it joins the tailnet once it logged in again.
+func ImportNode(
+	tx *gorm.DB,
+	node types.Node,
+	ipv4, ipv6 *netip.Addr,
+	enabledRoutes []netip.Prefix,
+) (*types.Node, error) {
+	_, err := GetNodeByMachineKey(tx, node.MachineKey)
+	if err == nil {
+		return nil, ErrNodeAlreadyRegistered
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Keep the name the node had, unless another node has it.
+	if node.GivenName != "" {
+		node.GivenName, err = ensureUniqueGivenName(tx, node.GivenName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to ensure unique given name: %w", err)
+		}
+	}
+
+	registered, err := RegisterNode(tx, node, ipv4, ipv6)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := SaveNodeRoutes(tx, registered); err != nil {
+		return nil, fmt.Errorf("saving the routes of %s: %w", registered.Hostname, err)
+	}
+
+	if len(enabledRoutes) > 0 {
+		routes := make([]string, len(enabledRoutes))
+		for index, prefix := range enabledRoutes {
+			routes[index] = prefix.String()
+		}
+
+		if _, err := enableRoutes(tx, registered, routes...); err != nil {
+			return nil, fmt.Errorf("enabling the routes of %s: %w", registered.Hostname, err)
+		}
+	}
+
+	return registered, nil
+}
+
 // NodeSetNodeKey sets the node key of a node and saves it to the database.
 func NodeSetNodeKey(tx *gorm.DB, node *types.Node, nodeKey key.NodePublic) error {
-	return tx.Model(node).Updates(types.Node{
-		NodeKey: nodeKey,
-	}).Error
+	if err := tx.Model(&types.Node{}).Where("id = ?", node.ID).Update("node_key", nodeKey.String()).Error; err != nil {
+		return err
+	}
+	node.NodeKey = nodeKey
+
+	return nil
+}
+
+// RenewNodeKey replaces the node key of a node renewing it while keeping
+// its machine key, and sets its expiry if it is not nil.
+func RenewNodeKey(tx *gorm.DB, node *types.Node, nodeKey key.NodePublic, expiry *time.Time) error {
+	if err := NodeSetNodeKey(tx, node, nodeKey); err != nil {
+		return fmt.Errorf("setting node key: %w", err)
+	}
+
+	if expiry != nil {
+		if err := NodeSetExpiry(tx, node.ID, *expiry); err != nil {
+			return fmt.Errorf("setting node expiry: %w", err)
+		}
+		node.Expiry = expiry
+	}
+
+	return nil
+}
+
+func (hsdb *HSDatabase) NodeSetAttestation(nodeID types.NodeID, attestation *types.NodeAttestation) error {
+	return hsdb.Write(func(tx *gorm.DB) error {
+		return NodeSetAttestation(tx, nodeID, attestation)
+	})
+}
+
+// NodeSetAttestation records the hardware attestation of a node.
+func NodeSetAttestation(tx *gorm.DB, nodeID types.NodeID, attestation *types.NodeAttestation) error {
+	data, err := json.Marshal(attestation)
+	if err != nil {
+		return fmt.Errorf("marshalling attestation: %w", err)
+	}
+
+	return tx.Model(&types.Node{}).Where("id = ?", nodeID).Update("attestation", string(data)).Error
 }
 
 func (hsdb *HSDatabase) NodeSetMachineKey(
@@ -453,9 +620,12 @@ func NodeSetMachineKey(
 	node *types.Node,
 	machineKey key.MachinePublic,
 ) error {
-	return tx.Model(node).Updates(types.Node{
-		MachineKey: machineKey,
-	}).Error
+	if err := tx.Model(&types.Node{}).Where("id = ?", node.ID).Update("machine_key", machineKey.String()).Error; err != nil {
+		return err
+	}
+	node.MachineKey = machineKey
+
+	return nil
 }
 
 // NodeSave saves a node object to the database, prefer to use a specific save method rather
@@ -581,12 +751,29 @@ func enableRoutes(tx *gorm.DB,
 			Where("node_id = ? AND prefix = ?", node.ID, types.IPPrefix(prefix)).
 			First(&route).Error
 		if err == nil {
+			if !route.Enabled {
+				err = recordRouteEvent(tx, node, route.Prefix, types.RouteEventEnabled, routeActor(tx), "")
+				if err != nil {
+					return nil, err
+				}
+			}
 			route.Enabled = true
 
 			// Mark already as primary if there is only this node offering this subnet
 			// (and is not an exit route)
 			if !route.IsExitRoute() {
-				route.IsPrimary = isUniquePrefix(tx, route)
+				isPrimary := isUniquePrefix(tx, route)
+				if isPrimary && !route.IsPrimary {
+					now := time.Now()
+					route.PrimaryChangedAt = &now
+					route.PrimaryReason = "enabled as the only route for the prefix"
+
+					err = recordRouteEvent(tx, node, route.Prefix, types.RouteEventPrimary, routeActor(tx), route.PrimaryReason)
+					if err != nil {
+						return nil, err
+					}
+				}
+				route.IsPrimary = isPrimary
 			}
 
 			err = tx.Save(&route).Error
@@ -607,7 +794,7 @@ func enableRoutes(tx *gorm.DB,
 
 	node.Routes = nRoutes
 
-	log.Trace().
+	dbLog.Trace().
 		Caller().
 		Str("node", node.Hostname).
 		Strs("routes", routeStrs).
@@ -656,6 +843,13 @@ func isUnqiueName(tx *gorm.DB, name string) (bool, error) {
 	return len(nodes) == 0, nil
 }
 
+// PreviewGivenName returns the given name a node registering with the
+// hostname name would receive. If the name is taken, the random suffix
+// of the node will differ from the one returned.
+func PreviewGivenName(tx *gorm.DB, name string) (string, error) {
+	return ensureUniqueGivenName(tx, name)
+}
+
 func ensureUniqueGivenName(
 	tx *gorm.DB,
 	name string,
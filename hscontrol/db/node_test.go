@@ -114,6 +114,61 @@ func (s *Suite) TestGetNodeByAnyNodeKey(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+func (s *Suite) TestGetNodeByNodeKey(c *check.C) {
+	user, err := db.CreateUser("test")
+	c.Assert(err, check.IsNil)
+
+	nodeKey := key.NewNode()
+	node := types.Node{
+		ID:             0,
+		MachineKey:     key.NewMachine().Public(),
+		NodeKey:        nodeKey.Public(),
+		Hostname:       "testnode",
+		UserID:         user.ID,
+		RegisterMethod: util.RegisterMethodAuthKey,
+	}
+	trx := db.DB.Save(&node)
+	c.Assert(trx.Error, check.IsNil)
+
+	_, err = db.GetNodeByNodeKey(key.NewNode().Public())
+	c.Assert(err, check.NotNil)
+
+	found, err := db.GetNodeByNodeKey(nodeKey.Public())
+	c.Assert(err, check.IsNil)
+	c.Assert(found.ID, check.Equals, node.ID)
+}
+
+func (s *Suite) TestSetLastSeenAddr(c *check.C) {
+	user, err := db.CreateUser("test")
+	c.Assert(err, check.IsNil)
+
+	node := types.Node{
+		ID:             0,
+		MachineKey:     key.NewMachine().Public(),
+		NodeKey:        key.NewNode().Public(),
+		Hostname:       "testnode",
+		UserID:         user.ID,
+		RegisterMethod: util.RegisterMethodAuthKey,
+	}
+	trx := db.DB.Save(&node)
+	c.Assert(trx.Error, check.IsNil)
+
+	found, err := db.GetNodeByID(node.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(found.LastSeenAddr, check.IsNil)
+
+	addr := netip.MustParseAddr("2001:db8::1")
+	err = db.Write(func(tx *gorm.DB) error {
+		return SetLastSeenAddr(tx, node.ID, addr)
+	})
+	c.Assert(err, check.IsNil)
+
+	found, err = db.GetNodeByID(node.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(found.LastSeenAddr, check.NotNil)
+	c.Assert(*found.LastSeenAddr, check.Equals, addr)
+}
+
 func (s *Suite) TestHardDeleteNode(c *check.C) {
 	user, err := db.CreateUser("test")
 	c.Assert(err, check.IsNil)
@@ -0,0 +1,39 @@
+package db
+
+import (
+	"github.com/juanfont/headscale/hscontrol/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (hsdb *HSDatabase) SaveOIDCSession(session *types.OIDCSession) error {
+	return hsdb.Write(func(tx *gorm.DB) error {
+		return SaveOIDCSession(tx, session)
+	})
+}
+
+// SaveOIDCSession stores or replaces the OIDC session of a user.
+func SaveOIDCSession(tx *gorm.DB, session *types.OIDCSession) error {
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(session).Error
+}
+
+// DeleteOIDCSession deletes the OIDC session of a user, if any.
+func DeleteOIDCSession(tx *gorm.DB, userName string) error {
+	return tx.Where("user_name = ?", userName).Delete(&types.OIDCSession{}).Error
+}
+
+func (hsdb *HSDatabase) ListOIDCSessions() ([]types.OIDCSession, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.OIDCSession, error) {
+		return ListOIDCSessions(rx)
+	})
+}
+
+// ListOIDCSessions returns the OIDC sessions of all users.
+func ListOIDCSessions(tx *gorm.DB) ([]types.OIDCSession, error) {
+	var sessions []types.OIDCSession
+	if err := tx.Order("user_name").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
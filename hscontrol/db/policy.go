@@ -22,6 +22,40 @@ func (hsdb *HSDatabase) SetPolicy(policy string) (*types.Policy, error) {
 	return &p, nil
 }
 
+// SetPolicyIfVersion sets the policy in the database if the latest
+// policy still has the expected version, the ID of its row. A version
+// of zero skips the check.
+func (hsdb *HSDatabase) SetPolicyIfVersion(
+	policy string,
+	version uint,
+) (*types.Policy, error) {
+	return Write(hsdb.DB, func(tx *gorm.DB) (*types.Policy, error) {
+		if version != 0 {
+			var current types.Policy
+			if err := tx.
+				Order("id DESC").
+				Limit(1).
+				First(&current).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+
+			if current.ID != version {
+				return nil, types.ErrPolicyVersionMismatch
+			}
+		}
+
+		p := types.Policy{
+			Data: policy,
+		}
+
+		if err := tx.Clauses(clause.Returning{}).Create(&p).Error; err != nil {
+			return nil, err
+		}
+
+		return &p, nil
+	})
+}
+
 // GetPolicy returns the latest policy in the database.
 func (hsdb *HSDatabase) GetPolicy() (*types.Policy, error) {
 	var p types.Policy
@@ -41,3 +75,115 @@ func (hsdb *HSDatabase) GetPolicy() (*types.Policy, error) {
 
 	return &p, nil
 }
+
+// CreatePolicyRollout stores a new rollout in the canary state. It fails
+// with types.ErrPolicyRolloutInProgress if another rollout is in
+// progress.
+func (hsdb *HSDatabase) CreatePolicyRollout(rollout types.PolicyRollout) (*types.PolicyRollout, error) {
+	return Write(hsdb.DB, func(tx *gorm.DB) (*types.PolicyRollout, error) {
+		if _, err := GetActivePolicyRollout(tx); err == nil {
+			return nil, types.ErrPolicyRolloutInProgress
+		} else if !errors.Is(err, types.ErrPolicyRolloutNotFound) {
+			return nil, err
+		}
+
+		rollout.State = types.PolicyRolloutCanary
+		if err := tx.Create(&rollout).Error; err != nil {
+			return nil, err
+		}
+
+		return &rollout, nil
+	})
+}
+
+func (hsdb *HSDatabase) GetActivePolicyRollout() (*types.PolicyRollout, error) {
+	return Read(hsdb.DB, GetActivePolicyRollout)
+}
+
+// GetActivePolicyRollout returns the rollout in the canary state.
+func GetActivePolicyRollout(tx *gorm.DB) (*types.PolicyRollout, error) {
+	var rollout types.PolicyRollout
+	if err := tx.
+		Where("state = ?", types.PolicyRolloutCanary).
+		First(&rollout).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, types.ErrPolicyRolloutNotFound
+		}
+
+		return nil, err
+	}
+
+	return &rollout, nil
+}
+
+// GetLatestPolicyRollout returns the last rollout started, whatever its
+// state.
+func (hsdb *HSDatabase) GetLatestPolicyRollout() (*types.PolicyRollout, error) {
+	var rollout types.PolicyRollout
+	if err := hsdb.DB.
+		Order("id DESC").
+		First(&rollout).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, types.ErrPolicyRolloutNotFound
+		}
+
+		return nil, err
+	}
+
+	return &rollout, nil
+}
+
+// FinishPolicyRollout moves the rollout in the canary state to state,
+// promoted or rolled back, recording reason.
+func FinishPolicyRollout(tx *gorm.DB, id uint64, state, reason string) (*types.PolicyRollout, error) {
+	rollout, err := GetActivePolicyRollout(tx)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.ID != id {
+		return nil, types.ErrPolicyRolloutNotFound
+	}
+
+	rollout.State = state
+	rollout.Reason = reason
+	if err := tx.Save(rollout).Error; err != nil {
+		return nil, err
+	}
+
+	return rollout, nil
+}
+
+// PromotePolicyRollout stores the policy of the rollout as the policy of
+// the tailnet and marks the rollout promoted. It fails with
+// types.ErrPolicyVersionMismatch if the policy was changed since the
+// rollout started.
+func (hsdb *HSDatabase) PromotePolicyRollout(id uint64) (*types.Policy, error) {
+	return Write(hsdb.DB, func(tx *gorm.DB) (*types.Policy, error) {
+		rollout, err := FinishPolicyRollout(tx, id, types.PolicyRolloutPromoted, "")
+		if err != nil {
+			return nil, err
+		}
+
+		var current types.Policy
+		if err := tx.
+			Order("id DESC").
+			Limit(1).
+			First(&current).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		if current.ID != rollout.BaseVersion {
+			return nil, types.ErrPolicyVersionMismatch
+		}
+
+		p := types.Policy{
+			Data: rollout.Data,
+		}
+
+		if err := tx.Clauses(clause.Returning{}).Create(&p).Error; err != nil {
+			return nil, err
+		}
+
+		return &p, nil
+	})
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/netip"
 	"strings"
 	"time"
 
 	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
 	"gorm.io/gorm"
 	"tailscale.com/types/ptr"
 )
@@ -19,8 +21,21 @@ var (
 	ErrSingleUseAuthKeyHasBeenUsed = errors.New("AuthKey has already been used")
 	ErrUserMismatch                = errors.New("user mismatch")
 	ErrPreAuthKeyACLTagInvalid     = errors.New("AuthKey tag is invalid")
+	ErrPreAuthKeyNodeOptionInvalid = errors.New("AuthKey node option is invalid")
 )
 
+// PreAuthKeyNodeOptions are the properties fixed on the nodes registered
+// with a PreAuthKey.
+type PreAuthKeyNodeOptions struct {
+	NamePrefix     string
+	Expiry         time.Duration
+	ApprovedRoutes []string
+
+	// Service attributes the nodes to a service instead of the user of
+	// the key. It requires the key to be tagged.
+	Service string
+}
+
 func (hsdb *HSDatabase) CreatePreAuthKey(
 	userName string,
 	reusable bool,
@@ -96,6 +111,58 @@ func CreatePreAuthKey(
 	return &key, nil
 }
 
+// SetPreAuthKeyNodeOptions validates the node options and stores them
+// on the key.
+func SetPreAuthKeyNodeOptions(
+	tx *gorm.DB,
+	key *types.PreAuthKey,
+	opts PreAuthKeyNodeOptions,
+) error {
+	if opts.NamePrefix != "" {
+		if err := util.CheckForFQDNRules(opts.NamePrefix); err != nil {
+			return fmt.Errorf("%w: name prefix: %w", ErrPreAuthKeyNodeOptionInvalid, err)
+		}
+	}
+
+	if opts.Expiry < 0 {
+		return fmt.Errorf("%w: node expiry must not be negative", ErrPreAuthKeyNodeOptionInvalid)
+	}
+
+	if opts.Service != "" {
+		if _, err := GetServiceAccount(tx, opts.Service); err != nil {
+			return fmt.Errorf("%w: service %q: %w", ErrPreAuthKeyNodeOptionInvalid, opts.Service, err)
+		}
+
+		var tags int64
+		if err := tx.Model(&types.PreAuthKeyACLTag{}).Where("pre_auth_key_id = ?", key.ID).Count(&tags).Error; err != nil {
+			return fmt.Errorf("failed to count key tags: %w", err)
+		}
+		if tags == 0 {
+			return fmt.Errorf("%w: service requires the key to be tagged", ErrPreAuthKeyNodeOptionInvalid)
+		}
+	}
+
+	var routes types.StringList
+	for _, route := range opts.ApprovedRoutes {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return fmt.Errorf("%w: approved route: %w", ErrPreAuthKeyNodeOptionInvalid, err)
+		}
+		routes = append(routes, prefix.Masked().String())
+	}
+
+	key.NodeNamePrefix = opts.NamePrefix
+	key.NodeExpiry = opts.Expiry
+	key.ApprovedRoutes = routes
+	key.Service = opts.Service
+
+	if err := tx.Save(key).Error; err != nil {
+		return fmt.Errorf("failed to save key node options: %w", err)
+	}
+
+	return nil
+}
+
 func (hsdb *HSDatabase) ListPreAuthKeys(userName string) ([]types.PreAuthKey, error) {
 	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.PreAuthKey, error) {
 		return ListPreAuthKeys(rx, userName)
@@ -117,6 +184,27 @@ func ListPreAuthKeys(tx *gorm.DB, userName string) ([]types.PreAuthKey, error) {
 	return keys, nil
 }
 
+func (hsdb *HSDatabase) ListActivePreAuthKeys(now time.Time) ([]types.PreAuthKey, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.PreAuthKey, error) {
+		return ListActivePreAuthKeys(rx, now)
+	})
+}
+
+// ListActivePreAuthKeys returns the PreAuthKeys that can still register
+// nodes: they have not expired, and are reusable or not used yet.
+func ListActivePreAuthKeys(tx *gorm.DB, now time.Time) ([]types.PreAuthKey, error) {
+	keys := []types.PreAuthKey{}
+	if err := tx.
+		Preload("User").
+		Where("expiration IS NULL OR expiration > ?", now).
+		Where("reusable = ? OR used = ?", true, false).
+		Find(&keys).Error; err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
 // GetPreAuthKey returns a PreAuthKey for a given key.
 func GetPreAuthKey(tx *gorm.DB, user string, key string) (*types.PreAuthKey, error) {
 	pak, err := ValidatePreAuthKey(tx, key)
@@ -193,6 +281,10 @@ func ValidatePreAuthKey(tx *gorm.DB, k string) (*types.PreAuthKey, error) {
 		return nil, ErrPreAuthKeyExpired
 	}
 
+	if pak.User.IsSuspended() {
+		return nil, ErrUserSuspended
+	}
+
 	if pak.Reusable { // we don't need to check if has been used before
 		return &pak, nil
 	}
@@ -1,11 +1,18 @@
 package db
 
 import (
+	"net/netip"
+	"testing"
 	"time"
 
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/check.v1"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 	"tailscale.com/types/ptr"
 )
 
@@ -171,3 +178,116 @@ func (*Suite) TestPreAuthKeyACLTags(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(listedPaks[0].Proto().GetAclTags(), check.DeepEquals, tags)
 }
+
+func TestPreAuthKeyNodeOptions(t *testing.T) {
+	adb, err := newTestDB()
+	require.NoError(t, err)
+
+	user, err := adb.CreateUser("test")
+	require.NoError(t, err)
+
+	pak, err := adb.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	require.NoError(t, err)
+
+	for _, opts := range []PreAuthKeyNodeOptions{
+		{NamePrefix: "CI"},
+		{Expiry: -time.Hour},
+		{ApprovedRoutes: []string{"10.0.0.0"}},
+	} {
+		err := adb.Write(func(tx *gorm.DB) error {
+			return SetPreAuthKeyNodeOptions(tx, pak, opts)
+		})
+		assert.ErrorIs(t, err, ErrPreAuthKeyNodeOptionInvalid, "options %+v", opts)
+	}
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return SetPreAuthKeyNodeOptions(tx, pak, PreAuthKeyNodeOptions{
+			NamePrefix:     "ci",
+			Expiry:         24 * time.Hour,
+			ApprovedRoutes: []string{"10.20.1.1/16"},
+		})
+	})
+	require.NoError(t, err)
+
+	keys, err := adb.ListPreAuthKeys(user.Name)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "ci", keys[0].NodeNamePrefix)
+	assert.Equal(t, 24*time.Hour, keys[0].NodeExpiry)
+	assert.Equal(t, types.StringList{"10.20.0.0/16"}, keys[0].ApprovedRoutes)
+
+	v4 := netip.MustParseAddr("100.64.0.1")
+	node, err := adb.RegisterNode(types.Node{
+		MachineKey:     key.NewMachine().Public(),
+		NodeKey:        key.NewNode().Public(),
+		Hostname:       "runner",
+		UserID:         user.ID,
+		RegisterMethod: util.RegisterMethodAuthKey,
+		AuthKeyID:      ptr.To(pak.ID),
+		Hostinfo: &tailcfg.Hostinfo{
+			RoutableIPs: []netip.Prefix{
+				netip.MustParsePrefix("10.20.30.0/24"),
+				netip.MustParsePrefix("10.30.0.0/24"),
+			},
+		},
+	}, &v4, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ci-runner", node.GivenName)
+
+	_, err = adb.SaveNodeRoutes(node)
+	require.NoError(t, err)
+
+	node, err = adb.GetNodeByID(node.ID)
+	require.NoError(t, err)
+
+	// The routes approved by the key are enabled without a policy.
+	err = adb.EnableAutoApprovedRoutes(nil, node)
+	require.NoError(t, err)
+
+	enabledRoutes, err := adb.GetEnabledRoutes(node)
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.20.30.0/24")}, enabledRoutes)
+}
+
+func TestPreAuthKeyService(t *testing.T) {
+	adb, err := newTestDB()
+	require.NoError(t, err)
+
+	user, err := adb.CreateUser("admin")
+	require.NoError(t, err)
+
+	untagged, err := adb.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return SetPreAuthKeyNodeOptions(tx, untagged, PreAuthKeyNodeOptions{Service: "backup"})
+	})
+	require.ErrorIs(t, err, ErrPreAuthKeyNodeOptionInvalid)
+
+	tagged, err := adb.CreatePreAuthKey(user.Name, true, false, nil, []string{"tag:backup"})
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return SetPreAuthKeyNodeOptions(tx, tagged, PreAuthKeyNodeOptions{Service: "backup"})
+	})
+	require.ErrorIs(t, err, ErrServiceAccountNotFound)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		if _, err := CreateServiceAccount(tx, "backup", "Nightly backups"); err != nil {
+			return err
+		}
+
+		return SetPreAuthKeyNodeOptions(tx, tagged, PreAuthKeyNodeOptions{Service: "backup"})
+	})
+	require.NoError(t, err)
+
+	pak, err := adb.ValidatePreAuthKey(tagged.Key)
+	require.NoError(t, err)
+	assert.Equal(t, "backup", pak.Service)
+	assert.Equal(t, "backup", pak.Proto().GetService())
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return DestroyServiceAccount(tx, "backup")
+	})
+	require.ErrorIs(t, err, ErrServiceAccountInUse)
+}
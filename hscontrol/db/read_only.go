@@ -0,0 +1,34 @@
+package db
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// ErrReadOnly is returned by writes to the database of a replica, it
+// only reads the database of the primary.
+var ErrReadOnly = errors.New("database is read-only, headscale is running as a replica")
+
+// rejectWrites makes every write through the connection fail with
+// ErrReadOnly, before it reaches the database.
+func rejectWrites(db *gorm.DB) error {
+	reject := func(tx *gorm.DB) {
+		_ = tx.AddError(ErrReadOnly)
+	}
+
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("*").Register("headscale:read_only", reject); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("*").Register("headscale:read_only", reject); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("*").Register("headscale:read_only", reject); err != nil {
+		return err
+	}
+
+	// Exec runs the raw callbacks, raw queries run the query or row
+	// callbacks.
+	return callbacks.Raw().Before("*").Register("headscale:read_only", reject)
+}
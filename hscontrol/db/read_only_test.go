@@ -0,0 +1,45 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestRejectWrites(t *testing.T) {
+	hsdb, err := NewHeadscaleDatabase(types.DatabaseConfig{
+		Type: types.DatabaseSqlite,
+		Sqlite: types.SqliteConfig{
+			Path: filepath.Join(t.TempDir(), "headscale.db"),
+		},
+	}, "")
+	if err != nil {
+		t.Fatalf("setting up database: %s", err)
+	}
+
+	if _, err := hsdb.CreateUser("primary"); err != nil {
+		t.Fatalf("CreateUser() error = %s", err)
+	}
+
+	if err := rejectWrites(hsdb.DB); err != nil {
+		t.Fatalf("rejectWrites() error = %s", err)
+	}
+
+	if _, err := hsdb.CreateUser("replica"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("CreateUser() error = %v, want %v", err, ErrReadOnly)
+	}
+	if err := hsdb.DB.Exec("DELETE FROM users").Error; !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Exec() error = %v, want %v", err, ErrReadOnly)
+	}
+
+	// Reads go through.
+	users, err := hsdb.ListUsers()
+	if err != nil {
+		t.Fatalf("ListUsers() error = %s", err)
+	}
+	if len(users) != 1 || users[0].Name != "primary" {
+		t.Errorf("ListUsers() = %+v, want only the user created before", users)
+	}
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func (hsdb *HSDatabase) SavePendingRegistration(machineKey string, node types.Node, expiresAt time.Time) error {
+	return hsdb.Write(func(tx *gorm.DB) error {
+		return SavePendingRegistration(tx, machineKey, node, expiresAt)
+	})
+}
+
+// SavePendingRegistration stores or replaces the node waiting to be
+// registered with the given machine key.
+func SavePendingRegistration(tx *gorm.DB, machineKey string, node types.Node, expiresAt time.Time) error {
+	registration := types.PendingRegistration{
+		MachineKey: machineKey,
+		ExpiresAt:  expiresAt,
+		Node:       node,
+	}
+
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&registration).Error
+}
+
+// DeletePendingRegistration deletes the node waiting to be registered
+// with the given machine key, if any.
+func DeletePendingRegistration(tx *gorm.DB, machineKey string) error {
+	return tx.Where("machine_key = ?", machineKey).Delete(&types.PendingRegistration{}).Error
+}
+
+func (hsdb *HSDatabase) ListPendingRegistrations() ([]types.PendingRegistration, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.PendingRegistration, error) {
+		return ListPendingRegistrations(rx, time.Now())
+	})
+}
+
+// ListPendingRegistrations returns the pending registrations that have
+// not expired by now.
+func ListPendingRegistrations(tx *gorm.DB, now time.Time) ([]types.PendingRegistration, error) {
+	var registrations []types.PendingRegistration
+	if err := tx.Where("expires_at > ?", now).Order("created_at").Find(&registrations).Error; err != nil {
+		return nil, err
+	}
+
+	return registrations, nil
+}
+
+func (hsdb *HSDatabase) DeleteExpiredPendingRegistrations() (int64, error) {
+	return Write(hsdb.DB, func(tx *gorm.DB) (int64, error) {
+		return DeleteExpiredPendingRegistrations(tx, time.Now())
+	})
+}
+
+// DeleteExpiredPendingRegistrations deletes the pending registrations
+// that have expired by now and returns how many it deleted.
+func DeleteExpiredPendingRegistrations(tx *gorm.DB, now time.Time) (int64, error) {
+	result := tx.Where("expires_at <= ?", now).Delete(&types.PendingRegistration{})
+
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestPendingRegistrations(t *testing.T) {
+	db := dbForTest(t, "pending-registrations")
+
+	if _, err := db.CreateUser("test"); err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	pending := key.NewMachine().Public()
+	expired := key.NewMachine().Public()
+	nodeKey := key.NewNode().Public()
+
+	node := types.Node{
+		MachineKey: pending,
+		NodeKey:    nodeKey,
+		Hostname:   "laptop",
+		Expiry:     &time.Time{},
+		Hostinfo:   &tailcfg.Hostinfo{OS: "linux"},
+	}
+
+	if err := db.SavePendingRegistration(pending.String(), node, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SavePendingRegistration() error = %s", err)
+	}
+	// Saving again replaces the registration.
+	node.Hostname = "laptop-2"
+	if err := db.SavePendingRegistration(pending.String(), node, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SavePendingRegistration() error = %s", err)
+	}
+	if err := db.SavePendingRegistration(expired.String(), types.Node{MachineKey: expired}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("SavePendingRegistration() error = %s", err)
+	}
+
+	registrations, err := db.ListPendingRegistrations()
+	if err != nil {
+		t.Fatalf("ListPendingRegistrations() error = %s", err)
+	}
+	if len(registrations) != 1 {
+		t.Fatalf("ListPendingRegistrations() returned %d registrations, want 1", len(registrations))
+	}
+
+	deleted, err := db.DeleteExpiredPendingRegistrations()
+	if err != nil {
+		t.Fatalf("DeleteExpiredPendingRegistrations() error = %s", err)
+	}
+	if deleted != 1 {
+		t.Errorf("DeleteExpiredPendingRegistrations() deleted %d registrations, want 1", deleted)
+	}
+
+	restored := registrations[0].Node
+	if restored.MachineKey != pending || restored.NodeKey != nodeKey {
+		t.Errorf("restored node has keys %s %s, want %s %s", restored.MachineKey, restored.NodeKey, pending, nodeKey)
+	}
+	if restored.Hostname != "laptop-2" || restored.Hostinfo.OS != "linux" {
+		t.Errorf("restored node = %+v, want the last saved node", restored)
+	}
+
+	registrationCache := cache.New(time.Hour, time.Hour)
+	registrationCache.Set(pending.String(), restored, time.Hour)
+
+	_, err = Write(db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		return RegisterNodeFromAuthCallback(tx, registrationCache, pending, "test", nil, util.RegisterMethodCLI, nil, nil)
+	})
+	if err != nil {
+		t.Fatalf("RegisterNodeFromAuthCallback() error = %s", err)
+	}
+
+	registrations, err = db.ListPendingRegistrations()
+	if err != nil {
+		t.Fatalf("ListPendingRegistrations() error = %s", err)
+	}
+	if len(registrations) != 0 {
+		t.Errorf("registered node is still pending")
+	}
+}
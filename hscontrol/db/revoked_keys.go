@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RevokeKeys adds keys of the node to the revoked keys, revoking a key
+// twice is not an error.
+func RevokeKeys(tx *gorm.DB, nodeID types.NodeID, keys ...string) error {
+	for _, key := range keys {
+		revoked := types.RevokedKey{Key: key, NodeID: nodeID.Uint64()}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error; err != nil {
+			return fmt.Errorf("revoking key: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (hsdb *HSDatabase) IsKeyRevoked(key string) (bool, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) (bool, error) {
+		return IsKeyRevoked(rx, key)
+	})
+}
+
+// IsKeyRevoked returns whether the key, with its type prefix, has been
+// revoked.
+func IsKeyRevoked(tx *gorm.DB, key string) (bool, error) {
+	var count int64
+	if err := tx.Model(&types.RevokedKey{}).Where("key = ?", key).Count(&count).Error; err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// RotateNodeKey expires the node and revokes its node key, so it must
+// authenticate again with a new node key. Revoking the machine key too
+// keeps the device from coming back at all, it can only register as a
+// new node once its state is reset.
+func RotateNodeKey(tx *gorm.DB, nodeID types.NodeID, machineKey bool, now time.Time) (*types.Node, error) {
+	node, err := GetNodeByID(tx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{node.NodeKey.String()}
+	if machineKey {
+		keys = append(keys, node.MachineKey.String())
+	}
+
+	if err := RevokeKeys(tx, node.ID, keys...); err != nil {
+		return nil, err
+	}
+
+	if err := NodeSetExpiry(tx, node.ID, now); err != nil {
+		return nil, fmt.Errorf("expiring node: %w", err)
+	}
+	node.Expiry = &now
+
+	return node, nil
+}
@@ -4,12 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"slices"
 	"sort"
+	"time"
 
 	"github.com/juanfont/headscale/hscontrol/policy"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/puzpuzpuz/xsync/v3"
-	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 	"tailscale.com/util/set"
 )
@@ -142,13 +143,20 @@ func DisableRoute(tx *gorm.DB,
 	// https://github.com/juanfont/headscale/issues/804#issuecomment-1399314002
 	var update []types.NodeID
 	if !route.IsExitRoute() {
+		if route.Enabled {
+			err = recordRouteEvent(tx, &node, route.Prefix, types.RouteEventDisabled, routeActor(tx), "")
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		route.Enabled = false
 		err = tx.Save(route).Error
 		if err != nil {
 			return nil, err
 		}
 
-		update, err = failoverRouteTx(tx, isLikelyConnected, route)
+		update, err = failoverRouteTx(tx, isLikelyConnected, route, "primary route was disabled")
 		if err != nil {
 			return nil, err
 		}
@@ -160,6 +168,13 @@ func DisableRoute(tx *gorm.DB,
 
 		for i := range routes {
 			if routes[i].IsExitRoute() {
+				if routes[i].Enabled {
+					err = recordRouteEvent(tx, &node, routes[i].Prefix, types.RouteEventDisabled, routeActor(tx), "")
+					if err != nil {
+						return nil, err
+					}
+				}
+
 				routes[i].Enabled = false
 				routes[i].IsPrimary = false
 
@@ -181,6 +196,58 @@ func DisableRoute(tx *gorm.DB,
 	return update, nil
 }
 
+// SetPrefixRoutes enables or disables the routes for prefix of all nodes
+// advertising it that match. It returns the changed routes, and the nodes
+// that have to be notified about the change.
+func SetPrefixRoutes(
+	tx *gorm.DB,
+	prefix netip.Prefix,
+	enable bool,
+	match func(node *types.Node) bool,
+	isLikelyConnected *xsync.MapOf[types.NodeID, bool],
+) (types.Routes, []types.NodeID, error) {
+	routes, err := getRoutesByPrefix(tx, prefix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting routes by prefix: %w", err)
+	}
+
+	changed := make(set.Set[types.NodeID])
+	var ids []uint64
+	for _, route := range routes {
+		if !route.Advertised || route.Enabled == enable || !match(&route.Node) {
+			continue
+		}
+
+		if enable {
+			update, err := EnableRoute(tx, uint64(route.ID))
+			if err != nil {
+				return nil, nil, err
+			}
+			changed.AddSlice(update.ChangeNodes)
+		} else {
+			update, err := DisableRoute(tx, uint64(route.ID), isLikelyConnected)
+			if err != nil {
+				return nil, nil, err
+			}
+			changed.AddSlice(update)
+		}
+
+		ids = append(ids, uint64(route.ID))
+	}
+
+	var updated types.Routes
+	if len(ids) > 0 {
+		if err := tx.Preload("Node").Preload("Node.User").Find(&updated, ids).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	nodes := changed.Slice()
+	slices.Sort(nodes)
+
+	return updated, nodes, nil
+}
+
 func (hsdb *HSDatabase) DeleteRoute(
 	id uint64,
 	isLikelyConnected *xsync.MapOf[types.NodeID, bool],
@@ -208,7 +275,7 @@ func DeleteRoute(
 	// https://github.com/juanfont/headscale/issues/804#issuecomment-1399314002
 	var update []types.NodeID
 	if !route.IsExitRoute() {
-		update, err = failoverRouteTx(tx, isLikelyConnected, route)
+		update, err = failoverRouteTx(tx, isLikelyConnected, route, "primary route was deleted")
 		if err != nil {
 			return nil, nil
 		}
@@ -216,6 +283,11 @@ func DeleteRoute(
 		if err := tx.Unscoped().Delete(&route).Error; err != nil {
 			return nil, err
 		}
+
+		err = recordRouteEvent(tx, &node, route.Prefix, types.RouteEventDeleted, routeActor(tx), "")
+		if err != nil {
+			return nil, err
+		}
 	} else {
 		routes, err = GetNodeRoutes(tx, &node)
 		if err != nil {
@@ -232,6 +304,13 @@ func DeleteRoute(
 		if err := tx.Unscoped().Delete(&routesToDelete).Error; err != nil {
 			return nil, err
 		}
+
+		for _, r := range routesToDelete {
+			err = recordRouteEvent(tx, &node, r.Prefix, types.RouteEventDeleted, routeActor(tx), "")
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	// If update is empty, it means that one was not created
@@ -265,9 +344,14 @@ func deleteNodeRoutes(tx *gorm.DB, node *types.Node, isLikelyConnected *xsync.Ma
 			return nil, fmt.Errorf("deleting route(%d): %w", &routes[i].ID, err)
 		}
 
+		err = recordRouteEvent(tx, node, routes[i].Prefix, types.RouteEventDeleted, routeActor(tx), "node was deleted")
+		if err != nil {
+			return nil, err
+		}
+
 		// TODO(kradalby): This is a bit too aggressive, we could probably
 		// figure out which routes needs to be failed over rather than all.
-		chn, err := failoverRouteTx(tx, isLikelyConnected, &routes[i])
+		chn, err := failoverRouteTx(tx, isLikelyConnected, &routes[i], "node of the primary route was deleted")
 		if err != nil {
 			return changed, fmt.Errorf("failing over route after delete: %w", err)
 		}
@@ -354,7 +438,7 @@ func SaveNodeRoutes(tx *gorm.DB, node *types.Node) (bool, error) {
 		advertisedRoutes[prefix] = false
 	}
 
-	log.Trace().
+	dbLog.Trace().
 		Str("node", node.Hostname).
 		Interface("advertisedRoutes", advertisedRoutes).
 		Interface("currentRoutes", currentRoutes).
@@ -364,11 +448,17 @@ func SaveNodeRoutes(tx *gorm.DB, node *types.Node) (bool, error) {
 		if _, ok := advertisedRoutes[netip.Prefix(route.Prefix)]; ok {
 			if !route.Advertised {
 				currentRoutes[pos].Advertised = true
+				currentRoutes[pos].WithdrawnAt = nil
 				err := tx.Save(&currentRoutes[pos]).Error
 				if err != nil {
 					return sendUpdate, err
 				}
 
+				err = recordRouteEvent(tx, node, route.Prefix, types.RouteEventAdvertised, types.RouteActorNode, "")
+				if err != nil {
+					return sendUpdate, err
+				}
+
 				// If a route that is newly "saved" is already
 				// enabled, set sendUpdate to true as it is now
 				// available.
@@ -378,12 +468,20 @@ func SaveNodeRoutes(tx *gorm.DB, node *types.Node) (bool, error) {
 			}
 			advertisedRoutes[netip.Prefix(route.Prefix)] = true
 		} else if route.Advertised {
+			// Withdrawn routes are disabled or removed by
+			// WithdrawStaleRoutes.
+			now := time.Now()
 			currentRoutes[pos].Advertised = false
-			currentRoutes[pos].Enabled = false
+			currentRoutes[pos].WithdrawnAt = &now
 			err := tx.Save(&currentRoutes[pos]).Error
 			if err != nil {
 				return sendUpdate, err
 			}
+
+			err = recordRouteEvent(tx, node, route.Prefix, types.RouteEventWithdrawn, types.RouteActorNode, "")
+			if err != nil {
+				return sendUpdate, err
+			}
 		}
 	}
 
@@ -399,12 +497,103 @@ func SaveNodeRoutes(tx *gorm.DB, node *types.Node) (bool, error) {
 			if err != nil {
 				return sendUpdate, err
 			}
+
+			err = recordRouteEvent(tx, node, route.Prefix, types.RouteEventAdvertised, types.RouteActorNode, "")
+			if err != nil {
+				return sendUpdate, err
+			}
 		}
 	}
 
 	return sendUpdate, nil
 }
 
+func (hsdb *HSDatabase) WithdrawStaleRoutes(
+	gracePeriod time.Duration,
+	isLikelyConnected *xsync.MapOf[types.NodeID, bool],
+) (types.Routes, []types.NodeID, error) {
+	var removed types.Routes
+	changed, err := Write(hsdb.DB, func(tx *gorm.DB) ([]types.NodeID, error) {
+		var changed []types.NodeID
+		var err error
+		removed, changed, err = WithdrawStaleRoutes(tx, gracePeriod, isLikelyConnected, time.Now())
+
+		return changed, err
+	})
+
+	return removed, changed, err
+}
+
+// WithdrawStaleRoutes handles the routes their node stopped advertising.
+// Primary routes fail over to another node right away. Once the grace
+// period passed, the routes are removed. Without a grace period, they
+// are disabled and kept instead. It returns the removed routes and the
+// nodes that have to be notified.
+func WithdrawStaleRoutes(
+	tx *gorm.DB,
+	gracePeriod time.Duration,
+	isLikelyConnected *xsync.MapOf[types.NodeID, bool],
+	now time.Time,
+) (types.Routes, []types.NodeID, error) {
+	var routes types.Routes
+	err := tx.
+		Preload("Node").
+		Preload("Node.User").
+		Where("withdrawn_at IS NOT NULL").
+		Find(&routes).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := make(set.Set[types.NodeID])
+	var removed types.Routes
+	for pos := range routes {
+		route := &routes[pos]
+
+		update, err := failoverRouteTx(tx, isLikelyConnected, route, "route is no longer advertised")
+		if err != nil {
+			return nil, nil, err
+		}
+		changed.AddSlice(update)
+
+		switch {
+		case gracePeriod == 0:
+			if route.Enabled {
+				err := recordRouteEvent(tx, &route.Node, route.Prefix, types.RouteEventDisabled, routeActor(tx), "no longer advertised")
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+
+			route.Enabled = false
+			route.WithdrawnAt = nil
+			if err := tx.Save(route).Error; err != nil {
+				return nil, nil, err
+			}
+		case now.Sub(*route.WithdrawnAt) >= gracePeriod:
+			err := tx.Unscoped().Delete(&types.Route{}, route.ID).Error
+			if err != nil {
+				return nil, nil, err
+			}
+			removed = append(removed, *route)
+
+			err = recordRouteEvent(tx, &route.Node, route.Prefix, types.RouteEventDeleted, routeActor(tx), "not advertised for "+gracePeriod.String())
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			continue
+		}
+
+		changed.Add(route.Node.ID)
+	}
+
+	nodes := changed.Slice()
+	slices.Sort(nodes)
+
+	return removed, nodes, nil
+}
+
 // FailoverNodeRoutesIfNeccessary takes a node and checks if the node's route
 // need to be failed over to another host.
 // If needed, the failover will be attempted.
@@ -438,7 +627,7 @@ nodeRouteLoop:
 				// if not, we need to failover the route
 				failover := failoverRoute(isLikelyConnected, &route, routes)
 				if failover != nil {
-					err := failover.save(tx)
+					err := failover.save(tx, "node of the primary route went offline")
 					if err != nil {
 						return nil, fmt.Errorf("saving failover routes: %w", err)
 					}
@@ -476,10 +665,12 @@ nodeRouteLoop:
 //
 // and tries to find a new route to take over its place.
 // If the given route was not primary, it returns early.
+// The reason is recorded on the old and new primary route.
 func failoverRouteTx(
 	tx *gorm.DB,
 	isLikelyConnected *xsync.MapOf[types.NodeID, bool],
 	r *types.Route,
+	reason string,
 ) ([]types.NodeID, error) {
 	if r == nil {
 		return nil, nil
@@ -506,12 +697,12 @@ func failoverRouteTx(
 		return nil, nil
 	}
 
-	err = fo.save(tx)
+	err = fo.save(tx, reason)
 	if err != nil {
 		return nil, fmt.Errorf("saving failover route: %w", err)
 	}
 
-	log.Trace().
+	dbLog.Trace().
 		Str("hostname", fo.new.Node.Hostname).
 		Msgf("set primary to new route, was: id(%d), host(%s), now: id(%d), host(%s)", fo.old.ID, fo.old.Node.Hostname, fo.new.ID, fo.new.Node.Hostname)
 
@@ -524,7 +715,15 @@ type failover struct {
 	new *types.Route
 }
 
-func (f *failover) save(tx *gorm.DB) error {
+// save stores the changed routes, recording why the primary route was
+// changed.
+func (f *failover) save(tx *gorm.DB, reason string) error {
+	now := time.Now()
+	f.old.PrimaryChangedAt = &now
+	f.old.PrimaryReason = fmt.Sprintf("replaced by %s: %s", f.new.Node.Hostname, reason)
+	f.new.PrimaryChangedAt = &now
+	f.new.PrimaryReason = fmt.Sprintf("took over from %s: %s", f.old.Node.Hostname, reason)
+
 	err := tx.Save(f.old).Error
 	if err != nil {
 		return fmt.Errorf("saving old primary: %w", err)
@@ -535,7 +734,7 @@ func (f *failover) save(tx *gorm.DB) error {
 		return fmt.Errorf("saving new primary: %w", err)
 	}
 
-	return nil
+	return recordRouteEvent(tx, &f.new.Node, f.new.Prefix, types.RouteEventPrimary, routeActor(tx), f.new.PrimaryReason)
 }
 
 func failoverRoute(
@@ -605,7 +804,8 @@ func (hsdb *HSDatabase) EnableAutoApprovedRoutes(
 	})
 }
 
-// EnableAutoApprovedRoutes enables any routes advertised by a node that match the ACL autoApprovers policy.
+// EnableAutoApprovedRoutes enables any routes advertised by a node that match the ACL autoApprovers policy,
+// or the approved routes of the pre auth key the node registered with.
 func EnableAutoApprovedRoutes(
 	tx *gorm.DB,
 	aclPolicy *policy.ACLPolicy,
@@ -620,15 +820,25 @@ func EnableAutoApprovedRoutes(
 		return fmt.Errorf("getting advertised routes for node(%s %d): %w", node.Hostname, node.ID, err)
 	}
 
-	log.Trace().Interface("routes", routes).Msg("routes for autoapproving")
+	dbLog.Trace().Interface("routes", routes).Msg("routes for autoapproving")
 
-	var approvedRoutes types.Routes
+	var approvedRoutes, keyApprovedRoutes types.Routes
 
 	for _, advertisedRoute := range routes {
 		if advertisedRoute.Enabled {
 			continue
 		}
 
+		if node.AuthKey != nil && node.AuthKey.ApprovesRoute(netip.Prefix(advertisedRoute.Prefix)) {
+			keyApprovedRoutes = append(keyApprovedRoutes, advertisedRoute)
+
+			continue
+		}
+
+		if aclPolicy == nil {
+			continue
+		}
+
 		routeApprovers, err := aclPolicy.AutoApprovers.GetRouteApprovers(
 			netip.Prefix(advertisedRoute.Prefix),
 		)
@@ -636,7 +846,7 @@ func EnableAutoApprovedRoutes(
 			return fmt.Errorf("failed to resolve autoApprovers for route(%d) for node(%s %d): %w", advertisedRoute.ID, node.Hostname, node.ID, err)
 		}
 
-		log.Trace().
+		dbLog.Trace().
 			Str("node", node.Hostname).
 			Str("user", node.User.Name).
 			Strs("routeApprovers", routeApprovers).
@@ -661,12 +871,102 @@ func EnableAutoApprovedRoutes(
 		}
 	}
 
-	for _, approvedRoute := range approvedRoutes {
-		_, err := EnableRoute(tx, uint64(approvedRoute.ID))
-		if err != nil {
-			return fmt.Errorf("enabling approved route(%d): %w", approvedRoute.ID, err)
+	for _, approval := range []struct {
+		actor  string
+		routes types.Routes
+	}{
+		{types.RouteActorPreAuthKey, keyApprovedRoutes},
+		{types.RouteActorAutoApprovers, approvedRoutes},
+	} {
+		approveTx := tx.WithContext(types.RouteActorKey.WithValue(tx.Statement.Context, approval.actor))
+		for _, approvedRoute := range approval.routes {
+			_, err := EnableRoute(approveTx, uint64(approvedRoute.ID))
+			if err != nil {
+				return fmt.Errorf("enabling approved route(%d): %w", approvedRoute.ID, err)
+			}
 		}
 	}
 
 	return nil
 }
+
+// recordRouteEvent adds a change of a route of the node to the route
+// history.
+func recordRouteEvent(
+	tx *gorm.DB,
+	node *types.Node,
+	prefix types.IPPrefix,
+	action string,
+	actor string,
+	reason string,
+) error {
+	return tx.Create(&types.RouteEvent{
+		NodeID:   node.ID.Uint64(),
+		Hostname: node.Hostname,
+		Prefix:   prefix,
+		Action:   action,
+		Actor:    actor,
+		Reason:   reason,
+	}).Error
+}
+
+// routeActor returns who changes routes in the transaction, set with
+// types.RouteActorKey on its context.
+func routeActor(tx *gorm.DB) string {
+	if tx.Statement.Context == nil {
+		return types.RouteActorHeadscale
+	}
+
+	return types.RouteActorKey.Value(tx.Statement.Context)
+}
+
+func (hsdb *HSDatabase) ListRouteEvents(
+	prefix *netip.Prefix,
+	nodeID types.NodeID,
+	since time.Time,
+) ([]types.RouteEvent, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.RouteEvent, error) {
+		return ListRouteEvents(rx, prefix, nodeID, since)
+	})
+}
+
+// ListRouteEvents returns the route history, oldest first. Only the
+// events of routes overlapping prefix, of the node or since the time
+// are returned if they are set. Exit routes overlap every prefix, their
+// events are only returned when prefix is that exit route.
+func ListRouteEvents(
+	tx *gorm.DB,
+	prefix *netip.Prefix,
+	nodeID types.NodeID,
+	since time.Time,
+) ([]types.RouteEvent, error) {
+	query := tx.Order("created_at, id")
+	if nodeID != 0 {
+		query = query.Where("node_id = ?", nodeID)
+	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+
+	var events []types.RouteEvent
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+
+	if prefix != nil {
+		exitRoute := *prefix == types.ExitRouteV4 || *prefix == types.ExitRouteV6
+		events = slices.DeleteFunc(events, func(event types.RouteEvent) bool {
+			route := netip.Prefix(event.Prefix)
+			if exitRoute {
+				return route != *prefix
+			}
+			if route == types.ExitRouteV4 || route == types.ExitRouteV6 {
+				return true
+			}
+
+			return !prefix.Overlaps(route)
+		})
+	}
+
+	return events, nil
+}
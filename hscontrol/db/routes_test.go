@@ -1,6 +1,7 @@
 package db
 
 import (
+	"context"
 	"net/netip"
 	"os"
 	"testing"
@@ -1048,7 +1049,7 @@ func TestFailoverRouteTx(t *testing.T) {
 			}
 
 			got, err := Write(db.DB, func(tx *gorm.DB) ([]types.NodeID, error) {
-				return failoverRouteTx(tx, smap(tt.isConnected), &tt.failingRoute)
+				return failoverRouteTx(tx, smap(tt.isConnected), &tt.failingRoute, "test")
 			})
 
 			if (err != nil) != tt.wantErr {
@@ -1238,3 +1239,302 @@ func TestFailoverRoute(t *testing.T) {
 		})
 	}
 }
+
+func TestSetPrefixRoutes(t *testing.T) {
+	db := dbForTest(t, "set-prefix-routes")
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+
+	user := types.User{Name: "test"}
+	if err := db.DB.Save(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	for _, node := range []types.Node{
+		{Hostname: "router1", ForcedTags: types.StringList{"tag:router"}},
+		{Hostname: "router2", ForcedTags: types.StringList{"tag:router"}},
+		{Hostname: "laptop"},
+	} {
+		node.UserID = user.ID
+		node.Hostinfo = &tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{prefix}}
+		if err := db.DB.Save(&node).Error; err != nil {
+			t.Fatalf("failed to create node: %s", err)
+		}
+
+		if _, err := db.SaveNodeRoutes(&node); err != nil {
+			t.Fatalf("failed to save routes: %s", err)
+		}
+	}
+
+	isRouter := func(node *types.Node) bool {
+		return util.StringOrPrefixListContains(node.ForcedTags, "tag:router")
+	}
+
+	var changed []types.NodeID
+	routes, err := Write(db.DB, func(tx *gorm.DB) (types.Routes, error) {
+		var routes types.Routes
+		var err error
+		routes, changed, err = SetPrefixRoutes(tx, prefix, true, isRouter, smap(nil))
+
+		return routes, err
+	})
+	if err != nil {
+		t.Fatalf("SetPrefixRoutes() error = %s", err)
+	}
+
+	if diff := cmp.Diff([]types.NodeID{1, 2}, changed); diff != "" {
+		t.Errorf("SetPrefixRoutes() changed nodes mismatch (-want +got):\n%s", diff)
+	}
+
+	if len(routes) != 2 {
+		t.Fatalf("SetPrefixRoutes() changed %d routes, want 2", len(routes))
+	}
+
+	var primaries int
+	for _, route := range routes {
+		if !route.Enabled || route.Node.Hostname == "laptop" {
+			t.Errorf("route %s is not an enabled route of a router", route.String())
+		}
+
+		if route.IsPrimary {
+			primaries++
+		}
+	}
+
+	if primaries != 1 {
+		t.Errorf("SetPrefixRoutes() made %d primary routes, want 1", primaries)
+	}
+}
+
+func TestWithdrawStaleRoutes(t *testing.T) {
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	connected := smap(map[types.NodeID]bool{1: true, 2: true})
+
+	setup := func(t *testing.T) *HSDatabase {
+		db := dbForTest(t, "withdraw-stale-routes")
+
+		user := types.User{Name: "test"}
+		if err := db.DB.Save(&user).Error; err != nil {
+			t.Fatalf("failed to create user: %s", err)
+		}
+
+		for _, hostname := range []string{"router1", "router2"} {
+			node := types.Node{
+				Hostname: hostname,
+				UserID:   user.ID,
+				Hostinfo: &tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{prefix}},
+			}
+			if err := db.DB.Save(&node).Error; err != nil {
+				t.Fatalf("failed to create node: %s", err)
+			}
+
+			if _, err := db.SaveNodeRoutes(&node); err != nil {
+				t.Fatalf("failed to save routes: %s", err)
+			}
+		}
+
+		_, err := Write(db.DB, func(tx *gorm.DB) (types.Routes, error) {
+			routes, _, err := SetPrefixRoutes(tx, prefix, true, func(*types.Node) bool { return true }, connected)
+
+			return routes, err
+		})
+		if err != nil {
+			t.Fatalf("SetPrefixRoutes() error = %s", err)
+		}
+
+		// router1 stops advertising the prefix.
+		node, err := db.GetNodeByID(1)
+		if err != nil {
+			t.Fatalf("GetNodeByID() error = %s", err)
+		}
+		node.Hostinfo = &tailcfg.Hostinfo{}
+		if _, err := db.SaveNodeRoutes(node); err != nil {
+			t.Fatalf("failed to save routes: %s", err)
+		}
+
+		return db
+	}
+
+	withdraw := func(t *testing.T, db *HSDatabase, gracePeriod time.Duration, now time.Time) (types.Routes, []types.NodeID) {
+		var removed types.Routes
+		changed, err := Write(db.DB, func(tx *gorm.DB) ([]types.NodeID, error) {
+			var changed []types.NodeID
+			var err error
+			removed, changed, err = WithdrawStaleRoutes(tx, gracePeriod, connected, now)
+
+			return changed, err
+		})
+		if err != nil {
+			t.Fatalf("WithdrawStaleRoutes() error = %s", err)
+		}
+
+		return removed, changed
+	}
+
+	route := func(t *testing.T, db *HSDatabase, nodeID types.NodeID) *types.Route {
+		routes, err := db.GetNodeRoutes(&types.Node{ID: nodeID})
+		if err != nil {
+			t.Fatalf("GetNodeRoutes() error = %s", err)
+		}
+		if len(routes) == 0 {
+			return nil
+		}
+
+		return &routes[0]
+	}
+
+	t.Run("grace-period", func(t *testing.T) {
+		db := setup(t)
+
+		// The route fails over at once but stays enabled.
+		removed, changed := withdraw(t, db, time.Hour, time.Now())
+		if len(removed) != 0 {
+			t.Errorf("WithdrawStaleRoutes() removed %d routes during the grace period", len(removed))
+		}
+		if diff := cmp.Diff([]types.NodeID{1, 2}, changed); diff != "" {
+			t.Errorf("WithdrawStaleRoutes() changed nodes mismatch (-want +got):\n%s", diff)
+		}
+		if r := route(t, db, 1); r == nil || !r.Enabled || r.IsPrimary || r.WithdrawnAt == nil {
+			t.Errorf("route of router1 = %+v, want enabled, withdrawn and not primary", r)
+		}
+		if r := route(t, db, 2); r == nil || !r.IsPrimary {
+			t.Errorf("route of router2 = %+v, want primary", r)
+		}
+
+		removed, changed = withdraw(t, db, time.Hour, time.Now().Add(2*time.Hour))
+		if len(removed) != 1 || removed[0].Node.Hostname != "router1" {
+			t.Errorf("WithdrawStaleRoutes() removed %v, want the route of router1", removed)
+		}
+		if diff := cmp.Diff([]types.NodeID{1}, changed); diff != "" {
+			t.Errorf("WithdrawStaleRoutes() changed nodes mismatch (-want +got):\n%s", diff)
+		}
+		if r := route(t, db, 1); r != nil {
+			t.Errorf("route of router1 = %+v, want removed", r)
+		}
+	})
+
+	t.Run("readvertised", func(t *testing.T) {
+		db := setup(t)
+		withdraw(t, db, time.Hour, time.Now())
+
+		node, err := db.GetNodeByID(1)
+		if err != nil {
+			t.Fatalf("GetNodeByID() error = %s", err)
+		}
+		node.Hostinfo = &tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{prefix}}
+		sendUpdate, err := db.SaveNodeRoutes(node)
+		if err != nil {
+			t.Fatalf("failed to save routes: %s", err)
+		}
+		if !sendUpdate {
+			t.Errorf("SaveNodeRoutes() = false, want an update for the enabled route")
+		}
+
+		withdraw(t, db, time.Hour, time.Now().Add(2*time.Hour))
+		if r := route(t, db, 1); r == nil || !r.Enabled || !r.Advertised || r.WithdrawnAt != nil {
+			t.Errorf("route of router1 = %+v, want enabled and advertised", r)
+		}
+	})
+
+	t.Run("no-grace-period", func(t *testing.T) {
+		db := setup(t)
+
+		removed, _ := withdraw(t, db, 0, time.Now())
+		if len(removed) != 0 {
+			t.Errorf("WithdrawStaleRoutes() removed %d routes, want them disabled", len(removed))
+		}
+		if r := route(t, db, 1); r == nil || r.Enabled || r.WithdrawnAt != nil {
+			t.Errorf("route of router1 = %+v, want disabled and kept", r)
+		}
+	})
+}
+
+func TestListRouteEvents(t *testing.T) {
+	db := dbForTest(t, "list-route-events")
+	connected := smap(map[types.NodeID]bool{1: true})
+
+	user := types.User{Name: "test"}
+	if err := db.DB.Save(&user).Error; err != nil {
+		t.Fatalf("failed to create user: %s", err)
+	}
+
+	node := types.Node{
+		Hostname: "router1",
+		UserID:   user.ID,
+		Hostinfo: &tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{
+			netip.MustParsePrefix("10.1.0.0/16"),
+			netip.MustParsePrefix("192.168.0.0/24"),
+			types.ExitRouteV4,
+		}},
+	}
+	if err := db.DB.Save(&node).Error; err != nil {
+		t.Fatalf("failed to create node: %s", err)
+	}
+	if _, err := db.SaveNodeRoutes(&node); err != nil {
+		t.Fatalf("failed to save routes: %s", err)
+	}
+
+	ctx := types.RouteActorKey.WithValue(context.Background(), "api key abc")
+	_, err := Write(db.DB.WithContext(ctx), func(tx *gorm.DB) (types.Routes, error) {
+		routes, _, err := SetPrefixRoutes(
+			tx,
+			netip.MustParsePrefix("10.1.0.0/16"),
+			true,
+			func(*types.Node) bool { return true },
+			connected,
+		)
+
+		return routes, err
+	})
+	if err != nil {
+		t.Fatalf("SetPrefixRoutes() error = %s", err)
+	}
+
+	node.Hostinfo = &tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{
+		netip.MustParsePrefix("192.168.0.0/24"),
+		types.ExitRouteV4,
+	}}
+	if _, err := db.SaveNodeRoutes(&node); err != nil {
+		t.Fatalf("failed to save routes: %s", err)
+	}
+
+	type event struct{ Prefix, Action, Actor string }
+	list := func(prefix *netip.Prefix) []event {
+		events, err := db.ListRouteEvents(prefix, 0, time.Time{})
+		if err != nil {
+			t.Fatalf("ListRouteEvents() error = %s", err)
+		}
+
+		var got []event
+		for _, e := range events {
+			if e.Hostname != "router1" {
+				t.Errorf("event hostname = %q, want router1", e.Hostname)
+			}
+			got = append(got, event{netip.Prefix(e.Prefix).String(), e.Action, e.Actor})
+		}
+
+		return got
+	}
+
+	prefix := netip.MustParsePrefix("10.0.0.0/8")
+	want := []event{
+		{"10.1.0.0/16", types.RouteEventAdvertised, types.RouteActorNode},
+		{"10.1.0.0/16", types.RouteEventEnabled, "api key abc"},
+		{"10.1.0.0/16", types.RouteEventPrimary, "api key abc"},
+		{"10.1.0.0/16", types.RouteEventWithdrawn, types.RouteActorNode},
+	}
+	if diff := cmp.Diff(want, list(&prefix)); diff != "" {
+		t.Errorf("ListRouteEvents(10.0.0.0/8) mismatch (-want +got):\n%s", diff)
+	}
+
+	exitRoute := types.ExitRouteV4
+	want = []event{
+		{"0.0.0.0/0", types.RouteEventAdvertised, types.RouteActorNode},
+	}
+	if diff := cmp.Diff(want, list(&exitRoute)); diff != "" {
+		t.Errorf("ListRouteEvents(0.0.0.0/0) mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := list(nil); len(got) != 6 {
+		t.Errorf("ListRouteEvents(nil) = %v, want 6 events", got)
+	}
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrServiceAccountExists   = errors.New("service account already exists")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrServiceAccountInUse    = errors.New("service account still attributed to nodes or pre auth keys")
+)
+
+// CreateServiceAccount creates a service account, its name follows the
+// rules of user names.
+func CreateServiceAccount(tx *gorm.DB, name, description string) (*types.ServiceAccount, error) {
+	if err := util.CheckForFQDNRules(name); err != nil {
+		return nil, err
+	}
+
+	if _, err := GetServiceAccount(tx, name); err == nil {
+		return nil, ErrServiceAccountExists
+	}
+
+	account := types.ServiceAccount{
+		Name:        name,
+		Description: description,
+	}
+	if err := tx.Create(&account).Error; err != nil {
+		return nil, fmt.Errorf("creating service account: %w", err)
+	}
+
+	return &account, nil
+}
+
+// GetServiceAccount returns the service account with the name.
+func GetServiceAccount(tx *gorm.DB, name string) (*types.ServiceAccount, error) {
+	var account types.ServiceAccount
+	if err := tx.Where("name = ?", name).First(&account).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrServiceAccountNotFound
+		}
+
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+func (hsdb *HSDatabase) ListServiceAccounts() ([]types.ServiceAccount, error) {
+	return Read(hsdb.DB, ListServiceAccounts)
+}
+
+// ListServiceAccounts returns the service accounts ordered by name.
+func ListServiceAccounts(tx *gorm.DB) ([]types.ServiceAccount, error) {
+	accounts := []types.ServiceAccount{}
+	if err := tx.Order("name").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// DestroyServiceAccount deletes a service account, unless nodes or pre
+// auth keys are still attributed to it.
+func DestroyServiceAccount(tx *gorm.DB, name string) error {
+	account, err := GetServiceAccount(tx, name)
+	if err != nil {
+		return err
+	}
+
+	var nodes, keys int64
+	if err := tx.Model(&types.Node{}).Where("service = ?", name).Count(&nodes).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&types.PreAuthKey{}).Where("service = ?", name).Count(&keys).Error; err != nil {
+		return err
+	}
+	if nodes > 0 || keys > 0 {
+		return fmt.Errorf("%w: %d node(s), %d key(s)", ErrServiceAccountInUse, nodes, keys)
+	}
+
+	return tx.Delete(account).Error
+}
+
+// SetNodeService attributes the node to the service account, or back
+// to its user if service is empty.
+func SetNodeService(tx *gorm.DB, nodeID types.NodeID, service string) error {
+	if service != "" {
+		if _, err := GetServiceAccount(tx, service); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&types.Node{}).Where("id = ?", nodeID).Update("service", service).Error; err != nil {
+		return fmt.Errorf("failed to update node service in the database: %w", err)
+	}
+
+	return nil
+}
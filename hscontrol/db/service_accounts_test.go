@@ -0,0 +1,96 @@
+package db
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"tailscale.com/types/key"
+)
+
+func TestServiceAccounts(t *testing.T) {
+	adb, err := newTestDB()
+	require.NoError(t, err)
+
+	user, err := adb.CreateUser("admin")
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		for _, name := range []string{"monitoring", "backup"} {
+			if _, err := CreateServiceAccount(tx, name, ""); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		_, err := CreateServiceAccount(tx, "backup", "")
+
+		return err
+	})
+	require.ErrorIs(t, err, ErrServiceAccountExists)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		_, err := CreateServiceAccount(tx, "Backup Jobs", "")
+
+		return err
+	})
+	require.ErrorIs(t, err, util.ErrInvalidUserName)
+
+	accounts, err := adb.ListServiceAccounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	assert.Equal(t, "backup", accounts[0].Name)
+	assert.Equal(t, "monitoring", accounts[1].Name)
+
+	v4 := netip.MustParseAddr("100.64.0.1")
+	node, err := adb.RegisterNode(types.Node{
+		MachineKey:     key.NewMachine().Public(),
+		NodeKey:        key.NewNode().Public(),
+		Hostname:       "backup-1",
+		UserID:         user.ID,
+		RegisterMethod: util.RegisterMethodCLI,
+		ForcedTags:     []string{"tag:backup"},
+	}, &v4, nil)
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return SetNodeService(tx, node.ID, "unknown")
+	})
+	require.ErrorIs(t, err, ErrServiceAccountNotFound)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return SetNodeService(tx, node.ID, "backup")
+	})
+	require.NoError(t, err)
+
+	node, err = adb.GetNodeByID(node.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "backup", node.Service)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return DestroyServiceAccount(tx, "backup")
+	})
+	require.ErrorIs(t, err, ErrServiceAccountInUse)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		if err := SetNodeService(tx, node.ID, ""); err != nil {
+			return err
+		}
+
+		return DestroyServiceAccount(tx, "backup")
+	})
+	require.NoError(t, err)
+
+	err = adb.Write(func(tx *gorm.DB) error {
+		return DestroyServiceAccount(tx, "backup")
+	})
+	require.ErrorIs(t, err, ErrServiceAccountNotFound)
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const defaultSQLiteBusyTimeout = 10 * time.Second
+
+var (
+	errSnapshotNotSupported = errors.New(
+		"snapshots are only supported for sqlite, use pg_dump for postgres",
+	)
+	errSnapshotExists  = errors.New("snapshot file already exists")
+	errSnapshotCorrupt = errors.New("snapshot failed the integrity check")
+)
+
+// Checkpoint copies the content of the SQLite WAL into the database
+// file with the given checkpoint mode. A checkpoint that cannot finish
+// because a reader, like a backup tool, holds the WAL is not an error,
+// it is completed by a later checkpoint.
+func (hsdb *HSDatabase) Checkpoint(mode string) error {
+	var busy, walPages, checkpointedPages int
+
+	err := hsdb.DB.Raw(
+		"PRAGMA wal_checkpoint("+strings.ToUpper(mode)+")",
+	).Row().Scan(&busy, &walPages, &checkpointedPages)
+	if err != nil {
+		return fmt.Errorf("checkpointing WAL: %w", err)
+	}
+
+	dbLog.Debug().
+		Bool("busy", busy != 0).
+		Int("wal_pages", walPages).
+		Int("checkpointed_pages", checkpointedPages).
+		Str("mode", mode).
+		Msg("Checkpointed SQLite WAL")
+
+	return nil
+}
+
+// Snapshot writes a consistent copy of the SQLite database to path. It
+// only reads the database, so it is safe to run while headscale is
+// serving. The copy is vacuumed and has no WAL.
+func Snapshot(cfg types.DatabaseConfig, path string) error {
+	if cfg.Type != types.DatabaseSqlite {
+		return errSnapshotNotSupported
+	}
+
+	dbConn, err := openDB(cfg)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := dbConn.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	return snapshot(dbConn, path)
+}
+
+func snapshot(dbConn *gorm.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s: %w", path, errSnapshotExists)
+	}
+
+	if err := util.EnsureDir(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("creating directory for snapshot: %w", err)
+	}
+
+	if err := dbConn.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("writing snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// VerifySnapshot runs the SQLite integrity check on a snapshot.
+func VerifySnapshot(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+
+	dbConn, err := gorm.Open(sqlite.Open(path), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return fmt.Errorf("opening snapshot: %w", err)
+	}
+
+	sqlDB, err := dbConn.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	var result string
+	if err := dbConn.Raw("PRAGMA integrity_check").Row().Scan(&result); err != nil {
+		return fmt.Errorf("checking snapshot integrity: %w", err)
+	}
+
+	if result != "ok" {
+		return fmt.Errorf("%w: %s", errSnapshotCorrupt, result)
+	}
+
+	return nil
+}
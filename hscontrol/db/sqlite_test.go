@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	cfg := types.DatabaseConfig{
+		Type: types.DatabaseSqlite,
+		Sqlite: types.SqliteConfig{
+			Path:          filepath.Join(dir, "headscale.db"),
+			WriteAheadLog: true,
+		},
+	}
+
+	hsdb, err := NewHeadscaleDatabase(cfg, "")
+	if err != nil {
+		t.Fatalf("setting up database: %s", err)
+	}
+
+	if _, err := hsdb.CreateUser("snapshot"); err != nil {
+		t.Fatalf("CreateUser() error = %s", err)
+	}
+
+	if err := hsdb.Checkpoint("passive"); err != nil {
+		t.Fatalf("Checkpoint() error = %s", err)
+	}
+
+	// The server keeps its connection open while the snapshot is taken.
+	snapshotPath := filepath.Join(dir, "backup", "snapshot.db")
+	if err := Snapshot(cfg, snapshotPath); err != nil {
+		t.Fatalf("Snapshot() error = %s", err)
+	}
+
+	snapshotDB, err := NewHeadscaleDatabase(types.DatabaseConfig{
+		Type:   types.DatabaseSqlite,
+		Sqlite: types.SqliteConfig{Path: snapshotPath},
+	}, "")
+	if err != nil {
+		t.Fatalf("opening snapshot: %s", err)
+	}
+
+	if _, err := snapshotDB.GetUser("snapshot"); err != nil {
+		t.Errorf("GetUser() in snapshot error = %s", err)
+	}
+
+	if err := VerifySnapshot(snapshotPath); err != nil {
+		t.Errorf("VerifySnapshot() error = %s", err)
+	}
+
+	corruptPath := filepath.Join(dir, "corrupt.db")
+	if err := os.WriteFile(corruptPath, []byte("not a database"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySnapshot(corruptPath); err == nil {
+		t.Errorf("VerifySnapshot() of a corrupt file returned no error")
+	}
+
+	if err := Snapshot(cfg, snapshotPath); !errors.Is(err, errSnapshotExists) {
+		t.Errorf("Snapshot() to an existing file error = %v, want %s", err, errSnapshotExists)
+	}
+
+	err = Snapshot(types.DatabaseConfig{Type: types.DatabasePostgres}, snapshotPath)
+	if !errors.Is(err, errSnapshotNotSupported) {
+		t.Errorf("Snapshot() of postgres error = %v, want %s", err, errSnapshotNotSupported)
+	}
+}
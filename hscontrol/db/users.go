@@ -3,6 +3,7 @@ package db
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
@@ -13,6 +14,8 @@ var (
 	ErrUserExists        = errors.New("user already exists")
 	ErrUserNotFound      = errors.New("user not found")
 	ErrUserStillHasNodes = errors.New("user not empty: node(s) found")
+	ErrUserSuspended     = errors.New("user is suspended")
+	ErrUserNotSuspended  = errors.New("user is not suspended")
 )
 
 func (hsdb *HSDatabase) CreateUser(name string) (*types.User, error) {
@@ -37,6 +40,11 @@ func CreateUser(tx *gorm.DB, name string) (*types.User, error) {
 		return nil, fmt.Errorf("creating user: %w", err)
 	}
 
+	// A user takes the name over from a renamed user.
+	if err := expireUserAliases(tx, name, time.Now()); err != nil {
+		return nil, err
+	}
+
 	return &user, nil
 }
 
@@ -112,9 +120,184 @@ func RenameUser(tx *gorm.DB, oldName, newName string) error {
 		return result.Error
 	}
 
+	if err := expireUserAliases(tx, newName, time.Now()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RenameUserWithAlias renames a User like RenameUser and records the
+// old name as an alias of the User, resolving to it until expiresAt.
+func RenameUserWithAlias(tx *gorm.DB, oldName, newName string, expiresAt time.Time) error {
+	if err := RenameUser(tx, oldName, newName); err != nil {
+		return err
+	}
+
+	user, err := GetUser(tx, newName)
+	if err != nil {
+		return err
+	}
+
+	alias := types.UserAlias{
+		Name:      oldName,
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.Create(&alias).Error; err != nil {
+		return fmt.Errorf("creating user alias: %w", err)
+	}
+
 	return nil
 }
 
+// expireUserAliases expires the aliases with the given name, so it no
+// longer resolves to a renamed user.
+func expireUserAliases(tx *gorm.DB, name string, now time.Time) error {
+	return tx.Model(&types.UserAlias{}).
+		Where("name = ? AND expires_at > ?", name, now).
+		Update("expires_at", now).Error
+}
+
+func (hsdb *HSDatabase) ListUserAliases(name string) ([]types.UserAlias, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) ([]types.UserAlias, error) {
+		return ListUserAliases(rx, name)
+	})
+}
+
+// ListUserAliases returns the previous names of a User, including
+// expired ones, oldest first.
+func ListUserAliases(tx *gorm.DB, name string) ([]types.UserAlias, error) {
+	user, err := GetUser(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := []types.UserAlias{}
+	if err := tx.
+		Where(&types.UserAlias{UserID: user.ID}).
+		Order("created_at").
+		Find(&aliases).Error; err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+func (hsdb *HSDatabase) ActiveUserAliases() (map[string]types.UserAlias, error) {
+	return Read(hsdb.DB, func(rx *gorm.DB) (map[string]types.UserAlias, error) {
+		return ActiveUserAliases(rx, time.Now())
+	})
+}
+
+// ActiveUserAliases returns the aliases that have not expired by their
+// name, with the User they resolve to.
+func ActiveUserAliases(tx *gorm.DB, now time.Time) (map[string]types.UserAlias, error) {
+	aliases := []types.UserAlias{}
+	if err := tx.
+		Preload("User").
+		Where("expires_at > ?", now).
+		Find(&aliases).Error; err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]types.UserAlias, len(aliases))
+	for _, alias := range aliases {
+		resolved[alias.Name] = alias
+	}
+
+	return resolved, nil
+}
+
+// SuspendUser suspends a User and expires all of its nodes at the given
+// time. The expired nodes are returned so their peers can be notified.
+// Returns error if the User does not exist or is already suspended.
+func SuspendUser(tx *gorm.DB, name string, now time.Time) (types.Nodes, error) {
+	user, err := GetUser(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.IsSuspended() {
+		return nil, ErrUserSuspended
+	}
+
+	user.SuspendedAt = &now
+	if err := tx.Save(user).Error; err != nil {
+		return nil, fmt.Errorf("suspending user: %w", err)
+	}
+
+	return ExpireUserNodes(tx, name, now)
+}
+
+// ExpireUserNodes expires all the nodes of a User at the given time.
+// The expired nodes are returned so their peers can be notified.
+func ExpireUserNodes(tx *gorm.DB, name string, now time.Time) (types.Nodes, error) {
+	nodes, err := ListNodesByUser(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		if err := NodeSetExpiry(tx, node.ID, now); err != nil {
+			return nil, fmt.Errorf("expiring node %d: %w", node.ID, err)
+		}
+	}
+
+	return nodes, nil
+}
+
+// ResumeUser lifts the suspension of a User, its nodes stay expired
+// until they are authenticated again.
+// Returns error if the User does not exist or is not suspended.
+func ResumeUser(tx *gorm.DB, name string) (*types.User, error) {
+	user, err := GetUser(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.IsSuspended() {
+		return nil, ErrUserNotSuspended
+	}
+
+	if err := tx.Model(user).Update("suspended_at", nil).Error; err != nil {
+		return nil, fmt.Errorf("resuming user: %w", err)
+	}
+	user.SuspendedAt = nil
+
+	return user, nil
+}
+
+// SetUserPassword sets the password hash of a User for the built-in
+// authentication, an empty hash removes the password.
+func SetUserPassword(tx *gorm.DB, name string, hash string) (*types.User, error) {
+	user, err := GetUser(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(user).Update("password_hash", hash).Error; err != nil {
+		return nil, fmt.Errorf("setting password: %w", err)
+	}
+
+	return user, nil
+}
+
+// SetUserTOTP sets the TOTP secret of a User for the built-in
+// authentication, an empty secret disables TOTP.
+func SetUserTOTP(tx *gorm.DB, name string, secret string) (*types.User, error) {
+	user, err := GetUser(tx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Model(user).Update("totp_secret", secret).Error; err != nil {
+		return nil, fmt.Errorf("setting TOTP secret: %w", err)
+	}
+
+	return user, nil
+}
+
 func (hsdb *HSDatabase) GetUser(name string) (*types.User, error) {
 	return Read(hsdb.DB, func(rx *gorm.DB) (*types.User, error) {
 		return GetUser(rx, name)
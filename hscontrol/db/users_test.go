@@ -1,6 +1,8 @@
 package db
 
 import (
+	"time"
+
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
 	"gopkg.in/check.v1"
@@ -90,6 +92,95 @@ func (s *Suite) TestRenameUser(c *check.C) {
 	c.Assert(err, check.Equals, ErrUserExists)
 }
 
+func (s *Suite) TestRenameUserWithAlias(c *check.C) {
+	_, err := db.CreateUser("old")
+	c.Assert(err, check.IsNil)
+
+	now := time.Now()
+	err = db.Write(func(tx *gorm.DB) error {
+		return RenameUserWithAlias(tx, "old", "new", now.Add(time.Hour))
+	})
+	c.Assert(err, check.IsNil)
+
+	aliases, err := db.ActiveUserAliases()
+	c.Assert(err, check.IsNil)
+	c.Assert(aliases, check.HasLen, 1)
+	c.Assert(aliases["old"].User.Name, check.Equals, "new")
+
+	history, err := db.ListUserAliases("new")
+	c.Assert(err, check.IsNil)
+	c.Assert(history, check.HasLen, 1)
+	c.Assert(history[0].Name, check.Equals, "old")
+
+	// Creating a user with the old name expires the alias,
+	// but keeps it in the history of the renamed user.
+	_, err = db.CreateUser("old")
+	c.Assert(err, check.IsNil)
+
+	aliases, err = db.ActiveUserAliases()
+	c.Assert(err, check.IsNil)
+	c.Assert(aliases, check.HasLen, 0)
+
+	history, err = db.ListUserAliases("new")
+	c.Assert(err, check.IsNil)
+	c.Assert(history, check.HasLen, 1)
+}
+
+func (s *Suite) TestSuspendAndResumeUser(c *check.C) {
+	user, err := db.CreateUser("test")
+	c.Assert(err, check.IsNil)
+
+	pak, err := db.CreatePreAuthKey(user.Name, true, false, nil, nil)
+	c.Assert(err, check.IsNil)
+
+	node := types.Node{
+		ID:             0,
+		Hostname:       "testnode",
+		UserID:         user.ID,
+		RegisterMethod: util.RegisterMethodAuthKey,
+		AuthKeyID:      ptr.To(pak.ID),
+	}
+	trx := db.DB.Save(&node)
+	c.Assert(trx.Error, check.IsNil)
+
+	now := time.Now()
+	nodes, err := Write(db.DB, func(tx *gorm.DB) (types.Nodes, error) {
+		return SuspendUser(tx, "test", now)
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+
+	suspended, err := db.GetUser("test")
+	c.Assert(err, check.IsNil)
+	c.Assert(suspended.IsSuspended(), check.Equals, true)
+
+	expired, err := db.GetNodeByID(node.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(expired.IsExpired(), check.Equals, true)
+
+	_, err = db.ValidatePreAuthKey(pak.Key)
+	c.Assert(err, check.Equals, ErrUserSuspended)
+
+	_, err = Write(db.DB, func(tx *gorm.DB) (types.Nodes, error) {
+		return SuspendUser(tx, "test", now)
+	})
+	c.Assert(err, check.Equals, ErrUserSuspended)
+
+	resumed, err := Write(db.DB, func(tx *gorm.DB) (*types.User, error) {
+		return ResumeUser(tx, "test")
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(resumed.IsSuspended(), check.Equals, false)
+
+	_, err = db.ValidatePreAuthKey(pak.Key)
+	c.Assert(err, check.IsNil)
+
+	_, err = Write(db.DB, func(tx *gorm.DB) (*types.User, error) {
+		return ResumeUser(tx, "test")
+	})
+	c.Assert(err, check.Equals, ErrUserNotSuspended)
+}
+
 func (s *Suite) TestSetMachineUser(c *check.C) {
 	oldUser, err := db.CreateUser("old")
 	c.Assert(err, check.IsNil)
@@ -9,11 +9,13 @@ import (
 	"os"
 
 	"github.com/juanfont/headscale/hscontrol/types"
-	"github.com/rs/zerolog/log"
+	"github.com/juanfont/headscale/hscontrol/util"
 	"gopkg.in/yaml.v3"
 	"tailscale.com/tailcfg"
 )
 
+var derpLog = util.NewSubsystemLogger(util.LogSubsystemDERP)
+
 func loadDERPMapFromPath(path string) (*tailcfg.DERPMap, error) {
 	derpFile, err := os.Open(path)
 	if err != nil {
@@ -84,13 +86,13 @@ func GetDERPMap(cfg types.DERPConfig) *tailcfg.DERPMap {
 	var derpMaps []*tailcfg.DERPMap
 
 	for _, path := range cfg.Paths {
-		log.Debug().
+		derpLog.Debug().
 			Str("func", "GetDERPMap").
 			Str("path", path).
 			Msg("Loading DERPMap from path")
 		derpMap, err := loadDERPMapFromPath(path)
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Str("func", "GetDERPMap").
 				Str("path", path).
 				Err(err).
@@ -104,12 +106,12 @@ func GetDERPMap(cfg types.DERPConfig) *tailcfg.DERPMap {
 
 	for _, addr := range cfg.URLs {
 		derpMap, err := loadDERPMapFromURL(addr)
-		log.Debug().
+		derpLog.Debug().
 			Str("func", "GetDERPMap").
 			Str("url", addr.String()).
 			Msg("Loading DERPMap from path")
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Str("func", "GetDERPMap").
 				Str("url", addr.String()).
 				Err(err).
@@ -123,7 +125,7 @@ func GetDERPMap(cfg types.DERPConfig) *tailcfg.DERPMap {
 
 	derpMap := mergeDERPMaps(derpMaps)
 
-	log.Trace().Interface("derpMap", derpMap).Msg("DERPMap loaded")
+	derpLog.Trace().Interface("derpMap", derpMap).Msg("DERPMap loaded")
 
 	return derpMap
 }
@@ -14,13 +14,14 @@ import (
 
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/rs/zerolog/log"
 	"tailscale.com/derp"
 	"tailscale.com/net/stun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 )
 
+var derpLog = util.NewSubsystemLogger(util.LogSubsystemDERP)
+
 // fastStartHeader is the header (with value "1") that signals to the HTTP
 // server that the DERP HTTP client does not want the HTTP 101 response
 // headers and it will begin writing & reading the DERP protocol immediately
@@ -32,6 +33,9 @@ type DERPServer struct {
 	key           key.NodePrivate
 	cfg           *types.DERPConfig
 	tailscaleDERP *derp.Server
+	usage         *usageTracker
+	rateLimitFn   RateLimitFunc
+	banFn         BanFunc
 }
 
 func NewDERPServer(
@@ -39,15 +43,19 @@ func NewDERPServer(
 	derpKey key.NodePrivate,
 	cfg *types.DERPConfig,
 ) (*DERPServer, error) {
-	log.Trace().Caller().Msg("Creating new embedded DERP server")
+	derpLog.Trace().Caller().Msg("Creating new embedded DERP server")
 	server := derp.NewServer(derpKey, util.TSLogfWrapper()) // nolint // zerolinter complains
 
-	return &DERPServer{
+	d := &DERPServer{
 		serverURL:     serverURL,
 		key:           derpKey,
 		cfg:           cfg,
 		tailscaleDERP: server,
-	}, nil
+		usage:         newUsageTracker(),
+	}
+	registerCollector(d)
+
+	return d, nil
 }
 
 func (d *DERPServer) GenerateRegion() (tailcfg.DERPRegion, error) {
@@ -73,35 +81,59 @@ func (d *DERPServer) GenerateRegion() (tailcfg.DERPRegion, error) {
 		}
 	}
 
+	if d.cfg.ServerHostname != "" {
+		host = d.cfg.ServerHostname
+	}
+	if d.cfg.ServerDERPPort != 0 {
+		port = d.cfg.ServerDERPPort
+	}
+
+	name := d.cfg.ServerNodeName
+	if name == "" {
+		name = fmt.Sprintf("%d", d.cfg.ServerRegionID)
+	}
+
 	localDERPregion := tailcfg.DERPRegion{
 		RegionID:   d.cfg.ServerRegionID,
 		RegionCode: d.cfg.ServerRegionCode,
 		RegionName: d.cfg.ServerRegionName,
+		Latitude:   d.cfg.Latitude,
+		Longitude:  d.cfg.Longitude,
 		Avoid:      false,
 		Nodes: []*tailcfg.DERPNode{
 			{
-				Name:     fmt.Sprintf("%d", d.cfg.ServerRegionID),
-				RegionID: d.cfg.ServerRegionID,
-				HostName: host,
-				DERPPort: port,
-				IPv4:     d.cfg.IPv4,
-				IPv6:     d.cfg.IPv6,
+				Name:      name,
+				RegionID:  d.cfg.ServerRegionID,
+				HostName:  host,
+				DERPPort:  port,
+				IPv4:      d.cfg.IPv4,
+				IPv6:      d.cfg.IPv6,
+				CanPort80: d.cfg.CanPort80,
 			},
 		},
 	}
 
-	_, portSTUNStr, err := net.SplitHostPort(d.cfg.STUNAddr)
-	if err != nil {
-		return tailcfg.DERPRegion{}, err
-	}
-	portSTUN, err := strconv.Atoi(portSTUNStr)
-	if err != nil {
-		return tailcfg.DERPRegion{}, err
+	// The STUN port is advertised separately when the listener sits
+	// behind a NAT or load balancer mapping another port.
+	portSTUN := d.cfg.ServerSTUNPort
+	if portSTUN == 0 {
+		_, portSTUNStr, err := net.SplitHostPort(d.cfg.STUNAddr)
+		if err != nil {
+			return tailcfg.DERPRegion{}, err
+		}
+		portSTUN, err = strconv.Atoi(portSTUNStr)
+		if err != nil {
+			return tailcfg.DERPRegion{}, err
+		}
 	}
 	localDERPregion.Nodes[0].STUNPort = portSTUN
 
-	log.Info().Caller().Msgf("DERP region: %+v", localDERPregion)
-	log.Info().Caller().Msgf("DERP Nodes[0]: %+v", localDERPregion.Nodes[0])
+	// In STUN-only mode the embedded server does not relay traffic,
+	// clients will only use the region to discover their public endpoints.
+	localDERPregion.Nodes[0].STUNOnly = d.cfg.STUNOnly
+
+	derpLog.Info().Caller().Msgf("DERP region: %+v", localDERPregion)
+	derpLog.Info().Caller().Msgf("DERP Nodes[0]: %+v", localDERPregion.Nodes[0])
 
 	return localDERPregion, nil
 }
@@ -110,12 +142,12 @@ func (d *DERPServer) DERPHandler(
 	writer http.ResponseWriter,
 	req *http.Request,
 ) {
-	log.Trace().Caller().Msgf("/derp request from %v", req.RemoteAddr)
+	derpLog.Trace().Caller().Msgf("/derp request from %v", req.RemoteAddr)
 	upgrade := strings.ToLower(req.Header.Get("Upgrade"))
 
 	if upgrade != "websocket" && upgrade != "derp" {
 		if upgrade != "" {
-			log.Warn().
+			derpLog.Warn().
 				Caller().
 				Msg("No Upgrade header in DERP server request. If headscale is behind a reverse proxy, make sure it is configured to pass WebSockets through.")
 		}
@@ -123,7 +155,7 @@ func (d *DERPServer) DERPHandler(
 		writer.WriteHeader(http.StatusUpgradeRequired)
 		_, err := writer.Write([]byte("DERP requires connection upgrade"))
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Caller().
 				Err(err).
 				Msg("Failed to write response")
@@ -136,12 +168,12 @@ func (d *DERPServer) DERPHandler(
 
 	hijacker, ok := writer.(http.Hijacker)
 	if !ok {
-		log.Error().Caller().Msg("DERP requires Hijacker interface from Gin")
+		derpLog.Error().Caller().Msg("DERP requires Hijacker interface from Gin")
 		writer.Header().Set("Content-Type", "text/plain")
 		writer.WriteHeader(http.StatusInternalServerError)
 		_, err := writer.Write([]byte("HTTP does not support general TCP support"))
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Caller().
 				Err(err).
 				Msg("Failed to write response")
@@ -152,12 +184,12 @@ func (d *DERPServer) DERPHandler(
 
 	netConn, conn, err := hijacker.Hijack()
 	if err != nil {
-		log.Error().Caller().Err(err).Msgf("Hijack failed")
+		derpLog.Error().Caller().Err(err).Msgf("Hijack failed")
 		writer.Header().Set("Content-Type", "text/plain")
 		writer.WriteHeader(http.StatusInternalServerError)
 		_, err = writer.Write([]byte("HTTP does not support general TCP support"))
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Caller().
 				Err(err).
 				Msg("Failed to write response")
@@ -165,7 +197,7 @@ func (d *DERPServer) DERPHandler(
 
 		return
 	}
-	log.Trace().Caller().Msgf("Hijacked connection from %v", req.RemoteAddr)
+	derpLog.Trace().Caller().Msgf("Hijacked connection from %v", req.RemoteAddr)
 
 	if !fastStart {
 		pubKey := d.key.Public()
@@ -179,7 +211,48 @@ func (d *DERPServer) DERPHandler(
 			string(pubKeyStr))
 	}
 
-	d.tailscaleDERP.Accept(req.Context(), netConn, conn, netConn.RemoteAddr().String())
+	// Flush the upgrade response, the rest of the connection is
+	// written through the usage accounting connection.
+	if err := conn.Flush(); err != nil {
+		derpLog.Error().Caller().Err(err).Msg("Failed to write DERP upgrade response")
+		netConn.Close()
+
+		return
+	}
+
+	usageConn := newUsageConn(netConn, conn.Reader, d.usage, d.rateLimitFn, d.banFn)
+
+	d.tailscaleDERP.Accept(req.Context(), usageConn, usageConn.readWriter(), netConn.RemoteAddr().String())
+}
+
+// SetRateLimitFunc sets the function used to look up the rate limit of
+// new DERP connections. It must be called before the server is serving.
+func (d *DERPServer) SetRateLimitFunc(fn RateLimitFunc) {
+	d.rateLimitFn = fn
+}
+
+// SetBanFunc sets the function used to look up whether the node key of
+// a new DERP connection is banned. It must be called before the server
+// is serving.
+func (d *DERPServer) SetBanFunc(fn BanFunc) {
+	d.banFn = fn
+}
+
+// DisconnectNodeKey closes the open DERP connections of the node key
+// and returns how many there were.
+func (d *DERPServer) DisconnectNodeKey(nodeKey key.NodePublic) int {
+	return d.usage.closeConns(nodeKey)
+}
+
+// Clients returns the clients connected to the embedded DERP server.
+func (d *DERPServer) Clients() []Client {
+	return d.usage.clients()
+}
+
+// NodeUsage returns the traffic the embedded DERP server has relayed
+// for the given node key, see NodeUsage for how long it is kept.
+func (d *DERPServer) NodeUsage(nodeKey key.NodePublic) NodeUsage {
+	return d.usage.lookup(nodeKey)
 }
 
 // DERPProbeHandler is the endpoint that js/wasm clients hit to measure
@@ -196,7 +269,7 @@ func DERPProbeHandler(
 		writer.WriteHeader(http.StatusMethodNotAllowed)
 		_, err := writer.Write([]byte("bogus probe method"))
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Caller().
 				Err(err).
 				Msg("Failed to write response")
@@ -228,7 +301,7 @@ func DERPBootstrapDNSHandler(
 			for _, node := range region.Nodes { // we don't care if we override some nodes
 				addrs, err := resolver.LookupIP(resolvCtx, "ip", node.HostName)
 				if err != nil {
-					log.Trace().
+					derpLog.Trace().
 						Caller().
 						Err(err).
 						Msgf("bootstrap DNS lookup failed %q", node.HostName)
@@ -242,7 +315,7 @@ func DERPBootstrapDNSHandler(
 		writer.WriteHeader(http.StatusOK)
 		err := json.NewEncoder(writer).Encode(dnsEntries)
 		if err != nil {
-			log.Error().
+			derpLog.Error().
 				Caller().
 				Err(err).
 				Msg("Failed to write response")
@@ -252,15 +325,20 @@ func DERPBootstrapDNSHandler(
 
 // ServeSTUN starts a STUN server on the configured addr.
 func (d *DERPServer) ServeSTUN() {
-	packetConn, err := net.ListenPacket("udp", d.cfg.STUNAddr)
+	d.ServeSTUNOn("udp", d.cfg.STUNAddr)
+}
+
+// ServeSTUNOn starts a STUN server on the given network and addr.
+func (d *DERPServer) ServeSTUNOn(network string, addr string) {
+	packetConn, err := net.ListenPacket(network, addr)
 	if err != nil {
-		log.Fatal().Msgf("failed to open STUN listener: %v", err)
+		derpLog.Fatal().Msgf("failed to open STUN listener: %v", err)
 	}
-	log.Info().Msgf("STUN server started at %s", packetConn.LocalAddr())
+	derpLog.Info().Msgf("STUN server started at %s", packetConn.LocalAddr())
 
 	udpConn, ok := packetConn.(*net.UDPConn)
 	if !ok {
-		log.Fatal().Msg("STUN listener is not a UDP listener")
+		derpLog.Fatal().Msg("STUN listener is not a UDP listener")
 	}
 	serverSTUNListener(context.Background(), udpConn)
 }
@@ -278,21 +356,21 @@ func serverSTUNListener(ctx context.Context, packetConn *net.UDPConn) {
 			if ctx.Err() != nil {
 				return
 			}
-			log.Error().Caller().Err(err).Msgf("STUN ReadFrom")
+			derpLog.Error().Caller().Err(err).Msgf("STUN ReadFrom")
 			time.Sleep(time.Second)
 
 			continue
 		}
-		log.Trace().Caller().Msgf("STUN request from %v", udpAddr)
+		derpLog.Trace().Caller().Msgf("STUN request from %v", udpAddr)
 		pkt := buf[:bytesRead]
 		if !stun.Is(pkt) {
-			log.Trace().Caller().Msgf("UDP packet is not STUN")
+			derpLog.Trace().Caller().Msgf("UDP packet is not STUN")
 
 			continue
 		}
 		txid, err := stun.ParseBindingRequest(pkt)
 		if err != nil {
-			log.Trace().Caller().Err(err).Msgf("STUN parse error")
+			derpLog.Trace().Caller().Err(err).Msgf("STUN parse error")
 
 			continue
 		}
@@ -301,7 +379,7 @@ func serverSTUNListener(ctx context.Context, packetConn *net.UDPConn) {
 		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(udpAddr.Port)))
 		_, err = packetConn.WriteTo(res, udpAddr)
 		if err != nil {
-			log.Trace().Caller().Err(err).Msgf("Issue writing to UDP")
+			derpLog.Trace().Caller().Err(err).Msgf("Issue writing to UDP")
 
 			continue
 		}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestGenerateRegion(t *testing.T) {
+	tests := []struct {
+		name     string
+		stunOnly bool
+	}{
+		{
+			name:     "derp-and-stun",
+			stunOnly: false,
+		},
+		{
+			name:     "stun-only",
+			stunOnly: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := NewDERPServer(
+				"https://headscale.example.com",
+				key.NewNode(),
+				&types.DERPConfig{
+					ServerRegionID:   999,
+					ServerRegionCode: "headscale",
+					STUNAddr:         "0.0.0.0:3478",
+					STUNOnly:         tt.stunOnly,
+				},
+			)
+			if err != nil {
+				t.Fatalf("creating DERP server: %s", err)
+			}
+
+			region, err := server.GenerateRegion()
+			if err != nil {
+				t.Fatalf("generating region: %s", err)
+			}
+
+			if len(region.Nodes) != 1 {
+				t.Fatalf("expected one node, got %d", len(region.Nodes))
+			}
+
+			node := region.Nodes[0]
+			if node.HostName != "headscale.example.com" || node.DERPPort != 443 {
+				t.Errorf("unexpected DERP node address %s:%d", node.HostName, node.DERPPort)
+			}
+			if node.STUNPort != 3478 {
+				t.Errorf("STUNPort = %d, want 3478", node.STUNPort)
+			}
+			if node.STUNOnly != tt.stunOnly {
+				t.Errorf("STUNOnly = %t, want %t", node.STUNOnly, tt.stunOnly)
+			}
+		})
+	}
+}
+
+func TestGenerateRegionOverrides(t *testing.T) {
+	server, err := NewDERPServer(
+		"https://headscale.example.com",
+		key.NewNode(),
+		&types.DERPConfig{
+			ServerRegionID:   999,
+			ServerRegionCode: "fra",
+			ServerRegionName: "Frankfurt",
+			ServerNodeName:   "999a",
+			ServerHostname:   "derp.example.com",
+			ServerDERPPort:   8443,
+			ServerSTUNPort:   3479,
+			STUNAddr:         "0.0.0.0:3478",
+			IPv4:             "203.0.113.1",
+			IPv6:             "none",
+			CanPort80:        true,
+			Latitude:         50.11,
+			Longitude:        8.68,
+		},
+	)
+	if err != nil {
+		t.Fatalf("creating DERP server: %s", err)
+	}
+
+	region, err := server.GenerateRegion()
+	if err != nil {
+		t.Fatalf("generating region: %s", err)
+	}
+
+	want := tailcfg.DERPRegion{
+		RegionID:   999,
+		RegionCode: "fra",
+		RegionName: "Frankfurt",
+		Latitude:   50.11,
+		Longitude:  8.68,
+		Nodes: []*tailcfg.DERPNode{
+			{
+				Name:      "999a",
+				RegionID:  999,
+				HostName:  "derp.example.com",
+				DERPPort:  8443,
+				STUNPort:  3479,
+				IPv4:      "203.0.113.1",
+				IPv6:      "none",
+				CanPort80: true,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, region); diff != "" {
+		t.Errorf("GenerateRegion() mismatch (-want +got):\n%s", diff)
+	}
+}
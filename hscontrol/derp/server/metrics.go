@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"expvar"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"tailscale.com/metrics"
+)
+
+var (
+	derpClientsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "derp", "clients"),
+		"clients connected to the embedded DERP server, remote clients are connected to a meshed peer",
+		[]string{"region", "location"}, nil,
+	)
+	derpConnectionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "derp", "connections"),
+		"open connections to the embedded DERP server",
+		[]string{"region"}, nil,
+	)
+	derpAcceptsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "derp", "accepts_total"),
+		"total connections accepted by the embedded DERP server",
+		[]string{"region"}, nil,
+	)
+	derpPacketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "derp", "packets_total"),
+		"total packets relayed by the embedded DERP server, forwarded packets go through a meshed peer",
+		[]string{"region", "direction"}, nil,
+	)
+	derpBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(prometheusNamespace, "derp", "bytes_total"),
+		"total bytes relayed by the embedded DERP server",
+		[]string{"region", "direction"}, nil,
+	)
+)
+
+// derpCollector exports the counters of the embedded DERP server as
+// Prometheus metrics, labelled with its region.
+type derpCollector struct {
+	server atomic.Pointer[DERPServer]
+}
+
+// registerCollector exports the metrics of the DERP server. Only one
+// DERP server runs at a time, a new server replaces the previous one.
+func registerCollector(server *DERPServer) {
+	collector := &derpCollector{}
+	collector.server.Store(server)
+
+	err := prometheus.Register(collector)
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(*derpCollector); ok {
+			existing.server.Store(server)
+		}
+	}
+}
+
+func (c *derpCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- derpClientsDesc
+	ch <- derpConnectionsDesc
+	ch <- derpAcceptsDesc
+	ch <- derpPacketsDesc
+	ch <- derpBytesDesc
+}
+
+func (c *derpCollector) Collect(ch chan<- prometheus.Metric) {
+	server := c.server.Load()
+	if server == nil {
+		return
+	}
+
+	vars, ok := server.tailscaleDERP.ExpVar().(*metrics.Set)
+	if !ok {
+		return
+	}
+	region := strconv.Itoa(server.cfg.ServerRegionID)
+	value := func(name string) float64 {
+		return expvarFloat(vars.Get(name))
+	}
+
+	ch <- prometheus.MustNewConstMetric(derpClientsDesc, prometheus.GaugeValue, value("gauge_clients_local"), region, "local")
+	ch <- prometheus.MustNewConstMetric(derpClientsDesc, prometheus.GaugeValue, value("gauge_clients_remote"), region, "remote")
+	ch <- prometheus.MustNewConstMetric(derpConnectionsDesc, prometheus.GaugeValue, value("gauge_current_connections"), region)
+	ch <- prometheus.MustNewConstMetric(derpAcceptsDesc, prometheus.CounterValue, value("accepts"), region)
+
+	for direction, name := range map[string]string{
+		"received":      "packets_received",
+		"sent":          "packets_sent",
+		"dropped":       "packets_dropped",
+		"forwarded_in":  "packets_forwarded_in",
+		"forwarded_out": "packets_forwarded_out",
+	} {
+		ch <- prometheus.MustNewConstMetric(derpPacketsDesc, prometheus.CounterValue, value(name), region, direction)
+	}
+
+	ch <- prometheus.MustNewConstMetric(derpBytesDesc, prometheus.CounterValue, value("bytes_received"), region, "received")
+	ch <- prometheus.MustNewConstMetric(derpBytesDesc, prometheus.CounterValue, value("bytes_sent"), region, "sent")
+}
+
+// expvarFloat returns the value of an integer expvar of the DERP
+// server, 0 for other vars.
+func expvarFloat(v expvar.Var) float64 {
+	switch v := v.(type) {
+	case *expvar.Int:
+		return float64(v.Value())
+	case expvar.Func:
+		switch n := v.Value().(type) {
+		case int:
+			return float64(n)
+		case int64:
+			return float64(n)
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,472 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+	"tailscale.com/types/key"
+)
+
+const (
+	prometheusNamespace = "headscale"
+
+	// derpFrameClientInfo is the type of the first frame a DERP client
+	// sends, it starts with the 32 byte public node key of the client.
+	derpFrameClientInfo = 0x02
+	derpFrameHeaderLen  = 1 + 4
+	derpKeyLen          = 32
+
+	// usageRetention is how long the usage of a node key is kept after
+	// its last connection closed.
+	usageRetention = time.Hour
+)
+
+var (
+	errClientBanned       = errors.New("DERP client is banned")
+	errRateLimitExhausted = errors.New("DERP rate limit allows no traffic")
+)
+
+// derpClientBytes is not broken down by node key, the clients of the
+// embedded DERP server are not verified and can present any key. The
+// usage of each node is available with NodeUsage instead.
+var derpClientBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: prometheusNamespace,
+	Name:      "derp_client_bytes_total",
+	Help:      "total bytes relayed by the embedded DERP server, rx is received from the nodes, tx is sent to the nodes",
+}, []string{"direction"})
+
+var (
+	derpClientRxBytes = derpClientBytes.WithLabelValues("rx")
+	derpClientTxBytes = derpClientBytes.WithLabelValues("tx")
+)
+
+// NodeUsage is the amount of traffic the embedded DERP server has
+// exchanged with a node key since headscale started. It is forgotten
+// an hour after the last connection of the node key closed.
+type NodeUsage struct {
+	RxBytes uint64
+	TxBytes uint64
+
+	// LastActive is when the node last sent data to the DERP server,
+	// it is zero if it has not connected since headscale started.
+	LastActive time.Time
+}
+
+type nodeUsage struct {
+	rx atomic.Uint64
+	tx atomic.Uint64
+
+	// lastActive is the UnixNano time of the last received data.
+	lastActive atomic.Int64
+
+	// idleSince is when the last connection of the node key closed,
+	// it is zero while it is connected. It is guarded by the mutex of
+	// the usageTracker.
+	idleSince time.Time
+}
+
+func (u *nodeUsage) addRx(n int) {
+	u.rx.Add(uint64(n))
+	derpClientRxBytes.Add(float64(n))
+	u.lastActive.Store(time.Now().UnixNano())
+}
+
+func (u *nodeUsage) addTx(n int) {
+	u.tx.Add(uint64(n))
+	derpClientTxBytes.Add(float64(n))
+}
+
+// usageTracker keeps the traffic counters of the node keys that are
+// connected to the embedded DERP server, or were within usageRetention,
+// and their open connections.
+type usageTracker struct {
+	mu    sync.Mutex
+	usage map[key.NodePublic]*nodeUsage
+	conns map[key.NodePublic]map[*usageConn]struct{}
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{
+		usage: make(map[key.NodePublic]*nodeUsage),
+		conns: make(map[key.NodePublic]map[*usageConn]struct{}),
+	}
+}
+
+func (t *usageTracker) addConn(nodeKey key.NodePublic, conn *usageConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.conns[nodeKey] == nil {
+		t.conns[nodeKey] = make(map[*usageConn]struct{})
+	}
+	t.conns[nodeKey][conn] = struct{}{}
+
+	if u, ok := t.usage[nodeKey]; ok {
+		u.idleSince = time.Time{}
+	}
+}
+
+func (t *usageTracker) removeConn(nodeKey key.NodePublic, conn *usageConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.conns[nodeKey], conn)
+	if len(t.conns[nodeKey]) == 0 {
+		delete(t.conns, nodeKey)
+
+		if u, ok := t.usage[nodeKey]; ok {
+			u.idleSince = time.Now()
+		}
+	}
+}
+
+// closeConns closes the open connections of the node key and returns
+// how many there were.
+func (t *usageTracker) closeConns(nodeKey key.NodePublic) int {
+	t.mu.Lock()
+	conns := make([]*usageConn, 0, len(t.conns[nodeKey]))
+	for conn := range t.conns[nodeKey] {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	return len(conns)
+}
+
+// get returns the usage of the node key, forgetting the usage of the
+// node keys that have been idle for longer than usageRetention.
+func (t *usageTracker) get(nodeKey key.NodePublic) *nodeUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(time.Now())
+
+	if u, ok := t.usage[nodeKey]; ok {
+		return u
+	}
+
+	u := &nodeUsage{}
+	t.usage[nodeKey] = u
+
+	return u
+}
+
+// prune forgets the usage of the node keys without open connections
+// whose last connection closed more than usageRetention before now.
+// The caller must hold the mutex.
+func (t *usageTracker) prune(now time.Time) {
+	for nodeKey, u := range t.usage {
+		if !u.idleSince.IsZero() && now.Sub(u.idleSince) > usageRetention {
+			delete(t.usage, nodeKey)
+		}
+	}
+}
+
+func (t *usageTracker) lookup(nodeKey key.NodePublic) NodeUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.usage[nodeKey]
+	if !ok {
+		return NodeUsage{}
+	}
+
+	usage := NodeUsage{
+		RxBytes: u.rx.Load(),
+		TxBytes: u.tx.Load(),
+	}
+	if lastActive := u.lastActive.Load(); lastActive != 0 {
+		usage.LastActive = time.Unix(0, lastActive)
+	}
+
+	return usage
+}
+
+// RateLimitFunc returns the rate limit to apply to the DERP connection
+// of the given node key, or nil if the connection is not limited.
+type RateLimitFunc func(nodeKey key.NodePublic) *types.DERPRateLimit
+
+// BanFunc returns whether the node key is banned from the DERP server.
+type BanFunc func(nodeKey key.NodePublic) bool
+
+// Client is a client connected to the embedded DERP server.
+type Client struct {
+	NodeKey     key.NodePublic
+	RemoteAddr  string
+	ConnectedAt time.Time
+
+	// RxBytes and TxBytes are the bytes received from and sent to the
+	// client over this connection.
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// clients returns the clients connected to the DERP server, the oldest
+// connection first.
+func (t *usageTracker) clients() []Client {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var clients []Client
+	for nodeKey, conns := range t.conns {
+		for conn := range conns {
+			state := conn.state.Load()
+			clients = append(clients, Client{
+				NodeKey:     nodeKey,
+				RemoteAddr:  conn.RemoteAddr().String(),
+				ConnectedAt: state.connectedAt,
+				RxBytes:     state.rx.Load(),
+				TxBytes:     state.tx.Load(),
+			})
+		}
+	}
+
+	slices.SortFunc(clients, func(a, b Client) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+
+	return clients
+}
+
+// connState is the accounting and throttling state of a DERP
+// connection once the client has identified itself.
+type connState struct {
+	nodeKey     key.NodePublic
+	usage       *nodeUsage
+	connectedAt time.Time
+
+	// rx and tx are the bytes of this connection, usage counts all the
+	// connections of the node key.
+	rx atomic.Uint64
+	tx atomic.Uint64
+
+	// rxLimit and txLimit are nil if the connection is not rate
+	// limited.
+	rxLimit *rate.Limiter
+	txLimit *rate.Limiter
+}
+
+// usageConn wraps a hijacked DERP connection and accounts and throttles
+// the traffic of the node key the client presents in its first frame,
+// and closes the connection if the node key is banned. Traffic
+// exchanged before the client has identified itself is neither
+// accounted nor throttled.
+type usageConn struct {
+	net.Conn
+
+	r       io.Reader
+	tracker *usageTracker
+	limitFn RateLimitFunc
+	banFn   BanFunc
+
+	// hdr holds the start of the client stream until the
+	// client key has been read. It is only used by Read.
+	hdr    []byte
+	state  atomic.Pointer[connState]
+	closed atomic.Bool
+}
+
+func newUsageConn(
+	conn net.Conn,
+	r io.Reader,
+	tracker *usageTracker,
+	limitFn RateLimitFunc,
+	banFn BanFunc,
+) *usageConn {
+	return &usageConn{
+		Conn:    conn,
+		r:       r,
+		tracker: tracker,
+		limitFn: limitFn,
+		banFn:   banFn,
+		hdr:     make([]byte, 0, derpFrameHeaderLen+derpKeyLen),
+	}
+}
+
+// readWriter returns a bufio.ReadWriter reading and writing through
+// the accounting connection.
+func (c *usageConn) readWriter() *bufio.ReadWriter {
+	return bufio.NewReadWriter(bufio.NewReader(c), bufio.NewWriter(c))
+}
+
+func (c *usageConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if n > 0 {
+		if state := c.state.Load(); state != nil {
+			state.usage.addRx(n)
+			state.rx.Add(uint64(n))
+			if terr := throttle(state.rxLimit, n); terr != nil {
+				return n, terr
+			}
+		} else if banned := c.inspect(b[:n]); banned {
+			c.Close()
+
+			return 0, errClientBanned
+		}
+	}
+
+	return n, err
+}
+
+func (c *usageConn) Close() error {
+	if state := c.state.Load(); state != nil && !c.closed.Swap(true) {
+		c.tracker.removeConn(state.nodeKey, c)
+
+		derpLog.Info().
+			Str("node_key", state.nodeKey.ShortString()).
+			Str("remote_addr", c.RemoteAddr().String()).
+			Dur("duration", time.Since(state.connectedAt)).
+			Uint64("rx_bytes", state.rx.Load()).
+			Uint64("tx_bytes", state.tx.Load()).
+			Msg("DERP client disconnected")
+	}
+
+	return c.Conn.Close()
+}
+
+func (c *usageConn) Write(b []byte) (int, error) {
+	state := c.state.Load()
+	if state != nil {
+		if err := throttle(state.txLimit, len(b)); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := c.Conn.Write(b)
+	if n > 0 && state != nil {
+		state.usage.addTx(n)
+		state.tx.Add(uint64(n))
+	}
+
+	return n, err
+}
+
+// inspect collects the start of the client stream and looks up the
+// usage counters of the node once the client key has been received. It
+// returns true if the node key is banned.
+func (c *usageConn) inspect(b []byte) bool {
+	if c.hdr == nil {
+		return false
+	}
+
+	missing := cap(c.hdr) - len(c.hdr)
+	if len(b) < missing {
+		c.hdr = append(c.hdr, b...)
+
+		return false
+	}
+	c.hdr = append(c.hdr, b[:missing]...)
+
+	nodeKey, ok := parseClientKey(c.hdr)
+	c.hdr = nil
+	if !ok {
+		return false
+	}
+
+	if c.banFn != nil && c.banFn(nodeKey) {
+		derpLog.Info().
+			Str("node_key", nodeKey.ShortString()).
+			Str("remote_addr", c.RemoteAddr().String()).
+			Msg("Refusing banned DERP client")
+
+		return true
+	}
+
+	state := &connState{
+		nodeKey:     nodeKey,
+		usage:       c.tracker.get(nodeKey),
+		connectedAt: time.Now(),
+	}
+	if c.limitFn != nil {
+		if limit := c.limitFn(nodeKey); limit != nil {
+			state.rxLimit, state.txLimit = newLimiter(limit), newLimiter(limit)
+		}
+	}
+
+	state.usage.addRx(len(b) - missing)
+	state.rx.Add(uint64(len(b) - missing))
+	c.state.Store(state)
+	c.tracker.addConn(nodeKey, c)
+
+	derpLog.Info().
+		Str("node_key", nodeKey.ShortString()).
+		Str("remote_addr", c.RemoteAddr().String()).
+		Bool("rate_limited", state.rxLimit != nil).
+		Msg("DERP client connected")
+
+	return false
+}
+
+// newLimiter returns a limiter allowing the configured bits per second,
+// bursting up to the configured bytes, or one second of traffic if the
+// burst is not set.
+func newLimiter(limit *types.DERPRateLimit) *rate.Limiter {
+	bytesPerSecond := limit.BitsPerSecond / 8
+	burst := limit.BurstBytes
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), max(burst, 1))
+}
+
+// throttle blocks until the limiter allows n bytes, it is a no-op if
+// the limiter is nil.
+func throttle(limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+
+	for n > 0 {
+		chunk := min(n, limiter.Burst())
+		if chunk <= 0 {
+			return errRateLimitExhausted
+		}
+		if err := limiter.WaitN(context.Background(), chunk); err != nil {
+			return fmt.Errorf("throttling DERP traffic: %w", err)
+		}
+		n -= chunk
+	}
+
+	return nil
+}
+
+// parseClientKey extracts the node key from the header of a DERP
+// ClientInfo frame.
+func parseClientKey(hdr []byte) (key.NodePublic, bool) {
+	if len(hdr) < derpFrameHeaderLen+derpKeyLen || hdr[0] != derpFrameClientInfo {
+		return key.NodePublic{}, false
+	}
+
+	if binary.BigEndian.Uint32(hdr[1:derpFrameHeaderLen]) < derpKeyLen {
+		return key.NodePublic{}, false
+	}
+
+	var nodeKey key.NodePublic
+	err := nodeKey.ReadRawWithoutAllocating(
+		bufio.NewReader(bytes.NewReader(hdr[derpFrameHeaderLen:])),
+	)
+	if err != nil {
+		return key.NodePublic{}, false
+	}
+
+	return nodeKey, true
+}
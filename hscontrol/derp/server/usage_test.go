@@ -0,0 +1,258 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"tailscale.com/types/key"
+)
+
+func clientInfoFrame(nodeKey key.NodePublic, payload int) []byte {
+	raw := nodeKey.AppendTo(nil)
+
+	frame := []byte{derpFrameClientInfo}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(raw)+payload))
+	frame = append(frame, raw...)
+
+	return append(frame, make([]byte, payload)...)
+}
+
+func TestUsageConnAccounting(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+
+	tests := []struct {
+		name    string
+		stream  []byte
+		chunk   int
+		wantKey bool
+		wantRx  uint64
+	}{
+		{
+			name:    "client-info-single-read",
+			stream:  clientInfoFrame(nodeKey, 100),
+			chunk:   1024,
+			wantKey: true,
+			wantRx:  100,
+		},
+		{
+			name:    "client-info-split-reads",
+			stream:  clientInfoFrame(nodeKey, 100),
+			chunk:   3,
+			wantKey: true,
+			wantRx:  100,
+		},
+		{
+			name:    "not-client-info",
+			stream:  append([]byte{0x04}, clientInfoFrame(nodeKey, 10)[1:]...),
+			chunk:   1024,
+			wantKey: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newUsageTracker()
+			server, client := net.Pipe()
+			defer server.Close()
+			defer client.Close()
+
+			conn := newUsageConn(server, bytes.NewReader(tt.stream), tracker, nil, nil)
+
+			buf := make([]byte, tt.chunk)
+			for {
+				_, err := conn.Read(buf)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("read: %s", err)
+				}
+			}
+
+			go io.Copy(io.Discard, client) //nolint
+			if _, err := conn.Write(make([]byte, 42)); err != nil {
+				t.Fatalf("write: %s", err)
+			}
+
+			got := tracker.lookup(nodeKey)
+			if !tt.wantKey {
+				if got != (NodeUsage{}) {
+					t.Errorf("expected no usage, got %+v", got)
+				}
+
+				return
+			}
+
+			if got.RxBytes != tt.wantRx {
+				t.Errorf("RxBytes = %d, want %d", got.RxBytes, tt.wantRx)
+			}
+			if got.TxBytes != 42 {
+				t.Errorf("TxBytes = %d, want %d", got.TxBytes, 42)
+			}
+			if got.LastActive.IsZero() {
+				t.Errorf("LastActive is not set")
+			}
+		})
+	}
+}
+
+func TestUsageConnBan(t *testing.T) {
+	banned := key.NewNode().Public()
+	allowed := key.NewNode().Public()
+	banFn := func(nodeKey key.NodePublic) bool { return nodeKey == banned }
+
+	tracker := newUsageTracker()
+	read := func(nodeKey key.NodePublic) (*usageConn, error) {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+
+		conn := newUsageConn(server, bytes.NewReader(clientInfoFrame(nodeKey, 10)), tracker, nil, banFn)
+		_, err := conn.Read(make([]byte, 1024))
+
+		return conn, err
+	}
+
+	if _, err := read(banned); !errors.Is(err, errClientBanned) {
+		t.Errorf("read from a banned client error = %v, want %v", err, errClientBanned)
+	}
+
+	conn, err := read(allowed)
+	if err != nil {
+		t.Fatalf("read from an allowed client: %s", err)
+	}
+
+	// Open connections are closed when the node key gets banned.
+	if got := tracker.closeConns(allowed); got != 1 {
+		t.Errorf("closeConns() = %d, want 1", got)
+	}
+	if _, err := conn.Write([]byte{0}); err == nil {
+		t.Errorf("write after closeConns() succeeded, want the connection closed")
+	}
+	if got := tracker.closeConns(allowed); got != 0 {
+		t.Errorf("closeConns() after close = %d, want 0", got)
+	}
+}
+
+func TestUsageConnClients(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+	tracker := newUsageTracker()
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	conn := newUsageConn(server, bytes.NewReader(clientInfoFrame(nodeKey, 10)), tracker, nil, nil)
+	if _, err := conn.Read(make([]byte, 1024)); err != nil {
+		t.Fatalf("read: %s", err)
+	}
+
+	clients := tracker.clients()
+	if len(clients) != 1 || clients[0].NodeKey != nodeKey || clients[0].RxBytes != 10 || clients[0].ConnectedAt.IsZero() {
+		t.Fatalf("clients() = %+v, want the connected client", clients)
+	}
+
+	conn.Close()
+	if clients := tracker.clients(); len(clients) != 0 {
+		t.Errorf("clients() after close = %+v, want none", clients)
+	}
+
+	// Closing twice does not log or untrack twice.
+	conn.Close()
+}
+
+func TestUsageTrackerPrune(t *testing.T) {
+	connected := key.NewNode().Public()
+	idle := key.NewNode().Public()
+	tracker := newUsageTracker()
+
+	for _, nodeKey := range []key.NodePublic{connected, idle} {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+
+		conn := newUsageConn(server, bytes.NewReader(clientInfoFrame(nodeKey, 10)), tracker, nil, nil)
+		if _, err := conn.Read(make([]byte, 1024)); err != nil {
+			t.Fatalf("read: %s", err)
+		}
+		if nodeKey == idle {
+			conn.Close()
+		}
+	}
+
+	tracker.mu.Lock()
+	tracker.prune(time.Now())
+	tracker.mu.Unlock()
+	if tracker.lookup(idle).RxBytes != 10 {
+		t.Errorf("usage of a node key that just disconnected was forgotten")
+	}
+
+	tracker.mu.Lock()
+	tracker.prune(time.Now().Add(usageRetention + time.Minute))
+	tracker.mu.Unlock()
+	if got := tracker.lookup(idle); got != (NodeUsage{}) {
+		t.Errorf("usage of an idle node key = %+v, want it forgotten", got)
+	}
+	if tracker.lookup(connected).RxBytes != 10 {
+		t.Errorf("usage of a connected node key was forgotten")
+	}
+}
+
+func TestThrottleError(t *testing.T) {
+	if err := throttle(nil, 10); err != nil {
+		t.Errorf("throttle() without a limiter error = %s", err)
+	}
+
+	// A limiter that can never refill must fail rather than let the
+	// traffic through unlimited.
+	if err := throttle(rate.NewLimiter(0, 1), 2); err == nil {
+		t.Error("throttle() with a zero rate passed")
+	}
+}
+
+func TestDERPCollector(t *testing.T) {
+	d, err := NewDERPServer("https://headscale.example.com", key.NewNode(), &types.DERPConfig{ServerRegionID: 999})
+	if err != nil {
+		t.Fatalf("NewDERPServer() error = %s", err)
+	}
+
+	collector := &derpCollector{}
+	collector.server.Store(d)
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %s", err)
+	}
+
+	got := make(map[string]int)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			got[family.GetName()]++
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "region" && label.GetValue() != "999" {
+					t.Errorf("%s region = %q, want 999", family.GetName(), label.GetValue())
+				}
+			}
+		}
+	}
+
+	want := map[string]int{
+		"headscale_derp_clients":       2,
+		"headscale_derp_connections":   1,
+		"headscale_derp_accepts_total": 1,
+		"headscale_derp_packets_total": 5,
+		"headscale_derp_bytes_total":   2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("collected metrics mismatch (-want +got):\n%s", diff)
+	}
+}
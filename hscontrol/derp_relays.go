@@ -0,0 +1,107 @@
+package hscontrol
+
+import (
+	"errors"
+	"reflect"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/tailcfg"
+)
+
+var errDERPRelayRegionInUse = errors.New("DERP region ID is used by a configured region")
+
+// addDERPRelays adds the regions of the online DERP relays to the
+// DERPMap. The configured regions and the embedded DERP server take
+// precedence over a relay registered with the same region ID.
+func (h *Headscale) addDERPRelays(derpMap *tailcfg.DERPMap) {
+	if !h.cfg.DERP.RelaysEnabled {
+		return
+	}
+
+	relays, err := h.db.ListDERPRelays()
+	if err != nil {
+		log.Error().Err(err).Msg("Cannot list DERP relays, leaving them out of the DERPMap")
+
+		return
+	}
+
+	for _, relay := range relays {
+		if !relay.Online(h.cfg.DERP.RelayExpiry) {
+			continue
+		}
+
+		if _, ok := derpMap.Regions[relay.RegionID]; ok {
+			log.Warn().
+				Int("region_id", relay.RegionID).
+				Str("hostname", relay.Hostname).
+				Msg("DERP relay uses the region ID of a configured region, leaving it out of the DERPMap")
+
+			continue
+		}
+
+		region := relay.Region()
+		derpMap.Regions[relay.RegionID] = &region
+	}
+}
+
+// checkDERPRelayRegion returns an error if the region ID of a relay
+// registering for the first time is already used in the DERPMap.
+func (h *Headscale) checkDERPRelayRegion(regionID int) error {
+	if _, err := h.db.GetDERPRelay(regionID); err == nil {
+		return nil
+	}
+
+	if _, ok := h.derpMap.Load().Regions[regionID]; ok {
+		return errDERPRelayRegionInUse
+	}
+
+	return nil
+}
+
+// derpRelayServed reports if the DERPMap currently serves the region of
+// the relay as registered.
+func (h *Headscale) derpRelayServed(relay types.DERPRelay) bool {
+	served, ok := h.derpMap.Load().Regions[relay.RegionID]
+	if !ok {
+		return false
+	}
+
+	return reflect.DeepEqual(*served, relay.Region())
+}
+
+// derpRelayExpiryWorker removes the relays that stopped sending
+// heartbeats from the DERPMap.
+func (h *Headscale) derpRelayExpiryWorker(cancelChan <-chan struct{}) {
+	ticker := time.NewTicker(h.cfg.DERP.RelayExpiry / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-cancelChan:
+			return
+
+		case <-ticker.C:
+			relays, err := h.db.ListDERPRelays()
+			if err != nil {
+				log.Error().Err(err).Msg("Cannot list DERP relays to expire")
+
+				continue
+			}
+
+			for _, relay := range relays {
+				if !relay.Online(h.cfg.DERP.RelayExpiry) && h.derpRelayServed(relay) {
+					log.Info().
+						Int("region_id", relay.RegionID).
+						Str("hostname", relay.Hostname).
+						Time("last_seen", relay.LastSeen).
+						Msg("DERP relay missed its heartbeats, removing it from the DERPMap")
+					h.updateDERPMap("derp-relay-expired")
+
+					break
+				}
+			}
+		}
+	}
+}
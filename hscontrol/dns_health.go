@@ -0,0 +1,317 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/dnstype"
+)
+
+const dnsMessageMaxSize = 512
+
+var errDNSProbeAnswer = errors.New("nameserver did not answer the query")
+
+// dnsHealth tracks which nameservers of the DNS configuration answer.
+// The clients only get the nameservers that answer, a domain whose
+// nameservers all fail keeps all of them.
+type dnsHealth struct {
+	mu sync.Mutex
+
+	// configured is the DNS configuration before the nameservers that
+	// are down were left out, applied is the one sent to the clients.
+	// A reload replaces applied with the new configuration.
+	configured *tailcfg.DNSConfig
+	applied    *tailcfg.DNSConfig
+	down       map[string]bool
+
+	// probe sends a query for domain to the nameserver at addr, it is
+	// probeNameserver unless replaced by tests.
+	probe func(ctx context.Context, addr, domain string) error
+}
+
+func (h *Headscale) checkDNSNameserversEvery(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		h.checkDNSNameservers(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkDNSNameservers probes every nameserver of the DNS configuration
+// and sends the clients a new configuration when one went down or came
+// back.
+func (h *Headscale) checkDNSNameservers(ctx context.Context) {
+	current := h.cfg.Reloadable().DNSConfig
+	if current == nil {
+		return
+	}
+
+	h.dnsHealth.mu.Lock()
+	reloaded := current != h.dnsHealth.applied
+	if reloaded {
+		h.dnsHealth.configured = current
+	}
+	configured := h.dnsHealth.configured
+	probe := h.dnsHealth.probe
+	h.dnsHealth.mu.Unlock()
+
+	if probe == nil {
+		probe = probeNameserver
+	}
+
+	// Global nameservers are asked for the root zone, split DNS
+	// nameservers for their domain.
+	domains := make(map[string]string)
+	for _, resolver := range configured.Resolvers {
+		domains[resolver.Addr] = "."
+	}
+	for domain, resolvers := range configured.Routes {
+		for _, resolver := range resolvers {
+			if _, ok := domains[resolver.Addr]; !ok {
+				domains[resolver.Addr] = domain
+			}
+		}
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		down = make(map[string]bool)
+	)
+	for addr, domain := range domains {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, h.cfg.DNSHealthCheck.Timeout)
+			defer cancel()
+
+			if err := probe(probeCtx, addr, domain); err != nil {
+				log.Debug().Err(err).Str("nameserver", addr).Msg("DNS health check failed")
+
+				mu.Lock()
+				down[addr] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	h.dnsHealth.mu.Lock()
+	changed := !maps.Equal(down, h.dnsHealth.down)
+	if changed {
+		for addr := range down {
+			if !h.dnsHealth.down[addr] {
+				log.Warn().Str("nameserver", addr).Msg("Nameserver is down, leaving it out of the DNS configuration")
+			}
+		}
+		for addr := range h.dnsHealth.down {
+			if !down[addr] {
+				log.Info().Str("nameserver", addr).Msg("Nameserver is up again")
+			}
+		}
+	}
+	h.dnsHealth.down = down
+
+	if !changed && !reloaded {
+		h.dnsHealth.mu.Unlock()
+
+		return
+	}
+
+	// A reload during the checks is picked up by the next ones.
+	h.reloadMu.Lock()
+	reloadable := *h.cfg.Reloadable()
+	if reloadable.DNSConfig != current {
+		h.reloadMu.Unlock()
+		h.dnsHealth.mu.Unlock()
+
+		return
+	}
+	h.dnsHealth.applied = withoutNameservers(configured, down)
+	reloadable.DNSConfig = h.dnsHealth.applied
+	h.cfg.SetReloadable(&reloadable)
+	h.reloadMu.Unlock()
+	h.dnsHealth.mu.Unlock()
+
+	// The clients already have the configuration after a reload if all
+	// the nameservers are up.
+	if !changed && len(down) == 0 {
+		return
+	}
+
+	notifyCtx := types.NotifyCtx(context.Background(), "dns-health", "na")
+	h.nodeNotifier.NotifyAll(notifyCtx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+}
+
+// withoutNameservers returns a copy of cfg without the nameservers in
+// down, unless that leaves the global nameservers or a domain without
+// any.
+func withoutNameservers(cfg *tailcfg.DNSConfig, down map[string]bool) *tailcfg.DNSConfig {
+	filter := func(resolvers []*dnstype.Resolver) []*dnstype.Resolver {
+		var up []*dnstype.Resolver
+		for _, resolver := range resolvers {
+			if !down[resolver.Addr] {
+				up = append(up, resolver)
+			}
+		}
+
+		if len(up) == 0 {
+			return resolvers
+		}
+
+		return up
+	}
+
+	filtered := cfg.Clone()
+	filtered.Resolvers = filter(filtered.Resolvers)
+	for domain, resolvers := range filtered.Routes {
+		filtered.Routes[domain] = filter(resolvers)
+	}
+
+	return filtered
+}
+
+// probeNameserver asks the nameserver at addr, an IP address or the URL
+// of a DNS-over-HTTPS resolver, for the SOA record of domain. Any
+// answer, even an error, counts as the nameserver being up.
+func probeNameserver(ctx context.Context, addr, domain string) error {
+	doh := strings.HasPrefix(addr, "https://")
+
+	// DNS-over-HTTPS queries use the ID 0 to be cacheable.
+	var id uint16
+	if !doh {
+		id = uint16(time.Now().UnixNano())
+	}
+
+	query, err := dnsQuery(id, domain)
+	if err != nil {
+		return err
+	}
+
+	var answer []byte
+	if doh {
+		answer, err = dohExchange(ctx, addr, query)
+	} else {
+		answer, err = udpExchange(ctx, addr, query)
+	}
+	if err != nil {
+		return err
+	}
+
+	var parser dnsmessage.Parser
+	header, err := parser.Start(answer)
+	if err != nil {
+		return fmt.Errorf("parsing answer: %w", err)
+	}
+	if !header.Response || header.ID != id {
+		return errDNSProbeAnswer
+	}
+
+	return nil
+}
+
+func dnsQuery(id uint16, domain string) ([]byte, error) {
+	if !strings.HasSuffix(domain, ".") {
+		domain += "."
+	}
+
+	name, err := dnsmessage.NewName(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(dnsmessage.Question{
+		Name:  name,
+		Type:  dnsmessage.TypeSOA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+
+	return builder.Finish()
+}
+
+func udpExchange(ctx context.Context, addr string, query []byte) ([]byte, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", netip.AddrPortFrom(ip, 53).String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	answer := make([]byte, dnsMessageMaxSize)
+	n, err := conn.Read(answer)
+	if err != nil {
+		return nil, err
+	}
+
+	return answer[:n], nil
+}
+
+func dohExchange(ctx context.Context, url string, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", errDNSProbeAnswer, resp.StatusCode)
+	}
+
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
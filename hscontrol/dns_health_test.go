@@ -0,0 +1,117 @@
+package hscontrol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/dnstype"
+)
+
+func TestCheckDNSNameservers(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{
+		DNSHealthCheck: types.DNSHealthCheckConfig{Interval: time.Minute, Timeout: time.Second},
+		DNSConfig: &tailcfg.DNSConfig{
+			Resolvers: []*dnstype.Resolver{{Addr: "1.1.1.1"}, {Addr: "https://dns.example.com/dns-query"}},
+			Routes: map[string][]*dnstype.Resolver{
+				"corp.example.com":     {{Addr: "10.0.0.1"}, {Addr: "10.0.0.2"}},
+				"lab.example.com":      {{Addr: "10.0.0.3"}},
+				"64.100.in-addr.arpa.": nil,
+			},
+		},
+	})
+
+	var (
+		mu     sync.Mutex
+		down   map[string]bool
+		probed = make(map[string]string)
+	)
+	h.dnsHealth.probe = func(ctx context.Context, addr, domain string) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		probed[addr] = domain
+		if down[addr] {
+			return errors.New("timeout")
+		}
+
+		return nil
+	}
+
+	addrs := func() map[string][]string {
+		got := map[string][]string{}
+		dnsConfig := h.cfg.Reloadable().DNSConfig
+		for _, resolver := range dnsConfig.Resolvers {
+			got["."] = append(got["."], resolver.Addr)
+		}
+		for domain, resolvers := range dnsConfig.Routes {
+			got[domain] = []string{}
+			for _, resolver := range resolvers {
+				got[domain] = append(got[domain], resolver.Addr)
+			}
+		}
+
+		return got
+	}
+
+	h.checkDNSNameservers(context.Background())
+
+	wantProbed := map[string]string{
+		"1.1.1.1":                           ".",
+		"https://dns.example.com/dns-query": ".",
+		"10.0.0.1":                          "corp.example.com",
+		"10.0.0.2":                          "corp.example.com",
+		"10.0.0.3":                          "lab.example.com",
+	}
+	if diff := cmp.Diff(wantProbed, probed); diff != "" {
+		t.Errorf("probed nameservers mismatch (-want +got):\n%s", diff)
+	}
+
+	all := map[string][]string{
+		".":                    {"1.1.1.1", "https://dns.example.com/dns-query"},
+		"corp.example.com":     {"10.0.0.1", "10.0.0.2"},
+		"lab.example.com":      {"10.0.0.3"},
+		"64.100.in-addr.arpa.": {},
+	}
+	if diff := cmp.Diff(all, addrs()); diff != "" {
+		t.Errorf("all up mismatch (-want +got):\n%s", diff)
+	}
+
+	down = map[string]bool{"1.1.1.1": true, "10.0.0.1": true, "10.0.0.3": true}
+	h.checkDNSNameservers(context.Background())
+
+	want := map[string][]string{
+		".":                    {"https://dns.example.com/dns-query"},
+		"corp.example.com":     {"10.0.0.2"},
+		"lab.example.com":      {"10.0.0.3"},
+		"64.100.in-addr.arpa.": {},
+	}
+	if diff := cmp.Diff(want, addrs()); diff != "" {
+		t.Errorf("some down mismatch (-want +got):\n%s", diff)
+	}
+
+	down = nil
+	h.checkDNSNameservers(context.Background())
+
+	if diff := cmp.Diff(all, addrs()); diff != "" {
+		t.Errorf("up again mismatch (-want +got):\n%s", diff)
+	}
+
+	// A reload replaces the configuration the checks start from.
+	h.applyReloadedConfig(&types.Config{
+		DNSConfig: &tailcfg.DNSConfig{
+			Resolvers: []*dnstype.Resolver{{Addr: "8.8.8.8"}, {Addr: "9.9.9.9"}},
+		},
+	})
+	down = map[string]bool{"8.8.8.8": true}
+	h.checkDNSNameservers(context.Background())
+
+	if diff := cmp.Diff(map[string][]string{".": {"9.9.9.9"}}, addrs()); diff != "" {
+		t.Errorf("reloaded mismatch (-want +got):\n%s", diff)
+	}
+}
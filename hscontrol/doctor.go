@@ -0,0 +1,508 @@
+package hscontrol
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/net/stun"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	doctorTimeout = 5 * time.Second
+
+	// doctorSTUNAttempts is how many binding requests are sent before
+	// the STUN server is reported unreachable, UDP packets get lost.
+	doctorSTUNAttempts = 3
+
+	doctorCertificateExpiryWarning = 14 * 24 * time.Hour
+	doctorDBSlowThreshold          = 100 * time.Millisecond
+	doctorClockSkewWarning         = 5 * time.Second
+	doctorClockSkewFailure         = time.Minute
+)
+
+// DoctorCheck is the result of a check of the deployment, with how to
+// fix it when it does not pass.
+type DoctorCheck struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Details string `json:"details,omitempty"`
+	Fix     string `json:"fix,omitempty"`
+}
+
+type doctor struct {
+	cfg *types.Config
+
+	// roots verifies the TLS certificates, nil for the system roots.
+	roots *x509.CertPool
+
+	client *http.Client
+}
+
+func newDoctor(cfg *types.Config) *doctor {
+	return &doctor{
+		cfg:    cfg,
+		client: &http.Client{Timeout: doctorTimeout},
+	}
+}
+
+// Doctor checks headscale works end to end with the configuration: the
+// TLS certificate chain, that server_url and the STUN server of the
+// embedded DERP server answer, the latency of the database and the
+// clock of the host.
+func Doctor(ctx context.Context, cfg *types.Config) []DoctorCheck {
+	d := newDoctor(cfg)
+
+	return d.run(ctx, d.checkTLS, d.checkServerURL, d.checkSTUN, d.checkDatabase, d.checkClock)
+}
+
+// selfTest runs the checks of Doctor once headscale serves and logs the
+// ones not passing. The database is left out, /health reports it.
+func (h *Headscale) selfTest(ctx context.Context) {
+	d := newDoctor(h.cfg)
+
+	passed := true
+	for _, check := range d.run(ctx, d.checkTLS, d.checkServerURL, d.checkSTUN, d.checkClock) {
+		switch check.Status {
+		case healthWarn:
+			log.Warn().Str("check", check.Check).Str("fix", check.Fix).Msg(check.Details)
+		case healthFail:
+			log.Error().Str("check", check.Check).Str("fix", check.Fix).Msg(check.Details)
+		default:
+			continue
+		}
+		passed = false
+	}
+
+	if passed {
+		log.Info().Msg("Startup self-test passed")
+	}
+}
+
+func (d *doctor) run(ctx context.Context, checks ...func(context.Context) []DoctorCheck) []DoctorCheck {
+	var results []DoctorCheck
+	for _, check := range checks {
+		results = append(results, check(ctx)...)
+	}
+
+	return results
+}
+
+// serverURL returns server_url and its host and port.
+func (d *doctor) serverURL() (*url.URL, string, string, error) {
+	serverURL, err := url.Parse(d.cfg.ServerURL)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	port := serverURL.Port()
+	if port == "" {
+		port = "80"
+		if serverURL.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return serverURL, serverURL.Hostname(), port, nil
+}
+
+// checkTLS verifies the certificate chain of tls_cert_path, or the one
+// served at server_url when TLS is terminated elsewhere, like clients do.
+func (d *doctor) checkTLS(ctx context.Context) []DoctorCheck {
+	check := DoctorCheck{Check: "tls"}
+
+	serverURL, host, port, err := d.serverURL()
+	if err != nil {
+		check.Status, check.Details = healthFail, fmt.Sprintf("parsing server_url: %s", err)
+		check.Fix = "Set server_url to the URL nodes connect to, like https://headscale.example.com"
+
+		return []DoctorCheck{check}
+	}
+
+	if serverURL.Scheme != "https" {
+		check.Status = healthWarn
+		check.Details = "server_url is not HTTPS"
+		check.Fix = "Set tls_cert_path and tls_key_path or tls_letsencrypt_hostname, or terminate TLS on a reverse proxy and use an https server_url"
+
+		return []DoctorCheck{check}
+	}
+
+	var (
+		chain  []*x509.Certificate
+		source string
+	)
+	if d.cfg.TLS.CertPath != "" {
+		source = "tls_cert_path"
+		chain, err = loadCertificateChain(d.cfg.TLS.CertPath)
+	} else {
+		source = "the certificate served at server_url"
+		chain, err = d.fetchCertificateChain(ctx, net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		check.Status, check.Details = healthFail, err.Error()
+		check.Fix = fmt.Sprintf("Make sure %s is a valid PEM certificate chain", source)
+
+		return []DoctorCheck{check}
+	}
+
+	leaf := chain[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         d.roots,
+		Intermediates: intermediates,
+	})
+
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		hostnameErr      x509.HostnameError
+		invalid          x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority):
+		check.Status, check.Details = healthFail, err.Error()
+		check.Fix = fmt.Sprintf("The chain of %s is incomplete or self signed, append the intermediate certificates of the issuer after the certificate", source)
+	case errors.As(err, &hostnameErr):
+		check.Status, check.Details = healthFail, err.Error()
+		check.Fix = fmt.Sprintf("Issue the certificate for %s, the host of server_url", host)
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		check.Status, check.Details = healthFail, err.Error()
+		check.Fix = fmt.Sprintf("Renew %s", source)
+	case err != nil:
+		check.Status, check.Details = healthFail, err.Error()
+		check.Fix = fmt.Sprintf("Replace %s with a certificate for %s issued by a trusted authority", source, host)
+	case time.Until(leaf.NotAfter) < doctorCertificateExpiryWarning:
+		check.Status = healthWarn
+		check.Details = fmt.Sprintf("certificate for %s expires on %s", host, leaf.NotAfter.Format(time.DateOnly))
+		check.Fix = fmt.Sprintf("Renew %s, or check why it was not renewed automatically", source)
+	default:
+		check.Status = healthPass
+		check.Details = fmt.Sprintf(
+			"certificate for %s issued by %s, valid until %s",
+			host,
+			leaf.Issuer.CommonName,
+			leaf.NotAfter.Format(time.DateOnly),
+		)
+	}
+
+	return []DoctorCheck{check}
+}
+
+func loadCertificateChain(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%s contains no certificate", path)
+	}
+
+	return chain, nil
+}
+
+func (d *doctor) fetchCertificateChain(ctx context.Context, addr string) ([]*x509.Certificate, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: doctorTimeout},
+		Config: &tls.Config{
+			// The chain is verified afterwards to explain what is wrong
+			// with it.
+			//nolint:gosec
+			InsecureSkipVerify: true,
+		},
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	//nolint:forcetypeassert
+	return conn.(*tls.Conn).ConnectionState().PeerCertificates, nil
+}
+
+// checkServerURL fetches the key of the server through server_url, the
+// way nodes start talking to headscale. It goes through the public DNS
+// name, firewall and reverse proxy, but from this host.
+func (d *doctor) checkServerURL(ctx context.Context) []DoctorCheck {
+	check := DoctorCheck{Check: "server_url"}
+
+	serverURL, host, port, err := d.serverURL()
+	if err != nil {
+		check.Status, check.Details = healthFail, fmt.Sprintf("parsing server_url: %s", err)
+		check.Fix = "Set server_url to the URL nodes connect to, like https://headscale.example.com"
+
+		return []DoctorCheck{check}
+	}
+
+	keyURL := serverURL.JoinPath("key")
+	keyURL.RawQuery = "v=" + strconv.Itoa(int(tailcfg.CurrentCapabilityVersion))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURL.String(), nil)
+	if err != nil {
+		check.Status, check.Details = healthFail, err.Error()
+
+		return []DoctorCheck{check}
+	}
+
+	client := d.client
+	if d.roots != nil {
+		client = &http.Client{
+			Timeout:   d.client.Timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: d.roots}},
+		}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		var certErr *tls.CertificateVerificationError
+
+		check.Status, check.Details = healthFail, err.Error()
+		if errors.As(err, &certErr) {
+			check.Fix = "Nodes reject the TLS certificate, see the tls check"
+		} else {
+			check.Fix = fmt.Sprintf(
+				"Check that %s resolves to this server, that the firewall allows TCP port %s and that it is forwarded to listen_addr (%s)",
+				host,
+				port,
+				d.cfg.Addr,
+			)
+		}
+
+		return []DoctorCheck{check}
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		check.Status = healthFail
+		check.Details = fmt.Sprintf("%s returned %s", keyURL.Path, resp.Status)
+		check.Fix = fmt.Sprintf(
+			"Something else than headscale answers at %s, make sure the reverse proxy forwards all paths and websocket upgrades to listen_addr (%s)",
+			host,
+			d.cfg.Addr,
+		)
+
+		return []DoctorCheck{check}
+	}
+
+	check.Status = healthPass
+	check.Details = fmt.Sprintf(
+		"reached from this host in %s, check it from outside the network too",
+		time.Since(start).Round(time.Millisecond),
+	)
+
+	return []DoctorCheck{check}
+}
+
+// checkSTUN sends binding requests to the STUN server of the embedded
+// DERP server, at the address nodes get in the DERP map.
+func (d *doctor) checkSTUN(ctx context.Context) []DoctorCheck {
+	if !d.cfg.DERP.ServerEnabled {
+		return nil
+	}
+
+	check := DoctorCheck{Check: "derp:stun"}
+
+	_, host, _, err := d.serverURL()
+	if err != nil {
+		check.Status, check.Details = healthFail, fmt.Sprintf("parsing server_url: %s", err)
+
+		return []DoctorCheck{check}
+	}
+	if d.cfg.DERP.IPv4 != "" {
+		host = d.cfg.DERP.IPv4
+	}
+
+	_, port, err := net.SplitHostPort(d.cfg.DERP.STUNAddr)
+	if err != nil {
+		check.Status, check.Details = healthFail, fmt.Sprintf("parsing derp.server.stun_listen_addr: %s", err)
+
+		return []DoctorCheck{check}
+	}
+
+	addr := net.JoinHostPort(host, port)
+	public, err := stunBindingRequest(ctx, addr)
+	if err != nil {
+		check.Status = healthFail
+		check.Details = fmt.Sprintf("no answer from %s: %s", addr, err)
+		check.Fix = fmt.Sprintf(
+			"Allow UDP port %s in the firewall and forward it to derp.server.stun_listen_addr (%s), without it nodes cannot discover their public endpoints",
+			port,
+			d.cfg.DERP.STUNAddr,
+		)
+
+		return []DoctorCheck{check}
+	}
+
+	check.Status = healthPass
+	check.Details = fmt.Sprintf("%s answered, public address of this host is %s", addr, public)
+
+	return []DoctorCheck{check}
+}
+
+// stunBindingRequest returns the public address the STUN server at addr
+// sees.
+func stunBindingRequest(ctx context.Context, addr string) (netip.AddrPort, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", addr)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 1024)
+	for range doctorSTUNAttempts {
+		txID := stun.NewTxID()
+		if _, err = conn.Write(stun.Request(txID)); err != nil {
+			return netip.AddrPort{}, err
+		}
+
+		if err = conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+			return netip.AddrPort{}, err
+		}
+
+		var n int
+		n, err = conn.Read(buf)
+		if err != nil {
+			continue
+		}
+
+		gotTxID, public, err := stun.ParseResponse(buf[:n])
+		if err != nil {
+			return netip.AddrPort{}, err
+		}
+		if gotTxID != txID {
+			continue
+		}
+
+		return public, nil
+	}
+
+	return netip.AddrPort{}, err
+}
+
+// checkDatabase measures the latency of the database.
+func (d *doctor) checkDatabase(ctx context.Context) []DoctorCheck {
+	check := DoctorCheck{Check: "database"}
+
+	latency, err := db.Latency(ctx, d.cfg.Database)
+	switch {
+	case err != nil:
+		check.Status, check.Details = healthFail, err.Error()
+		check.Fix = "Check the database section of the configuration and that headscale can reach the database"
+	case latency > doctorDBSlowThreshold:
+		check.Status = healthWarn
+		check.Details = fmt.Sprintf("a query took %s", latency.Round(time.Millisecond))
+		check.Fix = "Run the database closer to headscale or on faster storage, map updates wait for it"
+	default:
+		check.Status = healthPass
+		check.Details = fmt.Sprintf("a query took %s", latency.Round(time.Microsecond))
+	}
+
+	return []DoctorCheck{check}
+}
+
+// clockReference returns a server to compare the clock with: the OIDC
+// issuer, whose tokens are only valid for a short time, else the DERP
+// map URL.
+func (d *doctor) clockReference() string {
+	if providers := d.cfg.OIDC.Providers(); len(providers) > 0 {
+		return providers[0].Issuer
+	}
+
+	if len(d.cfg.DERP.URLs) > 0 {
+		return d.cfg.DERP.URLs[0].String()
+	}
+
+	return ""
+}
+
+// checkClock compares the clock with the Date header of a reference
+// server.
+func (d *doctor) checkClock(ctx context.Context) []DoctorCheck {
+	check := DoctorCheck{Check: "clock"}
+
+	reference := d.clockReference()
+	if reference == "" {
+		check.Status = healthPass
+		check.Details = "no OIDC issuer or DERP map URL to compare the clock with"
+
+		return []DoctorCheck{check}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, reference, nil)
+	if err != nil {
+		check.Status, check.Details = healthWarn, err.Error()
+
+		return []DoctorCheck{check}
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		check.Status, check.Details = healthWarn, fmt.Sprintf("comparing the clock with %s: %s", reference, err)
+
+		return []DoctorCheck{check}
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		check.Status, check.Details = healthWarn, fmt.Sprintf("%s sent no valid Date header", reference)
+
+		return []DoctorCheck{check}
+	}
+
+	// The Date header is truncated to the second, the middle of the
+	// round trip is compared with the middle of that second.
+	skew := start.Add(rtt / 2).Sub(date.Add(time.Second / 2)).Round(time.Second)
+
+	check.Details = fmt.Sprintf("%s off from %s", skew, reference)
+	switch {
+	case skew.Abs() > doctorClockSkewFailure:
+		check.Status = healthFail
+	case skew.Abs() > doctorClockSkewWarning:
+		check.Status = healthWarn
+	default:
+		check.Status = healthPass
+
+		return []DoctorCheck{check}
+	}
+	check.Fix = "Synchronise the clock of the host, for example with `timedatectl set-ntp true`, node key expiry and OIDC logins depend on it"
+
+	return []DoctorCheck{check}
+}
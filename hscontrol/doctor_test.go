@@ -0,0 +1,145 @@
+package hscontrol
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/net/stun"
+)
+
+func TestDoctorTLSAndServerURL(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+
+	d := newDoctor(&types.Config{ServerURL: srv.URL})
+	ctx := context.Background()
+
+	// Clients do not trust the self signed certificate.
+	tlsCheck := d.checkTLS(ctx)[0]
+	if tlsCheck.Status != healthFail || !strings.Contains(tlsCheck.Fix, "incomplete or self signed") {
+		t.Errorf("tls check with an untrusted certificate = %+v, want a failure about the chain", tlsCheck)
+	}
+	urlCheck := d.checkServerURL(ctx)[0]
+	if urlCheck.Status != healthFail || !strings.Contains(urlCheck.Fix, "tls check") {
+		t.Errorf("server_url check with an untrusted certificate = %+v, want a failure pointing to the tls check", urlCheck)
+	}
+
+	d.roots = x509.NewCertPool()
+	d.roots.AddCert(srv.Certificate())
+
+	if got := d.checkTLS(ctx)[0]; got.Status != healthPass {
+		t.Errorf("tls check with a trusted certificate = %+v, want %q", got, healthPass)
+	}
+	if got := d.checkServerURL(ctx)[0]; got.Status != healthPass {
+		t.Errorf("server_url check = %+v, want %q", got, healthPass)
+	}
+
+	// Something else than headscale answers at server_url.
+	d.cfg.ServerURL = srv.URL + "/elsewhere"
+	if got := d.checkServerURL(ctx)[0]; got.Status != healthFail || !strings.Contains(got.Details, "404") {
+		t.Errorf("server_url check on the wrong path = %+v, want a 404 failure", got)
+	}
+
+	// Plain HTTP only warns, a reverse proxy may still terminate TLS.
+	d.cfg.ServerURL = "http://127.0.0.1:8080"
+	if got := d.checkTLS(ctx)[0]; got.Status != healthWarn {
+		t.Errorf("tls check without HTTPS = %+v, want %q", got, healthWarn)
+	}
+}
+
+func TestDoctorSTUN(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			txID, err := stun.ParseBindingRequest(buf[:n])
+			if err != nil {
+				continue
+			}
+			udpAddr := addr.(*net.UDPAddr)
+			conn.WriteTo(stun.Response(txID, udpAddr.AddrPort()), addr)
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(conn.LocalAddr().String())
+	d := newDoctor(&types.Config{
+		ServerURL: "https://127.0.0.1",
+		DERP: types.DERPConfig{
+			ServerEnabled: true,
+			STUNAddr:      "0.0.0.0:" + port,
+		},
+	})
+
+	got := d.checkSTUN(context.Background())
+	if len(got) != 1 || got[0].Status != healthPass || !strings.Contains(got[0].Details, "127.0.0.1") {
+		t.Errorf("stun check = %+v, want a pass reporting the public address", got)
+	}
+
+	conn.Close()
+	if got := d.checkSTUN(context.Background()); got[0].Status != healthFail || !strings.Contains(got[0].Fix, "UDP port") {
+		t.Errorf("stun check without server = %+v, want a failure about the firewall", got)
+	}
+
+	d.cfg.DERP.ServerEnabled = false
+	if got := d.checkSTUN(context.Background()); len(got) != 0 {
+		t.Errorf("stun check without embedded DERP server = %+v, want none", got)
+	}
+}
+
+func TestDoctorClock(t *testing.T) {
+	var offset atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(time.Duration(offset.Load())).UTC().Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	derpURL, _ := url.Parse(srv.URL + "/derpmap/default")
+	d := newDoctor(&types.Config{DERP: types.DERPConfig{URLs: []url.URL{*derpURL}}})
+
+	if got := d.checkClock(context.Background())[0]; got.Status != healthPass {
+		t.Errorf("clock check in sync = %+v, want %q", got, healthPass)
+	}
+
+	offset.Store(int64(-2 * time.Minute))
+	if got := d.checkClock(context.Background())[0]; got.Status != healthFail || got.Fix == "" {
+		t.Errorf("clock check two minutes ahead = %+v, want a failure with a fix", got)
+	}
+}
+
+func TestDoctorDatabase(t *testing.T) {
+	d := newDoctor(&types.Config{
+		Database: types.DatabaseConfig{
+			Type: types.DatabaseSqlite,
+			Sqlite: types.SqliteConfig{
+				Path: filepath.Join(t.TempDir(), "headscale.db"),
+			},
+		},
+	})
+
+	if got := d.checkDatabase(context.Background())[0]; got.Status == healthFail {
+		t.Errorf("database check = %+v, want it to reach the database", got)
+	}
+}
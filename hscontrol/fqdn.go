@@ -0,0 +1,55 @@
+package hscontrol
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// userDomainCollision returns an error if the MagicDNS domain of a user
+// named name collides with the DNS configuration. The user name is only
+// part of the FQDNs with dns.use_username_in_magic_dns.
+func (h *Headscale) userDomainCollision(name string) error {
+	reloadable := h.cfg.Reloadable()
+	if !reloadable.DNSUserNameInMagicDNS || reloadable.BaseDomain == "" {
+		return nil
+	}
+
+	return h.cfg.DomainCollision(fmt.Sprintf("%s.%s", name, reloadable.BaseDomain))
+}
+
+// warnFQDNCollisions logs the users and nodes whose FQDN collides with
+// the search domains or extra records, the DNS configuration may have
+// changed since they were created.
+func (h *Headscale) warnFQDNCollisions() {
+	users, err := h.db.ListUsers()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list users to check their FQDN")
+
+		return
+	}
+
+	for _, user := range users {
+		if err := h.userDomainCollision(user.Name); err != nil {
+			log.Warn().Err(err).Str("user", user.Name).Msg("MagicDNS domain of user collides with the DNS configuration")
+		}
+	}
+
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list nodes to check their FQDN")
+
+		return
+	}
+
+	for _, node := range nodes {
+		fqdn, err := node.GetFQDN(h.cfg, h.cfg.Reloadable().BaseDomain)
+		if err != nil {
+			continue
+		}
+
+		if err := h.cfg.FQDNCollision(fqdn); err != nil {
+			log.Warn().Err(err).Uint64("node.id", node.ID.Uint64()).Msg("FQDN of node collides with the DNS configuration")
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Package geoip looks up the location of IP addresses in an offline
+// MaxMind (MMDB) database, like GeoLite2-City or GeoLite2-Country.
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Location is the location of an IP address.
+type Location struct {
+	CountryCode string
+	Country     string
+	City        string
+	Latitude    float64
+	Longitude   float64
+
+	// HasCoordinates reports if the database had coordinates
+	// for the address, Country databases do not.
+	HasCoordinates bool
+}
+
+// String returns a human readable location, e.g. "Oslo, Norway".
+func (l Location) String() string {
+	parts := make([]string, 0, 2)
+	if l.City != "" {
+		parts = append(parts, l.City)
+	}
+	if l.Country != "" {
+		parts = append(parts, l.Country)
+	} else if l.CountryCode != "" {
+		parts = append(parts, l.CountryCode)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// record is the subset of the GeoLite2/GeoIP2 City and Country
+// schema used by headscale.
+type record struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Locator looks up locations in an MMDB database.
+type Locator struct {
+	db *maxminddb.Reader
+}
+
+// Open opens the MMDB database at path.
+func Open(path string) (*Locator, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening geoip database: %w", err)
+	}
+
+	return &Locator{db: db}, nil
+}
+
+// Close closes the database.
+func (l *Locator) Close() error {
+	return l.db.Close()
+}
+
+// Lookup returns the location of addr, ok is false if the address
+// is not in the database or it could not be read.
+func (l *Locator) Lookup(addr netip.Addr) (Location, bool) {
+	if l == nil || !addr.IsValid() {
+		return Location{}, false
+	}
+
+	var rec record
+	_, found, err := l.db.LookupNetwork(addr.Unmap().AsSlice(), &rec)
+	if err != nil || !found {
+		return Location{}, false
+	}
+
+	loc := Location{
+		CountryCode: rec.Country.ISOCode,
+		Country:     rec.Country.Names["en"],
+		City:        rec.City.Names["en"],
+	}
+
+	if rec.Location.Latitude != nil && rec.Location.Longitude != nil {
+		loc.Latitude = *rec.Location.Latitude
+		loc.Longitude = *rec.Location.Longitude
+		loc.HasCoordinates = true
+	}
+
+	return loc, true
+}
@@ -0,0 +1,59 @@
+package hscontrol
+
+import (
+	"strings"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// registerGRPCServices registers the headscale API on the server, and
+// the reflection and health services if they are enabled.
+func (h *Headscale) registerGRPCServices(server *grpc.Server) {
+	v1.RegisterHeadscaleServiceServer(server, newHeadscaleV1APIServer(h))
+
+	if h.cfg.GRPCReflection {
+		reflection.Register(server)
+	}
+
+	if h.grpcHealth != nil {
+		healthpb.RegisterHealthServer(server, h.grpcHealth)
+	}
+}
+
+// newGRPCHealthServer returns a health service reporting headscale as
+// not serving until it finished starting.
+func newGRPCHealthServer() *health.Server {
+	server := health.NewServer()
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	server.SetServingStatus(v1.HeadscaleService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return server
+}
+
+// setGRPCServing reports the overall status and the status of the
+// headscale API to the health service, like /ready.
+func (h *Headscale) setGRPCServing(serving bool) {
+	if h.grpcHealth == nil {
+		return
+	}
+
+	if !serving {
+		h.grpcHealth.Shutdown()
+
+		return
+	}
+
+	h.grpcHealth.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	h.grpcHealth.SetServingStatus(v1.HeadscaleService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+}
+
+// isGRPCHealthMethod reports if the method is part of the health
+// service, which is called by load balancers and service meshes without
+// credentials.
+func isGRPCHealthMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+healthpb.Health_ServiceDesc.ServiceName+"/")
+}
@@ -0,0 +1,122 @@
+package hscontrol
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCHealthAndReflection(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{
+		GRPCReflection: true,
+		GRPCHealth:     true,
+	})
+	h.grpcHealth = newGRPCHealthServer()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(h.grpcAuthenticationInterceptor),
+		grpc.StreamInterceptor(h.grpcStreamAuthenticationInterceptor),
+	)
+	h.registerGRPCServices(server)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ctx := context.Background()
+	healthClient := healthpb.NewHealthClient(conn)
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		t.Helper()
+
+		res, err := healthClient.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("Check(%q) error = %s", service, err)
+		}
+
+		return res.GetStatus()
+	}
+
+	// Health checks do not need an API key, and follow readiness.
+	if got := check(""); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status during startup = %s, want NOT_SERVING", got)
+	}
+
+	h.setGRPCServing(true)
+	if got := check("headscale.v1.HeadscaleService"); got != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("status once ready = %s, want SERVING", got)
+	}
+
+	h.setGRPCServing(false)
+	if got := check(""); got != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status while shutting down = %s, want NOT_SERVING", got)
+	}
+
+	// The API itself still requires one.
+	_, err = v1.NewHeadscaleServiceClient(conn).ListUsers(ctx, &v1.ListUsersRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("ListUsers() without API key error = %v, want Unauthenticated", err)
+	}
+
+	listServices := func(ctx context.Context) (*reflectionpb.ServerReflectionResponse, error) {
+		t.Helper()
+
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+		if err != nil {
+			t.Fatalf("ServerReflectionInfo() error = %s", err)
+		}
+		if err := stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}); err != nil {
+			t.Fatalf("sending reflection request: %s", err)
+		}
+
+		return stream.Recv()
+	}
+
+	// So does the reflection service.
+	if _, err := listServices(ctx); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("reflection without API key error = %v, want Unauthenticated", err)
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	apiKey, _, err := h.db.CreateAPIKey(&expiration, nil, 0)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %s", err)
+	}
+	res, err := listServices(metadata.AppendToOutgoingContext(ctx, "authorization", AuthPrefix+apiKey))
+	if err != nil {
+		t.Fatalf("receiving reflection response: %s", err)
+	}
+
+	var services []string
+	for _, service := range res.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	for _, want := range []string{"headscale.v1.HeadscaleService", "grpc.health.v1.Health"} {
+		if !slices.Contains(services, want) {
+			t.Errorf("reflection lists %v, want %s", services, want)
+		}
+	}
+}
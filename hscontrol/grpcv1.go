@@ -2,15 +2,20 @@
 package hscontrol
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/netip"
 	"os"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -21,6 +26,7 @@ import (
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/localauth"
 	"github.com/juanfont/headscale/hscontrol/policy"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
@@ -53,7 +59,11 @@ func (api headscaleV1APIServer) CreateUser(
 	ctx context.Context,
 	request *v1.CreateUserRequest,
 ) (*v1.CreateUserResponse, error) {
-	user, err := api.h.db.CreateUser(request.GetName())
+	if err := api.h.userDomainCollision(request.GetName()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := api.h.createUser(request.GetName())
 	if err != nil {
 		return nil, err
 	}
@@ -65,7 +75,14 @@ func (api headscaleV1APIServer) RenameUser(
 	ctx context.Context,
 	request *v1.RenameUserRequest,
 ) (*v1.RenameUserResponse, error) {
-	err := api.h.db.RenameUser(request.GetOldName(), request.GetNewName())
+	if err := api.h.userDomainCollision(request.GetNewName()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	expiresAt := time.Now().Add(api.h.cfg.UserAliasExpiry)
+	err := api.h.db.Write(func(tx *gorm.DB) error {
+		return db.RenameUserWithAlias(tx, request.GetOldName(), request.GetNewName(), expiresAt)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -75,6 +92,17 @@ func (api headscaleV1APIServer) RenameUser(
 		return nil, err
 	}
 
+	// The policy resolves the user by its current name, the nodes of
+	// the user need to be matched by the alias from now on.
+	if err := api.h.reloadUserAliases(); err != nil {
+		return nil, err
+	}
+
+	ctx = types.NotifyCtx(ctx, "cli-renameuser", user.Name)
+	api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
 	return &v1.RenameUserResponse{User: user.Proto()}, nil
 }
 
@@ -90,6 +118,147 @@ func (api headscaleV1APIServer) DeleteUser(
 	return &v1.DeleteUserResponse{}, nil
 }
 
+func (api headscaleV1APIServer) SuspendUser(
+	ctx context.Context,
+	request *v1.SuspendUserRequest,
+) (*v1.SuspendUserResponse, error) {
+	now := time.Now()
+
+	nodes, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (types.Nodes, error) {
+		return db.SuspendUser(tx, request.GetName(), now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		ctx := types.NotifyCtx(ctx, "cli-suspenduser-self", node.Hostname)
+		api.h.nodeNotifier.NotifyByNodeID(
+			ctx,
+			types.StateUpdate{
+				Type:        types.StateSelfUpdate,
+				ChangeNodes: []types.NodeID{node.ID},
+			},
+			node.ID)
+	}
+
+	// The nodes of the user are no longer part of the policy,
+	// so every node needs its packet filter recomputed.
+	ctx = types.NotifyCtx(ctx, "cli-suspenduser", request.GetName())
+	api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	user, err := api.h.db.GetUser(request.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Int("nodes", len(nodes)).
+		Msg("user suspended")
+
+	return &v1.SuspendUserResponse{User: user.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) ResumeUser(
+	ctx context.Context,
+	request *v1.ResumeUserRequest,
+) (*v1.ResumeUserResponse, error) {
+	user, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.User, error) {
+		return db.ResumeUser(tx, request.GetName())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Msg("user resumed")
+
+	return &v1.ResumeUserResponse{User: user.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) ListUserAliases(
+	ctx context.Context,
+	request *v1.ListUserAliasesRequest,
+) (*v1.ListUserAliasesResponse, error) {
+	aliases, err := api.h.db.ListUserAliases(request.GetName())
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*v1.UserAlias, len(aliases))
+	for index, alias := range aliases {
+		response[index] = alias.Proto()
+	}
+
+	return &v1.ListUserAliasesResponse{Aliases: response}, nil
+}
+
+func (api headscaleV1APIServer) SetUserPassword(
+	ctx context.Context,
+	request *v1.SetUserPasswordRequest,
+) (*v1.SetUserPasswordResponse, error) {
+	var hash string
+	if request.GetPassword() != "" {
+		var err error
+		hash, err = localauth.HashPassword(request.GetPassword())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	user, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.User, error) {
+		return db.SetUserPassword(tx, request.GetName(), hash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Bool("has_password", hash != "").
+		Msg("user password changed")
+
+	return &v1.SetUserPasswordResponse{User: user.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) SetUserTOTP(
+	ctx context.Context,
+	request *v1.SetUserTOTPRequest,
+) (*v1.SetUserTOTPResponse, error) {
+	var secret string
+	if request.GetEnabled() {
+		var err error
+		secret, err = localauth.NewTOTPSecret()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.User, error) {
+		return db.SetUserTOTP(tx, request.GetName(), secret)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Bool("totp_enabled", secret != "").
+		Msg("user TOTP changed")
+
+	response := &v1.SetUserTOTPResponse{User: user.Proto()}
+	if secret != "" {
+		response.Secret = secret
+		response.Uri = localauth.TOTPURI(api.h.cfg.LocalAuth.TOTPIssuer, user.Name, secret)
+	}
+
+	return response, nil
+}
+
 func (api headscaleV1APIServer) ListUsers(
 	ctx context.Context,
 	request *v1.ListUsersRequest,
@@ -104,13 +273,20 @@ func (api headscaleV1APIServer) ListUsers(
 		response[index] = user.Proto()
 	}
 
-	sort.Slice(response, func(i, j int) bool {
-		return response[i].Id < response[j].Id
-	})
+	page, next, err := paginate(request, response, userListOrders)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Trace().Caller().Interface("users", page).Msg("")
 
-	log.Trace().Caller().Interface("users", response).Msg("")
+	return &v1.ListUsersResponse{Users: page, NextPageToken: next}, nil
+}
 
-	return &v1.ListUsersResponse{Users: response}, nil
+var userListOrders = listOrders[*v1.User]{
+	"id":         func(a, b *v1.User) int { return compareNumericIDs(a.GetId(), b.GetId()) },
+	"name":       func(a, b *v1.User) int { return cmp.Compare(a.GetName(), b.GetName()) },
+	"created_at": func(a, b *v1.User) int { return compareTimestamps(a.GetCreatedAt(), b.GetCreatedAt()) },
 }
 
 func (api headscaleV1APIServer) CreatePreAuthKey(
@@ -131,13 +307,35 @@ func (api headscaleV1APIServer) CreatePreAuthKey(
 		}
 	}
 
-	preAuthKey, err := api.h.db.CreatePreAuthKey(
-		request.GetUser(),
-		request.GetReusable(),
-		request.GetEphemeral(),
-		&expiration,
-		request.AclTags,
-	)
+	opts := db.PreAuthKeyNodeOptions{
+		NamePrefix:     request.GetNodeNamePrefix(),
+		Expiry:         request.GetNodeExpiry().AsDuration(),
+		ApprovedRoutes: request.GetApprovedRoutes(),
+		Service:        request.GetService(),
+	}
+
+	preAuthKey, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.PreAuthKey, error) {
+		preAuthKey, err := db.CreatePreAuthKey(
+			tx,
+			request.GetUser(),
+			request.GetReusable(),
+			request.GetEphemeral(),
+			&expiration,
+			request.AclTags,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := db.SetPreAuthKeyNodeOptions(tx, preAuthKey, opts); err != nil {
+			return nil, err
+		}
+
+		return preAuthKey, nil
+	})
+	if errors.Is(err, db.ErrPreAuthKeyNodeOptionInvalid) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -178,11 +376,18 @@ func (api headscaleV1APIServer) ListPreAuthKeys(
 		response[index] = key.Proto()
 	}
 
-	sort.Slice(response, func(i, j int) bool {
-		return response[i].Id < response[j].Id
-	})
+	page, next, err := paginate(request, response, preAuthKeyListOrders)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListPreAuthKeysResponse{PreAuthKeys: page, NextPageToken: next}, nil
+}
 
-	return &v1.ListPreAuthKeysResponse{PreAuthKeys: response}, nil
+var preAuthKeyListOrders = listOrders[*v1.PreAuthKey]{
+	"id":         func(a, b *v1.PreAuthKey) int { return compareNumericIDs(a.GetId(), b.GetId()) },
+	"created_at": func(a, b *v1.PreAuthKey) int { return compareTimestamps(a.GetCreatedAt(), b.GetCreatedAt()) },
+	"expiration": func(a, b *v1.PreAuthKey) int { return compareTimestamps(a.GetExpiration(), b.GetExpiration()) },
 }
 
 func (api headscaleV1APIServer) RegisterNode(
@@ -234,9 +439,11 @@ func (api headscaleV1APIServer) GetNode(
 
 	resp := node.Proto()
 
-	// Populate the online field based on
-	// currently connected nodes.
-	resp.Online = api.h.nodeNotifier.IsConnected(node.ID)
+	// Populate the online and last seen fields based on
+	// the activity of the node.
+	api.h.setLiveness(resp, node)
+	resp.LastSeenLocation = api.h.nodeLocation(node)
+	resp.ValidTags, resp.InvalidTags = api.h.ACLPolicy.TagsOfNode(node)
 
 	return &v1.GetNodeResponse{Node: resp}, nil
 }
@@ -252,27 +459,20 @@ func (api headscaleV1APIServer) SetTags(
 		}
 	}
 
-	node, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
-		err := db.SetTags(tx, types.NodeID(request.GetNodeId()), request.GetTags())
-		if err != nil {
-			return nil, err
-		}
-
-		return db.GetNodeByID(tx, types.NodeID(request.GetNodeId()))
-	})
+	node, err := api.changeTags(
+		ctx,
+		types.NodeID(request.GetNodeId()),
+		request.GetForce(),
+		func([]string) []string {
+			return request.GetTags()
+		},
+	)
 	if err != nil {
 		return &v1.SetTagsResponse{
 			Node: nil,
-		}, status.Error(codes.InvalidArgument, err.Error())
+		}, err
 	}
 
-	ctx = types.NotifyCtx(ctx, "cli-settags", node.Hostname)
-	api.h.nodeNotifier.NotifyWithIgnore(ctx, types.StateUpdate{
-		Type:        types.StatePeerChanged,
-		ChangeNodes: []types.NodeID{node.ID},
-		Message:     "called from api.SetTags",
-	}, node.ID)
-
 	log.Trace().
 		Str("node", node.Hostname).
 		Strs("tags", request.GetTags()).
@@ -281,6 +481,115 @@ func (api headscaleV1APIServer) SetTags(
 	return &v1.SetTagsResponse{Node: node.Proto()}, nil
 }
 
+func (api headscaleV1APIServer) AddTag(
+	ctx context.Context,
+	request *v1.AddTagRequest,
+) (*v1.AddTagResponse, error) {
+	if err := validateTag(request.GetTag()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	node, err := api.changeTags(
+		ctx,
+		types.NodeID(request.GetNodeId()),
+		request.GetForce(),
+		func(current []string) []string {
+			return append(slices.Clone(current), request.GetTag())
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.AddTagResponse{Node: node.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) RemoveTag(
+	ctx context.Context,
+	request *v1.RemoveTagRequest,
+) (*v1.RemoveTagResponse, error) {
+	node, err := api.changeTags(
+		ctx,
+		types.NodeID(request.GetNodeId()),
+		false,
+		func(current []string) []string {
+			return slices.DeleteFunc(slices.Clone(current), func(tag string) bool {
+				return tag == request.GetTag()
+			})
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RemoveTagResponse{Node: node.Proto()}, nil
+}
+
+// changeTags replaces the forced tags of a node with the tags returned
+// by change for its current tags. Unless force is set, the user of the
+// node must own the added tags in the TagOwners of the policy. If the
+// caller uses an API key limited to some tags, it can only add and
+// remove those. The filters of all nodes are recompiled afterwards, as
+// they can depend on the tags.
+func (api headscaleV1APIServer) changeTags(
+	ctx context.Context,
+	nodeID types.NodeID,
+	force bool,
+	change func(current []string) []string,
+) (*types.Node, error) {
+	apiKey, err := api.h.apiKeyFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	node, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		node, err := db.GetNodeByID(tx, nodeID)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+
+		tags := change(node.ForcedTags)
+
+		for _, tag := range tags {
+			if slices.Contains(node.ForcedTags, tag) {
+				continue
+			}
+
+			if apiKey != nil && !apiKey.CanManageTag(tag) {
+				return nil, status.Errorf(codes.PermissionDenied, "API key is not allowed to add %s", tag)
+			}
+
+			if !force && api.h.ACLPolicy != nil {
+				if err := api.h.ACLPolicy.ValidateNodeTagOwner(tag, node); err != nil {
+					return nil, status.Error(codes.InvalidArgument, err.Error())
+				}
+			}
+		}
+
+		for _, tag := range node.ForcedTags {
+			if apiKey != nil && !slices.Contains(tags, tag) && !apiKey.CanManageTag(tag) {
+				return nil, status.Errorf(codes.PermissionDenied, "API key is not allowed to remove %s", tag)
+			}
+		}
+
+		if err := db.SetTags(tx, nodeID, tags); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		return db.GetNodeByID(tx, nodeID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = types.NotifyCtx(ctx, "cli-settags", node.Hostname)
+	api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	return node, nil
+}
+
 func validateTag(tag string) error {
 	if strings.Index(tag, "tag:") != 0 {
 		return errors.New("tag must start with the string 'tag:'")
@@ -366,6 +675,84 @@ func (api headscaleV1APIServer) ExpireNode(
 	return &v1.ExpireNodeResponse{Node: node.Proto()}, nil
 }
 
+func (api headscaleV1APIServer) RotateNodeKey(
+	ctx context.Context,
+	request *v1.RotateNodeKeyRequest,
+) (*v1.RotateNodeKeyResponse, error) {
+	node, err := api.revokeNodeKeys(ctx, types.NodeID(request.GetNodeId()), request.GetMachineKey())
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RotateNodeKeyResponse{Node: node.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) RevokeNode(
+	ctx context.Context,
+	request *v1.RevokeNodeRequest,
+) (*v1.RevokeNodeResponse, error) {
+	node, err := api.revokeNodeKeys(ctx, types.NodeID(request.GetNodeId()), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.RevokeNodeResponse{Node: node.Proto()}, nil
+}
+
+// revokeNodeKeys expires the node and revokes its node key, and its
+// machine key if machineKey is set. A node losing its machine key is
+// removed from the maps of its peers, its own map session is closed and
+// its connections to the embedded DERP server are dropped.
+func (api headscaleV1APIServer) revokeNodeKeys(
+	ctx context.Context,
+	nodeID types.NodeID,
+	machineKey bool,
+) (*types.Node, error) {
+	now := time.Now()
+
+	node, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		return db.RotateNodeKey(tx, nodeID, machineKey, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if machineKey {
+		// The update reaches the node too, which closes its session.
+		ctx = types.NotifyCtx(ctx, "cli-revokenode", node.Hostname)
+		api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type:    types.StatePeerRemoved,
+			Removed: []types.NodeID{node.ID},
+		})
+
+		if api.h.DERPServer != nil {
+			api.h.DERPServer.DisconnectNodeKey(node.NodeKey)
+		}
+	} else {
+		// The node learns it expired and asks for a new login with a
+		// new node key, its peers stop using the old one.
+		ctx = types.NotifyCtx(ctx, "cli-rotatenodekey-self", node.Hostname)
+		api.h.nodeNotifier.NotifyByNodeID(
+			ctx,
+			types.StateUpdate{
+				Type:        types.StateSelfUpdate,
+				ChangeNodes: []types.NodeID{node.ID},
+			},
+			node.ID)
+
+		ctx = types.NotifyCtx(ctx, "cli-rotatenodekey-peers", node.Hostname)
+		api.h.nodeNotifier.NotifyWithIgnore(ctx, types.StateUpdateExpire(node.ID, now), node.ID)
+	}
+
+	log.Info().
+		Str("node", node.Hostname).
+		Str("node_key", node.NodeKey.ShortString()).
+		Bool("machine_key", machineKey).
+		Msg("node keys revoked")
+
+	return node, nil
+}
+
 func (api headscaleV1APIServer) RenameNode(
 	ctx context.Context,
 	request *v1.RenameNodeRequest,
@@ -401,66 +788,305 @@ func (api headscaleV1APIServer) RenameNode(
 	return &v1.RenameNodeResponse{Node: node.Proto()}, nil
 }
 
-func (api headscaleV1APIServer) ListNodes(
+func (api headscaleV1APIServer) SetNodeNote(
 	ctx context.Context,
-	request *v1.ListNodesRequest,
-) (*v1.ListNodesResponse, error) {
-	isLikelyConnected := api.h.nodeNotifier.LikelyConnectedMap()
-	if request.GetUser() != "" {
-		nodes, err := db.Read(api.h.db.DB, func(rx *gorm.DB) (types.Nodes, error) {
-			return db.ListNodesByUser(rx, request.GetUser())
-		})
+	request *v1.SetNodeNoteRequest,
+) (*v1.SetNodeNoteResponse, error) {
+	node, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		node, err := db.GetNodeByID(tx, types.NodeID(request.GetNodeId()))
 		if err != nil {
 			return nil, err
 		}
 
-		response := make([]*v1.Node, len(nodes))
-		for index, node := range nodes {
-			resp := node.Proto()
-
-			// Populate the online field based on
-			// currently connected nodes.
-			if val, ok := isLikelyConnected.Load(node.ID); ok && val {
-				resp.Online = true
-			}
-
-			response[index] = resp
+		err = db.SetNodeNote(tx, node.ID, strings.TrimSpace(request.GetNote()))
+		if errors.Is(err, db.ErrNodeNoteTooLong) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err != nil {
+			return nil, err
 		}
 
-		return &v1.ListNodesResponse{Nodes: response}, nil
-	}
-
-	nodes, err := api.h.db.ListNodes()
+		return db.GetNodeByID(tx, node.ID)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	sort.Slice(nodes, func(i, j int) bool {
-		return nodes[i].ID < nodes[j].ID
-	})
+	log.Trace().
+		Str("node", node.Hostname).
+		Str("note", node.Note).
+		Msg("node note set")
 
-	response := make([]*v1.Node, len(nodes))
-	for index, node := range nodes {
-		resp := node.Proto()
+	return &v1.SetNodeNoteResponse{Node: node.Proto()}, nil
+}
 
-		// Populate the online field based on
-		// currently connected nodes.
-		if val, ok := isLikelyConnected.Load(node.ID); ok && val {
-			resp.Online = true
+func (api headscaleV1APIServer) SetNodeDERPHome(
+	ctx context.Context,
+	request *v1.SetNodeDERPHomeRequest,
+) (*v1.SetNodeDERPHomeResponse, error) {
+	region := int(request.GetRegionId())
+	if region < 0 {
+		return nil, status.Error(codes.InvalidArgument, db.ErrNodeDERPHomeRegionInvalid.Error())
+	}
+	if region != 0 {
+		if derpMap := api.h.derpMap.Load(); derpMap != nil {
+			if _, ok := derpMap.Regions[region]; !ok {
+				return nil, status.Errorf(codes.InvalidArgument, "DERP region %d is not in the DERP map", region)
+			}
 		}
-
-		validTags, invalidTags := api.h.ACLPolicy.TagsOfNode(
-			node,
-		)
-		resp.InvalidTags = invalidTags
-		resp.ValidTags = validTags
-		response[index] = resp
 	}
 
-	return &v1.ListNodesResponse{Nodes: response}, nil
-}
+	node, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		node, err := db.GetNodeByID(tx, types.NodeID(request.GetNodeId()))
+		if err != nil {
+			return nil, err
+		}
 
-func (api headscaleV1APIServer) MoveNode(
+		if err := db.SetNodeDERPHomeRegion(tx, node.ID, region); err != nil {
+			return nil, err
+		}
+
+		return db.GetNodeByID(tx, node.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = types.NotifyCtx(ctx, "cli-derphome", node.Hostname)
+	api.h.nodeNotifier.NotifyByNodeID(
+		ctx,
+		types.StateUpdate{
+			Type:    types.StateDERPUpdated,
+			DERPMap: api.h.derpMap.Load(),
+		},
+		node.ID)
+
+	log.Trace().
+		Str("node", node.Hostname).
+		Int("region", node.DERPHomeRegion).
+		Msg("node DERP home region set")
+
+	return &v1.SetNodeDERPHomeResponse{Node: node.Proto()}, nil
+}
+
+// SetNodeService attributes a tagged node to a service account, the
+// untagged nodes of a user cannot be attributed to a service.
+func (api headscaleV1APIServer) SetNodeService(
+	ctx context.Context,
+	request *v1.SetNodeServiceRequest,
+) (*v1.SetNodeServiceResponse, error) {
+	node, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		node, err := db.GetNodeByID(tx, types.NodeID(request.GetNodeId()))
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+
+		if request.GetService() != "" && !api.nodeIsTagged(node) {
+			return nil, status.Error(codes.FailedPrecondition, "only tagged nodes can be attributed to a service")
+		}
+
+		if err := db.SetNodeService(tx, node.ID, request.GetService()); err != nil {
+			if errors.Is(err, db.ErrServiceAccountNotFound) {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+
+			return nil, err
+		}
+
+		return db.GetNodeByID(tx, node.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = types.NotifyCtx(ctx, "cli-setservice", node.Hostname)
+	api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	log.Trace().
+		Str("node", node.Hostname).
+		Str("service", node.Service).
+		Msg("node service set")
+
+	return &v1.SetNodeServiceResponse{Node: node.Proto()}, nil
+}
+
+// nodeIsTagged reports if the node has forced tags, or requested tags
+// allowed by the policy.
+func (api headscaleV1APIServer) nodeIsTagged(node *types.Node) bool {
+	if len(node.ForcedTags) > 0 {
+		return true
+	}
+
+	if api.h.ACLPolicy == nil {
+		return false
+	}
+
+	validTags, _ := api.h.ACLPolicy.TagsOfNode(node)
+
+	return len(validTags) > 0
+}
+
+func (api headscaleV1APIServer) ListNodes(
+	ctx context.Context,
+	request *v1.ListNodesRequest,
+) (*v1.ListNodesResponse, error) {
+	var nodes types.Nodes
+	var err error
+	if request.GetUser() != "" {
+		nodes, err = db.Read(api.h.db.DB, func(rx *gorm.DB) (types.Nodes, error) {
+			return db.ListNodesByUser(rx, request.GetUser())
+		})
+	} else {
+		nodes, err = api.h.db.ListNodes()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+
+	response := make([]*v1.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if tag := request.GetTag(); tag != "" && !slices.Contains(api.h.nodeTags(node), tag) {
+			continue
+		}
+
+		if olderThan := request.GetClientVersionOlderThan(); olderThan != "" {
+			// Nodes that did not report a version are left out, as
+			// it is unknown whether they are older.
+			if node.Hostinfo == nil || node.Hostinfo.IPNVersion == "" ||
+				util.TailscaleVersionNewerOrEqual(olderThan, node.Hostinfo.IPNVersion) {
+				continue
+			}
+		}
+
+		resp := node.Proto()
+
+		// Populate the online and last seen fields based on
+		// the activity of the node.
+		api.h.setLiveness(resp, node)
+		resp.LastSeenLocation = api.h.nodeLocation(node)
+
+		if request.Online != nil && resp.GetOnline() != request.GetOnline() {
+			continue
+		}
+
+		validTags, invalidTags := api.h.ACLPolicy.TagsOfNode(
+			node,
+		)
+		resp.InvalidTags = invalidTags
+		resp.ValidTags = validTags
+		response = append(response, resp)
+	}
+
+	page, next, err := paginate(request, response, nodeListOrders)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ListNodesResponse{Nodes: page, NextPageToken: next}, nil
+}
+
+var nodeListOrders = listOrders[*v1.Node]{
+	"id":         func(a, b *v1.Node) int { return cmp.Compare(a.GetId(), b.GetId()) },
+	"name":       func(a, b *v1.Node) int { return cmp.Compare(a.GetGivenName(), b.GetGivenName()) },
+	"hostname":   func(a, b *v1.Node) int { return cmp.Compare(a.GetName(), b.GetName()) },
+	"user":       func(a, b *v1.Node) int { return cmp.Compare(a.GetUser().GetName(), b.GetUser().GetName()) },
+	"created_at": func(a, b *v1.Node) int { return compareTimestamps(a.GetCreatedAt(), b.GetCreatedAt()) },
+	"last_seen":  func(a, b *v1.Node) int { return compareTimestamps(a.GetLastSeen(), b.GetLastSeen()) },
+	"expiry":     func(a, b *v1.Node) int { return compareTimestamps(a.GetExpiry(), b.GetExpiry()) },
+}
+
+func (api headscaleV1APIServer) Search(
+	ctx context.Context,
+	request *v1.SearchRequest,
+) (*v1.SearchResponse, error) {
+	query := strings.TrimSpace(request.GetQuery())
+	if query == "" {
+		return nil, status.Error(codes.InvalidArgument, "query must not be empty")
+	}
+
+	limit := int(request.GetLimit())
+	switch {
+	case limit < 0:
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	case limit == 0:
+		limit = searchDefaultLimit
+	case limit > searchMaxLimit:
+		limit = searchMaxLimit
+	}
+
+	users, err := api.h.db.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := api.h.db.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*v1.SearchResult
+	for _, user := range users {
+		match := searchUser(query, &user)
+		if match.score == 0 {
+			continue
+		}
+
+		results = append(results, &v1.SearchResult{
+			Score:  int32(match.score),
+			Field:  match.field,
+			Value:  match.value,
+			Result: &v1.SearchResult_User{User: user.Proto()},
+		})
+	}
+
+	for _, node := range nodes {
+		match := searchNode(query, node, api.h.nodeTags(node))
+		if match.score == 0 {
+			continue
+		}
+
+		resp := node.Proto()
+		api.h.setLiveness(resp, node)
+		resp.LastSeenLocation = api.h.nodeLocation(node)
+		resp.ValidTags, resp.InvalidTags = api.h.ACLPolicy.TagsOfNode(node)
+
+		results = append(results, &v1.SearchResult{
+			Score:  int32(match.score),
+			Field:  match.field,
+			Value:  match.value,
+			Result: &v1.SearchResult_Node{Node: resp},
+		})
+	}
+
+	slices.SortStableFunc(results, func(a, b *v1.SearchResult) int {
+		return cmp.Or(
+			cmp.Compare(b.GetScore(), a.GetScore()),
+			cmp.Compare(searchResultName(a), searchResultName(b)),
+		)
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return &v1.SearchResponse{Results: results}, nil
+}
+
+func searchResultName(result *v1.SearchResult) string {
+	if node := result.GetNode(); node != nil {
+		return node.GetGivenName()
+	}
+
+	return result.GetUser().GetName()
+}
+
+func (api headscaleV1APIServer) MoveNode(
 	ctx context.Context,
 	request *v1.MoveNodeRequest,
 ) (*v1.MoveNodeResponse, error) {
@@ -495,6 +1121,360 @@ func (api headscaleV1APIServer) BackfillNodeIPs(
 	return &v1.BackfillNodeIPsResponse{Changes: changes}, nil
 }
 
+func (api headscaleV1APIServer) ListNodeStats(
+	ctx context.Context,
+	request *v1.ListNodeStatsRequest,
+) (*v1.ListNodeStatsResponse, error) {
+	if api.h.DERPServer == nil {
+		return nil, status.Error(codes.FailedPrecondition, "embedded DERP server is not enabled")
+	}
+
+	var nodes types.Nodes
+	if request.GetNodeId() != 0 {
+		node, err := api.h.db.GetNodeByID(types.NodeID(request.GetNodeId()))
+		if err != nil {
+			return nil, err
+		}
+		nodes = types.Nodes{node}
+	} else {
+		var err error
+		nodes, err = api.h.db.ListNodes()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stats := make([]*v1.NodeStats, len(nodes))
+	for index, node := range nodes {
+		usage := api.h.DERPServer.NodeUsage(node.NodeKey)
+		stats[index] = &v1.NodeStats{
+			NodeId:      node.ID.Uint64(),
+			Name:        node.GivenName,
+			NodeKey:     node.NodeKey.String(),
+			DerpRxBytes: usage.RxBytes,
+			DerpTxBytes: usage.TxBytes,
+		}
+	}
+
+	// Heaviest users of the relay first.
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].GetDerpRxBytes()+stats[i].GetDerpTxBytes() >
+			stats[j].GetDerpRxBytes()+stats[j].GetDerpTxBytes()
+	})
+
+	return &v1.ListNodeStatsResponse{NodeStats: stats}, nil
+}
+
+func (api headscaleV1APIServer) ListDERPClients(
+	ctx context.Context,
+	request *v1.ListDERPClientsRequest,
+) (*v1.ListDERPClientsResponse, error) {
+	if api.h.DERPServer == nil {
+		return nil, status.Error(codes.FailedPrecondition, "embedded DERP server is not enabled")
+	}
+
+	clients := api.h.DERPServer.Clients()
+	response := make([]*v1.DERPClient, len(clients))
+	for index, client := range clients {
+		response[index] = &v1.DERPClient{
+			NodeKey:     client.NodeKey.String(),
+			RemoteAddr:  client.RemoteAddr,
+			ConnectedAt: timestamppb.New(client.ConnectedAt),
+			RxBytes:     client.RxBytes,
+			TxBytes:     client.TxBytes,
+		}
+
+		// Clients are not required to be nodes of this headscale.
+		if node, err := api.h.db.GetNodeByNodeKey(client.NodeKey); err == nil {
+			response[index].NodeId = node.ID.Uint64()
+			response[index].Name = node.GivenName
+		}
+	}
+
+	return &v1.ListDERPClientsResponse{Clients: response}, nil
+}
+
+func (api headscaleV1APIServer) RegisterDERPRelay(
+	ctx context.Context,
+	request *v1.RegisterDERPRelayRequest,
+) (*v1.RegisterDERPRelayResponse, error) {
+	if !api.h.cfg.DERP.RelaysEnabled {
+		return nil, status.Error(codes.FailedPrecondition, "DERP relays are not enabled, see derp.relays.enabled")
+	}
+
+	relay := types.DERPRelayFromProto(request.GetRelay())
+
+	// Keys of relays can only register their own region.
+	caller, err := api.h.apiKeyFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if caller != nil && caller.DERPRegionID != 0 && caller.DERPRegionID != relay.RegionID {
+		return nil, status.Errorf(codes.PermissionDenied, "API key is limited to DERP region %d", caller.DERPRegionID)
+	}
+
+	if err := api.h.checkDERPRelayRegion(relay.RegionID); err != nil {
+		return nil, status.Errorf(codes.AlreadyExists, "region %d: %s", relay.RegionID, err)
+	}
+
+	registered, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.DERPRelay, error) {
+		return db.RegisterDERPRelay(tx, relay)
+	})
+	if errors.Is(err, db.ErrInvalidDERPRelay) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Heartbeats of a relay already in the DERPMap do not change it.
+	if !api.h.derpRelayServed(*registered) {
+		log.Info().
+			Int("region_id", registered.RegionID).
+			Str("hostname", registered.Hostname).
+			Msg("DERP relay registered, updating the DERPMap")
+		api.h.updateDERPMap("derp-relay-registered")
+	}
+
+	return &v1.RegisterDERPRelayResponse{Relay: registered.Proto(api.h.cfg.DERP.RelayExpiry)}, nil
+}
+
+func (api headscaleV1APIServer) ListDERPRelays(
+	ctx context.Context,
+	request *v1.ListDERPRelaysRequest,
+) (*v1.ListDERPRelaysResponse, error) {
+	relays, err := api.h.db.ListDERPRelays()
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*v1.DERPRelay, len(relays))
+	for index, relay := range relays {
+		response[index] = relay.Proto(api.h.cfg.DERP.RelayExpiry)
+	}
+
+	return &v1.ListDERPRelaysResponse{Relays: response}, nil
+}
+
+func (api headscaleV1APIServer) DeleteDERPRelay(
+	ctx context.Context,
+	request *v1.DeleteDERPRelayRequest,
+) (*v1.DeleteDERPRelayResponse, error) {
+	relay, err := api.h.db.GetDERPRelay(int(request.GetRegionId()))
+	if errors.Is(err, db.ErrDERPRelayNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	served := api.h.derpRelayServed(*relay)
+	if err := api.h.db.Write(func(tx *gorm.DB) error {
+		return db.DeleteDERPRelay(tx, relay.RegionID)
+	}); err != nil {
+		return nil, err
+	}
+
+	if served {
+		api.h.updateDERPMap("derp-relay-deleted")
+	}
+
+	return &v1.DeleteDERPRelayResponse{}, nil
+}
+
+func (api headscaleV1APIServer) CreateServiceAccount(
+	ctx context.Context,
+	request *v1.CreateServiceAccountRequest,
+) (*v1.CreateServiceAccountResponse, error) {
+	account, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.ServiceAccount, error) {
+		return db.CreateServiceAccount(tx, request.GetName(), request.GetDescription())
+	})
+	if errors.Is(err, db.ErrServiceAccountExists) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.Is(err, util.ErrInvalidUserName) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.CreateServiceAccountResponse{ServiceAccount: account.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) ListServiceAccounts(
+	ctx context.Context,
+	request *v1.ListServiceAccountsRequest,
+) (*v1.ListServiceAccountsResponse, error) {
+	accounts, err := api.h.db.ListServiceAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]*v1.ServiceAccount, len(accounts))
+	for index := range accounts {
+		response[index] = accounts[index].Proto()
+	}
+
+	return &v1.ListServiceAccountsResponse{ServiceAccounts: response}, nil
+}
+
+func (api headscaleV1APIServer) DeleteServiceAccount(
+	ctx context.Context,
+	request *v1.DeleteServiceAccountRequest,
+) (*v1.DeleteServiceAccountResponse, error) {
+	err := api.h.db.Write(func(tx *gorm.DB) error {
+		return db.DestroyServiceAccount(tx, request.GetName())
+	})
+	if errors.Is(err, db.ErrServiceAccountNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, db.ErrServiceAccountInUse) {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.DeleteServiceAccountResponse{}, nil
+}
+
+func (api headscaleV1APIServer) ImportTailnet(
+	ctx context.Context,
+	request *v1.ImportTailnetRequest,
+) (*v1.ImportTailnetResponse, error) {
+	response, err := api.h.importTailnet(request)
+	if errors.Is(err, ErrInvalidImport) || errors.Is(err, db.ErrUserNotFound) || errors.Is(err, util.ErrInvalidUserName) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return response, nil
+}
+
+func (api headscaleV1APIServer) GetNodeNetcheck(
+	ctx context.Context,
+	request *v1.GetNodeNetcheckRequest,
+) (*v1.GetNodeNetcheckResponse, error) {
+	node, err := api.h.db.GetNodeByID(types.NodeID(request.GetNodeId()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	reports, err := api.h.db.ListNetcheckReports(node.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.GetNodeNetcheckResponse{}
+	for _, report := range reports {
+		response.Reports = append(response.Reports, report.Proto())
+	}
+
+	return response, nil
+}
+
+func (api headscaleV1APIServer) NodeC2N(
+	ctx context.Context,
+	request *v1.NodeC2NRequest,
+) (*v1.NodeC2NResponse, error) {
+	apiKey, err := api.h.apiKeyFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	// Keys limited to tags only manage tags.
+	if apiKey != nil && len(apiKey.Tags) > 0 {
+		return nil, status.Error(codes.PermissionDenied, "API keys limited to tags cannot send requests to nodes")
+	}
+
+	node, err := api.h.db.GetNodeByID(types.NodeID(request.GetNodeId()))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	method := request.GetMethod()
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	resp, err := api.h.c2nRequest(ctx, node, strings.ToUpper(method), request.GetPath(), request.GetBody())
+	switch {
+	case errors.Is(err, ErrC2NEndpoint):
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrC2NNodeOffline):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	case err != nil:
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading answer of the node: %w", err)
+	}
+
+	log.Info().
+		Str("node", node.Hostname).
+		Str("method", method).
+		Str("path", request.GetPath()).
+		Int("status", resp.StatusCode).
+		Msg("Sent c2n request")
+
+	return &v1.NodeC2NResponse{
+		StatusCode:  int32(resp.StatusCode),
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	}, nil
+}
+
+func (api headscaleV1APIServer) PreviewNodeFQDN(
+	ctx context.Context,
+	request *v1.PreviewNodeFQDNRequest,
+) (*v1.PreviewNodeFQDNResponse, error) {
+	if request.GetHostname() == "" {
+		return nil, status.Error(codes.InvalidArgument, "hostname is required")
+	}
+
+	node := types.Node{Hostname: request.GetHostname()}
+	if request.GetUser() != "" {
+		user, err := api.h.db.GetUser(request.GetUser())
+		if err != nil {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		node.User = *user
+	} else if api.h.cfg.Reloadable().DNSUserNameInMagicDNS {
+		return nil, status.Error(codes.InvalidArgument, "user is required with dns.use_username_in_magic_dns")
+	}
+
+	givenName, err := db.Read(api.h.db.DB, func(rx *gorm.DB) (string, error) {
+		return db.PreviewGivenName(rx, request.GetHostname())
+	})
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	node.GivenName = givenName
+
+	fqdn, err := node.GetFQDN(api.h.cfg, api.h.cfg.Reloadable().BaseDomain)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response := &v1.PreviewNodeFQDNResponse{
+		GivenName: givenName,
+		Fqdn:      fqdn,
+	}
+	if err := api.h.cfg.FQDNCollision(fqdn); err != nil {
+		response.Collision = err.Error()
+	}
+
+	return response, nil
+}
+
 func (api headscaleV1APIServer) GetRoutes(
 	ctx context.Context,
 	request *v1.GetRoutesRequest,
@@ -506,51 +1486,213 @@ func (api headscaleV1APIServer) GetRoutes(
 		return nil, err
 	}
 
-	return &v1.GetRoutesResponse{
-		Routes: types.Routes(routes).Proto(),
-	}, nil
-}
+	return &v1.GetRoutesResponse{
+		Routes: api.h.routesProto(routes),
+	}, nil
+}
+
+// GetEffectiveRoutes returns the routes grouped by prefix, with the
+// nodes advertising them and the primary route serving each prefix.
+func (api headscaleV1APIServer) GetEffectiveRoutes(
+	ctx context.Context,
+	request *v1.GetEffectiveRoutesRequest,
+) (*v1.GetEffectiveRoutesResponse, error) {
+	routes, err := db.Read(api.h.db.DB, func(rx *gorm.DB) (types.Routes, error) {
+		return db.GetRoutes(rx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := make([]netip.Prefix, 0)
+	byPrefix := types.Routes(routes).PrefixMap()
+	for prefix := range byPrefix {
+		prefixes = append(prefixes, netip.Prefix(prefix))
+	}
+	slices.SortFunc(prefixes, util.ComparePrefix)
+
+	resp := &v1.GetEffectiveRoutesResponse{}
+	for _, prefix := range prefixes {
+		advertisers := byPrefix[types.IPPrefix(prefix)]
+		effective := &v1.EffectiveRoute{
+			Prefix:      prefix.String(),
+			Advertisers: types.Routes(advertisers).Proto(),
+			ExitRoute:   advertisers[0].IsExitRoute(),
+		}
+
+		for i, route := range effective.GetAdvertisers() {
+			route.Node.Online = api.h.nodeNotifier.IsConnected(advertisers[i].Node.ID)
+
+			if route.GetIsPrimary() {
+				effective.Primary = route
+			}
+		}
+
+		resp.Routes = append(resp.Routes, effective)
+	}
+
+	return resp, nil
+}
+
+func (api headscaleV1APIServer) EnableRoute(
+	ctx context.Context,
+	request *v1.EnableRouteRequest,
+) (*v1.EnableRouteResponse, error) {
+	update, err := db.Write(api.routesDB(ctx), func(tx *gorm.DB) (*types.StateUpdate, error) {
+		return db.EnableRoute(tx, request.GetRouteId())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if update != nil {
+		ctx := types.NotifyCtx(ctx, "cli-enableroute", "unknown")
+		api.h.nodeNotifier.NotifyAll(
+			ctx, *update)
+	}
+
+	return &v1.EnableRouteResponse{}, nil
+}
+
+func (api headscaleV1APIServer) DisableRoute(
+	ctx context.Context,
+	request *v1.DisableRouteRequest,
+) (*v1.DisableRouteResponse, error) {
+	update, err := db.Write(api.routesDB(ctx), func(tx *gorm.DB) ([]types.NodeID, error) {
+		return db.DisableRoute(tx, request.GetRouteId(), api.h.nodeNotifier.LikelyConnectedMap())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if update != nil {
+		ctx := types.NotifyCtx(ctx, "cli-disableroute", "unknown")
+		api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type:        types.StatePeerChanged,
+			ChangeNodes: update,
+		})
+	}
+
+	return &v1.DisableRouteResponse{}, nil
+}
+
+func (api headscaleV1APIServer) EnablePrefixRoutes(
+	ctx context.Context,
+	request *v1.EnablePrefixRoutesRequest,
+) (*v1.EnablePrefixRoutesResponse, error) {
+	routes, err := api.setPrefixRoutes(ctx, request.GetPrefix(), request.GetTag(), request.GetUser(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.EnablePrefixRoutesResponse{Routes: routes.Proto()}, nil
+}
+
+func (api headscaleV1APIServer) DisablePrefixRoutes(
+	ctx context.Context,
+	request *v1.DisablePrefixRoutesRequest,
+) (*v1.DisablePrefixRoutesResponse, error) {
+	routes, err := api.setPrefixRoutes(ctx, request.GetPrefix(), request.GetTag(), request.GetUser(), false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.DisablePrefixRoutesResponse{Routes: routes.Proto()}, nil
+}
+
+// setPrefixRoutes enables or disables a prefix on all nodes advertising
+// it, optionally only on nodes with the tag or of the user.
+func (api headscaleV1APIServer) setPrefixRoutes(
+	ctx context.Context,
+	prefixStr, tag, user string,
+	enable bool,
+) (types.Routes, error) {
+	prefix, err := netip.ParsePrefix(prefixStr)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	match := func(node *types.Node) bool {
+		if user != "" && node.User.Name != user {
+			return false
+		}
+
+		if tag != "" && !slices.Contains(node.ForcedTags, tag) {
+			validTags, _ := api.h.ACLPolicy.TagsOfNode(node)
+			if !slices.Contains(validTags, tag) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	var changed []types.NodeID
+	routes, err := db.Write(api.routesDB(ctx), func(tx *gorm.DB) (types.Routes, error) {
+		var routes types.Routes
+		routes, changed, err = db.SetPrefixRoutes(
+			tx,
+			prefix.Masked(),
+			enable,
+			match,
+			api.h.nodeNotifier.LikelyConnectedMap(),
+		)
 
-func (api headscaleV1APIServer) EnableRoute(
-	ctx context.Context,
-	request *v1.EnableRouteRequest,
-) (*v1.EnableRouteResponse, error) {
-	update, err := db.Write(api.h.db.DB, func(tx *gorm.DB) (*types.StateUpdate, error) {
-		return db.EnableRoute(tx, request.GetRouteId())
+		return routes, err
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	if update != nil {
-		ctx := types.NotifyCtx(ctx, "cli-enableroute", "unknown")
-		api.h.nodeNotifier.NotifyAll(
-			ctx, *update)
+	if len(changed) > 0 {
+		ctx := types.NotifyCtx(ctx, "cli-setprefixroutes", prefix.String())
+		api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type:        types.StatePeerChanged,
+			ChangeNodes: changed,
+		})
 	}
 
-	return &v1.EnableRouteResponse{}, nil
+	return routes, nil
 }
 
-func (api headscaleV1APIServer) DisableRoute(
+// routesDB returns the database with the caller of the request as the
+// actor of the route changes recorded in the route history.
+func (api headscaleV1APIServer) routesDB(ctx context.Context) *gorm.DB {
+	return api.h.db.DB.WithContext(types.RouteActorKey.WithValue(ctx, api.h.requestActor(ctx)))
+}
+
+// GetRouteHistory returns the recorded changes of routes, optionally of
+// the routes overlapping a prefix or of a node.
+func (api headscaleV1APIServer) GetRouteHistory(
 	ctx context.Context,
-	request *v1.DisableRouteRequest,
-) (*v1.DisableRouteResponse, error) {
-	update, err := db.Write(api.h.db.DB, func(tx *gorm.DB) ([]types.NodeID, error) {
-		return db.DisableRoute(tx, request.GetRouteId(), api.h.nodeNotifier.LikelyConnectedMap())
-	})
+	request *v1.GetRouteHistoryRequest,
+) (*v1.GetRouteHistoryResponse, error) {
+	var prefix *netip.Prefix
+	if request.GetPrefix() != "" {
+		parsed, err := netip.ParsePrefix(request.GetPrefix())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		parsed = parsed.Masked()
+		prefix = &parsed
+	}
+
+	var since time.Time
+	if request.GetSince() != nil {
+		since = request.GetSince().AsTime()
+	}
+
+	events, err := api.h.db.ListRouteEvents(prefix, types.NodeID(request.GetNodeId()), since)
 	if err != nil {
 		return nil, err
 	}
 
-	if update != nil {
-		ctx := types.NotifyCtx(ctx, "cli-disableroute", "unknown")
-		api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
-			Type:        types.StatePeerChanged,
-			ChangeNodes: update,
-		})
+	resp := &v1.GetRouteHistoryResponse{}
+	for _, event := range events {
+		resp.Events = append(resp.Events, event.Proto())
 	}
 
-	return &v1.DisableRouteResponse{}, nil
+	return resp, nil
 }
 
 func (api headscaleV1APIServer) GetNodeRoutes(
@@ -568,7 +1710,7 @@ func (api headscaleV1APIServer) GetNodeRoutes(
 	}
 
 	return &v1.GetNodeRoutesResponse{
-		Routes: types.Routes(routes).Proto(),
+		Routes: api.h.routesProto(routes),
 	}, nil
 }
 
@@ -577,7 +1719,7 @@ func (api headscaleV1APIServer) DeleteRoute(
 	request *v1.DeleteRouteRequest,
 ) (*v1.DeleteRouteResponse, error) {
 	isConnected := api.h.nodeNotifier.LikelyConnectedMap()
-	update, err := db.Write(api.h.db.DB, func(tx *gorm.DB) ([]types.NodeID, error) {
+	update, err := db.Write(api.routesDB(ctx), func(tx *gorm.DB) ([]types.NodeID, error) {
 		return db.DeleteRoute(tx, request.GetRouteId(), isConnected)
 	})
 	if err != nil {
@@ -604,8 +1746,32 @@ func (api headscaleV1APIServer) CreateApiKey(
 		expiration = request.GetExpiration().AsTime()
 	}
 
+	for _, tag := range request.GetTags() {
+		if err := validateTag(tag); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	derpRegionID := int(request.GetDerpRegionId())
+	if derpRegionID < 0 {
+		return nil, status.Error(codes.InvalidArgument, "DERP region ID must be positive")
+	}
+	if derpRegionID != 0 && len(request.GetTags()) != 0 {
+		return nil, status.Error(codes.InvalidArgument, "API keys are limited either to tags or to a DERP region")
+	}
+
+	caller, err := api.h.apiKeyFromContext(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err := checkAPIKeyNotBroader(caller, request.GetTags(), expiration); err != nil {
+		return nil, err
+	}
+
 	apiKey, _, err := api.h.db.CreateAPIKey(
 		&expiration,
+		request.GetTags(),
+		derpRegionID,
 	)
 	if err != nil {
 		return nil, err
@@ -614,6 +1780,32 @@ func (api headscaleV1APIServer) CreateApiKey(
 	return &v1.CreateApiKeyResponse{ApiKey: apiKey}, nil
 }
 
+// checkAPIKeyNotBroader refuses to create a key with the tags and
+// expiration that is broader than the caller's key, if the caller uses
+// an API key limited to tags. Such a key can only create keys limited
+// to some of its tags that expire no later than itself.
+func checkAPIKeyNotBroader(caller *types.APIKey, tags []string, expiration time.Time) error {
+	if caller == nil || len(caller.Tags) == 0 {
+		return nil
+	}
+
+	if len(tags) == 0 {
+		return status.Error(codes.PermissionDenied, "API keys limited to tags can only create keys limited to tags")
+	}
+
+	for _, tag := range tags {
+		if !caller.CanManageTag(tag) {
+			return status.Errorf(codes.PermissionDenied, "API key is not allowed to manage %s", tag)
+		}
+	}
+
+	if caller.Expiration != nil && (expiration.IsZero() || expiration.After(*caller.Expiration)) {
+		return status.Error(codes.PermissionDenied, "API key cannot create keys expiring after itself")
+	}
+
+	return nil
+}
+
 func (api headscaleV1APIServer) ExpireApiKey(
 	ctx context.Context,
 	request *v1.ExpireApiKeyRequest,
@@ -690,6 +1882,7 @@ func (api headscaleV1APIServer) GetPolicy(
 		return &v1.GetPolicyResponse{
 			Policy:    p.Data,
 			UpdatedAt: timestamppb.New(p.UpdatedAt),
+			Version:   uint64(p.ID),
 		}, nil
 	case types.PolicyModeFile:
 		// Read the file and return the contents as-is.
@@ -716,60 +1909,443 @@ func (api headscaleV1APIServer) SetPolicy(
 	_ context.Context,
 	request *v1.SetPolicyRequest,
 ) (*v1.SetPolicyResponse, error) {
-	if api.h.cfg.Policy.Mode != types.PolicyModeDB {
-		return nil, types.ErrPolicyUpdateIsDisabled
+	updated, err := api.h.updatePolicy(
+		request.GetExpectedVersion(),
+		func([]byte) ([]byte, error) {
+			return []byte(request.GetPolicy()), nil
+		},
+	)
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	response := &v1.SetPolicyResponse{
+		Policy:    updated.Data,
+		UpdatedAt: timestamppb.New(updated.UpdatedAt),
+		Version:   uint64(updated.ID),
 	}
 
-	p := request.GetPolicy()
+	return response, nil
+}
 
-	pol, err := policy.LoadACLPolicyFromBytes([]byte(p))
+func (api headscaleV1APIServer) AddPolicyGroupMembers(
+	_ context.Context,
+	request *v1.AddPolicyGroupMembersRequest,
+) (*v1.AddPolicyGroupMembersResponse, error) {
+	updated, err := api.h.updatePolicy(
+		request.GetExpectedVersion(),
+		func(current []byte) ([]byte, error) {
+			return policy.AddGroupMembers(current, request.GetGroup(), request.GetMembers())
+		},
+	)
 	if err != nil {
-		return nil, fmt.Errorf("loading ACL policy file: %w", err)
+		return nil, policyUpdateError(err)
 	}
 
-	// Validate and reject configuration that would error when applied
-	// when creating a map response. This requires nodes, so there is still
-	// a scenario where they might be allowed if the server has no nodes
-	// yet, but it should help for the general case and for hot reloading
-	// configurations.
-	nodes, err := api.h.db.ListNodes()
+	return &v1.AddPolicyGroupMembersResponse{
+		Policy:    updated.Data,
+		UpdatedAt: timestamppb.New(updated.UpdatedAt),
+		Version:   uint64(updated.ID),
+	}, nil
+}
+
+func (api headscaleV1APIServer) RemovePolicyGroupMembers(
+	_ context.Context,
+	request *v1.RemovePolicyGroupMembersRequest,
+) (*v1.RemovePolicyGroupMembersResponse, error) {
+	updated, err := api.h.updatePolicy(
+		request.GetExpectedVersion(),
+		func(current []byte) ([]byte, error) {
+			return policy.RemoveGroupMembers(current, request.GetGroup(), request.GetMembers())
+		},
+	)
 	if err != nil {
-		return nil, fmt.Errorf("loading nodes from database to validate policy: %w", err)
+		return nil, policyUpdateError(err)
 	}
 
-	_, err = pol.CompileFilterRules(nodes)
+	return &v1.RemovePolicyGroupMembersResponse{
+		Policy:    updated.Data,
+		UpdatedAt: timestamppb.New(updated.UpdatedAt),
+		Version:   uint64(updated.ID),
+	}, nil
+}
+
+func (api headscaleV1APIServer) SetPolicyHost(
+	_ context.Context,
+	request *v1.SetPolicyHostRequest,
+) (*v1.SetPolicyHostResponse, error) {
+	prefix, err := util.ParseIPOrPrefix(request.GetAddress())
 	if err != nil {
-		return nil, fmt.Errorf("verifying policy rules: %w", err)
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
-	if len(nodes) > 0 {
-		_, err = pol.CompileSSHPolicy(nodes[0], nodes)
-		if err != nil {
-			return nil, fmt.Errorf("verifying SSH rules: %w", err)
+	updated, err := api.h.updatePolicy(
+		request.GetExpectedVersion(),
+		func(current []byte) ([]byte, error) {
+			return policy.SetHost(current, request.GetName(), prefix)
+		},
+	)
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return &v1.SetPolicyHostResponse{
+		Policy:    updated.Data,
+		UpdatedAt: timestamppb.New(updated.UpdatedAt),
+		Version:   uint64(updated.ID),
+	}, nil
+}
+
+func (api headscaleV1APIServer) DeletePolicyHost(
+	_ context.Context,
+	request *v1.DeletePolicyHostRequest,
+) (*v1.DeletePolicyHostResponse, error) {
+	updated, err := api.h.updatePolicy(
+		request.GetExpectedVersion(),
+		func(current []byte) ([]byte, error) {
+			return policy.DeleteHost(current, request.GetName())
+		},
+	)
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return &v1.DeletePolicyHostResponse{
+		Policy:    updated.Data,
+		UpdatedAt: timestamppb.New(updated.UpdatedAt),
+		Version:   uint64(updated.ID),
+	}, nil
+}
+
+func (api headscaleV1APIServer) GetPolicyStats(
+	_ context.Context,
+	_ *v1.GetPolicyStatsRequest,
+) (*v1.GetPolicyStatsResponse, error) {
+	stats, usages, err := api.h.policyRuleStats()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &v1.GetPolicyStatsResponse{
+		TrackedSince: timestamppb.New(api.h.policyRuleUsage.trackedSince()),
+	}
+	for index, stat := range stats {
+		rule := &v1.PolicyRuleStats{
+			Index:            uint32(stat.Index),
+			Action:           stat.ACL.Action,
+			Sources:          stat.ACL.Sources,
+			Destinations:     stat.ACL.Destinations,
+			SourceNodes:      uint64(stat.SourceNodes),
+			DestinationNodes: uint64(stat.DestinationNodes),
+			Pairs:            uint64(stat.Pairs),
+			Description:      stat.ACL.Description,
+		}
+		if usage := usages[index]; !usage.LastUsed.IsZero() {
+			rule.LastUsed = timestamppb.New(usage.LastUsed)
 		}
+		if usage := usages[index]; !usage.UnusedSince.IsZero() {
+			rule.UnusedSince = timestamppb.New(usage.UnusedSince)
+		}
+
+		response.Rules = append(response.Rules, rule)
 	}
 
-	updated, err := api.h.db.SetPolicy(p)
+	return response, nil
+}
+
+func (api headscaleV1APIServer) DiffPolicy(
+	_ context.Context,
+	request *v1.DiffPolicyRequest,
+) (*v1.DiffPolicyResponse, error) {
+	nodes, err := api.h.db.ListNodes()
 	if err != nil {
 		return nil, err
 	}
 
-	api.h.ACLPolicy = pol
+	oldPol, err := api.loadPolicyToDiff("old", request.GetOldPolicy())
+	if err != nil {
+		return nil, err
+	}
 
-	ctx := types.NotifyCtx(context.Background(), "acl-update", "na")
-	api.h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
-		Type: types.StateFullUpdate,
-	})
+	newPol, err := api.loadPolicyToDiff("new", request.GetNewPolicy())
+	if err != nil {
+		return nil, err
+	}
 
-	response := &v1.SetPolicyResponse{
-		Policy:    updated.Data,
-		UpdatedAt: timestamppb.New(updated.UpdatedAt),
+	diffs, err := policy.Diff(oldPol, newPol, nodes)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	response := &v1.DiffPolicyResponse{}
+	for _, diff := range diffs {
+		response.Pairs = append(response.Pairs, &v1.PolicyPairDiff{
+			SourceId:        diff.Source.ID.Uint64(),
+			SourceName:      diff.Source.GivenName,
+			DestinationId:   diff.Destination.ID.Uint64(),
+			DestinationName: diff.Destination.GivenName,
+			Change:          diff.Change,
+			AddedPorts:      diff.Added,
+			RemovedPorts:    diff.Removed,
+		})
 	}
 
 	return response, nil
 }
 
+// loadPolicyToDiff loads a policy like headscale loads its own, without
+// rejecting the constructs policy.disallow and policy.strict refuse, as
+// the old policy might still use them.
+func (api headscaleV1APIServer) loadPolicyToDiff(which string, data string) (*policy.ACLPolicy, error) {
+	pol, err := policy.LoadACLPolicyFromBytes([]byte(data))
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "loading the %s policy: %s", which, err)
+	}
+
+	if err := api.h.setUserAliases(pol); err != nil {
+		return nil, err
+	}
+	api.h.setDirectoryGroups(pol)
+
+	return pol, nil
+}
+
+func (api headscaleV1APIServer) StartPolicyRollout(
+	_ context.Context,
+	request *v1.StartPolicyRolloutRequest,
+) (*v1.StartPolicyRolloutResponse, error) {
+	rollout, err := api.h.startPolicyRollout(
+		[]byte(request.GetPolicy()),
+		request.GetCanaryTag(),
+		request.GetDuration().AsDuration(),
+		request.GetProbes(),
+		request.GetExpectedVersion(),
+	)
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return &v1.StartPolicyRolloutResponse{Rollout: api.policyRolloutProto(rollout)}, nil
+}
+
+func (api headscaleV1APIServer) GetPolicyRollout(
+	_ context.Context,
+	_ *v1.GetPolicyRolloutRequest,
+) (*v1.GetPolicyRolloutResponse, error) {
+	rollout, err := api.h.db.GetLatestPolicyRollout()
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return &v1.GetPolicyRolloutResponse{Rollout: api.policyRolloutProto(rollout)}, nil
+}
+
+func (api headscaleV1APIServer) PromotePolicyRollout(
+	_ context.Context,
+	_ *v1.PromotePolicyRolloutRequest,
+) (*v1.PromotePolicyRolloutResponse, error) {
+	rollout, promoted, err := api.h.promotePolicyRollout()
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return &v1.PromotePolicyRolloutResponse{
+		Rollout: api.policyRolloutProto(rollout),
+		Version: uint64(promoted.ID),
+	}, nil
+}
+
+func (api headscaleV1APIServer) RollbackPolicyRollout(
+	ctx context.Context,
+	request *v1.RollbackPolicyRolloutRequest,
+) (*v1.RollbackPolicyRolloutResponse, error) {
+	reason := request.GetReason()
+	if reason == "" {
+		reason = "rolled back by " + api.h.requestActor(ctx)
+	}
+
+	rollout, err := api.h.rollbackPolicyRollout(reason)
+	if err != nil {
+		return nil, policyUpdateError(err)
+	}
+
+	return &v1.RollbackPolicyRolloutResponse{Rollout: api.policyRolloutProto(rollout)}, nil
+}
+
+// policyRolloutProto adds the canary nodes to the rollout in progress.
+func (api headscaleV1APIServer) policyRolloutProto(rollout *types.PolicyRollout) *v1.PolicyRollout {
+	resp := rollout.Proto()
+
+	r := api.h.policyRollout.Load()
+	if r == nil || r.rollout.ID != rollout.ID {
+		return resp
+	}
+
+	nodes, err := api.h.db.ListNodes()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list the canary nodes of the policy rollout")
+
+		return resp
+	}
+	for _, node := range nodes {
+		if api.h.isCanaryNode(r, node) {
+			resp.CanaryNodes = append(resp.CanaryNodes, node.ID.Uint64())
+		}
+	}
+
+	return resp
+}
+
+func (api headscaleV1APIServer) DatabaseGC(
+	_ context.Context,
+	_ *v1.DatabaseGCRequest,
+) (*v1.DatabaseGCResponse, error) {
+	result, err := api.h.garbageCollect()
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.DatabaseGCResponse{
+		OrphanedRoutes:       result.OrphanedRoutes,
+		DeletedRoutes:        result.DeletedRoutes,
+		OrphanedPreAuthTags:  result.OrphanedPreAuthTags,
+		EphemeralNodes:       int64(len(result.EphemeralNodes)),
+		PreAuthKeys:          result.PreAuthKeys,
+		ApiKeys:              result.APIKeys,
+		NetcheckReports:      result.NetcheckReports,
+		UserAliases:          result.UserAliases,
+		RouteEvents:          result.RouteEvents,
+		PendingRegistrations: result.PendingRegistrations,
+	}, nil
+}
+
+func (api headscaleV1APIServer) GetLogLevels(
+	_ context.Context,
+	_ *v1.GetLogLevelsRequest,
+) (*v1.GetLogLevelsResponse, error) {
+	return &v1.GetLogLevelsResponse{Levels: logLevelsProto()}, nil
+}
+
+func (api headscaleV1APIServer) SetLogLevel(
+	_ context.Context,
+	request *v1.SetLogLevelRequest,
+) (*v1.SetLogLevelResponse, error) {
+	subsystem, err := util.ParseLogSubsystem(request.GetSubsystem())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	level, err := zerolog.ParseLevel(request.GetLevel())
+	if err != nil || level == zerolog.NoLevel {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid log level %q", request.GetLevel())
+	}
+
+	util.SetLogLevel(subsystem, level)
+
+	log.Info().
+		Str("subsystem", string(subsystem)).
+		Stringer("level", level).
+		Msg("Log level changed")
+
+	return &v1.SetLogLevelResponse{Levels: logLevelsProto()}, nil
+}
+
+// logLevelsProto returns the default log level, then the levels of the
+// subsystems.
+func logLevelsProto() []*v1.LogLevel {
+	levels := []*v1.LogLevel{{Level: util.LogLevel(util.LogSubsystemDefault).String()}}
+	for _, subsystem := range util.LogSubsystems {
+		levels = append(levels, &v1.LogLevel{
+			Subsystem: string(subsystem),
+			Level:     util.LogLevel(subsystem).String(),
+		})
+	}
+
+	return levels
+}
+
+// policyUpdateError returns the gRPC status for an error updating the
+// policy.
+func policyUpdateError(err error) error {
+	switch {
+	case errors.Is(err, types.ErrPolicyVersionMismatch):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, types.ErrPolicyUpdateIsDisabled), errors.Is(err, types.ErrPolicyRolloutInProgress):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, types.ErrPolicyRolloutNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, policy.ErrGroupNotFound), errors.Is(err, policy.ErrHostNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, policy.ErrInvalidGroup), errors.Is(err, policy.ErrFeatureDisallowed),
+		errors.Is(err, policy.ErrStrictPolicy), errors.Is(err, ErrInvalidPolicyRollout):
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return err
+}
+
 // The following service calls are for testing and debugging
+func (api headscaleV1APIServer) DebugConnectivityMatrix(
+	ctx context.Context,
+	request *v1.DebugConnectivityMatrixRequest,
+) (*v1.DebugConnectivityMatrixResponse, error) {
+	nodes, err := api.h.db.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := api.h.ACLPolicy.CompileFilterRules(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	allowedBy, err := api.h.ACLPolicy.RulesAllowing(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := nodes
+	if len(request.GetNodeIds()) > 0 {
+		byID := nodes.IDMap()
+		selected = nil
+
+		for _, id := range request.GetNodeIds() {
+			node, ok := byID[types.NodeID(id)]
+			if !ok {
+				return nil, status.Errorf(codes.NotFound, "node %d not found", id)
+			}
+			selected = append(selected, node)
+		}
+	}
+
+	return &v1.DebugConnectivityMatrixResponse{
+		Checks: connectivityMatrix(selected, filter, allowedBy, api.h.nodeNotifier.IsConnected),
+	}, nil
+}
+
+func (api headscaleV1APIServer) DebugProfile(
+	ctx context.Context,
+	request *v1.DebugProfileRequest,
+) (*v1.DebugProfileResponse, error) {
+	if !api.h.cfg.Debug.ProfilingEnabled {
+		return nil, status.Error(
+			codes.FailedPrecondition,
+			"profiling is disabled, enable it with debug.profiling_enabled",
+		)
+	}
+
+	data, err := collectProfile(ctx, request.GetProfile(), request.GetDuration().AsDuration())
+	if err != nil {
+		if errors.Is(err, errUnknownProfile) || errors.Is(err, errProfileDuration) {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		return nil, err
+	}
+
+	return &v1.DebugProfileResponse{Data: data}, nil
+}
+
 func (api headscaleV1APIServer) DebugCreateNode(
 	ctx context.Context,
 	request *v1.DebugCreateNodeRequest,
@@ -820,11 +2396,7 @@ func (api headscaleV1APIServer) DebugCreateNode(
 		Str("machine_key", mkey.ShortString()).
 		Msg("adding debug machine via CLI, appending to registration cache")
 
-	api.h.registrationCache.Set(
-		mkey.String(),
-		newNode,
-		registerCacheExpiration,
-	)
+	api.h.setPendingRegistration(mkey, newNode)
 
 	return &v1.DebugCreateNodeResponse{Node: newNode.Proto()}, nil
 }
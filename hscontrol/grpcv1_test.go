@@ -1,6 +1,32 @@
 package hscontrol
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/derp"
+	"github.com/juanfont/headscale/hscontrol/notifier"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
 
 func Test_validateTag(t *testing.T) {
 	type args struct {
@@ -40,3 +66,1152 @@ func Test_validateTag(t *testing.T) {
 		})
 	}
 }
+
+// newTestAPIServer returns a headscale with a new database, and its
+// API server.
+func newTestAPIServer(t *testing.T, cfg *types.Config) (*Headscale, v1.HeadscaleServiceServer) {
+	t.Helper()
+
+	hsdb, err := db.NewHeadscaleDatabase(
+		types.DatabaseConfig{
+			Type: "sqlite3",
+			Sqlite: types.SqliteConfig{
+				Path: t.TempDir() + "/headscale_test.db",
+			},
+		},
+		"",
+	)
+	if err != nil {
+		t.Fatalf("creating database: %s", err)
+	}
+
+	cfg.Tuning.BatchChangeDelay = time.Second
+	h := &Headscale{
+		cfg:          cfg,
+		db:           hsdb,
+		nodeNotifier: notifier.NewNotifier(cfg),
+	}
+	t.Cleanup(h.nodeNotifier.Close)
+
+	return h, newHeadscaleV1APIServer(h)
+}
+
+// createTestNode saves node as a node of the user named userName,
+// creating the user if it does not exist yet. The keys, the given name
+// and the register method are filled in when node leaves them empty.
+func createTestNode(t *testing.T, h *Headscale, userName string, node *types.Node) *types.Node {
+	t.Helper()
+
+	_, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		user, err := db.GetUser(tx, userName)
+		if errors.Is(err, db.ErrUserNotFound) {
+			user, err = db.CreateUser(tx, userName)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		node.UserID = user.ID
+		if node.MachineKey.IsZero() {
+			node.MachineKey = key.NewMachine().Public()
+		}
+		if node.NodeKey.IsZero() {
+			node.NodeKey = key.NewNode().Public()
+		}
+		if node.GivenName == "" {
+			node.GivenName = node.Hostname
+		}
+		if node.RegisterMethod == "" {
+			node.RegisterMethod = util.RegisterMethodCLI
+		}
+
+		return node, tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node %s: %s", node.Hostname, err)
+	}
+
+	return node
+}
+
+func TestCreateUserTakesOverAlias(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{UserAliasExpiry: time.Hour})
+	h.ACLPolicy = &policy.ACLPolicy{}
+
+	ctx := context.Background()
+
+	if _, err := api.CreateUser(ctx, &v1.CreateUserRequest{Name: "joe"}); err != nil {
+		t.Fatalf("CreateUser() error = %s", err)
+	}
+	if _, err := api.RenameUser(ctx, &v1.RenameUserRequest{OldName: "joe", NewName: "joseph"}); err != nil {
+		t.Fatalf("RenameUser() error = %s", err)
+	}
+	if _, ok := h.ACLPolicy.UserAliases["joe"]; !ok {
+		t.Fatalf("the policy does not resolve joe to the renamed user")
+	}
+
+	if _, err := api.CreateUser(ctx, &v1.CreateUserRequest{Name: "joe"}); err != nil {
+		t.Fatalf("CreateUser() with the old name error = %s", err)
+	}
+	if alias, ok := h.ACLPolicy.UserAliases["joe"]; ok {
+		t.Errorf("the policy still resolves joe to %s after a new joe was created", alias.User.Name)
+	}
+}
+
+func TestPolicyGroupAndHostEdits(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{
+		Policy: types.PolicyConfig{Mode: types.PolicyModeDB},
+	})
+
+	ctx := context.Background()
+
+	set, err := api.SetPolicy(ctx, &v1.SetPolicyRequest{
+		Policy: `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`,
+	})
+	if err != nil {
+		t.Fatalf("SetPolicy() error = %s", err)
+	}
+
+	added, err := api.AddPolicyGroupMembers(ctx, &v1.AddPolicyGroupMembersRequest{
+		Group:           "group:admin",
+		Members:         []string{"alice", "bob"},
+		ExpectedVersion: set.GetVersion(),
+	})
+	if err != nil {
+		t.Fatalf("AddPolicyGroupMembers() error = %s", err)
+	}
+
+	if got := h.ACLPolicy.Groups["group:admin"]; len(got) != 2 {
+		t.Errorf("group:admin = %v, want alice and bob", got)
+	}
+
+	_, err = api.SetPolicyHost(ctx, &v1.SetPolicyHostRequest{
+		Name:            "db",
+		Address:         "10.0.0.10",
+		ExpectedVersion: set.GetVersion(),
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("SetPolicyHost() with an old version error = %v, want %s", err, codes.Aborted)
+	}
+
+	host, err := api.SetPolicyHost(ctx, &v1.SetPolicyHostRequest{
+		Name:            "db",
+		Address:         "10.0.0.10",
+		ExpectedVersion: added.GetVersion(),
+	})
+	if err != nil {
+		t.Fatalf("SetPolicyHost() error = %s", err)
+	}
+
+	if _, ok := h.ACLPolicy.Hosts["db"]; !ok {
+		t.Errorf("host db is missing from the policy")
+	}
+
+	_, err = api.RemovePolicyGroupMembers(ctx, &v1.RemovePolicyGroupMembersRequest{
+		Group:   "group:dev",
+		Members: []string{"alice"},
+	})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("RemovePolicyGroupMembers() of an unknown group error = %v, want %s", err, codes.NotFound)
+	}
+
+	got, err := api.GetPolicy(ctx, &v1.GetPolicyRequest{})
+	if err != nil {
+		t.Fatalf("GetPolicy() error = %s", err)
+	}
+
+	if got.GetVersion() != host.GetVersion() {
+		t.Errorf("GetPolicy() version = %d, want %d", got.GetVersion(), host.GetVersion())
+	}
+}
+
+func TestSetPolicyStrict(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{
+		Policy: types.PolicyConfig{Mode: types.PolicyModeDB, Strict: true},
+	})
+
+	ctx := context.Background()
+
+	_, err := api.SetPolicy(ctx, &v1.SetPolicyRequest{
+		Policy: `{"Hosts": {"db": "10.0.0.10"}, "acls": [{"action": "accept", "src": ["*"], "dst": ["db:5432"]}]}`,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("SetPolicy() with a miscased field error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	if h.ACLPolicy != nil {
+		t.Errorf("the rejected policy was loaded")
+	}
+
+	_, err = api.SetPolicy(ctx, &v1.SetPolicyRequest{
+		Policy: `{"hosts": {"db": "10.0.0.10"}, "acls": [{"action": "accept", "src": ["*"], "dst": ["db:5432"]}]}`,
+	})
+	if err != nil {
+		t.Fatalf("SetPolicy() error = %s", err)
+	}
+}
+
+func TestDiffPolicy(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		for _, name := range []string{"alice", "bob"} {
+			user, err := db.CreateUser(tx, name)
+			if err != nil {
+				return err
+			}
+
+			ip := netip.MustParseAddr("100.64.0.1")
+			if name == "bob" {
+				ip = netip.MustParseAddr("100.64.0.2")
+			}
+			node := &types.Node{
+				Hostname:       name + "-laptop",
+				GivenName:      name + "-laptop",
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+				IPv4:           &ip,
+			}
+			if err := tx.Save(node).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	ctx := context.Background()
+
+	resp, err := api.DiffPolicy(ctx, &v1.DiffPolicyRequest{
+		OldPolicy: `{"acls": [{"action": "accept", "src": ["alice"], "dst": ["bob:22"]}]}`,
+		NewPolicy: `{"acls": [{"action": "accept", "src": ["alice"], "dst": ["bob:22,443"]}]}`,
+	})
+	if err != nil {
+		t.Fatalf("DiffPolicy() error = %s", err)
+	}
+
+	want := []*v1.PolicyPairDiff{{
+		SourceId:        1,
+		SourceName:      "alice-laptop",
+		DestinationId:   2,
+		DestinationName: "bob-laptop",
+		Change:          policy.PairChanged,
+		AddedPorts:      []string{"443"},
+	}}
+	if diff := cmp.Diff(want, resp.GetPairs(), protocmp.Transform()); diff != "" {
+		t.Errorf("DiffPolicy() unexpected result (-want +got):\n%s", diff)
+	}
+
+	_, err = api.DiffPolicy(ctx, &v1.DiffPolicyRequest{
+		OldPolicy: `{"acls": [}`,
+		NewPolicy: `{}`,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("DiffPolicy() of an invalid policy error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestChangeTags(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+	h.ACLPolicy = &policy.ACLPolicy{
+		TagOwners: policy.TagOwners{
+			"tag:router": {"alice"},
+			"tag:server": {"alice"},
+			"tag:other":  {"bob"},
+		},
+	}
+
+	node, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return nil, err
+		}
+
+		node := &types.Node{
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			Hostname:       "router",
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+		}
+
+		return node, tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	keyStr, _, err := h.db.CreateAPIKey(nil, []string{"tag:router"}, 0)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %s", err)
+	}
+	scoped := metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs("authorization", AuthPrefix+keyStr),
+	)
+
+	nodeID := uint64(node.ID)
+
+	resp, err := api.AddTag(scoped, &v1.AddTagRequest{NodeId: nodeID, Tag: "tag:router"})
+	if err != nil {
+		t.Fatalf("AddTag() error = %s", err)
+	}
+	if diff := cmp.Diff([]string{"tag:router"}, resp.GetNode().GetForcedTags()); diff != "" {
+		t.Errorf("AddTag() tags mismatch (-want +got):\n%s", diff)
+	}
+
+	_, err = api.AddTag(scoped, &v1.AddTagRequest{NodeId: nodeID, Tag: "tag:server"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("AddTag() outside of the key scope error = %v, want %s", err, codes.PermissionDenied)
+	}
+
+	_, err = api.AddTag(context.Background(), &v1.AddTagRequest{NodeId: nodeID, Tag: "tag:other"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("AddTag() of a tag owned by another user error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	_, err = api.AddTag(context.Background(), &v1.AddTagRequest{NodeId: nodeID, Tag: "tag:other", Force: true})
+	if err != nil {
+		t.Fatalf("AddTag() with force error = %s", err)
+	}
+
+	_, err = api.SetTags(scoped, &v1.SetTagsRequest{NodeId: nodeID, Tags: []string{"tag:router"}})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("SetTags() removing a tag outside of the key scope error = %v, want %s", err, codes.PermissionDenied)
+	}
+
+	removed, err := api.RemoveTag(scoped, &v1.RemoveTagRequest{NodeId: nodeID, Tag: "tag:router"})
+	if err != nil {
+		t.Fatalf("RemoveTag() error = %s", err)
+	}
+	if diff := cmp.Diff([]string{"tag:other"}, removed.GetNode().GetForcedTags()); diff != "" {
+		t.Errorf("RemoveTag() tags mismatch (-want +got):\n%s", diff)
+	}
+
+	// The REST API reaches the handlers through the unix socket.
+	handler := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+	for method, want := range map[string]codes.Code{
+		v1.HeadscaleService_SetTags_FullMethodName:    codes.OK,
+		v1.HeadscaleService_ListNodes_FullMethodName:  codes.PermissionDenied,
+		v1.HeadscaleService_CreateUser_FullMethodName: codes.PermissionDenied,
+	} {
+		_, err := h.apiKeyScopeInterceptor(scoped, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if status.Code(err) != want {
+			t.Errorf("calling %s with a key limited to tags error = %v, want %s", method, err, want)
+		}
+	}
+
+	for _, tags := range [][]string{nil, {"tag:router", "tag:server"}} {
+		_, err = api.CreateApiKey(scoped, &v1.CreateApiKeyRequest{Tags: tags})
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("CreateApiKey(%v) with a key limited to tags error = %v, want %s", tags, err, codes.PermissionDenied)
+		}
+	}
+	if _, err := api.CreateApiKey(scoped, &v1.CreateApiKeyRequest{Tags: []string{"tag:router"}}); err != nil {
+		t.Errorf("CreateApiKey() within the key scope error = %s", err)
+	}
+}
+
+func TestGetEffectiveRoutes(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	prefix := netip.MustParsePrefix("10.0.0.0/24")
+
+	_, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.StateUpdate, error) {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, hostname := range []string{"router1", "router2"} {
+			node := &types.Node{
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				Hostname:       hostname,
+				GivenName:      hostname,
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+				Hostinfo:       &tailcfg.Hostinfo{RoutableIPs: []netip.Prefix{prefix}},
+			}
+			if err := tx.Save(node).Error; err != nil {
+				return nil, err
+			}
+
+			if _, err := db.SaveNodeRoutes(tx, node); err != nil {
+				return nil, err
+			}
+
+			routes, err := db.GetNodeRoutes(tx, node)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, err := db.EnableRoute(tx, uint64(routes[0].ID)); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("setting up routes: %s", err)
+	}
+
+	resp, err := api.GetEffectiveRoutes(context.Background(), &v1.GetEffectiveRoutesRequest{})
+	if err != nil {
+		t.Fatalf("GetEffectiveRoutes() error = %s", err)
+	}
+
+	if len(resp.GetRoutes()) != 1 {
+		t.Fatalf("GetEffectiveRoutes() = %d prefixes, want 1", len(resp.GetRoutes()))
+	}
+
+	route := resp.GetRoutes()[0]
+	if route.GetPrefix() != prefix.String() || len(route.GetAdvertisers()) != 2 {
+		t.Errorf("GetEffectiveRoutes() = %s with %d advertisers, want %s with 2", route.GetPrefix(), len(route.GetAdvertisers()), prefix)
+	}
+
+	primary := route.GetPrimary()
+	if primary.GetNode().GetGivenName() != "router1" {
+		t.Errorf("primary = %q, want router1", primary.GetNode().GetGivenName())
+	}
+
+	if primary.GetPrimaryReason() == "" || primary.GetPrimaryChangedAt() == nil {
+		t.Errorf("primary route does not say why or since when it is primary")
+	}
+}
+
+func TestListNodesFilters(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+	h.ACLPolicy = &policy.ACLPolicy{
+		TagOwners: policy.TagOwners{"tag:server": {"alice"}},
+	}
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		for _, node := range []*types.Node{
+			{Hostname: "old", ForcedTags: []string{"tag:server"}, Hostinfo: &tailcfg.Hostinfo{IPNVersion: "1.60.0-t1234"}},
+			{Hostname: "new", Hostinfo: &tailcfg.Hostinfo{IPNVersion: "1.72.1-tabcd", RequestTags: []string{"tag:server"}}},
+			{Hostname: "unknown"},
+		} {
+			node.MachineKey = key.NewMachine().Public()
+			node.NodeKey = key.NewNode().Public()
+			node.UserID = user.ID
+			node.RegisterMethod = util.RegisterMethodCLI
+			if err := tx.Save(node).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	online, offline := true, false
+	tests := []struct {
+		name    string
+		request *v1.ListNodesRequest
+		want    []string
+	}{
+		{
+			name:    "all",
+			request: &v1.ListNodesRequest{},
+			want:    []string{"old", "new", "unknown"},
+		},
+		{
+			name:    "forced or allowed tag",
+			request: &v1.ListNodesRequest{Tag: "tag:server"},
+			want:    []string{"old", "new"},
+		},
+		{
+			name:    "older client",
+			request: &v1.ListNodesRequest{ClientVersionOlderThan: "1.66"},
+			want:    []string{"old"},
+		},
+		{
+			name:    "online",
+			request: &v1.ListNodesRequest{Online: &online},
+			want:    nil,
+		},
+		{
+			name:    "offline of user",
+			request: &v1.ListNodesRequest{User: "alice", Online: &offline, Tag: "tag:server"},
+			want:    []string{"old", "new"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := api.ListNodes(context.Background(), tt.request)
+			if err != nil {
+				t.Fatalf("ListNodes() error = %s", err)
+			}
+
+			var got []string
+			for _, node := range resp.GetNodes() {
+				got = append(got, node.GetName())
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ListNodes() nodes mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestListNodesPagination(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		for _, hostname := range []string{"c", "e", "a", "d", "b"} {
+			node := &types.Node{
+				Hostname:       hostname,
+				GivenName:      hostname,
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+			}
+			if err := tx.Save(node).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	ctx := context.Background()
+	request := &v1.ListNodesRequest{PageSize: 2, OrderBy: "name desc"}
+
+	var (
+		got   []string
+		pages int
+	)
+	for {
+		resp, err := api.ListNodes(ctx, request)
+		if err != nil {
+			t.Fatalf("ListNodes() error = %s", err)
+		}
+		pages++
+
+		for _, node := range resp.GetNodes() {
+			got = append(got, node.GetGivenName())
+		}
+
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		request.PageToken = resp.GetNextPageToken()
+	}
+
+	if diff := cmp.Diff([]string{"e", "d", "c", "b", "a"}, got); diff != "" || pages != 3 {
+		t.Errorf("ListNodes() pages = %d, nodes mismatch (-want +got):\n%s", pages, diff)
+	}
+
+	// A token only continues the query it was returned for.
+	first, err := api.ListNodes(ctx, &v1.ListNodesRequest{PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListNodes() error = %s", err)
+	}
+	_, err = api.ListNodes(ctx, &v1.ListNodesRequest{PageSize: 2, OrderBy: "hostname", PageToken: first.GetNextPageToken()})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListNodes() with the token of another query error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	_, err = api.ListNodes(ctx, &v1.ListNodesRequest{OrderBy: "ip_addresses"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListNodes() ordered by an unknown field error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	// Only the fields of the read mask are returned.
+	masked, err := api.ListNodes(ctx, &v1.ListNodesRequest{
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"id", "given_name", "user.name"}},
+	})
+	if err != nil {
+		t.Fatalf("ListNodes() with read mask error = %s", err)
+	}
+	node := masked.GetNodes()[0]
+	if node.GetId() == 0 || node.GetGivenName() == "" || node.GetUser().GetName() != "alice" {
+		t.Errorf("masked node = %v, want id, given_name and user.name", node)
+	}
+	if node.GetName() != "" || node.GetNodeKey() != "" || node.GetUser().GetId() != "" || node.GetCreatedAt() != nil {
+		t.Errorf("masked node = %v, want the other fields cleared", node)
+	}
+
+	_, err = api.ListNodes(ctx, &v1.ListNodesRequest{
+		ReadMask: &fieldmaskpb.FieldMask{Paths: []string{"secret"}},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ListNodes() with an unknown read mask field error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	// Clients not paginating still get all nodes.
+	all, err := api.ListNodes(ctx, &v1.ListNodesRequest{})
+	if err != nil || len(all.GetNodes()) != 5 || all.GetNextPageToken() != "" {
+		t.Errorf("ListNodes() = %d nodes, next page %q, %v, want all 5 nodes", len(all.GetNodes()), all.GetNextPageToken(), err)
+	}
+}
+
+func TestRevokeNode(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	var lost, laptop *types.Node
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		for _, node := range []**types.Node{&lost, &laptop} {
+			*node = &types.Node{
+				Hostname:       "node",
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+			}
+			if err := tx.Save(*node).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	if _, err := api.RevokeNode(context.Background(), &v1.RevokeNodeRequest{NodeId: uint64(lost.ID)}); err != nil {
+		t.Fatalf("RevokeNode() error = %s", err)
+	}
+
+	got, err := h.db.GetNodeByID(lost.ID)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if !got.IsExpired() {
+		t.Errorf("revoked node is not expired")
+	}
+
+	// The revoked node is nobody's peer anymore.
+	peers, err := h.db.ListPeers(laptop.ID)
+	if err != nil {
+		t.Fatalf("ListPeers() error = %s", err)
+	}
+	if len(peers) != 0 {
+		t.Errorf("ListPeers() = %d peers, want the revoked node left out", len(peers))
+	}
+
+	for _, k := range []string{lost.NodeKey.String(), lost.MachineKey.String()} {
+		if revoked, err := h.db.IsKeyRevoked(k); err != nil || !revoked {
+			t.Errorf("IsKeyRevoked(%s) = %t, %v, want revoked", k, revoked, err)
+		}
+	}
+
+	if !h.derpBanned(lost.NodeKey) {
+		t.Errorf("revoked node key is not banned from DERP")
+	}
+	if h.derpBanned(laptop.NodeKey) {
+		t.Errorf("node key of another node is banned from DERP")
+	}
+}
+
+func TestDERPBannedByRestriction(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{
+		DERP: types.DERPConfig{ServerRegionID: 999},
+	})
+	h.ACLPolicy = &policy.ACLPolicy{
+		DERPRestrictions: []policy.DERPRestriction{
+			{Sources: []string{"sovereign"}, Regions: []int{900}},
+			{Sources: []string{"local"}, Regions: []int{999}},
+		},
+	}
+
+	nodes := make(map[string]*types.Node)
+	err := h.db.Write(func(tx *gorm.DB) error {
+		for _, name := range []string{"sovereign", "local", "other"} {
+			user, err := db.CreateUser(tx, name)
+			if err != nil {
+				return err
+			}
+
+			node := &types.Node{
+				Hostname:       name,
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+			}
+			if err := tx.Save(node).Error; err != nil {
+				return err
+			}
+			nodes[name] = node
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	for name, want := range map[string]bool{"sovereign": true, "local": false, "other": false} {
+		if got := h.derpBanned(nodes[name].NodeKey); got != want {
+			t.Errorf("derpBanned(%s) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestSetNodeNote(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	var node *types.Node
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		node = &types.Node{
+			Hostname:       "laptop",
+			GivenName:      "laptop",
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+		}
+
+		return tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	ctx := context.Background()
+	resp, err := api.SetNodeNote(ctx, &v1.SetNodeNoteRequest{
+		NodeId: uint64(node.ID),
+		Note:   " loaner laptop, return by May ",
+	})
+	if err != nil {
+		t.Fatalf("SetNodeNote() error = %s", err)
+	}
+	if got := resp.GetNode().GetNote(); got != "loaner laptop, return by May" {
+		t.Errorf("SetNodeNote() note = %q, want it trimmed", got)
+	}
+
+	listed, err := api.ListNodes(ctx, &v1.ListNodesRequest{})
+	if err != nil {
+		t.Fatalf("ListNodes() error = %s", err)
+	}
+	if got := listed.GetNodes()[0].GetNote(); got != "loaner laptop, return by May" {
+		t.Errorf("ListNodes() note = %q, want the note", got)
+	}
+
+	found, err := api.Search(ctx, &v1.SearchRequest{Query: "loaner"})
+	if err != nil {
+		t.Fatalf("Search() error = %s", err)
+	}
+	if len(found.GetResults()) != 1 || found.GetResults()[0].GetField() != "note" {
+		t.Errorf("Search() by note = %v, want the node found by its note", found.GetResults())
+	}
+
+	_, err = api.SetNodeNote(ctx, &v1.SetNodeNoteRequest{
+		NodeId: uint64(node.ID),
+		Note:   strings.Repeat("x", db.MaxNodeNoteLength+1),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SetNodeNote() with a long note error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	resp, err = api.SetNodeNote(ctx, &v1.SetNodeNoteRequest{NodeId: uint64(node.ID)})
+	if err != nil || resp.GetNode().GetNote() != "" {
+		t.Errorf("SetNodeNote() without note = %v, %v, want the note removed", resp.GetNode(), err)
+	}
+}
+
+func TestSetNodeDERPHome(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+	h.derpMap.Store(&tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			900: {RegionID: 900, RegionCode: "dc"},
+		},
+	})
+
+	var node *types.Node
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "ci")
+		if err != nil {
+			return err
+		}
+
+		node = &types.Node{
+			Hostname:       "runner",
+			GivenName:      "runner",
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+		}
+
+		return tx.Save(node).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	ctx := context.Background()
+	resp, err := api.SetNodeDERPHome(ctx, &v1.SetNodeDERPHomeRequest{NodeId: uint64(node.ID), RegionId: 900})
+	if err != nil {
+		t.Fatalf("SetNodeDERPHome() error = %s", err)
+	}
+	if got := resp.GetNode().GetDerpHomeRegion(); got != 900 {
+		t.Errorf("SetNodeDERPHome() region = %d, want 900", got)
+	}
+
+	got, err := h.db.GetNodeByID(node.ID)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if got.DERPHomeRegion != 900 {
+		t.Errorf("stored DERP home region = %d, want 900", got.DERPHomeRegion)
+	}
+
+	for _, region := range []int32{-1, 901} {
+		_, err = api.SetNodeDERPHome(ctx, &v1.SetNodeDERPHomeRequest{NodeId: uint64(node.ID), RegionId: region})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("SetNodeDERPHome() to region %d error = %v, want %s", region, err, codes.InvalidArgument)
+		}
+	}
+
+	resp, err = api.SetNodeDERPHome(ctx, &v1.SetNodeDERPHomeRequest{NodeId: uint64(node.ID)})
+	if err != nil || resp.GetNode().GetDerpHomeRegion() != 0 {
+		t.Errorf("SetNodeDERPHome() without region = %v, %v, want the pin removed", resp.GetNode(), err)
+	}
+}
+
+func TestSetNodeService(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	var tagged, untagged *types.Node
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "admin")
+		if err != nil {
+			return err
+		}
+
+		tagged = &types.Node{
+			Hostname:       "backup-1",
+			GivenName:      "backup-1",
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+			ForcedTags:     []string{"tag:backup"},
+		}
+		untagged = &types.Node{
+			Hostname:       "laptop",
+			GivenName:      "laptop",
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+		}
+		if err := tx.Save(tagged).Error; err != nil {
+			return err
+		}
+
+		return tx.Save(untagged).Error
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	ctx := context.Background()
+	_, err = api.SetNodeService(ctx, &v1.SetNodeServiceRequest{NodeId: uint64(tagged.ID), Service: "backup"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("SetNodeService() to an unknown service error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	if _, err := api.CreateServiceAccount(ctx, &v1.CreateServiceAccountRequest{Name: "backup"}); err != nil {
+		t.Fatalf("CreateServiceAccount() error = %s", err)
+	}
+	if _, err := api.CreateServiceAccount(ctx, &v1.CreateServiceAccountRequest{Name: "backup"}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("CreateServiceAccount() twice error = %v, want %s", err, codes.AlreadyExists)
+	}
+
+	_, err = api.SetNodeService(ctx, &v1.SetNodeServiceRequest{NodeId: uint64(untagged.ID), Service: "backup"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("SetNodeService() of an untagged node error = %v, want %s", err, codes.FailedPrecondition)
+	}
+
+	resp, err := api.SetNodeService(ctx, &v1.SetNodeServiceRequest{NodeId: uint64(tagged.ID), Service: "backup"})
+	if err != nil {
+		t.Fatalf("SetNodeService() error = %s", err)
+	}
+	if got := resp.GetNode().GetService(); got != "backup" {
+		t.Errorf("SetNodeService() service = %q, want %q", got, "backup")
+	}
+
+	_, err = api.DeleteServiceAccount(ctx, &v1.DeleteServiceAccountRequest{Name: "backup"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DeleteServiceAccount() in use error = %v, want %s", err, codes.FailedPrecondition)
+	}
+}
+
+func TestPreviewNodeFQDN(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{
+		BaseDomain:            "example.com",
+		DNSUserNameInMagicDNS: true,
+		DNSConfig: &tailcfg.DNSConfig{
+			Domains: []string{"example.com", "corp.net", "lab.example.com"},
+			ExtraRecords: []tailcfg.DNSRecord{
+				{Name: "grafana.alice.example.com", Type: "A", Value: "100.64.0.3"},
+				{Name: "office.example.com", Type: "A", Value: "100.64.0.4"},
+			},
+		},
+	})
+
+	for _, name := range []string{"office", "lab"} {
+		_, err := api.CreateUser(context.Background(), &v1.CreateUserRequest{Name: name})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("CreateUser(%s) error = %v, want %s", name, err, codes.InvalidArgument)
+		}
+	}
+
+	if _, err := api.CreateUser(context.Background(), &v1.CreateUserRequest{Name: "alice"}); err != nil {
+		t.Fatalf("CreateUser(alice) error = %s", err)
+	}
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.GetUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(&types.Node{
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			Hostname:       "laptop",
+			GivenName:      "laptop",
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodCLI,
+		}).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	resp, err := api.PreviewNodeFQDN(context.Background(), &v1.PreviewNodeFQDNRequest{User: "alice", Hostname: "Desktop"})
+	if err != nil {
+		t.Fatalf("PreviewNodeFQDN(Desktop) error = %s", err)
+	}
+	if resp.GetFqdn() != "desktop.alice.example.com" || resp.GetCollision() != "" {
+		t.Errorf("PreviewNodeFQDN(Desktop) = %v", resp)
+	}
+
+	resp, err = api.PreviewNodeFQDN(context.Background(), &v1.PreviewNodeFQDNRequest{User: "alice", Hostname: "laptop"})
+	if err != nil {
+		t.Fatalf("PreviewNodeFQDN(laptop) error = %s", err)
+	}
+	if !strings.HasPrefix(resp.GetGivenName(), "laptop-") {
+		t.Errorf("PreviewNodeFQDN(laptop) given name = %q, want a suffix", resp.GetGivenName())
+	}
+
+	resp, err = api.PreviewNodeFQDN(context.Background(), &v1.PreviewNodeFQDNRequest{User: "alice", Hostname: "grafana"})
+	if err != nil {
+		t.Fatalf("PreviewNodeFQDN(grafana) error = %s", err)
+	}
+	if resp.GetCollision() == "" {
+		t.Errorf("PreviewNodeFQDN(grafana) = %v, want a collision with the extra record", resp)
+	}
+
+	_, err = api.PreviewNodeFQDN(context.Background(), &v1.PreviewNodeFQDNRequest{Hostname: "grafana"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("PreviewNodeFQDN() without user error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
+
+func TestDERPRelays(t *testing.T) {
+	derpMapPath := t.TempDir() + "/derp.yaml"
+	err := os.WriteFile(derpMapPath, []byte(`
+regions:
+  1:
+    regionid: 1
+    regioncode: home
+    nodes:
+      - name: 1a
+        regionid: 1
+        hostname: derp.example.com
+`), 0o600)
+	if err != nil {
+		t.Fatalf("writing DERP map: %s", err)
+	}
+
+	h, api := newTestAPIServer(t, &types.Config{
+		DERP: types.DERPConfig{
+			Paths:         []string{derpMapPath},
+			RelaysEnabled: true,
+			RelayExpiry:   time.Minute,
+		},
+	})
+	h.derpMap.Store(derp.GetDERPMap(h.cfg.DERP))
+
+	ctx := context.Background()
+	relay := &v1.DERPRelay{
+		RegionId:   900,
+		RegionCode: "fra",
+		RegionName: "Frankfurt",
+		Hostname:   "fra.derp.example.com",
+		DerpPort:   443,
+		StunPort:   3478,
+	}
+
+	_, err = api.RegisterDERPRelay(ctx, &v1.RegisterDERPRelayRequest{
+		Relay: &v1.DERPRelay{RegionId: 1, Hostname: "other.example.com"},
+	})
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("RegisterDERPRelay() for a configured region error = %v, want %s", err, codes.AlreadyExists)
+	}
+
+	_, err = api.RegisterDERPRelay(ctx, &v1.RegisterDERPRelayRequest{
+		Relay: &v1.DERPRelay{RegionId: 901},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("RegisterDERPRelay() without hostname error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	response, err := api.RegisterDERPRelay(ctx, &v1.RegisterDERPRelayRequest{Relay: relay})
+	if err != nil {
+		t.Fatalf("RegisterDERPRelay() error = %s", err)
+	}
+	if !response.GetRelay().GetOnline() {
+		t.Errorf("registered relay is not online")
+	}
+
+	region, ok := h.derpMap.Load().Regions[900]
+	if !ok {
+		t.Fatalf("registered relay is not in the DERPMap")
+	}
+	if got := region.Nodes[0].HostName; got != "fra.derp.example.com" {
+		t.Errorf("relay hostname in the DERPMap = %q, want fra.derp.example.com", got)
+	}
+	if _, ok := h.derpMap.Load().Regions[1]; !ok {
+		t.Errorf("configured region is missing from the DERPMap")
+	}
+
+	// A heartbeat registers the relay again, for the same region.
+	if _, err := api.RegisterDERPRelay(ctx, &v1.RegisterDERPRelayRequest{Relay: relay}); err != nil {
+		t.Fatalf("RegisterDERPRelay() heartbeat error = %s", err)
+	}
+
+	relays, err := api.ListDERPRelays(ctx, &v1.ListDERPRelaysRequest{})
+	if err != nil {
+		t.Fatalf("ListDERPRelays() error = %s", err)
+	}
+	if len(relays.GetRelays()) != 1 {
+		t.Fatalf("ListDERPRelays() = %d relays, want 1", len(relays.GetRelays()))
+	}
+
+	// A relay missing its heartbeats is left out of the DERPMap.
+	err = h.db.DB.Model(&types.DERPRelay{}).Where("region_id = ?", 900).
+		Update("last_seen", time.Now().Add(-2*time.Minute)).Error
+	if err != nil {
+		t.Fatalf("expiring relay: %s", err)
+	}
+	h.updateDERPMap("test")
+	if _, ok := h.derpMap.Load().Regions[900]; ok {
+		t.Errorf("expired relay is still in the DERPMap")
+	}
+
+	if _, err := api.RegisterDERPRelay(ctx, &v1.RegisterDERPRelayRequest{Relay: relay}); err != nil {
+		t.Fatalf("RegisterDERPRelay() error = %s", err)
+	}
+	if _, ok := h.derpMap.Load().Regions[900]; !ok {
+		t.Errorf("relay registering again is not in the DERPMap")
+	}
+
+	if _, err := api.DeleteDERPRelay(ctx, &v1.DeleteDERPRelayRequest{RegionId: 900}); err != nil {
+		t.Fatalf("DeleteDERPRelay() error = %s", err)
+	}
+	if _, ok := h.derpMap.Load().Regions[900]; ok {
+		t.Errorf("deleted relay is still in the DERPMap")
+	}
+
+	_, err = api.DeleteDERPRelay(ctx, &v1.DeleteDERPRelayRequest{RegionId: 900})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteDERPRelay() of a deleted relay error = %v, want %s", err, codes.NotFound)
+	}
+}
+
+func TestDERPRelayAPIKey(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{
+		DERP: types.DERPConfig{
+			RelaysEnabled: true,
+			RelayExpiry:   time.Minute,
+		},
+	})
+	h.derpMap.Store(&tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{}})
+
+	_, err := api.CreateApiKey(context.Background(), &v1.CreateApiKeyRequest{
+		Tags:         []string{"tag:router"},
+		DerpRegionId: 900,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("CreateApiKey() limited to tags and a region error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	expiration := time.Now().Add(time.Hour)
+	keyStr, _, err := h.db.CreateAPIKey(&expiration, nil, 900)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %s", err)
+	}
+	scoped := metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs("authorization", AuthPrefix+keyStr),
+	)
+
+	relay := func(regionID int32) *v1.RegisterDERPRelayRequest {
+		return &v1.RegisterDERPRelayRequest{
+			Relay: &v1.DERPRelay{RegionId: regionID, Hostname: "derp.example.com", DerpPort: 443},
+		}
+	}
+
+	if _, err := api.RegisterDERPRelay(scoped, relay(900)); err != nil {
+		t.Fatalf("RegisterDERPRelay() of the region of the key error = %s", err)
+	}
+
+	_, err = api.RegisterDERPRelay(scoped, relay(901))
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("RegisterDERPRelay() of another region error = %v, want %s", err, codes.PermissionDenied)
+	}
+
+	handler := func(context.Context, interface{}) (interface{}, error) { return nil, nil }
+	for method, want := range map[string]codes.Code{
+		v1.HeadscaleService_RegisterDERPRelay_FullMethodName: codes.OK,
+		v1.HeadscaleService_DeleteDERPRelay_FullMethodName:   codes.PermissionDenied,
+		v1.HeadscaleService_CreateApiKey_FullMethodName:      codes.PermissionDenied,
+	} {
+		_, err := h.apiKeyScopeInterceptor(scoped, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		if status.Code(err) != want {
+			t.Errorf("calling %s with a key limited to a DERP region error = %v, want %s", method, err, want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package hscontrol
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,7 +12,10 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/util"
 	"github.com/rs/zerolog/log"
+	"rsc.io/qr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 )
@@ -31,6 +35,10 @@ const (
 	// TODO(juan): remove this once https://github.com/juanfont/headscale/issues/727 is fixed.
 	registrationHoldoff        = time.Second * 5
 	reservedResponseHeaderSize = 4
+
+	// registerQRCodeScale is the size in pixels of a module of the
+	// QR code on the registration page.
+	registerQRCodeScale = 4
 )
 
 var ErrRegisterMethodCLIDoesNotSupportExpire = errors.New(
@@ -96,46 +104,68 @@ func (h *Headscale) KeyHandler(
 	}
 }
 
-func (h *Headscale) HealthHandler(
+// DERPMapHandler serves the DERP map distributed to the nodes, merged
+// from the configured sources and the embedded DERP server, as JSON.
+// Other headscale instances can list it in derp.urls, it has the format
+// of https://controlplane.tailscale.com/derpmap/default.
+// Listens in /derpmap/default.
+func (h *Headscale) DERPMapHandler(
 	writer http.ResponseWriter,
 	req *http.Request,
 ) {
-	respond := func(err error) {
-		writer.Header().Set("Content-Type", "application/health+json; charset=utf-8")
-
-		res := struct {
-			Status string `json:"status"`
-		}{
-			Status: "pass",
-		}
+	derpMap := h.derpMap.Load()
+	if derpMap == nil {
+		http.Error(writer, "DERP map is not loaded yet", http.StatusServiceUnavailable)
 
-		if err != nil {
-			writer.WriteHeader(http.StatusInternalServerError)
-			log.Error().Caller().Err(err).Msg("health check failed")
-			res.Status = "fail"
-		}
+		return
+	}
 
-		buf, err := json.Marshal(res)
-		if err != nil {
-			log.Error().Caller().Err(err).Msg("marshal failed")
-		}
-		_, err = writer.Write(buf)
-		if err != nil {
-			log.Error().Caller().Err(err).Msg("write failed")
-		}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(writer).Encode(derpMap); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
 	}
+}
 
-	if err := h.db.PingDB(req.Context()); err != nil {
-		respond(err)
+// PolicySchemaHandler returns the JSON Schema of the policy format, so
+// editors and CI can validate policies before they are uploaded.
+// Listens in /policy/schema.json.
+func (h *Headscale) PolicySchemaHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	schema, err := policy.Schema()
+	if err != nil {
+		util.LogErr(err, "Could not generate the policy schema")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
 
 		return
 	}
 
-	respond(nil)
+	writer.Header().Set("Content-Type", "application/schema+json")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write(schema); err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Failed to write response")
+	}
 }
 
 type registerWebAPITemplateConfig struct {
 	Key string
+
+	// QRCode is the key as a QR code image, for an administrator
+	// registering many nodes to scan it from the screen of the node.
+	QRCode template.URL
+
+	// LocalAuth shows a sign in form for the built-in authentication.
+	LocalAuth bool
+	AskTOTP   bool
+	Error     string
 }
 
 var registerWebAPITemplate = template.Must(
@@ -159,14 +189,60 @@ var registerWebAPITemplate = template.Must(
 	<body>
 		<h1>headscale</h1>
 		<h2>Machine registration</h2>
+		{{if .LocalAuth}}
+		{{if .Error}}<p>{{.Error}}</p>{{end}}
+		<form method="post" action="/register/{{.Key}}">
+			<p>
+				<label for="username">Username</label>
+				<input type="text" id="username" name="username" autocomplete="username" required>
+			</p>
+			<p>
+				<label for="password">Password</label>
+				<input type="password" id="password" name="password" autocomplete="current-password" required>
+			</p>
+			<p>
+				<label for="totp">One-time code{{if not .AskTOTP}} (if enabled){{end}}</label>
+				<input type="text" id="totp" name="totp" inputmode="numeric" autocomplete="one-time-code"{{if .AskTOTP}} required{{end}}>
+			</p>
+			<button type="submit">Sign in</button>
+		</form>
+		<p>
+			Or ask an administrator to run the command below in the headscale server to add this machine to your network:
+		</p>
+		{{else}}
 		<p>
 			Run the command below in the headscale server to add this machine to your network:
 		</p>
+		{{end}}
 		<code>headscale nodes register --user USERNAME --key {{.Key}}</code>
+		{{if .QRCode}}
+		<p>
+			Or let the administrator scan the key:
+		</p>
+		<img src="{{.QRCode}}" alt="{{.Key}}">
+		{{end}}
 	</body>
 </html>
 `))
 
+// registerQRCode returns the key as a QR code PNG image in a data URL,
+// or an empty URL if it cannot be encoded.
+func registerQRCode(key string) template.URL {
+	code, err := qr.Encode(key, qr.M)
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Could not encode the key as a QR code")
+
+		return ""
+	}
+	code.Scale = registerQRCodeScale
+
+	//nolint:gosec // the image is generated from a validated key.
+	return template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+}
+
 // RegisterWebAPI shows a simple message in the browser to point to the CLI
 // Listens in /register/:nkey.
 //
@@ -202,17 +278,31 @@ func (h *Headscale) RegisterWebAPI(
 		return
 	}
 
+	h.renderRegisterWebAPI(writer, http.StatusOK, registerWebAPITemplateConfig{
+		Key:       machineKey.String(),
+		LocalAuth: h.passwordAuthEnabled(),
+		AskTOTP:   h.cfg.LocalAuth.RequireTOTP,
+	})
+}
+
+func (h *Headscale) renderRegisterWebAPI(
+	writer http.ResponseWriter,
+	status int,
+	config registerWebAPITemplateConfig,
+) {
+	if config.QRCode == "" && config.Key != "" {
+		config.QRCode = registerQRCode(config.Key)
+	}
+
 	var content bytes.Buffer
-	if err := registerWebAPITemplate.Execute(&content, registerWebAPITemplateConfig{
-		Key: machineKey.String(),
-	}); err != nil {
+	if err := registerWebAPITemplate.Execute(&content, config); err != nil {
 		log.Error().
 			Str("func", "RegisterWebAPI").
 			Err(err).
 			Msg("Could not render register web API template")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		writer.WriteHeader(http.StatusInternalServerError)
-		_, err = writer.Write([]byte("Could not render register web API template"))
+		_, err := writer.Write([]byte("Could not render register web API template"))
 		if err != nil {
 			log.Error().
 				Caller().
@@ -224,8 +314,8 @@ func (h *Headscale) RegisterWebAPI(
 	}
 
 	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
-	writer.WriteHeader(http.StatusOK)
-	_, err = writer.Write(content.Bytes())
+	writer.WriteHeader(status)
+	_, err := writer.Write(content.Bytes())
 	if err != nil {
 		log.Error().
 			Caller().
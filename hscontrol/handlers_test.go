@@ -0,0 +1,93 @@
+package hscontrol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/derp"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestDERPMapHandler(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{})
+
+	rec := httptest.NewRecorder()
+	h.DERPMapHandler(rec, httptest.NewRequest(http.MethodGet, "/derpmap/default", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("DERP map before it is loaded = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	h.derpMap.Store(&tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			999: {
+				RegionID:   999,
+				RegionCode: "headscale",
+				RegionName: "Headscale Embedded DERP",
+				Nodes: []*tailcfg.DERPNode{
+					{Name: "999a", RegionID: 999, HostName: "headscale.example.com", STUNPort: 3478},
+				},
+			},
+		},
+	})
+
+	srv := httptest.NewServer(http.HandlerFunc(h.DERPMapHandler))
+	defer srv.Close()
+
+	// Another headscale instance consumes the map from derp.urls.
+	serverURL, err := url.Parse(srv.URL + "/derpmap/default")
+	if err != nil {
+		t.Fatalf("parsing URL: %s", err)
+	}
+
+	got := derp.GetDERPMap(types.DERPConfig{URLs: []url.URL{*serverURL}})
+	if diff := cmp.Diff(h.derpMap.Load(), got); diff != "" {
+		t.Errorf("consumed DERP map mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRegisterWebAPIQRCode(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{})
+
+	machineKey := key.NewMachine().Public()
+	req := mux.SetURLVars(
+		httptest.NewRequest(http.MethodGet, "/register/"+machineKey.String(), nil),
+		map[string]string{"mkey": machineKey.String()},
+	)
+
+	rec := httptest.NewRecorder()
+	h.RegisterWebAPI(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("RegisterWebAPI() = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	body := rec.Body.String()
+	if !strings.Contains(body, `<img src="data:image/png;base64,`) {
+		t.Errorf("registration page has no QR code of the key:\n%s", body)
+	}
+	if !strings.Contains(body, "--key "+machineKey.String()) {
+		t.Errorf("registration page has no register command for the key")
+	}
+}
+
+func TestPolicySchemaHandler(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{})
+
+	rec := httptest.NewRecorder()
+	h.PolicySchemaHandler(rec, httptest.NewRequest(http.MethodGet, "/policy/schema.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("policy schema status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/schema+json" {
+		t.Errorf("Content-Type = %q, want application/schema+json", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"derpRestrictions"`) {
+		t.Errorf("policy schema does not describe the headscale extensions")
+	}
+}
@@ -0,0 +1,280 @@
+package hscontrol
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	healthPass = "pass"
+	healthWarn = "warn"
+	healthFail = "fail"
+
+	// healthDBSlowThreshold is how long a database ping can take before
+	// the database is reported degraded.
+	healthDBSlowThreshold = time.Second
+
+	// healthOIDCInterval is how long the reachability of the OIDC
+	// discovery documents is cached, probes must not hammer the
+	// identity providers.
+	healthOIDCInterval = time.Minute
+	healthOIDCTimeout  = 5 * time.Second
+)
+
+// healthCheck is the result of a check of a dependency, in the format of
+// https://datatracker.ietf.org/doc/html/draft-inadarei-api-health-check.
+type healthCheck struct {
+	ComponentID   string    `json:"componentId,omitempty"`
+	Status        string    `json:"status"`
+	ObservedValue any       `json:"observedValue,omitempty"`
+	ObservedUnit  string    `json:"observedUnit,omitempty"`
+	Output        string    `json:"output,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+type healthResponse struct {
+	Status string                   `json:"status"`
+	Checks map[string][]healthCheck `json:"checks,omitempty"`
+}
+
+// loadedPolicy describes the policy in use.
+type loadedPolicy struct {
+	// Version is the ID of the policy in database mode, 0 for a file.
+	Version  uint
+	Hash     string
+	LoadedAt time.Time
+}
+
+// setLoadedPolicy records the policy put in use.
+func (h *Headscale) setLoadedPolicy(pol *policy.ACLPolicy, version uint) {
+	setPolicyMetrics(version)
+
+	if pol == nil {
+		h.loadedPolicy.Store(nil)
+
+		return
+	}
+
+	data, err := json.Marshal(pol)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to encode policy for its hash")
+	}
+	sum := sha256.Sum256(data)
+
+	h.loadedPolicy.Store(&loadedPolicy{
+		Version:  version,
+		Hash:     hex.EncodeToString(sum[:]),
+		LoadedAt: time.Now(),
+	})
+}
+
+// oidcHealth caches whether the discovery documents of the OIDC
+// providers are reachable.
+type oidcHealth struct {
+	mu      sync.Mutex
+	checked time.Time
+	checks  []healthCheck
+}
+
+// HealthHandler reports the status of each dependency of headscale. A
+// failed dependency stops headscale from serving nodes and fails the
+// check, a degraded one, like an unreachable identity provider, is
+// reported as a warning and passes.
+func (h *Headscale) HealthHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	res := h.health(req.Context())
+
+	writer.Header().Set("Content-Type", "application/health+json; charset=utf-8")
+	if res.Status == healthFail {
+		log.Error().Caller().Any("checks", res.Checks).Msg("health check failed")
+		writer.WriteHeader(http.StatusInternalServerError)
+	}
+
+	buf, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Caller().Err(err).Msg("marshal failed")
+	}
+	_, err = writer.Write(buf)
+	if err != nil {
+		log.Error().Caller().Err(err).Msg("write failed")
+	}
+}
+
+func (h *Headscale) health(ctx context.Context) healthResponse {
+	res := healthResponse{
+		Status: healthPass,
+		Checks: map[string][]healthCheck{
+			"database:responseTime": {h.databaseHealth(ctx)},
+			"derp:regions":          {h.derpHealth()},
+		},
+	}
+	for name, check := range h.policyHealth() {
+		res.Checks[name] = []healthCheck{check}
+	}
+	if oidc := h.oidcDiscoveryHealth(ctx); len(oidc) > 0 {
+		res.Checks["oidc:discovery"] = oidc
+	}
+
+	for _, checks := range res.Checks {
+		for _, check := range checks {
+			switch {
+			case check.Status == healthFail:
+				res.Status = healthFail
+			case check.Status == healthWarn && res.Status == healthPass:
+				res.Status = healthWarn
+			}
+		}
+	}
+
+	return res
+}
+
+func (h *Headscale) databaseHealth(ctx context.Context) healthCheck {
+	start := time.Now()
+	err := h.db.PingDB(ctx)
+	elapsed := time.Since(start)
+
+	check := healthCheck{
+		Status:        healthPass,
+		ObservedValue: float64(elapsed.Microseconds()) / 1000,
+		ObservedUnit:  "ms",
+		Time:          start,
+	}
+
+	switch {
+	case err != nil:
+		check.Status = healthFail
+		check.Output = err.Error()
+	case elapsed > healthDBSlowThreshold:
+		check.Status = healthWarn
+		check.Output = fmt.Sprintf("database answered in %s", elapsed.Round(time.Millisecond))
+	}
+
+	return check
+}
+
+func (h *Headscale) derpHealth() healthCheck {
+	check := healthCheck{
+		Status: healthPass,
+		Time:   time.Now(),
+	}
+
+	regions := 0
+	if derpMap := h.derpMap.Load(); derpMap != nil {
+		regions = len(derpMap.Regions)
+	}
+	check.ObservedValue = regions
+
+	if regions == 0 {
+		check.Status = healthFail
+		check.Output = "the DERP map has no regions, nodes cannot connect to each other"
+	}
+
+	return check
+}
+
+// policyHealth reports the version and the hash of the policy in use.
+// Headscale does not start with a policy it cannot load and keeps the
+// last one when a reload fails, the checks always pass.
+func (h *Headscale) policyHealth() map[string]healthCheck {
+	loaded := h.loadedPolicy.Load()
+	if loaded == nil {
+		return map[string]healthCheck{
+			"policy:version": {
+				Status: healthPass,
+				Output: "no policy loaded, all traffic is allowed",
+				Time:   time.Now(),
+			},
+		}
+	}
+
+	return map[string]healthCheck{
+		"policy:version": {
+			Status:        healthPass,
+			ObservedValue: loaded.Version,
+			Time:          loaded.LoadedAt,
+		},
+		"policy:hash": {
+			Status:        healthPass,
+			ObservedValue: loaded.Hash,
+			Time:          loaded.LoadedAt,
+		},
+	}
+}
+
+// oidcDiscoveryHealth checks the discovery document of every configured
+// OIDC provider is reachable. A provider that is unavailable only stops
+// OIDC logins, it is reported degraded.
+func (h *Headscale) oidcDiscoveryHealth(ctx context.Context) []healthCheck {
+	providers := h.cfg.OIDC.Providers()
+	if len(providers) == 0 {
+		return nil
+	}
+
+	h.oidcHealth.mu.Lock()
+	defer h.oidcHealth.mu.Unlock()
+
+	if time.Since(h.oidcHealth.checked) < healthOIDCInterval {
+		return h.oidcHealth.checks
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, healthOIDCTimeout)
+	defer cancel()
+
+	checks := make([]healthCheck, 0, len(providers))
+	for _, cfg := range providers {
+		check := healthCheck{
+			ComponentID: cfg.Name,
+			Status:      healthPass,
+			Time:        time.Now(),
+		}
+
+		if _, ok := h.getOIDCProvider(cfg.Name); !ok {
+			check.Status = healthWarn
+			check.Output = "the provider could not be set up at startup, OIDC logins are unavailable"
+		} else if err := checkOIDCDiscovery(ctx, cfg.Issuer); err != nil {
+			check.Status = healthWarn
+			check.Output = err.Error()
+		}
+
+		checks = append(checks, check)
+	}
+
+	h.oidcHealth.checked = time.Now()
+	h.oidcHealth.checks = checks
+
+	return checks
+}
+
+func checkOIDCDiscovery(ctx context.Context, issuer string) error {
+	url := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching discovery document: %s", resp.Status)
+	}
+
+	return nil
+}
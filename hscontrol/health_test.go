@@ -0,0 +1,76 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+func TestHealthHandler(t *testing.T) {
+	issuer := httptest.NewServer(http.NotFoundHandler())
+	defer issuer.Close()
+
+	h, _ := newTestAPIServer(t, &types.Config{
+		OIDC: types.OIDCConfig{
+			Issuer: issuer.URL,
+			Name:   "corp",
+		},
+	})
+
+	check := func() (int, healthResponse) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.HealthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		var res healthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("decoding health response: %s", err)
+		}
+
+		return rec.Code, res
+	}
+
+	// Without a DERP region nodes cannot connect, the check fails.
+	code, res := check()
+	if code != http.StatusInternalServerError || res.Status != healthFail {
+		t.Errorf("health without DERP regions = %d %q, want %d %q", code, res.Status, http.StatusInternalServerError, healthFail)
+	}
+	if got := res.Checks["derp:regions"][0].Status; got != healthFail {
+		t.Errorf("derp status = %q, want %q", got, healthFail)
+	}
+	if got := res.Checks["database:responseTime"][0].Status; got != healthPass {
+		t.Errorf("database status = %q, want %q", got, healthPass)
+	}
+
+	// An identity provider that is not set up only degrades headscale.
+	h.derpMap.Store(&tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{1: {RegionID: 1}}})
+	h.oidcHealth.checked = h.oidcHealth.checked.AddDate(-1, 0, 0)
+
+	code, res = check()
+	if code != http.StatusOK || res.Status != healthWarn {
+		t.Errorf("health with OIDC unavailable = %d %q, want %d %q", code, res.Status, http.StatusOK, healthWarn)
+	}
+	oidc := res.Checks["oidc:discovery"]
+	if len(oidc) != 1 || oidc[0].Status != healthWarn || oidc[0].ComponentID != "corp" {
+		t.Errorf("oidc checks = %+v, want a warning for corp", oidc)
+	}
+
+	// The version and hash of the policy are reported.
+	h.setLoadedPolicy(&policy.ACLPolicy{
+		ACLs: []policy.ACL{{Action: "accept", Sources: []string{"*"}, Destinations: []string{"*:*"}}},
+	}, 7)
+
+	_, res = check()
+	if got := res.Checks["policy:version"][0].ObservedValue; got != float64(7) {
+		t.Errorf("policy version = %v, want 7", got)
+	}
+	if got, _ := res.Checks["policy:hash"][0].ObservedValue.(string); len(got) != 64 {
+		t.Errorf("policy hash = %q, want a sha256", got)
+	}
+}
@@ -0,0 +1,94 @@
+package hscontrol
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+)
+
+// syncLDAPGroups reads the groups from the LDAP directory every
+// interval and updates the policy when they change.
+func (h *Headscale) syncLDAPGroups(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		groups, err := h.ldap.Groups()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read groups from LDAP")
+		} else if h.setLDAPGroups(groups) {
+			log.Info().
+				Int("groups", len(groups)).
+				Msg("Groups from LDAP changed, updating policy")
+
+			if err := h.reloadDirectoryGroups(); err != nil {
+				log.Error().Err(err).Msg("Failed to update policy with groups from LDAP")
+			} else {
+				ctx := types.NotifyCtx(context.Background(), "ldap-groups", "na")
+				h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+					Type: types.StateFullUpdate,
+				})
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setLDAPGroups stores the groups read from LDAP and reports if they
+// changed.
+func (h *Headscale) setLDAPGroups(groups policy.Groups) bool {
+	h.directoryGroupsMu.Lock()
+	defer h.directoryGroupsMu.Unlock()
+
+	if maps.EqualFunc(h.directoryGroups, groups, slices.Equal) {
+		return false
+	}
+	h.directoryGroups = groups
+
+	return true
+}
+
+// setDirectoryGroups fills in the groups read from a directory, so the
+// policy can use them next to the groups it defines.
+func (h *Headscale) setDirectoryGroups(pol *policy.ACLPolicy) {
+	h.directoryGroupsMu.RLock()
+	defer h.directoryGroupsMu.RUnlock()
+
+	pol.DirectoryGroups = h.directoryGroups
+}
+
+// reloadDirectoryGroups updates the directory groups of the current
+// policy.
+func (h *Headscale) reloadDirectoryGroups() error {
+	if h.ACLPolicy == nil {
+		return nil
+	}
+
+	pol := *h.ACLPolicy
+	h.setDirectoryGroups(&pol)
+
+	// Reject groups that make the policy fail to compile, keeping
+	// the previous groups in place.
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		return err
+	}
+
+	if _, err := pol.CompileFilterRules(nodes); err != nil {
+		return err
+	}
+
+	h.ACLPolicy = &pol
+
+	return nil
+}
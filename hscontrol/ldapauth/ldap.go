@@ -0,0 +1,220 @@
+// Package ldapauth authenticates users against an LDAP directory, like
+// Active Directory, and resolves their group memberships for the
+// policy.
+package ldapauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+const searchPageSize = 500
+
+var (
+	ErrInvalidCredentials = errors.New("invalid LDAP credentials")
+	ErrUserNotFound       = errors.New("LDAP user not found")
+	ErrAmbiguousUser      = errors.New("LDAP user filter matched more than one entry")
+)
+
+// Client connects to the directory for every operation, connections
+// are not kept open between logins and group synchronisations.
+type Client struct {
+	cfg types.LDAPConfig
+}
+
+func New(cfg types.LDAPConfig) *Client {
+	return &Client{cfg: cfg}
+}
+
+// connect opens a connection and binds with the service account, or
+// anonymously if none is configured.
+func (c *Client) connect() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.cfg.InsecureSkipVerify, //nolint:gosec
+	}
+
+	conn, err := ldap.DialURL(c.cfg.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("connecting to LDAP server: %w", err)
+	}
+
+	if c.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("starting TLS: %w", err)
+		}
+	}
+
+	if c.cfg.BindDN != "" {
+		if err := conn.Bind(c.cfg.BindDN, c.cfg.BindPassword); err != nil {
+			conn.Close()
+
+			return nil, fmt.Errorf("binding as %q: %w", c.cfg.BindDN, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// Authenticate looks up the user with the user filter and binds as it
+// with the password. It returns the value of the username attribute,
+// which is the name of the user in headscale.
+func (c *Client) Authenticate(username, password string) (string, error) {
+	// An empty password is an unauthenticated bind, which many
+	// servers accept for any DN.
+	if username == "" || password == "" {
+		return "", ErrInvalidCredentials
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		c.cfg.UserBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2, 0, false,
+		c.userFilter(ldap.EscapeFilter(username)),
+		[]string{c.cfg.UsernameAttribute},
+		nil,
+	))
+	if err != nil {
+		return "", fmt.Errorf("searching LDAP user: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return "", ErrUserNotFound
+	case 1:
+	default:
+		return "", ErrAmbiguousUser
+	}
+
+	entry := result.Entries[0]
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return "", ErrInvalidCredentials
+		}
+
+		return "", fmt.Errorf("binding as %q: %w", entry.DN, err)
+	}
+
+	if name := entry.GetAttributeValue(c.cfg.UsernameAttribute); name != "" {
+		return name, nil
+	}
+
+	return username, nil
+}
+
+// Groups returns the members of all groups matching the group filter,
+// keyed by "group:" and the group name, as used in the policy.
+func (c *Client) Groups() (map[string][]string, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	users, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		c.cfg.UserBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		c.userFilter("*"),
+		[]string{c.cfg.UsernameAttribute},
+		nil,
+	), searchPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("searching LDAP users: %w", err)
+	}
+
+	groups, err := conn.SearchWithPaging(ldap.NewSearchRequest(
+		c.cfg.GroupBaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0, 0, false,
+		c.cfg.GroupFilter,
+		[]string{c.cfg.GroupNameAttribute, c.cfg.GroupMemberAttribute},
+		nil,
+	), searchPageSize)
+	if err != nil {
+		return nil, fmt.Errorf("searching LDAP groups: %w", err)
+	}
+
+	return groupMembers(c.cfg, users.Entries, groups.Entries), nil
+}
+
+func (c *Client) userFilter(username string) string {
+	return strings.ReplaceAll(c.cfg.UserFilter, "{username}", username)
+}
+
+// groupMembers maps the members of the groups to usernames. Members
+// are either DNs of users, like with groupOfNames and Active Directory,
+// or usernames, like memberUid of posixGroup. Members that are not
+// found, like nested groups, are skipped.
+func groupMembers(
+	cfg types.LDAPConfig,
+	users []*ldap.Entry,
+	groups []*ldap.Entry,
+) map[string][]string {
+	usernames := make(map[string]string, len(users))
+	for _, user := range users {
+		if name := user.GetAttributeValue(cfg.UsernameAttribute); name != "" {
+			usernames[normalizeDN(user.DN)] = name
+		}
+	}
+
+	result := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		name := group.GetAttributeValue(cfg.GroupNameAttribute)
+		if name == "" {
+			continue
+		}
+
+		members := []string{}
+		for _, member := range group.GetAttributeValues(cfg.GroupMemberAttribute) {
+			if !strings.Contains(member, "=") {
+				members = append(members, member)
+
+				continue
+			}
+
+			if username, ok := usernames[normalizeDN(member)]; ok {
+				members = append(members, username)
+			}
+		}
+
+		result["group:"+name] = members
+	}
+
+	return result
+}
+
+// normalizeDN returns the DN in a form that compares equal for the
+// different spellings of the same DN.
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(dn)
+	}
+
+	rdns := make([]string, len(parsed.RDNs))
+	for index, rdn := range parsed.RDNs {
+		attributes := make([]string, len(rdn.Attributes))
+		for i, attribute := range rdn.Attributes {
+			attributes[i] = strings.ToLower(attribute.Type) + "=" + strings.ToLower(attribute.Value)
+		}
+		rdns[index] = strings.Join(attributes, "+")
+	}
+
+	return strings.Join(rdns, ",")
+}
@@ -0,0 +1,61 @@
+package ldapauth
+
+import (
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestGroupMembers(t *testing.T) {
+	cfg := types.LDAPConfig{
+		UsernameAttribute:    "uid",
+		GroupNameAttribute:   "cn",
+		GroupMemberAttribute: "member",
+	}
+
+	users := []*ldap.Entry{
+		ldap.NewEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+			"uid": {"alice"},
+		}),
+		ldap.NewEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+			"uid": {"bob"},
+		}),
+	}
+
+	groups := []*ldap.Entry{
+		ldap.NewEntry("cn=engineering,ou=groups,dc=example,dc=com", map[string][]string{
+			"cn": {"engineering"},
+			"member": {
+				"UID=Alice, OU=People, DC=example, DC=com",
+				"cn=nested,ou=groups,dc=example,dc=com",
+			},
+		}),
+		ldap.NewEntry("cn=ops,ou=groups,dc=example,dc=com", map[string][]string{
+			"cn":     {"ops"},
+			"member": {"bob"},
+		}),
+		ldap.NewEntry("cn=empty,ou=groups,dc=example,dc=com", map[string][]string{
+			"cn": {"empty"},
+		}),
+	}
+
+	want := map[string][]string{
+		"group:engineering": {"alice"},
+		"group:ops":         {"bob"},
+		"group:empty":       {},
+	}
+
+	if diff := cmp.Diff(want, groupMembers(cfg, users, groups)); diff != "" {
+		t.Errorf("groupMembers() unexpected result (-want +got):\n%s", diff)
+	}
+}
+
+func TestAuthenticateRejectsEmptyPassword(t *testing.T) {
+	client := New(types.LDAPConfig{URL: "ldap://127.0.0.1:1"})
+
+	if _, err := client.Authenticate("alice", ""); err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() with an empty password error = %v, want %v", err, ErrInvalidCredentials)
+	}
+}
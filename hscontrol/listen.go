@@ -0,0 +1,110 @@
+package hscontrol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation, see sd_listen_fds(3).
+const listenFDsStart = 3
+
+// activatedListeners returns the listeners passed by systemd socket
+// activation and clears the environment so they are not passed on to
+// child processes.
+func activatedListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count == 0 {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := listenFDsStart; fd < listenFDsStart+count; fd++ {
+		closeOnExec(fd)
+
+		file := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket activation file descriptor %d: %w", fd, err)
+		}
+
+		listeners = append(listeners, listener)
+	}
+
+	return listeners, nil
+}
+
+// takeActivatedListener returns and removes the socket activated
+// listener bound to addr, nil if there is none.
+func (h *Headscale) takeActivatedListener(addr string) net.Listener {
+	want, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil
+	}
+
+	for index, listener := range h.activatedListeners {
+		got, ok := listener.Addr().(*net.TCPAddr)
+		if !ok || got.Port != want.Port {
+			continue
+		}
+
+		if got.IP.Equal(want.IP) || (got.IP.IsUnspecified() && (want.IP == nil || want.IP.IsUnspecified())) {
+			h.activatedListeners = append(h.activatedListeners[:index], h.activatedListeners[index+1:]...)
+
+			return listener
+		}
+	}
+
+	return nil
+}
+
+// listen returns a listener for addr, taking over a socket passed by
+// systemd socket activation if there is one. Otherwise the address is
+// bound, with SO_REUSEPORT if listen_reuse_port is set so a new
+// headscale can bind it before the running one stops.
+func (h *Headscale) listen(network string, addr string) (net.Listener, error) {
+	if listener := h.takeActivatedListener(addr); listener != nil {
+		log.Info().
+			Str("addr", listener.Addr().String()).
+			Msg("Using listener from socket activation")
+
+		return listener, nil
+	}
+
+	var listenConfig net.ListenConfig
+	if h.cfg.ListenReusePort && strings.HasPrefix(network, "tcp") {
+		listenConfig.Control = reusePort
+	}
+
+	return listenConfig.Listen(context.Background(), network, addr)
+}
+
+// closeUnusedActivatedListeners closes the socket activated listeners
+// that do not match any configured address.
+func (h *Headscale) closeUnusedActivatedListeners() {
+	for _, listener := range h.activatedListeners {
+		log.Warn().
+			Str("addr", listener.Addr().String()).
+			Msg("Closing listener from socket activation that matches no configured address")
+		listener.Close()
+	}
+	h.activatedListeners = nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package hscontrol
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errReusePortUnsupported = errors.New("listen_reuse_port is not supported on this platform")
+
+// closeOnExec does nothing, socket activation only passes file
+// descriptors on unix.
+func closeOnExec(fd int) {}
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return errReusePortUnsupported
+}
@@ -0,0 +1,66 @@
+package hscontrol
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestListenReusePort(t *testing.T) {
+	h := &Headscale{cfg: &types.Config{ListenReusePort: true}}
+
+	first, err := h.listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen() error = %s", err)
+	}
+	defer first.Close()
+
+	// A second headscale must be able to bind the address while the
+	// first one is still serving.
+	second, err := h.listen("tcp", first.Addr().String())
+	if err != nil {
+		t.Fatalf("listen() on a bound address error = %s", err)
+	}
+	second.Close()
+
+	h.cfg.ListenReusePort = false
+	if _, err := h.listen("tcp", first.Addr().String()); err == nil {
+		t.Fatalf("listen() without listen_reuse_port bound an address in use")
+	}
+}
+
+func TestTakeActivatedListener(t *testing.T) {
+	loopback, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loopback.Close()
+
+	wildcard, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wildcard.Close()
+
+	wildcardPort := wildcard.Addr().(*net.TCPAddr).Port
+
+	h := &Headscale{activatedListeners: []net.Listener{loopback, wildcard}}
+
+	if got := h.takeActivatedListener("127.0.0.1:1"); got != nil {
+		t.Errorf("takeActivatedListener() matched another port: %s", got.Addr())
+	}
+
+	if got := h.takeActivatedListener(net.JoinHostPort("0.0.0.0", strconv.Itoa(wildcardPort))); got != wildcard {
+		t.Errorf("takeActivatedListener(0.0.0.0) = %v, want the wildcard listener", got)
+	}
+
+	if got := h.takeActivatedListener(loopback.Addr().String()); got != loopback {
+		t.Errorf("takeActivatedListener(%s) = %v, want the loopback listener", loopback.Addr(), got)
+	}
+
+	if len(h.activatedListeners) != 0 {
+		t.Errorf("activatedListeners = %v, want all taken", h.activatedListeners)
+	}
+}
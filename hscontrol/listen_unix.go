@@ -0,0 +1,25 @@
+//go:build unix
+
+package hscontrol
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
+
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var sockErr error
+	err := conn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return sockErr
+}
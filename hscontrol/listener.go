@@ -0,0 +1,191 @@
+package hscontrol
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	grpcMiddleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/pires/go-proxyproto"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var errInsecureGRPCListener = errors.New(
+	"gRPC listener without TLS requires grpc_allow_insecure",
+)
+
+// newRemoteGRPCServer returns an authenticated gRPC server for the
+// Headscale API, using TLS if tlsConfig is not nil.
+func (h *Headscale) newRemoteGRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	grpcOptions := []grpc.ServerOption{
+		grpc.UnaryInterceptor(
+			grpcMiddleware.ChainUnaryServer(
+				h.grpcAuthenticationInterceptor,
+				// Uncomment to debug grpc communication.
+				// zerolog.NewUnaryServerInterceptor(),
+			),
+		),
+		grpc.StreamInterceptor(h.grpcStreamAuthenticationInterceptor),
+	}
+
+	if tlsConfig != nil {
+		grpcOptions = append(grpcOptions,
+			grpc.Creds(credentials.NewTLS(tlsConfig)),
+		)
+	} else {
+		log.Warn().Msg("gRPC is running without security")
+	}
+
+	grpcServer := grpc.NewServer(grpcOptions...)
+
+	h.registerGRPCServices(grpcServer)
+
+	return grpcServer
+}
+
+// proxyProtocolListener wraps the listener to read PROXY protocol
+// headers if enabled, so the RemoteAddr of connections is the address
+// of the client instead of the load balancer.
+func (h *Headscale) proxyProtocolListener(listener net.Listener) (net.Listener, error) {
+	if !h.cfg.ProxyProtocol.Enabled {
+		return listener, nil
+	}
+
+	policy, err := proxyproto.LaxWhiteListPolicy(h.cfg.ProxyProtocol.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy_protocol.trusted_proxies: %w", err)
+	}
+
+	return &proxyproto.Listener{
+		Listener:          listener,
+		Policy:            policy,
+		ReadHeaderTimeout: types.HTTPTimeout,
+	}, nil
+}
+
+// clientAddr returns the address of the client of the request, without
+// the port. Behind a load balancer sending PROXY protocol headers it is
+// the address of the client, otherwise that of the load balancer.
+func clientAddr(req *http.Request) string {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return addrPort.Addr().String()
+}
+
+// listenerTLSConfig returns the TLS settings of an additional listener,
+// nil means the listener is served without TLS.
+func listenerTLSConfig(
+	listener types.ListenerConfig,
+	defaultTLS *tls.Config,
+) (*tls.Config, error) {
+	if listener.Insecure {
+		return nil, nil
+	}
+
+	if listener.TLSCertPath == "" {
+		return defaultTLS, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(listener.TLSCertPath, listener.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate for %s: %w", listener.Addr, err)
+	}
+
+	return &tls.Config{
+		NextProtos:   []string{"http/1.1"},
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// serveListeners starts the additional listeners configured in
+// listeners. HTTP and metrics listeners are served by the given
+// servers, every gRPC listener gets its own server as the TLS settings
+// of a gRPC server cannot differ per listener. The started gRPC servers
+// are returned so they can be stopped on shutdown.
+func (h *Headscale) serveListeners(
+	errorGroup *errgroup.Group,
+	defaultTLS *tls.Config,
+	httpServer *http.Server,
+	debugHTTPServer *http.Server,
+) ([]*grpc.Server, error) {
+	var grpcServers []*grpc.Server
+
+	for _, listener := range h.cfg.Listeners {
+		if listener.Type == types.ListenerSTUN {
+			if h.DERPServer == nil {
+				return nil, fmt.Errorf(
+					"STUN listener %s requires derp.server.enabled",
+					listener.Addr,
+				)
+			}
+
+			go h.DERPServer.ServeSTUNOn(listener.Network, listener.Addr)
+
+			continue
+		}
+
+		tlsConfig, err := listenerTLSConfig(listener, defaultTLS)
+		if err != nil {
+			return nil, err
+		}
+
+		// The metrics server is plain HTTP unless the listener has
+		// its own certificate.
+		if listener.Type == types.ListenerMetrics && listener.TLSCertPath == "" {
+			tlsConfig = nil
+		}
+
+		if listener.Type == types.ListenerGRPC && tlsConfig == nil && !h.cfg.GRPCAllowInsecure {
+			return nil, fmt.Errorf("%s: %w", listener.Addr, errInsecureGRPCListener)
+		}
+
+		netListener, err := h.listen(listener.Network, listener.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind to %s address %s: %w", listener.Network, listener.Addr, err)
+		}
+
+		if listener.Type == types.ListenerHTTP {
+			netListener, err = h.proxyProtocolListener(netListener)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		switch listener.Type {
+		case types.ListenerGRPC:
+			grpcServer := h.newRemoteGRPCServer(tlsConfig)
+			grpcServers = append(grpcServers, grpcServer)
+			errorGroup.Go(func() error { return grpcServer.Serve(netListener) })
+
+		case types.ListenerHTTP, types.ListenerMetrics:
+			server := httpServer
+			if listener.Type == types.ListenerMetrics {
+				server = debugHTTPServer
+			}
+
+			if tlsConfig != nil {
+				netListener = tls.NewListener(netListener, tlsConfig)
+			}
+
+			errorGroup.Go(func() error { return server.Serve(netListener) })
+		}
+
+		log.Info().
+			Str("type", string(listener.Type)).
+			Bool("tls", tlsConfig != nil).
+			Msgf("listening and serving on: %s", netListener.Addr())
+	}
+
+	return grpcServers, nil
+}
@@ -0,0 +1,88 @@
+package hscontrol
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// nodeLastSeen returns the last time the node was active, from its
+// poll session, its traffic through the embedded DERP server or the
+// database, whichever is the most recent.
+func (h *Headscale) nodeLastSeen(node *types.Node) *time.Time {
+	lastSeen := node.LastSeen
+
+	if seen, ok := h.nodeNotifier.LastSeen(node.ID); ok {
+		if lastSeen == nil || seen.After(*lastSeen) {
+			lastSeen = &seen
+		}
+	}
+
+	if h.DERPServer != nil {
+		active := h.DERPServer.NodeUsage(node.NodeKey).LastActive
+		if !active.IsZero() && (lastSeen == nil || active.After(*lastSeen)) {
+			lastSeen = &active
+		}
+	}
+
+	return lastSeen
+}
+
+// setLiveness populates the online and last seen fields of a node
+// returned by the API. A node is online while it has a poll session.
+func (h *Headscale) setLiveness(resp *v1.Node, node *types.Node) {
+	resp.Online = h.nodeNotifier.IsLikelyConnected(node.ID)
+
+	if lastSeen := h.nodeLastSeen(node); lastSeen != nil {
+		resp.LastSeen = timestamppb.New(*lastSeen)
+	}
+}
+
+// persistLastSeen writes the last seen time of the nodes that were
+// active since the previous run to the database, so it is accurate to
+// the interval and survives restarts.
+func (h *Headscale) persistLastSeen(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	var since time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now()
+
+			seen := h.nodeNotifier.LastSeenSince(since)
+			if len(seen) == 0 {
+				since = now
+
+				continue
+			}
+
+			err := h.db.Write(func(tx *gorm.DB) error {
+				for nodeID, lastSeen := range seen {
+					if err := db.SetLastSeen(tx, nodeID, lastSeen); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+			if err != nil {
+				log.Error().Err(err).Msg("failed to persist last seen of nodes")
+
+				continue
+			}
+
+			since = now
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package hscontrol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestNodeLiveness(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	stored := time.Now().Add(-time.Hour).UTC()
+	node := createTestNode(t, h, "alice", &types.Node{
+		Hostname: "laptop",
+		LastSeen: &stored,
+	})
+
+	getNode := func() *v1.Node {
+		t.Helper()
+
+		resp, err := api.GetNode(context.Background(), &v1.GetNodeRequest{NodeId: node.ID.Uint64()})
+		if err != nil {
+			t.Fatalf("GetNode() error = %s", err)
+		}
+
+		return resp.GetNode()
+	}
+
+	got := getNode()
+	if got.GetOnline() {
+		t.Errorf("node without a poll session is online")
+	}
+	if !got.GetLastSeen().AsTime().Equal(stored) {
+		t.Errorf("LastSeen = %s, want the stored %s", got.GetLastSeen().AsTime(), stored)
+	}
+
+	ch := make(chan types.StateUpdate, 1)
+	h.nodeNotifier.AddNode(node.ID, ch)
+
+	got = getNode()
+	if !got.GetOnline() {
+		t.Errorf("node with a poll session is offline")
+	}
+	if time.Since(got.GetLastSeen().AsTime()) > time.Minute {
+		t.Errorf("LastSeen = %s, want the time the session opened", got.GetLastSeen().AsTime())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.persistLastSeen(ctx, 10*time.Millisecond)
+
+	h.nodeNotifier.RemoveNode(node.ID, ch)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		persisted, err := h.db.GetNodeByID(node.ID)
+		if err != nil {
+			t.Fatalf("GetNodeByID() error = %s", err)
+		}
+		if persisted.LastSeen != nil && time.Since(*persisted.LastSeen) < time.Minute {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last seen was not persisted, got %v", persisted.LastSeen)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if getNode().GetOnline() {
+		t.Errorf("node is online after its poll session closed")
+	}
+}
@@ -0,0 +1,282 @@
+package hscontrol
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/localauth"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"tailscale.com/types/key"
+)
+
+const (
+	localAuthFailuresCachePrefix     = "local-auth-failures-"
+	localAuthAddrFailuresCachePrefix = "local-auth-addr-failures-"
+	localAuthTOTPCachePrefix         = "local-auth-totp-"
+	localAuthMaxFailures             = 5
+
+	// localAuthMaxAddrFailures is higher than the limit per user as
+	// several users can sign in from behind the same address.
+	localAuthMaxAddrFailures = 20
+
+	// localAuthTOTPReuseWindow covers all periods ValidateTOTP accepts,
+	// a code cannot be used twice while it is valid.
+	localAuthTOTPReuseWindow = 2 * time.Minute
+)
+
+var (
+	errLocalAuthFailed = errors.New("wrong username, password or one-time code")
+	errTooManyFailures = errors.New("too many failed attempts")
+)
+
+// RegisterLocalAuth registers the node with the built-in username and
+// password authentication, or with LDAP. Nodes are sent to OIDC instead
+// when it is configured.
+// Listens in POST /register/:mkey.
+func (h *Headscale) RegisterLocalAuth(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	if !h.passwordAuthEnabled() {
+		http.Error(writer, "built-in authentication is disabled", http.StatusNotFound)
+
+		return
+	}
+
+	var machineKey key.MachinePublic
+	if err := machineKey.UnmarshalText([]byte(mux.Vars(req)["mkey"])); err != nil {
+		log.Warn().Err(err).Msg("Failed to parse incoming nodekey")
+		http.Error(writer, "Wrong params", http.StatusBadRequest)
+
+		return
+	}
+
+	config := registerWebAPITemplateConfig{
+		Key:       machineKey.String(),
+		LocalAuth: true,
+		AskTOTP:   h.cfg.LocalAuth.RequireTOTP,
+	}
+
+	userName := req.PostFormValue("username")
+	user, method, err := h.authenticateLocalUser(
+		userName,
+		req.PostFormValue("password"),
+		req.PostFormValue("totp"),
+		time.Now(),
+		clientAddr(req),
+	)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("user", userName).
+			Str("client_addr", clientAddr(req)).
+			Str("machine_key", machineKey.ShortString()).
+			Msg("Built-in authentication failed")
+
+		config.Error = errLocalAuthFailed.Error()
+		h.renderRegisterWebAPI(writer, http.StatusUnauthorized, config)
+
+		return
+	}
+
+	ipv4, ipv6, err := h.ipAlloc.Next()
+	if err != nil {
+		util.LogErr(err, "could not allocate IP addresses")
+		http.Error(writer, "could not register node", http.StatusInternalServerError)
+
+		return
+	}
+
+	node, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.Node, error) {
+		return db.RegisterNodeFromAuthCallback(
+			tx,
+			h.registrationCache,
+			machineKey,
+			user.Name,
+			nil,
+			method,
+			ipv4, ipv6,
+		)
+	})
+	if err != nil {
+		util.LogErr(err, "could not register node")
+
+		switch {
+		case errors.Is(err, db.ErrNodeNotFoundRegistrationCache):
+			config.Error = "The registration has expired, run tailscale up again."
+		case errors.Is(err, db.ErrUserSuspended), errors.Is(err, db.ErrDifferentRegisteredUser):
+			config.Error = err.Error()
+		default:
+			http.Error(writer, "could not register node", http.StatusInternalServerError)
+
+			return
+		}
+
+		h.renderRegisterWebAPI(writer, http.StatusForbidden, config)
+
+		return
+	}
+
+	log.Info().
+		Str("user", user.Name).
+		Str("node", node.Hostname).
+		Str("method", method).
+		Msg("Registered node with password authentication")
+
+	content, err := renderOIDCCallbackTemplate(writer, &IDTokenClaims{Email: user.Name})
+	if err != nil {
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		util.LogErr(err, "Failed to write response")
+	}
+}
+
+// passwordAuthEnabled reports if nodes can be registered by signing in
+// with a username and password.
+func (h *Headscale) passwordAuthEnabled() bool {
+	return (h.cfg.LocalAuth.Enabled || h.ldap != nil) && len(h.oidcProviders) == 0
+}
+
+// authenticateLocalUser checks the password and, if the user has it
+// enabled or it is required, the one-time code of a user. It returns
+// the registration method the user authenticated with. After
+// localAuthMaxFailures failed attempts the user, and after
+// localAuthMaxAddrFailures the client address, is locked out until the
+// failures expire from the registration cache.
+func (h *Headscale) authenticateLocalUser(
+	userName, password, code string,
+	now time.Time,
+	clientAddr string,
+) (*types.User, string, error) {
+	failuresKey := localAuthFailuresCachePrefix + userName
+	if h.failuresExceeded(failuresKey, localAuthMaxFailures) {
+		return nil, "", fmt.Errorf("%w for user %q", errTooManyFailures, userName)
+	}
+
+	addrFailuresKey := localAuthAddrFailuresCachePrefix + clientAddr
+	if h.failuresExceeded(addrFailuresKey, localAuthMaxAddrFailures) {
+		return nil, "", fmt.Errorf("%w from %s", errTooManyFailures, clientAddr)
+	}
+
+	user, method, err := h.checkCredentials(userName, password, code, now)
+	if err != nil {
+		h.countAttempt(failuresKey)
+		h.countAttempt(addrFailuresKey)
+
+		return nil, "", err
+	}
+
+	h.registrationCache.Delete(failuresKey)
+
+	return user, method, nil
+}
+
+// failuresExceeded reports if the failures counted under key in the
+// registration cache reached limit.
+func (h *Headscale) failuresExceeded(key string, limit int) bool {
+	failures, ok := h.registrationCache.Get(key)
+	if !ok {
+		return false
+	}
+
+	count, ok := failures.(int)
+
+	return ok && count >= limit
+}
+
+// countAttempt counts an attempt under key and returns the attempts
+// counted so far, they expire from the registration cache
+// registerCacheExpiration after the first.
+func (h *Headscale) countAttempt(key string) int {
+	for {
+		if h.registrationCache.Add(key, 1, registerCacheExpiration) == nil {
+			return 1
+		}
+
+		// The count can expire between Add and IncrementInt.
+		if count, err := h.registrationCache.IncrementInt(key, 1); err == nil {
+			return count
+		}
+	}
+}
+
+// checkCredentials checks the password of users with a password set in
+// headscale, other users are authenticated with LDAP if configured and
+// created on their first sign in.
+func (h *Headscale) checkCredentials(
+	userName, password, code string,
+	now time.Time,
+) (*types.User, string, error) {
+	user, err := h.db.GetUser(userName)
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		return nil, "", err
+	}
+
+	method := util.RegisterMethodPassword
+	switch {
+	case user != nil && user.PasswordHash != "" && h.cfg.LocalAuth.Enabled:
+		ok, err := localauth.CheckPassword(user.PasswordHash, password)
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			return nil, "", fmt.Errorf("wrong password for user %q", userName)
+		}
+
+	case h.ldap != nil:
+		ldapName, err := h.ldap.Authenticate(userName, password)
+		if err != nil {
+			return nil, "", err
+		}
+
+		name, err := util.NormalizeToFQDNRules(ldapName, false)
+		if err != nil {
+			return nil, "", err
+		}
+
+		user, err = h.db.GetUser(name)
+		if errors.Is(err, db.ErrUserNotFound) {
+			user, err = h.createUser(name)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		method = util.RegisterMethodLDAP
+
+	default:
+		return nil, "", fmt.Errorf("user %q has no password", userName)
+	}
+
+	if user.TOTPSecret == "" {
+		if h.cfg.LocalAuth.RequireTOTP {
+			return nil, "", fmt.Errorf("user %q has no TOTP secret", userName)
+		}
+
+		return user, method, nil
+	}
+
+	if !localauth.ValidateTOTP(user.TOTPSecret, code, now) {
+		return nil, "", fmt.Errorf("wrong one-time code for user %q", userName)
+	}
+
+	if h.registrationCache.Add(
+		localAuthTOTPCachePrefix+userName+"-"+code,
+		true,
+		localAuthTOTPReuseWindow,
+	) != nil {
+		return nil, "", fmt.Errorf("one-time code for user %q was already used", userName)
+	}
+
+	return user, method, nil
+}
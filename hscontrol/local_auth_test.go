@@ -0,0 +1,107 @@
+package hscontrol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/localauth"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+)
+
+func TestAuthenticateLocalUser(t *testing.T) {
+	hsdb, err := db.NewHeadscaleDatabase(
+		types.DatabaseConfig{
+			Type: "sqlite3",
+			Sqlite: types.SqliteConfig{
+				Path: t.TempDir() + "/headscale_test.db",
+			},
+		},
+		"",
+	)
+	if err != nil {
+		t.Fatalf("creating database: %s", err)
+	}
+
+	h := &Headscale{
+		cfg:               &types.Config{LocalAuth: types.LocalAuthConfig{Enabled: true}},
+		db:                hsdb,
+		registrationCache: cache.New(registerCacheExpiration, time.Minute),
+	}
+
+	hash, err := localauth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %s", err)
+	}
+
+	secret, err := localauth.NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret() error = %s", err)
+	}
+
+	_, err = db.Write(hsdb.DB, func(tx *gorm.DB) (*types.User, error) {
+		if _, err := db.CreateUser(tx, "local"); err != nil {
+			return nil, err
+		}
+
+		if _, err := db.SetUserPassword(tx, "local", hash); err != nil {
+			return nil, err
+		}
+
+		return db.SetUserTOTP(tx, "local", secret)
+	})
+	if err != nil {
+		t.Fatalf("setting up user: %s", err)
+	}
+
+	now := time.Now()
+	code, err := localauth.TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %s", err)
+	}
+
+	if _, _, err := h.authenticateLocalUser("local", "correct horse", "", now, "192.0.2.1"); err == nil {
+		t.Errorf("authenticateLocalUser() without a one-time code succeeded")
+	}
+
+	user, method, err := h.authenticateLocalUser("local", "correct horse", code, now, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("authenticateLocalUser() error = %s", err)
+	}
+	if user.Name != "local" || method != util.RegisterMethodPassword {
+		t.Errorf("authenticateLocalUser() = %q, %q, want %q, %q", user.Name, method, "local", util.RegisterMethodPassword)
+	}
+
+	if _, _, err := h.authenticateLocalUser("local", "correct horse", code, now, "192.0.2.1"); err == nil {
+		t.Errorf("authenticateLocalUser() accepted a used one-time code")
+	}
+
+	// Failures from one address lock it out across users.
+	for i := range localAuthMaxAddrFailures {
+		_, _, _ = h.authenticateLocalUser(fmt.Sprintf("unknown%d", i), "wrong horse", "", now, "192.0.2.2")
+	}
+	if _, _, err := h.authenticateLocalUser("local", "wrong horse", "", now, "192.0.2.2"); !errors.Is(err, errTooManyFailures) {
+		t.Errorf("authenticateLocalUser() from a locked out address error = %v, want %v", err, errTooManyFailures)
+	}
+
+	for range localAuthMaxFailures {
+		if _, _, err := h.authenticateLocalUser("local", "wrong horse", code, now, "192.0.2.1"); err == nil {
+			t.Fatalf("authenticateLocalUser() accepted a wrong password")
+		}
+	}
+
+	later := now.Add(30 * time.Second)
+	next, err := localauth.TOTPCode(secret, later)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %s", err)
+	}
+
+	if _, _, err := h.authenticateLocalUser("local", "correct horse", next, later, "192.0.2.1"); err == nil {
+		t.Errorf("authenticateLocalUser() succeeded for a locked out user")
+	}
+}
@@ -0,0 +1,63 @@
+package localauth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %s", err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		t.Errorf("HashPassword() = %q, want an argon2id hash", hash)
+	}
+
+	if ok, err := CheckPassword(hash, "correct horse"); err != nil || !ok {
+		t.Errorf("CheckPassword() with the right password = %t, %v", ok, err)
+	}
+
+	if ok, err := CheckPassword(hash, "wrong horse"); err != nil || ok {
+		t.Errorf("CheckPassword() with a wrong password = %t, %v", ok, err)
+	}
+
+	if _, err := CheckPassword("$2a$10$bcrypt", "correct horse"); err == nil {
+		t.Errorf("CheckPassword() accepted a hash of another algorithm")
+	}
+
+	if _, err := HashPassword("short"); err == nil {
+		t.Errorf("HashPassword() accepted a short password")
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// Test vector from RFC 6238, appendix B.
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).
+		EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("TOTPCode() error = %s", err)
+	}
+
+	if code != "287082" {
+		t.Errorf("TOTPCode() = %q, want %q", code, "287082")
+	}
+
+	if !ValidateTOTP(secret, code, now.Add(totpPeriod)) {
+		t.Errorf("ValidateTOTP() rejected the code of the previous period")
+	}
+
+	if ValidateTOTP(secret, code, now.Add(3*totpPeriod)) {
+		t.Errorf("ValidateTOTP() accepted an old code")
+	}
+
+	if ValidateTOTP(secret, "", now) {
+		t.Errorf("ValidateTOTP() accepted an empty code")
+	}
+}
@@ -0,0 +1,119 @@
+package mapper
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"tailscale.com/smallzstd"
+)
+
+const defaultBrotliQuality = 4
+
+var mapResponseBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "headscale",
+	Name:      "mapresponse_bytes_total",
+	Help:      "total bytes of map responses by compression, before (raw) and after (sent) compressing",
+}, []string{"compression", "stage"})
+
+// zstdLevels are the zstd_level values of the map_compression
+// configuration.
+var zstdLevels = map[string]zstd.EncoderLevel{
+	"":        zstd.SpeedFastest,
+	"fastest": zstd.SpeedFastest,
+	"default": zstd.SpeedDefault,
+	"better":  zstd.SpeedBetterCompression,
+	"best":    zstd.SpeedBestCompression,
+}
+
+// compressor compresses map responses with the method the client asked
+// for in its MapRequest, zstd for Tailscale clients.
+type compressor struct {
+	zstd   sync.Pool
+	brotli sync.Pool
+}
+
+func newCompressor(cfg types.MapCompressionConfig) *compressor {
+	level, ok := zstdLevels[cfg.ZstdLevel]
+	if !ok {
+		level = zstd.SpeedFastest
+	}
+
+	quality := cfg.BrotliQuality
+	if quality <= 0 {
+		quality = defaultBrotliQuality
+	}
+
+	return &compressor{
+		zstd: sync.Pool{
+			New: func() any {
+				encoder, err := smallzstd.NewEncoder(nil, zstd.WithEncoderLevel(level))
+				if err != nil {
+					panic(err)
+				}
+
+				return encoder
+			},
+		},
+		brotli: sync.Pool{
+			New: func() any {
+				return brotli.NewWriterLevel(nil, quality)
+			},
+		},
+	}
+}
+
+// compress returns the body compressed with the given method, or as is
+// if the method is not supported.
+func (c *compressor) compress(method string, in []byte) []byte {
+	var out []byte
+
+	switch method {
+	case util.ZstdCompression:
+		out = c.zstdEncode(in)
+	case util.BrotliCompression:
+		out = c.brotliEncode(in)
+	default:
+		method = "none"
+		out = in
+	}
+
+	mapResponseBytes.WithLabelValues(method, "raw").Add(float64(len(in)))
+	mapResponseBytes.WithLabelValues(method, "sent").Add(float64(len(out)))
+
+	return out
+}
+
+func (c *compressor) zstdEncode(in []byte) []byte {
+	encoder, ok := c.zstd.Get().(*zstd.Encoder)
+	if !ok {
+		panic("invalid type in sync pool")
+	}
+	out := encoder.EncodeAll(in, nil)
+	_ = encoder.Close()
+	c.zstd.Put(encoder)
+
+	return out
+}
+
+func (c *compressor) brotliEncode(in []byte) []byte {
+	writer, ok := c.brotli.Get().(*brotli.Writer)
+	if !ok {
+		panic("invalid type in sync pool")
+	}
+	defer c.brotli.Put(writer)
+
+	var out bytes.Buffer
+	writer.Reset(&out)
+
+	// Writing to a bytes.Buffer does not fail.
+	_, _ = writer.Write(in)
+	_ = writer.Close()
+
+	return out.Bytes()
+}
@@ -0,0 +1,58 @@
+package mapper
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+	in := bytes.Repeat([]byte(`{"Peers":[{"Name":"node.example.com"}]}`), 1000)
+
+	decoders := map[string]func([]byte) ([]byte, error){
+		util.ZstdCompression: func(b []byte) ([]byte, error) {
+			decoder, err := zstd.NewReader(nil)
+			if err != nil {
+				return nil, err
+			}
+			defer decoder.Close()
+
+			return decoder.DecodeAll(b, nil)
+		},
+		util.BrotliCompression: func(b []byte) ([]byte, error) {
+			return io.ReadAll(brotli.NewReader(bytes.NewReader(b)))
+		},
+	}
+
+	for _, level := range []string{"fastest", "best"} {
+		c := newCompressor(types.MapCompressionConfig{ZstdLevel: level, BrotliQuality: 11})
+
+		for method, decode := range decoders {
+			// Twice, to use the pooled encoders again.
+			for range 2 {
+				out := c.compress(method, in)
+				if len(out) >= len(in) {
+					t.Errorf("%s at %s did not compress, %d bytes", method, level, len(out))
+				}
+
+				got, err := decode(out)
+				if err != nil {
+					t.Fatalf("decoding %s at %s: %s", method, level, err)
+				}
+				if !bytes.Equal(got, in) {
+					t.Errorf("%s at %s did not round trip", method, level)
+				}
+			}
+		}
+	}
+
+	c := newCompressor(types.MapCompressionConfig{})
+	if out := c.compress("", in); !bytes.Equal(out, in) {
+		t.Errorf("uncompressed response was changed")
+	}
+}
@@ -1,34 +1,39 @@
 package mapper
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
 	"slices"
 	"sort"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 
 	mapset "github.com/deckarep/golang-set/v2"
 	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/mapsign"
 	"github.com/juanfont/headscale/hscontrol/notifier"
 	"github.com/juanfont/headscale/hscontrol/policy"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/klauspost/compress/zstd"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
 	"tailscale.com/envknob"
-	"tailscale.com/smallzstd"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
+	"tailscale.com/types/opt"
+	"tailscale.com/util/set"
 )
 
+var mapperLog = util.NewSubsystemLogger(util.LogSubsystemMapper)
+
 const (
 	nextDNSDoHPrefix           = "https://dns.nextdns.io"
 	reservedResponseHeaderSize = 4
@@ -54,8 +59,12 @@ type Mapper struct {
 	// TODO(kradalby): figure out if this is the format we want this in
 	db      *db.HSDatabase
 	cfg     *types.Config
-	derpMap *tailcfg.DERPMap
+	derpMap atomic.Pointer[tailcfg.DERPMap]
 	notif   *notifier.Notifier
+	pool    *compilePool
+
+	compressor *compressor
+	signer     *mapsign.Signer
 
 	uid     string
 	created time.Time
@@ -75,16 +84,27 @@ func NewMapper(
 ) *Mapper {
 	uid, _ := util.GenerateRandomStringDNSSafe(mapperIDLength)
 
-	return &Mapper{
-		db:      db,
-		cfg:     cfg,
-		derpMap: derpMap,
-		notif:   notif,
+	m := &Mapper{
+		db:    db,
+		cfg:   cfg,
+		notif: notif,
+		pool:  newCompilePool(cfg.Tuning.PolicyCompileWorkers),
+
+		compressor: newCompressor(cfg.MapCompression),
 
 		uid:     uid,
 		created: time.Now(),
 		seq:     0,
 	}
+	m.derpMap.Store(derpMap)
+
+	return m
+}
+
+// SetSigner signs the DERPMap and the DNS configuration of the map
+// responses with the signer.
+func (m *Mapper) SetSigner(signer *mapsign.Signer) {
+	m.signer = signer
 }
 
 func (m *Mapper) String() string {
@@ -116,15 +136,16 @@ func generateDNSConfig(
 	node *types.Node,
 	peers types.Nodes,
 ) *tailcfg.DNSConfig {
-	if cfg.DNSConfig == nil {
+	reloadable := cfg.Reloadable()
+	if reloadable.DNSConfig == nil {
 		return nil
 	}
 
-	dnsConfig := cfg.DNSConfig.Clone()
+	dnsConfig := reloadable.DNSConfig.Clone()
 
 	// if MagicDNS is enabled
 	if dnsConfig.Proxied {
-		if cfg.DNSUserNameInMagicDNS {
+		if reloadable.DNSUserNameInMagicDNS {
 			// Only inject the Search Domain of the current user
 			// shared nodes should use their full FQDN
 			dnsConfig.Domains = append(
@@ -180,6 +201,7 @@ func addNextDNSMetadata(resolvers []*dnstype.Resolver, node *types.Node) {
 // fullMapResponse creates a complete MapResponse for a node.
 // It is a separate function to make testing easier.
 func (m *Mapper) fullMapResponse(
+	ctx context.Context,
 	node *types.Node,
 	peers types.Nodes,
 	pol *policy.ACLPolicy,
@@ -190,7 +212,11 @@ func (m *Mapper) fullMapResponse(
 		return nil, err
 	}
 
+	release := m.pool.acquire()
+	defer release()
+
 	err = appendPeerChanges(
+		ctx,
 		resp,
 		true, // full change
 		pol,
@@ -198,7 +224,7 @@ func (m *Mapper) fullMapResponse(
 		capVer,
 		peers,
 		peers,
-		m.cfg,
+		m.cfg, m.pool,
 	)
 	if err != nil {
 		return nil, err
@@ -209,21 +235,30 @@ func (m *Mapper) fullMapResponse(
 
 // FullMapResponse returns a MapResponse for the given node.
 func (m *Mapper) FullMapResponse(
+	ctx context.Context,
 	mapRequest tailcfg.MapRequest,
 	node *types.Node,
 	pol *policy.ACLPolicy,
 	messages ...string,
-) ([]byte, error) {
+) (_ []byte, err error) {
+	ctx, span := util.StartSpan(ctx, "headscale.map.full", nodeAttributes(node)...)
+	defer func() { util.EndSpan(span, err) }()
+
 	peers, err := m.ListPeers(node.ID)
 	if err != nil {
 		return nil, err
 	}
+	span.SetAttributes(attribute.Int("peers", len(peers)))
 
-	resp, err := m.fullMapResponse(node, peers, pol, mapRequest.Version)
+	resp, err := m.fullMapResponse(ctx, node, peers, pol, mapRequest.Version)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := m.signMapResponse(resp, node, peers, pol, mapRequest.Version); err != nil {
+		return nil, err
+	}
+
 	return m.marshalMapResponse(mapRequest, resp, node, mapRequest.Compress, messages...)
 }
 
@@ -241,6 +276,10 @@ func (m *Mapper) ReadOnlyMapResponse(
 		return nil, err
 	}
 
+	if err := m.signMapResponse(resp, node, nil, pol, mapRequest.Version); err != nil {
+		return nil, err
+	}
+
 	return m.marshalMapResponse(mapRequest, resp, node, mapRequest.Compress, messages...)
 }
 
@@ -258,23 +297,196 @@ func (m *Mapper) DERPMapResponse(
 	mapRequest tailcfg.MapRequest,
 	node *types.Node,
 	derpMap *tailcfg.DERPMap,
+	pol *policy.ACLPolicy,
 ) ([]byte, error) {
-	m.derpMap = derpMap
+	m.derpMap.Store(derpMap)
+
+	resp := m.baseMapResponse()
+	resp.DERPMap = m.derpMapFor(node, pol)
+
+	if err := m.signMapResponse(&resp, node, nil, pol, mapRequest.Version); err != nil {
+		return nil, err
+	}
+
+	return m.marshalMapResponse(mapRequest, &resp, node, mapRequest.Compress)
+}
+
+// signMapResponse signs the DERPMap and the DNS configuration of a map
+// response carrying any of them or the node itself. The signature is a
+// capability of the node, replacing the one the node has, so the
+// response carries all three and the node holds what was signed. The
+// peers are listed when nil.
+func (m *Mapper) signMapResponse(
+	resp *tailcfg.MapResponse,
+	node *types.Node,
+	peers types.Nodes,
+	pol *policy.ACLPolicy,
+	capVer tailcfg.CapabilityVersion,
+) error {
+	if m.signer == nil || (resp.Node == nil && resp.DERPMap == nil && resp.DNSConfig == nil) {
+		return nil
+	}
+
+	if resp.Node == nil {
+		tailnode, err := tailNode(node, capVer, pol, m.cfg)
+		if err != nil {
+			return err
+		}
+		resp.Node = tailnode
+	}
+
+	if resp.DERPMap == nil {
+		resp.DERPMap = m.derpMapFor(node, pol)
+	}
+
+	if resp.DNSConfig == nil {
+		if peers == nil {
+			var err error
+			peers, err = m.ListPeers(node.ID)
+			if err != nil {
+				return err
+			}
+		}
+		resp.DNSConfig = generateDNSConfig(m.cfg, m.cfg.Reloadable().BaseDomain, node, peers)
+	}
+
+	nodeKey, err := resp.Node.Key.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	sig, err := m.signer.Sign(string(nodeKey), resp.DERPMap, resp.DNSConfig)
+	if err != nil {
+		return fmt.Errorf("signing map response: %w", err)
+	}
+
+	return m.signer.Attach(resp.Node, sig)
+}
+
+// derpMapFor returns the DERP map sent to the node. The regions the
+// derpRestrictions of the policy do not allow the node to relay
+// through are left out. When the node is pinned to a home region, the
+// other regions are marked to be avoided so the node picks it as its
+// home.
+func (m *Mapper) derpMapFor(node *types.Node, pol *policy.ACLPolicy) *tailcfg.DERPMap {
+	current := m.derpMap.Load()
+	if current == nil {
+		return nil
+	}
+
+	derpMap := current
+
+	regions, restricted, err := pol.DERPRegions(node)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Uint64("node.id", node.ID.Uint64()).
+			Msg("Failed to resolve the DERP regions of the node from the policy")
+	}
+	if restricted {
+		derpMap = restrictDERPMap(derpMap, regions)
+	}
+
+	home := m.derpHomeRegion(node, pol)
+	if home == 0 {
+		return derpMap
+	}
+
+	if _, ok := derpMap.Regions[home]; !ok {
+		return derpMap
+	}
+
+	if derpMap == current {
+		derpMap = derpMap.Clone()
+	}
+	for id, region := range derpMap.Regions {
+		if id != home {
+			region.Avoid = true
+		}
+	}
+
+	return derpMap
+}
+
+// restrictDERPMap returns a copy of the DERP map with only the regions
+// listed. Without regions, every region is kept for STUN only: the
+// node cannot relay through them but still discovers its public
+// endpoints to connect directly to its peers.
+func restrictDERPMap(derpMap *tailcfg.DERPMap, regions []int) *tailcfg.DERPMap {
+	restricted := derpMap.Clone()
+
+	if len(regions) == 0 {
+		for _, region := range restricted.Regions {
+			for _, node := range region.Nodes {
+				node.STUNOnly = true
+			}
+		}
+
+		return restricted
+	}
+
+	for id := range restricted.Regions {
+		if !slices.Contains(regions, id) {
+			delete(restricted.Regions, id)
+		}
+	}
+
+	return restricted
+}
+
+// derpHomeRegion returns the DERP region the node is pinned to, by
+// the node itself, then the derpHomes of the policy, then the
+// prefer_derp_region of client_tuning. 0 leaves the choice to the
+// client.
+func (m *Mapper) derpHomeRegion(node *types.Node, pol *policy.ACLPolicy) int {
+	if node.DERPHomeRegion != 0 {
+		return node.DERPHomeRegion
+	}
+
+	home, err := pol.DERPHomeRegion(node)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Uint64("node.id", node.ID.Uint64()).
+			Msg("Failed to resolve the DERP home region of the node from the policy")
+	}
+	if home != 0 {
+		return home
+	}
+
+	if tuning := m.cfg.ClientTuningFor(node, nodeTags(node, pol)); tuning != nil {
+		return tuning.PreferDERPRegion
+	}
+
+	return 0
+}
 
+// PingRequestResponse returns a MapResponse asking the node to answer
+// the ping request.
+func (m *Mapper) PingRequestResponse(
+	mapRequest tailcfg.MapRequest,
+	node *types.Node,
+	pingRequest *tailcfg.PingRequest,
+) ([]byte, error) {
 	resp := m.baseMapResponse()
-	resp.DERPMap = derpMap
+	resp.PingRequest = pingRequest
 
 	return m.marshalMapResponse(mapRequest, &resp, node, mapRequest.Compress)
 }
 
 func (m *Mapper) PeerChangedResponse(
+	ctx context.Context,
 	mapRequest tailcfg.MapRequest,
 	node *types.Node,
 	changed map[types.NodeID]bool,
 	patches []*tailcfg.PeerChange,
 	pol *policy.ACLPolicy,
 	messages ...string,
-) ([]byte, error) {
+) (_ []byte, err error) {
+	ctx, span := util.StartSpan(ctx, "headscale.map.peer_changed",
+		append(nodeAttributes(node), attribute.Int("changed", len(changed)))...)
+	defer func() { util.EndSpan(span, err) }()
+
 	resp := m.baseMapResponse()
 
 	peers, err := m.ListPeers(node.ID)
@@ -299,7 +511,11 @@ func (m *Mapper) PeerChangedResponse(
 		}
 	}
 
+	release := m.pool.acquire()
+	defer release()
+
 	err = appendPeerChanges(
+		ctx,
 		&resp,
 		false, // partial change
 		pol,
@@ -307,7 +523,7 @@ func (m *Mapper) PeerChangedResponse(
 		mapRequest.Version,
 		peers,
 		changedNodes,
-		m.cfg,
+		m.cfg, m.pool,
 	)
 	if err != nil {
 		return nil, err
@@ -338,6 +554,10 @@ func (m *Mapper) PeerChangedResponse(
 	}
 	resp.Node = tailnode
 
+	if err := m.signMapResponse(&resp, node, peers, pol, mapRequest.Version); err != nil {
+		return nil, err
+	}
+
 	return m.marshalMapResponse(mapRequest, &resp, node, mapRequest.Compress, messages...)
 }
 
@@ -410,19 +630,14 @@ func (m *Mapper) marshalMapResponse(
 			fmt.Sprintf("%s-%s-%d-%s.json", now, m.uid, atomic.LoadUint64(&m.seq), responseType),
 		)
 
-		log.Trace().Msgf("Writing MapResponse to %s", mapResponsePath)
+		mapperLog.Trace().Msgf("Writing MapResponse to %s", mapResponsePath)
 		err = os.WriteFile(mapResponsePath, body, perms)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	var respBody []byte
-	if compression == util.ZstdCompression {
-		respBody = zstdEncode(jsonBody)
-	} else {
-		respBody = jsonBody
-	}
+	respBody := m.compressor.compress(compression, jsonBody)
 
 	data := make([]byte, reservedResponseHeaderSize)
 	binary.LittleEndian.PutUint32(data, uint32(len(respBody)))
@@ -431,29 +646,34 @@ func (m *Mapper) marshalMapResponse(
 	return data, nil
 }
 
-func zstdEncode(in []byte) []byte {
-	encoder, ok := zstdEncoderPool.Get().(*zstd.Encoder)
-	if !ok {
-		panic("invalid type in sync pool")
+// clientVersion tells the node whether it runs the latest version
+// configured in client_updates, or nil if there is nothing to tell.
+func clientVersion(node *types.Node, cfg types.ClientUpdatesConfig) *tailcfg.ClientVersion {
+	version := node.ClientVersion()
+	if cfg.LatestVersion == "" || version == "" {
+		return nil
 	}
-	out := encoder.EncodeAll(in, nil)
-	_ = encoder.Close()
-	zstdEncoderPool.Put(encoder)
 
-	return out
-}
+	if util.TailscaleVersionNewerOrEqual(cfg.LatestVersion, version) {
+		return &tailcfg.ClientVersion{RunningLatest: true}
+	}
 
-var zstdEncoderPool = &sync.Pool{
-	New: func() any {
-		encoder, err := smallzstd.NewEncoder(
-			nil,
-			zstd.WithEncoderLevel(zstd.SpeedFastest))
-		if err != nil {
-			panic(err)
-		}
+	clientVersion := &tailcfg.ClientVersion{
+		LatestVersion: cfg.LatestVersion,
+		Notify:        cfg.Notify,
+	}
+
+	// Clients older than the minimum version always notify their user.
+	if cfg.MinimumVersion != "" && !util.TailscaleVersionNewerOrEqual(cfg.MinimumVersion, version) {
+		clientVersion.UrgentSecurityUpdate = true
+		clientVersion.Notify = true
+	}
+
+	if clientVersion.Notify {
+		clientVersion.NotifyURL = cfg.NotifyURL
+	}
 
-		return encoder
-	},
+	return clientVersion
 }
 
 // baseMapResponse returns a tailcfg.MapResponse with
@@ -487,9 +707,9 @@ func (m *Mapper) baseWithConfigMapResponse(
 	}
 	resp.Node = tailnode
 
-	resp.DERPMap = m.derpMap
+	resp.DERPMap = m.derpMapFor(node, pol)
 
-	resp.Domain = m.cfg.BaseDomain
+	resp.Domain = m.cfg.Reloadable().BaseDomain
 
 	// Do not instruct clients to collect services we do not
 	// support or do anything with them
@@ -497,6 +717,21 @@ func (m *Mapper) baseWithConfigMapResponse(
 
 	resp.KeepAlive = false
 
+	//   - 85: 2024-01-05: Client understands MaxKeyDuration
+	if capVer >= 85 {
+		resp.MaxKeyDuration = m.cfg.NodeKeyRenewal.Expiry
+	}
+
+	//   - 73: 2023-09-01: Non-Windows clients expect to receive ClientVersion
+	if capVer >= 73 {
+		resp.ClientVersion = clientVersion(node, m.cfg.ClientUpdates)
+	}
+
+	//   - 83: 2023-12-18: Client understands DefaultAutoUpdate
+	if capVer >= 83 && m.cfg.ClientUpdates.AutoUpdate {
+		resp.DefaultAutoUpdate = opt.NewBool(true)
+	}
+
 	resp.Debug = &tailcfg.Debug{
 		DisableLogTail: !m.cfg.LogTail.Enabled,
 	}
@@ -518,6 +753,115 @@ func (m *Mapper) ListPeers(nodeID types.NodeID) (types.Nodes, error) {
 	return peers, nil
 }
 
+// VisiblePeers returns the IDs of the peers of the node the policy
+// lets it see, the peers map responses describe to it.
+func (m *Mapper) VisiblePeers(
+	node *types.Node,
+	pol *policy.ACLPolicy,
+) (set.Set[types.NodeID], error) {
+	peers, err := m.db.ListPeers(node.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	packetFilter, err := pol.CompileFilterRules(append(peers, node))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packetFilter) > 0 {
+		peers = policy.FilterNodesByACL(node, peers, packetFilter)
+	}
+
+	visible := make(set.Set[types.NodeID], len(peers))
+	for _, peer := range peers {
+		visible.Add(peer.ID)
+	}
+
+	return visible, nil
+}
+
+// compileFilterRules compiles the filter rules of the policy for the
+// nodes in a span.
+func compileFilterRules(
+	ctx context.Context,
+	pol *policy.ACLPolicy,
+	nodes types.Nodes,
+) (rules []tailcfg.FilterRule, err error) {
+	_, span := util.StartSpan(ctx, "headscale.policy.compile", attribute.Int("nodes", len(nodes)))
+	defer func() { util.EndSpan(span, err) }()
+
+	rules, err = pol.CompileFilterRules(nodes)
+	span.SetAttributes(attribute.Int("rules", len(rules)))
+
+	return rules, err
+}
+
+// filterIPFamilies removes the addresses and prefixes of the disabled
+// address families from the filter rules, and the rules left without
+// sources or destinations.
+func filterIPFamilies(rules []tailcfg.FilterRule, families types.IPFamiliesConfig) []tailcfg.FilterRule {
+	if !families.DisableIPv4 && !families.DisableIPv6 {
+		return rules
+	}
+
+	disabled := func(ip string) bool {
+		if prefix, err := netip.ParsePrefix(ip); err == nil {
+			return families.Disabled(prefix.Addr())
+		}
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			return families.Disabled(addr)
+		}
+		if from, _, ok := strings.Cut(ip, "-"); ok {
+			if addr, err := netip.ParseAddr(from); err == nil {
+				return families.Disabled(addr)
+			}
+		}
+
+		// Wildcards apply to the enabled family.
+		return false
+	}
+
+	filtered := make([]tailcfg.FilterRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.SrcIPs = slices.DeleteFunc(slices.Clone(rule.SrcIPs), disabled)
+		rule.DstPorts = slices.DeleteFunc(slices.Clone(rule.DstPorts), func(dst tailcfg.NetPortRange) bool {
+			return disabled(dst.IP)
+		})
+
+		var grants []tailcfg.CapGrant
+		for _, grant := range rule.CapGrant {
+			grant.Dsts = slices.DeleteFunc(slices.Clone(grant.Dsts), func(prefix netip.Prefix) bool {
+				return families.Disabled(prefix.Addr())
+			})
+			if len(grant.Dsts) > 0 {
+				grants = append(grants, grant)
+			}
+		}
+		rule.CapGrant = grants
+
+		if len(rule.SrcIPs) == 0 || (len(rule.DstPorts) == 0 && len(rule.CapGrant) == 0) {
+			continue
+		}
+
+		filtered = append(filtered, rule)
+	}
+
+	return filtered
+}
+
+// nodeAttributes returns the span attributes identifying the node.
+func nodeAttributes(node *types.Node) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("node.id", int64(node.ID)),
+		attribute.String("node.name", node.Hostname),
+	}
+}
+
+func isExpired(node *types.Node) bool {
+	return node.IsExpired()
+}
+
 func nodeMapToList(nodes map[uint64]*types.Node) types.Nodes {
 	ret := make(types.Nodes, 0)
 
@@ -531,6 +875,7 @@ func nodeMapToList(nodes map[uint64]*types.Node) types.Nodes {
 // appendPeerChanges mutates a tailcfg.MapResponse with all the
 // necessary changes when peers have changed.
 func appendPeerChanges(
+	ctx context.Context,
 	resp *tailcfg.MapResponse,
 
 	fullChange bool,
@@ -540,28 +885,68 @@ func appendPeerChanges(
 	peers types.Nodes,
 	changed types.Nodes,
 	cfg *types.Config,
+	pool *compilePool,
 ) error {
-	packetFilter, err := pol.CompileFilterRules(append(peers, node))
+	nodes := append(peers, node)
+	packetFilter, err := compileFilterRules(ctx, pol, nodes)
 	if err != nil {
 		return err
 	}
+	packetFilter = filterIPFamilies(packetFilter, cfg.IPFamilies)
 
-	sshPolicy, err := pol.CompileSSHPolicy(node, peers)
+	// In soft expiry mode, expired nodes stay visible to their peers
+	// but the packet filter only allows traffic between active nodes.
+	activeFilter := packetFilter
+	if cfg.NodeExpiry.Mode == types.NodeExpiryModeSoft && slices.ContainsFunc(nodes, isExpired) {
+		activeFilter, err = compileFilterRules(ctx, pol, slices.DeleteFunc(slices.Clone(nodes), isExpired))
+		if err != nil {
+			return err
+		}
+		activeFilter = filterIPFamilies(activeFilter, cfg.IPFamilies)
+	}
+
+	// The SSH policy, the peers the node can see and its packet
+	// filter only depend on the compiled rules and are compiled
+	// in parallel.
+	var sshPolicy *tailcfg.SSHPolicy
+	var reduced []tailcfg.FilterRule
+	err = pool.run(
+		func() error {
+			// If there are filter rules present, see if there are any nodes that cannot
+			// access each-other at all and remove them from the peers.
+			if len(packetFilter) > 0 {
+				changed = pool.filterPeers(node, changed, packetFilter)
+			}
+
+			return nil
+		},
+		func() error {
+			var err error
+			sshPolicy, err = pol.CompileSSHPolicy(node, peers)
+
+			return err
+		},
+		func() error {
+			reduced = policy.ReduceFilterRules(node, activeFilter)
+
+			return nil
+		},
+	)
 	if err != nil {
 		return err
 	}
 
-	// If there are filter rules present, see if there are any nodes that cannot
-	// access each-other at all and remove them from the peers.
-	if len(packetFilter) > 0 {
-		changed = policy.FilterNodesByACL(node, changed, packetFilter)
+	// An SSH policy without rules rejects all connections, in case the
+	// SSH server of the node still runs.
+	if sshDisabled(node, pol, cfg) {
+		sshPolicy = &tailcfg.SSHPolicy{}
 	}
 
 	profiles := generateUserProfiles(node, changed)
 
 	dnsConfig := generateDNSConfig(
 		cfg,
-		cfg.BaseDomain,
+		cfg.Reloadable().BaseDomain,
 		node,
 		peers,
 	)
@@ -571,6 +956,16 @@ func appendPeerChanges(
 		return err
 	}
 
+	// Exit nodes are only offered to nodes the policy allows to reach
+	// the Internet, e.g. with autogroup:internet.
+	if !policy.CanUseExitNodes(node, packetFilter) {
+		for _, peer := range tailPeers {
+			peer.AllowedIPs = slices.DeleteFunc(peer.AllowedIPs, func(prefix netip.Prefix) bool {
+				return prefix == types.ExitRouteV4 || prefix == types.ExitRouteV6
+			})
+		}
+	}
+
 	// Peers is always returned sorted by Node.ID.
 	sort.SliceStable(tailPeers, func(x, y int) bool {
 		return tailPeers[x].ID < tailPeers[y].ID
@@ -591,7 +986,7 @@ func appendPeerChanges(
 		// new PacketFilters field and "base" allows us to send a full update when we
 		// have to send an empty list, avoiding the hack in the else block.
 		resp.PacketFilters = map[string][]tailcfg.FilterRule{
-			"base": policy.ReduceFilterRules(node, packetFilter),
+			"base": reduced,
 		}
 	} else {
 		// This is a hack to avoid sending an empty list of packet filters.
@@ -599,11 +994,10 @@ func appendPeerChanges(
 		// be omitted, causing the client to consider it unchanged, keeping the
 		// previous packet filter. Worst case, this can cause a node that previously
 		// has access to a node to _not_ loose access if an empty (allow none) is sent.
-		reduced := policy.ReduceFilterRules(node, packetFilter)
 		if len(reduced) > 0 {
 			resp.PacketFilter = reduced
 		} else {
-			resp.PacketFilter = packetFilter
+			resp.PacketFilter = activeFilter
 		}
 	}
 
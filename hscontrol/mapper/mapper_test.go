@@ -1,16 +1,20 @@
 package mapper
 
 import (
+	"context"
 	"fmt"
 	"net/netip"
+	"sort"
 	"testing"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/juanfont/headscale/hscontrol/mapsign"
 	"github.com/juanfont/headscale/hscontrol/policy"
 	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
 	"gopkg.in/check.v1"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
@@ -479,6 +483,7 @@ func Test_fullMapResponse(t *testing.T) {
 			)
 
 			got, err := mappy.fullMapResponse(
+				context.Background(),
 				tt.node,
 				tt.peers,
 				tt.pol,
@@ -505,3 +510,360 @@ func Test_fullMapResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterIPFamilies(t *testing.T) {
+	rules := []tailcfg.FilterRule{
+		{
+			SrcIPs: []string{"100.64.0.1/32", "fd7a:115c:a1e0::1/128"},
+			DstPorts: []tailcfg.NetPortRange{
+				{IP: "100.64.0.2/32", Ports: tailcfg.PortRangeAny},
+				{IP: "fd7a:115c:a1e0::2/128", Ports: tailcfg.PortRangeAny},
+			},
+		},
+		{
+			SrcIPs:   []string{"*"},
+			DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}},
+		},
+		{
+			SrcIPs:   []string{"fd7a:115c:a1e0::3/128"},
+			DstPorts: []tailcfg.NetPortRange{{IP: "100.64.0.2/32", Ports: tailcfg.PortRangeAny}},
+		},
+		{
+			SrcIPs: []string{"100.64.0.1-100.64.0.9"},
+			CapGrant: []tailcfg.CapGrant{
+				{Dsts: []netip.Prefix{netip.MustParsePrefix("fd7a:115c:a1e0::2/128")}},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(rules, filterIPFamilies(rules, types.IPFamiliesConfig{}), util.Comparers...); diff != "" {
+		t.Errorf("filterIPFamilies() without disabled families changed the rules (-want +got):\n%s", diff)
+	}
+
+	want := []tailcfg.FilterRule{
+		{
+			SrcIPs:   []string{"100.64.0.1/32"},
+			DstPorts: []tailcfg.NetPortRange{{IP: "100.64.0.2/32", Ports: tailcfg.PortRangeAny}},
+		},
+		{
+			SrcIPs:   []string{"*"},
+			DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}},
+		},
+	}
+
+	got := filterIPFamilies(rules, types.IPFamiliesConfig{DisableIPv6: true})
+	if diff := cmp.Diff(want, got, append(util.Comparers, cmpopts.EquateEmpty())...); diff != "" {
+		t.Errorf("filterIPFamilies() unexpected result (-want +got):\n%s", diff)
+	}
+
+	if len(rules[0].SrcIPs) != 2 {
+		t.Errorf("filterIPFamilies() modified the compiled rules")
+	}
+}
+
+func TestClientVersion(t *testing.T) {
+	cfg := types.ClientUpdatesConfig{
+		LatestVersion: "1.72.1",
+		Notify:        true,
+		NotifyURL:     "https://example.com/update",
+	}
+	nodeWithVersion := func(version string) *types.Node {
+		return &types.Node{Hostinfo: &tailcfg.Hostinfo{IPNVersion: version}}
+	}
+
+	tests := []struct {
+		name string
+		node *types.Node
+		cfg  types.ClientUpdatesConfig
+		want *tailcfg.ClientVersion
+	}{
+		{
+			name: "latest",
+			node: nodeWithVersion("1.72.1-t1234abcd-g5678"),
+			cfg:  cfg,
+			want: &tailcfg.ClientVersion{RunningLatest: true},
+		},
+		{
+			name: "older",
+			node: nodeWithVersion("1.66.4-tabcd"),
+			cfg:  cfg,
+			want: &tailcfg.ClientVersion{
+				LatestVersion: "1.72.1",
+				Notify:        true,
+				NotifyURL:     "https://example.com/update",
+			},
+		},
+		{
+			name: "older-without-notification",
+			node: nodeWithVersion("1.66.4"),
+			cfg:  types.ClientUpdatesConfig{LatestVersion: "1.72.1"},
+			want: &tailcfg.ClientVersion{LatestVersion: "1.72.1"},
+		},
+		{
+			name: "below-minimum",
+			node: nodeWithVersion("1.50.0"),
+			cfg: types.ClientUpdatesConfig{
+				LatestVersion:  "1.72.1",
+				MinimumVersion: "1.60",
+				NotifyURL:      "https://example.com/update",
+			},
+			want: &tailcfg.ClientVersion{
+				LatestVersion:        "1.72.1",
+				UrgentSecurityUpdate: true,
+				Notify:               true,
+				NotifyURL:            "https://example.com/update",
+			},
+		},
+		{
+			name: "above-minimum",
+			node: nodeWithVersion("1.66.4"),
+			cfg: types.ClientUpdatesConfig{
+				LatestVersion:  "1.72.1",
+				MinimumVersion: "1.60",
+			},
+			want: &tailcfg.ClientVersion{LatestVersion: "1.72.1"},
+		},
+		{
+			name: "unknown-version",
+			node: &types.Node{},
+			cfg:  cfg,
+			want: nil,
+		},
+		{
+			name: "not-configured",
+			node: nodeWithVersion("1.66.4"),
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clientVersion(tt.node, tt.cfg)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("clientVersion() unexpected result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDERPMapFor(t *testing.T) {
+	derpMap := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1:   {RegionID: 1, RegionCode: "nyc"},
+			900: {RegionID: 900, RegionCode: "branch"},
+		},
+	}
+	cfg := &types.Config{
+		ClientTuning: []types.ClientTuning{
+			{
+				Prefixes:         []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+				PreferDERPRegion: 900,
+			},
+		},
+	}
+	m := NewMapper(nil, cfg, derpMap, nil)
+
+	avoided := func(derpMap *tailcfg.DERPMap) []int {
+		var ids []int
+		for id, region := range derpMap.Regions {
+			if region.Avoid {
+				ids = append(ids, id)
+			}
+		}
+
+		return ids
+	}
+
+	pol := &policy.ACLPolicy{
+		DERPHomes: []policy.DERPHome{{Sources: []string{"ci"}, Region: 1}},
+	}
+
+	lastSeen := netip.MustParseAddr("198.51.100.1")
+	tests := []struct {
+		name string
+		node *types.Node
+		want []int
+	}{
+		{
+			name: "pinned-node",
+			node: &types.Node{
+				Endpoints:      []netip.AddrPort{netip.MustParseAddrPort("203.0.113.7:41641")},
+				User:           types.User{Name: "ci"},
+				DERPHomeRegion: 900,
+			},
+			want: []int{1},
+		},
+		{
+			name: "pinned-by-policy",
+			node: &types.Node{
+				Endpoints: []netip.AddrPort{netip.MustParseAddrPort("203.0.113.7:41641")},
+				User:      types.User{Name: "ci"},
+			},
+			want: []int{900},
+		},
+		{
+			name: "pinned-to-unknown-region",
+			node: &types.Node{
+				User:           types.User{Name: "bob"},
+				DERPHomeRegion: 902,
+			},
+		},
+		{
+			name: "endpoint-in-network",
+			node: &types.Node{Endpoints: []netip.AddrPort{netip.MustParseAddrPort("203.0.113.7:41641")}},
+			want: []int{1},
+		},
+		{
+			name: "other-network",
+			node: &types.Node{
+				Endpoints:    []netip.AddrPort{netip.MustParseAddrPort("192.168.1.2:41641")},
+				LastSeenAddr: &lastSeen,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.derpMapFor(tt.node, pol)
+			if diff := cmp.Diff(tt.want, avoided(got)); diff != "" {
+				t.Errorf("avoided regions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The shared DERP map is left alone.
+	if got := avoided(derpMap); len(got) != 0 {
+		t.Errorf("avoided regions of the shared DERP map = %v, want none", got)
+	}
+}
+
+func TestDERPMapForRestrictions(t *testing.T) {
+	derpMap := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1:   {RegionID: 1, RegionCode: "nyc", Nodes: []*tailcfg.DERPNode{{Name: "1a", RegionID: 1}}},
+			900: {RegionID: 900, RegionCode: "eu", Nodes: []*tailcfg.DERPNode{{Name: "900a", RegionID: 900}}},
+		},
+	}
+	m := NewMapper(nil, &types.Config{}, derpMap, nil)
+
+	pol := &policy.ACLPolicy{
+		DERPRestrictions: []policy.DERPRestriction{
+			{Sources: []string{"eu"}, Regions: []int{900}},
+			{Sources: []string{"direct"}},
+		},
+		DERPHomes: []policy.DERPHome{{Sources: []string{"direct"}, Region: 1}},
+	}
+
+	type region struct {
+		ID       int
+		STUNOnly bool
+		Avoid    bool
+	}
+	regions := func(derpMap *tailcfg.DERPMap) []region {
+		var got []region
+		for id, r := range derpMap.Regions {
+			got = append(got, region{ID: id, STUNOnly: r.Nodes[0].STUNOnly, Avoid: r.Avoid})
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i].ID < got[j].ID })
+
+		return got
+	}
+
+	tests := []struct {
+		name string
+		node *types.Node
+		want []region
+	}{
+		{
+			name: "not-restricted",
+			node: &types.Node{User: types.User{Name: "bob"}},
+			want: []region{{ID: 1}, {ID: 900}},
+		},
+		{
+			name: "allowed-regions",
+			node: &types.Node{User: types.User{Name: "eu"}},
+			want: []region{{ID: 900}},
+		},
+		{
+			name: "no-relay",
+			node: &types.Node{User: types.User{Name: "direct"}},
+			want: []region{{ID: 1, STUNOnly: true}, {ID: 900, STUNOnly: true, Avoid: true}},
+		},
+		{
+			name: "pinned-to-a-removed-region",
+			node: &types.Node{User: types.User{Name: "eu"}, DERPHomeRegion: 1},
+			want: []region{{ID: 900}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := m.derpMapFor(tt.node, pol)
+			if diff := cmp.Diff(tt.want, regions(got)); diff != "" {
+				t.Errorf("regions mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	// The shared DERP map is left alone.
+	if diff := cmp.Diff([]region{{ID: 1}, {ID: 900}}, regions(derpMap)); diff != "" {
+		t.Errorf("shared DERP map changed (-want +got):\n%s", diff)
+	}
+}
+
+func TestSignMapResponse(t *testing.T) {
+	derpMap := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "nyc"},
+		},
+	}
+	cfg := &types.Config{
+		BaseDomain: "example.com",
+		DNSConfig:  &tailcfg.DNSConfig{Domains: []string{"example.com"}},
+	}
+	m := NewMapper(nil, cfg, derpMap, nil)
+
+	signingKey, err := mapsign.LoadOrCreateKey(t.TempDir() + "/map_signing_private.key")
+	if err != nil {
+		t.Fatalf("creating signing key: %s", err)
+	}
+	signer := mapsign.NewSigner(signingKey)
+	m.SetSigner(signer)
+
+	ipv4 := netip.MustParseAddr("100.64.0.1")
+	node := &types.Node{
+		ID:         1,
+		Hostname:   "laptop",
+		GivenName:  "laptop",
+		MachineKey: key.NewMachine().Public(),
+		NodeKey:    key.NewNode().Public(),
+		IPv4:       &ipv4,
+		User:       types.User{Name: "alice"},
+	}
+	pol := &policy.ACLPolicy{}
+
+	// A DERPMap update replaces the signature of the node, so it also
+	// carries the node and the DNS configuration.
+	resp := m.baseMapResponse()
+	resp.DERPMap = m.derpMapFor(node, pol)
+	if err := m.signMapResponse(&resp, node, types.Nodes{}, pol, 74); err != nil {
+		t.Fatalf("signMapResponse() error = %s", err)
+	}
+	if resp.Node == nil || resp.DNSConfig == nil {
+		t.Fatalf("signed DERPMap update does not carry the node and the DNS configuration")
+	}
+
+	netMap := &mapsign.NetMap{SelfNode: *resp.Node, DNS: *resp.DNSConfig, DERPMap: resp.DERPMap}
+	if _, err := mapsign.VerifyNetMap(netMap, signer.PublicKey()); err != nil {
+		t.Errorf("VerifyNetMap() error = %s", err)
+	}
+
+	keepAlive := m.baseMapResponse()
+	keepAlive.KeepAlive = true
+	if err := m.signMapResponse(&keepAlive, node, types.Nodes{}, pol, 74); err != nil {
+		t.Fatalf("signMapResponse() error = %s", err)
+	}
+	if keepAlive.Node != nil {
+		t.Errorf("keep alive carries the node")
+	}
+}
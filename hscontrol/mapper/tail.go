@@ -3,6 +3,7 @@ package mapper
 import (
 	"fmt"
 	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/juanfont/headscale/hscontrol/policy"
@@ -43,21 +44,29 @@ func tailNode(
 	pol *policy.ACLPolicy,
 	cfg *types.Config,
 ) (*tailcfg.Node, error) {
-	addrs := node.Prefixes()
+	addrs := slices.DeleteFunc(node.Prefixes(), func(prefix netip.Prefix) bool {
+		return cfg.IPFamilies.Disabled(prefix.Addr())
+	})
 
 	allowedIPs := append(
 		[]netip.Prefix{},
 		addrs...) // we append the node own IP, as it is required by the clients
 
 	primaryPrefixes := []netip.Prefix{}
+	exitNode := false
 
 	for _, route := range node.Routes {
+		if cfg.IPFamilies.Disabled(netip.Prefix(route.Prefix).Addr()) {
+			continue
+		}
+
 		if route.Enabled {
 			if route.IsPrimary {
 				allowedIPs = append(allowedIPs, netip.Prefix(route.Prefix))
 				primaryPrefixes = append(primaryPrefixes, netip.Prefix(route.Prefix))
 			} else if route.IsExitRoute() {
 				allowedIPs = append(allowedIPs, netip.Prefix(route.Prefix))
+				exitNode = true
 			}
 		}
 	}
@@ -76,13 +85,12 @@ func tailNode(
 		keyExpiry = time.Time{}
 	}
 
-	hostname, err := node.GetFQDN(cfg, cfg.BaseDomain)
+	hostname, err := node.GetFQDN(cfg, cfg.Reloadable().BaseDomain)
 	if err != nil {
 		return nil, fmt.Errorf("tailNode, failed to create FQDN: %s", err)
 	}
 
-	tags, _ := pol.TagsOfNode(node)
-	tags = lo.Uniq(append(tags, node.ForcedTags...))
+	tags := nodeTags(node, pol)
 
 	tNode := tailcfg.Node{
 		ID:       tailcfg.NodeID(node.ID), // this is the actual ID
@@ -125,6 +133,11 @@ func tailNode(
 		if cfg.RandomizeClientPort {
 			tNode.CapMap[tailcfg.NodeAttrRandomizeClientPort] = []tailcfg.RawMessage{}
 		}
+
+		//   - 84: 2024-01-04: Client understands SeamlessKeyRenewal
+		if cfg.NodeKeyRenewal.Seamless && capVer >= 84 {
+			tNode.CapMap[tailcfg.NodeAttrSeamlessKeyRenewal] = []tailcfg.RawMessage{}
+		}
 	} else {
 		tNode.Capabilities = []tailcfg.NodeCapability{
 			tailcfg.CapabilityFileSharing,
@@ -137,6 +150,30 @@ func tailNode(
 		}
 	}
 
+	if tuning := cfg.ClientTuningFor(node, tags); tuning != nil {
+		for _, attr := range tuning.NodeAttrs() {
+			if capVer >= 74 {
+				tNode.CapMap[attr] = []tailcfg.RawMessage{}
+			} else {
+				tNode.Capabilities = append(tNode.Capabilities, attr)
+			}
+		}
+
+		// Without the SSH capability the node refuses to start its
+		// Tailscale SSH server.
+		if tuning.DisableSSH {
+			delete(tNode.CapMap, tailcfg.CapabilitySSH)
+			tNode.Capabilities = slices.DeleteFunc(tNode.Capabilities, func(c tailcfg.NodeCapability) bool {
+				return c == tailcfg.CapabilitySSH
+			})
+		}
+	}
+
+	//   - 76: 2023-09-20: Client understands ExitNodeDNSResolvers for IsWireGuardOnly nodes
+	if exitNode && capVer >= 76 {
+		tNode.ExitNodeDNSResolvers = cfg.ExitNodeDNSResolversFor(tags)
+	}
+
 	//   - 72: 2023-08-23: TS-2023-006 UPnP issue fixed; UPnP can now be used again
 	if capVer < 72 {
 		tNode.Capabilities = append(tNode.Capabilities, tailcfg.NodeAttrDisableUPnP)
@@ -150,3 +187,19 @@ func tailNode(
 
 	return &tNode, nil
 }
+
+// nodeTags returns the tags of the node, the tags the policy allows it
+// to request and its forced tags.
+func nodeTags(node *types.Node, pol *policy.ACLPolicy) []string {
+	tags, _ := pol.TagsOfNode(node)
+
+	return lo.Uniq(append(tags, node.ForcedTags...))
+}
+
+// sshDisabled reports whether client_tuning turns off the Tailscale SSH
+// server of the node.
+func sshDisabled(node *types.Node, pol *policy.ACLPolicy, cfg *types.Config) bool {
+	tuning := cfg.ClientTuningFor(node, nodeTags(node, pol))
+
+	return tuning != nil && tuning.DisableSSH
+}
@@ -3,6 +3,7 @@ package mapper
 import (
 	"encoding/json"
 	"net/netip"
+	"slices"
 	"testing"
 	"time"
 
@@ -10,7 +11,9 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/juanfont/headscale/hscontrol/policy"
 	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/dnstype"
 	"tailscale.com/types/key"
 )
 
@@ -207,6 +210,111 @@ func TestTailNode(t *testing.T) {
 	}
 }
 
+func TestTailNodeExitNodeDNSResolvers(t *testing.T) {
+	cfg := &types.Config{
+		ExitNodeDNSResolvers: []types.ExitNodeDNSResolvers{
+			{Tags: []string{"tag:exit-eu"}, Resolvers: []*dnstype.Resolver{{Addr: "10.1.0.53"}}},
+			{Tags: []string{"tag:exit-us", "tag:exit"}, Resolvers: []*dnstype.Resolver{{Addr: "10.2.0.53"}}},
+		},
+	}
+	exitRoute := types.Route{
+		Prefix:     types.IPPrefix(netip.MustParsePrefix("0.0.0.0/0")),
+		Advertised: true,
+		Enabled:    true,
+	}
+
+	tests := []struct {
+		name   string
+		node   *types.Node
+		capVer tailcfg.CapabilityVersion
+		want   []*dnstype.Resolver
+	}{
+		{
+			name:   "tagged-exit-node",
+			node:   &types.Node{GivenName: "exit", ForcedTags: []string{"tag:exit"}, Routes: []types.Route{exitRoute}},
+			capVer: 76,
+			want:   []*dnstype.Resolver{{Addr: "10.2.0.53"}},
+		},
+		{
+			name:   "old-client",
+			node:   &types.Node{GivenName: "exit", ForcedTags: []string{"tag:exit-eu"}, Routes: []types.Route{exitRoute}},
+			capVer: 75,
+		},
+		{
+			name:   "exit-route-disabled",
+			node:   &types.Node{GivenName: "exit", ForcedTags: []string{"tag:exit-eu"}, Routes: []types.Route{{Prefix: exitRoute.Prefix, Advertised: true}}},
+			capVer: 76,
+		},
+		{
+			name:   "other-tag",
+			node:   &types.Node{GivenName: "exit", ForcedTags: []string{"tag:server"}, Routes: []types.Route{exitRoute}},
+			capVer: 76,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tailNode(tt.node, tt.capVer, &policy.ACLPolicy{}, cfg)
+			if err != nil {
+				t.Fatalf("tailNode() error = %s", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got.ExitNodeDNSResolvers); diff != "" {
+				t.Errorf("ExitNodeDNSResolvers mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTailNodeDisableSSH(t *testing.T) {
+	cfg := &types.Config{
+		ClientTuning: []types.ClientTuning{
+			{Tags: []string{"tag:dc"}, DisableSSH: true},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		node   *types.Node
+		capVer tailcfg.CapabilityVersion
+		want   bool
+	}{
+		{
+			name:   "tagged",
+			node:   &types.Node{GivenName: "dc1", ForcedTags: []string{"tag:dc"}},
+			capVer: 74,
+			want:   false,
+		},
+		{
+			name:   "tagged-old-client",
+			node:   &types.Node{GivenName: "dc1", ForcedTags: []string{"tag:dc"}},
+			capVer: 73,
+			want:   false,
+		},
+		{
+			name:   "other-tag",
+			node:   &types.Node{GivenName: "web", ForcedTags: []string{"tag:web"}},
+			capVer: 74,
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tailNode(tt.node, tt.capVer, &policy.ACLPolicy{}, cfg)
+			if err != nil {
+				t.Fatalf("tailNode() error = %s", err)
+			}
+
+			_, hasSSH := got.CapMap[tailcfg.CapabilitySSH]
+			hasSSH = hasSSH || slices.Contains(got.Capabilities, tailcfg.CapabilitySSH)
+			if hasSSH != tt.want {
+				t.Errorf("SSH capability = %t, want %t", hasSSH, tt.want)
+			}
+		})
+	}
+}
+
 func TestNodeExpiry(t *testing.T) {
 	tp := func(t time.Time) *time.Time {
 		return &t
@@ -271,3 +379,55 @@ func TestNodeExpiry(t *testing.T) {
 		})
 	}
 }
+
+func TestTailNodeDisableIPv6(t *testing.T) {
+	cfg := &types.Config{
+		IPFamilies: types.IPFamiliesConfig{DisableIPv6: true},
+	}
+	ipv4 := netip.MustParseAddr("100.64.0.1")
+	ipv6 := netip.MustParseAddr("fd7a:115c:a1e0::1")
+	route := func(prefix string, primary bool) types.Route {
+		return types.Route{
+			Prefix:     types.IPPrefix(netip.MustParsePrefix(prefix)),
+			Advertised: true,
+			Enabled:    true,
+			IsPrimary:  primary,
+		}
+	}
+
+	node := &types.Node{
+		GivenName: "router",
+		IPv4:      &ipv4,
+		IPv6:      &ipv6,
+		Routes: []types.Route{
+			route("0.0.0.0/0", false),
+			route("::/0", false),
+			route("192.168.0.0/24", true),
+			route("fd00:1::/64", true),
+		},
+	}
+
+	got, err := tailNode(node, 74, &policy.ACLPolicy{}, cfg)
+	if err != nil {
+		t.Fatalf("tailNode() error = %s", err)
+	}
+
+	wantAddresses := []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")}
+	if diff := cmp.Diff(wantAddresses, got.Addresses, util.PrefixComparer); diff != "" {
+		t.Errorf("Addresses unexpected result (-want +got):\n%s", diff)
+	}
+
+	wantAllowedIPs := []netip.Prefix{
+		netip.MustParsePrefix("100.64.0.1/32"),
+		netip.MustParsePrefix("0.0.0.0/0"),
+		netip.MustParsePrefix("192.168.0.0/24"),
+	}
+	if diff := cmp.Diff(wantAllowedIPs, got.AllowedIPs, util.PrefixComparer); diff != "" {
+		t.Errorf("AllowedIPs unexpected result (-want +got):\n%s", diff)
+	}
+
+	wantPrimaryRoutes := []netip.Prefix{netip.MustParsePrefix("192.168.0.0/24")}
+	if diff := cmp.Diff(wantPrimaryRoutes, got.PrimaryRoutes, util.PrefixComparer); diff != "" {
+		t.Errorf("PrimaryRoutes unexpected result (-want +got):\n%s", diff)
+	}
+}
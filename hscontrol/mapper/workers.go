@@ -0,0 +1,139 @@
+package mapper
+
+import (
+	"runtime"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"golang.org/x/sync/errgroup"
+	"tailscale.com/tailcfg"
+)
+
+// minPeersPerWorker is the smallest number of peers worth filtering
+// on a worker of its own, below it the overhead of the goroutine
+// outweighs the work.
+const minPeersPerWorker = 256
+
+// compilePool bounds the CPU spent compiling policies for map
+// responses. Every map response holds a slot while it compiles its
+// policy, so a mass update of all nodes does not run more compilations
+// than there are workers, and the work of a response is spread on the
+// idle workers.
+type compilePool struct {
+	slots chan struct{}
+}
+
+// newCompilePool returns a pool of the given number of workers, or one
+// per CPU if it is not positive.
+func newCompilePool(workers int) *compilePool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	return &compilePool{
+		slots: make(chan struct{}, workers),
+	}
+}
+
+// acquire blocks until a worker is free and returns the function
+// releasing it. A nil pool does not limit anything.
+func (p *compilePool) acquire() func() {
+	if p == nil {
+		return func() {}
+	}
+
+	p.slots <- struct{}{}
+
+	return func() { <-p.slots }
+}
+
+// tryAcquire takes a worker if one is free.
+func (p *compilePool) tryAcquire() bool {
+	if p == nil {
+		return false
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// run runs the functions, on the idle workers of the pool and on the
+// calling goroutine, which is expected to already hold a worker. It
+// returns the first error.
+func (p *compilePool) run(fns ...func() error) error {
+	var group errgroup.Group
+
+	// Without an idle worker, functions run on the calling goroutine.
+	var inline []func() error
+	for _, fn := range fns[1:] {
+		if !p.tryAcquire() {
+			inline = append(inline, fn)
+
+			continue
+		}
+
+		group.Go(func() error {
+			defer func() { <-p.slots }()
+
+			return fn()
+		})
+	}
+
+	err := fns[0]()
+	for _, fn := range inline {
+		if err != nil {
+			break
+		}
+		err = fn()
+	}
+
+	if waitErr := group.Wait(); err == nil {
+		err = waitErr
+	}
+
+	return err
+}
+
+// filterPeers returns the peers the node can see with the packet
+// filter, splitting large peer lists across the workers of the pool.
+// The order of the peers is kept.
+func (p *compilePool) filterPeers(
+	node *types.Node,
+	peers types.Nodes,
+	packetFilter []tailcfg.FilterRule,
+) types.Nodes {
+	chunks := 1
+	if p != nil {
+		chunks = min(cap(p.slots), len(peers)/minPeersPerWorker)
+	}
+	if chunks <= 1 {
+		return policy.FilterNodesByACL(node, peers, packetFilter)
+	}
+
+	size := (len(peers) + chunks - 1) / chunks
+	results := make([]types.Nodes, chunks)
+	fns := make([]func() error, 0, chunks)
+
+	for index := range chunks {
+		chunk := peers[min(index*size, len(peers)):min((index+1)*size, len(peers))]
+		fns = append(fns, func() error {
+			results[index] = policy.FilterNodesByACL(node, chunk, packetFilter)
+
+			return nil
+		})
+	}
+
+	// The chunks never fail.
+	_ = p.run(fns...)
+
+	var filtered types.Nodes
+	for _, result := range results {
+		filtered = append(filtered, result...)
+	}
+
+	return filtered
+}
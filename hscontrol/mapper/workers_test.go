@@ -0,0 +1,142 @@
+package mapper
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+func TestCompilePoolFilterPeers(t *testing.T) {
+	ip := func(index int) *netip.Addr {
+		addr := netip.AddrFrom4([4]byte{100, 64, byte(index >> 8), byte(index)})
+
+		return &addr
+	}
+
+	node := &types.Node{ID: 1, IPv4: ip(1)}
+	var peers types.Nodes
+	for index := 2; index < 700; index++ {
+		peers = append(peers, &types.Node{ID: types.NodeID(index), IPv4: ip(index)})
+	}
+
+	// The node can reach the peers in the first /23.
+	filter := []tailcfg.FilterRule{{
+		SrcIPs: []string{"100.64.0.1/32"},
+		DstPorts: []tailcfg.NetPortRange{{
+			IP:    "100.64.0.0/23",
+			Ports: tailcfg.PortRangeAny,
+		}},
+	}}
+
+	want := policy.FilterNodesByACL(node, peers, filter)
+	if len(want) != 510 {
+		t.Fatalf("FilterNodesByACL() returned %d peers, want 510", len(want))
+	}
+
+	for _, workers := range []int{1, 4, 16} {
+		got := newCompilePool(workers).filterPeers(node, peers, filter)
+		if diff := cmp.Diff(want, got, cmp.Comparer(func(a, b *types.Node) bool {
+			return a.ID == b.ID
+		})); diff != "" {
+			t.Errorf("filterPeers() with %d workers mismatch (-want +got):\n%s", workers, diff)
+		}
+	}
+}
+
+func TestCompilePoolRun(t *testing.T) {
+	pool := newCompilePool(2)
+	release := pool.acquire()
+	defer release()
+
+	var ran [4]bool
+	fns := make([]func() error, 0, len(ran))
+	for index := range ran {
+		fns = append(fns, func() error {
+			ran[index] = true
+
+			return nil
+		})
+	}
+
+	if err := pool.run(fns...); err != nil {
+		t.Fatalf("run() error = %s", err)
+	}
+	for index, ok := range ran {
+		if !ok {
+			t.Errorf("function %d did not run", index)
+		}
+	}
+
+	errFailed := errors.New("failed")
+	err := pool.run(
+		func() error { return nil },
+		func() error { return errFailed },
+	)
+	if !errors.Is(err, errFailed) {
+		t.Errorf("run() error = %v, want %v", err, errFailed)
+	}
+
+	if len(pool.slots) != 1 {
+		t.Errorf("run() holds %d workers, want only the acquired one", len(pool.slots))
+	}
+}
+
+func TestAppendPeerChangesSoftExpiry(t *testing.T) {
+	ip := func(addr string) *netip.Addr {
+		ip := netip.MustParseAddr(addr)
+
+		return &ip
+	}
+	expired := time.Now().Add(-time.Hour)
+
+	node := &types.Node{ID: 1, GivenName: "a", IPv4: ip("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}}
+	peers := types.Nodes{
+		{ID: 2, GivenName: "b", IPv4: ip("100.64.0.2"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}},
+		{ID: 3, GivenName: "c", IPv4: ip("100.64.0.3"), User: types.User{Name: "carol"}, Hostinfo: &tailcfg.Hostinfo{}, Expiry: &expired},
+	}
+	pol := &policy.ACLPolicy{
+		ACLs: []policy.ACL{
+			{Action: "accept", Sources: []string{"bob", "carol"}, Destinations: []string{"alice:*"}},
+		},
+	}
+
+	tests := []struct {
+		mode    types.NodeExpiryMode
+		wantSrc []string
+	}{
+		{mode: types.NodeExpiryModeClient, wantSrc: []string{"100.64.0.2/32", "100.64.0.3/32"}},
+		{mode: types.NodeExpiryModeSoft, wantSrc: []string{"100.64.0.2/32"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			cfg := &types.Config{NodeExpiry: types.NodeExpiryConfig{Mode: tt.mode}}
+
+			var resp tailcfg.MapResponse
+			err := appendPeerChanges(context.Background(), &resp, true, pol, node, 90, peers, peers, cfg, newCompilePool(1))
+			if err != nil {
+				t.Fatalf("appendPeerChanges() error = %s", err)
+			}
+
+			// The expired peer stays in the netmap, marked expired.
+			if len(resp.Peers) != 2 || !resp.Peers[1].Expired {
+				t.Fatalf("Peers = %v, want both peers with c expired", resp.Peers)
+			}
+
+			var gotSrc []string
+			for _, rule := range resp.PacketFilters["base"] {
+				gotSrc = append(gotSrc, rule.SrcIPs...)
+			}
+			if diff := cmp.Diff(tt.wantSrc, gotSrc); diff != "" {
+				t.Errorf("SrcIPs mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+// Package mapsign signs the DERPMap and the DNS configuration sent to
+// the nodes, so tampering with them between headscale and the nodes,
+// e.g. by a compromised reverse proxy, can be detected by anyone pinning
+// the signing key.
+package mapsign
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// CapSigningKey is the node capability holding the public key the
+	// map responses are signed with, as "ed25519:<base64>".
+	CapSigningKey tailcfg.NodeCapability = "https://headscale.net/cap/map-signing-key"
+
+	// CapSignature is the node capability holding the Signature of the
+	// DERPMap and the DNS configuration sent with the node.
+	CapSignature tailcfg.NodeCapability = "https://headscale.net/cap/map-signature"
+
+	keyPrefix      = "ed25519:"
+	messageVersion = "headscale map signature v1"
+	privateKeyMode = 0o600
+)
+
+var (
+	ErrInvalidSignature = errors.New("map signature is invalid")
+	ErrUnpinnedKey      = errors.New("map is signed with a key other than the pinned one")
+	ErrNotSigned        = errors.New("map is not signed")
+)
+
+// Signature signs the digests of the DERPMap and the DNS configuration
+// of a node at a point in time.
+type Signature struct {
+	SignedAt        time.Time `json:"signed_at"`
+	DERPMapSHA256   string    `json:"derp_map_sha256"`
+	DNSConfigSHA256 string    `json:"dns_config_sha256"`
+	Signature       string    `json:"signature"`
+}
+
+// Signer signs the maps sent to the nodes.
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+func NewSigner(key ed25519.PrivateKey) *Signer {
+	return &Signer{key: key}
+}
+
+// PublicKey returns the public key of the signer, as it is sent in
+// CapSigningKey.
+func (s *Signer) PublicKey() string {
+	return FormatPublicKey(s.key.Public().(ed25519.PublicKey))
+}
+
+func FormatPublicKey(key ed25519.PublicKey) string {
+	return keyPrefix + base64.StdEncoding.EncodeToString(key)
+}
+
+func ParsePublicKey(str string) (ed25519.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(str, keyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("public key %q does not start with %q", str, keyPrefix)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key %q is not an ed25519 key", str)
+	}
+
+	return ed25519.PublicKey(key), nil
+}
+
+func digest(value any) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// message returns the signed bytes, it binds the digests to the node
+// key so a signature cannot be replayed to another node.
+func (sig *Signature) message(nodeKey string) []byte {
+	return []byte(strings.Join([]string{
+		messageVersion,
+		nodeKey,
+		sig.SignedAt.UTC().Format(time.RFC3339),
+		sig.DERPMapSHA256,
+		sig.DNSConfigSHA256,
+	}, "\n"))
+}
+
+// Sign signs the DERPMap and the DNS configuration sent to the node
+// with the node key.
+func (s *Signer) Sign(
+	nodeKey string,
+	derpMap *tailcfg.DERPMap,
+	dnsConfig *tailcfg.DNSConfig,
+) (*Signature, error) {
+	sig, err := newSignature(derpMap, dnsConfig)
+	if err != nil {
+		return nil, err
+	}
+	sig.SignedAt = time.Now().Truncate(time.Second)
+	sig.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, sig.message(nodeKey)))
+
+	return sig, nil
+}
+
+func newSignature(derpMap *tailcfg.DERPMap, dnsConfig *tailcfg.DNSConfig) (*Signature, error) {
+	derpMapDigest, err := digest(derpMap)
+	if err != nil {
+		return nil, fmt.Errorf("hashing DERPMap: %w", err)
+	}
+
+	dnsConfigDigest, err := digest(dnsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("hashing DNS config: %w", err)
+	}
+
+	return &Signature{
+		DERPMapSHA256:   derpMapDigest,
+		DNSConfigSHA256: dnsConfigDigest,
+	}, nil
+}
+
+// Verify checks the signature of the DERPMap and the DNS configuration
+// of the node with the node key against the pinned public key.
+func Verify(
+	key ed25519.PublicKey,
+	sig *Signature,
+	nodeKey string,
+	derpMap *tailcfg.DERPMap,
+	dnsConfig *tailcfg.DNSConfig,
+) error {
+	want, err := newSignature(derpMap, dnsConfig)
+	if err != nil {
+		return err
+	}
+	if want.DERPMapSHA256 != sig.DERPMapSHA256 {
+		return fmt.Errorf("%w: DERPMap does not match its digest", ErrInvalidSignature)
+	}
+	if want.DNSConfigSHA256 != sig.DNSConfigSHA256 {
+		return fmt.Errorf("%w: DNS config does not match its digest", ErrInvalidSignature)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || !ed25519.Verify(key, sig.message(nodeKey), signature) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Attach adds the public key and the signature to the capabilities of
+// the node.
+func (s *Signer) Attach(node *tailcfg.Node, sig *Signature) error {
+	keyValue, err := json.Marshal(s.PublicKey())
+	if err != nil {
+		return err
+	}
+	sigValue, err := json.Marshal(sig)
+	if err != nil {
+		return err
+	}
+
+	if node.CapMap == nil {
+		node.CapMap = tailcfg.NodeCapMap{}
+	}
+	node.CapMap[CapSigningKey] = []tailcfg.RawMessage{tailcfg.RawMessage(keyValue)}
+	node.CapMap[CapSignature] = []tailcfg.RawMessage{tailcfg.RawMessage(sigValue)}
+
+	return nil
+}
+
+// FromCapMap returns the public key and the signature of the
+// capabilities of a node.
+func FromCapMap(capMap tailcfg.NodeCapMap) (string, *Signature, error) {
+	keyValues, sigValues := capMap[CapSigningKey], capMap[CapSignature]
+	if len(keyValues) == 0 || len(sigValues) == 0 {
+		return "", nil, ErrNotSigned
+	}
+
+	var key string
+	if err := json.Unmarshal([]byte(keyValues[0]), &key); err != nil {
+		return "", nil, fmt.Errorf("parsing %s: %w", CapSigningKey, err)
+	}
+
+	var sig Signature
+	if err := json.Unmarshal([]byte(sigValues[0]), &sig); err != nil {
+		return "", nil, fmt.Errorf("parsing %s: %w", CapSignature, err)
+	}
+
+	return key, &sig, nil
+}
+
+// NetMap is the part of the network map of a node, as printed by
+// "tailscale debug netmap", that is signed.
+type NetMap struct {
+	SelfNode tailcfg.Node
+	DNS      tailcfg.DNSConfig
+	DERPMap  *tailcfg.DERPMap
+}
+
+// VerifyNetMap checks the signature of the network map of a node. With
+// pinned set, the map has to be signed with the pinned key, otherwise
+// with the key it carries. It returns the key the map is signed with.
+func VerifyNetMap(netMap *NetMap, pinned string) (string, error) {
+	keyStr, sig, err := FromCapMap(netMap.SelfNode.CapMap)
+	if err != nil {
+		return "", err
+	}
+
+	if pinned != "" && keyStr != pinned {
+		return keyStr, ErrUnpinnedKey
+	}
+
+	key, err := ParsePublicKey(keyStr)
+	if err != nil {
+		return keyStr, err
+	}
+
+	// The node keeps an empty DNS configuration when it was sent none.
+	var dnsConfig *tailcfg.DNSConfig
+	if !reflect.DeepEqual(netMap.DNS, tailcfg.DNSConfig{}) {
+		dnsConfig = &netMap.DNS
+	}
+
+	nodeKey, err := netMap.SelfNode.Key.MarshalText()
+	if err != nil {
+		return keyStr, err
+	}
+
+	return keyStr, Verify(key, sig, string(nodeKey), netMap.DERPMap, dnsConfig)
+}
+
+// LoadOrCreateKey reads the PKCS #8 encoded ed25519 private key at path,
+// it is created if missing.
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("creating map signing key directory: %w", err)
+		}
+		err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), privateKeyMode)
+		if err != nil {
+			return nil, fmt.Errorf("saving map signing key: %w", err)
+		}
+
+		return key, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading map signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("map signing key %q is not PEM encoded", path)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing map signing key: %w", err)
+	}
+
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("map signing key %q is not an ed25519 key", path)
+	}
+
+	return key, nil
+}
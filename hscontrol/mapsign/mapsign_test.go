@@ -0,0 +1,90 @@
+package mapsign
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestSignAndVerifyNetMap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "map_signing_private.key")
+	signingKey, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKey() error = %s", err)
+	}
+
+	loaded, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKey() of the created key error = %s", err)
+	}
+	if !loaded.Equal(signingKey) {
+		t.Fatalf("LoadOrCreateKey() did not load the created key")
+	}
+
+	signer := NewSigner(signingKey)
+	nodeKey := key.NewNode().Public()
+	nodeKeyText, _ := nodeKey.MarshalText()
+	node := &tailcfg.Node{Name: "laptop.example.com.", Key: nodeKey}
+	derpMap := &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {RegionID: 1, RegionCode: "fra", Nodes: []*tailcfg.DERPNode{{Name: "1a", RegionID: 1, HostName: "derp.example.com"}}},
+		},
+	}
+	dnsConfig := &tailcfg.DNSConfig{Domains: []string{"example.com"}, Proxied: true}
+
+	sig, err := signer.Sign(string(nodeKeyText), derpMap, dnsConfig)
+	if err != nil {
+		t.Fatalf("Sign() error = %s", err)
+	}
+	if err := signer.Attach(node, sig); err != nil {
+		t.Fatalf("Attach() error = %s", err)
+	}
+
+	// The node holds the map as it was sent.
+	netMap := func() *NetMap {
+		data, err := json.Marshal(map[string]any{"SelfNode": node, "DNS": dnsConfig, "DERPMap": derpMap})
+		if err != nil {
+			t.Fatalf("marshalling netmap: %s", err)
+		}
+		var netMap NetMap
+		if err := json.Unmarshal(data, &netMap); err != nil {
+			t.Fatalf("parsing netmap: %s", err)
+		}
+
+		return &netMap
+	}
+
+	got, err := VerifyNetMap(netMap(), signer.PublicKey())
+	if err != nil {
+		t.Fatalf("VerifyNetMap() error = %s", err)
+	}
+	if got != signer.PublicKey() {
+		t.Errorf("VerifyNetMap() key = %s, want %s", got, signer.PublicKey())
+	}
+
+	other, _ := LoadOrCreateKey(filepath.Join(t.TempDir(), "other.key"))
+	if _, err := VerifyNetMap(netMap(), NewSigner(other).PublicKey()); !errors.Is(err, ErrUnpinnedKey) {
+		t.Errorf("VerifyNetMap() with another pinned key error = %v, want %s", err, ErrUnpinnedKey)
+	}
+
+	derpMap.Regions[1].Nodes[0].HostName = "evil.example.com"
+	if _, err := VerifyNetMap(netMap(), ""); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyNetMap() of a tampered DERPMap error = %v, want %s", err, ErrInvalidSignature)
+	}
+	derpMap.Regions[1].Nodes[0].HostName = "derp.example.com"
+
+	// A signature is only valid for the node it was sent to.
+	node.Key = key.NewNode().Public()
+	if _, err := VerifyNetMap(netMap(), ""); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyNetMap() of another node error = %v, want %s", err, ErrInvalidSignature)
+	}
+
+	node.CapMap = nil
+	if _, err := VerifyNetMap(netMap(), ""); !errors.Is(err, ErrNotSigned) {
+		t.Errorf("VerifyNetMap() of an unsigned map error = %v, want %s", err, ErrNotSigned)
+	}
+}
@@ -0,0 +1,198 @@
+package hscontrol
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+var (
+	policyVersion = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "policy_version",
+		Help:      "version of the policy in use, the ID of its row in database mode, 0 for a policy file",
+	})
+	policyLoadedTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "policy_loaded_timestamp_seconds",
+		Help:      "unix time the policy in use was loaded",
+	})
+)
+
+// setPolicyMetrics records that the policy with the given version, 0
+// for a policy file, was loaded.
+func setPolicyMetrics(version uint) {
+	policyVersion.Set(float64(version))
+	policyLoadedTimestamp.SetToCurrentTime()
+}
+
+// inventoryCollector exports gauges of the nodes online, the routes
+// enabled and the pre-auth keys active, read from the database on every
+// scrape. metrics.cardinality sets how finely they are broken down.
+type inventoryCollector struct {
+	h           *Headscale
+	cardinality types.MetricsCardinality
+
+	nodesOnline       *prometheus.Desc
+	routesEnabled     *prometheus.Desc
+	preAuthKeysActive *prometheus.Desc
+}
+
+func newInventoryCollector(h *Headscale) *inventoryCollector {
+	cardinality := h.cfg.Metrics.Cardinality
+
+	var nodeLabels, userLabels []string
+	switch cardinality {
+	case types.MetricsCardinalityUser:
+		nodeLabels = []string{"user"}
+		userLabels = []string{"user"}
+	case types.MetricsCardinalityNode:
+		nodeLabels = []string{"user", "node"}
+		userLabels = []string{"user"}
+	}
+
+	return &inventoryCollector{
+		h:           h,
+		cardinality: cardinality,
+		nodesOnline: prometheus.NewDesc(
+			prometheus.BuildFQName(prometheusNamespace, "", "nodes_online"),
+			"number of nodes connected to headscale",
+			nodeLabels,
+			nil,
+		),
+		routesEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(prometheusNamespace, "", "routes_enabled"),
+			"number of enabled routes",
+			nodeLabels,
+			nil,
+		),
+		preAuthKeysActive: prometheus.NewDesc(
+			prometheus.BuildFQName(prometheusNamespace, "", "preauth_keys_active"),
+			"number of pre-auth keys that can still register nodes",
+			userLabels,
+			nil,
+		),
+	}
+}
+
+// registerInventoryMetrics adds the inventory gauges to the metrics.
+func (h *Headscale) registerInventoryMetrics() error {
+	err := prometheus.Register(newInventoryCollector(h))
+
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		return nil
+	}
+
+	return err
+}
+
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nodesOnline
+	ch <- c.routesEnabled
+	ch <- c.preAuthKeysActive
+}
+
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	nodes, err := c.h.db.ListNodes()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list nodes for metrics")
+		ch <- prometheus.NewInvalidMetric(c.nodesOnline, err)
+	} else {
+		online := c.newGaugeCounts()
+		for _, node := range nodes {
+			value := 0.0
+			if c.h.nodeNotifier.IsLikelyConnected(node.ID) {
+				value = 1
+			}
+			online.add(c.nodeLabels(node), value)
+		}
+		online.collect(ch, c.nodesOnline)
+	}
+
+	routes, err := db.Read(c.h.db.DB, func(rx *gorm.DB) (types.Routes, error) {
+		return db.GetRoutes(rx)
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list routes for metrics")
+		ch <- prometheus.NewInvalidMetric(c.routesEnabled, err)
+	} else {
+		enabled := c.newGaugeCounts()
+		for _, route := range routes {
+			value := 0.0
+			if route.Enabled {
+				value = 1
+			}
+			enabled.add(c.nodeLabels(&route.Node), value)
+		}
+		enabled.collect(ch, c.routesEnabled)
+	}
+
+	keys, err := c.h.db.ListActivePreAuthKeys(time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list pre-auth keys for metrics")
+		ch <- prometheus.NewInvalidMetric(c.preAuthKeysActive, err)
+	} else {
+		active := c.newGaugeCounts()
+		for _, key := range keys {
+			var labels []string
+			if c.cardinality != types.MetricsCardinalityTailnet {
+				labels = []string{key.User.Name}
+			}
+			active.add(labels, 1)
+		}
+		active.collect(ch, c.preAuthKeysActive)
+	}
+}
+
+// nodeLabels returns the label values of the node for the node and
+// route gauges.
+func (c *inventoryCollector) nodeLabels(node *types.Node) []string {
+	switch c.cardinality {
+	case types.MetricsCardinalityUser:
+		return []string{node.User.Name}
+	case types.MetricsCardinalityNode:
+		return []string{node.User.Name, node.GivenName}
+	default:
+		return nil
+	}
+}
+
+// gaugeCounts sums the values of a gauge by label values.
+type gaugeCounts struct {
+	labels map[string][]string
+	values map[string]float64
+}
+
+// newGaugeCounts returns the sums of a gauge, the tailnet total starts
+// at zero to be sent even without anything to count.
+func (c *inventoryCollector) newGaugeCounts() *gaugeCounts {
+	counts := &gaugeCounts{
+		labels: make(map[string][]string),
+		values: make(map[string]float64),
+	}
+	if c.cardinality == types.MetricsCardinalityTailnet {
+		counts.add(nil, 0)
+	}
+
+	return counts
+}
+
+func (g *gaugeCounts) add(labels []string, value float64) {
+	key := strings.Join(labels, "\x00")
+	g.labels[key] = labels
+	g.values[key] += value
+}
+
+func (g *gaugeCounts) collect(ch chan<- prometheus.Metric, desc *prometheus.Desc) {
+	for key, value := range g.values {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, g.labels[key]...)
+	}
+}
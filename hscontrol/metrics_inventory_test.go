@@ -0,0 +1,108 @@
+package hscontrol
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+func TestInventoryCollector(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{})
+
+	laptop := createTestNode(t, h, "alice", &types.Node{Hostname: "laptop"})
+	router := createTestNode(t, h, "alice", &types.Node{Hostname: "router"})
+	phone := createTestNode(t, h, "bob", &types.Node{Hostname: "phone"})
+
+	_, err := db.Write(h.db.DB, func(tx *gorm.DB) (any, error) {
+		alice, bob := laptop.UserID, phone.UserID
+
+		expired := time.Now().Add(-time.Hour)
+		for _, key := range []*types.PreAuthKey{
+			{Key: "reusable", UserID: alice, Reusable: true},
+			{Key: "expired", UserID: alice, Reusable: true, Expiration: &expired},
+			{Key: "used", UserID: bob, Used: true},
+			{Key: "unused", UserID: bob},
+		} {
+			if err := tx.Save(key).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, tx.Save(&types.Route{
+			NodeID:     uint64(router.ID),
+			Prefix:     types.IPPrefix(netip.MustParsePrefix("10.0.0.0/8")),
+			Advertised: true,
+			Enabled:    true,
+		}).Error
+	})
+	if err != nil {
+		t.Fatalf("creating pre auth keys and routes: %s", err)
+	}
+
+	h.nodeNotifier.AddNode(laptop.ID, make(chan types.StateUpdate, 1))
+
+	gather := func(cardinality types.MetricsCardinality) map[string]float64 {
+		h.cfg.Metrics.Cardinality = cardinality
+
+		registry := prometheus.NewPedanticRegistry()
+		registry.MustRegister(newInventoryCollector(h))
+
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatalf("Gather() error = %s", err)
+		}
+
+		got := make(map[string]float64)
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				var labels []string
+				for _, label := range metric.GetLabel() {
+					labels = append(labels, fmt.Sprintf("%s=%s", label.GetName(), label.GetValue()))
+				}
+				got[family.GetName()+"{"+strings.Join(labels, ",")+"}"] = metric.GetGauge().GetValue()
+			}
+		}
+
+		return got
+	}
+
+	want := map[string]float64{
+		"headscale_nodes_online{}":        1,
+		"headscale_routes_enabled{}":      1,
+		"headscale_preauth_keys_active{}": 2,
+	}
+	if diff := cmp.Diff(want, gather(types.MetricsCardinalityTailnet)); diff != "" {
+		t.Errorf("tailnet metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	want = map[string]float64{
+		"headscale_nodes_online{user=alice}":        1,
+		"headscale_nodes_online{user=bob}":          0,
+		"headscale_routes_enabled{user=alice}":      1,
+		"headscale_preauth_keys_active{user=alice}": 1,
+		"headscale_preauth_keys_active{user=bob}":   1,
+	}
+	if diff := cmp.Diff(want, gather(types.MetricsCardinalityUser)); diff != "" {
+		t.Errorf("user metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	want = map[string]float64{
+		"headscale_nodes_online{node=laptop,user=alice}":   1,
+		"headscale_nodes_online{node=router,user=alice}":   0,
+		"headscale_nodes_online{node=phone,user=bob}":      0,
+		"headscale_routes_enabled{node=router,user=alice}": 1,
+		"headscale_preauth_keys_active{user=alice}":        1,
+		"headscale_preauth_keys_active{user=bob}":          1,
+	}
+	if diff := cmp.Diff(want, gather(types.MetricsCardinalityNode)); diff != "" {
+		t.Errorf("node metrics mismatch (-want +got):\n%s", diff)
+	}
+}
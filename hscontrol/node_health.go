@@ -0,0 +1,129 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/tailcfg"
+)
+
+// healthChangeMaxSize limits the health reports nodes can send.
+const healthChangeMaxSize = 64 << 10
+
+// nodeHealth holds the health warnings the nodes reported, by node and
+// subsystem. The nodes only report changes, the warnings are kept until
+// the node clears them.
+type nodeHealth struct {
+	mu       sync.Mutex
+	warnings map[types.NodeID]map[string]string
+}
+
+func (nh *nodeHealth) set(nodeID types.NodeID, subsys, text string) {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	if text == "" {
+		delete(nh.warnings[nodeID], subsys)
+		if len(nh.warnings[nodeID]) == 0 {
+			delete(nh.warnings, nodeID)
+		}
+
+		return
+	}
+
+	if nh.warnings == nil {
+		nh.warnings = make(map[types.NodeID]map[string]string)
+	}
+	if nh.warnings[nodeID] == nil {
+		nh.warnings[nodeID] = make(map[string]string)
+	}
+	nh.warnings[nodeID][subsys] = text
+}
+
+// get returns the warnings of the node as "subsystem: text", sorted.
+func (nh *nodeHealth) get(nodeID types.NodeID) []string {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	var warnings []string
+	for subsys, text := range nh.warnings[nodeID] {
+		warnings = append(warnings, subsys+": "+text)
+	}
+	slices.Sort(warnings)
+
+	return warnings
+}
+
+// NoiseHealthChangeHandler records the health changes a node reports,
+// like the errors of its router configuring IP forwarding or the
+// firewall.
+func (ns *noiseServer) NoiseHealthChangeHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, healthChangeMaxSize))
+	if err != nil {
+		http.Error(writer, "reading health change", http.StatusBadRequest)
+
+		return
+	}
+
+	var change tailcfg.HealthChangeRequest
+	if err := json.Unmarshal(body, &change); err != nil {
+		http.Error(writer, "decoding health change", http.StatusBadRequest)
+
+		return
+	}
+
+	node, err := ns.headscale.db.GetNodeByMachineKey(ns.machineKey)
+	if err != nil {
+		http.Error(writer, "unknown node", http.StatusNotFound)
+
+		return
+	}
+
+	// Clients up to 1.62 do not send their node key.
+	if !change.NodeKey.IsZero() && change.NodeKey != node.NodeKey {
+		http.Error(writer, "node key mismatch", http.StatusForbidden)
+
+		return
+	}
+
+	log.Debug().
+		Str("node", node.Hostname).
+		Str("subsystem", change.Subsys).
+		Str("error", change.Error).
+		Msg("Node reported a health change")
+
+	ns.headscale.nodeHealth.set(node.ID, change.Subsys, change.Error)
+
+	writer.WriteHeader(http.StatusOK)
+}
+
+// routeWarnings returns why the routes of the node might not pass
+// traffic: the health warnings it reported and hints from its Hostinfo.
+func (h *Headscale) routeWarnings(node *types.Node) []string {
+	var warnings []string
+	if node.Hostinfo != nil && node.Hostinfo.ShieldsUp {
+		warnings = append(warnings, "shields up: the node blocks incoming connections")
+	}
+
+	return append(warnings, h.nodeHealth.get(node.ID)...)
+}
+
+// routesProto returns the routes for the API, with the warnings of
+// their nodes.
+func (h *Headscale) routesProto(routes types.Routes) []*v1.Route {
+	protoRoutes := routes.Proto()
+	for i, route := range routes {
+		protoRoutes[i].HealthWarnings = h.routeWarnings(&route.Node)
+	}
+
+	return protoRoutes
+}
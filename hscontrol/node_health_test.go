@@ -0,0 +1,92 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestNodeHealthRouteWarnings(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	node := createTestNode(t, h, "alice", &types.Node{
+		Hostname: "router",
+		Hostinfo: &tailcfg.Hostinfo{
+			ShieldsUp:   true,
+			RoutableIPs: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")},
+		},
+	})
+	if _, err := h.db.SaveNodeRoutes(node); err != nil {
+		t.Fatalf("saving routes: %s", err)
+	}
+
+	report := func(change tailcfg.HealthChangeRequest) int {
+		body, err := json.Marshal(change)
+		if err != nil {
+			t.Fatalf("encoding health change: %s", err)
+		}
+
+		rec := httptest.NewRecorder()
+		ns := &noiseServer{headscale: h, machineKey: node.MachineKey}
+		ns.NoiseHealthChangeHandler(rec, httptest.NewRequest(http.MethodPost, "/machine/update-health", bytes.NewReader(body)))
+
+		return rec.Code
+	}
+
+	warnings := func() []string {
+		resp, err := api.GetRoutes(context.Background(), &v1.GetRoutesRequest{})
+		if err != nil {
+			t.Fatalf("GetRoutes() error = %s", err)
+		}
+		if len(resp.GetRoutes()) != 1 {
+			t.Fatalf("GetRoutes() = %v, want one route", resp.GetRoutes())
+		}
+
+		return resp.GetRoutes()[0].GetHealthWarnings()
+	}
+
+	if code := report(tailcfg.HealthChangeRequest{
+		Subsys:  "router",
+		Error:   "IP forwarding is disabled",
+		NodeKey: node.NodeKey,
+	}); code != http.StatusOK {
+		t.Fatalf("health change status = %d, want %d", code, http.StatusOK)
+	}
+
+	want := []string{
+		"shields up: the node blocks incoming connections",
+		"router: IP forwarding is disabled",
+	}
+	if diff := cmp.Diff(want, warnings()); diff != "" {
+		t.Errorf("route warnings mismatch (-want +got):\n%s", diff)
+	}
+
+	// A report for another node key is refused.
+	if code := report(tailcfg.HealthChangeRequest{
+		Subsys:  "router",
+		NodeKey: key.NewNode().Public(),
+	}); code != http.StatusForbidden {
+		t.Errorf("health change with another node key status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	// Clients up to 1.62 send no node key, an empty error clears the
+	// warning.
+	if code := report(tailcfg.HealthChangeRequest{Subsys: "router"}); code != http.StatusOK {
+		t.Fatalf("health change status = %d, want %d", code, http.StatusOK)
+	}
+
+	want = []string{"shields up: the node blocks incoming connections"}
+	if diff := cmp.Diff(want, warnings()); diff != "" {
+		t.Errorf("route warnings after clearing mismatch (-want +got):\n%s", diff)
+	}
+}
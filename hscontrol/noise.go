@@ -96,10 +96,15 @@ func (h *Headscale) NoiseUpgradeHandler(
 	// a single hijacked connection from /ts2021, using netutil.NewOneConnListener
 	router := mux.NewRouter()
 	router.Use(prometheusMiddleware)
+	router.Use(noiseServer.rejectRevokedMachineKey)
 
 	router.HandleFunc("/machine/register", noiseServer.NoiseRegistrationHandler).
 		Methods(http.MethodPost)
 	router.HandleFunc("/machine/map", noiseServer.NoisePollNetMapHandler)
+	router.HandleFunc("/machine/c2n/{id}", noiseServer.NoiseC2NAnswerHandler).
+		Methods(http.MethodPost)
+	router.HandleFunc("/machine/update-health", noiseServer.NoiseHealthChangeHandler).
+		Methods(http.MethodPost)
 
 	server := http.Server{
 		ReadTimeout: types.HTTPTimeout,
@@ -121,6 +126,34 @@ func (h *Headscale) NoiseUpgradeHandler(
 	)
 }
 
+// rejectRevokedMachineKey refuses every request of a machine whose key
+// has been revoked, like a lost device.
+func (ns *noiseServer) rejectRevokedMachineKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		revoked, err := ns.headscale.db.IsKeyRevoked(ns.machineKey.String())
+		if err != nil {
+			log.Error().
+				Caller().
+				Err(err).
+				Msg("Cannot check whether the machine key is revoked")
+			http.Error(writer, "Internal error", http.StatusInternalServerError)
+
+			return
+		}
+		if revoked {
+			log.Info().
+				Str("machine_key", ns.machineKey.ShortString()).
+				Str("path", req.URL.Path).
+				Msg("Refusing request of a machine with a revoked key")
+			http.Error(writer, "machine key revoked", http.StatusForbidden)
+
+			return
+		}
+
+		next.ServeHTTP(writer, req)
+	})
+}
+
 func (ns *noiseServer) earlyNoise(protocolVersion int, writer io.Writer) error {
 	log.Trace().
 		Caller().
@@ -218,6 +251,26 @@ func (ns *noiseServer) NoisePollNetMapHandler(
 
 	ns.nodeKey = mapRequest.NodeKey
 
+	revoked, err := ns.headscale.db.IsKeyRevoked(mapRequest.NodeKey.String())
+	if err != nil {
+		log.Error().
+			Caller().
+			Err(err).
+			Msg("Cannot check whether the node key is revoked")
+		http.Error(writer, "Internal error", http.StatusInternalServerError)
+
+		return
+	}
+	if revoked {
+		log.Info().
+			Str("handler", "NoisePollNetMap").
+			Str("node_key", mapRequest.NodeKey.ShortString()).
+			Msg("Refusing map request with a revoked node key")
+		http.Error(writer, "node key revoked", http.StatusForbidden)
+
+		return
+	}
+
 	node, err := ns.headscale.db.GetNodeByAnyKey(
 		ns.conn.Peer(),
 		mapRequest.NodeKey,
@@ -232,7 +285,7 @@ func (ns *noiseServer) NoisePollNetMapHandler(
 		return
 	}
 
-	sess := ns.headscale.newMapSession(req.Context(), mapRequest, writer, node)
+	sess := ns.headscale.newMapSession(req.Context(), mapRequest, writer, node, req.RemoteAddr)
 	sess.tracef("a node sending a MapRequest with Noise protocol")
 	if !sess.isStreaming() {
 		sess.serve()
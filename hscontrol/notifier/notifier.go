@@ -34,6 +34,7 @@ type Notifier struct {
 	l         deadlock.Mutex
 	nodes     map[types.NodeID]chan<- types.StateUpdate
 	connected *xsync.MapOf[types.NodeID, bool]
+	lastSeen  *xsync.MapOf[types.NodeID, time.Time]
 	b         *batcher
 	cfg       *types.Config
 	closed    bool
@@ -43,6 +44,7 @@ func NewNotifier(cfg *types.Config) *Notifier {
 	n := &Notifier{
 		nodes:     make(map[types.NodeID]chan<- types.StateUpdate),
 		connected: xsync.NewMapOf[types.NodeID, bool](),
+		lastSeen:  xsync.NewMapOf[types.NodeID, time.Time](),
 		cfg:       cfg,
 		closed:    false,
 	}
@@ -91,13 +93,15 @@ func (n *Notifier) AddNode(nodeID types.NodeID, c chan<- types.StateUpdate) {
 	if curr, ok := n.nodes[nodeID]; ok {
 		n.tracef(nodeID, "channel present, closing and replacing")
 		close(curr)
+	} else {
+		notifierNodeUpdateChans.Inc()
 	}
 
 	n.nodes[nodeID] = c
 	n.connected.Store(nodeID, true)
+	n.Seen(nodeID)
 
 	n.tracef(nodeID, "added new channel")
-	notifierNodeUpdateChans.Inc()
 }
 
 // RemoveNode removes a node and a given channel from the notifier.
@@ -122,22 +126,46 @@ func (n *Notifier) RemoveNode(nodeID types.NodeID, c chan<- types.StateUpdate) b
 
 	// If the channel exist, but it does not belong
 	// to the caller, ignore.
-	if curr, ok := n.nodes[nodeID]; ok {
-		if curr != c {
-			n.tracef(nodeID, "channel has been replaced, not removing")
-			return false
-		}
+	curr, ok := n.nodes[nodeID]
+	if ok && curr != c {
+		n.tracef(nodeID, "channel has been replaced, not removing")
+		return false
 	}
 
-	delete(n.nodes, nodeID)
+	// DisconnectAll has already removed the channel.
+	if ok {
+		n.removeChannel(nodeID)
+	}
 	n.connected.Store(nodeID, false)
+	n.Seen(nodeID)
 
 	n.tracef(nodeID, "removed channel")
-	notifierNodeUpdateChans.Dec()
 
 	return true
 }
 
+// DisconnectAll closes the channels of all nodes, ending their poll
+// sessions so the nodes reconnect.
+func (n *Notifier) DisconnectAll() {
+	notifierWaitersForLock.WithLabelValues("lock", "disconnect").Inc()
+	n.l.Lock()
+	defer n.l.Unlock()
+	notifierWaitersForLock.WithLabelValues("lock", "disconnect").Dec()
+
+	for nodeID, c := range n.nodes {
+		close(c)
+		n.removeChannel(nodeID)
+		n.connected.Store(nodeID, false)
+	}
+}
+
+// removeChannel removes the channel of a node, it is the only place the
+// channels are counted down. The lock must be held.
+func (n *Notifier) removeChannel(nodeID types.NodeID) {
+	delete(n.nodes, nodeID)
+	notifierNodeUpdateChans.Dec()
+}
+
 // IsConnected reports if a node is connected to headscale and has a
 // poll session open.
 func (n *Notifier) IsConnected(nodeID types.NodeID) bool {
@@ -165,6 +193,33 @@ func (n *Notifier) LikelyConnectedMap() *xsync.MapOf[types.NodeID, bool] {
 	return n.connected
 }
 
+// Seen records that the node was active now, like when a map
+// response or keep alive was delivered on its poll session.
+func (n *Notifier) Seen(nodeID types.NodeID) {
+	n.lastSeen.Store(nodeID, time.Now())
+}
+
+// LastSeen reports when the node was last active since headscale
+// started, and false if it has not been seen.
+func (n *Notifier) LastSeen(nodeID types.NodeID) (time.Time, bool) {
+	return n.lastSeen.Load(nodeID)
+}
+
+// LastSeenSince returns the nodes that were active after the given
+// time, with the time they were last seen.
+func (n *Notifier) LastSeenSince(since time.Time) map[types.NodeID]time.Time {
+	seen := make(map[types.NodeID]time.Time)
+	n.lastSeen.Range(func(nodeID types.NodeID, lastSeen time.Time) bool {
+		if lastSeen.After(since) {
+			seen[nodeID] = lastSeen
+		}
+
+		return true
+	})
+
+	return seen
+}
+
 func (n *Notifier) NotifyAll(ctx context.Context, update types.StateUpdate) {
 	n.NotifyWithIgnore(ctx, update)
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"tailscale.com/tailcfg"
 )
 
@@ -263,3 +264,43 @@ func TestBatcher(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeUpdateChansGauge(t *testing.T) {
+	n := NewNotifier(&types.Config{
+		Tuning: types.Tuning{BatchChangeDelay: time.Hour},
+	})
+	defer n.Close()
+
+	before := testutil.ToFloat64(notifierNodeUpdateChans)
+	open := func() float64 {
+		return testutil.ToFloat64(notifierNodeUpdateChans) - before
+	}
+
+	first, second := make(chan types.StateUpdate), make(chan types.StateUpdate)
+	n.AddNode(1, first)
+	n.AddNode(2, second)
+
+	// A new connection of the same node replaces its channel.
+	replaced := make(chan types.StateUpdate)
+	n.AddNode(2, replaced)
+	if got := open(); got != 2 {
+		t.Fatalf("open channels after adding = %v, want 2", got)
+	}
+
+	// The poll sessions ending after a disconnect remove their nodes
+	// again, they must not count them down twice.
+	n.DisconnectAll()
+	third := make(chan types.StateUpdate)
+	n.AddNode(3, third)
+	n.RemoveNode(1, first)
+	n.RemoveNode(2, second)
+	n.RemoveNode(2, replaced)
+	if got := open(); got != 1 {
+		t.Errorf("open channels after disconnecting = %v, want 1", got)
+	}
+
+	n.RemoveNode(3, third)
+	if got := open(); got != 0 {
+		t.Errorf("open channels after removing = %v, want 0", got)
+	}
+}
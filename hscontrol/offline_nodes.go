@@ -0,0 +1,218 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// offlineNodeNotice is posted to offline_nodes.webhook_url before a node
+// is reaped.
+type offlineNodeNotice struct {
+	Type     string    `json:"type"`
+	NodeID   uint64    `json:"node_id"`
+	Hostname string    `json:"hostname"`
+	Name     string    `json:"name"`
+	User     string    `json:"user"`
+	LastSeen time.Time `json:"last_seen"`
+	Action   string    `json:"action"`
+	DryRun   bool      `json:"dry_run"`
+}
+
+func (h *Headscale) reapOfflineNodesEvery(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.reapOfflineNodes(ctx)
+		}
+	}
+}
+
+// reapOfflineNodes deletes or expires the nodes that have not been seen
+// for offline_nodes.after. In dry run, they are only reported.
+func (h *Headscale) reapOfflineNodes(ctx context.Context) {
+	cfg := h.cfg.OfflineNodes
+
+	nodes, err := h.offlineNodes(time.Now())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list offline nodes")
+
+		return
+	}
+
+	for _, node := range nodes {
+		lastSeen := lastSeenOrCreated(node)
+
+		log.Info().
+			Uint64("node.id", node.ID.Uint64()).
+			Str("node", node.Hostname).
+			Str("user", node.User.Name).
+			Time("last_seen", lastSeen).
+			Str("action", cfg.Action).
+			Bool("dry_run", cfg.DryRun).
+			Msg("Reaping offline node")
+
+		if cfg.WebhookURL != "" {
+			err := sendOfflineNodeWebhook(ctx, cfg.WebhookURL, offlineNodeNotice{
+				Type:     "offline_node",
+				NodeID:   node.ID.Uint64(),
+				Hostname: node.Hostname,
+				Name:     node.GivenName,
+				User:     node.User.Name,
+				LastSeen: lastSeen,
+				Action:   cfg.Action,
+				DryRun:   cfg.DryRun,
+			})
+			if err != nil {
+				log.Error().
+					Err(err).
+					Str("node", node.Hostname).
+					Msg("failed to send offline node webhook")
+			}
+		}
+
+		if cfg.DryRun {
+			continue
+		}
+
+		switch cfg.Action {
+		case types.OfflineNodeActionDelete:
+			err = h.deleteOfflineNode(node)
+		case types.OfflineNodeActionExpire:
+			err = h.expireOfflineNode(node)
+		}
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("node", node.Hostname).
+				Str("action", cfg.Action).
+				Msg("failed to reap offline node")
+		}
+	}
+}
+
+// offlineNodes returns the nodes the reaper acts on: nodes that are
+// not ephemeral, not connected, have not been seen since before
+// offline_nodes.after and have none of the exempt tags. Nodes that
+// already expired are left alone when the action is to expire them.
+func (h *Headscale) offlineNodes(now time.Time) (types.Nodes, error) {
+	cfg := h.cfg.OfflineNodes
+	cutoff := now.Add(-cfg.After)
+
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(nodes, func(node *types.Node) bool {
+		if node.IsEphemeral() || h.nodeNotifier.IsConnected(node.ID) {
+			return true
+		}
+
+		if !lastSeenOrCreated(node).Before(cutoff) {
+			return true
+		}
+
+		if cfg.Action == types.OfflineNodeActionExpire && node.IsExpired() {
+			return true
+		}
+
+		return slices.ContainsFunc(h.nodeTags(node), func(tag string) bool {
+			return slices.Contains(cfg.ExemptTags, tag)
+		})
+	}), nil
+}
+
+// lastSeenOrCreated returns when the node was last seen, or registered
+// if it never connected.
+func lastSeenOrCreated(node *types.Node) time.Time {
+	if node.LastSeen != nil && !node.LastSeen.IsZero() {
+		return *node.LastSeen
+	}
+
+	return node.CreatedAt
+}
+
+func (h *Headscale) deleteOfflineNode(node *types.Node) error {
+	changedNodes, err := h.db.DeleteNode(
+		node,
+		h.nodeNotifier.LikelyConnectedMap(),
+	)
+	if err != nil {
+		return err
+	}
+
+	ctx := types.NotifyCtx(context.Background(), "offline-node-delete", node.Hostname)
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type:    types.StatePeerRemoved,
+		Removed: []types.NodeID{node.ID},
+	})
+
+	if changedNodes != nil {
+		h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type:        types.StatePeerChanged,
+			ChangeNodes: changedNodes,
+		})
+	}
+
+	return nil
+}
+
+func (h *Headscale) expireOfflineNode(node *types.Node) error {
+	now := time.Now()
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		return db.NodeSetExpiry(tx, node.ID, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx := types.NotifyCtx(context.Background(), "offline-node-expire", node.Hostname)
+	h.nodeNotifier.NotifyWithIgnore(ctx, types.StateUpdateExpire(node.ID, now), node.ID)
+
+	return nil
+}
+
+// sendOfflineNodeWebhook posts the notice as JSON to url.
+func sendOfflineNodeWebhook(ctx context.Context, url string, notice offlineNodeNotice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, types.HTTPTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("offline node webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
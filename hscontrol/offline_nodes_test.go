@@ -0,0 +1,88 @@
+package hscontrol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestReapOfflineNodes(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		notices []offlineNodeNotice
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var notice offlineNodeNotice
+		if err := json.NewDecoder(r.Body).Decode(&notice); err != nil {
+			t.Errorf("decoding webhook: %s", err)
+		}
+
+		mu.Lock()
+		notices = append(notices, notice)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	cfg := &types.Config{
+		OfflineNodes: types.OfflineNodesConfig{
+			After:      30 * 24 * time.Hour,
+			Action:     types.OfflineNodeActionDelete,
+			ExemptTags: []string{"tag:server"},
+			DryRun:     true,
+			WebhookURL: webhook.URL,
+		},
+	}
+	h, _ := newTestAPIServer(t, cfg)
+
+	recent := time.Now().Add(-time.Hour)
+	old := time.Now().Add(-60 * 24 * time.Hour)
+
+	lost := createTestNode(t, h, "alice", &types.Node{Hostname: "lost", LastSeen: &old})
+	createTestNode(t, h, "alice", &types.Node{Hostname: "recent", LastSeen: &recent})
+	createTestNode(t, h, "alice", &types.Node{Hostname: "server", LastSeen: &old, ForcedTags: []string{"tag:server"}})
+
+	ephemeralKey := &types.PreAuthKey{Key: "ephemeral", UserID: lost.UserID, Ephemeral: true}
+	if err := h.db.DB.Save(ephemeralKey).Error; err != nil {
+		t.Fatalf("creating pre auth key: %s", err)
+	}
+	createTestNode(t, h, "alice", &types.Node{Hostname: "ephemeral", LastSeen: &old, AuthKeyID: &ephemeralKey.ID})
+
+	hostnames := func() []string {
+		nodes, err := h.db.ListNodes()
+		if err != nil {
+			t.Fatalf("ListNodes() error = %s", err)
+		}
+
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Hostname)
+		}
+
+		return names
+	}
+
+	// A dry run only reports the node.
+	h.reapOfflineNodes(context.Background())
+	if diff := cmp.Diff([]string{"lost", "recent", "server", "ephemeral"}, hostnames()); diff != "" {
+		t.Errorf("nodes after dry run mismatch (-want +got):\n%s", diff)
+	}
+	if len(notices) != 1 || notices[0].Hostname != "lost" || !notices[0].DryRun {
+		t.Errorf("webhook notices = %+v, want a dry run notice for lost", notices)
+	}
+
+	h.cfg.OfflineNodes.DryRun = false
+	h.reapOfflineNodes(context.Background())
+	if diff := cmp.Diff([]string{"recent", "server", "ephemeral"}, hostnames()); diff != "" {
+		t.Errorf("nodes after reaping mismatch (-want +got):\n%s", diff)
+	}
+	if len(notices) != 2 || notices[1].Action != types.OfflineNodeActionDelete || notices[1].DryRun {
+		t.Errorf("webhook notices = %+v, want a delete notice", notices)
+	}
+}
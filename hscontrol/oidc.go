@@ -19,12 +19,13 @@ import (
 	"github.com/juanfont/headscale/hscontrol/db"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/rs/zerolog/log"
 	"golang.org/x/oauth2"
 	"gorm.io/gorm"
 	"tailscale.com/types/key"
 )
 
+var oidcLog = util.NewSubsystemLogger(util.LogSubsystemOIDC)
+
 const (
 	randomByteSize = 16
 )
@@ -42,7 +43,11 @@ var (
 	errOIDCInvalidNodeState = errors.New(
 		"requested node state key expired before authorisation completed",
 	)
-	errOIDCNodeKeyMissing = errors.New("could not get node key from cache")
+	errOIDCNodeKeyMissing  = errors.New("could not get node key from cache")
+	errOIDCUnknownProvider = errors.New("unknown OIDC provider")
+	errOIDCProviderDomain  = errors.New(
+		"authenticated principal domain is routed to another provider",
+	)
 )
 
 type IDTokenClaims struct {
@@ -50,30 +55,132 @@ type IDTokenClaims struct {
 	Groups   []string `json:"groups,omitempty"`
 	Email    string   `json:"email"`
 	Username string   `json:"preferred_username,omitempty"`
+
+	// Raw holds all the claims of the token, for the claim to tag
+	// mapping.
+	Raw map[string]any `json:"-"`
+}
+
+// oidcProvider is an OIDC issuer users can authenticate with.
+type oidcProvider struct {
+	cfg          types.OIDCProviderConfig
+	provider     *oidc.Provider
+	oauth2Config *oauth2.Config
+
+	// httpClient sends the token requests, it is only set when the
+	// client authenticates with a client assertion.
+	httpClient *http.Client
+}
+
+// oidcRegistration is stored in the registration cache under the OIDC
+// state while the user authenticates.
+type oidcRegistration struct {
+	MachineKey key.MachinePublic
+	Provider   string
+
+	// Verifier is the PKCE code verifier of the authorization request.
+	Verifier string
+
+	// Portal is set when the user signs in to the self-service portal
+	// instead of registering a node.
+	Portal bool
 }
 
 func (h *Headscale) initOIDC() error {
-	var err error
 	// grab oidc config if it hasn't been already
-	if h.oauth2Config == nil {
-		h.oidcProvider, err = oidc.NewProvider(context.Background(), h.cfg.OIDC.Issuer)
+	if len(h.oidcProviders) > 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, cfg := range h.cfg.OIDC.Providers() {
+		provider, err := oidc.NewProvider(context.Background(), cfg.Issuer)
 		if err != nil {
-			return fmt.Errorf("creating OIDC provider from issuer config: %w", err)
+			errs = append(errs, fmt.Errorf(
+				"creating OIDC provider %q from issuer config: %w",
+				cfg.Name,
+				err,
+			))
+
+			continue
 		}
 
-		h.oauth2Config = &oauth2.Config{
-			ClientID:     h.cfg.OIDC.ClientID,
-			ClientSecret: h.cfg.OIDC.ClientSecret,
-			Endpoint:     h.oidcProvider.Endpoint(),
+		oauth2Config := &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     provider.Endpoint(),
 			RedirectURL: fmt.Sprintf(
 				"%s/oidc/callback",
 				strings.TrimSuffix(h.cfg.ServerURL, "/"),
 			),
-			Scopes: h.cfg.OIDC.Scope,
+			Scopes: cfg.Scope,
+		}
+		oauth2Config.Endpoint.AuthStyle = oidcAuthStyle(cfg.ClientAuthMethod)
+
+		signer, err := newClientAssertionSigner(cfg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"creating client assertion signer for OIDC provider %q: %w",
+				cfg.Name,
+				err,
+			))
+
+			continue
+		}
+
+		var httpClient *http.Client
+		if signer != nil {
+			// The secret signs the assertion and must not be sent
+			// in the token request.
+			oauth2Config.ClientSecret = ""
+			httpClient = &http.Client{
+				Timeout: types.HTTPTimeout,
+				Transport: &clientAssertionTransport{
+					base:     http.DefaultTransport,
+					signer:   signer,
+					clientID: cfg.ClientID,
+					tokenURL: oauth2Config.Endpoint.TokenURL,
+				},
+			}
 		}
+
+		h.oidcProviders = append(h.oidcProviders, &oidcProvider{
+			cfg:          cfg,
+			provider:     provider,
+			oauth2Config: oauth2Config,
+			httpClient:   httpClient,
+		})
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// getOIDCProvider returns the OIDC provider with the given name.
+func (h *Headscale) getOIDCProvider(name string) (*oidcProvider, bool) {
+	for _, provider := range h.oidcProviders {
+		if provider.cfg.Name == name {
+			return provider, true
+		}
+	}
+
+	return nil, false
+}
+
+// oidcProviderForEmail returns the OIDC provider the domain of the
+// email address is routed to.
+func (h *Headscale) oidcProviderForEmail(email string) (*oidcProvider, bool) {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return nil, false
+	}
+
+	for _, provider := range h.oidcProviders {
+		if provider.cfg.HandlesDomain(domain) {
+			return provider, true
+		}
+	}
+
+	return nil, false
 }
 
 func (h *Headscale) determineTokenExpiration(idTokenExpiration time.Time) time.Time {
@@ -94,7 +201,7 @@ func (h *Headscale) RegisterOIDC(
 	vars := mux.Vars(req)
 	machineKeyStr, ok := vars["mkey"]
 
-	log.Debug().
+	oidcLog.Debug().
 		Caller().
 		Str("machine_key", machineKeyStr).
 		Bool("ok", ok).
@@ -108,7 +215,7 @@ func (h *Headscale) RegisterOIDC(
 		[]byte(machineKeyStr),
 	)
 	if err != nil {
-		log.Warn().
+		oidcLog.Warn().
 			Err(err).
 			Msg("Failed to parse incoming nodekey in OIDC registration")
 
@@ -122,6 +229,27 @@ func (h *Headscale) RegisterOIDC(
 		return
 	}
 
+	provider, ok := h.selectOIDCProvider(req)
+	if !ok {
+		h.renderOIDCProviderPicker(writer, req, machineKey)
+
+		return
+	}
+
+	h.redirectToOIDCProvider(writer, req, provider, oidcRegistration{
+		MachineKey: machineKey,
+		Provider:   provider.cfg.Name,
+	})
+}
+
+// redirectToOIDCProvider stores the registration under a new OIDC state
+// and redirects the user to the provider to authenticate.
+func (h *Headscale) redirectToOIDCProvider(
+	writer http.ResponseWriter,
+	req *http.Request,
+	provider *oidcProvider,
+	registration oidcRegistration,
+) {
 	randomBlob := make([]byte, randomByteSize)
 	if _, err := rand.Read(randomBlob); err != nil {
 		util.LogErr(err, "could not read 16 bytes from rand")
@@ -133,26 +261,120 @@ func (h *Headscale) RegisterOIDC(
 
 	stateStr := hex.EncodeToString(randomBlob)[:32]
 
+	if provider.cfg.PKCE {
+		registration.Verifier = oauth2.GenerateVerifier()
+	}
+
 	// place the node key into the state cache, so it can be retrieved later
-	h.registrationCache.Set(
-		stateStr,
-		machineKey,
-		registerCacheExpiration,
-	)
+	h.registrationCache.Set(stateStr, registration, registerCacheExpiration)
 
 	// Add any extra parameter provided in the configuration to the Authorize Endpoint request
-	extras := make([]oauth2.AuthCodeOption, 0, len(h.cfg.OIDC.ExtraParams))
+	extras := make([]oauth2.AuthCodeOption, 0, len(provider.cfg.ExtraParams)+2)
+
+	if registration.Verifier != "" {
+		extras = append(extras, oauth2.S256ChallengeOption(registration.Verifier))
+	}
 
-	for k, v := range h.cfg.OIDC.ExtraParams {
+	for k, v := range provider.cfg.ExtraParams {
 		extras = append(extras, oauth2.SetAuthURLParam(k, v))
 	}
 
-	authURL := h.oauth2Config.AuthCodeURL(stateStr, extras...)
-	log.Debug().Msgf("Redirecting to %s for authentication", authURL)
+	if email := req.URL.Query().Get("email"); email != "" {
+		extras = append(extras, oauth2.SetAuthURLParam("login_hint", email))
+	}
+
+	authURL := provider.oauth2Config.AuthCodeURL(stateStr, extras...)
+	oidcLog.Debug().Msgf("Redirecting to %s for authentication", authURL)
 
 	http.Redirect(writer, req, authURL, http.StatusFound)
 }
 
+// selectOIDCProvider returns the provider the user authenticates with,
+// picked by the provider or email query parameters. If there is only
+// one provider it is always used.
+func (h *Headscale) selectOIDCProvider(req *http.Request) (*oidcProvider, bool) {
+	if len(h.oidcProviders) == 1 {
+		return h.oidcProviders[0], true
+	}
+
+	if name := req.URL.Query().Get("provider"); name != "" {
+		return h.getOIDCProvider(name)
+	}
+
+	if email := req.URL.Query().Get("email"); email != "" {
+		return h.oidcProviderForEmail(email)
+	}
+
+	return nil, false
+}
+
+type oidcProviderPickerTemplateConfig struct {
+	Key       string
+	Email     string
+	Providers []string
+}
+
+var oidcProviderPickerTemplate = template.Must(
+	template.New("oidcproviderpicker").Parse(`
+<html>
+	<head>
+		<title>Sign in - Headscale</title>
+		<meta name=viewport content="width=device-width, initial-scale=1">
+		<style>
+			body {
+				font-family: sans;
+			}
+			a {
+				display: block;
+				margin: 8px 0;
+			}
+		</style>
+	</head>
+	<body>
+		<h1>headscale</h1>
+		<h2>Sign in</h2>
+		{{if .Email}}<p>No sign in provider is configured for {{.Email}}.</p>{{end}}
+		<form method="get" action="/oidc/register/{{.Key}}">
+			<label for="email">Email address</label>
+			<input type="email" id="email" name="email" required>
+			<button type="submit">Continue</button>
+		</form>
+		<p>Or sign in with:</p>
+		{{range .Providers}}<a href="/oidc/register/{{$.Key}}?provider={{.}}">{{.}}</a>{{end}}
+	</body>
+</html>
+`))
+
+// renderOIDCProviderPicker lets the user pick an OIDC provider, either
+// explicitly or by entering their email address.
+func (h *Headscale) renderOIDCProviderPicker(
+	writer http.ResponseWriter,
+	req *http.Request,
+	machineKey key.MachinePublic,
+) {
+	config := oidcProviderPickerTemplateConfig{
+		Key:   machineKey.String(),
+		Email: req.URL.Query().Get("email"),
+	}
+	for _, provider := range h.oidcProviders {
+		config.Providers = append(config.Providers, provider.cfg.Name)
+	}
+
+	var content bytes.Buffer
+	if err := oidcProviderPickerTemplate.Execute(&content, config); err != nil {
+		util.LogErr(err, "Could not render OIDC provider picker template")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		util.LogErr(err, "Failed to write response")
+	}
+}
+
 type oidcCallbackTemplateConfig struct {
 	User string
 	Verb string
@@ -179,15 +401,79 @@ func (h *Headscale) OIDCCallback(
 		return
 	}
 
-	rawIDToken, err := h.getIDTokenForOIDCCallback(req.Context(), writer, code, state)
+	provider, registration, err := h.getOIDCProviderForCallback(writer, state)
+	if err != nil {
+		return
+	}
+
+	rawIDToken, refreshToken, err := getIDTokenForOIDCCallback(
+		req.Context(),
+		writer,
+		provider,
+		registration,
+		code,
+		state,
+	)
 	if err != nil {
 		return
 	}
 
-	idToken, err := h.verifyIDTokenForOIDCCallback(req.Context(), writer, rawIDToken)
+	idToken, err := verifyIDTokenForOIDCCallback(req.Context(), writer, provider, rawIDToken)
 	if err != nil {
 		return
 	}
+
+	if registration.Portal {
+		h.registrationCache.Delete(state)
+		h.completePortalOIDCLogin(writer, req, provider, idToken)
+
+		return
+	}
+
+	h.completeOIDCLogin(writer, provider, state, idToken, refreshToken)
+}
+
+// authorizeOIDCClaims returns the claims of a verified ID token if the
+// allowlists of the provider and of the configuration let the user in.
+func (h *Headscale) authorizeOIDCClaims(
+	writer http.ResponseWriter,
+	provider *oidcProvider,
+	idToken *oidc.IDToken,
+) (*IDTokenClaims, error) {
+	claims, err := extractIDTokenClaims(writer, idToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.validateOIDCProviderDomain(writer, provider, claims); err != nil {
+		return nil, err
+	}
+
+	reloadable := h.cfg.Reloadable()
+	if err := validateOIDCAllowedDomains(writer, reloadable.OIDCAllowedDomains, claims); err != nil {
+		return nil, err
+	}
+
+	if err := validateOIDCAllowedGroups(writer, reloadable.OIDCAllowedGroups, claims); err != nil {
+		return nil, err
+	}
+
+	if err := validateOIDCAllowedUsers(writer, reloadable.OIDCAllowedUsers, claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// completeOIDCLogin authorizes the user of a verified ID token and
+// registers or reauthenticates the node the login was started for.
+func (h *Headscale) completeOIDCLogin(
+	writer http.ResponseWriter,
+	provider *oidcProvider,
+	state string,
+	idToken *oidc.IDToken,
+	refreshToken string,
+) {
 	idTokenExpiry := h.determineTokenExpiration(idToken.Expiry)
 
 	// TODO: we can use userinfo at some point to grab additional information about the user (groups membership, etc)
@@ -197,22 +483,12 @@ func (h *Headscale) OIDCCallback(
 	// 	return
 	// }
 
-	claims, err := extractIDTokenClaims(writer, idToken)
+	claims, err := h.authorizeOIDCClaims(writer, provider, idToken)
 	if err != nil {
 		return
 	}
 
-	if err := validateOIDCAllowedDomains(writer, h.cfg.OIDC.AllowedDomains, claims); err != nil {
-		return
-	}
-
-	if err := validateOIDCAllowedGroups(writer, h.cfg.OIDC.AllowedGroups, claims); err != nil {
-		return
-	}
-
-	if err := validateOIDCAllowedUsers(writer, h.cfg.OIDC.AllowedUsers, claims); err != nil {
-		return
-	}
+	h.saveOIDCSession(provider, claims, refreshToken)
 
 	machineKey, nodeExists, err := h.validateNodeForOIDCCallback(
 		writer,
@@ -229,15 +505,26 @@ func (h *Headscale) OIDCCallback(
 		return
 	}
 
+	if h.cfg.RegistrationVerification.Enabled() {
+		h.requestRegistrationVerification(writer, &pendingRegistration{
+			MachineKey: *machineKey,
+			UserName:   userName,
+			Claims:     claims,
+			Expiry:     idTokenExpiry,
+		})
+
+		return
+	}
+
 	// register the node if it's new
-	log.Debug().Msg("Registering new node after successful callback")
+	oidcLog.Debug().Msg("Registering new node after successful callback")
 
 	user, err := h.findOrCreateNewUserForOIDCCallback(writer, userName)
 	if err != nil {
 		return
 	}
 
-	if err := h.registerNodeForOIDCCallback(writer, user, machineKey, idTokenExpiry); err != nil {
+	if err := h.registerNodeForOIDCCallback(writer, user, machineKey, idTokenExpiry, claims); err != nil {
 		return
 	}
 
@@ -274,12 +561,66 @@ func validateOIDCCallbackParams(
 	return code, state, nil
 }
 
-func (h *Headscale) getIDTokenForOIDCCallback(
+// getOIDCProviderForCallback returns the provider and registration the
+// authentication with the given state was started with.
+func (h *Headscale) getOIDCProviderForCallback(
+	writer http.ResponseWriter,
+	state string,
+) (*oidcProvider, oidcRegistration, error) {
+	registrationIf, ok := h.registrationCache.Get(state)
+	if !ok {
+		oidcLog.Trace().
+			Msg("requested node state key expired before authorisation completed")
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusBadRequest)
+		_, err := writer.Write([]byte("state has expired"))
+		if err != nil {
+			util.LogErr(err, "Failed to write response")
+		}
+
+		return nil, oidcRegistration{}, errOIDCNodeKeyMissing
+	}
+
+	registration, ok := registrationIf.(oidcRegistration)
+	if !ok {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusBadRequest)
+		_, err := writer.Write([]byte("state is invalid"))
+		if err != nil {
+			util.LogErr(err, "Failed to write response")
+		}
+
+		return nil, oidcRegistration{}, errOIDCInvalidNodeState
+	}
+
+	provider, ok := h.getOIDCProvider(registration.Provider)
+	if !ok {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusBadRequest)
+		_, err := writer.Write([]byte("unknown OIDC provider"))
+		if err != nil {
+			util.LogErr(err, "Failed to write response")
+		}
+
+		return nil, oidcRegistration{}, errOIDCUnknownProvider
+	}
+
+	return provider, registration, nil
+}
+
+func getIDTokenForOIDCCallback(
 	ctx context.Context,
 	writer http.ResponseWriter,
+	provider *oidcProvider,
+	registration oidcRegistration,
 	code, state string,
-) (string, error) {
-	oauth2Token, err := h.oauth2Config.Exchange(ctx, code)
+) (string, string, error) {
+	var opts []oauth2.AuthCodeOption
+	if registration.Verifier != "" {
+		opts = append(opts, oauth2.VerifierOption(registration.Verifier))
+	}
+
+	oauth2Token, err := provider.oauth2Config.Exchange(provider.oauth2Context(ctx), code, opts...)
 	if err != nil {
 		util.LogErr(err, "Could not exchange code for token")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -289,14 +630,16 @@ func (h *Headscale) getIDTokenForOIDCCallback(
 			util.LogErr(err, "Failed to write response")
 		}
 
-		return "", err
+		return "", "", err
 	}
 
-	log.Trace().
+	event := oidcLog.Trace().
 		Caller().
-		Str("code", code).
-		Str("state", state).
-		Msg("Got oidc callback")
+		Str("state", state)
+	if !util.LogRedaction() {
+		event = event.Str("code", code)
+	}
+	event.Msg("Got oidc callback")
 
 	rawIDToken, rawIDTokenOK := oauth2Token.Extra("id_token").(string)
 	if !rawIDTokenOK {
@@ -307,18 +650,19 @@ func (h *Headscale) getIDTokenForOIDCCallback(
 			util.LogErr(err, "Failed to write response")
 		}
 
-		return "", errNoOIDCIDToken
+		return "", "", errNoOIDCIDToken
 	}
 
-	return rawIDToken, nil
+	return rawIDToken, oauth2Token.RefreshToken, nil
 }
 
-func (h *Headscale) verifyIDTokenForOIDCCallback(
+func verifyIDTokenForOIDCCallback(
 	ctx context.Context,
 	writer http.ResponseWriter,
+	provider *oidcProvider,
 	rawIDToken string,
 ) (*oidc.IDToken, error) {
-	verifier := h.oidcProvider.Verifier(&oidc.Config{ClientID: h.cfg.OIDC.ClientID})
+	verifier := provider.provider.Verifier(&oidc.Config{ClientID: provider.cfg.ClientID})
 	idToken, err := verifier.Verify(ctx, rawIDToken)
 	if err != nil {
 		util.LogErr(err, "failed to verify id token")
@@ -340,7 +684,11 @@ func extractIDTokenClaims(
 	idToken *oidc.IDToken,
 ) (*IDTokenClaims, error) {
 	var claims IDTokenClaims
-	if err := idToken.Claims(&claims); err != nil {
+	err := idToken.Claims(&claims)
+	if err == nil {
+		err = idToken.Claims(&claims.Raw)
+	}
+	if err != nil {
 		util.LogErr(err, "Failed to decode id token claims")
 
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -363,18 +711,26 @@ func validateOIDCAllowedDomains(
 	allowedDomains []string,
 	claims *IDTokenClaims,
 ) error {
+	if err := checkOIDCAllowedDomains(allowedDomains, claims); err != nil {
+		oidcLog.Trace().Msg("authenticated principal does not match any allowed domain")
+
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusBadRequest)
+		_, werr := writer.Write([]byte("unauthorized principal (domain mismatch)"))
+		if werr != nil {
+			util.LogErr(werr, "Failed to write response")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func checkOIDCAllowedDomains(allowedDomains []string, claims *IDTokenClaims) error {
 	if len(allowedDomains) > 0 {
 		if at := strings.LastIndex(claims.Email, "@"); at < 0 ||
 			!slices.Contains(allowedDomains, claims.Email[at+1:]) {
-			log.Trace().Msg("authenticated principal does not match any allowed domain")
-
-			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			writer.WriteHeader(http.StatusBadRequest)
-			_, err := writer.Write([]byte("unauthorized principal (domain mismatch)"))
-			if err != nil {
-				util.LogErr(err, "Failed to write response")
-			}
-
 			return errOIDCAllowedDomains
 		}
 	}
@@ -391,6 +747,22 @@ func validateOIDCAllowedGroups(
 	allowedGroups []string,
 	claims *IDTokenClaims,
 ) error {
+	if err := checkOIDCAllowedGroups(allowedGroups, claims); err != nil {
+		oidcLog.Trace().Msg("authenticated principal not in any allowed groups")
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusBadRequest)
+		_, werr := writer.Write([]byte("unauthorized principal (allowed groups)"))
+		if werr != nil {
+			util.LogErr(werr, "Failed to write response")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func checkOIDCAllowedGroups(allowedGroups []string, claims *IDTokenClaims) error {
 	if len(allowedGroups) > 0 {
 		for _, group := range allowedGroups {
 			if slices.Contains(claims.Groups, group) {
@@ -398,15 +770,36 @@ func validateOIDCAllowedGroups(
 			}
 		}
 
-		log.Trace().Msg("authenticated principal not in any allowed groups")
+		return errOIDCAllowedGroups
+	}
+
+	return nil
+}
+
+// validateOIDCProviderDomain ensures users can only authenticate with
+// the provider their email domain is routed to, so a provider cannot
+// sign in users of a domain owned by another provider.
+func (h *Headscale) validateOIDCProviderDomain(
+	writer http.ResponseWriter,
+	provider *oidcProvider,
+	claims *IDTokenClaims,
+) error {
+	_, domain, _ := strings.Cut(claims.Email, "@")
+
+	routed, ok := h.oidcProviderForEmail(claims.Email)
+	if (ok && routed != provider) || (!ok && len(provider.cfg.Domains) > 0) {
+		oidcLog.Trace().
+			Str("provider", provider.cfg.Name).
+			Str("domain", domain).
+			Msg("authenticated principal domain is not routed to the provider")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unauthorized principal (allowed groups)"))
+		_, err := writer.Write([]byte("unauthorized principal (provider mismatch)"))
 		if err != nil {
 			util.LogErr(err, "Failed to write response")
 		}
 
-		return errOIDCAllowedGroups
+		return errOIDCProviderDomain
 	}
 
 	return nil
@@ -419,16 +812,24 @@ func validateOIDCAllowedUsers(
 	allowedUsers []string,
 	claims *IDTokenClaims,
 ) error {
-	if len(allowedUsers) > 0 &&
-		!slices.Contains(allowedUsers, claims.Email) {
-		log.Trace().Msg("authenticated principal does not match any allowed user")
+	if err := checkOIDCAllowedUsers(allowedUsers, claims); err != nil {
+		oidcLog.Trace().Msg("authenticated principal does not match any allowed user")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		writer.WriteHeader(http.StatusBadRequest)
-		_, err := writer.Write([]byte("unauthorized principal (user mismatch)"))
-		if err != nil {
-			util.LogErr(err, "Failed to write response")
+		_, werr := writer.Write([]byte("unauthorized principal (user mismatch)"))
+		if werr != nil {
+			util.LogErr(werr, "Failed to write response")
 		}
 
+		return err
+	}
+
+	return nil
+}
+
+func checkOIDCAllowedUsers(allowedUsers []string, claims *IDTokenClaims) error {
+	if len(allowedUsers) > 0 &&
+		!slices.Contains(allowedUsers, claims.Email) {
 		return errOIDCAllowedUsers
 	}
 
@@ -448,7 +849,7 @@ func (h *Headscale) validateNodeForOIDCCallback(
 	// retrieve nodekey from state cache
 	machineKeyIf, machineKeyFound := h.registrationCache.Get(state)
 	if !machineKeyFound {
-		log.Trace().
+		oidcLog.Trace().
 			Msg("requested node state key expired before authorisation completed")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		writer.WriteHeader(http.StatusBadRequest)
@@ -460,10 +861,9 @@ func (h *Headscale) validateNodeForOIDCCallback(
 		return nil, false, errOIDCNodeKeyMissing
 	}
 
-	var machineKey key.MachinePublic
-	machineKey, machineKeyOK := machineKeyIf.(key.MachinePublic)
-	if !machineKeyOK {
-		log.Trace().
+	registration, registrationOK := machineKeyIf.(oidcRegistration)
+	if !registrationOK {
+		oidcLog.Trace().
 			Interface("got", machineKeyIf).
 			Msg("requested node state key is not a nodekey")
 		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
@@ -476,14 +876,32 @@ func (h *Headscale) validateNodeForOIDCCallback(
 		return nil, false, errOIDCInvalidNodeState
 	}
 
+	machineKey := registration.MachineKey
+
 	// retrieve node information if it exist
 	// The error is not important, because if it does not
 	// exist, then this is a new node and we will move
 	// on to registration.
 	node, _ := h.db.GetNodeByMachineKey(machineKey)
 
+	if node != nil && node.User.IsSuspended() {
+		oidcLog.Trace().
+			Caller().
+			Str("node", node.Hostname).
+			Str("user", node.User.Name).
+			Msg("node belongs to a suspended user, refusing to reauthenticate")
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.WriteHeader(http.StatusForbidden)
+		_, err := writer.Write([]byte("user is suspended"))
+		if err != nil {
+			util.LogErr(err, "Failed to write response")
+		}
+
+		return nil, true, db.ErrUserSuspended
+	}
+
 	if node != nil {
-		log.Trace().
+		oidcLog.Trace().
 			Caller().
 			Str("node", node.Hostname).
 			Msg("node already registered, reauthenticating")
@@ -499,7 +917,7 @@ func (h *Headscale) validateNodeForOIDCCallback(
 
 			return nil, true, err
 		}
-		log.Debug().
+		oidcLog.Debug().
 			Str("node", node.Hostname).
 			Str("expiresAt", fmt.Sprintf("%v", expiry)).
 			Msg("successfully refreshed node")
@@ -576,7 +994,7 @@ func (h *Headscale) findOrCreateNewUserForOIDCCallback(
 ) (*types.User, error) {
 	user, err := h.db.GetUser(userName)
 	if errors.Is(err, db.ErrUserNotFound) {
-		user, err = h.db.CreateUser(userName)
+		user, err = h.createUser(userName)
 		if err != nil {
 			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 			writer.WriteHeader(http.StatusInternalServerError)
@@ -606,6 +1024,7 @@ func (h *Headscale) registerNodeForOIDCCallback(
 	user *types.User,
 	machineKey *key.MachinePublic,
 	expiry time.Time,
+	claims *IDTokenClaims,
 ) error {
 	ipv4, ipv6, err := h.ipAlloc.Next()
 	if err != nil {
@@ -613,7 +1032,7 @@ func (h *Headscale) registerNodeForOIDCCallback(
 	}
 
 	if err := h.db.Write(func(tx *gorm.DB) error {
-		if _, err := db.RegisterNodeFromAuthCallback(
+		node, err := db.RegisterNodeFromAuthCallback(
 			// TODO(kradalby): find a better way to use the cache across modules
 			tx,
 			h.registrationCache,
@@ -622,10 +1041,15 @@ func (h *Headscale) registerNodeForOIDCCallback(
 			&expiry,
 			util.RegisterMethodOIDC,
 			ipv4, ipv6,
-		); err != nil {
+		)
+		if err != nil {
 			return err
 		}
 
+		if tags := oidcClaimTags(h.cfg.OIDC.ClaimTags, claims); len(tags) > 0 {
+			return db.SetTags(tx, node.ID, append(node.ForcedTags, tags...))
+		}
+
 		return nil
 	}); err != nil {
 		util.LogErr(err, "could not register node")
@@ -642,6 +1066,44 @@ func (h *Headscale) registerNodeForOIDCCallback(
 	return nil
 }
 
+// oidcClaimTags returns the tags of the claim to tag mappings matching
+// the claims, in the order of the mappings.
+func oidcClaimTags(claimTags []types.OIDCClaimTag, claims *IDTokenClaims) []string {
+	var tags []string
+	for _, claimTag := range claimTags {
+		if claimHasValue(claims.Raw[claimTag.Claim], claimTag.Value) {
+			for _, tag := range claimTag.Tags {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+
+	return tags
+}
+
+// claimHasValue reports whether the claim is the value, or a list
+// containing it. Numbers and booleans are compared in their JSON form.
+func claimHasValue(claim any, value string) bool {
+	switch claim := claim.(type) {
+	case nil:
+		return false
+	case string:
+		return claim == value
+	case []any:
+		for _, item := range claim {
+			if claimHasValue(item, value) {
+				return true
+			}
+		}
+
+		return false
+	default:
+		return fmt.Sprint(claim) == value
+	}
+}
+
 func renderOIDCCallbackTemplate(
 	writer http.ResponseWriter,
 	claims *IDTokenClaims,
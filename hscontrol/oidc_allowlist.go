@@ -0,0 +1,358 @@
+package hscontrol
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const (
+	// oidcRefreshTimeout bounds the refresh of a session, so an identity
+	// provider that does not answer does not stall the other checks.
+	oidcRefreshTimeout = 30 * time.Second
+
+	// oidcSealedTokenPrefix marks the refresh tokens encrypted with the
+	// key of the server. Tokens saved before they were encrypted are
+	// read as they are and encrypted at the next check.
+	oidcSealedTokenPrefix = "sealed1:"
+	oidcSealingKeyInfo    = "headscale oidc refresh token"
+)
+
+var (
+	errOIDCNoSealingKey       = errors.New("no noise private key to encrypt OIDC refresh tokens with")
+	errOIDCSealedTokenInvalid = errors.New("refresh token cannot be decrypted, was the noise private key changed?")
+)
+
+// checkOIDCAllowlists checks the claims against the allowed domains,
+// groups and users of the configuration.
+func checkOIDCAllowlists(cfg *types.ReloadableConfig, claims *IDTokenClaims) error {
+	if err := checkOIDCAllowedDomains(cfg.OIDCAllowedDomains, claims); err != nil {
+		return err
+	}
+
+	if err := checkOIDCAllowedGroups(cfg.OIDCAllowedGroups, claims); err != nil {
+		return err
+	}
+
+	return checkOIDCAllowedUsers(cfg.OIDCAllowedUsers, claims)
+}
+
+// saveOIDCSession keeps the refresh token of a login, so the allowlists
+// can be checked again later. Providers only hand out refresh tokens
+// with the offline_access scope.
+func (h *Headscale) saveOIDCSession(
+	provider *oidcProvider,
+	claims *IDTokenClaims,
+	refreshToken string,
+) {
+	if h.cfg.OIDC.AllowlistCheckInterval <= 0 || refreshToken == "" {
+		return
+	}
+
+	userName, err := util.NormalizeToFQDNRules(claims.Email, h.cfg.OIDC.StripEmaildomain)
+	if err != nil {
+		return
+	}
+
+	sealed, err := h.sealOIDCRefreshToken(userName, refreshToken)
+	if err != nil {
+		oidcLog.Error().Err(err).Str("user", userName).Msg("failed to encrypt OIDC refresh token")
+
+		return
+	}
+
+	err = h.db.SaveOIDCSession(&types.OIDCSession{
+		UserName:     userName,
+		Provider:     provider.cfg.Name,
+		Email:        claims.Email,
+		RefreshToken: sealed,
+		CheckedAt:    time.Now(),
+	})
+	if err != nil {
+		oidcLog.Error().Err(err).Str("user", userName).Msg("failed to save OIDC session")
+	}
+}
+
+// enforceOIDCAllowlists checks the OIDC sessions against the allowlists
+// every interval, and expires the nodes of the users the identity
+// provider no longer allows once the grace period passed.
+func (h *Headscale) enforceOIDCAllowlists(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sessions, err := h.db.ListOIDCSessions()
+			if err != nil {
+				oidcLog.Error().Err(err).Msg("failed to list OIDC sessions")
+
+				continue
+			}
+
+			for _, session := range sessions {
+				if err := h.checkOIDCSession(ctx, session, time.Now()); err != nil {
+					oidcLog.Error().
+						Err(err).
+						Str("user", session.UserName).
+						Msg("failed to check OIDC session")
+				}
+			}
+		}
+	}
+}
+
+// checkOIDCSession refreshes the token of the session and checks the
+// allowlists with its claims. A refresh rejected by the identity
+// provider counts as denied, other failures are retried later.
+func (h *Headscale) checkOIDCSession(ctx context.Context, session types.OIDCSession, now time.Time) error {
+	provider, ok := h.getOIDCProvider(session.Provider)
+	if !ok {
+		return h.db.Write(func(tx *gorm.DB) error {
+			return db.DeleteOIDCSession(tx, session.UserName)
+		})
+	}
+
+	token, err := h.openOIDCRefreshToken(session)
+	if err != nil {
+		oidcLog.Warn().
+			Err(err).
+			Str("user", session.UserName).
+			Msg("dropping OIDC session, the allowlists are checked again after the next login")
+
+		return h.db.Write(func(tx *gorm.DB) error {
+			return db.DeleteOIDCSession(tx, session.UserName)
+		})
+	}
+
+	refreshCtx, cancel := context.WithTimeout(ctx, oidcRefreshTimeout)
+	claims, refreshToken, err := refreshOIDCClaims(refreshCtx, provider, token)
+	cancel()
+
+	var retrieveErr *oauth2.RetrieveError
+	switch {
+	case errors.As(err, &retrieveErr):
+		err = fmt.Errorf("refreshing token: %w", err)
+	case err != nil:
+		return err
+	default:
+		err = checkOIDCAllowlists(h.cfg.Reloadable(), claims)
+	}
+
+	if refreshToken == "" {
+		refreshToken = token
+	}
+	sealed, sealErr := h.sealOIDCRefreshToken(session.UserName, refreshToken)
+	if sealErr != nil {
+		return sealErr
+	}
+
+	session.RefreshToken = sealed
+	session.CheckedAt = now
+
+	if err == nil {
+		session.DeniedSince = nil
+
+		return h.db.SaveOIDCSession(&session)
+	}
+
+	if session.DeniedSince == nil {
+		oidcLog.Info().
+			Err(err).
+			Str("user", session.UserName).
+			Dur("grace_period", h.cfg.OIDC.AllowlistGracePeriod).
+			Msg("OIDC user is no longer allowed")
+
+		session.DeniedSince = &now
+	}
+
+	if now.Sub(*session.DeniedSince) < h.cfg.OIDC.AllowlistGracePeriod {
+		return h.db.SaveOIDCSession(&session)
+	}
+
+	nodes, err := db.Write(h.db.DB, func(tx *gorm.DB) (types.Nodes, error) {
+		if err := db.DeleteOIDCSession(tx, session.UserName); err != nil {
+			return nil, err
+		}
+
+		nodes, err := db.ExpireUserNodes(tx, session.UserName, now)
+		if errors.Is(err, db.ErrUserNotFound) {
+			return nil, nil
+		}
+
+		return nodes, err
+	})
+	if err != nil {
+		return err
+	}
+
+	oidcLog.Info().
+		Str("user", session.UserName).
+		Int("nodes", len(nodes)).
+		Msg("expired the nodes of an OIDC user that is no longer allowed")
+
+	for _, node := range nodes {
+		ctx := types.NotifyCtx(ctx, "oidc-allowlist-self", node.Hostname)
+		h.nodeNotifier.NotifyByNodeID(
+			ctx,
+			types.StateUpdate{
+				Type:        types.StateSelfUpdate,
+				ChangeNodes: []types.NodeID{node.ID},
+			},
+			node.ID,
+		)
+
+		ctx = types.NotifyCtx(ctx, "oidc-allowlist-peers", node.Hostname)
+		h.nodeNotifier.NotifyWithIgnore(ctx, types.StateUpdateExpire(node.ID, now), node.ID)
+	}
+
+	return nil
+}
+
+// oidcSealingKey derives the key encrypting the refresh tokens of the
+// OIDC sessions from the noise private key, so the tokens are not
+// readable from database snapshots and backups alone.
+func (h *Headscale) oidcSealingKey() ([]byte, error) {
+	if h.noisePrivateKey == nil {
+		return nil, errOIDCNoSealingKey
+	}
+
+	secret, err := h.noisePrivateKey.MarshalText()
+	if err != nil {
+		return nil, err
+	}
+
+	sealingKey := make([]byte, chacha20poly1305.KeySize)
+	_, err = io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(oidcSealingKeyInfo)), sealingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealingKey, nil
+}
+
+// sealOIDCRefreshToken encrypts the refresh token of a user for storage.
+// The user name is authenticated with it, so a token cannot be moved to
+// the session of another user.
+func (h *Headscale) sealOIDCRefreshToken(userName string, refreshToken string) (string, error) {
+	sealingKey, err := h.oidcSealingKey()
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := chacha20poly1305.NewX(sealingKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(refreshToken)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(refreshToken), []byte(userName))
+
+	return oidcSealedTokenPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// openOIDCRefreshToken returns the refresh token of the session,
+// decrypting it if it was saved encrypted.
+func (h *Headscale) openOIDCRefreshToken(session types.OIDCSession) (string, error) {
+	encoded, ok := strings.CutPrefix(session.RefreshToken, oidcSealedTokenPrefix)
+	if !ok {
+		return session.RefreshToken, nil
+	}
+
+	sealingKey, err := h.oidcSealingKey()
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := chacha20poly1305.NewX(sealingKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errOIDCSealedTokenInvalid
+	}
+
+	token, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(session.UserName))
+	if err != nil {
+		return "", errOIDCSealedTokenInvalid
+	}
+
+	return string(token), nil
+}
+
+// refreshOIDCClaims uses the refresh token to get the current claims of
+// the user, from the refreshed ID token or the userinfo endpoint if the
+// provider does not return one. It returns the new refresh token if the
+// provider rotated it.
+func refreshOIDCClaims(
+	ctx context.Context,
+	provider *oidcProvider,
+	refreshToken string,
+) (*IDTokenClaims, string, error) {
+	token, err := provider.oauth2Config.TokenSource(
+		provider.oauth2Context(ctx),
+		&oauth2.Token{RefreshToken: refreshToken},
+	).Token()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var claims IDTokenClaims
+
+	if rawIDToken, ok := token.Extra("id_token").(string); ok {
+		verifier := provider.provider.Verifier(&oidc.Config{ClientID: provider.cfg.ClientID})
+		idToken, err := verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return nil, "", fmt.Errorf("verifying refreshed id token: %w", err)
+		}
+
+		err = idToken.Claims(&claims)
+		if err == nil {
+			err = idToken.Claims(&claims.Raw)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("decoding refreshed id token claims: %w", err)
+		}
+	} else {
+		userInfo, err := provider.provider.UserInfo(
+			provider.oauth2Context(ctx),
+			oauth2.StaticTokenSource(token),
+		)
+		if err != nil {
+			return nil, "", fmt.Errorf("getting userinfo: %w", err)
+		}
+
+		err = userInfo.Claims(&claims)
+		if err == nil {
+			err = userInfo.Claims(&claims.Raw)
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("decoding userinfo claims: %w", err)
+		}
+	}
+
+	return &claims, token.RefreshToken, nil
+}
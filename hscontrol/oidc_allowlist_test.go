@@ -0,0 +1,214 @@
+package hscontrol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+	"tailscale.com/types/key"
+)
+
+func TestCheckOIDCSession(t *testing.T) {
+	var mu sync.Mutex
+	groups := []string{"staff"}
+	revoked := false
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/token":
+			if revoked {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]any{"error": "invalid_grant"}) //nolint
+
+				return
+			}
+
+			json.NewEncoder(w).Encode(map[string]any{ //nolint
+				"access_token":  "access-token",
+				"token_type":    "Bearer",
+				"refresh_token": "rotated",
+			})
+		case "/userinfo":
+			json.NewEncoder(w).Encode(map[string]any{ //nolint
+				"sub":    "alice",
+				"email":  "alice@example.com",
+				"groups": groups,
+			})
+		}
+	}))
+	defer idp.Close()
+
+	h, _ := newTestAPIServer(t, &types.Config{
+		OIDC: types.OIDCConfig{
+			AllowedGroups:        []string{"staff"},
+			AllowlistGracePeriod: time.Hour,
+		},
+	})
+	noiseKey := key.NewMachine()
+	h.noisePrivateKey = &noiseKey
+
+	idpProvider := (&oidc.ProviderConfig{
+		IssuerURL:   idp.URL,
+		TokenURL:    idp.URL + "/token",
+		UserInfoURL: idp.URL + "/userinfo",
+	}).NewProvider(context.Background())
+	h.oidcProviders = []*oidcProvider{{
+		cfg:      types.OIDCProviderConfig{Name: "default", ClientID: "headscale"},
+		provider: idpProvider,
+		oauth2Config: &oauth2.Config{
+			ClientID: "headscale",
+			Endpoint: idpProvider.Endpoint(),
+		},
+	}}
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		return tx.Save(&types.Node{
+			MachineKey:     key.NewMachine().Public(),
+			NodeKey:        key.NewNode().Public(),
+			Hostname:       "laptop",
+			UserID:         user.ID,
+			RegisterMethod: util.RegisterMethodOIDC,
+		}).Error
+	})
+	if err != nil {
+		t.Fatalf("creating node: %s", err)
+	}
+
+	session := types.OIDCSession{
+		UserName:     "alice",
+		Provider:     "default",
+		Email:        "alice@example.com",
+		RefreshToken: "initial",
+	}
+	if err := h.db.SaveOIDCSession(&session); err != nil {
+		t.Fatalf("SaveOIDCSession() error = %s", err)
+	}
+
+	check := func(now time.Time) *types.OIDCSession {
+		t.Helper()
+
+		sessions, err := h.db.ListOIDCSessions()
+		if err != nil {
+			t.Fatalf("ListOIDCSessions() error = %s", err)
+		}
+		if len(sessions) == 0 {
+			t.Fatalf("session was deleted")
+		}
+
+		if err := h.checkOIDCSession(context.Background(), sessions[0], now); err != nil {
+			t.Fatalf("checkOIDCSession() error = %s", err)
+		}
+
+		sessions, err = h.db.ListOIDCSessions()
+		if err != nil {
+			t.Fatalf("ListOIDCSessions() error = %s", err)
+		}
+		if len(sessions) == 0 {
+			return nil
+		}
+
+		return &sessions[0]
+	}
+
+	expired := func() bool {
+		t.Helper()
+
+		nodes, err := db.Read(h.db.DB, func(rx *gorm.DB) (types.Nodes, error) {
+			return db.ListNodesByUser(rx, "alice")
+		})
+		if err != nil {
+			t.Fatalf("ListNodesByUser() error = %s", err)
+		}
+
+		return nodes[0].IsExpired()
+	}
+
+	// Nodes are expired at the time of the check, which has to be in
+	// the past for them to be expired now.
+	now := time.Now().Add(-2 * time.Hour)
+
+	// The session was saved before refresh tokens were encrypted, the
+	// rotated token is saved encrypted.
+	got := check(now)
+	if got.DeniedSince != nil || !strings.HasPrefix(got.RefreshToken, oidcSealedTokenPrefix) {
+		t.Errorf("allowed user: DeniedSince = %v, RefreshToken = %q", got.DeniedSince, got.RefreshToken)
+	}
+	if token, err := h.openOIDCRefreshToken(*got); err != nil || token != "rotated" {
+		t.Errorf("openOIDCRefreshToken() = %q, %v, want %q", token, err, "rotated")
+	}
+
+	// Removed from the allowed group, the nodes are kept during the
+	// grace period.
+	mu.Lock()
+	groups = []string{"contractors"}
+	mu.Unlock()
+
+	got = check(now)
+	if got.DeniedSince == nil || expired() {
+		t.Errorf("denied user within grace period: DeniedSince = %v, expired = %t", got.DeniedSince, expired())
+	}
+
+	// A refresh rejected by the identity provider is a denial as well.
+	mu.Lock()
+	revoked = true
+	mu.Unlock()
+
+	if got := check(now.Add(time.Hour)); got != nil {
+		t.Errorf("session of denied user after grace period was kept")
+	}
+	if !expired() {
+		t.Errorf("nodes of denied user after grace period are not expired")
+	}
+}
+
+func TestSealOIDCRefreshToken(t *testing.T) {
+	noiseKey := key.NewMachine()
+	h := &Headscale{noisePrivateKey: &noiseKey}
+
+	sealed, err := h.sealOIDCRefreshToken("alice", "refresh-token")
+	if err != nil {
+		t.Fatalf("sealOIDCRefreshToken() error = %s", err)
+	}
+	if strings.Contains(sealed, "refresh-token") {
+		t.Fatalf("sealOIDCRefreshToken() = %q, contains the token", sealed)
+	}
+
+	token, err := h.openOIDCRefreshToken(types.OIDCSession{UserName: "alice", RefreshToken: sealed})
+	if err != nil || token != "refresh-token" {
+		t.Errorf("openOIDCRefreshToken() = %q, %v, want %q", token, err, "refresh-token")
+	}
+
+	_, err = h.openOIDCRefreshToken(types.OIDCSession{UserName: "bob", RefreshToken: sealed})
+	if !errors.Is(err, errOIDCSealedTokenInvalid) {
+		t.Errorf("openOIDCRefreshToken() for another user error = %v, want %v", err, errOIDCSealedTokenInvalid)
+	}
+
+	otherKey := key.NewMachine()
+	other := &Headscale{noisePrivateKey: &otherKey}
+	_, err = other.openOIDCRefreshToken(types.OIDCSession{UserName: "alice", RefreshToken: sealed})
+	if !errors.Is(err, errOIDCSealedTokenInvalid) {
+		t.Errorf("openOIDCRefreshToken() with another key error = %v, want %v", err, errOIDCSealedTokenInvalid)
+	}
+}
@@ -0,0 +1,46 @@
+package hscontrol
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestOIDCClaimTags(t *testing.T) {
+	claimTags := []types.OIDCClaimTag{
+		{Claim: "department", Value: "finance", Tags: []string{"tag:finance"}},
+		{Claim: "groups", Value: "sre", Tags: []string{"tag:sre", "tag:oncall"}},
+		{Claim: "oncall", Value: "true", Tags: []string{"tag:oncall"}},
+	}
+
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   []string
+	}{
+		{
+			name:   "string-claim",
+			claims: map[string]any{"department": "finance"},
+			want:   []string{"tag:finance"},
+		},
+		{
+			name:   "list-claim-and-bool",
+			claims: map[string]any{"groups": []any{"dev", "sre"}, "oncall": true},
+			want:   []string{"tag:sre", "tag:oncall"},
+		},
+		{
+			name:   "no-match",
+			claims: map[string]any{"department": "sales", "groups": []any{"dev"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := oidcClaimTags(claimTags, &IDTokenClaims{Raw: tt.claims})
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("oidcClaimTags() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
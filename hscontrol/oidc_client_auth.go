@@ -0,0 +1,209 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"golang.org/x/oauth2"
+)
+
+const (
+	clientAssertionType     = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+	clientAssertionLifetime = 5 * time.Minute
+)
+
+var errOIDCClientPrivateKey = errors.New("unsupported OIDC client private key")
+
+// oidcAuthStyle returns how the oauth2 library sends the client
+// credentials for the client authentication method. The JWT methods
+// only send the client ID, the assertion is added by
+// clientAssertionTransport.
+func oidcAuthStyle(method string) oauth2.AuthStyle {
+	switch method {
+	case types.OIDCClientAuthSecretBasic:
+		return oauth2.AuthStyleInHeader
+	case types.OIDCClientAuthSecretPost,
+		types.OIDCClientAuthSecretJWT,
+		types.OIDCClientAuthPrivateKeyJWT:
+		return oauth2.AuthStyleInParams
+	default:
+		return oauth2.AuthStyleAutoDetect
+	}
+}
+
+// newClientAssertionSigner returns the signer for the client assertions
+// of the provider, or nil if its client authentication method does not
+// use them.
+func newClientAssertionSigner(cfg types.OIDCProviderConfig) (jose.Signer, error) {
+	var key jose.SigningKey
+
+	switch cfg.ClientAuthMethod {
+	case types.OIDCClientAuthSecretJWT:
+		key = jose.SigningKey{Algorithm: jose.HS256, Key: []byte(cfg.ClientSecret)}
+	case types.OIDCClientAuthPrivateKeyJWT:
+		privateKey, err := readClientPrivateKey(cfg.ClientPrivateKeyPath)
+		if err != nil {
+			return nil, err
+		}
+
+		algorithm, err := signatureAlgorithm(privateKey)
+		if err != nil {
+			return nil, err
+		}
+
+		key = jose.SigningKey{Algorithm: algorithm, Key: privateKey}
+	default:
+		return nil, nil
+	}
+
+	opts := (&jose.SignerOptions{}).WithType("JWT")
+	if cfg.ClientKeyID != "" {
+		opts = opts.WithHeader("kid", cfg.ClientKeyID)
+	}
+
+	return jose.NewSigner(key, opts)
+}
+
+// readClientPrivateKey reads a PEM encoded PKCS #8, PKCS #1 or SEC 1
+// private key.
+func readClientPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading OIDC client private key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s is not PEM encoded", errOIDCClientPrivateKey, path)
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", errOIDCClientPrivateKey, path)
+}
+
+func signatureAlgorithm(key crypto.Signer) (jose.SignatureAlgorithm, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jose.RS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jose.ES256, nil
+		case elliptic.P384():
+			return jose.ES384, nil
+		case elliptic.P521():
+			return jose.ES512, nil
+		}
+	case ed25519.PrivateKey:
+		return jose.EdDSA, nil
+	}
+
+	return "", fmt.Errorf("%w: %T", errOIDCClientPrivateKey, key)
+}
+
+// signClientAssertion returns a client assertion for the token
+// endpoint as described in RFC 7523.
+func signClientAssertion(
+	signer jose.Signer,
+	clientID, tokenURL string,
+	now time.Time,
+) (string, error) {
+	randomBlob := make([]byte, randomByteSize)
+	if _, err := rand.Read(randomBlob); err != nil {
+		return "", err
+	}
+
+	return jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   clientID,
+		Subject:  clientID,
+		Audience: jwt.Audience{tokenURL},
+		ID:       hex.EncodeToString(randomBlob),
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	}).Serialize()
+}
+
+// clientAssertionTransport adds a new client assertion to every request
+// to the token endpoint. Assertions are single use, so requests that are
+// repeated, like polling in the device flow, each get their own.
+type clientAssertionTransport struct {
+	base     http.RoundTripper
+	signer   jose.Signer
+	clientID string
+	tokenURL string
+}
+
+func (t *clientAssertionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || req.URL.String() != t.tokenURL || req.Body == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	assertion, err := signClientAssertion(t.signer, t.clientID, t.tokenURL, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("signing OIDC client assertion: %w", err)
+	}
+	form.Set("client_assertion_type", clientAssertionType)
+	form.Set("client_assertion", assertion)
+
+	encoded := form.Encode()
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewBufferString(encoded))
+	req.ContentLength = int64(len(encoded))
+	req.Header.Set("Content-Length", strconv.Itoa(len(encoded)))
+
+	return t.base.RoundTrip(req)
+}
+
+// oauth2Context returns a context the oauth2 library uses to send token
+// requests for the provider with.
+func (p *oidcProvider) oauth2Context(ctx context.Context) context.Context {
+	if p.httpClient == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, oauth2.HTTPClient, p.httpClient)
+}
@@ -0,0 +1,117 @@
+package hscontrol
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"golang.org/x/oauth2"
+)
+
+func TestGetIDTokenWithPKCEAndClientAssertion(t *testing.T) {
+	const secret = "a-client-secret-that-is-long-enough"
+
+	var tokenURL string
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("parsing token request: %s", err)
+		}
+
+		if got := r.PostForm.Get("code_verifier"); got != "the-verifier" {
+			t.Errorf("code_verifier = %q, want %q", got, "the-verifier")
+		}
+
+		if got := r.PostForm.Get("client_secret"); got != "" {
+			t.Errorf("client_secret was sent with a client assertion")
+		}
+
+		if got := r.PostForm.Get("client_assertion_type"); got != clientAssertionType {
+			t.Errorf("client_assertion_type = %q", got)
+		}
+
+		assertion, err := jwt.ParseSigned(
+			r.PostForm.Get("client_assertion"),
+			[]jose.SignatureAlgorithm{jose.HS256},
+		)
+		if err != nil {
+			t.Fatalf("parsing client assertion: %s", err)
+		}
+
+		var claims jwt.Claims
+		if err := assertion.Claims([]byte(secret), &claims); err != nil {
+			t.Fatalf("verifying client assertion: %s", err)
+		}
+
+		err = claims.Validate(jwt.Expected{
+			Issuer:      "headscale",
+			Subject:     "headscale",
+			AnyAudience: jwt.Audience{tokenURL},
+			Time:        time.Now(),
+		})
+		if err != nil {
+			t.Errorf("client assertion claims: %s", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{ //nolint
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"id_token":     "id-token",
+		})
+	}))
+	defer idp.Close()
+	tokenURL = idp.URL + "/token"
+
+	cfg := types.OIDCProviderConfig{
+		Name:             "default",
+		ClientID:         "headscale",
+		ClientSecret:     secret,
+		ClientAuthMethod: types.OIDCClientAuthSecretJWT,
+	}
+
+	signer, err := newClientAssertionSigner(cfg)
+	if err != nil {
+		t.Fatalf("newClientAssertionSigner() error = %s", err)
+	}
+
+	provider := &oidcProvider{
+		cfg: cfg,
+		oauth2Config: &oauth2.Config{
+			ClientID: cfg.ClientID,
+			Endpoint: oauth2.Endpoint{
+				TokenURL:  tokenURL,
+				AuthStyle: oidcAuthStyle(cfg.ClientAuthMethod),
+			},
+		},
+		httpClient: &http.Client{
+			Transport: &clientAssertionTransport{
+				base:     http.DefaultTransport,
+				signer:   signer,
+				clientID: cfg.ClientID,
+				tokenURL: tokenURL,
+			},
+		},
+	}
+
+	rawIDToken, _, err := getIDTokenForOIDCCallback(
+		context.Background(),
+		httptest.NewRecorder(),
+		provider,
+		oidcRegistration{Provider: "default", Verifier: "the-verifier"},
+		"code",
+		"state",
+	)
+	if err != nil {
+		t.Fatalf("getIDTokenForOIDCCallback() error = %s", err)
+	}
+
+	if rawIDToken != "id-token" {
+		t.Errorf("getIDTokenForOIDCCallback() = %q, want %q", rawIDToken, "id-token")
+	}
+}
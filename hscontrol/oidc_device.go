@@ -0,0 +1,392 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"golang.org/x/oauth2"
+	"tailscale.com/types/key"
+)
+
+const (
+	oidcDeviceFlowCachePrefix     = "oidc-device-"
+	oidcDeviceCodePageCachePrefix = "oidc-device-page-"
+
+	// oidcDeviceFlowsMax limits the device flows waiting for approval,
+	// each polls the provider until it is approved or expires.
+	oidcDeviceFlowsMax = 256
+
+	// oidcDeviceFlowsPerAddrMax limits the device flows started by
+	// registration requests from one client address.
+	oidcDeviceFlowsPerAddrMax = 8
+)
+
+var (
+	errOIDCDeviceFlowStarting = errors.New("OIDC device flow of the node is being started")
+	errTooManyOIDCDeviceFlows = errors.New("too many OIDC device flows waiting for approval")
+)
+
+// oidcDeviceFlowStarting marks a machine key in the registration cache
+// while its device flow is being started.
+type oidcDeviceFlowStarting struct{}
+
+// oidcDeviceFlows counts the device flows waiting for approval, in
+// total and by the client address that started them.
+type oidcDeviceFlows struct {
+	mu     sync.Mutex
+	total  int
+	byAddr map[string]int
+}
+
+// acquire counts a new device flow started from clientAddr, or returns
+// errTooManyOIDCDeviceFlows when a limit is reached.
+func (f *oidcDeviceFlows) acquire(clientAddr string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.total >= oidcDeviceFlowsMax || f.byAddr[clientAddr] >= oidcDeviceFlowsPerAddrMax {
+		return errTooManyOIDCDeviceFlows
+	}
+
+	if f.byAddr == nil {
+		f.byAddr = make(map[string]int)
+	}
+	f.total++
+	f.byAddr[clientAddr]++
+
+	return nil
+}
+
+// release stops counting a device flow started from clientAddr.
+func (f *oidcDeviceFlows) release(clientAddr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.total--
+	f.byAddr[clientAddr]--
+	if f.byAddr[clientAddr] <= 0 {
+		delete(f.byAddr, clientAddr)
+	}
+}
+
+// deviceFlowProvider returns the first OIDC provider with the device
+// flow enabled, or nil if there is none. Registrations verified with a
+// code need a browser to enter it, so the device flow is not used.
+func (h *Headscale) deviceFlowProvider() *oidcProvider {
+	if h.cfg.RegistrationVerification.Method == types.RegistrationVerificationCode {
+		return nil
+	}
+
+	for _, provider := range h.oidcProviders {
+		if provider.cfg.DeviceFlow {
+			return provider
+		}
+	}
+
+	return nil
+}
+
+// registrationAuthURL returns the URL a node that is not registered, or
+// needs to reauthenticate, is sent to. clientAddr is the address the
+// registration request came from.
+func (h *Headscale) registrationAuthURL(machineKey key.MachinePublic, clientAddr string) string {
+	serverURL := strings.TrimSuffix(h.cfg.ServerURL, "/")
+
+	if len(h.oidcProviders) == 0 {
+		return fmt.Sprintf("%s/register/%s", serverURL, machineKey.String())
+	}
+
+	if provider := h.deviceFlowProvider(); provider != nil {
+		authURL, err := h.startOIDCDeviceFlow(provider, machineKey, clientAddr)
+		if err == nil {
+			return authURL
+		}
+
+		oidcLog.Error().
+			Caller().
+			Err(err).
+			Str("provider", provider.cfg.Name).
+			Str("machine_key", machineKey.ShortString()).
+			Str("client_address", clientAddr).
+			Msg("Failed to start OIDC device flow, falling back to the browser flow")
+	}
+
+	return fmt.Sprintf("%s/oidc/register/%s", serverURL, machineKey.String())
+}
+
+// startOIDCDeviceFlow requests a device code from the provider and
+// returns the URL the user approves the node at. The client repeats its
+// registration request while waiting, so the flow is only started once
+// per machine key and the same URL is returned until it expires.
+func (h *Headscale) startOIDCDeviceFlow(
+	provider *oidcProvider,
+	machineKey key.MachinePublic,
+	clientAddr string,
+) (string, error) {
+	cacheKey := oidcDeviceFlowCachePrefix + machineKey.String()
+	if authURL, ok := h.registrationCache.Get(cacheKey); ok {
+		if authURL, ok := authURL.(string); ok {
+			return authURL, nil
+		}
+
+		return "", errOIDCDeviceFlowStarting
+	}
+
+	// Claim the machine key, so concurrent requests do not start a
+	// second flow while the provider is asked for a device code.
+	if err := h.registrationCache.Add(cacheKey, oidcDeviceFlowStarting{}, registerCacheExpiration); err != nil {
+		return "", errOIDCDeviceFlowStarting
+	}
+
+	if err := h.oidcDeviceFlows.acquire(clientAddr); err != nil {
+		h.registrationCache.Delete(cacheKey)
+
+		return "", err
+	}
+
+	authURL, expiry, err := h.requestOIDCDeviceCode(provider, machineKey, clientAddr)
+	if err != nil {
+		h.registrationCache.Delete(cacheKey)
+		h.oidcDeviceFlows.release(clientAddr)
+
+		return "", err
+	}
+	h.registrationCache.Set(cacheKey, authURL, time.Until(expiry))
+
+	return authURL, nil
+}
+
+// requestOIDCDeviceCode requests a device code from the provider and
+// starts waiting for the user to approve it. It returns the URL the
+// user approves the node at, and when the device code expires.
+func (h *Headscale) requestOIDCDeviceCode(
+	provider *oidcProvider,
+	machineKey key.MachinePublic,
+	clientAddr string,
+) (string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), types.HTTPTimeout)
+	defer cancel()
+
+	deviceAuth, err := provider.oauth2Config.DeviceAuth(provider.oauth2Context(ctx))
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("requesting device code: %w", err)
+	}
+
+	randomBlob := make([]byte, randomByteSize)
+	if _, err := rand.Read(randomBlob); err != nil {
+		return "", time.Time{}, fmt.Errorf("generating state: %w", err)
+	}
+	state := hex.EncodeToString(randomBlob)[:32]
+
+	expiry := time.Now().Add(registerCacheExpiration)
+	if !deviceAuth.Expiry.IsZero() && deviceAuth.Expiry.Before(expiry) {
+		expiry = deviceAuth.Expiry
+	}
+
+	// Without a URL that includes the user code, the user is sent to
+	// a page of headscale showing the code to enter at the provider.
+	authURL := deviceAuth.VerificationURIComplete
+	if authURL == "" {
+		pageID, err := util.GenerateRandomStringURLSafe(randomByteSize)
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("generating device code page: %w", err)
+		}
+
+		h.registrationCache.Set(
+			oidcDeviceCodePageCachePrefix+pageID,
+			oidcDeviceCodeTemplateConfig{
+				UserCode:        deviceAuth.UserCode,
+				VerificationURI: deviceAuth.VerificationURI,
+			},
+			time.Until(expiry),
+		)
+		authURL = fmt.Sprintf("%s/oidc/device/%s", strings.TrimSuffix(h.cfg.ServerURL, "/"), pageID)
+	}
+
+	// The node is registered like a browser login, with the
+	// state only known to headscale.
+	h.registrationCache.Set(
+		state,
+		oidcRegistration{
+			MachineKey: machineKey,
+			Provider:   provider.cfg.Name,
+		},
+		registerCacheExpiration,
+	)
+
+	oidcLog.Info().
+		Str("provider", provider.cfg.Name).
+		Str("machine_key", machineKey.ShortString()).
+		Msg("Started OIDC device flow")
+
+	go h.completeOIDCDeviceFlow(provider, state, machineKey, deviceAuth, expiry, clientAddr)
+
+	return authURL, expiry, nil
+}
+
+// completeOIDCDeviceFlow waits for the user to approve the device code
+// and then registers the node like the browser callback would.
+func (h *Headscale) completeOIDCDeviceFlow(
+	provider *oidcProvider,
+	state string,
+	machineKey key.MachinePublic,
+	deviceAuth *oauth2.DeviceAuthResponse,
+	expiry time.Time,
+	clientAddr string,
+) {
+	defer h.oidcDeviceFlows.release(clientAddr)
+
+	ctx, cancel := context.WithDeadline(context.Background(), expiry)
+	defer cancel()
+
+	token, err := provider.oauth2Config.DeviceAccessToken(provider.oauth2Context(ctx), deviceAuth)
+	if err != nil {
+		oidcLog.Warn().
+			Err(err).
+			Str("provider", provider.cfg.Name).
+			Str("machine_key", machineKey.ShortString()).
+			Msg("OIDC device flow was not approved")
+
+		return
+	}
+
+	writer := &deviceFlowResponseWriter{header: http.Header{}}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		oidcLog.Error().
+			Err(errNoOIDCIDToken).
+			Str("provider", provider.cfg.Name).
+			Msg("OIDC device flow failed")
+
+		return
+	}
+
+	idToken, err := verifyIDTokenForOIDCCallback(ctx, writer, provider, rawIDToken)
+	if err != nil {
+		return
+	}
+
+	h.completeOIDCLogin(writer, provider, state, idToken, token.RefreshToken)
+
+	if writer.status != http.StatusOK {
+		oidcLog.Warn().
+			Int("status", writer.status).
+			Str("provider", provider.cfg.Name).
+			Str("machine_key", machineKey.ShortString()).
+			Str("response", writer.body.String()).
+			Msg("OIDC device flow login was rejected")
+
+		return
+	}
+
+	oidcLog.Info().
+		Str("provider", provider.cfg.Name).
+		Str("machine_key", machineKey.ShortString()).
+		Msg("OIDC device flow login completed")
+}
+
+type oidcDeviceCodeTemplateConfig struct {
+	UserCode        string
+	VerificationURI string
+}
+
+var oidcDeviceCodeTemplate = template.Must(
+	template.New("oidcdevicecode").Parse(`
+<html>
+	<head>
+		<title>Sign in - Headscale</title>
+		<meta name=viewport content="width=device-width, initial-scale=1">
+		<style>
+			body {
+				font-family: sans;
+			}
+			code {
+				font-size: 2em;
+			}
+		</style>
+	</head>
+	<body>
+		<h1>headscale</h1>
+		<h2>Sign in</h2>
+		<p>Enter this code to approve your device:</p>
+		<p><code>{{.UserCode}}</code></p>
+		<p><a href="{{.VerificationURI}}">Continue to sign in</a></p>
+	</body>
+</html>
+`))
+
+// OIDCDeviceCode shows the user code of a device flow, for providers
+// that do not return a verification URL including it.
+// Listens in /oidc/device/:id.
+func (h *Headscale) OIDCDeviceCode(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	pageID := mux.Vars(req)["id"]
+
+	page, ok := h.registrationCache.Get(oidcDeviceCodePageCachePrefix + pageID)
+	if !ok {
+		http.Error(writer, "Unknown or expired sign in, restart it from the device", http.StatusNotFound)
+
+		return
+	}
+
+	config, ok := page.(oidcDeviceCodeTemplateConfig)
+	if !ok {
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	var content bytes.Buffer
+	if err := oidcDeviceCodeTemplate.Execute(&content, config); err != nil {
+		util.LogErr(err, "Could not render OIDC device code template")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		util.LogErr(err, "Failed to write response")
+	}
+}
+
+// deviceFlowResponseWriter collects the response of the login steps
+// shared with the browser callback, the device flow has no browser to
+// send it to so the outcome is logged instead.
+type deviceFlowResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *deviceFlowResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *deviceFlowResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.body.Write(b)
+}
+
+func (w *deviceFlowResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
@@ -0,0 +1,145 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/oauth2"
+	"tailscale.com/types/key"
+)
+
+// newTestDeviceFlowHeadscale returns a headscale with an OIDC provider
+// supporting the device flow, and the number of device codes requested
+// from the provider. The device codes are never approved.
+func newTestDeviceFlowHeadscale(t *testing.T, completeURI bool) (*Headscale, *oidcProvider, *atomic.Int32) {
+	t.Helper()
+
+	var deviceRequests atomic.Int32
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/device":
+			deviceRequests.Add(1)
+			resp := map[string]any{
+				"device_code":      "device-code",
+				"user_code":        "ABCD-EFGH",
+				"verification_uri": "https://idp.example.com/device",
+				"expires_in":       1,
+				"interval":         1,
+			}
+			if completeURI {
+				resp["verification_uri_complete"] = "https://idp.example.com/device?code=ABCD-EFGH"
+			}
+			json.NewEncoder(w).Encode(resp) //nolint
+		case "/token":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{ //nolint
+				"error": "authorization_pending",
+			})
+		}
+	}))
+	t.Cleanup(idp.Close)
+
+	provider := &oidcProvider{
+		cfg: types.OIDCProviderConfig{Name: "default", DeviceFlow: true},
+		oauth2Config: &oauth2.Config{
+			ClientID: "headscale",
+			Endpoint: oauth2.Endpoint{
+				DeviceAuthURL: idp.URL + "/device",
+				TokenURL:      idp.URL + "/token",
+			},
+		},
+	}
+
+	h := &Headscale{
+		cfg:               &types.Config{ServerURL: "https://headscale.example.com/"},
+		registrationCache: cache.New(registerCacheExpiration, time.Minute),
+		oidcProviders:     []*oidcProvider{provider},
+	}
+
+	return h, provider, &deviceRequests
+}
+
+func TestRegistrationAuthURLDeviceFlow(t *testing.T) {
+	h, provider, deviceRequests := newTestDeviceFlowHeadscale(t, true)
+	machineKey := key.NewMachine().Public()
+
+	provider.cfg.DeviceFlow = false
+	if got := h.registrationAuthURL(machineKey, "192.0.2.1"); !strings.HasPrefix(got, "https://headscale.example.com/oidc/register/") {
+		t.Errorf("registrationAuthURL() without device flow = %q", got)
+	}
+
+	provider.cfg.DeviceFlow = true
+
+	want := "https://idp.example.com/device?code=ABCD-EFGH"
+	for range 2 {
+		if got := h.registrationAuthURL(machineKey, "192.0.2.1"); got != want {
+			t.Errorf("registrationAuthURL() = %q, want %q", got, want)
+		}
+	}
+
+	if got := deviceRequests.Load(); got != 1 {
+		t.Errorf("device authorization requests = %d, want 1", got)
+	}
+}
+
+func TestOIDCDeviceCodePage(t *testing.T) {
+	h, _, _ := newTestDeviceFlowHeadscale(t, false)
+
+	authURL := h.registrationAuthURL(key.NewMachine().Public(), "192.0.2.1")
+	pageID, ok := strings.CutPrefix(authURL, "https://headscale.example.com/oidc/device/")
+	if !ok {
+		t.Fatalf("registrationAuthURL() = %q, want the device code page", authURL)
+	}
+
+	rec := httptest.NewRecorder()
+	req := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/oidc/device/"+pageID, nil), map[string]string{"id": pageID})
+	h.OIDCDeviceCode(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("OIDCDeviceCode() status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, want := range []string{"ABCD-EFGH", "https://idp.example.com/device"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("device code page does not contain %q", want)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	req = mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/oidc/device/unknown", nil), map[string]string{"id": "unknown"})
+	h.OIDCDeviceCode(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("OIDCDeviceCode() of an unknown page status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestOIDCDeviceFlowsPerAddrLimit(t *testing.T) {
+	h, _, deviceRequests := newTestDeviceFlowHeadscale(t, true)
+
+	for range oidcDeviceFlowsPerAddrMax {
+		if got := h.registrationAuthURL(key.NewMachine().Public(), "192.0.2.1"); !strings.HasPrefix(got, "https://idp.example.com/") {
+			t.Fatalf("registrationAuthURL() = %q, want the device flow", got)
+		}
+	}
+
+	// Further flows from the address fall back to the browser flow
+	// without asking the provider, other addresses are not limited.
+	if got := h.registrationAuthURL(key.NewMachine().Public(), "192.0.2.1"); !strings.HasPrefix(got, "https://headscale.example.com/oidc/register/") {
+		t.Errorf("registrationAuthURL() over the limit = %q, want the browser flow", got)
+	}
+	if got := deviceRequests.Load(); got != oidcDeviceFlowsPerAddrMax {
+		t.Errorf("device authorization requests = %d, want %d", got, oidcDeviceFlowsPerAddrMax)
+	}
+	if got := h.registrationAuthURL(key.NewMachine().Public(), "192.0.2.2"); !strings.HasPrefix(got, "https://idp.example.com/") {
+		t.Errorf("registrationAuthURL() from another address = %q, want the device flow", got)
+	}
+}
@@ -0,0 +1,230 @@
+package hscontrol
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxPageSize caps page_size, a request without page_size still gets
+// all the items.
+const maxPageSize = 1000
+
+// listRequest is a list request supporting pagination, sorting and
+// field masks.
+type listRequest interface {
+	proto.Message
+	GetPageSize() int32
+	GetPageToken() string
+	GetOrderBy() string
+	GetReadMask() *fieldmaskpb.FieldMask
+}
+
+// pageToken is the position of the next page. It is only valid for the
+// query it was returned for.
+type pageToken struct {
+	Offset int    `json:"o"`
+	Query  string `json:"q"`
+}
+
+// listOrders are the fields items can be sorted by with order_by, they
+// must include "id", the default order and the tie breaker.
+type listOrders[T any] map[string]func(a, b T) int
+
+// paginate sorts the items as requested by order_by and returns the
+// requested page, with the read mask applied, and the token of the next
+// page. Items added or removed between two requests shift the pages.
+func paginate[T proto.Message](req listRequest, items []T, orders listOrders[T]) ([]T, string, error) {
+	if req.GetPageSize() < 0 {
+		return nil, "", status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+
+	if err := sortItems(items, req.GetOrderBy(), orders); err != nil {
+		return nil, "", err
+	}
+
+	query, err := queryFingerprint(req)
+	if err != nil {
+		return nil, "", err
+	}
+
+	offset := 0
+	if req.GetPageToken() != "" {
+		token, err := decodePageToken(req.GetPageToken())
+		if err != nil || token.Query != query || token.Offset < 0 {
+			return nil, "", status.Error(codes.InvalidArgument, "invalid page_token, it does not belong to this query")
+		}
+		offset = min(token.Offset, len(items))
+	}
+
+	pageSize := int(req.GetPageSize())
+	switch {
+	case pageSize == 0 && req.GetPageToken() == "":
+		// Clients not paginating get everything, like before.
+		pageSize = len(items)
+	case pageSize == 0 || pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	end := min(offset+pageSize, len(items))
+	page := items[offset:end]
+
+	var next string
+	if end < len(items) {
+		next = encodePageToken(pageToken{Offset: end, Query: query})
+	}
+
+	if mask := req.GetReadMask(); len(mask.GetPaths()) > 0 {
+		for _, item := range page {
+			if err := applyReadMask(item, mask); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	return page, next, nil
+}
+
+func sortItems[T any](items []T, orderBy string, orders listOrders[T]) error {
+	byID := orders["id"]
+	slices.SortStableFunc(items, byID)
+
+	fields := strings.Fields(orderBy)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	compare, ok := orders[fields[0]]
+	if !ok || len(fields) > 2 || len(fields) == 2 && fields[1] != "asc" && fields[1] != "desc" {
+		names := make([]string, 0, len(orders))
+		for name := range orders {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		return status.Errorf(
+			codes.InvalidArgument,
+			"invalid order_by %q, it must be one of %s, optionally followed by asc or desc",
+			orderBy,
+			strings.Join(names, ", "),
+		)
+	}
+
+	if len(fields) == 2 && fields[1] == "desc" {
+		ascending := compare
+		compare = func(a, b T) int { return -ascending(a, b) }
+	}
+
+	slices.SortStableFunc(items, compare)
+
+	return nil
+}
+
+// queryFingerprint hashes the request without its paging fields.
+func queryFingerprint(req listRequest) (string, error) {
+	query := proto.Clone(req).ProtoReflect()
+	fields := query.Descriptor().Fields()
+	query.Clear(fields.ByName("page_size"))
+	query.Clear(fields.ByName("page_token"))
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(query.Interface())
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:8]), nil
+}
+
+func encodePageToken(token pageToken) string {
+	data, _ := json.Marshal(token)
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(value string) (pageToken, error) {
+	var token pageToken
+
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return token, err
+	}
+
+	err = json.Unmarshal(data, &token)
+
+	return token, err
+}
+
+// applyReadMask clears the fields of msg not listed in the mask.
+func applyReadMask(msg proto.Message, mask *fieldmaskpb.FieldMask) error {
+	if !mask.IsValid(msg) {
+		return status.Errorf(
+			codes.InvalidArgument,
+			"invalid read_mask %q for %s",
+			strings.Join(mask.GetPaths(), ","),
+			msg.ProtoReflect().Descriptor().Name(),
+		)
+	}
+
+	paths := make([][]string, 0, len(mask.GetPaths()))
+	for _, path := range mask.GetPaths() {
+		paths = append(paths, strings.Split(path, "."))
+	}
+	pruneMessage(msg.ProtoReflect(), paths)
+
+	return nil
+}
+
+// pruneMessage keeps the fields of m on the paths, a path ending at a
+// message keeps all its fields.
+func pruneMessage(m protoreflect.Message, paths [][]string) {
+	keep := make(map[protoreflect.Name][][]string)
+	for _, path := range paths {
+		name := protoreflect.Name(path[0])
+		if len(path) == 1 {
+			keep[name] = nil
+
+			continue
+		}
+		if sub, ok := keep[name]; !ok || sub != nil {
+			keep[name] = append(sub, path[1:])
+		}
+	}
+
+	m.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		sub, ok := keep[field.Name()]
+		switch {
+		case !ok:
+			m.Clear(field)
+		case sub != nil && field.Message() != nil && !field.IsList() && !field.IsMap():
+			pruneMessage(value.Message(), sub)
+		}
+
+		return true
+	})
+}
+
+func compareTimestamps(a, b *timestamppb.Timestamp) int {
+	return a.AsTime().Compare(b.AsTime())
+}
+
+// compareNumericIDs compares the string IDs of users and pre auth keys
+// as numbers.
+func compareNumericIDs(a, b string) int {
+	idA, _ := strconv.ParseUint(a, 10, 64)
+	idB, _ := strconv.ParseUint(b, 10, 64)
+
+	return cmp.Compare(idA, idB)
+}
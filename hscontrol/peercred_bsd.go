@@ -0,0 +1,35 @@
+//go:build darwin || freebsd
+
+package hscontrol
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func readPeerCredentials(conn net.Conn) (uint32, []uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, nil, errPeerCredentialsUnsupported
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var cred *unix.Xucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if credErr != nil {
+		return 0, nil, credErr
+	}
+
+	return cred.Uid, cred.Groups[:cred.Ngroups], nil
+}
@@ -0,0 +1,33 @@
+package hscontrol
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func readPeerCredentials(conn net.Conn) (uint32, []uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, nil, errPeerCredentialsUnsupported
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if credErr != nil {
+		return 0, nil, credErr
+	}
+
+	return cred.Uid, []uint32{cred.Gid}, nil
+}
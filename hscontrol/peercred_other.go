@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd
+
+package hscontrol
+
+import "net"
+
+func readPeerCredentials(conn net.Conn) (uint32, []uint32, error) {
+	return 0, nil, errPeerCredentialsUnsupported
+}
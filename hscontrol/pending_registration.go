@@ -0,0 +1,60 @@
+package hscontrol
+
+import (
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/types/key"
+)
+
+// setPendingRegistration keeps the node waiting to be registered in the
+// registration cache and in the database, so its registration URL stays
+// valid if headscale restarts before it is registered.
+func (h *Headscale) setPendingRegistration(machineKey key.MachinePublic, node types.Node) {
+	h.registrationCache.Set(machineKey.String(), node, registerCacheExpiration)
+
+	err := h.db.SavePendingRegistration(
+		machineKey.String(),
+		node,
+		time.Now().Add(registerCacheExpiration),
+	)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("machine_key", machineKey.ShortString()).
+			Msg("failed to persist pending registration, it will be lost on restart")
+	}
+}
+
+// restorePendingRegistrations loads the nodes waiting to be registered
+// into the registration cache, for the time they have left. The expired
+// ones are deleted first, unless the database is read-only.
+func (h *Headscale) restorePendingRegistrations() error {
+	if !h.isReadOnly() {
+		if _, err := h.db.DeleteExpiredPendingRegistrations(); err != nil {
+			return err
+		}
+	}
+
+	registrations, err := h.db.ListPendingRegistrations()
+	if err != nil {
+		return err
+	}
+
+	for _, registration := range registrations {
+		h.registrationCache.Set(
+			registration.MachineKey,
+			registration.Node,
+			time.Until(registration.ExpiresAt),
+		)
+	}
+
+	if len(registrations) > 0 {
+		log.Info().
+			Int("count", len(registrations)).
+			Msg("Restored pending registrations")
+	}
+
+	return nil
+}
@@ -7,18 +7,21 @@ import (
 	"io"
 	"net/netip"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/juanfont/headscale/hscontrol/policy/matcher"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/rs/zerolog/log"
 	"github.com/tailscale/hujson"
 	"go4.org/netipx"
 	"tailscale.com/tailcfg"
 )
 
+var policyLog = util.NewSubsystemLogger(util.LogSubsystemPolicy)
+
 var (
 	ErrEmptyPolicy       = errors.New("empty policy")
 	ErrInvalidAction     = errors.New("invalid action")
@@ -27,7 +30,10 @@ var (
 	ErrInvalidPortFormat = errors.New("invalid port format")
 	ErrWildcardIsNeeded  = errors.New("wildcard as port is required for the protocol")
 	ErrUnknownAutogroup  = errors.New("unknown autogroup")
+	ErrGroupNotFound     = errors.New("group not found in policy")
+	ErrHostNotFound      = errors.New("host not found in policy")
 	ErrAutogroupSelf     = errors.New(`dst "autogroup:self" only works with one src "autogroup:member" or "autogroup:self"`)
+	ErrUnknownPosture    = errors.New("unknown posture")
 )
 
 const (
@@ -42,6 +48,16 @@ const (
 	autogroupTagged    = "autogroup:tagged"
 	autogroupNonRoot   = "autogroup:nonroot"
 	autogroupDangerAll = "autogroup:danger-all"
+
+	postureAttested = "node:attested"
+)
+
+// The peer capabilities of Tailscale peer relays. A node granted
+// PeerCapabilityRelay on a peer may relay its traffic through it, the
+// peer is told with PeerCapabilityRelayTarget granted the other way.
+const (
+	PeerCapabilityRelay       tailcfg.PeerCapability = "tailscale.com/cap/relay"
+	PeerCapabilityRelayTarget tailcfg.PeerCapability = "tailscale.com/cap/relay-target"
 )
 
 var theInternetSet *netipx.IPSet
@@ -110,7 +126,7 @@ const (
 
 // LoadACLPolicyFromPath loads the ACL policy from the specify path, and generates the ACL rules.
 func LoadACLPolicyFromPath(path string) (*ACLPolicy, error) {
-	log.Debug().
+	policyLog.Debug().
 		Str("func", "LoadACLPolicy").
 		Str("path", path).
 		Msg("Loading ACL policy from path")
@@ -126,10 +142,15 @@ func LoadACLPolicyFromPath(path string) (*ACLPolicy, error) {
 		return nil, err
 	}
 
-	log.Debug().
+	event := policyLog.Debug().
 		Str("path", path).
-		Bytes("file", policyBytes).
-		Msg("Loading ACLs")
+		Int("size", len(policyBytes))
+	// The policy can hold addresses and names operators do not want in
+	// their logs, it is only logged in full with redaction turned off.
+	if !util.LogRedaction() {
+		event = event.Bytes("file", policyBytes)
+	}
+	event.Msg("Loading ACLs")
 
 	return LoadACLPolicyFromBytes(policyBytes)
 }
@@ -153,6 +174,8 @@ func LoadACLPolicyFromBytes(acl []byte) (*ACLPolicy, error) {
 		return nil, ErrEmptyPolicy
 	}
 
+	policy.fieldDeprecations = fieldDeprecations(acl)
+
 	return &policy, nil
 }
 
@@ -171,7 +194,7 @@ func GenerateFilterAndSSHRulesForTests(
 		return []tailcfg.FilterRule{}, &tailcfg.SSHPolicy{}, err
 	}
 
-	log.Trace().Interface("ACL", rules).Str("node", node.GivenName).Msg("ACL rules")
+	policyLog.Trace().Interface("ACL", rules).Str("node", node.GivenName).Msg("ACL rules")
 
 	sshPolicy, err := policy.CompileSSHPolicy(node, peers)
 	if err != nil {
@@ -226,9 +249,11 @@ func (pol *ACLPolicy) CompileFilterRules(
 					destinations = oldDst
 
 					splitACL := ACL{
-						Action:       acl.Action,
-						Sources:      []string{autogroupSelf},
-						Destinations: newDst,
+						Action:        acl.Action,
+						Sources:       []string{autogroupSelf},
+						Destinations:  newDst,
+						SourcePosture: acl.SourcePosture,
+						Description:   acl.Description,
 					}
 					acls = append(acls, splitACL)
 				}
@@ -240,6 +265,14 @@ func (pol *ACLPolicy) CompileFilterRules(
 			srcIPs = append(srcIPs, srcs...)
 		}
 
+		if len(acl.SourcePosture) > 0 {
+			var err error
+			srcIPs, err = filterSourcesByPosture(srcIPs, acl.SourcePosture, nodes)
+			if err != nil {
+				return nil, fmt.Errorf("parsing policy, acl index: %d: %w", index, err)
+			}
+		}
+
 		protocols, isWildcard, err := parseProtocol(acl.Protocol)
 		if err != nil {
 			return nil, fmt.Errorf("parsing policy, protocol err: %w ", err)
@@ -291,9 +324,72 @@ func (pol *ACLPolicy) CompileFilterRules(
 		})
 	}
 
+	relayRules, err := pol.compilePeerRelays(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(rules, relayRules...), nil
+}
+
+// compilePeerRelays returns the rules granting the sources of every
+// peer relay rule the relay capability on its relays, and the relays
+// the relay-target capability on the sources, telling the sources they
+// can use them. These rules only carry capabilities, they do not allow
+// any traffic.
+func (pol *ACLPolicy) compilePeerRelays(nodes types.Nodes) ([]tailcfg.FilterRule, error) {
+	var rules []tailcfg.FilterRule
+	for index, relay := range pol.PeerRelays {
+		expand := func(aliases []string) (*netipx.IPSet, error) {
+			var build netipx.IPSetBuilder
+			for _, alias := range aliases {
+				set, err := pol.ExpandAlias(nodes, alias)
+				if err != nil {
+					return nil, fmt.Errorf("parsing policy, peerRelays index: %d: %w", index, err)
+				}
+				build.AddSet(set)
+			}
+
+			return build.IPSet()
+		}
+
+		srcs, err := expand(relay.Sources)
+		if err != nil {
+			return nil, err
+		}
+		relays, err := expand(relay.Destinations)
+		if err != nil {
+			return nil, err
+		}
+		if len(srcs.Prefixes()) == 0 || len(relays.Prefixes()) == 0 {
+			continue
+		}
+
+		rules = append(rules,
+			capGrantRule(srcs, relays, PeerCapabilityRelay),
+			capGrantRule(relays, srcs, PeerCapabilityRelayTarget),
+		)
+	}
+
 	return rules, nil
 }
 
+// capGrantRule returns a rule granting srcs the capability on dsts.
+func capGrantRule(srcs, dsts *netipx.IPSet, capability tailcfg.PeerCapability) tailcfg.FilterRule {
+	var srcIPs []string
+	for _, prefix := range srcs.Prefixes() {
+		srcIPs = append(srcIPs, prefix.String())
+	}
+
+	return tailcfg.FilterRule{
+		SrcIPs: srcIPs,
+		CapGrant: []tailcfg.CapGrant{{
+			Dsts:   dsts.Prefixes(),
+			CapMap: tailcfg.PeerCapMap{capability: nil},
+		}},
+	}
+}
+
 // ReduceFilterRules takes a node and a set of rules and removes all rules and destinations
 // that are not relevant to that particular node.
 func ReduceFilterRules(node *types.Node, rules []tailcfg.FilterRule) []tailcfg.FilterRule {
@@ -330,11 +426,21 @@ func ReduceFilterRules(node *types.Node, rules []tailcfg.FilterRule) []tailcfg.F
 			}
 		}
 
-		if len(dests) > 0 {
+		var grants []tailcfg.CapGrant
+		for _, grant := range rule.CapGrant {
+			if slices.ContainsFunc(grant.Dsts, func(dst netip.Prefix) bool {
+				return slices.ContainsFunc(node.IPs(), dst.Contains)
+			}) {
+				grants = append(grants, grant)
+			}
+		}
+
+		if len(dests) > 0 || len(grants) > 0 {
 			ret = append(ret, tailcfg.FilterRule{
 				SrcIPs:   rule.SrcIPs,
 				DstPorts: dests,
 				IPProto:  rule.IPProto,
+				CapGrant: grants,
 			})
 		}
 	}
@@ -350,7 +456,10 @@ func (pol *ACLPolicy) CompileSSHPolicy(
 		return nil, nil
 	}
 
-	var rules []*tailcfg.SSHRule
+	var (
+		rules        []*tailcfg.SSHRule
+		descriptions []string
+	)
 
 	acceptAction := tailcfg.SSHAction{
 		Message:                  "",
@@ -411,10 +520,19 @@ func (pol *ACLPolicy) CompileSSHPolicy(
 			} else {
 				action = *checkAction
 			}
+		case "reject":
+			action = rejectAction
 		default:
 			return nil, fmt.Errorf("parsing SSH policy, unknown action %q, index: %d: %w", sshACL.Action, index, err)
 		}
 
+		if sshACL.Message != "" {
+			action.Message = sshACL.Message
+			if !strings.HasSuffix(action.Message, "\n") {
+				action.Message += "\n"
+			}
+		}
+
 		principals := make([]*tailcfg.SSHPrincipal, 0, len(sshACL.Sources))
 		for innerIndex, rawSrc := range sshACL.Sources {
 			if isWildcard(rawSrc) {
@@ -461,6 +579,8 @@ func (pol *ACLPolicy) CompileSSHPolicy(
 							Destinations: newDst,
 							Users:        sshACL.Users,
 							CheckPeriod:  sshACL.CheckPeriod,
+							Description:  sshACL.Description,
+							Message:      sshACL.Message,
 						}
 						sshs = append(sshs, splitACL)
 					}
@@ -489,6 +609,23 @@ func (pol *ACLPolicy) CompileSSHPolicy(
 			SSHUsers:   userMap,
 			Action:     &action,
 		})
+
+		if sshACL.Description != "" && !slices.Contains(descriptions, sshACL.Description) {
+			descriptions = append(descriptions, sshACL.Description)
+		}
+	}
+
+	// The rules are matched in order, the connections no rule accepts
+	// are rejected with the descriptions of the rules of the node, so
+	// the users know whom to ask for access.
+	if len(descriptions) > 0 {
+		reject := rejectAction
+		reject.Message = sshRejectMessage(descriptions)
+		rules = append(rules, &tailcfg.SSHRule{
+			Principals: []*tailcfg.SSHPrincipal{{Any: true}},
+			SSHUsers:   map[string]string{"*": "="},
+			Action:     &reject,
+		})
 	}
 
 	return &tailcfg.SSHPolicy{
@@ -496,6 +633,16 @@ func (pol *ACLPolicy) CompileSSHPolicy(
 	}, nil
 }
 
+func sshRejectMessage(descriptions []string) string {
+	var msg strings.Builder
+	msg.WriteString("Tailscale SSH access to this node was denied by the policy.\n")
+	for _, description := range descriptions {
+		msg.WriteString("  " + description + "\n")
+	}
+
+	return msg.String()
+}
+
 func sshCheckAction(duration string) (*tailcfg.SSHAction, error) {
 	sessionLength, err := time.ParseDuration(duration)
 	if err != nil {
@@ -523,7 +670,7 @@ func parseDestination(dest string) (string, string, error) {
 		port := tokens[len(tokens)-1]
 
 		maybeIPv6Str := strings.TrimSuffix(dest, ":"+port)
-		log.Trace().Str("maybeIPv6Str", maybeIPv6Str).Msg("")
+		policyLog.Trace().Str("maybeIPv6Str", maybeIPv6Str).Msg("")
 
 		filteredMaybeIPv6Str := maybeIPv6Str
 		if strings.Contains(maybeIPv6Str, "/") {
@@ -532,7 +679,7 @@ func parseDestination(dest string) (string, string, error) {
 		}
 
 		if maybeIPv6, err := netip.ParseAddr(filteredMaybeIPv6Str); err != nil && !maybeIPv6.Is6() {
-			log.Trace().Err(err).Msg("trying to parse as IPv6")
+			policyLog.Trace().Err(err).Msg("trying to parse as IPv6")
 
 			return "", "", fmt.Errorf(
 				"failed to parse destination, tokens %v: %w",
@@ -614,6 +761,56 @@ func parseProtocol(protocol string) ([]int, bool, error) {
 
 // expandSource returns a set of Source IPs that would be associated
 // with the given src alias.
+// filterSourcesByPosture keeps the addresses of the sources that belong
+// to nodes satisfying all the postures.
+func filterSourcesByPosture(
+	srcIPs []string,
+	postures []string,
+	nodes types.Nodes,
+) ([]string, error) {
+	for _, posture := range postures {
+		if posture != postureAttested {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownPosture, posture)
+		}
+	}
+
+	// node:attested is the only posture so far.
+	var allowed netipx.IPSetBuilder
+	for _, node := range nodes {
+		if node.IsAttested() {
+			node.AppendToIPSet(&allowed)
+		}
+	}
+
+	allowedSet, err := allowed.IPSet()
+	if err != nil {
+		return nil, err
+	}
+
+	var sources netipx.IPSetBuilder
+	for _, src := range srcIPs {
+		prefix, err := netip.ParsePrefix(src)
+		if err != nil {
+			return nil, err
+		}
+		sources.AddPrefix(prefix)
+	}
+
+	sources.Intersect(allowedSet)
+
+	ipSet, err := sources.IPSet()
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []string
+	for _, prefix := range ipSet.Prefixes() {
+		prefixes = append(prefixes, prefix.String())
+	}
+
+	return prefixes, nil
+}
+
 func (pol *ACLPolicy) expandSource(
 	src string,
 	nodes types.Nodes,
@@ -639,21 +836,25 @@ func (pol *ACLPolicy) expandSource(
 // - an ip
 // - a cidr
 // - an autogroup
+// - a service
 // and transform these in IPAddresses.
 func (pol *ACLPolicy) ExpandAlias(
 	nodes types.Nodes,
 	alias string,
 ) (*netipx.IPSet, error) {
+	// Nodes of suspended users are never granted access by an alias.
 	if isWildcard(alias) {
-		return util.ParseIPSet("*", nil)
+		return expandWildcard(nodes)
 	}
 
 	build := netipx.IPSetBuilder{}
 
-	log.Debug().
+	policyLog.Debug().
 		Str("alias", alias).
 		Msg("Expanding")
 
+	nodes = excludeSuspendedNodes(nodes)
+
 	// if alias is a group
 	if isGroup(alias) {
 		return pol.expandIPsFromGroup(alias, nodes)
@@ -668,6 +869,11 @@ func (pol *ACLPolicy) ExpandAlias(
 		return pol.expandAutoGroup(alias, nodes)
 	}
 
+	// if alias is a service
+	if isService(alias) {
+		return expandIPsFromService(alias, nodes)
+	}
+
 	// if alias is a user
 	if ips, err := pol.expandIPsFromUser(alias, nodes); ips != nil {
 		return ips, err
@@ -676,7 +882,7 @@ func (pol *ACLPolicy) ExpandAlias(
 	// if alias is an host
 	// Note, this is recursive.
 	if h, ok := pol.Hosts[alias]; ok {
-		log.Trace().Str("host", h.String()).Msg("ExpandAlias got hosts entry")
+		policyLog.Trace().Str("host", h.String()).Msg("ExpandAlias got hosts entry")
 
 		return pol.ExpandAlias(nodes, h.String())
 	}
@@ -691,7 +897,25 @@ func (pol *ACLPolicy) ExpandAlias(
 		return pol.expandIPsFromIPPrefix(prefix, nodes)
 	}
 
-	log.Warn().Msgf("No IPs found with the alias %v", alias)
+	policyLog.Warn().Msgf("No IPs found with the alias %v", alias)
+
+	return build.IPSet()
+}
+
+// expandIPsFromService returns the IPs of the nodes attributed to the
+// service by the pre auth key they registered with.
+func expandIPsFromService(
+	alias string,
+	nodes types.Nodes,
+) (*netipx.IPSet, error) {
+	var build netipx.IPSetBuilder
+
+	service := strings.TrimPrefix(alias, "svc:")
+	for _, node := range nodes {
+		if node.Service == service {
+			node.AppendToIPSet(&build)
+		}
+	}
 
 	return build.IPSet()
 }
@@ -752,7 +976,7 @@ func expandPorts(portsStr string, isWild bool) (*[]tailcfg.PortRange, error) {
 
 	var ports []tailcfg.PortRange
 	for _, portStr := range strings.Split(portsStr, ",") {
-		log.Trace().Msgf("parsing portstring: %s", portStr)
+		policyLog.Trace().Msgf("parsing portstring: %s", portStr)
 		rang := strings.Split(portStr, "-")
 		switch len(rang) {
 		case 1:
@@ -787,8 +1011,8 @@ func expandPorts(portsStr string, isWild bool) (*[]tailcfg.PortRange, error) {
 	return &ports, nil
 }
 
-// expandOwnersFromTag will return a list of user. An owner can be either a user or a group
-// a group cannot be composed of groups.
+// expandOwnersFromTag will return a list of user. An owner can be either a user, a group
+// or a service account as svc:<name>, a group cannot be composed of groups.
 func expandOwnersFromTag(
 	pol *ACLPolicy,
 	tag string,
@@ -814,7 +1038,7 @@ func expandOwnersFromTag(
 			}
 			owners = append(owners, gs...)
 		} else {
-			owners = append(owners, owner)
+			owners = append(owners, pol.resolveUser(owner))
 		}
 	}
 
@@ -827,8 +1051,11 @@ func (pol *ACLPolicy) expandUsersFromGroup(
 	group string,
 ) ([]string, error) {
 	var users []string
-	log.Trace().Caller().Interface("pol", pol).Msg("test")
+	policyLog.Trace().Caller().Interface("pol", pol).Msg("test")
 	aclGroups, ok := pol.Groups[group]
+	if !ok {
+		aclGroups, ok = pol.DirectoryGroups[group]
+	}
 	if !ok {
 		return []string{}, fmt.Errorf(
 			"group %v isn't registered. %w",
@@ -851,7 +1078,7 @@ func (pol *ACLPolicy) expandUsersFromGroup(
 				ErrInvalidGroup,
 			)
 		}
-		users = append(users, grp)
+		users = append(users, pol.resolveUser(grp))
 	}
 
 	return users, nil
@@ -910,16 +1137,13 @@ func (pol *ACLPolicy) expandIPsFromTag(
 	}
 
 	// filter out nodes per tag owner
-	for _, user := range owners {
-		nodes := filterNodesByUser(nodes, user)
-		for _, node := range nodes {
-			if node.Hostinfo == nil {
-				continue
-			}
+	for _, node := range nodes {
+		if node.Hostinfo == nil || !ownsTag(owners, node) {
+			continue
+		}
 
-			if util.StringOrPrefixListContains(node.Hostinfo.RequestTags, alias) {
-				node.AppendToIPSet(&build)
-			}
+		if util.StringOrPrefixListContains(node.Hostinfo.RequestTags, alias) {
+			node.AppendToIPSet(&build)
 		}
 	}
 
@@ -932,6 +1156,7 @@ func (pol *ACLPolicy) expandIPsFromUser(
 ) (*netipx.IPSet, error) {
 	var build netipx.IPSetBuilder
 
+	user = pol.resolveUser(user)
 	filteredNodes := filterNodesByUser(nodes, user)
 	filteredNodes = excludeCorrectlyTaggedNodes(pol, filteredNodes, user)
 
@@ -951,7 +1176,7 @@ func (pol *ACLPolicy) expandIPsFromSingleIP(
 	ip netip.Addr,
 	nodes types.Nodes,
 ) (*netipx.IPSet, error) {
-	log.Trace().Str("ip", ip.String()).Msg("ExpandAlias got ip")
+	policyLog.Trace().Str("ip", ip.String()).Msg("ExpandAlias got ip")
 
 	matches := nodes.FilterByIP(ip)
 
@@ -969,7 +1194,7 @@ func (pol *ACLPolicy) expandIPsFromIPPrefix(
 	prefix netip.Prefix,
 	nodes types.Nodes,
 ) (*netipx.IPSet, error) {
-	log.Trace().Str("prefix", prefix.String()).Msg("expandAlias got prefix")
+	policyLog.Trace().Str("prefix", prefix.String()).Msg("expandAlias got prefix")
 	var build netipx.IPSetBuilder
 	build.AddPrefix(prefix)
 
@@ -977,7 +1202,7 @@ func (pol *ACLPolicy) expandIPsFromIPPrefix(
 	// addresses for the hosts that belong to tailscale. This doesnt really affect stuff like subnet routers.
 	for _, node := range nodes {
 		for _, ip := range node.IPs() {
-			// log.Trace().
+			// policyLog.Trace().
 			// 	Msgf("checking if node ip (%s) is part of prefix (%s): %v, is single ip prefix (%v), addr: %s", ip.String(), prefix.String(), prefix.Contains(ip), prefix.IsSingleIP(), prefix.Addr().String())
 			if prefix.Contains(ip) {
 				node.AppendToIPSet(&build)
@@ -1057,6 +1282,10 @@ func isTag(str string) bool {
 	return strings.HasPrefix(str, "tag:")
 }
 
+func isService(str string) bool {
+	return strings.HasPrefix(str, "svc:")
+}
+
 func isAutoGroup(str string) bool {
 	return strings.HasPrefix(str, autogroupPrefix)
 }
@@ -1085,13 +1314,7 @@ func (pol *ACLPolicy) TagsOfNode(
 
 				continue
 			}
-			var found bool
-			for _, owner := range owners {
-				if node.User.Name == owner {
-					found = true
-				}
-			}
-			if found {
+			if ownsTag(owners, node) {
 				validTagMap[tag] = true
 			} else {
 				invalidTagMap[tag] = true
@@ -1108,6 +1331,331 @@ func (pol *ACLPolicy) TagsOfNode(
 	return validTags, invalidTags
 }
 
+// TagRejection is a tag requested by a node it may not set, with the
+// rule of the TagOwners that rejects it.
+type TagRejection struct {
+	Tag    string
+	Reason string
+}
+
+// RequestTagsError lists the tags a node requested with
+// --advertise-tags that it may not set.
+type RequestTagsError struct {
+	Rejections []TagRejection
+}
+
+func (e *RequestTagsError) Error() string {
+	tags := make([]string, len(e.Rejections))
+	reasons := make([]string, len(e.Rejections))
+	for index, rejection := range e.Rejections {
+		tags[index] = rejection.Tag
+		reasons[index] = rejection.Reason
+	}
+
+	return fmt.Sprintf(
+		"requested tags [%s] are invalid or not permitted: %s",
+		strings.Join(tags, " "),
+		strings.Join(reasons, "; "),
+	)
+}
+
+func (e *RequestTagsError) Unwrap() error {
+	return ErrInvalidTag
+}
+
+// CheckRequestTags returns a *RequestTagsError explaining which TagOwners
+// rule rejects each tag the node requested but may not set. The tags
+// forced on the node are not checked.
+func (pol *ACLPolicy) CheckRequestTags(node *types.Node) error {
+	if node == nil || node.Hostinfo == nil {
+		return nil
+	}
+
+	requested := slices.Clone(node.Hostinfo.RequestTags)
+	slices.Sort(requested)
+
+	var rejections []TagRejection
+	for _, tag := range slices.Compact(requested) {
+		if slices.Contains(node.ForcedTags, tag) {
+			continue
+		}
+
+		if pol == nil {
+			rejections = append(rejections, TagRejection{
+				Tag:    tag,
+				Reason: fmt.Sprintf("%s is not defined in tagOwners, there is no policy", tag),
+			})
+
+			continue
+		}
+
+		rule, ok := pol.TagOwners[tag]
+		if !ok {
+			rejections = append(rejections, TagRejection{
+				Tag:    tag,
+				Reason: fmt.Sprintf("%s is not defined in tagOwners", tag),
+			})
+
+			continue
+		}
+
+		owners, err := expandOwnersFromTag(pol, tag)
+		if err != nil {
+			rejections = append(rejections, TagRejection{
+				Tag:    tag,
+				Reason: fmt.Sprintf("tagOwners of %s: %s", tag, err),
+			})
+
+			continue
+		}
+
+		if ownsTag(owners, node) {
+			continue
+		}
+
+		requester := fmt.Sprintf("user %q", node.User.Name)
+		if node.Service != "" {
+			requester += fmt.Sprintf(" or service %q", "svc:"+node.Service)
+		}
+		rejections = append(rejections, TagRejection{
+			Tag: tag,
+			Reason: fmt.Sprintf(
+				"tagOwners of %s [%s] does not include %s",
+				tag,
+				strings.Join(rule, " "),
+				requester,
+			),
+		})
+	}
+
+	if len(rejections) == 0 {
+		return nil
+	}
+
+	return &RequestTagsError{Rejections: rejections}
+}
+
+// ValidateTagOwner returns an error if the tag is not defined in the
+// TagOwners of the policy, or if the user is not one of its owners.
+func (pol *ACLPolicy) ValidateTagOwner(tag string, user types.User) error {
+	owners, err := expandOwnersFromTag(pol, tag)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(owners, user.Name) {
+		return fmt.Errorf("%w: user %q does not own %s", ErrInvalidTag, user.Name, tag)
+	}
+
+	return nil
+}
+
+// ValidateNodeTagOwner returns an error if the tag is not defined in
+// the TagOwners of the policy, or if neither the user nor the service
+// account of the node is one of its owners.
+func (pol *ACLPolicy) ValidateNodeTagOwner(tag string, node *types.Node) error {
+	owners, err := expandOwnersFromTag(pol, tag)
+	if err != nil {
+		return err
+	}
+
+	if !ownsTag(owners, node) {
+		return fmt.Errorf("%w: node %q does not own %s", ErrInvalidTag, node.Hostname, tag)
+	}
+
+	return nil
+}
+
+// ownsTag reports if the user or the service account of the node is
+// one of the owners of a tag.
+func ownsTag(owners []string, node *types.Node) bool {
+	if slices.Contains(owners, node.User.Name) {
+		return true
+	}
+
+	return node.Service != "" && slices.Contains(owners, "svc:"+node.Service)
+}
+
+// MayAdvertiseExitRoutes reports if the node is allowed to advertise
+// exit routes by the exitNodeAdvertisers of the policy. Without a
+// policy, or if the list is empty, every node is allowed.
+func (pol *ACLPolicy) MayAdvertiseExitRoutes(node *types.Node) (bool, error) {
+	if pol == nil || len(pol.ExitNodeAdvertisers) == 0 {
+		return true, nil
+	}
+
+	for _, alias := range pol.ExitNodeAdvertisers {
+		if node.User.Name != "" && pol.resolveUser(alias) == node.User.Name {
+			return true, nil
+		}
+
+		ips, err := pol.ExpandAlias(types.Nodes{node}, alias)
+		if err != nil {
+			return false, fmt.Errorf("expanding alias %q for exitNodeAdvertisers: %w", alias, err)
+		}
+
+		for _, ip := range node.IPs() {
+			if ips.Contains(ip) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// DERPHomeRegion returns the DERP region the first matching derpHomes
+// rule of the policy pins the node to, 0 if none matches.
+func (pol *ACLPolicy) DERPHomeRegion(node *types.Node) (int, error) {
+	if pol == nil {
+		return 0, nil
+	}
+
+	for index, home := range pol.DERPHomes {
+		if home.Region <= 0 {
+			return 0, fmt.Errorf("parsing policy, derpHomes index: %d: region must be a DERP region ID", index)
+		}
+
+		matches, err := pol.matchesNode(node, home.Sources)
+		if err != nil {
+			return 0, fmt.Errorf("parsing policy, derpHomes index: %d: %w", index, err)
+		}
+		if matches {
+			return home.Region, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// DERPRegions returns the DERP regions the first matching
+// derpRestrictions rule of the policy allows the node to relay its
+// traffic through. restricted is false if no rule matches, the node
+// may then use every region.
+func (pol *ACLPolicy) DERPRegions(node *types.Node) (regions []int, restricted bool, err error) {
+	if pol == nil {
+		return nil, false, nil
+	}
+
+	for index, restriction := range pol.DERPRestrictions {
+		for _, region := range restriction.Regions {
+			if region <= 0 {
+				return nil, false, fmt.Errorf("parsing policy, derpRestrictions index: %d: regions must be DERP region IDs", index)
+			}
+		}
+
+		matches, err := pol.matchesNode(node, restriction.Sources)
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing policy, derpRestrictions index: %d: %w", index, err)
+		}
+		if matches {
+			return restriction.Regions, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// matchesNode reports if one of the aliases, users, groups, tags, hosts
+// or prefixes, matches the node.
+func (pol *ACLPolicy) matchesNode(node *types.Node, aliases []string) (bool, error) {
+	for _, alias := range aliases {
+		if node.User.Name != "" && pol.resolveUser(alias) == node.User.Name {
+			return true, nil
+		}
+
+		ips, err := pol.ExpandAlias(types.Nodes{node}, alias)
+		if err != nil {
+			return false, err
+		}
+
+		for _, ip := range node.IPs() {
+			if ips.Contains(ip) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// CanUseExitNodes reports if the filter rules allow the node to reach
+// any address on the Internet, which is what autogroup:internet grants.
+// Nodes that cannot reach the Internet are not offered exit nodes.
+func CanUseExitNodes(node *types.Node, rules []tailcfg.FilterRule) bool {
+	ips := node.IPs()
+
+	for _, rule := range rules {
+		match := matcher.MatchFromFilterRule(rule)
+		if match.SrcsContainsIPs(ips) && match.Dests.Overlaps(theInternet()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveUser returns the current name of a renamed user referenced by
+// its old name in the policy, other names are returned as is.
+func (pol *ACLPolicy) resolveUser(user string) string {
+	if pol == nil {
+		return user
+	}
+
+	alias, ok := pol.UserAliases[user]
+	if !ok || !alias.IsActive(time.Now()) {
+		return user
+	}
+
+	warned := false
+	if pol.WarnedUserAliases != nil {
+		_, warned = pol.WarnedUserAliases.LoadOrStore(user, true)
+	}
+
+	if !warned {
+		policyLog.Warn().
+			Str("old_name", user).
+			Str("user", alias.User.Name).
+			Time("expires_at", alias.ExpiresAt).
+			Msg("Policy references a renamed user by its old name, update the policy before the alias expires")
+	}
+
+	return alias.User.Name
+}
+
+// expandWildcard returns all addresses except those of the nodes of
+// suspended users.
+func expandWildcard(nodes types.Nodes) (*netipx.IPSet, error) {
+	all, err := util.ParseIPSet("*", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var build netipx.IPSetBuilder
+	build.AddSet(all)
+	for _, node := range nodes {
+		if node.User.IsSuspended() {
+			for _, ip := range node.IPs() {
+				build.Remove(ip)
+			}
+		}
+	}
+
+	return build.IPSet()
+}
+
+// excludeSuspendedNodes removes the nodes of suspended users.
+func excludeSuspendedNodes(nodes types.Nodes) types.Nodes {
+	out := make(types.Nodes, 0, len(nodes))
+	for _, node := range nodes {
+		if !node.User.IsSuspended() {
+			out = append(out, node)
+		}
+	}
+
+	return out
+}
+
 func filterNodesByUser(nodes types.Nodes, user string) types.Nodes {
 	var out types.Nodes
 	for _, node := range nodes {
@@ -3,7 +3,9 @@ package policy
 import (
 	"errors"
 	"net/netip"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/juanfont/headscale/hscontrol/types"
@@ -11,10 +13,12 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go4.org/netipx"
 	"gopkg.in/check.v1"
 	"gorm.io/gorm"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
 )
 
 var iap = func(ipStr string) *netip.Addr {
@@ -995,6 +999,37 @@ func Test_expandAlias(t *testing.T) {
 			}),
 			wantErr: false,
 		},
+		{
+			name: "wildcard with suspended user",
+			field: field{
+				pol: ACLPolicy{},
+			},
+			args: args{
+				alias: "*",
+				nodes: types.Nodes{
+					&types.Node{
+						IPv4: iap("100.64.0.1"),
+						User: types.User{Name: "joe"},
+					},
+					&types.Node{
+						IPv4: iap("100.64.0.3"),
+						User: types.User{
+							Name:        "marc",
+							SuspendedAt: ptr.To(time.Now()),
+						},
+					},
+				},
+			},
+			want: func() *netipx.IPSet {
+				var build netipx.IPSetBuilder
+				build.AddSet(set([]string{}, []string{"0.0.0.0/0", "::/0"}))
+				build.Remove(netip.MustParseAddr("100.64.0.3"))
+				ips, _ := build.IPSet()
+
+				return ips
+			}(),
+			wantErr: false,
+		},
 		{
 			name: "simple group",
 			field: field{
@@ -1028,6 +1063,101 @@ func Test_expandAlias(t *testing.T) {
 			}, []string{}),
 			wantErr: false,
 		},
+		{
+			name: "group with suspended user",
+			field: field{
+				pol: ACLPolicy{
+					Groups: Groups{"group:accountant": []string{"joe", "marc"}},
+				},
+			},
+			args: args{
+				alias: "group:accountant",
+				nodes: types.Nodes{
+					&types.Node{
+						IPv4: iap("100.64.0.1"),
+						User: types.User{Name: "joe"},
+					},
+					&types.Node{
+						IPv4: iap("100.64.0.3"),
+						User: types.User{
+							Name:        "marc",
+							SuspendedAt: ptr.To(time.Now()),
+						},
+					},
+				},
+			},
+			want: set([]string{
+				"100.64.0.1",
+			}, []string{}),
+			wantErr: false,
+		},
+		{
+			name: "renamed user by old name",
+			field: field{
+				pol: ACLPolicy{
+					UserAliases: map[string]types.UserAlias{
+						"joe": {
+							Name:      "joe",
+							User:      types.User{Name: "joseph"},
+							ExpiresAt: time.Now().Add(time.Hour),
+						},
+						"marc": {
+							Name:      "marc",
+							User:      types.User{Name: "marcus"},
+							ExpiresAt: time.Now().Add(-time.Hour),
+						},
+					},
+				},
+			},
+			args: args{
+				alias: "joe",
+				nodes: types.Nodes{
+					&types.Node{
+						IPv4:     iap("100.64.0.1"),
+						User:     types.User{Name: "joseph"},
+						Hostinfo: &tailcfg.Hostinfo{},
+					},
+					&types.Node{
+						IPv4:     iap("100.64.0.2"),
+						User:     types.User{Name: "marcus"},
+						Hostinfo: &tailcfg.Hostinfo{},
+					},
+				},
+			},
+			want: set([]string{
+				"100.64.0.1",
+			}, []string{}),
+			wantErr: false,
+		},
+		{
+			name: "group from directory",
+			field: field{
+				pol: ACLPolicy{
+					Groups: Groups{"group:accountant": []string{"joe"}},
+					DirectoryGroups: Groups{
+						"group:accountant":  []string{"marc"},
+						"group:engineering": []string{"marc"},
+					},
+				},
+			},
+			args: args{
+				alias: "group:engineering",
+				nodes: types.Nodes{
+					&types.Node{
+						IPv4: iap("100.64.0.1"),
+						User: types.User{Name: "joe"},
+					},
+					&types.Node{
+						IPv4: iap("100.64.0.2"),
+						User: types.User{Name: "marc"},
+					},
+				},
+			},
+			want: set([]string{
+				"100.64.0.2",
+			}, []string{}),
+			wantErr: false,
+		},
 		{
 			name: "wrong group",
 			field: field{
@@ -1381,6 +1511,60 @@ func Test_expandAlias(t *testing.T) {
 			want:    set([]string{"100.64.0.4"}, []string{}),
 			wantErr: false,
 		},
+		{
+			name: "service",
+			field: field{
+				pol: ACLPolicy{},
+			},
+			args: args{
+				alias: "svc:backup",
+				nodes: types.Nodes{
+					&types.Node{
+						IPv4:       iap("100.64.0.1"),
+						User:       types.User{Name: "joe"},
+						ForcedTags: []string{"tag:backup"},
+						Service:    "backup",
+					},
+					&types.Node{
+						IPv4:       iap("100.64.0.2"),
+						User:       types.User{Name: "joe"},
+						ForcedTags: []string{"tag:backup"},
+					},
+					&types.Node{
+						IPv4:    iap("100.64.0.3"),
+						User:    types.User{Name: "marc"},
+						Service: "monitoring",
+					},
+				},
+			},
+			want:    set([]string{"100.64.0.1"}, []string{}),
+			wantErr: false,
+		},
+		{
+			name: "user without the nodes of a service",
+			field: field{
+				pol: ACLPolicy{},
+			},
+			args: args{
+				alias: "joe",
+				nodes: types.Nodes{
+					&types.Node{
+						IPv4:       iap("100.64.0.1"),
+						User:       types.User{Name: "joe"},
+						ForcedTags: []string{"tag:backup"},
+						Service:    "backup",
+						Hostinfo:   &tailcfg.Hostinfo{},
+					},
+					&types.Node{
+						IPv4:     iap("100.64.0.2"),
+						User:     types.User{Name: "joe"},
+						Hostinfo: &tailcfg.Hostinfo{},
+					},
+				},
+			},
+			want:    set([]string{"100.64.0.2"}, []string{}),
+			wantErr: false,
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -1952,6 +2136,44 @@ func TestACLPolicy_generateFilterRules(t *testing.T) {
 	}
 }
 
+func TestCompileFilterRulesSourcePosture(t *testing.T) {
+	attested := &types.Node{
+		IPv4:        iap("100.64.0.1"),
+		User:        types.User{Name: "alice"},
+		Attestation: &types.NodeAttestation{Format: "tpm", Verified: true},
+	}
+	unverified := &types.Node{
+		IPv4:        iap("100.64.0.2"),
+		User:        types.User{Name: "alice"},
+		Attestation: &types.NodeAttestation{Format: "tpm"},
+	}
+	plain := &types.Node{
+		IPv4: iap("100.64.0.3"),
+		User: types.User{Name: "alice"},
+	}
+	nodes := types.Nodes{attested, unverified, plain}
+
+	pol := ACLPolicy{
+		ACLs: []ACL{
+			{
+				Action:        "accept",
+				Sources:       []string{"*"},
+				Destinations:  []string{"100.64.0.10:22"},
+				SourcePosture: []string{"node:attested"},
+			},
+		},
+	}
+
+	rules, err := pol.CompileFilterRules(nodes)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, []string{"100.64.0.1/32"}, rules[0].SrcIPs)
+
+	pol.ACLs[0].SourcePosture = []string{"node:os:linux"}
+	_, err = pol.CompileFilterRules(nodes)
+	assert.ErrorIs(t, err, ErrUnknownPosture)
+}
+
 // tsExitNodeDest is the list of destination IP ranges that are allowed when
 // you dump the filter list from a Tailscale node connected to Tailscale SaaS.
 var tsExitNodeDest = []tailcfg.NetPortRange{
@@ -3680,6 +3902,90 @@ func TestSSHRules(t *testing.T) {
 	}
 }
 
+func TestSSHRuleMessage(t *testing.T) {
+	node := types.Node{
+		Hostname: "db",
+		IPv4:     iap("100.64.0.2"),
+		User:     types.User{Name: "alice"},
+	}
+	peers := types.Nodes{
+		&types.Node{Hostname: "laptop", IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}},
+		&types.Node{Hostname: "kiosk", IPv4: iap("100.64.0.3"), User: types.User{Name: "bob"}},
+	}
+	pol := ACLPolicy{
+		SSHs: []SSH{
+			{
+				Action:       "reject",
+				Sources:      []string{"bob"},
+				Destinations: []string{"100.64.0.2"},
+				Users:        []string{"root"},
+				Message:      "Kiosks may not log into databases.",
+			},
+			{
+				Action:       "check",
+				Sources:      []string{"alice"},
+				Destinations: []string{"100.64.0.2"},
+				Users:        []string{"root"},
+				CheckPeriod:  "12h",
+				Message:      "Root sessions are recorded, see https://wiki.example.com/ssh\n",
+			},
+		},
+	}
+
+	got, err := pol.CompileSSHPolicy(&node, peers)
+	require.NoError(t, err)
+	require.Len(t, got.Rules, 2)
+
+	assert.True(t, got.Rules[0].Action.Reject)
+	assert.Equal(t, "Kiosks may not log into databases.\n", got.Rules[0].Action.Message)
+	assert.True(t, got.Rules[1].Action.Accept)
+	assert.Equal(t, 12*time.Hour, got.Rules[1].Action.SessionDuration)
+	assert.Equal(t, "Root sessions are recorded, see https://wiki.example.com/ssh\n", got.Rules[1].Action.Message)
+}
+
+func TestSSHRejectMessage(t *testing.T) {
+	node := types.Node{
+		Hostname: "db",
+		IPv4:     iap("100.64.0.2"),
+		User:     types.User{Name: "alice"},
+	}
+	peers := types.Nodes{
+		&types.Node{Hostname: "laptop", IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}},
+	}
+	pol := ACLPolicy{
+		Hosts: Hosts{"db": netip.MustParsePrefix("100.64.0.2/32")},
+		SSHs: []SSH{
+			{
+				Action:       "accept",
+				Sources:      []string{"alice"},
+				Destinations: []string{"db"},
+				Users:        []string{"postgres"},
+				Description:  "Database access, ask #dba",
+			},
+			{
+				Action:       "accept",
+				Sources:      []string{"alice"},
+				Destinations: []string{"100.64.0.9"},
+				Users:        []string{"root"},
+				Description:  "Not this node",
+			},
+		},
+	}
+
+	got, err := pol.CompileSSHPolicy(&node, peers)
+	require.NoError(t, err)
+	require.Len(t, got.Rules, 2)
+
+	reject := got.Rules[1]
+	assert.Equal(t, []*tailcfg.SSHPrincipal{{Any: true}}, reject.Principals)
+	assert.True(t, reject.Action.Reject)
+	assert.Equal(
+		t,
+		"Tailscale SSH access to this node was denied by the policy.\n  Database access, ask #dba\n",
+		reject.Action.Message,
+	)
+}
+
 func TestParseDestination(t *testing.T) {
 	tests := []struct {
 		dest      string
@@ -3974,3 +4280,518 @@ func TestValidTagInvalidUser(t *testing.T) {
 		t.Errorf("TestValidTagInvalidUser() unexpected result (-want +got):\n%s", diff)
 	}
 }
+
+func TestValidateTagOwner(t *testing.T) {
+	pol := &ACLPolicy{
+		Groups: Groups{"group:ops": {"alice"}},
+		TagOwners: TagOwners{
+			"tag:router": {"group:ops"},
+			"tag:server": {"bob"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		tag     string
+		user    string
+		wantErr bool
+	}{
+		{name: "owner-through-group", tag: "tag:router", user: "alice"},
+		{name: "owner", tag: "tag:server", user: "bob"},
+		{name: "not-an-owner", tag: "tag:server", user: "alice", wantErr: true},
+		{name: "undefined-tag", tag: "tag:unknown", user: "alice", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := pol.ValidateTagOwner(tt.tag, types.User{Name: tt.user})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTagOwner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil && !errors.Is(err, ErrInvalidTag) {
+				t.Errorf("ValidateTagOwner() error = %v, want %v", err, ErrInvalidTag)
+			}
+		})
+	}
+}
+
+func TestServiceAccountTagOwner(t *testing.T) {
+	pol := &ACLPolicy{
+		TagOwners: TagOwners{
+			"tag:backup": {"svc:backup"},
+		},
+	}
+
+	backup := &types.Node{
+		IPv4:     iap("100.64.0.1"),
+		Hostname: "backup-1",
+		User:     types.User{Name: "admin"},
+		Service:  "backup",
+		Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:backup"}},
+	}
+	other := &types.Node{
+		IPv4:     iap("100.64.0.2"),
+		Hostname: "laptop",
+		User:     types.User{Name: "admin"},
+		Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:backup"}},
+	}
+
+	if err := pol.ValidateNodeTagOwner("tag:backup", backup); err != nil {
+		t.Errorf("ValidateNodeTagOwner() of the service node error = %s", err)
+	}
+	if err := pol.ValidateNodeTagOwner("tag:backup", other); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("ValidateNodeTagOwner() of another node error = %v, want %v", err, ErrInvalidTag)
+	}
+
+	valid, invalid := pol.TagsOfNode(backup)
+	if !cmp.Equal(valid, []string{"tag:backup"}) || len(invalid) != 0 {
+		t.Errorf("TagsOfNode() of the service node = %v, %v, want tag:backup valid", valid, invalid)
+	}
+
+	ips, err := pol.ExpandAlias(types.Nodes{backup, other}, "tag:backup")
+	if err != nil {
+		t.Fatalf("ExpandAlias() error = %s", err)
+	}
+	if !ips.Contains(netip.MustParseAddr("100.64.0.1")) || ips.Contains(netip.MustParseAddr("100.64.0.2")) {
+		t.Errorf("ExpandAlias(tag:backup) = %v, want only the service node", ips.Prefixes())
+	}
+}
+
+func TestCheckRequestTags(t *testing.T) {
+	pol := &ACLPolicy{
+		Groups: Groups{"group:ops": {"alice"}},
+		TagOwners: TagOwners{
+			"tag:router": {"group:ops"},
+			"tag:server": {"bob", "svc:backup"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		pol     *ACLPolicy
+		node    *types.Node
+		wantErr string
+	}{
+		{
+			name: "owned-tags",
+			pol:  pol,
+			node: &types.Node{
+				User:     types.User{Name: "alice"},
+				Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:router", "tag:router"}},
+			},
+		},
+		{
+			name: "forced-tag",
+			pol:  pol,
+			node: &types.Node{
+				User:       types.User{Name: "alice"},
+				ForcedTags: []string{"tag:server"},
+				Hostinfo:   &tailcfg.Hostinfo{RequestTags: []string{"tag:server"}},
+			},
+		},
+		{
+			name: "not-an-owner",
+			pol:  pol,
+			node: &types.Node{
+				User:     types.User{Name: "alice"},
+				Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:server", "tag:router"}},
+			},
+			wantErr: `requested tags [tag:server] are invalid or not permitted: tagOwners of tag:server [bob svc:backup] does not include user "alice"`,
+		},
+		{
+			name: "service-not-an-owner",
+			pol:  pol,
+			node: &types.Node{
+				User:     types.User{Name: "alice"},
+				Service:  "ci",
+				Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:server"}},
+			},
+			wantErr: `requested tags [tag:server] are invalid or not permitted: tagOwners of tag:server [bob svc:backup] does not include user "alice" or service "svc:ci"`,
+		},
+		{
+			name: "undefined-tags",
+			pol:  pol,
+			node: &types.Node{
+				User:     types.User{Name: "bob"},
+				Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:web", "tag:db"}},
+			},
+			wantErr: `requested tags [tag:db tag:web] are invalid or not permitted: tag:db is not defined in tagOwners; tag:web is not defined in tagOwners`,
+		},
+		{
+			name: "no-policy",
+			node: &types.Node{
+				User:     types.User{Name: "bob"},
+				Hostinfo: &tailcfg.Hostinfo{RequestTags: []string{"tag:web"}},
+			},
+			wantErr: `requested tags [tag:web] are invalid or not permitted: tag:web is not defined in tagOwners, there is no policy`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pol.CheckRequestTags(tt.node)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckRequestTags() error = %s", err)
+				}
+
+				return
+			}
+
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("CheckRequestTags() error = %v, want %s", err, tt.wantErr)
+			}
+
+			var tagsErr *RequestTagsError
+			if !errors.As(err, &tagsErr) || !errors.Is(err, ErrInvalidTag) {
+				t.Errorf("CheckRequestTags() error = %#v, want a *RequestTagsError wrapping %v", err, ErrInvalidTag)
+			}
+		})
+	}
+}
+
+func TestMayAdvertiseExitRoutes(t *testing.T) {
+	pol := &ACLPolicy{
+		Groups:              Groups{"group:ops": {"alice"}},
+		TagOwners:           TagOwners{"tag:exit": {"bob"}},
+		ExitNodeAdvertisers: []string{"group:ops", "tag:exit"},
+	}
+
+	tests := []struct {
+		name string
+		pol  *ACLPolicy
+		node *types.Node
+		want bool
+	}{
+		{
+			name: "no-policy",
+			node: &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "carol"}, Hostinfo: &tailcfg.Hostinfo{}},
+			want: true,
+		},
+		{
+			name: "no-advertisers",
+			pol:  &ACLPolicy{},
+			node: &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "carol"}, Hostinfo: &tailcfg.Hostinfo{}},
+			want: true,
+		},
+		{
+			name: "in-group",
+			pol:  pol,
+			node: &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}},
+			want: true,
+		},
+		{
+			name: "tagged",
+			pol:  pol,
+			node: &types.Node{
+				IPv4:       iap("100.64.0.2"),
+				User:       types.User{Name: "bob"},
+				ForcedTags: []string{"tag:exit"},
+			},
+			want: true,
+		},
+		{
+			name: "not-allowed",
+			pol:  pol,
+			node: &types.Node{IPv4: iap("100.64.0.3"), User: types.User{Name: "carol"}, Hostinfo: &tailcfg.Hostinfo{}},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.pol.MayAdvertiseExitRoutes(tt.node)
+			if err != nil {
+				t.Fatalf("MayAdvertiseExitRoutes() error = %s", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("MayAdvertiseExitRoutes() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDERPHomeRegion(t *testing.T) {
+	pol := &ACLPolicy{
+		Groups:    Groups{"group:ops": {"alice"}},
+		TagOwners: TagOwners{"tag:ci": {"bob"}},
+		DERPHomes: []DERPHome{
+			{Sources: []string{"tag:ci"}, Region: 900},
+			{Sources: []string{"group:ops", "carol"}, Region: 901},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		pol     *ACLPolicy
+		node    *types.Node
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "no-policy",
+			node: &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}},
+		},
+		{
+			name: "tagged",
+			pol:  pol,
+			node: &types.Node{
+				IPv4:       iap("100.64.0.2"),
+				User:       types.User{Name: "bob"},
+				ForcedTags: []string{"tag:ci"},
+			},
+			want: 900,
+		},
+		{
+			name: "in-group",
+			pol:  pol,
+			node: &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}},
+			want: 901,
+		},
+		{
+			name: "user",
+			pol:  pol,
+			node: &types.Node{IPv4: iap("100.64.0.3"), User: types.User{Name: "carol"}, Hostinfo: &tailcfg.Hostinfo{}},
+			want: 901,
+		},
+		{
+			name: "not-pinned",
+			pol:  pol,
+			node: &types.Node{IPv4: iap("100.64.0.4"), User: types.User{Name: "dave"}, Hostinfo: &tailcfg.Hostinfo{}},
+		},
+		{
+			name:    "invalid-region",
+			pol:     &ACLPolicy{DERPHomes: []DERPHome{{Sources: []string{"dave"}}}},
+			node:    &types.Node{IPv4: iap("100.64.0.4"), User: types.User{Name: "dave"}, Hostinfo: &tailcfg.Hostinfo{}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.pol.DERPHomeRegion(tt.node)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DERPHomeRegion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("DERPHomeRegion() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDERPRegions(t *testing.T) {
+	pol := &ACLPolicy{
+		TagOwners: TagOwners{"tag:eu": {"alice"}, "tag:direct": {"alice"}},
+		DERPRestrictions: []DERPRestriction{
+			{Sources: []string{"tag:eu"}, Regions: []int{900, 901}},
+			{Sources: []string{"tag:direct"}},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		pol            *ACLPolicy
+		node           *types.Node
+		want           []int
+		wantRestricted bool
+		wantErr        bool
+	}{
+		{
+			name: "no-policy",
+			node: &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}},
+		},
+		{
+			name: "allowed-regions",
+			pol:  pol,
+			node: &types.Node{
+				IPv4:       iap("100.64.0.1"),
+				User:       types.User{Name: "alice"},
+				ForcedTags: []string{"tag:eu"},
+			},
+			want:           []int{900, 901},
+			wantRestricted: true,
+		},
+		{
+			name: "no-relay",
+			pol:  pol,
+			node: &types.Node{
+				IPv4:       iap("100.64.0.2"),
+				User:       types.User{Name: "alice"},
+				ForcedTags: []string{"tag:direct"},
+			},
+			wantRestricted: true,
+		},
+		{
+			name: "not-restricted",
+			pol:  pol,
+			node: &types.Node{IPv4: iap("100.64.0.3"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}},
+		},
+		{
+			name: "invalid-region",
+			pol: &ACLPolicy{
+				DERPRestrictions: []DERPRestriction{{Sources: []string{"bob"}, Regions: []int{0}}},
+			},
+			node:    &types.Node{IPv4: iap("100.64.0.3"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, restricted, err := tt.pol.DERPRegions(tt.node)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DERPRegions() error = %v, wantErr %t", err, tt.wantErr)
+			}
+
+			if restricted != tt.wantRestricted {
+				t.Errorf("DERPRegions() restricted = %t, want %t", restricted, tt.wantRestricted)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("DERPRegions() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCanUseExitNodes(t *testing.T) {
+	alice := &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}}
+	bob := &types.Node{IPv4: iap("100.64.0.2"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}}
+	nodes := types.Nodes{alice, bob}
+
+	tests := []struct {
+		name string
+		pol  *ACLPolicy
+		node *types.Node
+		want bool
+	}{
+		{
+			name: "no-policy",
+			node: alice,
+			want: true,
+		},
+		{
+			name: "internet-allowed",
+			pol: &ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"autogroup:internet:*"}},
+			}},
+			node: alice,
+			want: true,
+		},
+		{
+			name: "internet-not-allowed",
+			pol: &ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"autogroup:internet:*"}},
+				{Action: "accept", Sources: []string{"bob"}, Destinations: []string{"alice:*"}},
+			}},
+			node: bob,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := tt.pol.CompileFilterRules(nodes)
+			if err != nil {
+				t.Fatalf("CompileFilterRules() error = %s", err)
+			}
+
+			if got := CanUseExitNodes(tt.node, rules); got != tt.want {
+				t.Errorf("CanUseExitNodes() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerRelays(t *testing.T) {
+	alice := &types.Node{ID: 1, IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}}
+	bob := &types.Node{ID: 2, IPv4: iap("100.64.0.2"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}}
+	relay := &types.Node{
+		ID:         3,
+		IPv4:       iap("100.64.0.3"),
+		User:       types.User{Name: "admin"},
+		ForcedTags: []string{"tag:relay"},
+	}
+	nodes := types.Nodes{alice, bob, relay}
+
+	pol := &ACLPolicy{
+		TagOwners: TagOwners{"tag:relay": {"admin"}},
+		PeerRelays: []PeerRelay{
+			{Sources: []string{"alice"}, Destinations: []string{"tag:relay"}},
+		},
+	}
+
+	rules, err := pol.CompileFilterRules(nodes)
+	if err != nil {
+		t.Fatalf("CompileFilterRules() error = %s", err)
+	}
+
+	want := []tailcfg.FilterRule{
+		{
+			SrcIPs: []string{"100.64.0.1/32"},
+			CapGrant: []tailcfg.CapGrant{{
+				Dsts:   []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")},
+				CapMap: tailcfg.PeerCapMap{PeerCapabilityRelay: nil},
+			}},
+		},
+		{
+			SrcIPs: []string{"100.64.0.3/32"},
+			CapGrant: []tailcfg.CapGrant{{
+				Dsts:   []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+				CapMap: tailcfg.PeerCapMap{PeerCapabilityRelayTarget: nil},
+			}},
+		},
+	}
+	if diff := cmp.Diff(want, rules, util.Comparers...); diff != "" {
+		t.Errorf("CompileFilterRules() mismatch (-want +got):\n%s", diff)
+	}
+
+	// The relay gets the grant of the nodes using it, the nodes the
+	// grant telling them about the relay.
+	if diff := cmp.Diff(want[:1], ReduceFilterRules(relay, rules), util.Comparers...); diff != "" {
+		t.Errorf("ReduceFilterRules(relay) mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(want[1:], ReduceFilterRules(alice, rules), util.Comparers...); diff != "" {
+		t.Errorf("ReduceFilterRules(alice) mismatch (-want +got):\n%s", diff)
+	}
+	if got := ReduceFilterRules(bob, rules); len(got) != 0 {
+		t.Errorf("ReduceFilterRules(bob) = %v, want none", got)
+	}
+
+	// The relay and the nodes using it are peers, but the grants do not
+	// allow any traffic.
+	if got := FilterNodesByACL(alice, nodes, rules); len(got) != 1 || got[0].ID != relay.ID {
+		t.Errorf("FilterNodesByACL(alice) = %v, want the relay", got)
+	}
+	if got := FilterNodesByACL(bob, nodes, rules); len(got) != 0 {
+		t.Errorf("FilterNodesByACL(bob) = %v, want no peers", got)
+	}
+}
+
+func TestResolveUserWarnedAliases(t *testing.T) {
+	aliases := map[string]types.UserAlias{
+		"joe": {
+			Name:      "joe",
+			User:      types.User{Name: "joseph"},
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+	}
+
+	var warned sync.Map
+	pol := &ACLPolicy{UserAliases: aliases, WarnedUserAliases: &warned}
+	if got := pol.resolveUser("joe"); got != "joseph" {
+		t.Errorf("resolveUser() = %q, want %q", got, "joseph")
+	}
+	if _, ok := warned.Load("joe"); !ok {
+		t.Errorf("resolveUser() did not record the warning of the policy")
+	}
+
+	// Without a set of warnings, every reference is warned about.
+	pol.WarnedUserAliases = nil
+	if got := pol.resolveUser("joe"); got != "joseph" {
+		t.Errorf("resolveUser() without warnings = %q, want %q", got, "joseph")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"net/netip"
 	"strings"
+	"sync"
 
+	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/tailscale/hujson"
 )
 
@@ -17,6 +19,40 @@ type ACLPolicy struct {
 	Tests         []ACLTest     `json:"tests"`
 	AutoApprovers AutoApprovers `json:"autoApprovers"`
 	SSHs          []SSH         `json:"ssh"`
+
+	// ExitNodeAdvertisers lists the users, groups and tags allowed to
+	// advertise exit routes. If empty, every node can advertise them.
+	ExitNodeAdvertisers []string `json:"exitNodeAdvertisers"`
+
+	// PeerRelays lets nodes that cannot connect to each other directly
+	// relay their traffic through other nodes running a peer relay.
+	PeerRelays []PeerRelay `json:"peerRelays"`
+
+	// DERPHomes pins nodes to a DERP region as their home.
+	DERPHomes []DERPHome `json:"derpHomes"`
+
+	// DERPRestrictions limits the DERP regions nodes may relay their
+	// traffic through.
+	DERPRestrictions []DERPRestriction `json:"derpRestrictions"`
+
+	// UserAliases maps the old names of renamed users to their alias.
+	// It is not part of the policy file, headscale fills it in from
+	// the database.
+	UserAliases map[string]types.UserAlias `json:"-"`
+
+	// WarnedUserAliases holds the old names a warning about the policy
+	// referencing them has been logged for, to only warn once per name.
+	// Headscale shares it between the versions of its policy. If nil,
+	// every reference is warned about.
+	WarnedUserAliases *sync.Map `json:"-"`
+
+	// DirectoryGroups are groups resolved from a directory like LDAP.
+	// They are used for groups that are not defined in Groups.
+	DirectoryGroups Groups `json:"-"`
+
+	// fieldDeprecations are the unknown and miscased fields of the
+	// policy data it was loaded from.
+	fieldDeprecations []Deprecation `json:"-"`
 }
 
 // ACL is a basic rule for the ACL Policy.
@@ -25,6 +61,14 @@ type ACL struct {
 	Protocol     string   `json:"proto"`
 	Sources      []string `json:"src"`
 	Destinations []string `json:"dst"`
+
+	// SourcePosture restricts the sources to the nodes satisfying all
+	// the postures, like node:attested.
+	SourcePosture []string `json:"srcPosture,omitempty"`
+
+	// Description explains the rule, like who owns it, in the output
+	// about the rule.
+	Description string `json:"description,omitempty"`
 }
 
 // Groups references a series of alias in the ACL rules.
@@ -57,6 +101,45 @@ type SSH struct {
 	Destinations []string `json:"dst"`
 	Users        []string `json:"users"`
 	CheckPeriod  string   `json:"checkPeriod,omitempty"`
+
+	// Description explains the rule, like who owns it. It is shown to
+	// the users the SSH rules of a node reject.
+	Description string `json:"description,omitempty"`
+
+	// Message is shown to the user before the session the rule accepts
+	// or checks starts, or when the rule rejects it.
+	Message string `json:"message,omitempty"`
+}
+
+// PeerRelay lets the nodes of Sources use the nodes of Destinations as
+// peer relays.
+type PeerRelay struct {
+	Sources      []string `json:"src"`
+	Destinations []string `json:"dst"`
+
+	// Description explains the rule, like who owns it.
+	Description string `json:"description,omitempty"`
+}
+
+// DERPHome pins the nodes of Sources to the DERP region Region as their
+// home, the other regions of their DERP map are marked to be avoided.
+type DERPHome struct {
+	Sources []string `json:"src"`
+	Region  int      `json:"region"`
+
+	// Description explains the rule, like who owns it.
+	Description string `json:"description,omitempty"`
+}
+
+// DERPRestriction limits the nodes of Sources to relaying their traffic
+// through the DERP regions of Regions. Without regions the nodes may
+// not relay at all, they only connect directly to their peers.
+type DERPRestriction struct {
+	Sources []string `json:"src"`
+	Regions []int    `json:"regions"`
+
+	// Description explains the rule, like who owns it.
+	Description string `json:"description,omitempty"`
 }
 
 // UnmarshalJSON allows to parse the Hosts directly into netip objects.
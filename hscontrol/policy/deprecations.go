@@ -0,0 +1,255 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+var ErrStrictPolicy = errors.New("rejected by policy.strict")
+
+// DeprecationKind is a form of the policy format that is accepted for
+// now, but will be rejected by a future version of the format.
+type DeprecationKind string
+
+const (
+	// DeprecationUnknownField is a field headscale does not know, it is
+	// ignored.
+	DeprecationUnknownField DeprecationKind = "unknown-field"
+
+	// DeprecationFieldCase is a field spelled with a different case
+	// than its name, like "Hosts" for "hosts".
+	DeprecationFieldCase DeprecationKind = "field-case"
+
+	// DeprecationUserAlias is a user referenced by the old name of a
+	// renamed user, it stops working when the alias expires.
+	DeprecationUserAlias DeprecationKind = "user-alias"
+)
+
+// Deprecation is a deprecated form used at Path in the policy.
+type Deprecation struct {
+	Kind    DeprecationKind
+	Path    string
+	Message string
+}
+
+func (d Deprecation) String() string {
+	return d.Path + ": " + d.Message
+}
+
+// fieldDeprecations returns the unknown and miscased fields of the
+// standardized policy data, which encoding/json silently ignores or
+// accepts.
+func fieldDeprecations(data []byte) []Deprecation {
+	var deprecations []Deprecation
+	walkFields(&deprecations, "", reflect.TypeOf(ACLPolicy{}), data)
+
+	return deprecations
+}
+
+func walkFields(deprecations *[]Deprecation, path string, typ reflect.Type, data json.RawMessage) {
+	switch typ.Kind() {
+	case reflect.Pointer:
+		walkFields(deprecations, path, typ.Elem(), data)
+
+	case reflect.Slice, reflect.Array:
+		var items []json.RawMessage
+		if json.Unmarshal(data, &items) != nil {
+			return
+		}
+		for index, item := range items {
+			walkFields(deprecations, fmt.Sprintf("%s[%d]", path, index), typ.Elem(), item)
+		}
+
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return
+		}
+		keys := maps.Keys(values)
+		slices.Sort(keys)
+		for _, key := range keys {
+			walkFields(deprecations, joinPath(path, key), typ.Elem(), values[key])
+		}
+
+	case reflect.Struct:
+		var values map[string]json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return
+		}
+
+		fields := map[string]reflect.Type{}
+		for i := range typ.NumField() {
+			field := typ.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field.Type
+		}
+
+		keys := maps.Keys(values)
+		slices.Sort(keys)
+		for _, key := range keys {
+			if fieldType, ok := fields[key]; ok {
+				walkFields(deprecations, joinPath(path, key), fieldType, values[key])
+
+				continue
+			}
+
+			name, ok := foldedField(fields, key)
+			if !ok {
+				*deprecations = append(*deprecations, Deprecation{
+					Kind:    DeprecationUnknownField,
+					Path:    joinPath(path, key),
+					Message: fmt.Sprintf("unknown field %q is ignored", key),
+				})
+
+				continue
+			}
+
+			*deprecations = append(*deprecations, Deprecation{
+				Kind:    DeprecationFieldCase,
+				Path:    joinPath(path, key),
+				Message: fmt.Sprintf("field %q is spelled %q", key, name),
+			})
+			walkFields(deprecations, joinPath(path, key), fields[name], values[key])
+		}
+	}
+}
+
+// foldedField returns the name of the field matching key case
+// insensitively, like encoding/json does.
+func foldedField(fields map[string]reflect.Type, key string) (string, bool) {
+	for name := range fields {
+		if strings.EqualFold(name, key) {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// userAliasDeprecations returns the references of the policy to renamed
+// users by their old name.
+func (pol *ACLPolicy) userAliasDeprecations() []Deprecation {
+	if len(pol.UserAliases) == 0 {
+		return nil
+	}
+
+	var deprecations []Deprecation
+	check := func(path string, aliases ...string) {
+		for _, alias := range aliases {
+			userAlias, ok := pol.UserAliases[alias]
+			if !ok {
+				continue
+			}
+
+			deprecations = append(deprecations, Deprecation{
+				Kind:    DeprecationUserAlias,
+				Path:    path,
+				Message: fmt.Sprintf("%q is the old name of the renamed user %q", alias, userAlias.User.Name),
+			})
+		}
+	}
+	checkDestinations := func(path string, destinations []string) {
+		for _, dst := range destinations {
+			if host, _, err := parseDestination(dst); err == nil {
+				check(path, host)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(pol.Groups) {
+		check(joinPath("groups", name), pol.Groups[name]...)
+	}
+	for _, tag := range sortedKeys(pol.TagOwners) {
+		check(joinPath("tagOwners", tag), pol.TagOwners[tag]...)
+	}
+	for index, acl := range pol.ACLs {
+		check(fmt.Sprintf("acls[%d].src", index), acl.Sources...)
+		checkDestinations(fmt.Sprintf("acls[%d].dst", index), acl.Destinations)
+	}
+	for index, test := range pol.Tests {
+		check(fmt.Sprintf("tests[%d].src", index), test.Source)
+		checkDestinations(fmt.Sprintf("tests[%d].accept", index), test.Accept)
+		checkDestinations(fmt.Sprintf("tests[%d].deny", index), test.Deny)
+	}
+	for _, prefix := range sortedKeys(pol.AutoApprovers.Routes) {
+		check(joinPath("autoApprovers.routes", prefix), pol.AutoApprovers.Routes[prefix]...)
+	}
+	check("autoApprovers.exitNode", pol.AutoApprovers.ExitNode...)
+	for index, ssh := range pol.SSHs {
+		check(fmt.Sprintf("ssh[%d].src", index), ssh.Sources...)
+		check(fmt.Sprintf("ssh[%d].dst", index), ssh.Destinations...)
+	}
+	check("exitNodeAdvertisers", pol.ExitNodeAdvertisers...)
+	for index, relay := range pol.PeerRelays {
+		check(fmt.Sprintf("peerRelays[%d].src", index), relay.Sources...)
+		check(fmt.Sprintf("peerRelays[%d].dst", index), relay.Destinations...)
+	}
+	for index, home := range pol.DERPHomes {
+		check(fmt.Sprintf("derpHomes[%d].src", index), home.Sources...)
+	}
+	for index, restriction := range pol.DERPRestrictions {
+		check(fmt.Sprintf("derpRestrictions[%d].src", index), restriction.Sources...)
+	}
+
+	return deprecations
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+
+	return keys
+}
+
+// Deprecations returns the deprecated forms the policy uses. The user
+// aliases of the policy have to be filled in to find references to
+// renamed users.
+func (pol *ACLPolicy) Deprecations() []Deprecation {
+	if pol == nil {
+		return nil
+	}
+
+	return append(slices.Clone(pol.fieldDeprecations), pol.userAliasDeprecations()...)
+}
+
+// CheckDeprecations logs a warning for each deprecated form the policy
+// uses. With strict set it returns an error naming the first of them
+// instead, so a policy keeps loading after the forms are removed from
+// the format.
+func (pol *ACLPolicy) CheckDeprecations(strict bool) error {
+	deprecations := pol.Deprecations()
+
+	if strict && len(deprecations) > 0 {
+		return fmt.Errorf("%s: %w", deprecations[0], ErrStrictPolicy)
+	}
+
+	for _, deprecation := range deprecations {
+		policyLog.Warn().
+			Str("kind", string(deprecation.Kind)).
+			Str("path", deprecation.Path).
+			Str("deprecation", deprecation.Message).
+			Msg("Policy uses a deprecated form, it is rejected with policy.strict")
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeprecations(t *testing.T) {
+	aliases := map[string]types.UserAlias{
+		"bob": {
+			Name:      "bob",
+			User:      types.User{Name: "robert"},
+			ExpiresAt: time.Now().Add(time.Hour),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		aliases map[string]types.UserAlias
+		want    []string
+	}{
+		{
+			name: "current-format",
+			policy: `{
+				"groups": {"group:admin": ["alice"]},
+				"acls": [{"action": "accept", "src": ["group:admin"], "dst": ["*:*"]}],
+				"ssh": [{"action": "check", "src": ["alice"], "dst": ["alice"], "users": ["root"], "checkPeriod": "1h"}],
+			}`,
+			aliases: aliases,
+		},
+		{
+			name: "unknown-fields",
+			policy: `{
+				"hosts": {"db": "100.64.0.10"},
+				"acls": [{"action": "accept", "src": ["*"], "dst": ["db:5432"], "ports": [5432]}],
+				"randomizeClientPort": true,
+			}`,
+			want: []string{
+				`acls[0].ports: unknown field "ports" is ignored`,
+				`randomizeClientPort: unknown field "randomizeClientPort" is ignored`,
+			},
+		},
+		{
+			name: "field-case",
+			policy: `{
+				"Hosts": {"db": "100.64.0.10"},
+				"ACLs": [{"Action": "accept", "src": ["*"], "dst": ["db:5432"]}],
+			}`,
+			want: []string{
+				`ACLs: field "ACLs" is spelled "acls"`,
+				`ACLs[0].Action: field "Action" is spelled "action"`,
+				`Hosts: field "Hosts" is spelled "hosts"`,
+			},
+		},
+		{
+			name: "user-alias",
+			policy: `{
+				"groups": {"group:admin": ["alice", "bob"]},
+				"acls": [{"action": "accept", "src": ["bob"], "dst": ["bob:22", "alice:22"]}],
+				"autoApprovers": {"exitNode": ["bob"]},
+			}`,
+			aliases: aliases,
+			want: []string{
+				`groups.group:admin: "bob" is the old name of the renamed user "robert"`,
+				`acls[0].src: "bob" is the old name of the renamed user "robert"`,
+				`acls[0].dst: "bob" is the old name of the renamed user "robert"`,
+				`autoApprovers.exitNode: "bob" is the old name of the renamed user "robert"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pol, err := LoadACLPolicyFromBytes([]byte(tt.policy))
+			require.NoError(t, err)
+			pol.UserAliases = tt.aliases
+
+			var got []string
+			for _, deprecation := range pol.Deprecations() {
+				got = append(got, deprecation.String())
+			}
+			assert.Equal(t, tt.want, got)
+
+			require.NoError(t, pol.CheckDeprecations(false))
+
+			err = pol.CheckDeprecations(true)
+			if len(tt.want) == 0 {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.ErrorIs(t, err, ErrStrictPolicy)
+			assert.EqualError(t, err, tt.want[0]+": rejected by policy.strict")
+		})
+	}
+}
@@ -0,0 +1,280 @@
+package policy
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/juanfont/headscale/hscontrol/policy/matcher"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	PairAdded   = "added"
+	PairRemoved = "removed"
+	PairChanged = "changed"
+)
+
+// PairDiff is how a change of the policy changes what a node can reach
+// on another node.
+type PairDiff struct {
+	Source      *types.Node
+	Destination *types.Node
+
+	// Change is PairAdded if the source could not reach the destination
+	// before, PairRemoved if it cannot anymore and PairChanged if only
+	// the ports changed.
+	Change string
+
+	// Added and Removed are the ports the source can newly, or can no
+	// longer, reach, like "22", "80-443" or "*". Ports of rules limited
+	// to protocols are prefixed with the protocol, like "udp:53".
+	Added   []string
+	Removed []string
+}
+
+// Diff compiles both policies for the nodes and returns, for every pair
+// of different nodes, how what the source can reach on the destination
+// changed from the old to the new policy. Pairs without changes are left
+// out.
+func Diff(oldPol, newPol *ACLPolicy, nodes types.Nodes) ([]PairDiff, error) {
+	oldFilter, err := oldPol.CompileFilterRules(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("compiling the old policy: %w", err)
+	}
+
+	newFilter, err := newPol.CompileFilterRules(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("compiling the new policy: %w", err)
+	}
+
+	oldRules, newRules := compileRules(oldFilter), compileRules(newFilter)
+
+	var diffs []PairDiff
+	for _, src := range nodes {
+		for _, dst := range nodes {
+			if src.ID == dst.ID {
+				continue
+			}
+
+			before := allowedPorts(oldRules, src, dst)
+			after := allowedPorts(newRules, src, dst)
+
+			diff := PairDiff{
+				Source:      src,
+				Destination: dst,
+				Added:       after.without(before).strings(),
+				Removed:     before.without(after).strings(),
+			}
+
+			switch {
+			case len(diff.Added) == 0 && len(diff.Removed) == 0:
+				continue
+			case len(before) == 0:
+				diff.Change = PairAdded
+			case len(after) == 0:
+				diff.Change = PairRemoved
+			default:
+				diff.Change = PairChanged
+			}
+
+			diffs = append(diffs, diff)
+		}
+	}
+
+	return diffs, nil
+}
+
+// compiledRule is a filter rule with its addresses parsed once for all
+// the pairs of nodes.
+type compiledRule struct {
+	match     matcher.Match
+	protocols []string
+	dsts      []compiledDst
+}
+
+type compiledDst struct {
+	match matcher.Match
+	ports tailcfg.PortRange
+}
+
+func compileRules(filter []tailcfg.FilterRule) []compiledRule {
+	rules := make([]compiledRule, 0, len(filter))
+	for _, rule := range filter {
+		compiled := compiledRule{
+			match: matcher.MatchFromStrings(rule.SrcIPs, nil),
+		}
+
+		// Rules without protocols allow the default ones, kept apart
+		// from rules naming them.
+		if len(rule.IPProto) == 0 {
+			compiled.protocols = []string{""}
+		}
+		for _, proto := range rule.IPProto {
+			compiled.protocols = append(compiled.protocols, protocolName(proto))
+		}
+
+		for _, dst := range rule.DstPorts {
+			compiled.dsts = append(compiled.dsts, compiledDst{
+				match: matcher.MatchFromStrings(nil, []string{dst.IP}),
+				ports: dst.Ports,
+			})
+		}
+
+		rules = append(rules, compiled)
+	}
+
+	return rules
+}
+
+// allowedPorts returns the ports the rules allow src to reach on dst.
+func allowedPorts(rules []compiledRule, src, dst *types.Node) portSet {
+	srcIPs, dstIPs := src.IPs(), dst.IPs()
+
+	ports := make(portSet)
+	for _, rule := range rules {
+		if !rule.match.SrcsContainsIPs(srcIPs) {
+			continue
+		}
+
+		for _, d := range rule.dsts {
+			if !d.match.DestsContainsIP(dstIPs) {
+				continue
+			}
+
+			for _, proto := range rule.protocols {
+				ports[proto] = append(ports[proto], d.ports)
+			}
+		}
+	}
+
+	for proto, ranges := range ports {
+		ports[proto] = mergePortRanges(ranges)
+	}
+
+	return ports
+}
+
+// portSet is the port ranges allowed by protocol, "" being the default
+// protocols.
+type portSet map[string][]tailcfg.PortRange
+
+// without returns the ports of s that are not in other.
+func (s portSet) without(other portSet) portSet {
+	ret := make(portSet)
+	for proto, ranges := range s {
+		for _, r := range ranges {
+			left := []tailcfg.PortRange{r}
+			for _, o := range other[proto] {
+				left = subtractPortRange(left, o)
+			}
+			ret[proto] = append(ret[proto], left...)
+		}
+
+		if len(ret[proto]) == 0 {
+			delete(ret, proto)
+		}
+	}
+
+	return ret
+}
+
+func (s portSet) strings() []string {
+	var ret []string
+	for _, proto := range slices.Sorted(maps.Keys(s)) {
+		for _, r := range s[proto] {
+			port := formatPortRange(r)
+			if proto != "" {
+				port = proto + ":" + port
+			}
+			ret = append(ret, port)
+		}
+	}
+
+	return ret
+}
+
+// mergePortRanges sorts the ranges and merges the overlapping and
+// adjacent ones, so equal sets of ports have a single form.
+func mergePortRanges(ranges []tailcfg.PortRange) []tailcfg.PortRange {
+	slices.SortFunc(ranges, func(a, b tailcfg.PortRange) int {
+		return cmp.Compare(a.First, b.First)
+	})
+
+	var merged []tailcfg.PortRange
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && uint32(r.First) <= uint32(merged[last].Last)+1 {
+			merged[last].Last = max(merged[last].Last, r.Last)
+
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// subtractPortRange removes the ports of o from the ranges.
+func subtractPortRange(ranges []tailcfg.PortRange, o tailcfg.PortRange) []tailcfg.PortRange {
+	var ret []tailcfg.PortRange
+	for _, r := range ranges {
+		if o.Last < r.First || o.First > r.Last {
+			ret = append(ret, r)
+
+			continue
+		}
+		if o.First > r.First {
+			ret = append(ret, tailcfg.PortRange{First: r.First, Last: o.First - 1})
+		}
+		if o.Last < r.Last {
+			ret = append(ret, tailcfg.PortRange{First: o.Last + 1, Last: r.Last})
+		}
+	}
+
+	return ret
+}
+
+func formatPortRange(r tailcfg.PortRange) string {
+	switch {
+	case r == tailcfg.PortRangeAny:
+		return "*"
+	case r.First == r.Last:
+		return strconv.Itoa(int(r.First))
+	default:
+		return fmt.Sprintf("%d-%d", r.First, r.Last)
+	}
+}
+
+// protocolName returns the name of the protocol in the proto field of
+// ACLs, or its number. "icmp" covers ICMP for both IPv4 and IPv6.
+func protocolName(proto int) string {
+	switch proto {
+	case protocolICMP, protocolIPv6ICMP:
+		return "icmp"
+	case protocolIGMP:
+		return "igmp"
+	case protocolIPv4:
+		return "ipv4"
+	case protocolTCP:
+		return "tcp"
+	case protocolEGP:
+		return "egp"
+	case protocolIGP:
+		return "igp"
+	case protocolUDP:
+		return "udp"
+	case protocolGRE:
+		return "gre"
+	case protocolESP:
+		return "esp"
+	case protocolAH:
+		return "ah"
+	case protocolSCTP:
+		return "sctp"
+	default:
+		return strconv.Itoa(proto)
+	}
+}
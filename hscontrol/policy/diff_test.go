@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/tailcfg"
+)
+
+func TestDiff(t *testing.T) {
+	laptop := &types.Node{ID: 1, IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}}
+	server := &types.Node{ID: 2, IPv4: iap("100.64.0.2"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}}
+	db := &types.Node{ID: 3, IPv4: iap("100.64.0.3"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}}
+	nodes := types.Nodes{laptop, server, db}
+
+	oldPol := &ACLPolicy{
+		Hosts: Hosts{"server": netip.MustParsePrefix("100.64.0.2/32"), "db": netip.MustParsePrefix("100.64.0.3/32")},
+		ACLs: []ACL{
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"server:22,80-90"}},
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"server:85-100"}},
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"db:5432"}},
+		},
+	}
+	newPol := &ACLPolicy{
+		Hosts: Hosts{"server": netip.MustParsePrefix("100.64.0.2/32"), "db": netip.MustParsePrefix("100.64.0.3/32")},
+		ACLs: []ACL{
+			// The same ports as the old policy, except 22, in another form.
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"server:80-100"}},
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"server:53"}, Protocol: "udp"},
+			{Action: "accept", Sources: []string{"bob"}, Destinations: []string{"db:*"}},
+		},
+	}
+
+	diffs, err := Diff(oldPol, newPol, nodes)
+	require.NoError(t, err)
+
+	type pair struct {
+		src, dst       string
+		change         string
+		added, removed []string
+	}
+	var got []pair
+	for _, diff := range diffs {
+		got = append(got, pair{
+			src:     diff.Source.IPv4.String(),
+			dst:     diff.Destination.IPv4.String(),
+			change:  diff.Change,
+			added:   diff.Added,
+			removed: diff.Removed,
+		})
+	}
+
+	assert.Equal(t, []pair{
+		{src: "100.64.0.1", dst: "100.64.0.2", change: PairChanged, added: []string{"udp:53"}, removed: []string{"22"}},
+		{src: "100.64.0.1", dst: "100.64.0.3", change: PairRemoved, removed: []string{"5432"}},
+		{src: "100.64.0.2", dst: "100.64.0.3", change: PairAdded, added: []string{"*"}},
+	}, got)
+
+	same, err := Diff(newPol, newPol, nodes)
+	require.NoError(t, err)
+	assert.Empty(t, same)
+}
+
+func TestMergePortRanges(t *testing.T) {
+	got := mergePortRanges([]tailcfg.PortRange{
+		{First: 80, Last: 90},
+		{First: 22, Last: 22},
+		{First: 91, Last: 100},
+		{First: 95, Last: 96},
+		{First: 0, Last: 65535},
+	})
+	assert.Equal(t, []tailcfg.PortRange{tailcfg.PortRangeAny}, got)
+
+	got = subtractPortRange([]tailcfg.PortRange{{First: 80, Last: 100}}, tailcfg.PortRange{First: 85, Last: 90})
+	assert.Equal(t, []tailcfg.PortRange{{First: 80, Last: 84}, {First: 91, Last: 100}}, got)
+}
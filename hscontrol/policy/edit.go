@@ -0,0 +1,214 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/tailscale/hujson"
+)
+
+// patchOp is a JSON patch operation as described in RFC 6902.
+type patchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// AddGroupMembers adds members to a group of the HuJSON policy, creating
+// the group if it does not exist. Members already in the group are
+// skipped. Comments and the order of the policy are kept.
+func AddGroupMembers(data []byte, group string, members []string) ([]byte, error) {
+	if !strings.HasPrefix(group, "group:") {
+		return nil, fmt.Errorf("%w: %q does not start with %q", ErrInvalidGroup, group, "group:")
+	}
+
+	return patchPolicy(data, func(pol *ACLPolicy, hasSection func(string) bool) []patchOp {
+		var ops []patchOp
+		if !hasSection("groups") {
+			ops = append(ops, patchOp{Op: "add", Path: "/groups", Value: map[string]any{}})
+		}
+
+		existing, ok := pol.Groups[group]
+		if !ok {
+			ops = append(ops, patchOp{Op: "add", Path: "/groups/" + escapePointer(group), Value: []string{}})
+		}
+
+		for _, member := range members {
+			if slices.Contains(existing, member) {
+				continue
+			}
+			existing = append(existing, member)
+
+			ops = append(ops, patchOp{
+				Op:    "add",
+				Path:  "/groups/" + escapePointer(group) + "/-",
+				Value: member,
+			})
+		}
+
+		return ops
+	})
+}
+
+// RemoveGroupMembers removes members from a group of the HuJSON policy.
+// The group is kept even if it ends up empty, as it might still be used
+// in the rules.
+func RemoveGroupMembers(data []byte, group string, members []string) ([]byte, error) {
+	return patchPolicy(data, func(pol *ACLPolicy, _ func(string) bool) []patchOp {
+		existing, ok := pol.Groups[group]
+		if !ok {
+			return nil
+		}
+
+		var ops []patchOp
+
+		// Remove from the end, so the indexes of the other members stay
+		// valid while the patch is applied.
+		for i := len(existing) - 1; i >= 0; i-- {
+			if slices.Contains(members, existing[i]) {
+				ops = append(ops, patchOp{
+					Op:   "remove",
+					Path: fmt.Sprintf("/groups/%s/%d", escapePointer(group), i),
+				})
+			}
+		}
+
+		return ops
+	}, func(pol *ACLPolicy) error {
+		if _, ok := pol.Groups[group]; !ok {
+			return fmt.Errorf("%w: %q", ErrGroupNotFound, group)
+		}
+
+		return nil
+	})
+}
+
+// SetHost adds a host to the HuJSON policy, or replaces the address of
+// an existing one.
+func SetHost(data []byte, name string, prefix netip.Prefix) ([]byte, error) {
+	// Hosts without a prefix length are read as /32, so only IPv4
+	// addresses can be written without one.
+	value := prefix.String()
+	if prefix.IsSingleIP() && prefix.Addr().Is4() {
+		value = prefix.Addr().String()
+	}
+
+	return patchPolicy(data, func(pol *ACLPolicy, hasSection func(string) bool) []patchOp {
+		var ops []patchOp
+		if !hasSection("hosts") {
+			ops = append(ops, patchOp{Op: "add", Path: "/hosts", Value: map[string]any{}})
+		}
+
+		return append(ops, patchOp{Op: "add", Path: "/hosts/" + escapePointer(name), Value: value})
+	})
+}
+
+// DeleteHost removes a host from the HuJSON policy.
+func DeleteHost(data []byte, name string) ([]byte, error) {
+	return patchPolicy(data, func(_ *ACLPolicy, _ func(string) bool) []patchOp {
+		return []patchOp{{Op: "remove", Path: "/hosts/" + escapePointer(name)}}
+	}, func(pol *ACLPolicy) error {
+		if _, ok := pol.Hosts[name]; !ok {
+			return fmt.Errorf("%w: %q", ErrHostNotFound, name)
+		}
+
+		return nil
+	})
+}
+
+// RenameUsers replaces the users in the HuJSON policy, wherever they are
+// used, with their new names in names. A user followed by ports, like
+// "alice@example.com:22", keeps the ports. Comments and the layout of the
+// policy are kept.
+func RenameUsers(data []byte, names map[string]string) ([]byte, error) {
+	ast, err := hujson.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing hujson, err: %w", err)
+	}
+
+	ast.Range(func(v *hujson.Value) bool {
+		lit, ok := v.Value.(hujson.Literal)
+		if !ok || lit.Kind() != '"' {
+			return true
+		}
+
+		str := lit.String()
+		if name, ok := names[str]; ok {
+			v.Value = hujson.String(name)
+
+			return true
+		}
+
+		if idx := strings.LastIndex(str, ":"); idx > 0 {
+			if name, ok := names[str[:idx]]; ok {
+				v.Value = hujson.String(name + str[idx:])
+			}
+		}
+
+		return true
+	})
+	ast.UpdateOffsets()
+
+	return ast.Pack(), nil
+}
+
+// patchPolicy applies the operations returned by build to the HuJSON
+// policy in data. The checks run against the parsed policy before it is
+// changed. An empty policy is treated as an empty object.
+func patchPolicy(
+	data []byte,
+	build func(pol *ACLPolicy, hasSection func(string) bool) []patchOp,
+	checks ...func(pol *ACLPolicy) error,
+) ([]byte, error) {
+	if len(strings.TrimSpace(string(data))) == 0 {
+		data = []byte("{}")
+	}
+
+	ast, err := hujson.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing hujson, err: %w", err)
+	}
+
+	std := ast.Clone()
+	std.Standardize()
+
+	var pol ACLPolicy
+	if err := json.Unmarshal(std.Pack(), &pol); err != nil {
+		return nil, fmt.Errorf("unmarshalling policy, err: %w", err)
+	}
+
+	for _, check := range checks {
+		if err := check(&pol); err != nil {
+			return nil, err
+		}
+	}
+
+	hasSection := func(name string) bool {
+		return ast.Find("/"+name) != nil
+	}
+
+	ops := build(&pol, hasSection)
+	if len(ops) == 0 {
+		return data, nil
+	}
+
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ast.Patch(patch); err != nil {
+		return nil, fmt.Errorf("patching policy: %w", err)
+	}
+	ast.Format()
+
+	return ast.Pack(), nil
+}
+
+// escapePointer escapes a name for use in a JSON pointer.
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
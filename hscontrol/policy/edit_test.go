@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/util"
+)
+
+const editTestPolicy = `{
+	// The admins of the tailnet.
+	"groups": {
+		"group:admin": ["alice", "bob"], // keep this comment
+	},
+	"hosts": {
+		"db": "10.0.0.10",
+	},
+	"acls": [
+		{"action": "accept", "src": ["group:admin"], "dst": ["db:*"]},
+	],
+}`
+
+func TestPolicyEdits(t *testing.T) {
+	tests := []struct {
+		name      string
+		edit      func([]byte) ([]byte, error)
+		wantGroup map[string][]string
+		wantHosts map[string]netip.Prefix
+		wantErr   error
+	}{
+		{
+			name: "add-members",
+			edit: func(b []byte) ([]byte, error) {
+				return AddGroupMembers(b, "group:admin", []string{"bob", "carol"})
+			},
+			wantGroup: map[string][]string{"group:admin": {"alice", "bob", "carol"}},
+		},
+		{
+			name: "add-new-group",
+			edit: func(b []byte) ([]byte, error) {
+				return AddGroupMembers(b, "group:dev", []string{"dave"})
+			},
+			wantGroup: map[string][]string{
+				"group:admin": {"alice", "bob"},
+				"group:dev":   {"dave"},
+			},
+		},
+		{
+			name: "add-invalid-group",
+			edit: func(b []byte) ([]byte, error) {
+				return AddGroupMembers(b, "admins", []string{"dave"})
+			},
+			wantErr: ErrInvalidGroup,
+		},
+		{
+			name: "remove-members",
+			edit: func(b []byte) ([]byte, error) {
+				return RemoveGroupMembers(b, "group:admin", []string{"alice", "bob"})
+			},
+			wantGroup: map[string][]string{"group:admin": {}},
+		},
+		{
+			name: "remove-from-unknown-group",
+			edit: func(b []byte) ([]byte, error) {
+				return RemoveGroupMembers(b, "group:dev", []string{"alice"})
+			},
+			wantErr: ErrGroupNotFound,
+		},
+		{
+			name: "set-host",
+			edit: func(b []byte) ([]byte, error) {
+				return SetHost(b, "office", netip.MustParsePrefix("192.168.1.0/24"))
+			},
+			wantHosts: map[string]netip.Prefix{
+				"db":     netip.MustParsePrefix("10.0.0.10/32"),
+				"office": netip.MustParsePrefix("192.168.1.0/24"),
+			},
+		},
+		{
+			name: "replace-host",
+			edit: func(b []byte) ([]byte, error) {
+				return SetHost(b, "db", netip.MustParsePrefix("10.0.0.11/32"))
+			},
+			wantHosts: map[string]netip.Prefix{"db": netip.MustParsePrefix("10.0.0.11/32")},
+		},
+		{
+			name: "delete-host",
+			edit: func(b []byte) ([]byte, error) {
+				return DeleteHost(b, "db")
+			},
+			wantHosts: map[string]netip.Prefix{},
+		},
+		{
+			name: "delete-unknown-host",
+			edit: func(b []byte) ([]byte, error) {
+				return DeleteHost(b, "web")
+			},
+			wantErr: ErrHostNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.edit([]byte(editTestPolicy))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("edit() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if !strings.Contains(string(got), "// keep this comment") {
+				t.Errorf("edit() dropped the comments:\n%s", got)
+			}
+
+			pol, err := LoadACLPolicyFromBytes(got)
+			if err != nil {
+				t.Fatalf("loading edited policy: %s\n%s", err, got)
+			}
+
+			if tt.wantGroup != nil {
+				if diff := cmp.Diff(Groups(tt.wantGroup), pol.Groups); diff != "" {
+					t.Errorf("groups mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			if tt.wantHosts != nil {
+				if diff := cmp.Diff(Hosts(tt.wantHosts), pol.Hosts, util.Comparers...); diff != "" {
+					t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyEditsWithoutSections(t *testing.T) {
+	got, err := AddGroupMembers([]byte(`{"acls": []}`), "group:admin", []string{"alice"})
+	if err != nil {
+		t.Fatalf("AddGroupMembers() error = %s", err)
+	}
+
+	got, err = SetHost(got, "db", netip.MustParsePrefix("10.0.0.10/32"))
+	if err != nil {
+		t.Fatalf("SetHost() error = %s", err)
+	}
+
+	pol, err := LoadACLPolicyFromBytes(got)
+	if err != nil {
+		t.Fatalf("loading edited policy: %s\n%s", err, got)
+	}
+
+	if diff := cmp.Diff(Groups{"group:admin": {"alice"}}, pol.Groups); diff != "" {
+		t.Errorf("groups mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(Hosts{"db": netip.MustParsePrefix("10.0.0.10/32")}, pol.Hosts, util.Comparers...); diff != "" {
+		t.Errorf("hosts mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRenameUsers(t *testing.T) {
+	data := []byte(`{
+	// Admins
+	"groups": {"group:admin": ["alice@example.com", "bob@example.com"]},
+	"acls": [
+		{"action": "accept", "src": ["alice@example.com"], "dst": ["bob@example.com:22,443", "carol@example.com:*"]},
+	],
+}`)
+
+	got, err := RenameUsers(data, map[string]string{
+		"alice@example.com": "alice",
+		"bob@example.com":   "bob",
+	})
+	if err != nil {
+		t.Fatalf("RenameUsers() error = %s", err)
+	}
+
+	if !strings.Contains(string(got), "// Admins") {
+		t.Errorf("RenameUsers() dropped the comments:\n%s", got)
+	}
+
+	pol, err := LoadACLPolicyFromBytes(got)
+	if err != nil {
+		t.Fatalf("loading renamed policy: %s\n%s", err, got)
+	}
+
+	if diff := cmp.Diff(Groups{"group:admin": {"alice", "bob"}}, pol.Groups); diff != "" {
+		t.Errorf("groups mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"bob:22,443", "carol@example.com:*"}, pol.ACLs[0].Destinations); diff != "" {
+		t.Errorf("destinations mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,62 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+var ErrFeatureDisallowed = errors.New("disallowed by policy.disallow")
+
+// CheckDisallowed returns an error naming the first rule of the policy
+// using one of the disallowed features.
+func (pol *ACLPolicy) CheckDisallowed(disallowed []types.PolicyFeature) error {
+	if pol == nil || len(disallowed) == 0 {
+		return nil
+	}
+
+	dangerAll := slices.Contains(disallowed, types.PolicyFeatureDangerAll)
+	wildcard := slices.Contains(disallowed, types.PolicyFeatureWildcard)
+	internetAnyProtocol := slices.Contains(disallowed, types.PolicyFeatureInternetAnyProtocol)
+
+	for index, acl := range pol.ACLs {
+		var dstHosts []string
+		for _, dst := range acl.Destinations {
+			host, _, err := parseDestination(dst)
+			if err != nil {
+				return fmt.Errorf("acls[%d]: %w", index, err)
+			}
+			dstHosts = append(dstHosts, host)
+		}
+
+		if dangerAll && (slices.Contains(acl.Sources, autogroupDangerAll) || slices.Contains(dstHosts, autogroupDangerAll)) {
+			return fmt.Errorf("acls[%d]: %q is %w (%s)", index, autogroupDangerAll, ErrFeatureDisallowed, types.PolicyFeatureDangerAll)
+		}
+
+		if wildcard && slices.Contains(acl.Sources, "*") && slices.Contains(dstHosts, "*") {
+			return fmt.Errorf("acls[%d]: rule from \"*\" to \"*\" is %w (%s)", index, ErrFeatureDisallowed, types.PolicyFeatureWildcard)
+		}
+
+		if internetAnyProtocol && (acl.Protocol == "" || acl.Protocol == "*") && slices.Contains(dstHosts, autogroupInternet) {
+			return fmt.Errorf(
+				"acls[%d]: rule to %q without a protocol is %w (%s)",
+				index,
+				autogroupInternet,
+				ErrFeatureDisallowed,
+				types.PolicyFeatureInternetAnyProtocol,
+			)
+		}
+	}
+
+	if dangerAll {
+		for index, ssh := range pol.SSHs {
+			if slices.Contains(ssh.Sources, autogroupDangerAll) || slices.Contains(ssh.Destinations, autogroupDangerAll) {
+				return fmt.Errorf("ssh[%d]: %q is %w (%s)", index, autogroupDangerAll, ErrFeatureDisallowed, types.PolicyFeatureDangerAll)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckDisallowed(t *testing.T) {
+	all := []types.PolicyFeature{
+		types.PolicyFeatureDangerAll,
+		types.PolicyFeatureWildcard,
+		types.PolicyFeatureInternetAnyProtocol,
+	}
+
+	tests := []struct {
+		name       string
+		pol        ACLPolicy
+		disallowed []types.PolicyFeature
+		wantErr    string
+	}{
+		{
+			name: "nothing-disallowed",
+			pol: ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"*"}, Destinations: []string{"*:*"}},
+			}},
+		},
+		{
+			name: "allowed-rules",
+			pol: ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"*"}, Destinations: []string{"tag:web:443"}},
+				{Action: "accept", Protocol: "tcp", Sources: []string{"group:admin"}, Destinations: []string{"autogroup:internet:*"}},
+			}},
+			disallowed: all,
+		},
+		{
+			name: "danger-all-src",
+			pol: ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"tag:web"}, Destinations: []string{"tag:web:443"}},
+				{Action: "accept", Sources: []string{"autogroup:danger-all"}, Destinations: []string{"tag:web:443"}},
+			}},
+			disallowed: all,
+			wantErr:    `acls[1]: "autogroup:danger-all" is disallowed by policy.disallow (danger-all)`,
+		},
+		{
+			name: "danger-all-ssh",
+			pol: ACLPolicy{SSHs: []SSH{
+				{Action: "accept", Sources: []string{"autogroup:danger-all"}, Destinations: []string{"tag:web"}, Users: []string{"root"}},
+			}},
+			disallowed: []types.PolicyFeature{types.PolicyFeatureDangerAll},
+			wantErr:    `ssh[0]: "autogroup:danger-all" is disallowed by policy.disallow (danger-all)`,
+		},
+		{
+			name: "wildcard",
+			pol: ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"*"}, Destinations: []string{"*:22"}},
+			}},
+			disallowed: []types.PolicyFeature{types.PolicyFeatureWildcard},
+			wantErr:    `acls[0]: rule from "*" to "*" is disallowed by policy.disallow (wildcard)`,
+		},
+		{
+			name: "internet-any-protocol",
+			pol: ACLPolicy{ACLs: []ACL{
+				{Action: "accept", Sources: []string{"group:admin"}, Destinations: []string{"autogroup:internet:*"}},
+			}},
+			disallowed: []types.PolicyFeature{types.PolicyFeatureInternetAnyProtocol},
+			wantErr:    `acls[0]: rule to "autogroup:internet" without a protocol is disallowed by policy.disallow (internet-any-protocol)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.pol.CheckDisallowed(tt.disallowed)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+
+				return
+			}
+
+			require.ErrorIs(t, err, ErrFeatureDisallowed)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
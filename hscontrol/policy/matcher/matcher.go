@@ -19,6 +19,13 @@ func MatchFromFilterRule(rule tailcfg.FilterRule) Match {
 		dests = append(dests, dest.IP)
 	}
 
+	// Nodes granted a capability on others are peers of them.
+	for _, grant := range rule.CapGrant {
+		for _, dst := range grant.Dsts {
+			dests = append(dests, dst.String())
+		}
+	}
+
 	return MatchFromStrings(rule.SrcIPs, dests)
 }
 
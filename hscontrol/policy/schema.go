@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"reflect"
+	"strings"
+)
+
+// SchemaPath is where headscale serves the JSON Schema of the policy
+// format.
+const SchemaPath = "/policy/schema.json"
+
+// schemaDescriptions documents the fields of the policy in the schema,
+// keyed by the Go type and the JSON name of the field.
+var schemaDescriptions = map[string]string{
+	"ACLPolicy.groups":              "Groups of users, named group:<name>. Groups cannot contain groups.",
+	"ACLPolicy.hosts":               "Names for IP addresses and prefixes.",
+	"ACLPolicy.tagOwners":           "The users, groups and service accounts (svc:<name>) allowed to set each tag on their nodes.",
+	"ACLPolicy.acls":                "The rules allowing traffic between nodes, everything else is denied.",
+	"ACLPolicy.tests":               "Tests the rules have to pass for the policy to be accepted.",
+	"ACLPolicy.autoApprovers":       "The users, groups and tags whose advertised routes and exit nodes are approved automatically.",
+	"ACLPolicy.ssh":                 "The rules allowing Tailscale SSH connections.",
+	"ACLPolicy.exitNodeAdvertisers": "The users, groups and tags allowed to advertise exit routes, every node if empty.",
+	"ACLPolicy.peerRelays":          "Lets nodes relay their traffic through nodes running a peer relay.",
+	"ACLPolicy.derpHomes":           "Pins nodes to a DERP region as their home.",
+	"ACLPolicy.derpRestrictions":    "Limits the DERP regions nodes may relay their traffic through.",
+	"ACL.action":                    "What to do with the matching traffic.",
+	"ACL.proto":                     "IP protocol of the traffic, by name like tcp or number, every protocol if empty.",
+	"ACL.src":                       "Users, groups, tags, services, hosts, autogroups, IP addresses or prefixes.",
+	"ACL.dst":                       "Destinations as <alias>:<ports>, the ports are *, a port, a range or a comma separated list.",
+	"ACL.srcPosture":                "Postures all the sources must satisfy, like node:attested.",
+	"ACL.description":               "Explains the rule, like who owns it.",
+	"SSH.action":                    "accept allows the session, check requires the user to authenticate again every checkPeriod.",
+	"SSH.users":                     "Local users of the destination the session may log in as, autogroup:nonroot for all but root.",
+	"SSH.checkPeriod":               "How long an authentication of a check rule lasts, like 12h.",
+	"SSH.message":                   "Shown to the user before the session starts, or when it is rejected.",
+	"DERPHome.region":               "ID of the DERP region in the DERP map.",
+	"DERPRestriction.regions":       "IDs of the DERP regions the nodes may use, none for direct connections only.",
+}
+
+// schemaEnums lists the values accepted by the fields that only accept
+// a few values.
+var schemaEnums = map[string][]string{
+	"ACL.action": {"accept"},
+	"SSH.action": {"accept", "check"},
+}
+
+// schemaKeyPatterns restricts the keys of the maps of the policy.
+var schemaKeyPatterns = map[string]string{
+	"Groups":    "^group:",
+	"TagOwners": "^tag:",
+}
+
+// Schema returns the JSON Schema of the policy format accepted by
+// headscale, generated from the types of the policy. It includes the
+// headscale extensions to the Tailscale format.
+func Schema() ([]byte, error) {
+	generator := schemaGenerator{defs: map[string]any{}}
+	root := generator.object(reflect.TypeOf(ACLPolicy{}))
+
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "headscale policy"
+	root["$defs"] = generator.defs
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+// object returns the schema of a struct, with a property for each field
+// that has a JSON name.
+func (g *schemaGenerator) object(typ reflect.Type) map[string]any {
+	properties := map[string]any{}
+
+	for i := range typ.NumField() {
+		field := typ.Field(i)
+
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		key := typ.Name() + "." + name
+		property := g.schema(field.Type)
+		if description, ok := schemaDescriptions[key]; ok {
+			property["description"] = description
+		}
+		if enum, ok := schemaEnums[key]; ok {
+			property["enum"] = enum
+		}
+
+		properties[name] = property
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+func (g *schemaGenerator) schema(typ reflect.Type) map[string]any {
+	if typ == reflect.TypeOf(netip.Prefix{}) {
+		return map[string]any{
+			"type":        "string",
+			"description": "IP address or prefix.",
+		}
+	}
+
+	switch typ.Kind() {
+	case reflect.Struct:
+		// Named structs are defined once and referenced.
+		if _, ok := g.defs[typ.Name()]; !ok {
+			g.defs[typ.Name()] = nil
+			g.defs[typ.Name()] = g.object(typ)
+		}
+
+		return map[string]any{"$ref": "#/$defs/" + typ.Name()}
+
+	case reflect.Map:
+		schema := map[string]any{
+			"type":                 "object",
+			"additionalProperties": g.schema(typ.Elem()),
+		}
+		if pattern, ok := schemaKeyPatterns[typ.Name()]; ok {
+			schema["propertyNames"] = map[string]any{"pattern": pattern}
+		}
+
+		return schema
+
+	case reflect.Slice, reflect.Array:
+		return map[string]any{
+			"type":  "array",
+			"items": g.schema(typ.Elem()),
+		}
+
+	case reflect.Pointer:
+		return g.schema(typ.Elem())
+
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
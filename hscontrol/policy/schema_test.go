@@ -0,0 +1,75 @@
+package policy
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	data, err := Schema()
+	if err != nil {
+		t.Fatalf("Schema() error = %s", err)
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("Schema() is not JSON: %s", err)
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	typ := reflect.TypeOf(ACLPolicy{})
+	for i := range typ.NumField() {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name == "-" {
+			if _, ok := properties[typ.Field(i).Name]; ok {
+				t.Errorf("Schema() has a property for the internal field %s", typ.Field(i).Name)
+			}
+
+			continue
+		}
+
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Schema() has no property %q", name)
+		}
+	}
+
+	defs, _ := schema["$defs"].(map[string]any)
+	var checkRefs func(value any)
+	checkRefs = func(value any) {
+		switch value := value.(type) {
+		case map[string]any:
+			if ref, ok := value["$ref"].(string); ok {
+				if _, ok := defs[strings.TrimPrefix(ref, "#/$defs/")]; !ok {
+					t.Errorf("Schema() refers to the undefined %s", ref)
+				}
+			}
+			for _, v := range value {
+				checkRefs(v)
+			}
+		case []any:
+			for _, v := range value {
+				checkRefs(v)
+			}
+		}
+	}
+	checkRefs(schema)
+
+	ssh, _ := defs["SSH"].(map[string]any)
+	sshProperties, _ := ssh["properties"].(map[string]any)
+	action, _ := sshProperties["action"].(map[string]any)
+	if got := action["enum"]; !reflect.DeepEqual(got, []any{"accept", "check"}) {
+		t.Errorf("SSH action enum = %v, want accept and check", got)
+	}
+
+	groups, _ := properties["groups"].(map[string]any)
+	if got := groups["propertyNames"]; !reflect.DeepEqual(got, map[string]any{"pattern": "^group:"}) {
+		t.Errorf("groups propertyNames = %v, want the group: prefix", got)
+	}
+
+	hosts, _ := properties["hosts"].(map[string]any)
+	if got := hosts["additionalProperties"].(map[string]any)["type"]; got != "string" {
+		t.Errorf("hosts values type = %v, want string", got)
+	}
+}
@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"fmt"
+
+	"github.com/juanfont/headscale/hscontrol/policy/matcher"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+// RuleStat is how many nodes an ACL rule applies to.
+type RuleStat struct {
+	// Index is the position of the rule in the acls section.
+	Index int
+	ACL   ACL
+
+	// SourceNodes and DestinationNodes count the nodes the sources and
+	// destinations of the rule expand to.
+	SourceNodes      int
+	DestinationNodes int
+
+	// Pairs counts the pairs of different nodes the rule allows to
+	// connect.
+	Pairs int
+}
+
+// Unused reports whether the rule does not allow any node to reach
+// another node.
+func (s RuleStat) Unused() bool {
+	return s.Pairs == 0
+}
+
+// RuleStats returns, for every ACL rule of the policy, the nodes its
+// sources and destinations expand to.
+func (pol *ACLPolicy) RuleStats(nodes types.Nodes) ([]RuleStat, error) {
+	if pol == nil {
+		return nil, nil
+	}
+
+	compiled, err := pol.compileEachACL(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]RuleStat, 0, len(pol.ACLs))
+	for index, acl := range pol.ACLs {
+		rules := compiled[index]
+		stat := RuleStat{Index: index, ACL: acl}
+		both := 0
+		for _, node := range nodes {
+			ips := node.IPs()
+			src, dst := false, false
+			for _, rule := range rules {
+				match := matcher.MatchFromFilterRule(rule)
+				src = src || match.SrcsContainsIPs(ips)
+				dst = dst || match.DestsContainsIP(ips)
+			}
+
+			if src {
+				stat.SourceNodes++
+			}
+			if dst {
+				stat.DestinationNodes++
+			}
+			if src && dst {
+				both++
+			}
+		}
+
+		// A node reaching itself is not a pair.
+		stat.Pairs = stat.SourceNodes*stat.DestinationNodes - both
+		stats = append(stats, stat)
+	}
+
+	return stats, nil
+}
+
+// RulesAllowing returns the ACL rules of the policy that allow src to
+// reach dst, described by their description or their position.
+func (pol *ACLPolicy) RulesAllowing(nodes types.Nodes) (func(src, dst *types.Node) []string, error) {
+	if pol == nil {
+		return func(src, dst *types.Node) []string { return nil }, nil
+	}
+
+	compiled, err := pol.compileEachACL(nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(src, dst *types.Node) []string {
+		var allowing []string
+		for index, rules := range compiled {
+			if src.CanAccess(rules, dst) {
+				allowing = append(allowing, pol.ACLs[index].Label(index))
+			}
+		}
+
+		return allowing
+	}, nil
+}
+
+// Label names the rule at index in the acls section in the output about
+// the rule, its description if it has one.
+func (acl ACL) Label(index int) string {
+	if acl.Description != "" {
+		return acl.Description
+	}
+
+	return fmt.Sprintf("acls[%d]", index)
+}
+
+// compileEachACL compiles every ACL rule of the policy on its own.
+func (pol *ACLPolicy) compileEachACL(nodes types.Nodes) ([][]tailcfg.FilterRule, error) {
+	compiled := make([][]tailcfg.FilterRule, 0, len(pol.ACLs))
+	for _, acl := range pol.ACLs {
+		rulePol := *pol
+		rulePol.ACLs = []ACL{acl}
+		rulePol.PeerRelays = nil
+
+		rules, err := rulePol.CompileFilterRules(nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled = append(compiled, rules)
+	}
+
+	return compiled, nil
+}
@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/tailcfg"
+)
+
+func TestRuleStats(t *testing.T) {
+	nodes := types.Nodes{
+		&types.Node{
+			IPv4:     iap("100.64.0.1"),
+			User:     types.User{Name: "alice"},
+			Hostinfo: &tailcfg.Hostinfo{},
+		},
+		&types.Node{
+			IPv4:       iap("100.64.0.2"),
+			User:       types.User{Name: "alice"},
+			ForcedTags: []string{"tag:server"},
+			Hostinfo:   &tailcfg.Hostinfo{},
+		},
+		&types.Node{
+			IPv4:       iap("100.64.0.3"),
+			User:       types.User{Name: "alice"},
+			ForcedTags: []string{"tag:server"},
+			Hostinfo:   &tailcfg.Hostinfo{},
+		},
+	}
+
+	pol := ACLPolicy{
+		TagOwners: TagOwners{"tag:server": []string{"alice"}, "tag:db": []string{"alice"}},
+		ACLs: []ACL{
+			{Action: "accept", Sources: []string{"*"}, Destinations: []string{"tag:server:22"}},
+			{Action: "accept", Sources: []string{"tag:server"}, Destinations: []string{"tag:db:5432"}},
+			{Action: "accept", Sources: []string{"100.64.0.1"}, Destinations: []string{"100.64.0.1:*"}},
+		},
+	}
+
+	stats, err := pol.RuleStats(nodes)
+	require.NoError(t, err)
+	require.Len(t, stats, 3)
+
+	// Everyone can reach the two servers, but not themselves.
+	assert.Equal(t, 3, stats[0].SourceNodes)
+	assert.Equal(t, 2, stats[0].DestinationNodes)
+	assert.Equal(t, 4, stats[0].Pairs)
+	assert.False(t, stats[0].Unused())
+
+	// No node is tagged tag:db.
+	assert.Equal(t, 2, stats[1].SourceNodes)
+	assert.Equal(t, 0, stats[1].DestinationNodes)
+	assert.True(t, stats[1].Unused())
+
+	// A node allowed to reach only itself.
+	assert.Equal(t, 2, stats[2].Index)
+	assert.True(t, stats[2].Unused())
+}
+
+func TestRulesAllowing(t *testing.T) {
+	laptop := &types.Node{IPv4: iap("100.64.0.1"), User: types.User{Name: "alice"}, Hostinfo: &tailcfg.Hostinfo{}}
+	server := &types.Node{IPv4: iap("100.64.0.2"), User: types.User{Name: "bob"}, Hostinfo: &tailcfg.Hostinfo{}}
+	nodes := types.Nodes{laptop, server}
+
+	pol := &ACLPolicy{
+		ACLs: []ACL{
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"bob:22"}, Description: "SSH to bob, owned by ops"},
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"bob:*"}},
+			{Action: "accept", Sources: []string{"bob"}, Destinations: []string{"bob:*"}},
+		},
+	}
+
+	allowedBy, err := pol.RulesAllowing(nodes)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"SSH to bob, owned by ops", "acls[1]"}, allowedBy(laptop, server))
+	assert.Empty(t, allowedBy(server, laptop))
+}
@@ -0,0 +1,444 @@
+package hscontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidPolicyRollout = errors.New("invalid policy rollout")
+
+var (
+	policyRolloutActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "policy_rollout_active",
+		Help:      "1 while a policy is rolled out to canary nodes, else 0",
+	})
+	policyRolloutsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "policy_rollouts_total",
+		Help:      "total count of policy rollouts by how they finished",
+	}, []string{"state"})
+)
+
+// policyRollout is the rollout in progress with its policy loaded, and
+// what the watcher learned about the canary nodes.
+type policyRollout struct {
+	rollout types.PolicyRollout
+	pol     *policy.ACLPolicy
+
+	mu sync.Mutex
+	// warnings are the health warnings of the canary nodes when the
+	// watcher first saw them, only new warnings count against the
+	// rollout.
+	warnings map[types.NodeID][]string
+	// probeFailures counts the pings in a row a canary node failed, by
+	// node and probe.
+	probeFailures map[types.NodeID]map[string]int
+}
+
+func newPolicyRollout(rollout types.PolicyRollout, pol *policy.ACLPolicy) *policyRollout {
+	return &policyRollout{
+		rollout:       rollout,
+		pol:           pol,
+		warnings:      make(map[types.NodeID][]string),
+		probeFailures: make(map[types.NodeID]map[string]int),
+	}
+}
+
+// newWarning returns a warning of the node it did not have when it was
+// first seen during the rollout, or "".
+func (r *policyRollout) newWarning(nodeID types.NodeID, warnings []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen, ok := r.warnings[nodeID]
+	if !ok {
+		r.warnings[nodeID] = warnings
+
+		return ""
+	}
+
+	for _, warning := range warnings {
+		if !slices.Contains(seen, warning) {
+			return warning
+		}
+	}
+
+	return ""
+}
+
+// recordProbe counts a ping of the probe by the node and returns how
+// many pings in a row failed.
+func (r *policyRollout) recordProbe(nodeID types.NodeID, probe string, failed bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.probeFailures[nodeID] == nil {
+		r.probeFailures[nodeID] = make(map[string]int)
+	}
+
+	if !failed {
+		r.probeFailures[nodeID][probe] = 0
+
+		return 0
+	}
+
+	r.probeFailures[nodeID][probe]++
+
+	return r.probeFailures[nodeID][probe]
+}
+
+// policyFor returns the policy the maps of the node are generated with,
+// the policy of the rollout in progress for its canary nodes.
+func (h *Headscale) policyFor(node *types.Node) *policy.ACLPolicy {
+	if r := h.policyRollout.Load(); r != nil && h.isCanaryNode(r, node) {
+		return r.pol
+	}
+
+	return h.ACLPolicy
+}
+
+func (h *Headscale) isCanaryNode(r *policyRollout, node *types.Node) bool {
+	return slices.Contains(h.nodeTags(node), r.rollout.CanaryTag)
+}
+
+// loadPolicyRollout resumes the rollout in progress when headscale
+// starts.
+func (h *Headscale) loadPolicyRollout() error {
+	if h.cfg.Policy.Mode != types.PolicyModeDB {
+		return nil
+	}
+
+	rollout, err := h.db.GetActivePolicyRollout()
+	if err != nil {
+		if errors.Is(err, types.ErrPolicyRolloutNotFound) {
+			return nil
+		}
+
+		return fmt.Errorf("loading the policy rollout: %w", err)
+	}
+
+	pol, err := policy.LoadACLPolicyFromBytes([]byte(rollout.Data))
+	if err != nil {
+		return fmt.Errorf("loading the policy of rollout %d: %w", rollout.ID, err)
+	}
+
+	if err := h.setUserAliases(pol); err != nil {
+		return err
+	}
+	h.setDirectoryGroups(pol)
+
+	h.policyRollout.Store(newPolicyRollout(*rollout, pol))
+	policyRolloutActive.Set(1)
+
+	log.Info().
+		Uint64("rollout", rollout.ID).
+		Str("canary_tag", rollout.CanaryTag).
+		Time("promote_at", rollout.PromoteAt).
+		Msg("Resuming the policy rollout")
+
+	return nil
+}
+
+// startPolicyRollout applies the policy to the nodes with the canary tag
+// until it is promoted after duration. The policy is validated like a
+// policy set for the whole tailnet.
+func (h *Headscale) startPolicyRollout(
+	data []byte,
+	canaryTag string,
+	duration time.Duration,
+	probes []string,
+	expectedVersion uint64,
+) (*types.PolicyRollout, error) {
+	if h.cfg.Policy.Mode != types.PolicyModeDB {
+		return nil, types.ErrPolicyUpdateIsDisabled
+	}
+
+	if !strings.HasPrefix(canaryTag, "tag:") {
+		return nil, fmt.Errorf("%w: canary tag %q does not start with tag:", ErrInvalidPolicyRollout, canaryTag)
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("%w: the duration must be positive", ErrInvalidPolicyRollout)
+	}
+
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	for _, probe := range probes {
+		if _, err := resolveProbe(nodes, probe); err != nil {
+			return nil, err
+		}
+	}
+
+	h.policyUpdateMu.Lock()
+	defer h.policyUpdateMu.Unlock()
+
+	var baseVersion uint
+	current, err := h.db.GetPolicy()
+	switch {
+	case err == nil:
+		baseVersion = current.ID
+	case !errors.Is(err, types.ErrPolicyNotFound):
+		return nil, fmt.Errorf("loading ACL from database: %w", err)
+	}
+
+	if expectedVersion != 0 && uint64(baseVersion) != expectedVersion {
+		return nil, types.ErrPolicyVersionMismatch
+	}
+
+	pol, err := h.compilePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rollout, err := h.db.CreatePolicyRollout(types.PolicyRollout{
+		Data:        string(data),
+		BaseVersion: baseVersion,
+		CanaryTag:   canaryTag,
+		Probes:      probes,
+		PromoteAt:   time.Now().Add(duration),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.policyRollout.Store(newPolicyRollout(*rollout, pol))
+	policyRolloutActive.Set(1)
+
+	log.Info().
+		Uint64("rollout", rollout.ID).
+		Str("canary_tag", canaryTag).
+		Time("promote_at", rollout.PromoteAt).
+		Msg("Rolling out the policy to the canary nodes")
+
+	ctx := types.NotifyCtx(context.Background(), "policy-rollout-start", "na")
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	return rollout, nil
+}
+
+// promotePolicyRollout makes the policy of the rollout in progress the
+// policy of the tailnet.
+func (h *Headscale) promotePolicyRollout() (*types.PolicyRollout, *types.Policy, error) {
+	h.policyUpdateMu.Lock()
+	defer h.policyUpdateMu.Unlock()
+
+	r := h.policyRollout.Load()
+	if r == nil {
+		return nil, nil, types.ErrPolicyRolloutNotFound
+	}
+
+	promoted, err := h.db.PromotePolicyRollout(r.rollout.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.ACLPolicy = r.pol
+	h.setLoadedPolicy(r.pol, promoted.ID)
+	h.policyRollout.Store(nil)
+	policyRolloutActive.Set(0)
+	policyRolloutsFinished.WithLabelValues(types.PolicyRolloutPromoted).Inc()
+
+	log.Info().
+		Uint64("rollout", r.rollout.ID).
+		Uint("version", promoted.ID).
+		Msg("Promoted the policy rollout to the tailnet")
+
+	ctx := types.NotifyCtx(context.Background(), "policy-rollout-promote", "na")
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	rollout := r.rollout
+	rollout.State = types.PolicyRolloutPromoted
+
+	return &rollout, promoted, nil
+}
+
+// rollbackPolicyRollout gives the canary nodes the policy of the
+// tailnet back.
+func (h *Headscale) rollbackPolicyRollout(reason string) (*types.PolicyRollout, error) {
+	h.policyUpdateMu.Lock()
+	defer h.policyUpdateMu.Unlock()
+
+	r := h.policyRollout.Load()
+	if r == nil {
+		return nil, types.ErrPolicyRolloutNotFound
+	}
+
+	rollout, err := db.Write(h.db.DB, func(tx *gorm.DB) (*types.PolicyRollout, error) {
+		return db.FinishPolicyRollout(tx, r.rollout.ID, types.PolicyRolloutRolledBack, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h.policyRollout.Store(nil)
+	policyRolloutActive.Set(0)
+	policyRolloutsFinished.WithLabelValues(types.PolicyRolloutRolledBack).Inc()
+
+	log.Warn().
+		Uint64("rollout", rollout.ID).
+		Str("reason", reason).
+		Msg("Rolled back the policy rollout")
+
+	ctx := types.NotifyCtx(context.Background(), "policy-rollout-rollback", "na")
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	return rollout, nil
+}
+
+// watchPolicyRollout checks the canary nodes of the rollout in progress
+// every interval, rolling it back as soon as one of them is unhealthy
+// and promoting it once its duration passed.
+func (h *Headscale) watchPolicyRollout(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.checkPolicyRollout(ctx, time.Now())
+		}
+	}
+}
+
+func (h *Headscale) checkPolicyRollout(ctx context.Context, now time.Time) {
+	r := h.policyRollout.Load()
+	if r == nil {
+		return
+	}
+
+	if !now.Before(r.rollout.PromoteAt) {
+		_, _, err := h.promotePolicyRollout()
+		switch {
+		case errors.Is(err, types.ErrPolicyVersionMismatch):
+			if _, err := h.rollbackPolicyRollout("the policy was changed during the rollout"); err != nil {
+				log.Error().Err(err).Msg("failed to roll back the policy rollout")
+			}
+		case err != nil:
+			log.Error().Err(err).Msg("failed to promote the policy rollout")
+		}
+
+		return
+	}
+
+	reason, err := h.canaryProblem(ctx, r)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to check the canary nodes of the policy rollout")
+
+		return
+	}
+	if reason == "" {
+		return
+	}
+
+	if _, err := h.rollbackPolicyRollout(reason); err != nil {
+		log.Error().Err(err).Msg("failed to roll back the policy rollout")
+	}
+}
+
+// canaryProblem returns why the rollout must be rolled back: a canary
+// node reported a new health warning, or failed to ping a probe
+// ProbeFailures times in a row. It returns "" while the canary nodes are
+// healthy.
+func (h *Headscale) canaryProblem(ctx context.Context, r *policyRollout) (string, error) {
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		return "", err
+	}
+
+	var canaries types.Nodes
+	for _, node := range nodes {
+		if h.isCanaryNode(r, node) {
+			canaries = append(canaries, node)
+		}
+	}
+
+	for _, node := range canaries {
+		if warning := r.newWarning(node.ID, h.nodeHealth.get(node.ID)); warning != "" {
+			return fmt.Sprintf("%s reported %q", node.GivenName, warning), nil
+		}
+	}
+
+	probes := make(map[string]netip.Addr, len(r.rollout.Probes))
+	for _, probe := range r.rollout.Probes {
+		addr, err := resolveProbe(nodes, probe)
+		if err != nil {
+			return fmt.Sprintf("probe %s: %s", probe, err), nil
+		}
+		probes[probe] = addr
+	}
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		problem string
+	)
+	for _, node := range canaries {
+		if !h.nodeNotifier.IsConnected(node.ID) {
+			continue
+		}
+
+		for probe, addr := range probes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := h.pingFromNode(ctx, node, addr)
+				failures := r.recordProbe(node.ID, probe, err != nil)
+				if failures < h.cfg.Policy.Rollout.ProbeFailures {
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				problem = fmt.Sprintf("%s failed to reach probe %s %d times in a row: %s", node.GivenName, probe, failures, err)
+			}()
+		}
+	}
+	wg.Wait()
+
+	return problem, nil
+}
+
+// resolveProbe returns the tailnet address of the probe, given by name
+// or address.
+func resolveProbe(nodes types.Nodes, probe string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(probe); err == nil {
+		return addr, nil
+	}
+
+	for _, node := range nodes {
+		if node.GivenName != probe && node.Hostname != probe {
+			continue
+		}
+
+		if ips := node.IPs(); len(ips) > 0 {
+			return ips[0], nil
+		}
+	}
+
+	return netip.Addr{}, fmt.Errorf("%w: probe %q is not the name or address of a node", ErrInvalidPolicyRollout, probe)
+}
@@ -0,0 +1,218 @@
+package hscontrol
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gorm.io/gorm"
+	"tailscale.com/types/key"
+)
+
+func TestPolicyRollout(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{
+		Policy: types.PolicyConfig{Mode: types.PolicyModeDB},
+	})
+
+	ctx := context.Background()
+
+	var canary, other *types.Node
+	err := h.db.Write(func(tx *gorm.DB) error {
+		user, err := db.CreateUser(tx, "alice")
+		if err != nil {
+			return err
+		}
+
+		canary = &types.Node{Hostname: "canary", ForcedTags: []string{"tag:canary"}}
+		other = &types.Node{Hostname: "other"}
+		for _, node := range []*types.Node{canary, other} {
+			node.MachineKey = key.NewMachine().Public()
+			node.NodeKey = key.NewNode().Public()
+			node.UserID = user.ID
+			node.RegisterMethod = util.RegisterMethodCLI
+			if err := tx.Save(node).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	set, err := api.SetPolicy(ctx, &v1.SetPolicyRequest{
+		Policy: `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`,
+	})
+	if err != nil {
+		t.Fatalf("SetPolicy() error = %s", err)
+	}
+
+	const candidate = `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]}`
+
+	_, err = api.StartPolicyRollout(ctx, &v1.StartPolicyRolloutRequest{
+		Policy:    candidate,
+		CanaryTag: "canary",
+		Duration:  durationpb.New(time.Hour),
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("StartPolicyRollout() without tag: prefix error = %v, want %s", err, codes.InvalidArgument)
+	}
+
+	started, err := api.StartPolicyRollout(ctx, &v1.StartPolicyRolloutRequest{
+		Policy:          candidate,
+		CanaryTag:       "tag:canary",
+		Duration:        durationpb.New(time.Hour),
+		ExpectedVersion: set.GetVersion(),
+	})
+	if err != nil {
+		t.Fatalf("StartPolicyRollout() error = %s", err)
+	}
+
+	if got := started.GetRollout().GetCanaryNodes(); len(got) != 1 || got[0] != uint64(canary.ID) {
+		t.Errorf("canary nodes = %v, want [%d]", got, canary.ID)
+	}
+	if h.policyFor(canary) == h.ACLPolicy {
+		t.Error("the canary node is not given the policy of the rollout")
+	}
+	if h.policyFor(other) != h.ACLPolicy {
+		t.Error("a node without the canary tag is given the policy of the rollout")
+	}
+
+	_, err = api.StartPolicyRollout(ctx, &v1.StartPolicyRolloutRequest{
+		Policy:    candidate,
+		CanaryTag: "tag:canary",
+		Duration:  durationpb.New(time.Hour),
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("StartPolicyRollout() during a rollout error = %v, want %s", err, codes.FailedPrecondition)
+	}
+
+	rolledBack, err := api.RollbackPolicyRollout(ctx, &v1.RollbackPolicyRolloutRequest{Reason: "testing"})
+	if err != nil {
+		t.Fatalf("RollbackPolicyRollout() error = %s", err)
+	}
+	if rolledBack.GetRollout().GetState() != types.PolicyRolloutRolledBack {
+		t.Errorf("state = %q, want %q", rolledBack.GetRollout().GetState(), types.PolicyRolloutRolledBack)
+	}
+	if h.policyFor(canary) != h.ACLPolicy {
+		t.Error("the canary node kept the policy of the rolled back rollout")
+	}
+
+	_, err = api.PromotePolicyRollout(ctx, &v1.PromotePolicyRolloutRequest{})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("PromotePolicyRollout() without a rollout error = %v, want %s", err, codes.NotFound)
+	}
+
+	if _, err := h.startPolicyRollout([]byte(candidate), "tag:canary", time.Hour, nil, 0); err != nil {
+		t.Fatalf("startPolicyRollout() error = %s", err)
+	}
+
+	h.checkPolicyRollout(ctx, time.Now().Add(2*time.Hour))
+
+	got, err := api.GetPolicyRollout(ctx, &v1.GetPolicyRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetPolicyRollout() error = %s", err)
+	}
+	if got.GetRollout().GetState() != types.PolicyRolloutPromoted {
+		t.Errorf("state after its duration = %q, want %q", got.GetRollout().GetState(), types.PolicyRolloutPromoted)
+	}
+
+	current, err := api.GetPolicy(ctx, &v1.GetPolicyRequest{})
+	if err != nil {
+		t.Fatalf("GetPolicy() error = %s", err)
+	}
+	if current.GetPolicy() != candidate {
+		t.Errorf("policy after the promotion = %s, want %s", current.GetPolicy(), candidate)
+	}
+	if h.policyFor(other) != h.ACLPolicy || h.ACLPolicy.ACLs[0].Destinations[0] != "*:22" {
+		t.Error("the promoted policy is not applied to the tailnet")
+	}
+}
+
+func TestPolicyRolloutRolledBackWhenThePolicyChanged(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{
+		Policy: types.PolicyConfig{Mode: types.PolicyModeDB},
+	})
+
+	ctx := context.Background()
+
+	if _, err := h.startPolicyRollout(
+		[]byte(`{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:22"]}]}`),
+		"tag:canary", time.Hour, nil, 0,
+	); err != nil {
+		t.Fatalf("startPolicyRollout() error = %s", err)
+	}
+
+	if _, err := api.SetPolicy(ctx, &v1.SetPolicyRequest{
+		Policy: `{"acls": [{"action": "accept", "src": ["*"], "dst": ["*:*"]}]}`,
+	}); err != nil {
+		t.Fatalf("SetPolicy() error = %s", err)
+	}
+
+	h.checkPolicyRollout(ctx, time.Now().Add(2*time.Hour))
+
+	got, err := api.GetPolicyRollout(ctx, &v1.GetPolicyRolloutRequest{})
+	if err != nil {
+		t.Fatalf("GetPolicyRollout() error = %s", err)
+	}
+	if got.GetRollout().GetState() != types.PolicyRolloutRolledBack {
+		t.Errorf("state = %q, want %q", got.GetRollout().GetState(), types.PolicyRolloutRolledBack)
+	}
+	if h.ACLPolicy.ACLs[0].Destinations[0] != "*:*" {
+		t.Error("the rollout replaced a policy set during the rollout")
+	}
+}
+
+func TestPolicyRolloutCanaryChecks(t *testing.T) {
+	r := newPolicyRollout(types.PolicyRollout{}, &policy.ACLPolicy{})
+
+	if got := r.newWarning(1, []string{"dns"}); got != "" {
+		t.Errorf("first warnings of a node = %q, want them as its baseline", got)
+	}
+	if got := r.newWarning(1, []string{"dns"}); got != "" {
+		t.Errorf("known warning = %q, want none", got)
+	}
+	if got := r.newWarning(1, []string{"dns", "derp"}); got != "derp" {
+		t.Errorf("new warning = %q, want derp", got)
+	}
+
+	for want := 1; want <= 2; want++ {
+		if got := r.recordProbe(1, "db", true); got != want {
+			t.Errorf("failures = %d, want %d", got, want)
+		}
+	}
+	if got := r.recordProbe(1, "db", false); got != 0 {
+		t.Errorf("failures after a successful ping = %d, want 0", got)
+	}
+	if got := r.recordProbe(2, "db", true); got != 1 {
+		t.Errorf("failures of another node = %d, want 1", got)
+	}
+}
+
+func TestResolveProbe(t *testing.T) {
+	addr := netip.MustParseAddr("100.64.0.1")
+	nodes := types.Nodes{
+		{GivenName: "db", Hostname: "db-1", IPv4: &addr},
+	}
+
+	for _, probe := range []string{"db", "db-1", "100.64.0.1"} {
+		got, err := resolveProbe(nodes, probe)
+		if err != nil || got != addr {
+			t.Errorf("resolveProbe(%q) = %s, %v, want %s", probe, got, err, addr)
+		}
+	}
+
+	if _, err := resolveProbe(nodes, "web"); err == nil {
+		t.Error("resolveProbe() of an unknown node passed")
+	}
+}
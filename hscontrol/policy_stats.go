@@ -0,0 +1,118 @@
+package hscontrol
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/rs/zerolog/log"
+)
+
+// policyRuleUsage remembers, for each ACL rule, when it last allowed
+// nodes to connect and since when it did not. Rules are identified by
+// their content, so they keep their history when other rules are added
+// or removed.
+type policyRuleUsage struct {
+	mu          sync.Mutex
+	since       time.Time
+	lastUsed    map[string]time.Time
+	unusedSince map[string]time.Time
+}
+
+// ruleUsage is the history of a rule.
+type ruleUsage struct {
+	LastUsed    time.Time
+	UnusedSince time.Time
+}
+
+func ruleKey(acl policy.ACL) string {
+	key, _ := json.Marshal(acl)
+
+	return string(key)
+}
+
+// record updates the history of the rules with their current stats, and
+// forgets the rules that are no longer in the policy.
+func (u *policyRuleUsage) record(stats []policy.RuleStat, now time.Time) []ruleUsage {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.since.IsZero() {
+		u.since = now
+	}
+
+	lastUsed := make(map[string]time.Time, len(stats))
+	unusedSince := make(map[string]time.Time, len(stats))
+	usages := make([]ruleUsage, 0, len(stats))
+
+	for _, stat := range stats {
+		key := ruleKey(stat.ACL)
+
+		if used, ok := u.lastUsed[key]; ok {
+			lastUsed[key] = used
+		}
+
+		if stat.Unused() {
+			since, ok := u.unusedSince[key]
+			if !ok {
+				since = now
+			}
+			unusedSince[key] = since
+		} else {
+			lastUsed[key] = now
+		}
+
+		usages = append(usages, ruleUsage{
+			LastUsed:    lastUsed[key],
+			UnusedSince: unusedSince[key],
+		})
+	}
+
+	u.lastUsed = lastUsed
+	u.unusedSince = unusedSince
+
+	return usages
+}
+
+func (u *policyRuleUsage) trackedSince() time.Time {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	return u.since
+}
+
+// policyRuleStats computes the stats of the rules of the current policy
+// and records them in the usage history.
+func (h *Headscale) policyRuleStats() ([]policy.RuleStat, []ruleUsage, error) {
+	nodes, err := h.db.ListNodes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stats, err := h.ACLPolicy.RuleStats(nodes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stats, h.policyRuleUsage.record(stats, time.Now()), nil
+}
+
+// trackPolicyRuleUsage records the rule stats every interval, so rules
+// that stay unused can be reported.
+func (h *Headscale) trackPolicyRuleUsage(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, _, err := h.policyRuleStats(); err != nil {
+				log.Error().Err(err).Msg("failed to compute policy rule stats")
+			}
+		}
+	}
+}
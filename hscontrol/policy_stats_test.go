@@ -0,0 +1,46 @@
+package hscontrol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/policy"
+)
+
+func TestPolicyRuleUsage(t *testing.T) {
+	var usage policyRuleUsage
+
+	used := policy.RuleStat{ACL: policy.ACL{Action: "accept", Sources: []string{"*"}}, Pairs: 2}
+	unused := policy.RuleStat{ACL: policy.ACL{Action: "accept", Sources: []string{"tag:old"}}}
+
+	start := time.Now()
+	got := usage.record([]policy.RuleStat{used, unused}, start)
+	if !got[0].LastUsed.Equal(start) || !got[0].UnusedSince.IsZero() {
+		t.Errorf("used rule = %+v, want used at start", got[0])
+	}
+	if !got[1].LastUsed.IsZero() || !got[1].UnusedSince.Equal(start) {
+		t.Errorf("unused rule = %+v, want unused since start", got[1])
+	}
+
+	// The rule stops being used, it keeps when it was last used.
+	later := start.Add(time.Hour)
+	used.Pairs = 0
+	got = usage.record([]policy.RuleStat{unused, used}, later)
+	if !got[0].UnusedSince.Equal(start) {
+		t.Errorf("unused rule is unused since %s, want %s", got[0].UnusedSince, start)
+	}
+	if !got[1].LastUsed.Equal(start) || !got[1].UnusedSince.Equal(later) {
+		t.Errorf("rule that stopped being used = %+v", got[1])
+	}
+
+	// Removed rules are forgotten.
+	usage.record([]policy.RuleStat{used}, later)
+	got = usage.record([]policy.RuleStat{unused}, later.Add(time.Hour))
+	if !got[0].UnusedSince.Equal(later.Add(time.Hour)) {
+		t.Errorf("re-added rule is unused since %s, want the time it was re-added", got[0].UnusedSince)
+	}
+
+	if !usage.trackedSince().Equal(start) {
+		t.Errorf("trackedSince() = %s, want %s", usage.trackedSince(), start)
+	}
+}
@@ -3,8 +3,10 @@ package hscontrol
 import (
 	"context"
 	"fmt"
+	"maps"
 	"math/rand/v2"
 	"net/http"
+	"net/netip"
 	"slices"
 	"sort"
 	"strings"
@@ -14,13 +16,16 @@ import (
 	"github.com/juanfont/headscale/hscontrol/mapper"
 	"github.com/juanfont/headscale/hscontrol/types"
 	"github.com/juanfont/headscale/hscontrol/util"
-	"github.com/rs/zerolog/log"
 	"github.com/sasha-s/go-deadlock"
 	xslices "golang.org/x/exp/slices"
 	"gorm.io/gorm"
 	"tailscale.com/tailcfg"
+	"tailscale.com/util/set"
 )
 
+// mapperLog logs the map sessions with the mapper.
+var mapperLog = util.NewSubsystemLogger(util.LogSubsystemMapper)
+
 const (
 	keepAliveInterval = 50 * time.Second
 )
@@ -48,6 +53,16 @@ type mapSession struct {
 	node *types.Node
 	w    http.ResponseWriter
 
+	// visiblePeers caches the peers the node can see, to only send it
+	// the patches about them. It is reset by every update that can
+	// change it.
+	visiblePeers set.Set[types.NodeID]
+
+	// remoteAddr is the address the node connected from, if PROXY
+	// protocol is enabled it is the address of the client and not
+	// the load balancer.
+	remoteAddr netip.AddrPort
+
 	warnf  func(string, ...any)
 	infof  func(string, ...any)
 	tracef func(string, ...any)
@@ -59,8 +74,10 @@ func (h *Headscale) newMapSession(
 	req tailcfg.MapRequest,
 	w http.ResponseWriter,
 	node *types.Node,
+	remoteAddr string,
 ) *mapSession {
-	warnf, infof, tracef, errf := logPollFunc(req, node)
+	addrPort, _ := netip.ParseAddrPort(remoteAddr)
+	warnf, infof, tracef, errf := logPollFunc(req, node, addrPort)
 
 	var updateChan chan types.StateUpdate
 	if req.Stream {
@@ -75,6 +92,10 @@ func (h *Headscale) newMapSession(
 
 	ka := keepAliveInterval + (time.Duration(rand.IntN(9000)) * time.Millisecond)
 
+	if tuning := h.cfg.ClientTuningFor(node, h.nodeTags(node)); tuning != nil && tuning.KeepAliveInterval > 0 {
+		ka = tuning.KeepAliveInterval
+	}
+
 	return &mapSession{
 		h:      h,
 		ctx:    ctx,
@@ -84,6 +105,8 @@ func (h *Headscale) newMapSession(
 		capVer: req.Version,
 		mapper: h.mapper,
 
+		remoteAddr: addrPort,
+
 		ch:           updateChan,
 		cancelCh:     make(chan struct{}),
 		cancelChOpen: true,
@@ -142,7 +165,7 @@ func (m *mapSession) beforeServeLongPoll() {
 }
 
 func (m *mapSession) afterServeLongPoll() {
-	if m.node.IsEphemeral() {
+	if m.node.IsEphemeral() && !m.h.isReadOnly() {
 		m.h.ephemeralGC.Schedule(m.node.ID, m.h.cfg.EphemeralNodeInactivityTimeout)
 	}
 }
@@ -249,6 +272,7 @@ func (m *mapSession) serveLongPoll() {
 
 	m.h.nodeNotifier.AddNode(m.node.ID, m.ch)
 	go m.h.updateNodeOnlineStatus(true, m.node)
+	m.recordRemoteAddr()
 
 	m.infof("node has connected, mapSession: %p, chan: %p", m, m.ch)
 
@@ -297,11 +321,22 @@ func (m *mapSession) serveLongPoll() {
 				return
 			}
 
+			if m.h.cfg.NodeExpiry.Mode == types.NodeExpiryModeSoft {
+				update = softExpiryUpdate(update)
+			}
+
+			// Patches, DERP maps and pings do not change which peers
+			// the node can see, everything else might.
+			if update.Type != types.StatePeerChangedPatch && update.Type != types.StateDERPUpdated &&
+				update.Type != types.StatePingRequest {
+				m.visiblePeers = nil
+			}
+
 			updateType := "full"
 			switch update.Type {
 			case types.StateFullUpdate:
 				m.tracef("Sending Full MapResponse")
-				data, err = m.mapper.FullMapResponse(m.req, m.node, m.h.ACLPolicy, fmt.Sprintf("from mapSession: %p, stream: %t", m, m.isStreaming()))
+				data, err = m.mapper.FullMapResponse(ctx, m.req, m.node, m.h.policyFor(m.node), fmt.Sprintf("from mapSession: %p, stream: %t", m, m.isStreaming()))
 			case types.StatePeerChanged:
 				changed := make(map[types.NodeID]bool, len(update.ChangeNodes))
 
@@ -311,12 +346,16 @@ func (m *mapSession) serveLongPoll() {
 
 				lastMessage = update.Message
 				m.tracef(fmt.Sprintf("Sending Changed MapResponse: %v", lastMessage))
-				data, err = m.mapper.PeerChangedResponse(m.req, m.node, changed, update.ChangePatches, m.h.ACLPolicy, lastMessage)
+				data, err = m.mapper.PeerChangedResponse(ctx, m.req, m.node, changed, update.ChangePatches, m.h.policyFor(m.node), lastMessage)
 				updateType = "change"
 
 			case types.StatePeerChangedPatch:
-				m.tracef(fmt.Sprintf("Sending Changed Patch MapResponse: %v", lastMessage))
-				data, err = m.mapper.PeerChangedPatchResponse(m.req, m.node, update.ChangePatches, m.h.ACLPolicy)
+				var patches []*tailcfg.PeerChange
+				patches, err = m.visiblePatches(update.ChangePatches)
+				if err == nil && len(patches) > 0 {
+					m.tracef(fmt.Sprintf("Sending Changed Patch MapResponse: %v", lastMessage))
+					data, err = m.mapper.PeerChangedPatchResponse(m.req, m.node, patches, m.h.policyFor(m.node))
+				}
 				updateType = "patch"
 			case types.StatePeerRemoved:
 				changed := make(map[types.NodeID]bool, len(update.Removed))
@@ -325,18 +364,22 @@ func (m *mapSession) serveLongPoll() {
 					changed[nodeID] = false
 				}
 				m.tracef(fmt.Sprintf("Sending Changed MapResponse: %v", lastMessage))
-				data, err = m.mapper.PeerChangedResponse(m.req, m.node, changed, update.ChangePatches, m.h.ACLPolicy, lastMessage)
+				data, err = m.mapper.PeerChangedResponse(ctx, m.req, m.node, changed, update.ChangePatches, m.h.policyFor(m.node), lastMessage)
 				updateType = "remove"
 			case types.StateSelfUpdate:
 				lastMessage = update.Message
 				m.tracef(fmt.Sprintf("Sending Changed MapResponse: %v", lastMessage))
 				// create the map so an empty (self) update is sent
-				data, err = m.mapper.PeerChangedResponse(m.req, m.node, make(map[types.NodeID]bool), update.ChangePatches, m.h.ACLPolicy, lastMessage)
+				data, err = m.mapper.PeerChangedResponse(ctx, m.req, m.node, make(map[types.NodeID]bool), update.ChangePatches, m.h.policyFor(m.node), lastMessage)
 				updateType = "remove"
 			case types.StateDERPUpdated:
 				m.tracef("Sending DERPUpdate MapResponse")
-				data, err = m.mapper.DERPMapResponse(m.req, m.node, m.h.DERPMap)
+				data, err = m.mapper.DERPMapResponse(m.req, m.node, m.h.derpMap.Load(), m.h.policyFor(m.node))
 				updateType = "derp"
+			case types.StatePingRequest:
+				m.tracef("Sending PingRequest MapResponse")
+				data, err = m.mapper.PingRequestResponse(m.req, m.node, update.PingRequest)
+				updateType = "ping"
 			}
 
 			if err != nil {
@@ -348,21 +391,14 @@ func (m *mapSession) serveLongPoll() {
 			// Only send update if there is change
 			if data != nil {
 				startWrite := time.Now()
-				_, err = m.w.Write(data)
+				err = m.writeResponse(rc, data)
 				if err != nil {
 					mapResponseSent.WithLabelValues("error", updateType).Inc()
 					m.errf(err, "could not write the map response(%s), for mapSession: %p", update.Type.String(), m)
 					return
 				}
 
-				err = rc.Flush()
-				if err != nil {
-					mapResponseSent.WithLabelValues("error", updateType).Inc()
-					m.errf(err, "flushing the map response to client, for mapSession: %p", m)
-					return
-				}
-
-				log.Trace().Str("node", m.node.Hostname).TimeDiff("timeSpent", time.Now(), startWrite).Str("mkey", m.node.MachineKey.String()).Msg("finished writing mapresp to node")
+				mapperLog.Trace().Str("node", m.node.Hostname).TimeDiff("timeSpent", time.Now(), startWrite).Str("mkey", m.node.MachineKey.String()).Msg("finished writing mapresp to node")
 
 				if debugHighCardinalityMetrics {
 					mapResponseLastSentSeconds.WithLabelValues(updateType, m.node.ID.String()).Set(float64(time.Now().Unix()))
@@ -379,18 +415,12 @@ func (m *mapSession) serveLongPoll() {
 				mapResponseSent.WithLabelValues("error", "keepalive").Inc()
 				return
 			}
-			_, err = m.w.Write(data)
+			err = m.writeResponse(rc, data)
 			if err != nil {
 				m.errf(err, "Cannot write keep alive message")
 				mapResponseSent.WithLabelValues("error", "keepalive").Inc()
 				return
 			}
-			err = rc.Flush()
-			if err != nil {
-				m.errf(err, "flushing keep alive to client, for mapSession: %p", m)
-				mapResponseSent.WithLabelValues("error", "keepalive").Inc()
-				return
-			}
 
 			if debugHighCardinalityMetrics {
 				mapResponseLastSentSeconds.WithLabelValues("keepalive", m.node.ID.String()).Set(float64(time.Now().Unix()))
@@ -400,7 +430,76 @@ func (m *mapSession) serveLongPoll() {
 	}
 }
 
+// visiblePatches returns the patches about peers the node can see.
+// Patches of other nodes are dropped, like the online status of nodes
+// it cannot reach, so connects and disconnects only reach interested
+// peers.
+// softExpiryUpdate turns patches changing the expiry of nodes into a
+// change of the patched nodes, the packet filters must be sent again
+// when nodes are taken out of them or put back in. The other patches
+// of the batch are sent as changes of their nodes too.
+func softExpiryUpdate(update types.StateUpdate) types.StateUpdate {
+	if update.Type != types.StatePeerChangedPatch ||
+		!slices.ContainsFunc(update.ChangePatches, func(patch *tailcfg.PeerChange) bool {
+			return patch.KeyExpiry != nil
+		}) {
+		return update
+	}
+
+	changed := make([]types.NodeID, 0, len(update.ChangePatches))
+	for _, patch := range update.ChangePatches {
+		changed = append(changed, types.NodeID(patch.NodeID))
+	}
+
+	return types.StateUpdate{
+		Type:        types.StatePeerChanged,
+		ChangeNodes: changed,
+		Message:     "node expiry changed",
+	}
+}
+
+func (m *mapSession) visiblePatches(patches []*tailcfg.PeerChange) ([]*tailcfg.PeerChange, error) {
+	if m.visiblePeers == nil {
+		visible, err := m.mapper.VisiblePeers(m.node, m.h.policyFor(m.node))
+		if err != nil {
+			return nil, err
+		}
+		m.visiblePeers = visible
+	}
+
+	return slices.DeleteFunc(slices.Clone(patches), func(patch *tailcfg.PeerChange) bool {
+		return !m.visiblePeers.Contains(types.NodeID(patch.NodeID))
+	}), nil
+}
+
+// writeResponse writes and flushes a map response on the stream, and
+// records the node as seen once it has been delivered. A node that
+// does not take a response within a keep alive interval is considered
+// gone, the session is closed so its peers learn it is offline.
+func (m *mapSession) writeResponse(rc *http.ResponseController, data []byte) error {
+	rc.SetWriteDeadline(time.Now().Add(m.keepAlive))
+	// The deadline must not fire while the stream is idle.
+	defer rc.SetWriteDeadline(time.Time{})
+
+	if _, err := m.w.Write(data); err != nil {
+		return err
+	}
+
+	if err := rc.Flush(); err != nil {
+		return fmt.Errorf("flushing: %w", err)
+	}
+
+	m.h.nodeNotifier.Seen(m.node.ID)
+
+	return nil
+}
+
 func (m *mapSession) pollFailoverRoutes(where string, node *types.Node) {
+	// A replica serves the primary routes as the primary left them.
+	if m.h.isReadOnly() {
+		return
+	}
+
 	update, err := db.Write(m.h.db.DB, func(tx *gorm.DB) (*types.StateUpdate, error) {
 		return db.FailoverNodeRoutesIfNeccessary(tx, m.h.nodeNotifier.LikelyConnectedMap(), node)
 	})
@@ -425,7 +524,7 @@ func (h *Headscale) updateNodeOnlineStatus(online bool, node *types.Node) {
 		Online: &online,
 	}
 
-	if !online {
+	if !online && !h.isReadOnly() {
 		now := time.Now()
 
 		// lastSeen is only relevant if the node is disconnected.
@@ -436,7 +535,7 @@ func (h *Headscale) updateNodeOnlineStatus(online bool, node *types.Node) {
 			return db.SetLastSeen(tx, node.ID, *node.LastSeen)
 		})
 		if err != nil {
-			log.Error().Err(err).Msg("Cannot update node LastSeen")
+			mapperLog.Error().Err(err).Msg("Cannot update node LastSeen")
 
 			return
 		}
@@ -451,8 +550,105 @@ func (h *Headscale) updateNodeOnlineStatus(online bool, node *types.Node) {
 	}, node.ID)
 }
 
+// recordRemoteAddr stores the public address the node connected from.
+func (m *mapSession) recordRemoteAddr() {
+	if !m.remoteAddr.IsValid() || m.h.isReadOnly() {
+		return
+	}
+
+	addr := m.remoteAddr.Addr().Unmap()
+	if m.node.LastSeenAddr != nil && *m.node.LastSeenAddr == addr {
+		return
+	}
+
+	err := m.h.db.Write(func(tx *gorm.DB) error {
+		return db.SetLastSeenAddr(tx, m.node.ID, addr)
+	})
+	if err != nil {
+		m.errf(err, "Failed to store the address the node connected from")
+
+		return
+	}
+
+	// The node was last seen at the previous address when it
+	// disconnected, compare the two to detect impossible travel.
+	if m.h.cfg.AnomalyDetection.Enabled && m.node.LastSeenAddr != nil && m.node.LastSeen != nil {
+		m.h.checkImpossibleTravel(
+			m.node,
+			*m.node.LastSeenAddr,
+			*m.node.LastSeen,
+			addr,
+		)
+	}
+
+	m.node.LastSeenAddr = &addr
+}
+
+// rejectExitRoutes removes the exit routes from the Hostinfo sent by
+// the node if the policy does not allow it to advertise them.
+func (m *mapSession) rejectExitRoutes() {
+	if m.req.Hostinfo == nil {
+		return
+	}
+
+	isExitRoute := func(prefix netip.Prefix) bool {
+		return prefix == types.ExitRouteV4 || prefix == types.ExitRouteV6
+	}
+
+	if !slices.ContainsFunc(m.req.Hostinfo.RoutableIPs, isExitRoute) {
+		return
+	}
+
+	allowed, err := m.h.ACLPolicy.MayAdvertiseExitRoutes(m.node)
+	if err != nil {
+		m.errf(err, "Could not check if the node may advertise exit routes")
+	}
+	if allowed {
+		return
+	}
+
+	m.warnf("Rejecting exit routes, the node is not in exitNodeAdvertisers of the policy")
+	m.req.Hostinfo.RoutableIPs = slices.DeleteFunc(
+		slices.Clone(m.req.Hostinfo.RoutableIPs),
+		isExitRoute,
+	)
+}
+
+// recordNetcheckReport stores the netcheck report sent in the Hostinfo
+// of the node, if it differs from the last one.
+func (m *mapSession) recordNetcheckReport() {
+	if m.req.Hostinfo == nil || m.req.Hostinfo.NetInfo == nil || m.h.isReadOnly() {
+		return
+	}
+
+	netInfo := m.req.Hostinfo.NetInfo
+	if m.node.Hostinfo != nil && m.node.Hostinfo.NetInfo != nil {
+		old := m.node.Hostinfo.NetInfo
+		if old.BasicallyEqual(netInfo) && maps.Equal(old.DERPLatency, netInfo.DERPLatency) {
+			return
+		}
+	}
+
+	if err := m.h.db.AddNetcheckReport(m.node.ID, netInfo); err != nil {
+		m.errf(err, "Could not store netcheck report")
+	}
+}
+
 func (m *mapSession) handleEndpointUpdate() {
 	m.tracef("received endpoint update")
+	m.h.nodeNotifier.Seen(m.node.ID)
+
+	// A replica cannot store the update, the node sends its endpoints
+	// again once the primary is back.
+	if m.h.isReadOnly() {
+		m.w.WriteHeader(http.StatusOK)
+		mapResponseEndpointUpdates.WithLabelValues("read_only").Inc()
+
+		return
+	}
+
+	m.rejectExitRoutes()
+	m.recordNetcheckReport()
 
 	change := m.node.PeerChangeFromMapRequest(m.req)
 
@@ -462,6 +658,7 @@ func (m *mapSession) handleEndpointUpdate() {
 	m.node.ApplyPeerChange(&change)
 
 	sendUpdate, routesChanged := hostInfoChanged(m.node.Hostinfo, m.req.Hostinfo)
+	onlyNetInfo := onlyNetInfoChanged(m.node.Hostinfo, m.req.Hostinfo)
 
 	// The node might not set NetInfo if it has not changed and if
 	// the full HostInfo object is overrwritten, the information is lost.
@@ -503,13 +700,15 @@ func (m *mapSession) handleEndpointUpdate() {
 			return
 		}
 
-		if m.h.ACLPolicy != nil {
-			// update routes with peer information
-			err := m.h.db.EnableAutoApprovedRoutes(m.h.ACLPolicy, m.node)
-			if err != nil {
-				m.errf(err, "Error running auto approved routes")
-				mapResponseEndpointUpdates.WithLabelValues("error").Inc()
-			}
+		if err := m.h.withdrawStaleRoutes(); err != nil {
+			m.errf(err, "Error withdrawing stale routes")
+		}
+
+		// update routes with peer information
+		err = m.h.db.EnableAutoApprovedRoutes(m.h.ACLPolicy, m.node)
+		if err != nil {
+			m.errf(err, "Error running auto approved routes")
+			mapResponseEndpointUpdates.WithLabelValues("error").Inc()
 		}
 
 		// Send an update to the node itself with to ensure it
@@ -533,15 +732,25 @@ func (m *mapSession) handleEndpointUpdate() {
 		return
 	}
 
-	ctx := types.NotifyCtx(context.Background(), "poll-nodeupdate-peers-patch", m.node.Hostname)
-	m.h.nodeNotifier.NotifyWithIgnore(
-		ctx,
-		types.StateUpdate{
-			Type:        types.StatePeerChanged,
-			ChangeNodes: []types.NodeID{m.node.ID},
-			Message:     "called from handlePoll -> update",
-		},
-		m.node.ID)
+	update := types.StateUpdate{
+		Type:        types.StatePeerChanged,
+		ChangeNodes: []types.NodeID{m.node.ID},
+		Message:     "called from handlePoll -> update",
+	}
+
+	// Roaming clients mostly report new endpoints and DERP home
+	// regions, peers get those as patches instead of the whole node.
+	if !sendUpdate && onlyNetInfo {
+		update = types.StateUpdate{
+			Type:          types.StatePeerChangedPatch,
+			ChangePatches: []*tailcfg.PeerChange{&change},
+		}
+	}
+
+	if update.Type != types.StatePeerChangedPatch || !peerPatchEmpty(change) {
+		ctx := types.NotifyCtx(context.Background(), "poll-nodeupdate-peers-patch", m.node.Hostname)
+		m.h.nodeNotifier.NotifyWithIgnore(ctx, update, m.node.ID)
+	}
 
 	m.w.WriteHeader(http.StatusOK)
 	mapResponseEndpointUpdates.WithLabelValues("ok").Inc()
@@ -557,6 +766,13 @@ func (m *mapSession) handleEndpointUpdate() {
 func (m *mapSession) handleSaveNode() error {
 	m.tracef("saving node update from stream session")
 
+	if m.h.isReadOnly() {
+		return nil
+	}
+
+	m.rejectExitRoutes()
+	m.recordNetcheckReport()
+
 	change := m.node.PeerChangeFromMapRequest(m.req)
 
 	// A stream is being set up, the node is Online
@@ -589,12 +805,14 @@ func (m *mapSession) handleSaveNode() error {
 			return err
 		}
 
-		if m.h.ACLPolicy != nil {
-			// update routes with peer information
-			err := m.h.db.EnableAutoApprovedRoutes(m.h.ACLPolicy, m.node)
-			if err != nil {
-				return err
-			}
+		if err := m.h.withdrawStaleRoutes(); err != nil {
+			return err
+		}
+
+		// update routes with peer information
+		err = m.h.db.EnableAutoApprovedRoutes(m.h.ACLPolicy, m.node)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -618,7 +836,7 @@ func (m *mapSession) handleSaveNode() error {
 func (m *mapSession) handleReadOnlyRequest() {
 	m.tracef("Client asked for a lite update, responding without peers")
 
-	mapResp, err := m.mapper.ReadOnlyMapResponse(m.req, m.node, m.h.ACLPolicy)
+	mapResp, err := m.mapper.ReadOnlyMapResponse(m.req, m.node, m.h.policyFor(m.node))
 	if err != nil {
 		m.errf(err, "Failed to create MapResponse")
 		http.Error(m.w, "", http.StatusInternalServerError)
@@ -642,7 +860,7 @@ func (m *mapSession) handleReadOnlyRequest() {
 }
 
 func logTracePeerChange(hostname string, hostinfoChange bool, change *tailcfg.PeerChange) {
-	trace := log.Trace().Uint64("node.id", uint64(change.NodeID)).Str("hostname", hostname)
+	trace := mapperLog.Trace().Uint64("node.id", uint64(change.NodeID)).Str("hostname", hostname)
 
 	if change.Key != nil {
 		trace = trace.Str("node_key", change.Key.ShortString())
@@ -686,48 +904,63 @@ func peerChangeEmpty(chng tailcfg.PeerChange) bool {
 		chng.KeyExpiry == nil
 }
 
+// peerPatchEmpty reports if the patch has nothing peers need to know,
+// as the online status and last seen time of the node are sent by the
+// poll session.
+func peerPatchEmpty(chng tailcfg.PeerChange) bool {
+	return chng.Key == nil &&
+		chng.DiscoKey == nil &&
+		chng.Endpoints == nil &&
+		chng.DERPRegion == 0
+}
+
 func logPollFunc(
 	mapRequest tailcfg.MapRequest,
 	node *types.Node,
+	remoteAddr netip.AddrPort,
 ) (func(string, ...any), func(string, ...any), func(string, ...any), func(error, string, ...any)) {
 	return func(msg string, a ...any) {
-			log.Warn().
+			mapperLog.Warn().
 				Caller().
 				Bool("readOnly", mapRequest.ReadOnly).
 				Bool("omitPeers", mapRequest.OmitPeers).
 				Bool("stream", mapRequest.Stream).
 				Uint64("node.id", node.ID.Uint64()).
 				Str("node", node.Hostname).
+				Stringer("remote_addr", remoteAddr).
 				Msgf(msg, a...)
 		},
 		func(msg string, a ...any) {
-			log.Info().
+			mapperLog.Info().
 				Caller().
 				Bool("readOnly", mapRequest.ReadOnly).
 				Bool("omitPeers", mapRequest.OmitPeers).
 				Bool("stream", mapRequest.Stream).
 				Uint64("node.id", node.ID.Uint64()).
 				Str("node", node.Hostname).
+				Stringer("remote_addr", remoteAddr).
 				Msgf(msg, a...)
 		},
 		func(msg string, a ...any) {
-			log.Trace().
+			mapperLog.Trace().
 				Caller().
 				Bool("readOnly", mapRequest.ReadOnly).
 				Bool("omitPeers", mapRequest.OmitPeers).
 				Bool("stream", mapRequest.Stream).
 				Uint64("node.id", node.ID.Uint64()).
 				Str("node", node.Hostname).
+				Stringer("remote_addr", remoteAddr).
 				Msgf(msg, a...)
 		},
 		func(err error, msg string, a ...any) {
-			log.Error().
+			mapperLog.Error().
 				Caller().
 				Bool("readOnly", mapRequest.ReadOnly).
 				Bool("omitPeers", mapRequest.OmitPeers).
 				Bool("stream", mapRequest.Stream).
 				Uint64("node.id", node.ID.Uint64()).
 				Str("node", node.Hostname).
+				Stringer("remote_addr", remoteAddr).
 				Err(err).
 				Msgf(msg, a...)
 		}
@@ -770,3 +1003,17 @@ func hostInfoChanged(old, new *tailcfg.Hostinfo) (bool, bool) {
 
 	return false, false
 }
+
+// onlyNetInfoChanged reports if the hostinfo of a node is the same
+// except for the NetInfo, whose changes peers receive as patches. A
+// nil NetInfo in the new hostinfo means it has not changed.
+func onlyNetInfoChanged(old, new *tailcfg.Hostinfo) bool {
+	if old == nil || new == nil {
+		return old == new
+	}
+
+	oldClone, newClone := old.Clone(), new.Clone()
+	oldClone.NetInfo, newClone.NetInfo = nil, nil
+
+	return oldClone.Equal(newClone)
+}
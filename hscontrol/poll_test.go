@@ -0,0 +1,156 @@
+package hscontrol
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/mapper"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+func TestVisiblePatches(t *testing.T) {
+	cfg := &types.Config{}
+	h, _ := newTestAPIServer(t, cfg)
+	h.ACLPolicy = &policy.ACLPolicy{
+		ACLs: []policy.ACL{
+			{Action: "accept", Sources: []string{"alice"}, Destinations: []string{"alice:*"}},
+		},
+	}
+
+	nodes, err := db.Write(h.db.DB, func(tx *gorm.DB) (types.Nodes, error) {
+		var nodes types.Nodes
+		for index, owner := range []string{"alice", "alice", "bob"} {
+			user, err := db.GetUser(tx, owner)
+			if err != nil {
+				user, err = db.CreateUser(tx, owner)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			ip := netip.AddrFrom4([4]byte{100, 64, 0, byte(index + 1)})
+			node := &types.Node{
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				Hostname:       owner,
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+				IPv4:           &ip,
+			}
+			if err := tx.Save(node).Error; err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+
+		return nodes, nil
+	})
+	if err != nil {
+		t.Fatalf("creating nodes: %s", err)
+	}
+
+	// Sessions work on nodes loaded with their user.
+	node, err := h.db.GetNodeByID(nodes[0].ID)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+
+	m := &mapSession{
+		h:      h,
+		node:   node,
+		mapper: mapper.NewMapper(h.db, cfg, nil, h.nodeNotifier),
+	}
+
+	online := true
+	patches := []*tailcfg.PeerChange{
+		{NodeID: nodes[0].ID.NodeID(), Online: &online},
+		{NodeID: nodes[1].ID.NodeID(), Online: &online},
+		{NodeID: nodes[2].ID.NodeID(), Online: &online},
+	}
+
+	got, err := m.visiblePatches(patches)
+	if err != nil {
+		t.Fatalf("visiblePatches() error = %s", err)
+	}
+
+	// The node itself and the node of bob it cannot reach are dropped.
+	want := []*tailcfg.PeerChange{patches[1]}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("visiblePatches() mismatch (-want +got):\n%s", diff)
+	}
+	if len(patches) != 3 {
+		t.Errorf("visiblePatches() changed the shared patches")
+	}
+}
+
+func TestOnlyNetInfoChanged(t *testing.T) {
+	old := &tailcfg.Hostinfo{
+		Hostname: "laptop",
+		NetInfo:  &tailcfg.NetInfo{PreferredDERP: 1},
+	}
+
+	tests := []struct {
+		name string
+		new  *tailcfg.Hostinfo
+		want bool
+	}{
+		{
+			name: "derp-changed",
+			new:  &tailcfg.Hostinfo{Hostname: "laptop", NetInfo: &tailcfg.NetInfo{PreferredDERP: 2}},
+			want: true,
+		},
+		{
+			name: "netinfo-omitted",
+			new:  &tailcfg.Hostinfo{Hostname: "laptop"},
+			want: true,
+		},
+		{
+			name: "hostname-changed",
+			new:  &tailcfg.Hostinfo{Hostname: "desktop", NetInfo: &tailcfg.NetInfo{PreferredDERP: 2}},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := onlyNetInfoChanged(old, tt.new); got != tt.want {
+				t.Errorf("onlyNetInfoChanged() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftExpiryUpdate(t *testing.T) {
+	expiry := time.Now()
+	online := true
+
+	update := types.StateUpdate{
+		Type: types.StatePeerChangedPatch,
+		ChangePatches: []*tailcfg.PeerChange{
+			{NodeID: 1, KeyExpiry: &expiry},
+			{NodeID: 2, Online: &online},
+		},
+	}
+	want := types.StateUpdate{
+		Type:        types.StatePeerChanged,
+		ChangeNodes: []types.NodeID{1, 2},
+		Message:     "node expiry changed",
+	}
+	if diff := cmp.Diff(want, softExpiryUpdate(update)); diff != "" {
+		t.Errorf("softExpiryUpdate() mismatch (-want +got):\n%s", diff)
+	}
+
+	// Patches not changing any expiry are sent as they are.
+	update.ChangePatches = update.ChangePatches[1:]
+	if diff := cmp.Diff(update, softExpiryUpdate(update)); diff != "" {
+		t.Errorf("softExpiryUpdate() mismatch (-want +got):\n%s", diff)
+	}
+}
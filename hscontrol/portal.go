@@ -0,0 +1,735 @@
+package hscontrol
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	portalSessionCookie      = "headscale_portal"
+	portalSessionCachePrefix = "portal-session-"
+	portalTokenLength        = 32
+)
+
+var (
+	errPortalNotSignedIn      = errors.New("not signed in")
+	errPortalNodeNotOwned     = errors.New("node not found")
+	errPortalKeysDisabled     = errors.New("creating pre-auth keys is disabled")
+	errPortalKeyQuotaExceeded = errors.New("too many usable pre-auth keys, wait for one to expire")
+	errPortalKeyExpiration    = errors.New("invalid pre-auth key expiration")
+	errPortalReusableDisabled = errors.New("reusable pre-auth keys are disabled")
+)
+
+// portalSession is stored in the registration cache under the token of
+// the session cookie while the user is signed in to the portal.
+type portalSession struct {
+	UserName string
+
+	// CSRF is sent back with every form, so other sites cannot make
+	// the browser of the user change anything.
+	CSRF string
+}
+
+type portalTemplateConfig struct {
+	Error   string
+	Message string
+
+	// Sign in
+	LocalAuth bool
+	AskTOTP   bool
+	Providers []string
+
+	// Signed in
+	ServerURL      string
+	User           string
+	CSRF           string
+	Nodes          []portalNode
+	PreAuthKeys    []portalPreAuthKey
+	NewPreAuthKey  string
+	MaxPreAuthKeys int
+	MaxExpiration  string
+	AllowReusable  bool
+}
+
+type portalNode struct {
+	ID        uint64
+	Name      string
+	Hostname  string
+	Addresses string
+	Online    bool
+	LastSeen  string
+	Expiry    string
+	Expired   bool
+}
+
+type portalPreAuthKey struct {
+	Prefix     string
+	Reusable   bool
+	Used       bool
+	Expiration string
+}
+
+var portalTemplate = template.Must(
+	template.New("portal").Parse(`
+<html>
+	<head>
+		<title>Devices - Headscale</title>
+		<meta name=viewport content="width=device-width, initial-scale=1">
+		<style>
+			body {
+				font-family: sans;
+			}
+			table {
+				border-collapse: collapse;
+			}
+			td, th {
+				padding: 4px 8px;
+				border-bottom: 1px solid #bbb;
+				text-align: left;
+			}
+			form {
+				display: inline;
+			}
+			code {
+				display: block;
+				padding: 20px;
+				border: 1px solid #bbb;
+				background-color: #eee;
+			}
+		</style>
+	</head>
+	<body>
+		<h1>headscale</h1>
+		{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+		{{if .Message}}<p>{{.Message}}</p>{{end}}
+		{{if not .User}}
+		<h2>Sign in</h2>
+		{{if .LocalAuth}}
+		<form method="post" action="/portal/login">
+			<p>
+				<label for="username">Username</label>
+				<input type="text" id="username" name="username" autocomplete="username" required>
+			</p>
+			<p>
+				<label for="password">Password</label>
+				<input type="password" id="password" name="password" autocomplete="current-password" required>
+			</p>
+			<p>
+				<label for="totp">One-time code{{if not .AskTOTP}} (if enabled){{end}}</label>
+				<input type="text" id="totp" name="totp" inputmode="numeric" autocomplete="one-time-code"{{if .AskTOTP}} required{{end}}>
+			</p>
+			<button type="submit">Sign in</button>
+		</form>
+		{{end}}
+		{{range .Providers}}<p><a href="/portal/oidc?provider={{.}}">Sign in with {{.}}</a></p>{{end}}
+		{{else}}
+		<p>
+			Signed in as {{.User}}.
+			<form method="post" action="/portal/logout">
+				<input type="hidden" name="csrf" value="{{.CSRF}}">
+				<button type="submit">Sign out</button>
+			</form>
+		</p>
+		<h2>Devices</h2>
+		<table>
+			<tr><th>Name</th><th>Hostname</th><th>Addresses</th><th>Last seen</th><th>Expiry</th><th></th></tr>
+			{{range .Nodes}}
+			<tr>
+				<td>
+					<form method="post" action="/portal/nodes/{{.ID}}/rename">
+						<input type="hidden" name="csrf" value="{{$.CSRF}}">
+						<input type="text" name="name" value="{{.Name}}" required>
+						<button type="submit">Rename</button>
+					</form>
+				</td>
+				<td>{{.Hostname}}</td>
+				<td>{{.Addresses}}</td>
+				<td>{{if .Online}}online{{else}}{{.LastSeen}}{{end}}</td>
+				<td>{{if .Expired}}expired{{else}}{{.Expiry}}{{end}}</td>
+				<td>
+					{{if not .Expired}}
+					<form method="post" action="/portal/nodes/{{.ID}}/expire">
+						<input type="hidden" name="csrf" value="{{$.CSRF}}">
+						<button type="submit">Expire</button>
+					</form>
+					{{end}}
+				</td>
+			</tr>
+			{{else}}
+			<tr><td colspan="6">No devices yet.</td></tr>
+			{{end}}
+		</table>
+		{{if .MaxPreAuthKeys}}
+		<h2>Pre-auth keys</h2>
+		{{if .NewPreAuthKey}}
+		<p>Your new key, it is only shown once:</p>
+		<code>tailscale up --login-server {{.ServerURL}} --auth-key {{.NewPreAuthKey}}</code>
+		{{end}}
+		<table>
+			<tr><th>Key</th><th>Reusable</th><th>Expiration</th></tr>
+			{{range .PreAuthKeys}}
+			<tr><td>{{.Prefix}}…</td><td>{{if .Reusable}}yes{{else if .Used}}used{{else}}no{{end}}</td><td>{{.Expiration}}</td></tr>
+			{{end}}
+		</table>
+		<p>You can have up to {{.MaxPreAuthKeys}} usable keys, valid for at most {{.MaxExpiration}}.</p>
+		<form method="post" action="/portal/preauthkeys">
+			<input type="hidden" name="csrf" value="{{.CSRF}}">
+			<label for="expiration">Expiration</label>
+			<input type="text" id="expiration" name="expiration" value="{{.MaxExpiration}}" required>
+			{{if .AllowReusable}}
+			<label><input type="checkbox" name="reusable" value="true"> Reusable</label>
+			{{end}}
+			<button type="submit">Create key</button>
+		</form>
+		{{end}}
+		{{end}}
+	</body>
+</html>
+`))
+
+// PortalHandler shows the devices and pre-auth keys of the signed in
+// user, or the sign in page.
+// Listens in GET /portal.
+func (h *Headscale) PortalHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	session, err := h.portalSession(req)
+	if err != nil {
+		h.renderPortalSignIn(writer, http.StatusOK, "")
+
+		return
+	}
+
+	h.renderPortal(writer, http.StatusOK, session, portalTemplateConfig{})
+}
+
+// PortalLogin signs the user in to the portal with the built-in
+// username and password authentication, or with LDAP.
+// Listens in POST /portal/login.
+func (h *Headscale) PortalLogin(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	if !h.passwordAuthEnabled() {
+		http.Error(writer, "built-in authentication is disabled", http.StatusNotFound)
+
+		return
+	}
+
+	userName := req.PostFormValue("username")
+	user, _, err := h.authenticateLocalUser(
+		userName,
+		req.PostFormValue("password"),
+		req.PostFormValue("totp"),
+		time.Now(),
+		clientAddr(req),
+	)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("user", userName).
+			Str("client_addr", clientAddr(req)).
+			Msg("Portal sign in failed")
+
+		h.renderPortalSignIn(writer, http.StatusUnauthorized, errLocalAuthFailed.Error())
+
+		return
+	}
+
+	h.startPortalSession(writer, req, user)
+}
+
+// PortalOIDC redirects to the OIDC provider to sign in to the portal.
+// Listens in GET /portal/oidc.
+func (h *Headscale) PortalOIDC(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	provider, ok := h.selectOIDCProvider(req)
+	if !ok {
+		http.Redirect(writer, req, "/portal", http.StatusFound)
+
+		return
+	}
+
+	h.redirectToOIDCProvider(writer, req, provider, oidcRegistration{
+		Provider: provider.cfg.Name,
+		Portal:   true,
+	})
+}
+
+// completePortalOIDCLogin signs the user of a verified ID token in to
+// the portal.
+func (h *Headscale) completePortalOIDCLogin(
+	writer http.ResponseWriter,
+	req *http.Request,
+	provider *oidcProvider,
+	idToken *oidc.IDToken,
+) {
+	claims, err := h.authorizeOIDCClaims(writer, provider, idToken)
+	if err != nil {
+		return
+	}
+
+	userName, err := getUserName(writer, claims, h.cfg.OIDC.StripEmaildomain)
+	if err != nil {
+		return
+	}
+
+	user, err := h.findOrCreateNewUserForOIDCCallback(writer, userName)
+	if err != nil {
+		return
+	}
+
+	h.startPortalSession(writer, req, user)
+}
+
+// PortalLogout signs the user out of the portal.
+// Listens in POST /portal/logout.
+func (h *Headscale) PortalLogout(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	if _, ok := h.portalAuthorize(writer, req); !ok {
+		return
+	}
+
+	if cookie, err := req.Cookie(portalSessionCookie); err == nil {
+		h.registrationCache.Delete(portalSessionCachePrefix + cookie.Value)
+	}
+
+	h.setPortalCookie(writer, "", -1)
+	http.Redirect(writer, req, "/portal", http.StatusSeeOther)
+}
+
+// PortalRenameNode renames a node of the signed in user.
+// Listens in POST /portal/nodes/:id/rename.
+func (h *Headscale) PortalRenameNode(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	session, ok := h.portalAuthorize(writer, req)
+	if !ok {
+		return
+	}
+
+	node, err := h.portalNode(session, mux.Vars(req)["id"])
+	if err != nil {
+		h.renderPortal(writer, http.StatusNotFound, session, portalTemplateConfig{Error: err.Error()})
+
+		return
+	}
+
+	name := strings.TrimSpace(req.PostFormValue("name"))
+	_, err = newHeadscaleV1APIServer(h).RenameNode(req.Context(), &v1.RenameNodeRequest{
+		NodeId:  node.ID.Uint64(),
+		NewName: name,
+	})
+	if err != nil {
+		h.renderPortal(writer, http.StatusBadRequest, session, portalTemplateConfig{
+			Error: fmt.Sprintf("Cannot rename %s: %s", node.GivenName, status.Convert(err).Message()),
+		})
+
+		return
+	}
+
+	log.Info().
+		Str("user", session.UserName).
+		Str("node", node.GivenName).
+		Str("new_name", name).
+		Msg("Node renamed in the portal")
+
+	h.renderPortal(writer, http.StatusOK, session, portalTemplateConfig{
+		Message: fmt.Sprintf("%s renamed to %s.", node.GivenName, name),
+	})
+}
+
+// PortalExpireNode expires a node of the signed in user, it has to log
+// in again.
+// Listens in POST /portal/nodes/:id/expire.
+func (h *Headscale) PortalExpireNode(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	session, ok := h.portalAuthorize(writer, req)
+	if !ok {
+		return
+	}
+
+	node, err := h.portalNode(session, mux.Vars(req)["id"])
+	if err != nil {
+		h.renderPortal(writer, http.StatusNotFound, session, portalTemplateConfig{Error: err.Error()})
+
+		return
+	}
+
+	_, err = newHeadscaleV1APIServer(h).ExpireNode(req.Context(), &v1.ExpireNodeRequest{
+		NodeId: node.ID.Uint64(),
+	})
+	if err != nil {
+		util.LogErr(err, "could not expire node")
+		http.Error(writer, "could not expire node", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Info().
+		Str("user", session.UserName).
+		Str("node", node.GivenName).
+		Msg("Node expired in the portal")
+
+	h.renderPortal(writer, http.StatusOK, session, portalTemplateConfig{
+		Message: node.GivenName + " expired, it has to log in again.",
+	})
+}
+
+// PortalCreatePreAuthKey creates a personal pre-auth key, without
+// tags, for the signed in user within the quotas of the configuration.
+// Listens in POST /portal/preauthkeys.
+func (h *Headscale) PortalCreatePreAuthKey(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	session, ok := h.portalAuthorize(writer, req)
+	if !ok {
+		return
+	}
+
+	expiration, err := time.ParseDuration(req.PostFormValue("expiration"))
+	if err != nil || expiration <= 0 || expiration > h.cfg.Portal.MaxPreAuthKeyExpiration {
+		err = fmt.Errorf("%w, it must be a duration like 1h of at most %s",
+			errPortalKeyExpiration, h.cfg.Portal.MaxPreAuthKeyExpiration)
+		h.renderPortal(writer, http.StatusBadRequest, session, portalTemplateConfig{Error: err.Error()})
+
+		return
+	}
+
+	reusable := req.PostFormValue("reusable") == "true"
+
+	key, err := h.createPortalPreAuthKey(session.UserName, reusable, expiration, time.Now())
+	if err != nil {
+		if errors.Is(err, errPortalKeysDisabled) || errors.Is(err, errPortalKeyQuotaExceeded) ||
+			errors.Is(err, errPortalReusableDisabled) {
+			h.renderPortal(writer, http.StatusForbidden, session, portalTemplateConfig{Error: err.Error()})
+
+			return
+		}
+
+		util.LogErr(err, "could not create pre-auth key")
+		http.Error(writer, "could not create pre-auth key", http.StatusInternalServerError)
+
+		return
+	}
+
+	log.Info().
+		Str("user", session.UserName).
+		Bool("reusable", reusable).
+		Dur("expiration", expiration).
+		Msg("Pre-auth key created in the portal")
+
+	h.renderPortal(writer, http.StatusOK, session, portalTemplateConfig{NewPreAuthKey: key.Key})
+}
+
+// createPortalPreAuthKey creates a pre-auth key without tags for the
+// user, unless the user already has as many usable keys as allowed.
+func (h *Headscale) createPortalPreAuthKey(
+	userName string,
+	reusable bool,
+	expiration time.Duration,
+	now time.Time,
+) (*types.PreAuthKey, error) {
+	if h.cfg.Portal.MaxPreAuthKeys == 0 {
+		return nil, errPortalKeysDisabled
+	}
+	if reusable && !h.cfg.Portal.AllowReusablePreAuthKeys {
+		return nil, errPortalReusableDisabled
+	}
+
+	return db.Write(h.db.DB, func(tx *gorm.DB) (*types.PreAuthKey, error) {
+		keys, err := db.ListPreAuthKeys(tx, userName)
+		if err != nil {
+			return nil, err
+		}
+
+		usable := 0
+		for _, key := range keys {
+			if portalKeyUsable(key, now) {
+				usable++
+			}
+		}
+		if usable >= h.cfg.Portal.MaxPreAuthKeys {
+			return nil, errPortalKeyQuotaExceeded
+		}
+
+		expiry := now.Add(expiration)
+
+		return db.CreatePreAuthKey(tx, userName, reusable, false, &expiry, nil)
+	})
+}
+
+// portalKeyUsable reports if the key is a personal key, without tags,
+// that can still register nodes. Only those count against the quota.
+func portalKeyUsable(key types.PreAuthKey, now time.Time) bool {
+	if len(key.ACLTags) > 0 {
+		return false
+	}
+	if key.Expiration != nil && !key.Expiration.After(now) {
+		return false
+	}
+
+	return key.Reusable || !key.Used
+}
+
+// startPortalSession signs the user in to the portal and sends them to
+// their devices.
+func (h *Headscale) startPortalSession(
+	writer http.ResponseWriter,
+	req *http.Request,
+	user *types.User,
+) {
+	if user.IsSuspended() {
+		h.renderPortalSignIn(writer, http.StatusForbidden, db.ErrUserSuspended.Error())
+
+		return
+	}
+
+	token, err := util.GenerateRandomStringURLSafe(portalTokenLength)
+	if err != nil {
+		util.LogErr(err, "could not generate portal session")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	csrf, err := util.GenerateRandomStringURLSafe(portalTokenLength)
+	if err != nil {
+		util.LogErr(err, "could not generate portal session")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	h.registrationCache.Set(
+		portalSessionCachePrefix+token,
+		portalSession{UserName: user.Name, CSRF: csrf},
+		h.cfg.Portal.SessionExpiration,
+	)
+	h.setPortalCookie(writer, token, int(h.cfg.Portal.SessionExpiration.Seconds()))
+
+	log.Info().
+		Str("user", user.Name).
+		Msg("Signed in to the portal")
+
+	http.Redirect(writer, req, "/portal", http.StatusSeeOther)
+}
+
+func (h *Headscale) setPortalCookie(writer http.ResponseWriter, token string, maxAge int) {
+	http.SetCookie(writer, &http.Cookie{
+		Name:     portalSessionCookie,
+		Value:    token,
+		Path:     "/portal",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.cfg.ServerURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// portalSession returns the session of the portal cookie of the
+// request.
+func (h *Headscale) portalSession(req *http.Request) (*portalSession, error) {
+	cookie, err := req.Cookie(portalSessionCookie)
+	if err != nil || cookie.Value == "" {
+		return nil, errPortalNotSignedIn
+	}
+
+	sessionIf, ok := h.registrationCache.Get(portalSessionCachePrefix + cookie.Value)
+	if !ok {
+		return nil, errPortalNotSignedIn
+	}
+
+	session, ok := sessionIf.(portalSession)
+	if !ok {
+		return nil, errPortalNotSignedIn
+	}
+
+	return &session, nil
+}
+
+// portalAuthorize returns the session of a form sent from the portal,
+// checking its CSRF token and that the user still exists and is not
+// suspended. Otherwise it answers the request itself, ending the session
+// of a removed or suspended user.
+func (h *Headscale) portalAuthorize(
+	writer http.ResponseWriter,
+	req *http.Request,
+) (*portalSession, bool) {
+	session, err := h.portalSession(req)
+	if err != nil {
+		h.renderPortalSignIn(writer, http.StatusUnauthorized, "Your session has expired, sign in again.")
+
+		return nil, false
+	}
+
+	user, err := h.db.GetUser(session.UserName)
+	if err != nil || user.IsSuspended() {
+		if cookie, err := req.Cookie(portalSessionCookie); err == nil {
+			h.registrationCache.Delete(portalSessionCachePrefix + cookie.Value)
+		}
+		h.setPortalCookie(writer, "", -1)
+		h.renderPortalSignIn(writer, http.StatusUnauthorized, "Your session has expired, sign in again.")
+
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(req.PostFormValue("csrf")), []byte(session.CSRF)) != 1 {
+		http.Error(writer, "invalid form, reload the page", http.StatusForbidden)
+
+		return nil, false
+	}
+
+	return session, true
+}
+
+// portalNode returns the node with the ID if it belongs to the user of
+// the session.
+func (h *Headscale) portalNode(session *portalSession, idStr string) (*types.Node, error) {
+	id, err := strconv.ParseUint(idStr, util.Base10, 64)
+	if err != nil {
+		return nil, errPortalNodeNotOwned
+	}
+
+	node, err := h.db.GetNodeByID(types.NodeID(id))
+	if err != nil || node.User.Name != session.UserName {
+		return nil, errPortalNodeNotOwned
+	}
+
+	return node, nil
+}
+
+func (h *Headscale) renderPortalSignIn(writer http.ResponseWriter, status int, message string) {
+	config := portalTemplateConfig{
+		Error:     message,
+		LocalAuth: h.passwordAuthEnabled(),
+		AskTOTP:   h.cfg.LocalAuth.RequireTOTP,
+	}
+	for _, provider := range h.oidcProviders {
+		config.Providers = append(config.Providers, provider.cfg.Name)
+	}
+
+	h.writePortal(writer, status, config)
+}
+
+// renderPortal shows the devices and pre-auth keys of the user of the
+// session, with the message or error of config.
+func (h *Headscale) renderPortal(
+	writer http.ResponseWriter,
+	status int,
+	session *portalSession,
+	config portalTemplateConfig,
+) {
+	user, err := h.db.GetUser(session.UserName)
+	if err != nil || user.IsSuspended() {
+		h.renderPortalSignIn(writer, http.StatusUnauthorized, "Your session has expired, sign in again.")
+
+		return
+	}
+
+	now := time.Now()
+
+	nodes, err := db.Read(h.db.DB, func(rx *gorm.DB) (types.Nodes, error) {
+		return db.ListNodesByUser(rx, user.Name)
+	})
+	if err != nil {
+		util.LogErr(err, "could not list nodes")
+		http.Error(writer, "could not list nodes", http.StatusInternalServerError)
+
+		return
+	}
+
+	config.ServerURL = h.cfg.ServerURL
+	config.User = user.Name
+	config.CSRF = session.CSRF
+	for _, node := range nodes {
+		item := portalNode{
+			ID:        node.ID.Uint64(),
+			Name:      node.GivenName,
+			Hostname:  node.Hostname,
+			Addresses: strings.Join(node.IPsAsString(), ", "),
+			Online:    h.nodeNotifier.IsLikelyConnected(node.ID),
+			Expired:   node.IsExpired(),
+		}
+		if node.LastSeen != nil {
+			item.LastSeen = node.LastSeen.Format(time.DateTime)
+		}
+		if node.Expiry != nil && !node.Expiry.IsZero() {
+			item.Expiry = node.Expiry.Format(time.DateTime)
+		}
+		config.Nodes = append(config.Nodes, item)
+	}
+
+	config.MaxPreAuthKeys = h.cfg.Portal.MaxPreAuthKeys
+	config.MaxExpiration = h.cfg.Portal.MaxPreAuthKeyExpiration.String()
+	config.AllowReusable = h.cfg.Portal.AllowReusablePreAuthKeys
+	if config.MaxPreAuthKeys > 0 {
+		keys, err := h.db.ListPreAuthKeys(user.Name)
+		if err != nil {
+			util.LogErr(err, "could not list pre-auth keys")
+			http.Error(writer, "could not list pre-auth keys", http.StatusInternalServerError)
+
+			return
+		}
+
+		for _, key := range keys {
+			if !portalKeyUsable(key, now) {
+				continue
+			}
+
+			item := portalPreAuthKey{
+				Prefix:   key.Key[:min(len(key.Key), 8)],
+				Reusable: key.Reusable,
+				Used:     key.Used,
+			}
+			if key.Expiration != nil {
+				item.Expiration = key.Expiration.Format(time.DateTime)
+			}
+			config.PreAuthKeys = append(config.PreAuthKeys, item)
+		}
+	}
+
+	h.writePortal(writer, status, config)
+}
+
+func (h *Headscale) writePortal(writer http.ResponseWriter, status int, config portalTemplateConfig) {
+	var content bytes.Buffer
+	if err := portalTemplate.Execute(&content, config); err != nil {
+		util.LogErr(err, "Could not render portal template")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(status)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		util.LogErr(err, "Failed to write response")
+	}
+}
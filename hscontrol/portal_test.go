@@ -0,0 +1,294 @@
+package hscontrol
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/localauth"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/patrickmn/go-cache"
+	"gorm.io/gorm"
+	"tailscale.com/types/key"
+)
+
+func newTestPortal(t *testing.T) *Headscale {
+	t.Helper()
+
+	h, _ := newTestAPIServer(t, &types.Config{
+		ServerURL: "https://headscale.example.com",
+		LocalAuth: types.LocalAuthConfig{Enabled: true},
+		Portal: types.PortalConfig{
+			Enabled:                 true,
+			SessionExpiration:       time.Hour,
+			MaxPreAuthKeys:          2,
+			MaxPreAuthKeyExpiration: 24 * time.Hour,
+		},
+	})
+	h.registrationCache = cache.New(registerCacheExpiration, registerCacheCleanup)
+
+	hash, err := localauth.HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("HashPassword() error = %s", err)
+	}
+
+	err = h.db.Write(func(tx *gorm.DB) error {
+		for _, name := range []string{"alice", "bob"} {
+			user, err := db.CreateUser(tx, name)
+			if err != nil {
+				return err
+			}
+
+			node := types.Node{
+				MachineKey:     key.NewMachine().Public(),
+				NodeKey:        key.NewNode().Public(),
+				Hostname:       name + "-laptop",
+				GivenName:      name + "-laptop",
+				UserID:         user.ID,
+				RegisterMethod: util.RegisterMethodCLI,
+			}
+			if err := tx.Save(&node).Error; err != nil {
+				return err
+			}
+		}
+
+		_, err := db.SetUserPassword(tx, "alice", hash)
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("setting up users: %s", err)
+	}
+
+	return h
+}
+
+// portalSignIn signs alice in and returns the session cookie and the
+// CSRF token of the session.
+func portalSignIn(t *testing.T, h *Headscale) (*http.Cookie, string) {
+	t.Helper()
+
+	form := url.Values{"username": {"alice"}, "password": {"correct horse"}}
+	req := httptest.NewRequest(http.MethodPost, "/portal/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.PortalLogin(rec, req)
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("PortalLogin() status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != portalSessionCookie {
+		t.Fatalf("PortalLogin() cookies = %v, want a session cookie", cookies)
+	}
+	if !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Errorf("PortalLogin() cookie = %v, want HttpOnly and Secure", cookies[0])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/portal", nil)
+	req.AddCookie(cookies[0])
+	session, err := h.portalSession(req)
+	if err != nil {
+		t.Fatalf("portalSession() error = %s", err)
+	}
+
+	return cookies[0], session.CSRF
+}
+
+func portalPost(
+	h *Headscale,
+	handler http.HandlerFunc,
+	path string,
+	vars map[string]string,
+	cookie *http.Cookie,
+	form url.Values,
+) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	req = mux.SetURLVars(req, vars)
+
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	return rec
+}
+
+func TestPortalSignIn(t *testing.T) {
+	h := newTestPortal(t)
+
+	rec := httptest.NewRecorder()
+	h.PortalHandler(rec, httptest.NewRequest(http.MethodGet, "/portal", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `action="/portal/login"`) {
+		t.Errorf("PortalHandler() without session = %d, want the sign in page", rec.Code)
+	}
+
+	form := url.Values{"username": {"alice"}, "password": {"wrong"}}
+	rec = portalPost(h, h.PortalLogin, "/portal/login", nil, nil, form)
+	if rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("PortalLogin() with a wrong password = %d, want %d without cookie", rec.Code, http.StatusUnauthorized)
+	}
+
+	cookie, _ := portalSignIn(t, h)
+
+	req := httptest.NewRequest(http.MethodGet, "/portal", nil)
+	req.AddCookie(cookie)
+	rec = httptest.NewRecorder()
+	h.PortalHandler(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "alice-laptop") {
+		t.Errorf("PortalHandler() does not list the node of alice")
+	}
+	if strings.Contains(body, "bob-laptop") {
+		t.Errorf("PortalHandler() lists the node of another user")
+	}
+}
+
+func TestPortalNodes(t *testing.T) {
+	h := newTestPortal(t)
+	cookie, csrf := portalSignIn(t, h)
+
+	bobNode, err := h.db.GetNodeByID(2)
+	if err != nil || bobNode.User.Name != "bob" {
+		t.Fatalf("GetNodeByID(2) = %v, %v, want the node of bob", bobNode, err)
+	}
+
+	rename := func(id, name, token string) int {
+		return portalPost(h, h.PortalRenameNode, "/portal/nodes/"+id+"/rename",
+			map[string]string{"id": id}, cookie,
+			url.Values{"name": {name}, "csrf": {token}}).Code
+	}
+
+	if code := rename("1", "work-laptop", ""); code != http.StatusForbidden {
+		t.Errorf("rename without CSRF token = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := rename("2", "stolen", csrf); code != http.StatusNotFound {
+		t.Errorf("rename of the node of another user = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := rename("1", "work-laptop", csrf); code != http.StatusOK {
+		t.Errorf("rename = %d, want %d", code, http.StatusOK)
+	}
+
+	node, err := h.db.GetNodeByID(1)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if node.GivenName != "work-laptop" {
+		t.Errorf("GivenName = %q, want %q", node.GivenName, "work-laptop")
+	}
+
+	rec := portalPost(h, h.PortalExpireNode, "/portal/nodes/1/expire",
+		map[string]string{"id": "1"}, cookie, url.Values{"csrf": {csrf}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expire = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	node, err = h.db.GetNodeByID(1)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if !node.IsExpired() {
+		t.Errorf("node is not expired")
+	}
+}
+
+func TestPortalCreatePreAuthKey(t *testing.T) {
+	h := newTestPortal(t)
+	cookie, csrf := portalSignIn(t, h)
+
+	create := func(form url.Values) *httptest.ResponseRecorder {
+		form.Set("csrf", csrf)
+
+		return portalPost(h, h.PortalCreatePreAuthKey, "/portal/preauthkeys", nil, cookie, form)
+	}
+
+	if rec := create(url.Values{"expiration": {"48h"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expiration above the maximum = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := create(url.Values{"expiration": {"1h"}, "reusable": {"true"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("reusable key = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	for range h.cfg.Portal.MaxPreAuthKeys {
+		rec := create(url.Values{"expiration": {"1h"}})
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "--auth-key") {
+			t.Fatalf("create = %d, want %d with the new key", rec.Code, http.StatusOK)
+		}
+	}
+
+	if rec := create(url.Values{"expiration": {"1h"}}); rec.Code != http.StatusForbidden {
+		t.Errorf("create above the quota = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	keys, err := h.db.ListPreAuthKeys("alice")
+	if err != nil {
+		t.Fatalf("ListPreAuthKeys() error = %s", err)
+	}
+	if len(keys) != h.cfg.Portal.MaxPreAuthKeys {
+		t.Fatalf("len(keys) = %d, want %d", len(keys), h.cfg.Portal.MaxPreAuthKeys)
+	}
+	for _, key := range keys {
+		if key.Reusable || len(key.ACLTags) > 0 {
+			t.Errorf("key = %+v, want a single use key without tags", key)
+		}
+		if key.Expiration == nil || key.Expiration.After(time.Now().Add(time.Hour)) {
+			t.Errorf("key expiration = %v, want at most an hour", key.Expiration)
+		}
+	}
+
+	// Expired keys do not count against the quota.
+	if _, err := h.createPortalPreAuthKey("alice", false, time.Hour, time.Now().Add(2*time.Hour)); err != nil {
+		t.Errorf("createPortalPreAuthKey() after the keys expired error = %s", err)
+	}
+
+	h.cfg.Portal.MaxPreAuthKeys = 0
+	if _, err := h.createPortalPreAuthKey("alice", false, time.Hour, time.Now()); !errors.Is(err, errPortalKeysDisabled) {
+		t.Errorf("createPortalPreAuthKey() error = %v, want %v", err, errPortalKeysDisabled)
+	}
+}
+
+func TestPortalSuspendedUser(t *testing.T) {
+	h := newTestPortal(t)
+	cookie, csrf := portalSignIn(t, h)
+
+	err := h.db.Write(func(tx *gorm.DB) error {
+		_, err := db.SuspendUser(tx, "alice", time.Now())
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("SuspendUser() error = %s", err)
+	}
+
+	rec := portalPost(h, h.PortalRenameNode, "/portal/nodes/1/rename",
+		map[string]string{"id": "1"}, cookie,
+		url.Values{"name": {"work-laptop"}, "csrf": {csrf}})
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("rename by a suspended user = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	node, err := h.db.GetNodeByID(1)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+	if node.GivenName != "alice-laptop" {
+		t.Errorf("GivenName = %q, want %q", node.GivenName, "alice-laptop")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/portal", nil)
+	req.AddCookie(cookie)
+	if _, err := h.portalSession(req); !errors.Is(err, errPortalNotSignedIn) {
+		t.Errorf("portalSession() after suspension error = %v, want %v", err, errPortalNotSignedIn)
+	}
+}
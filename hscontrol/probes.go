@@ -0,0 +1,120 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// startupStep is a step headscale goes through before it can serve
+// nodes.
+type startupStep int
+
+const (
+	startupDatabase startupStep = iota
+	startupPolicy
+	startupDERPMap
+	startupListeners
+	startupSteps
+)
+
+var startupStepChecks = [startupSteps]struct {
+	name    string
+	pending string
+}{
+	startupDatabase:  {"startup:database", "the database is not migrated yet"},
+	startupPolicy:    {"startup:policy", "the policy is not compiled yet"},
+	startupDERPMap:   {"startup:derpMap", "the DERP map is not loaded yet"},
+	startupListeners: {"startup:listeners", "the listeners are not serving yet"},
+}
+
+// readiness tracks whether headscale finished starting and has not
+// started shutting down.
+type readiness struct {
+	done         [startupSteps]atomic.Bool
+	shuttingDown atomic.Bool
+}
+
+func (r *readiness) complete(step startupStep) {
+	r.done[step].Store(true)
+}
+
+// LiveHandler answers as long as headscale serves HTTP. Orchestrators
+// restart headscale when it fails, it does not depend on the database or
+// any other dependency that a restart would not fix.
+func (h *Headscale) LiveHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	writeProbe(writer, healthResponse{Status: healthPass}, http.StatusOK)
+}
+
+// ReadyHandler answers once headscale migrated the database, compiled
+// the policy, loaded the DERP map and serves on all its listeners, and
+// fails again as soon as it shuts down, so orchestrators only route
+// clients to instances able to serve them. A replica is only ready while
+// the primary is down.
+func (h *Headscale) ReadyHandler(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	res := healthResponse{
+		Status: healthPass,
+		Checks: make(map[string][]healthCheck),
+	}
+
+	now := time.Now()
+	for step, check := range startupStepChecks {
+		result := healthCheck{Status: healthPass, Time: now}
+		if !h.readiness.done[step].Load() {
+			result.Status = healthFail
+			result.Output = check.pending
+			res.Status = healthFail
+		}
+		res.Checks[check.name] = []healthCheck{result}
+	}
+
+	// A standing by replica is not ready, so clients are routed to
+	// the primary.
+	if h.isStandby() {
+		res.Status = healthFail
+		res.Checks["replica"] = []healthCheck{{
+			Status: healthFail,
+			Output: "the replica stands by while the primary is healthy",
+			Time:   now,
+		}}
+	}
+
+	if h.readiness.shuttingDown.Load() {
+		res.Status = healthFail
+		res.Checks["shutdown"] = []healthCheck{{
+			Status: healthFail,
+			Output: "headscale is shutting down",
+			Time:   now,
+		}}
+	}
+
+	code := http.StatusOK
+	if res.Status == healthFail {
+		code = http.StatusServiceUnavailable
+	}
+
+	writeProbe(writer, res, code)
+}
+
+func writeProbe(writer http.ResponseWriter, res healthResponse, code int) {
+	writer.Header().Set("Content-Type", "application/health+json; charset=utf-8")
+	writer.WriteHeader(code)
+
+	buf, err := json.Marshal(res)
+	if err != nil {
+		log.Error().Caller().Err(err).Msg("marshal failed")
+	}
+	_, err = writer.Write(buf)
+	if err != nil {
+		log.Error().Caller().Err(err).Msg("write failed")
+	}
+}
@@ -0,0 +1,64 @@
+package hscontrol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestReadyHandler(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{})
+
+	ready := func() (int, healthResponse) {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.ReadyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+		var res healthResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatalf("decoding ready response: %s", err)
+		}
+
+		return rec.Code, res
+	}
+
+	// Until every startup step is done clients must not be routed here.
+	h.readiness.complete(startupDatabase)
+	h.readiness.complete(startupPolicy)
+
+	code, res := ready()
+	if code != http.StatusServiceUnavailable || res.Status != healthFail {
+		t.Errorf("ready during startup = %d %q, want %d %q", code, res.Status, http.StatusServiceUnavailable, healthFail)
+	}
+	if got := res.Checks["startup:database"][0].Status; got != healthPass {
+		t.Errorf("database step = %q, want %q", got, healthPass)
+	}
+	if got := res.Checks["startup:derpMap"][0].Status; got != healthFail {
+		t.Errorf("DERP map step = %q, want %q", got, healthFail)
+	}
+
+	h.readiness.complete(startupDERPMap)
+	h.readiness.complete(startupListeners)
+
+	if code, res = ready(); code != http.StatusOK || res.Status != healthPass {
+		t.Errorf("ready after startup = %d %q, want %d %q", code, res.Status, http.StatusOK, healthPass)
+	}
+
+	// A shutting down instance stops taking clients but is still alive.
+	h.readiness.shuttingDown.Store(true)
+
+	code, res = ready()
+	if code != http.StatusServiceUnavailable || res.Checks["shutdown"][0].Status != healthFail {
+		t.Errorf("ready while shutting down = %d %+v, want %d with a shutdown failure", code, res.Checks["shutdown"], http.StatusServiceUnavailable)
+	}
+
+	rec := httptest.NewRecorder()
+	h.LiveHandler(rec, httptest.NewRequest(http.MethodGet, "/live", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("live while shutting down = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,78 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"runtime/trace"
+	"time"
+)
+
+const (
+	defaultProfileDuration = 30 * time.Second
+	maxProfileDuration     = 5 * time.Minute
+)
+
+var (
+	errUnknownProfile  = errors.New("unknown profile")
+	errProfileDuration = fmt.Errorf("profile duration must not exceed %s", maxProfileDuration)
+)
+
+// collectProfile collects the named runtime profile. The CPU profile
+// and the execution trace are recorded for the given duration, the
+// other profiles are a snapshot.
+func collectProfile(ctx context.Context, name string, duration time.Duration) ([]byte, error) {
+	if duration <= 0 {
+		duration = defaultProfileDuration
+	}
+	if duration > maxProfileDuration {
+		return nil, errProfileDuration
+	}
+
+	var buf bytes.Buffer
+
+	switch name {
+	case "cpu":
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			return nil, fmt.Errorf("starting CPU profile: %w", err)
+		}
+		sleepCtx(ctx, duration)
+		pprof.StopCPUProfile()
+
+	case "trace":
+		if err := trace.Start(&buf); err != nil {
+			return nil, fmt.Errorf("starting trace: %w", err)
+		}
+		sleepCtx(ctx, duration)
+		trace.Stop()
+
+	default:
+		profile := pprof.Lookup(name)
+		if profile == nil {
+			return nil, fmt.Errorf("%w: %q", errUnknownProfile, name)
+		}
+
+		if err := profile.WriteTo(&buf, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sleepCtx waits for the duration or until the context is done.
+func sleepCtx(ctx context.Context, duration time.Duration) {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
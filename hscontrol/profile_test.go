@@ -0,0 +1,81 @@
+package hscontrol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	grpcRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestCollectProfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  string
+		duration time.Duration
+		wantErr  error
+	}{
+		{name: "heap", profile: "heap"},
+		{name: "goroutine", profile: "goroutine"},
+		{name: "cpu", profile: "cpu", duration: 100 * time.Millisecond},
+		{name: "trace", profile: "trace", duration: 100 * time.Millisecond},
+		{name: "unknown", profile: "nope", wantErr: errUnknownProfile},
+		{name: "too-long", profile: "cpu", duration: time.Hour, wantErr: errProfileDuration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := collectProfile(context.Background(), tt.profile, tt.duration)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("collectProfile() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr == nil && len(data) == 0 {
+				t.Errorf("collectProfile() returned an empty profile")
+			}
+		})
+	}
+}
+
+func TestProfilingEndpointsRequireUnscopedAPIKey(t *testing.T) {
+	h, _ := newTestAPIServer(t, &types.Config{
+		Debug: types.DebugConfig{ProfilingEnabled: true},
+	})
+	router := h.createRouter(grpcRuntime.NewServeMux())
+
+	expiration := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		tags   []string
+		region int
+		want   int
+	}{
+		{name: "full", want: http.StatusOK},
+		{name: "tags", tags: []string{"tag:router"}, want: http.StatusForbidden},
+		{name: "derp-region", region: 900, want: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyStr, _, err := h.db.CreateAPIKey(&expiration, tt.tags, tt.region)
+			if err != nil {
+				t.Fatalf("CreateAPIKey() error = %s", err)
+			}
+
+			for _, path := range []string{"/debug/pprof/", "/debug/vars"} {
+				req := httptest.NewRequest(http.MethodGet, path, nil)
+				req.Header.Set("Authorization", AuthPrefix+keyStr)
+
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				if rec.Code != tt.want {
+					t.Errorf("GET %s: got status %d, want %d", path, rec.Code, tt.want)
+				}
+			}
+		})
+	}
+}
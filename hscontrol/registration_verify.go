@@ -0,0 +1,345 @@
+package hscontrol
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/types/key"
+)
+
+const (
+	registrationVerificationCachePrefix         = "verify-"
+	registrationVerificationAttemptsCachePrefix = "verify-attempts-"
+	registrationVerificationMaxAttempts         = 5
+)
+
+var errRegistrationVerificationEmail = errors.New(
+	"an email address is required to verify the registration",
+)
+
+// pendingRegistration is a node that is authenticated with OIDC and
+// waits for the registration to be verified.
+type pendingRegistration struct {
+	MachineKey key.MachinePublic
+	UserName   string
+	Claims     *IDTokenClaims
+	Expiry     time.Time
+}
+
+// requestRegistrationVerification holds back the registration of a new
+// node until the user follows the verification link, or enters the
+// code handed out by an admin.
+func (h *Headscale) requestRegistrationVerification(
+	writer http.ResponseWriter,
+	pending *pendingRegistration,
+) {
+	randomBlob := make([]byte, randomByteSize)
+	if _, err := rand.Read(randomBlob); err != nil {
+		util.LogErr(err, "could not read 16 bytes from rand")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+	token := hex.EncodeToString(randomBlob)
+
+	link := fmt.Sprintf(
+		"%s/register/verify/%s",
+		strings.TrimSuffix(h.cfg.ServerURL, "/"),
+		token,
+	)
+
+	// Links that could not be sent expire unused.
+	h.registrationCache.Set(
+		registrationVerificationCachePrefix+token,
+		pending,
+		registerCacheExpiration,
+	)
+
+	cfg := h.cfg.RegistrationVerification
+	switch cfg.Method {
+	case types.RegistrationVerificationCode:
+		writer.Header().Set("Location", link)
+		writer.WriteHeader(http.StatusSeeOther)
+
+		return
+
+	case types.RegistrationVerificationEmail:
+		if pending.Claims.Email == "" {
+			http.Error(writer, errRegistrationVerificationEmail.Error(), http.StatusForbidden)
+
+			return
+		}
+
+		if err := sendVerificationEmail(cfg.SMTP, pending.Claims.Email, link); err != nil {
+			util.LogErr(err, "Failed to send registration verification email")
+			http.Error(writer, "could not send verification email", http.StatusInternalServerError)
+
+			return
+		}
+
+	case types.RegistrationVerificationWebhook:
+		if err := sendVerificationWebhook(
+			context.Background(),
+			cfg.WebhookURL,
+			pending,
+			link,
+		); err != nil {
+			util.LogErr(err, "Failed to send registration verification webhook")
+			http.Error(writer, "could not send verification link", http.StatusInternalServerError)
+
+			return
+		}
+	}
+
+	log.Info().
+		Str("user", pending.UserName).
+		Str("machine_key", pending.MachineKey.ShortString()).
+		Str("method", cfg.Method).
+		Msg("Registration is waiting for verification")
+
+	renderRegistrationVerificationPage(writer, http.StatusOK, registrationVerificationTemplateConfig{
+		Sent: true,
+	})
+}
+
+// RegistrationVerification finishes a registration held back by
+// requestRegistrationVerification. The link only shows a confirmation
+// form, so link previews and mail scanners do not register the node.
+// Listens in /register/verify/:token.
+func (h *Headscale) RegistrationVerification(
+	writer http.ResponseWriter,
+	req *http.Request,
+) {
+	cacheKey := registrationVerificationCachePrefix + mux.Vars(req)["token"]
+
+	pendingIf, ok := h.registrationCache.Get(cacheKey)
+	if !ok {
+		http.Error(writer, "verification link has expired", http.StatusBadRequest)
+
+		return
+	}
+
+	pending, ok := pendingIf.(*pendingRegistration)
+	if !ok {
+		http.Error(writer, "verification link is invalid", http.StatusBadRequest)
+
+		return
+	}
+
+	cfg := h.cfg.RegistrationVerification
+	askCode := cfg.Method == types.RegistrationVerificationCode
+
+	if req.Method != http.MethodPost {
+		renderRegistrationVerificationPage(writer, http.StatusOK, registrationVerificationTemplateConfig{
+			AskCode: askCode,
+		})
+
+		return
+	}
+
+	if askCode {
+		// Codes are counted per user and before they are compared, so
+		// neither signing in again nor concurrent requests allow more
+		// than registrationVerificationMaxAttempts wrong codes.
+		attemptsKey := registrationVerificationAttemptsCachePrefix + pending.UserName
+		attempts := h.countAttempt(attemptsKey)
+
+		code := req.PostFormValue("code")
+		if attempts > registrationVerificationMaxAttempts ||
+			subtle.ConstantTimeCompare([]byte(code), []byte(cfg.Code)) != 1 {
+			if attempts >= registrationVerificationMaxAttempts {
+				h.registrationCache.Delete(cacheKey)
+			}
+
+			log.Warn().
+				Str("user", pending.UserName).
+				Str("machine_key", pending.MachineKey.ShortString()).
+				Int("attempts", attempts).
+				Msg("Wrong registration verification code")
+
+			renderRegistrationVerificationPage(writer, http.StatusForbidden, registrationVerificationTemplateConfig{
+				AskCode:   attempts < registrationVerificationMaxAttempts,
+				WrongCode: true,
+			})
+
+			return
+		}
+
+		h.registrationCache.Delete(attemptsKey)
+	}
+
+	h.registrationCache.Delete(cacheKey)
+
+	user, err := h.findOrCreateNewUserForOIDCCallback(writer, pending.UserName)
+	if err != nil {
+		return
+	}
+
+	if err := h.registerNodeForOIDCCallback(writer, user, &pending.MachineKey, pending.Expiry, pending.Claims); err != nil {
+		return
+	}
+
+	content, err := renderOIDCCallbackTemplate(writer, pending.Claims)
+	if err != nil {
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(http.StatusOK)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		util.LogErr(err, "Failed to write response")
+	}
+}
+
+// sendVerificationEmail emails the verification link to the user.
+func sendVerificationEmail(cfg types.SMTPConfig, to, link string) error {
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid email address %q", to)
+	}
+
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + cfg.From,
+		"To: " + to,
+		"Subject: Verify your new headscale node",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		"A new node is waiting to be registered to your account.",
+		"",
+		"Open the link below to finish registering it. If you did not",
+		"sign in to register a node, ignore this email.",
+		"",
+		link,
+		"",
+	}, "\r\n")
+
+	return smtp.SendMail(
+		net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)),
+		auth,
+		cfg.From,
+		[]string{to},
+		[]byte(msg),
+	)
+}
+
+// sendVerificationWebhook posts the verification link as JSON to url.
+func sendVerificationWebhook(
+	ctx context.Context,
+	url string,
+	pending *pendingRegistration,
+	link string,
+) error {
+	body, err := json.Marshal(map[string]string{
+		"type":        "registration_verification",
+		"user":        pending.UserName,
+		"email":       pending.Claims.Email,
+		"machine_key": pending.MachineKey.String(),
+		"link":        link,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, types.HTTPTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("verification webhook returned status %s", resp.Status)
+	}
+
+	return nil
+}
+
+type registrationVerificationTemplateConfig struct {
+	Sent      bool
+	AskCode   bool
+	WrongCode bool
+}
+
+var registrationVerificationTemplate = template.Must(
+	template.New("registrationverification").Parse(`
+<html>
+	<head>
+		<title>Verify registration - Headscale</title>
+		<meta name=viewport content="width=device-width, initial-scale=1">
+		<style>
+			body {
+				font-family: sans;
+			}
+		</style>
+	</head>
+	<body>
+		<h1>headscale</h1>
+		<h2>Verify registration</h2>
+		{{if .Sent}}
+		<p>A verification link was sent to you. Open it to finish registering the node.</p>
+		{{else}}
+		{{if .WrongCode}}<p>The verification code is wrong.</p>{{end}}
+		{{if or .AskCode (not .WrongCode)}}
+		<form method="post">
+			{{if .AskCode}}
+			<label for="code">Verification code from your administrator</label>
+			<input type="password" id="code" name="code" autocomplete="off" required>
+			{{end}}
+			<button type="submit">Register node</button>
+		</form>
+		{{else}}
+		<p>Too many wrong codes, sign in again to register the node.</p>
+		{{end}}
+		{{end}}
+	</body>
+</html>
+`))
+
+func renderRegistrationVerificationPage(
+	writer http.ResponseWriter,
+	status int,
+	config registrationVerificationTemplateConfig,
+) {
+	var content bytes.Buffer
+	if err := registrationVerificationTemplate.Execute(&content, config); err != nil {
+		util.LogErr(err, "Could not render registration verification template")
+		http.Error(writer, "Internal server error", http.StatusInternalServerError)
+
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.WriteHeader(status)
+	if _, err := writer.Write(content.Bytes()); err != nil {
+		util.LogErr(err, "Failed to write response")
+	}
+}
@@ -0,0 +1,91 @@
+package hscontrol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/patrickmn/go-cache"
+	"tailscale.com/types/key"
+)
+
+func TestRegistrationVerificationCode(t *testing.T) {
+	h := &Headscale{
+		cfg: &types.Config{
+			ServerURL: "https://headscale.example.com",
+			RegistrationVerification: types.RegistrationVerificationConfig{
+				Method: types.RegistrationVerificationCode,
+				Code:   "let-me-in",
+			},
+		},
+		registrationCache: cache.New(registerCacheExpiration, time.Minute),
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/register/verify/{token}", h.RegistrationVerification)
+
+	rec := httptest.NewRecorder()
+	h.requestRegistrationVerification(rec, &pendingRegistration{
+		MachineKey: key.NewMachine().Public(),
+		UserName:   "user1",
+		Claims:     &IDTokenClaims{Email: "user1@example.com"},
+	})
+
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("requestRegistrationVerification() status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+
+	link, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(link.Path, "/register/verify/") {
+		t.Fatalf("requestRegistrationVerification() location = %q", rec.Header().Get("Location"))
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link.Path, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="code"`) {
+		t.Fatalf("GET verification page = %d, want a code form", rec.Code)
+	}
+
+	for attempt := 1; attempt <= registrationVerificationMaxAttempts; attempt++ {
+		req := httptest.NewRequest(http.MethodPost, link.Path, strings.NewReader("code=wrong"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec = httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d with a wrong code = %d, want %d", attempt, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, link.Path, nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("verification after too many wrong codes = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Signing in again does not give the user more attempts.
+	rec = httptest.NewRecorder()
+	h.requestRegistrationVerification(rec, &pendingRegistration{
+		MachineKey: key.NewMachine().Public(),
+		UserName:   "user1",
+		Claims:     &IDTokenClaims{Email: "user1@example.com"},
+	})
+	link, err = url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("requestRegistrationVerification() location = %q", rec.Header().Get("Location"))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, link.Path, strings.NewReader("code=let-me-in"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("verification of a locked out user = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
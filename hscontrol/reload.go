@@ -0,0 +1,53 @@
+package hscontrol
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// reloadConfig rereads the configuration file and applies the settings
+// that can change while headscale runs: DNS, the DERP sources, the log
+// level and the OIDC allowlists. Connected nodes keep their long-poll
+// sessions and receive the new DNS settings and DERPMap. Everything else
+// needs a restart to take effect. If the file is invalid, the error is
+// returned and the running configuration is kept.
+func (h *Headscale) reloadConfig() error {
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	cfg, err := types.LoadServerConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	h.applyReloadedConfig(cfg)
+	h.warnFQDNCollisions()
+
+	log.Info().
+		Str("path", viper.ConfigFileUsed()).
+		Msg("Config reloaded, notifying nodes of change")
+
+	h.updateDERPMap("config-sighup")
+
+	ctx := types.NotifyCtx(context.Background(), "config-sighup", "na")
+	h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+		Type: types.StateFullUpdate,
+	})
+
+	return nil
+}
+
+// applyReloadedConfig publishes the settings of cfg that can be
+// reloaded. LoadServerConfig has already set the global log level.
+func (h *Headscale) applyReloadedConfig(cfg *types.Config) {
+	addMagicDNSRoutes(cfg)
+
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	h.cfg.SetReloadable(types.NewReloadableConfig(cfg))
+}
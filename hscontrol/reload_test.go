@@ -0,0 +1,67 @@
+package hscontrol
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/tailcfg"
+)
+
+func TestApplyReloadedConfig(t *testing.T) {
+	prefix := netip.MustParsePrefix("100.64.0.0/10")
+	h := &Headscale{
+		cfg: &types.Config{
+			ServerURL:  "https://headscale.example.com",
+			BaseDomain: "old.example.com",
+			PrefixV4:   &prefix,
+			DNSConfig:  &tailcfg.DNSConfig{Proxied: false},
+			OIDC: types.OIDCConfig{
+				Issuer:         "https://old.example.com",
+				AllowedDomains: []string{"old.example.com"},
+			},
+		},
+	}
+
+	h.applyReloadedConfig(&types.Config{
+		ServerURL:  "https://changed.example.com",
+		BaseDomain: "new.example.com",
+		PrefixV4:   &prefix,
+		DNSConfig: &tailcfg.DNSConfig{
+			Proxied: true,
+			Domains: []string{"new.example.com"},
+		},
+		OIDC: types.OIDCConfig{
+			Issuer:         "https://new.example.com",
+			AllowedDomains: []string{"new.example.com"},
+			AllowedUsers:   []string{"alice@new.example.com"},
+		},
+	})
+
+	reloaded := h.cfg.Reloadable()
+	if reloaded.BaseDomain != "new.example.com" {
+		t.Errorf("BaseDomain = %q, want reloaded", reloaded.BaseDomain)
+	}
+	if !reloaded.DNSConfig.Proxied {
+		t.Errorf("DNSConfig was not reloaded")
+	}
+	if _, ok := reloaded.DNSConfig.Routes["64.100.in-addr.arpa"]; !ok {
+		t.Errorf("DNSConfig.Routes = %v, want MagicDNS reverse zones", reloaded.DNSConfig.Routes)
+	}
+	if got := reloaded.OIDCAllowedUsers; len(got) != 1 || got[0] != "alice@new.example.com" {
+		t.Errorf("OIDC.AllowedUsers = %v, want reloaded", got)
+	}
+
+	// The running configuration is not changed, readers keep the
+	// settings they loaded before the reload.
+	if h.cfg.BaseDomain != "old.example.com" || h.cfg.DNSConfig.Proxied {
+		t.Errorf("running configuration was changed in place")
+	}
+
+	if h.cfg.ServerURL != "https://headscale.example.com" {
+		t.Errorf("ServerURL = %q, must not be reloaded", h.cfg.ServerURL)
+	}
+	if h.cfg.OIDC.Issuer != "https://old.example.com" {
+		t.Errorf("OIDC.Issuer = %q, must not be reloaded", h.cfg.OIDC.Issuer)
+	}
+}
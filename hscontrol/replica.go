@@ -0,0 +1,195 @@
+package hscontrol
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	replicaServing = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "replica_serving",
+		Help:      "1 if the replica serves nodes because the primary is down, else 0",
+	})
+	replicaPrimaryHealthy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: prometheusNamespace,
+		Name:      "replica_primary_healthy",
+		Help:      "1 if the last health check of the primary passed, else 0",
+	})
+	replicaFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: prometheusNamespace,
+		Name:      "replica_failovers_total",
+		Help:      "total count of the replica taking over from, and handing back to, the primary",
+	}, []string{"direction"})
+)
+
+// replica is the standby state of headscale running as a disaster
+// recovery replica. It stays in standby, refusing nodes, while the
+// primary is healthy and serves nodes read only once the primary failed
+// its health checks for FailoverAfter.
+type replica struct {
+	cfg    types.ReplicaConfig
+	client *http.Client
+
+	serving atomic.Bool
+
+	mu sync.Mutex
+	// lastHealthy is when the primary last passed a health check, the
+	// start of the replica counts as one.
+	lastHealthy time.Time
+}
+
+func newReplica(cfg types.ReplicaConfig) *replica {
+	return &replica{
+		cfg:         cfg,
+		client:      &http.Client{Timeout: cfg.HealthCheckInterval},
+		lastHealthy: time.Now(),
+	}
+}
+
+// observe records the result of a health check of the primary and
+// returns whether the replica started or stopped serving.
+func (r *replica) observe(healthy bool, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if healthy {
+		r.lastHealthy = now
+
+		return r.serving.CompareAndSwap(true, false)
+	}
+
+	if now.Sub(r.lastHealthy) < r.cfg.FailoverAfter {
+		return false
+	}
+
+	return r.serving.CompareAndSwap(false, true)
+}
+
+// checkPrimary returns whether the primary answers its health check.
+// A degraded primary still serves nodes and passes.
+func (r *replica) checkPrimary(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.cfg.PrimaryURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check of the primary returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// runReplica checks the health of the primary every health check
+// interval, taking over when it is down and handing the nodes back once
+// it is up again. While serving, the nodes are sent the state of the
+// database every refresh interval.
+func (h *Headscale) runReplica(ctx context.Context) {
+	cfg := h.cfg.Replica
+
+	healthTicker := time.NewTicker(cfg.HealthCheckInterval)
+	defer healthTicker.Stop()
+
+	refreshTicker := time.NewTicker(cfg.RefreshInterval)
+	defer refreshTicker.Stop()
+
+	log.Info().
+		Str("primary", cfg.PrimaryURL).
+		Msg("Running as a replica, standing by while the primary is healthy")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-healthTicker.C:
+			err := h.replica.checkPrimary(ctx)
+			if err != nil {
+				log.Debug().Err(err).Str("primary", cfg.PrimaryURL).Msg("Primary failed its health check")
+				replicaPrimaryHealthy.Set(0)
+			} else {
+				replicaPrimaryHealthy.Set(1)
+			}
+
+			if !h.replica.observe(err == nil, time.Now()) {
+				continue
+			}
+
+			if h.replica.serving.Load() {
+				log.Warn().
+					Err(err).
+					Str("primary", cfg.PrimaryURL).
+					Dur("down_for", cfg.FailoverAfter).
+					Msg("Primary is down, serving nodes read-only until it is back")
+				replicaServing.Set(1)
+				replicaFailovers.WithLabelValues("takeover").Inc()
+
+				continue
+			}
+
+			log.Info().
+				Str("primary", cfg.PrimaryURL).
+				Msg("Primary is back, handing the nodes back to it")
+			replicaServing.Set(0)
+			replicaFailovers.WithLabelValues("handback").Inc()
+
+			// Closing the sessions makes the nodes reconnect, and
+			// be routed to the primary.
+			h.nodeNotifier.DisconnectAll()
+
+		case <-refreshTicker.C:
+			if !h.replica.serving.Load() {
+				continue
+			}
+
+			notifyCtx := types.NotifyCtx(ctx, "replica-refresh", "na")
+			h.nodeNotifier.NotifyAll(notifyCtx, types.StateUpdate{
+				Type:    types.StateFullUpdate,
+				Message: "called from replica refresh",
+			})
+		}
+	}
+}
+
+// isStandby returns whether headscale is a replica that does not serve
+// nodes, as the primary is healthy.
+func (h *Headscale) isStandby() bool {
+	return h.replica != nil && !h.replica.serving.Load()
+}
+
+// isReadOnly returns whether headscale is a replica, which cannot store
+// what the nodes send.
+func (h *Headscale) isReadOnly() bool {
+	return h.replica != nil
+}
+
+// rejectWhileStandby refuses nodes while the replica stands by, so they
+// retry and are routed to the primary.
+func (h *Headscale) rejectWhileStandby(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, req *http.Request) {
+		if h.isStandby() {
+			writer.Header().Set("Retry-After", "10")
+			http.Error(writer, "replica standing by, the primary serves the nodes", http.StatusServiceUnavailable)
+
+			return
+		}
+
+		next(writer, req)
+	}
+}
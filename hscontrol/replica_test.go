@@ -0,0 +1,152 @@
+package hscontrol
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"tailscale.com/types/key"
+)
+
+func TestReplicaFailover(t *testing.T) {
+	start := time.Now()
+	r := newReplica(types.ReplicaConfig{
+		HealthCheckInterval: 10 * time.Second,
+		FailoverAfter:       time.Minute,
+	})
+	r.lastHealthy = start
+
+	steps := []struct {
+		name    string
+		healthy bool
+		after   time.Duration
+		changed bool
+		serving bool
+	}{
+		{"primary healthy", true, 10 * time.Second, false, false},
+		{"primary down briefly", false, 30 * time.Second, false, false},
+		{"primary down for failover_after", false, 70 * time.Second, true, true},
+		{"primary still down", false, 80 * time.Second, false, true},
+		{"primary back", true, 90 * time.Second, true, false},
+		{"primary down again", false, 100 * time.Second, false, false},
+	}
+
+	for _, step := range steps {
+		changed := r.observe(step.healthy, start.Add(step.after))
+		if changed != step.changed || r.serving.Load() != step.serving {
+			t.Errorf("%s: changed = %t, serving = %t, want %t, %t",
+				step.name, changed, r.serving.Load(), step.changed, step.serving)
+		}
+	}
+}
+
+func TestReplicaCheckPrimary(t *testing.T) {
+	var healthy atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/health" {
+			t.Errorf("checked %s, want /health", req.URL.Path)
+		}
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer primary.Close()
+
+	r := newReplica(types.ReplicaConfig{
+		PrimaryURL:          primary.URL,
+		HealthCheckInterval: time.Second,
+	})
+
+	if err := r.checkPrimary(context.Background()); err == nil {
+		t.Error("checkPrimary passed on a failing primary")
+	}
+
+	healthy.Store(true)
+	if err := r.checkPrimary(context.Background()); err != nil {
+		t.Errorf("checkPrimary failed on a healthy primary: %s", err)
+	}
+
+	primary.Close()
+	if err := r.checkPrimary(context.Background()); err == nil {
+		t.Error("checkPrimary passed on an unreachable primary")
+	}
+}
+
+func TestReplicaRejectsNodesWhileStandingBy(t *testing.T) {
+	h := &Headscale{
+		replica: newReplica(types.ReplicaConfig{FailoverAfter: time.Minute}),
+	}
+
+	handler := h.rejectWhileStandby(func(w http.ResponseWriter, req *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, ts2021UpgradePath, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("standing by = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+
+	h.replica.serving.Store(true)
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, ts2021UpgradePath, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("serving = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestReplicaStartsOnReadOnlyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	database := types.DatabaseConfig{
+		Type: types.DatabaseSqlite,
+		Sqlite: types.SqliteConfig{
+			Path: filepath.Join(dir, "headscale.db"),
+		},
+	}
+
+	// The primary migrates the database and leaves a pending and an
+	// expired registration behind.
+	primary, err := db.NewHeadscaleDatabase(database, "")
+	if err != nil {
+		t.Fatalf("setting up database: %s", err)
+	}
+	pending := key.NewMachine().Public()
+	expired := key.NewMachine().Public()
+	if err := primary.SavePendingRegistration(pending.String(), types.Node{MachineKey: pending}, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("SavePendingRegistration() error = %s", err)
+	}
+	if err := primary.SavePendingRegistration(expired.String(), types.Node{MachineKey: expired}, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("SavePendingRegistration() error = %s", err)
+	}
+
+	database.ReadOnly = true
+	h, err := NewHeadscale(&types.Config{
+		NoisePrivateKeyPath: filepath.Join(dir, "noise_private.key"),
+		Database:            database,
+		Replica: types.ReplicaConfig{
+			Enabled:             true,
+			HealthCheckInterval: time.Second,
+			FailoverAfter:       time.Minute,
+			RefreshInterval:     time.Second,
+		},
+		Tuning: types.Tuning{
+			BatchChangeDelay: time.Second,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewHeadscale() error = %s", err)
+	}
+	t.Cleanup(h.nodeNotifier.Close)
+
+	if _, ok := h.registrationCache.Get(pending.String()); !ok {
+		t.Errorf("pending registration was not restored")
+	}
+	if _, ok := h.registrationCache.Get(expired.String()); ok {
+		t.Errorf("expired registration was restored")
+	}
+}
@@ -0,0 +1,132 @@
+package hscontrol
+
+import (
+	"net/netip"
+	"strings"
+	"unicode"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+const (
+	searchDefaultLimit = 20
+	searchMaxLimit     = 100
+
+	searchScoreExact     = 100
+	searchScorePrefix    = 75
+	searchScoreSubstring = 50
+	searchScoreLoose     = 25
+
+	// searchMinKeyLength is how many characters of a key must be given
+	// to match it, shorter queries would match keys at random.
+	searchMinKeyLength = 6
+)
+
+// searchMatch is the best matching field of a node or a user.
+type searchMatch struct {
+	score int
+	field string
+	value string
+}
+
+func (m *searchMatch) add(score int, field, value string) {
+	if score > m.score {
+		*m = searchMatch{score: score, field: field, value: value}
+	}
+}
+
+// searchScore scores how well value matches the query: exactly, as a
+// prefix, as a substring, or as a substring ignoring case, spaces and
+// punctuation, so "jans mbp" finds "jans-mbp". 0 is no match.
+func searchScore(query, value string) int {
+	query = strings.ToLower(query)
+	value = strings.ToLower(value)
+
+	switch {
+	case value == "":
+		return 0
+	case value == query:
+		return searchScoreExact
+	case strings.HasPrefix(value, query):
+		return searchScorePrefix
+	case strings.Contains(value, query):
+		return searchScoreSubstring
+	}
+
+	if loose := searchNormalize(query); loose != "" && strings.Contains(searchNormalize(value), loose) {
+		return searchScoreLoose
+	}
+
+	return 0
+}
+
+func searchNormalize(value string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+
+		return -1
+	}, value)
+}
+
+// searchKeyScore matches a key by its prefix, with or without its
+// nodekey:, mkey: or discokey: type.
+func searchKeyScore(query, key string) int {
+	if len(query) < searchMinKeyLength {
+		return 0
+	}
+
+	query = strings.ToLower(query)
+	_, hex, _ := strings.Cut(key, ":")
+
+	switch {
+	case query == key || query == hex:
+		return searchScoreExact
+	case strings.HasPrefix(key, query) || strings.HasPrefix(hex, query):
+		return searchScorePrefix
+	}
+
+	return 0
+}
+
+// searchNode returns the best match of the query among the names,
+// addresses, tags, note, keys and user of the node. A match on the user
+// only counts half, searching for a person lists them before their
+// nodes.
+func searchNode(query string, node *types.Node, tags []string) searchMatch {
+	var match searchMatch
+
+	match.add(searchScore(query, node.GivenName), "given_name", node.GivenName)
+	match.add(searchScore(query, node.Hostname), "hostname", node.Hostname)
+
+	prefix, prefixErr := netip.ParsePrefix(query)
+	for _, addr := range node.IPs() {
+		match.add(searchScore(query, addr.String()), "ip_addresses", addr.String())
+		if prefixErr == nil && prefix.Contains(addr) {
+			match.add(searchScorePrefix, "ip_addresses", addr.String())
+		}
+	}
+
+	for _, tag := range tags {
+		match.add(searchScore(query, tag), "tags", tag)
+	}
+
+	match.add(searchScore(query, node.Note), "note", node.Note)
+
+	match.add(searchKeyScore(query, node.NodeKey.String()), "node_key", node.NodeKey.String())
+	match.add(searchKeyScore(query, node.MachineKey.String()), "machine_key", node.MachineKey.String())
+	match.add(searchKeyScore(query, node.DiscoKey.String()), "disco_key", node.DiscoKey.String())
+
+	match.add(searchScore(query, node.User.Name)/2, "user", node.User.Name)
+
+	return match
+}
+
+// searchUser returns the match of the query on the name of the user.
+func searchUser(query string, user *types.User) searchMatch {
+	var match searchMatch
+	match.add(searchScore(query, user.Name), "name", user.Name)
+
+	return match
+}
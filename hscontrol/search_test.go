@@ -0,0 +1,135 @@
+package hscontrol
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tailscale.com/types/key"
+)
+
+func TestSearchScore(t *testing.T) {
+	tests := []struct {
+		query string
+		value string
+		want  int
+	}{
+		{query: "laptop", value: "laptop", want: searchScoreExact},
+		{query: "LapTop", value: "laptop", want: searchScoreExact},
+		{query: "lap", value: "laptop", want: searchScorePrefix},
+		{query: "top", value: "laptop", want: searchScoreSubstring},
+		{query: "jans mbp", value: "jans-mbp", want: searchScoreLoose},
+		{query: "desktop", value: "laptop", want: 0},
+		{query: "laptop", value: "", want: 0},
+		{query: "-", value: "laptop", want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := searchScore(tt.query, tt.value); got != tt.want {
+			t.Errorf("searchScore(%q, %q) = %d, want %d", tt.query, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	h, api := newTestAPIServer(t, &types.Config{})
+
+	nodeKey := key.NewNode().Public()
+	for _, n := range []struct {
+		name string
+		ip   string
+		tags []string
+		key  key.NodePublic
+	}{
+		{name: "alice-laptop", ip: "100.64.0.1", key: nodeKey},
+		{name: "build-server", ip: "100.64.0.2", tags: []string{"tag:ci"}},
+		{name: "printer", ip: "100.64.1.1"},
+	} {
+		ip := netip.MustParseAddr(n.ip)
+		createTestNode(t, h, "alice", &types.Node{
+			Hostname:   n.name,
+			IPv4:       &ip,
+			ForcedTags: n.tags,
+			NodeKey:    n.key,
+		})
+	}
+	if _, err := h.db.CreateUser("bob"); err != nil {
+		t.Fatalf("creating user: %s", err)
+	}
+
+	type result struct {
+		Name  string
+		Field string
+		Score int32
+	}
+	search := func(query string) []result {
+		t.Helper()
+		resp, err := api.Search(context.Background(), &v1.SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("Search(%q) error = %s", query, err)
+		}
+
+		var got []result
+		for _, r := range resp.GetResults() {
+			got = append(got, result{Name: searchResultName(r), Field: r.GetField(), Score: r.GetScore()})
+		}
+
+		return got
+	}
+
+	tests := []struct {
+		query string
+		want  []result
+	}{
+		{
+			// The user first, then their nodes by the name of the user.
+			query: "alice",
+			want: []result{
+				{Name: "alice", Field: "name", Score: searchScoreExact},
+				{Name: "alice-laptop", Field: "given_name", Score: searchScorePrefix},
+				{Name: "build-server", Field: "user", Score: searchScoreExact / 2},
+				{Name: "printer", Field: "user", Score: searchScoreExact / 2},
+			},
+		},
+		{
+			query: "100.64.0.2",
+			want:  []result{{Name: "build-server", Field: "ip_addresses", Score: searchScoreExact}},
+		},
+		{
+			query: "100.64.1.0/24",
+			want:  []result{{Name: "printer", Field: "ip_addresses", Score: searchScorePrefix}},
+		},
+		{
+			query: "tag:ci",
+			want:  []result{{Name: "build-server", Field: "tags", Score: searchScoreExact}},
+		},
+		{
+			query: nodeKey.String()[len("nodekey:") : len("nodekey:")+10],
+			want:  []result{{Name: "alice-laptop", Field: "node_key", Score: searchScorePrefix}},
+		},
+		{
+			query: "build server",
+			want:  []result{{Name: "build-server", Field: "given_name", Score: searchScoreLoose}},
+		},
+	}
+
+	for _, tt := range tests {
+		if diff := cmp.Diff(tt.want, search(tt.query)); diff != "" {
+			t.Errorf("Search(%q) mismatch (-want +got):\n%s", tt.query, diff)
+		}
+	}
+
+	resp, err := api.Search(context.Background(), &v1.SearchRequest{Query: "alice", Limit: 2})
+	if err != nil || len(resp.GetResults()) != 2 {
+		t.Errorf("Search() with limit 2 = %d results, %v, want 2 results", len(resp.GetResults()), err)
+	}
+
+	if _, err := api.Search(context.Background(), &v1.SearchRequest{Query: "  "}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Search() with an empty query error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
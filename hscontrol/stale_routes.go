@@ -0,0 +1,60 @@
+package hscontrol
+
+import (
+	"context"
+	"net/netip"
+	"time"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+)
+
+// staleRoutesInterval is how often routes past their grace period are
+// looked for.
+const staleRoutesInterval = time.Minute
+
+func (h *Headscale) withdrawStaleRoutesEvery(ctx context.Context, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.withdrawStaleRoutes(); err != nil {
+				log.Error().Err(err).Msg("failed to withdraw stale routes")
+			}
+		}
+	}
+}
+
+// withdrawStaleRoutes fails over, disables or removes the routes their
+// node stopped advertising, and tells the nodes about it.
+func (h *Headscale) withdrawStaleRoutes() error {
+	removed, changed, err := h.db.WithdrawStaleRoutes(
+		h.cfg.Routes.StaleGracePeriod,
+		h.nodeNotifier.LikelyConnectedMap(),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, route := range removed {
+		log.Info().
+			Str("node", route.Node.Hostname).
+			Str("route", netip.Prefix(route.Prefix).String()).
+			Bool("enabled", route.Enabled).
+			Msg("Removed route the node no longer advertises")
+	}
+
+	if len(changed) > 0 {
+		ctx := types.NotifyCtx(context.Background(), "stale-routes", "na")
+		h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type:        types.StatePeerChanged,
+			ChangeNodes: changed,
+		})
+	}
+
+	return nil
+}
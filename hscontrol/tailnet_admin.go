@@ -0,0 +1,175 @@
+package hscontrol
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	grpcRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"tailscale.com/tsnet"
+	"tailscale.com/types/logger"
+)
+
+// tailnetAdmin is an embedded Tailscale node serving the admin API and
+// metrics on its tailnet address. The connections are encrypted by
+// WireGuard, so the servers do not use TLS, but API keys are still
+// required.
+type tailnetAdmin struct {
+	node          *tsnet.Server
+	grpcServer    *grpc.Server
+	httpServer    *http.Server
+	metricsServer *http.Server
+}
+
+func (h *Headscale) startTailnetAdmin(
+	ctx context.Context,
+	errorGroup *errgroup.Group,
+	grpcMux *grpcRuntime.ServeMux,
+	debugMux http.Handler,
+) (*tailnetAdmin, error) {
+	cfg := h.cfg.TailnetAdmin
+
+	if err := util.EnsureDir(cfg.StateDir); err != nil {
+		return nil, fmt.Errorf("creating tailnet admin state directory: %w", err)
+	}
+
+	admin := &tailnetAdmin{
+		node: &tsnet.Server{
+			Dir:        cfg.StateDir,
+			Hostname:   cfg.Hostname,
+			AuthKey:    cfg.AuthKey,
+			ControlURL: cfg.ControlURL,
+			Ephemeral:  cfg.Ephemeral,
+			Logf:       logger.Discard,
+			// UserLogf prints the login URL if the node has no auth key.
+			UserLogf: util.TSLogfWrapper(),
+		},
+	}
+
+	listen := func(port int) (net.Listener, error) {
+		listener, err := admin.node.Listen("tcp", ":"+strconv.Itoa(port))
+		if err != nil {
+			return nil, fmt.Errorf("listening on tailnet port %d: %w", port, err)
+		}
+
+		return listener, nil
+	}
+
+	if cfg.GRPCPort != 0 {
+		listener, err := listen(cfg.GRPCPort)
+		if err != nil {
+			admin.Close()
+
+			return nil, err
+		}
+
+		admin.grpcServer = h.newTailnetGRPCServer()
+		errorGroup.Go(func() error { return admin.grpcServer.Serve(listener) })
+	}
+
+	if cfg.HTTPPort != 0 {
+		listener, err := listen(cfg.HTTPPort)
+		if err != nil {
+			admin.Close()
+
+			return nil, err
+		}
+
+		admin.httpServer = &http.Server{
+			Handler:     h.tailnetAdminRouter(grpcMux),
+			ReadTimeout: types.HTTPTimeout,
+		}
+		errorGroup.Go(func() error { return admin.httpServer.Serve(listener) })
+	}
+
+	if cfg.MetricsPort != 0 {
+		listener, err := listen(cfg.MetricsPort)
+		if err != nil {
+			admin.Close()
+
+			return nil, err
+		}
+
+		admin.metricsServer = &http.Server{
+			Handler:     debugMux,
+			ReadTimeout: types.HTTPTimeout,
+		}
+		errorGroup.Go(func() error { return admin.metricsServer.Serve(listener) })
+	}
+
+	// The node can only come up once headscale serves requests if it
+	// joins this headscale, so it is not waited for.
+	go func() {
+		status, err := admin.node.Up(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to start the tailnet admin node")
+
+			return
+		}
+
+		addresses := make([]string, 0, len(status.TailscaleIPs))
+		for _, addr := range status.TailscaleIPs {
+			addresses = append(addresses, addr.String())
+		}
+
+		log.Info().
+			Str("hostname", cfg.Hostname).
+			Strs("addresses", addresses).
+			Int("grpc_port", cfg.GRPCPort).
+			Int("http_port", cfg.HTTPPort).
+			Int("metrics_port", cfg.MetricsPort).
+			Msg("Serving the admin API on the tailnet")
+	}()
+
+	return admin, nil
+}
+
+// newTailnetGRPCServer returns the gRPC server of the tailnet admin
+// node, it authenticates like the remote gRPC server but without TLS.
+func (h *Headscale) newTailnetGRPCServer() *grpc.Server {
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(h.grpcAuthenticationInterceptor),
+		grpc.StreamInterceptor(h.grpcStreamAuthenticationInterceptor),
+	)
+
+	h.registerGRPCServices(grpcServer)
+
+	return grpcServer
+}
+
+// tailnetAdminRouter serves the REST API.
+func (h *Headscale) tailnetAdminRouter(grpcMux *grpcRuntime.ServeMux) *mux.Router {
+	router := mux.NewRouter()
+	router.Use(prometheusMiddleware)
+
+	h.addAPIRoutes(router, grpcMux)
+
+	router.PathPrefix("/").HandlerFunc(notFoundHandler)
+
+	return router
+}
+
+// Close stops the servers and the node.
+func (a *tailnetAdmin) Close() {
+	if a.grpcServer != nil {
+		a.grpcServer.GracefulStop()
+	}
+
+	for _, server := range []*http.Server{a.httpServer, a.metricsServer} {
+		if server != nil {
+			server.Close()
+		}
+	}
+
+	if err := a.node.Close(); err != nil {
+		log.Error().Err(err).Msg("failed to close the tailnet admin node")
+	}
+}
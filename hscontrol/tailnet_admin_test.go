@@ -0,0 +1,52 @@
+package hscontrol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	grpcRuntime "github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/juanfont/headscale/hscontrol/types"
+)
+
+func TestTailnetAdminExclusiveRoutes(t *testing.T) {
+	tests := []struct {
+		name      string
+		exclusive bool
+		want      int
+	}{
+		{
+			name: "api-on-public-router",
+			want: http.StatusUnauthorized,
+		},
+		{
+			name:      "api-only-on-tailnet",
+			exclusive: true,
+			want:      http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestAPIServer(t, &types.Config{
+				TailnetAdmin: types.TailnetAdminConfig{
+					Enabled:   tt.exclusive,
+					Exclusive: tt.exclusive,
+				},
+			})
+			grpcMux := grpcRuntime.NewServeMux()
+
+			rec := httptest.NewRecorder()
+			h.createRouter(grpcMux).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/user", nil))
+			if rec.Code != tt.want {
+				t.Errorf("public router: got status %d, want %d", rec.Code, tt.want)
+			}
+
+			rec = httptest.NewRecorder()
+			h.tailnetAdminRouter(grpcMux).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/user", nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("tailnet router: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package hscontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+)
+
+var ErrInvalidImport = errors.New("invalid import")
+
+// importedNode is a node of an import, parsed before anything is
+// written.
+type importedNode struct {
+	user          string
+	node          types.Node
+	addrs         []netip.Addr
+	enabledRoutes []netip.Prefix
+}
+
+func parseImportedNode(imported *v1.ImportedNode, now time.Time) (*importedNode, error) {
+	ret := &importedNode{
+		user: imported.GetUser(),
+		node: types.Node{
+			Hostname:   imported.GetHostname(),
+			GivenName:  imported.GetGivenName(),
+			ForcedTags: imported.GetForcedTags(),
+			Hostinfo: &tailcfg.Hostinfo{
+				Hostname: imported.GetHostname(),
+				OS:       imported.GetOs(),
+			},
+			// The nodes log in again when they connect to headscale
+			// the first time.
+			Expiry:         &now,
+			RegisterMethod: util.RegisterMethodCLI,
+		},
+	}
+
+	if err := ret.node.MachineKey.UnmarshalText([]byte(imported.GetMachineKey())); err != nil {
+		return nil, fmt.Errorf("%w: machine key of %s: %s", ErrInvalidImport, imported.GetHostname(), err)
+	}
+	if err := ret.node.NodeKey.UnmarshalText([]byte(imported.GetNodeKey())); err != nil {
+		return nil, fmt.Errorf("%w: node key of %s: %s", ErrInvalidImport, imported.GetHostname(), err)
+	}
+
+	for _, tag := range ret.node.ForcedTags {
+		if err := validateTag(tag); err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrInvalidImport, imported.GetHostname(), err)
+		}
+	}
+
+	for _, addr := range imported.GetIpAddresses() {
+		parsed, err := netip.ParseAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: address of %s: %s", ErrInvalidImport, imported.GetHostname(), err)
+		}
+		ret.addrs = append(ret.addrs, parsed)
+	}
+
+	for _, route := range imported.GetAdvertisedRoutes() {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("%w: route of %s: %s", ErrInvalidImport, imported.GetHostname(), err)
+		}
+		ret.node.Hostinfo.RoutableIPs = append(ret.node.Hostinfo.RoutableIPs, prefix)
+	}
+
+	for _, route := range imported.GetEnabledRoutes() {
+		prefix, err := netip.ParsePrefix(route)
+		if err != nil {
+			return nil, fmt.Errorf("%w: route of %s: %s", ErrInvalidImport, imported.GetHostname(), err)
+		}
+		ret.enabledRoutes = append(ret.enabledRoutes, prefix)
+	}
+
+	return ret, nil
+}
+
+// allocateIPs keeps the addresses the node had if they are in the
+// prefixes of headscale and free, and allocates the others.
+func (h *Headscale) allocateIPs(addrs []netip.Addr) (*netip.Addr, *netip.Addr, error) {
+	var ipv4, ipv6 *netip.Addr
+	for _, addr := range addrs {
+		if !h.ipAlloc.Reserve(addr) {
+			continue
+		}
+
+		if addr.Is4() && ipv4 == nil {
+			ipv4 = &addr
+		} else if addr.Is6() && ipv6 == nil {
+			ipv6 = &addr
+		}
+	}
+
+	needs4 := ipv4 == nil && h.cfg.IPFamilies.Enabled(h.cfg.PrefixV4) != nil
+	needs6 := ipv6 == nil && h.cfg.IPFamilies.Enabled(h.cfg.PrefixV6) != nil
+	if !needs4 && !needs6 {
+		return ipv4, ipv6, nil
+	}
+
+	next4, next6, err := h.ipAlloc.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+	if needs4 {
+		ipv4 = next4
+	}
+	if needs6 {
+		ipv6 = next6
+	}
+
+	return ipv4, ipv6, nil
+}
+
+// importTailnet creates the users and nodes of a tailnet run by another
+// control server, and stores its policy. Users that exist are reused and
+// nodes already registered are skipped, so an import can be repeated.
+// The imported nodes are expired until they log in to headscale.
+func (h *Headscale) importTailnet(request *v1.ImportTailnetRequest) (*v1.ImportTailnetResponse, error) {
+	if request.GetPolicy() != "" && h.cfg.Policy.Mode != types.PolicyModeDB {
+		return nil, types.ErrPolicyUpdateIsDisabled
+	}
+
+	now := time.Now()
+	nodes := make([]*importedNode, 0, len(request.GetNodes()))
+	for _, imported := range request.GetNodes() {
+		node, err := parseImportedNode(imported, now)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	response := &v1.ImportTailnetResponse{}
+	err := h.db.Write(func(tx *gorm.DB) error {
+		for _, name := range request.GetUsers() {
+			_, err := db.GetUser(tx, name)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, db.ErrUserNotFound) {
+				return err
+			}
+
+			user, err := db.CreateUser(tx, name)
+			if err != nil {
+				return fmt.Errorf("creating user %s: %w", name, err)
+			}
+			response.CreatedUsers = append(response.CreatedUsers, user.Proto())
+		}
+
+		for _, imported := range nodes {
+			user, err := db.GetUser(tx, imported.user)
+			if err != nil {
+				return fmt.Errorf("user %s of %s: %w", imported.user, imported.node.Hostname, err)
+			}
+			imported.node.UserID = user.ID
+			imported.node.User = *user
+
+			if _, err := db.GetNodeByMachineKey(tx, imported.node.MachineKey); err == nil {
+				response.Skipped = append(response.Skipped, fmt.Sprintf(
+					"%s: %s",
+					imported.node.Hostname,
+					db.ErrNodeAlreadyRegistered,
+				))
+
+				continue
+			}
+
+			ipv4, ipv6, err := h.allocateIPs(imported.addrs)
+			if err != nil {
+				return fmt.Errorf("allocating addresses of %s: %w", imported.node.Hostname, err)
+			}
+
+			node, err := db.ImportNode(tx, imported.node, ipv4, ipv6, imported.enabledRoutes)
+			if err != nil {
+				return fmt.Errorf("importing %s: %w", imported.node.Hostname, err)
+			}
+			response.CreatedNodes = append(response.CreatedNodes, node.Proto())
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The created users take their names over from renamed users.
+	if len(response.GetCreatedUsers()) > 0 {
+		if err := h.reloadUserAliases(); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info().
+		Int("users", len(response.GetCreatedUsers())).
+		Int("nodes", len(response.GetCreatedNodes())).
+		Int("skipped", len(response.GetSkipped())).
+		Msg("Imported tailnet")
+
+	if request.GetPolicy() != "" {
+		// The policy is set once the users and nodes it refers to
+		// exist. If it is rejected, importing again with the policy
+		// fixed skips what was imported.
+		pol, err := h.updatePolicy(0, func([]byte) ([]byte, error) {
+			return []byte(request.GetPolicy()), nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("users and nodes were imported, but the policy was rejected: %w", err)
+		}
+		response.PolicyVersion = uint64(pol.ID)
+	}
+
+	if len(response.GetCreatedNodes()) > 0 {
+		ctx := types.NotifyCtx(context.Background(), "tailnet-import", "na")
+		h.nodeNotifier.NotifyAll(ctx, types.StateUpdate{
+			Type: types.StateFullUpdate,
+		})
+	}
+
+	return response, nil
+}
@@ -0,0 +1,135 @@
+package hscontrol
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/db"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"tailscale.com/types/key"
+)
+
+func TestImportTailnet(t *testing.T) {
+	prefix4 := netip.MustParsePrefix("100.64.0.0/10")
+	prefix6 := netip.MustParsePrefix("fd7a:115c:a1e0::/48")
+	h, api := newTestAPIServer(t, &types.Config{
+		PrefixV4: &prefix4,
+		PrefixV6: &prefix6,
+		Policy:   types.PolicyConfig{Mode: types.PolicyModeDB},
+	})
+
+	var err error
+	h.ipAlloc, err = db.NewIPAllocator(h.db, &prefix4, &prefix6, types.IPAllocationStrategySequential)
+	if err != nil {
+		t.Fatalf("creating IP allocator: %s", err)
+	}
+
+	laptopKey, routerKey := key.NewMachine().Public(), key.NewMachine().Public()
+	machineKey := func(k key.MachinePublic) string {
+		text, _ := k.MarshalText()
+
+		return string(text)
+	}
+	nodeKey := func() string {
+		text, _ := key.NewNode().Public().MarshalText()
+
+		return string(text)
+	}
+
+	request := &v1.ImportTailnetRequest{
+		Users: []string{"alice", "tagged-devices"},
+		Nodes: []*v1.ImportedNode{
+			{
+				User:        "alice",
+				Hostname:    "alice-laptop",
+				GivenName:   "laptop",
+				MachineKey:  machineKey(laptopKey),
+				NodeKey:     nodeKey(),
+				IpAddresses: []string{"100.101.102.103", "fd7a:115c:a1e0::1"},
+			},
+			{
+				User:             "tagged-devices",
+				Hostname:         "router",
+				MachineKey:       machineKey(routerKey),
+				NodeKey:          nodeKey(),
+				IpAddresses:      []string{"10.1.2.3"},
+				ForcedTags:       []string{"tag:server"},
+				AdvertisedRoutes: []string{"10.0.0.0/24", "10.0.1.0/24"},
+				EnabledRoutes:    []string{"10.0.0.0/24"},
+			},
+		},
+		Policy: `{"acls": [{"action": "accept", "src": ["alice"], "dst": ["tag:server:22"]}]}`,
+	}
+
+	ctx := context.Background()
+
+	resp, err := api.ImportTailnet(ctx, request)
+	if err != nil {
+		t.Fatalf("ImportTailnet() error = %s", err)
+	}
+
+	if got := len(resp.GetCreatedUsers()); got != 2 {
+		t.Errorf("created %d users, want 2", got)
+	}
+	if got := len(resp.GetCreatedNodes()); got != 2 {
+		t.Fatalf("created %d nodes, want 2", got)
+	}
+	if resp.GetPolicyVersion() == 0 {
+		t.Error("the policy was not stored")
+	}
+
+	laptop, err := h.db.GetNodeByMachineKey(laptopKey)
+	if err != nil {
+		t.Fatalf("getting the imported laptop: %s", err)
+	}
+	if laptop.GivenName != "laptop" {
+		t.Errorf("given name = %q, want laptop", laptop.GivenName)
+	}
+	if got := laptop.IPv4.String(); got != "100.101.102.103" {
+		t.Errorf("IPv4 = %s, want the address the node had", got)
+	}
+	if !laptop.IsExpired() {
+		t.Error("the imported node is not expired, it would not log in again")
+	}
+
+	router, err := h.db.GetNodeByMachineKey(routerKey)
+	if err != nil {
+		t.Fatalf("getting the imported router: %s", err)
+	}
+	if router.IPv4 == nil || !prefix4.Contains(*router.IPv4) {
+		t.Errorf("IPv4 = %v, want an address allocated in %s", router.IPv4, prefix4)
+	}
+
+	routes, err := h.db.GetNodeRoutes(router)
+	if err != nil {
+		t.Fatalf("getting routes: %s", err)
+	}
+	enabled := map[string]bool{}
+	for _, route := range routes {
+		enabled[netip.Prefix(route.Prefix).String()] = route.Enabled
+	}
+	if len(enabled) != 2 || !enabled["10.0.0.0/24"] || enabled["10.0.1.0/24"] {
+		t.Errorf("routes = %v, want both advertised and only 10.0.0.0/24 enabled", enabled)
+	}
+
+	// Importing again skips what was imported.
+	request.Policy = ""
+	again, err := api.ImportTailnet(ctx, request)
+	if err != nil {
+		t.Fatalf("ImportTailnet() again error = %s", err)
+	}
+	if len(again.GetCreatedUsers()) != 0 || len(again.GetCreatedNodes()) != 0 || len(again.GetSkipped()) != 2 {
+		t.Errorf("importing again created %d users and %d nodes and skipped %v",
+			len(again.GetCreatedUsers()), len(again.GetCreatedNodes()), again.GetSkipped())
+	}
+
+	request.Nodes[0].MachineKey = "mkey:invalid"
+	_, err = api.ImportTailnet(ctx, request)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("ImportTailnet() with an invalid key error = %v, want %s", err, codes.InvalidArgument)
+	}
+}
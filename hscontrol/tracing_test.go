@@ -0,0 +1,70 @@
+package hscontrol
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/juanfont/headscale/hscontrol/mapper"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"tailscale.com/tailcfg"
+)
+
+func TestMapResponseSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	cfg := &types.Config{}
+	h, _ := newTestAPIServer(t, cfg)
+	pol := &policy.ACLPolicy{
+		ACLs: []policy.ACL{
+			{Action: "accept", Sources: []string{"*"}, Destinations: []string{"*:*"}},
+		},
+	}
+
+	var nodes types.Nodes
+	for index, hostname := range []string{"laptop", "router"} {
+		ip := netip.AddrFrom4([4]byte{100, 64, 0, byte(index + 1)})
+		nodes = append(nodes, createTestNode(t, h, "alice", &types.Node{
+			Hostname: hostname,
+			IPv4:     &ip,
+			Hostinfo: &tailcfg.Hostinfo{},
+		}))
+	}
+
+	node, err := h.db.GetNodeByID(nodes[0].ID)
+	if err != nil {
+		t.Fatalf("GetNodeByID() error = %s", err)
+	}
+
+	m := mapper.NewMapper(h.db, cfg, nil, h.nodeNotifier)
+	if _, err := m.FullMapResponse(context.Background(), tailcfg.MapRequest{Version: 90}, node, pol); err != nil {
+		t.Fatalf("FullMapResponse() error = %s", err)
+	}
+
+	spans := exporter.GetSpans()
+	parents := make(map[string]string)
+	names := make(map[string]string)
+	for _, span := range spans {
+		names[span.SpanContext.SpanID().String()] = span.Name
+	}
+	for _, span := range spans {
+		parents[span.Name] = names[span.Parent.SpanID().String()]
+	}
+
+	// The policy compile span is a child of the map response span.
+	want := map[string]string{
+		"headscale.map.full":       "",
+		"headscale.policy.compile": "headscale.map.full",
+	}
+	if diff := cmp.Diff(want, parents); diff != "" {
+		t.Errorf("span parents mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,241 @@
+// Package tsimport reads the users, devices and policy of a tailnet from
+// the Tailscale admin API, to import them into headscale.
+package tsimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/policy"
+	"github.com/juanfont/headscale/hscontrol/util"
+)
+
+// DefaultBaseURL is the address of the Tailscale admin API.
+const DefaultBaseURL = "https://api.tailscale.com"
+
+const requestTimeout = 30 * time.Second
+
+// TaggedDevicesUser owns the tagged devices the admin API does not list
+// a user of the tailnet for, like Tailscale does.
+const TaggedDevicesUser = "tagged-devices"
+
+var ErrUserNameCollision = errors.New("users would get the same name in headscale")
+
+// User is a user of the tailnet, as listed by the admin API.
+type User struct {
+	ID          string `json:"id"`
+	LoginName   string `json:"loginName"`
+	DisplayName string `json:"displayName"`
+	// Type is "member" for the users of the tailnet and "shared" for
+	// users of other tailnets a device was shared with.
+	Type   string `json:"type"`
+	Role   string `json:"role"`
+	Status string `json:"status"`
+}
+
+// Device is a device of the tailnet, as listed by the admin API with
+// all its fields.
+type Device struct {
+	ID        string   `json:"id"`
+	User      string   `json:"user"`
+	Name      string   `json:"name"`
+	Hostname  string   `json:"hostname"`
+	OS        string   `json:"os"`
+	Addresses []string `json:"addresses"`
+	Tags      []string `json:"tags"`
+
+	MachineKey string `json:"machineKey"`
+	NodeKey    string `json:"nodeKey"`
+
+	AdvertisedRoutes []string `json:"advertisedRoutes"`
+	EnabledRoutes    []string `json:"enabledRoutes"`
+
+	// IsExternal is set for devices shared into the tailnet from
+	// another tailnet.
+	IsExternal bool `json:"isExternal"`
+}
+
+// Tailnet is what the admin API returned about a tailnet.
+type Tailnet struct {
+	Users   []User
+	Devices []Device
+	// Policy is the policy file of the tailnet, in HuJSON.
+	Policy []byte
+}
+
+// Client reads a tailnet from the admin API.
+type Client struct {
+	baseURL string
+	apiKey  string
+	tailnet string
+	client  *http.Client
+}
+
+// NewClient returns a client of the admin API at baseURL for the
+// tailnet, "-" being the default tailnet of the API key.
+func NewClient(baseURL, apiKey, tailnet string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		tailnet: tailnet,
+		client:  &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Fetch reads the users, devices and policy of the tailnet.
+func (c *Client) Fetch(ctx context.Context) (*Tailnet, error) {
+	var tailnet Tailnet
+
+	var users struct {
+		Users []User `json:"users"`
+	}
+	if err := c.getJSON(ctx, "users", &users); err != nil {
+		return nil, err
+	}
+	tailnet.Users = users.Users
+
+	var devices struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := c.getJSON(ctx, "devices?fields=all", &devices); err != nil {
+		return nil, err
+	}
+	tailnet.Devices = devices.Devices
+
+	pol, err := c.get(ctx, "acl", "application/hujson")
+	if err != nil {
+		return nil, err
+	}
+	tailnet.Policy = pol
+
+	return &tailnet, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, into any) error {
+	body, err := c.get(ctx, path, "application/json")
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, into); err != nil {
+		return fmt.Errorf("decoding %s: %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *Client) get(ctx context.Context, path string, accept string) ([]byte, error) {
+	endpoint := fmt.Sprintf("%s/api/v2/tailnet/%s/%s", c.baseURL, url.PathEscape(c.tailnet), path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.apiKey, "")
+	req.Header.Set("Accept", accept)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("requesting %s: %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+// Request returns the import of the tailnet into headscale, and the users
+// and devices it leaves out. Users are named like headscale names the
+// users of OIDC, stripping the domain of their login name with
+// stripEmailDomain, and the policy is rewritten to use these names.
+func (t *Tailnet) Request(stripEmailDomain bool) (*v1.ImportTailnetRequest, []string, error) {
+	var (
+		request  v1.ImportTailnetRequest
+		left     []string
+		names    = make(map[string]string)
+		loginsOf = make(map[string]string)
+	)
+
+	for _, user := range t.Users {
+		if user.Type == "shared" {
+			left = append(left, fmt.Sprintf("user %s: shared from another tailnet", user.LoginName))
+
+			continue
+		}
+
+		name, err := util.NormalizeToFQDNRules(user.LoginName, stripEmailDomain)
+		if err != nil {
+			return nil, nil, fmt.Errorf("user %s: %w", user.LoginName, err)
+		}
+
+		if other, ok := loginsOf[name]; ok {
+			return nil, nil, fmt.Errorf("%w: %s and %s are both %s", ErrUserNameCollision, other, user.LoginName, name)
+		}
+		loginsOf[name] = user.LoginName
+		names[user.LoginName] = name
+
+		request.Users = append(request.Users, name)
+	}
+
+	for _, device := range t.Devices {
+		if device.IsExternal {
+			left = append(left, fmt.Sprintf("device %s: shared from another tailnet", device.Name))
+
+			continue
+		}
+
+		user, ok := names[device.User]
+		if !ok && len(device.Tags) > 0 {
+			user, ok = TaggedDevicesUser, true
+			if !slices.Contains(request.Users, TaggedDevicesUser) {
+				request.Users = append(request.Users, TaggedDevicesUser)
+			}
+		}
+		if !ok {
+			left = append(left, fmt.Sprintf("device %s: user %s is not imported", device.Name, device.User))
+
+			continue
+		}
+
+		givenName, _, _ := strings.Cut(device.Name, ".")
+		request.Nodes = append(request.Nodes, &v1.ImportedNode{
+			User:             user,
+			Hostname:         device.Hostname,
+			GivenName:        givenName,
+			Os:               device.OS,
+			MachineKey:       device.MachineKey,
+			NodeKey:          device.NodeKey,
+			IpAddresses:      device.Addresses,
+			ForcedTags:       device.Tags,
+			AdvertisedRoutes: device.AdvertisedRoutes,
+			EnabledRoutes:    device.EnabledRoutes,
+		})
+	}
+
+	if len(t.Policy) > 0 {
+		pol, err := policy.RenameUsers(t.Policy, names)
+		if err != nil {
+			return nil, nil, fmt.Errorf("renaming the users of the policy: %w", err)
+		}
+		request.Policy = string(pol)
+	}
+
+	return &request, left, nil
+}
@@ -0,0 +1,141 @@
+package tsimport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+const testPolicy = `{
+	// Imported from Tailscale
+	"tagOwners": {"tag:server": ["alice@example.com"]},
+	"acls": [{"action": "accept", "src": ["alice@example.com"], "dst": ["tag:server:22"]}],
+}`
+
+func testAdminAPI(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, _, _ := req.BasicAuth(); user != "tskey-api-test" {
+			http.Error(w, `{"message": "API token invalid"}`, http.StatusUnauthorized)
+
+			return
+		}
+
+		switch req.URL.Path {
+		case "/api/v2/tailnet/-/users":
+			w.Write([]byte(`{"users": [
+				{"id": "1", "loginName": "alice@example.com", "type": "member", "role": "owner"},
+				{"id": "2", "loginName": "bob@partner.com", "type": "shared", "role": "member"}
+			]}`))
+		case "/api/v2/tailnet/-/devices":
+			if req.URL.Query().Get("fields") != "all" {
+				t.Errorf("devices are listed without fields=all")
+			}
+			w.Write([]byte(`{"devices": [
+				{
+					"id": "10", "user": "alice@example.com", "name": "laptop.tail1234.ts.net", "hostname": "alice-laptop",
+					"os": "macOS", "addresses": ["100.101.102.103", "fd7a:115c:a1e0::1"],
+					"machineKey": "mkey:aa", "nodeKey": "nodekey:bb"
+				},
+				{
+					"id": "11", "user": "ci@example.com", "name": "router.tail1234.ts.net", "hostname": "router",
+					"os": "linux", "tags": ["tag:server"], "advertisedRoutes": ["10.0.0.0/24"], "enabledRoutes": ["10.0.0.0/24"],
+					"machineKey": "mkey:cc", "nodeKey": "nodekey:dd"
+				},
+				{"id": "12", "user": "bob@partner.com", "name": "bobs-box.partner.ts.net", "isExternal": true}
+			]}`))
+		case "/api/v2/tailnet/-/acl":
+			if req.Header.Get("Accept") != "application/hujson" {
+				t.Errorf("policy is requested as %s", req.Header.Get("Accept"))
+			}
+			w.Write([]byte(testPolicy))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func TestFetchAndRequest(t *testing.T) {
+	server := testAdminAPI(t)
+	defer server.Close()
+
+	tailnet, err := NewClient(server.URL, "tskey-api-test", "-").Fetch(context.Background())
+	if err != nil {
+		t.Fatalf("Fetch() error = %s", err)
+	}
+
+	request, left, err := tailnet.Request(true)
+	if err != nil {
+		t.Fatalf("Request() error = %s", err)
+	}
+
+	want := &v1.ImportTailnetRequest{
+		Users: []string{"alice", TaggedDevicesUser},
+		Nodes: []*v1.ImportedNode{
+			{
+				User:        "alice",
+				Hostname:    "alice-laptop",
+				GivenName:   "laptop",
+				Os:          "macOS",
+				MachineKey:  "mkey:aa",
+				NodeKey:     "nodekey:bb",
+				IpAddresses: []string{"100.101.102.103", "fd7a:115c:a1e0::1"},
+			},
+			{
+				User:             TaggedDevicesUser,
+				Hostname:         "router",
+				GivenName:        "router",
+				Os:               "linux",
+				MachineKey:       "mkey:cc",
+				NodeKey:          "nodekey:dd",
+				ForcedTags:       []string{"tag:server"},
+				AdvertisedRoutes: []string{"10.0.0.0/24"},
+				EnabledRoutes:    []string{"10.0.0.0/24"},
+			},
+		},
+		Policy: strings.ReplaceAll(testPolicy, `"alice@example.com"`, `"alice"`),
+	}
+	if diff := cmp.Diff(want, request, protocmp.Transform()); diff != "" {
+		t.Errorf("Request() unexpected result (-want +got):\n%s", diff)
+	}
+
+	wantLeft := []string{
+		"user bob@partner.com: shared from another tailnet",
+		"device bobs-box.partner.ts.net: shared from another tailnet",
+	}
+	if diff := cmp.Diff(wantLeft, left); diff != "" {
+		t.Errorf("Request() left out (-want +got):\n%s", diff)
+	}
+}
+
+func TestFetchInvalidKey(t *testing.T) {
+	server := testAdminAPI(t)
+	defer server.Close()
+
+	_, err := NewClient(server.URL, "tskey-api-wrong", "-").Fetch(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "API token invalid") {
+		t.Errorf("Fetch() with an invalid key error = %v, want the error of the API", err)
+	}
+}
+
+func TestRequestUserNameCollision(t *testing.T) {
+	tailnet := &Tailnet{Users: []User{
+		{LoginName: "alice@example.com", Type: "member"},
+		{LoginName: "alice@example.org", Type: "member"},
+	}}
+
+	if _, _, err := tailnet.Request(true); err == nil {
+		t.Error("Request() passed with two users named alice")
+	}
+
+	if _, _, err := tailnet.Request(false); err != nil {
+		t.Errorf("Request() without stripping the domain error = %s", err)
+	}
+}
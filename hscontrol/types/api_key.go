@@ -1,6 +1,7 @@
 package types
 
 import (
+	"slices"
 	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
@@ -17,12 +18,27 @@ type APIKey struct {
 	CreatedAt  *time.Time
 	Expiration *time.Time
 	LastSeen   *time.Time
+
+	// Tags limits the tags the key can add to or remove from nodes.
+	// Keys without tags can change all tags.
+	Tags StringList
+
+	// DERPRegionID limits the key to registering the DERP relay of the
+	// region, for headscale-derp. Zero for other keys.
+	DERPRegionID int
+}
+
+// CanManageTag reports if the key is allowed to add or remove the tag.
+func (key *APIKey) CanManageTag(tag string) bool {
+	return len(key.Tags) == 0 || slices.Contains(key.Tags, tag)
 }
 
 func (key *APIKey) Proto() *v1.ApiKey {
 	protoKey := v1.ApiKey{
-		Id:     key.ID,
-		Prefix: key.Prefix,
+		Id:           key.ID,
+		Prefix:       key.Prefix,
+		Tags:         key.Tags,
+		DerpRegionId: int32(key.DERPRegionID),
 	}
 
 	if key.Expiration != nil {
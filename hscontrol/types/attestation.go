@@ -0,0 +1,166 @@
+package types
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"tailscale.com/types/key"
+)
+
+var (
+	ErrAttestationSignature   = errors.New("attestation signature does not match the node key")
+	ErrAttestationKeyMismatch = errors.New("attestation certificate does not match the attestation key")
+	ErrAttestationKeyType     = errors.New("unsupported attestation key type")
+)
+
+// AttestationStatement is the hardware attestation a client can send
+// next to its register request, in the Attestation field. The node key
+// is signed by a key held in a TPM or a secure enclave, whose
+// certificate chain leads to the vendor of the hardware.
+type AttestationStatement struct {
+	// Format names the hardware holding the key, like tpm or
+	// secure-enclave.
+	Format string
+
+	// PublicKey is the DER encoded PKIX public key of the attestation
+	// key.
+	PublicKey []byte
+
+	// Signature is the signature of the text form of the node key,
+	// "nodekey:<hex>", with the attestation key. ECDSA and RSA keys
+	// sign its SHA-256 digest.
+	Signature []byte
+
+	// Certificates is the DER encoded certificate chain of the
+	// attestation key, leaf first.
+	Certificates [][]byte
+}
+
+// NodeAttestation is the attestation recorded for a node.
+type NodeAttestation struct {
+	Format string
+
+	// KeyFingerprint is the hex encoded SHA-256 of the attestation
+	// public key.
+	KeyFingerprint string
+
+	// Issuer is the subject of the root the certificate chain was
+	// verified with.
+	Issuer string
+
+	// Verified is set when the certificate chain of the key was
+	// verified with the attestation roots, only verified attestations
+	// satisfy the node:attested posture.
+	Verified bool
+
+	AttestedAt time.Time
+}
+
+// VerifyAttestation checks the statement signs the node key and, if
+// roots are given, that its certificate chain leads to one of them.
+// A statement with a valid signature but no verified chain is recorded
+// as not verified.
+func VerifyAttestation(
+	stmt AttestationStatement,
+	nodeKey key.NodePublic,
+	roots *x509.CertPool,
+	now time.Time,
+) (*NodeAttestation, error) {
+	publicKey, err := x509.ParsePKIXPublicKey(stmt.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("parsing attestation key: %w", err)
+	}
+
+	if err := verifyAttestationSignature(publicKey, []byte(nodeKey.String()), stmt.Signature); err != nil {
+		return nil, err
+	}
+
+	fingerprint := sha256.Sum256(stmt.PublicKey)
+	attestation := &NodeAttestation{
+		Format:         stmt.Format,
+		KeyFingerprint: hex.EncodeToString(fingerprint[:]),
+		AttestedAt:     now,
+	}
+
+	if roots == nil || len(stmt.Certificates) == 0 {
+		return attestation, nil
+	}
+
+	certs := make([]*x509.Certificate, 0, len(stmt.Certificates))
+	for _, der := range stmt.Certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parsing attestation certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	leaf := certs[0]
+	leafKey, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil || string(leafKey) != string(stmt.PublicKey) {
+		return nil, ErrAttestationKeyMismatch
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return attestation, nil //nolint:nilerr // an unverified chain is recorded, not rejected.
+	}
+
+	chain := chains[0]
+	attestation.Verified = true
+	attestation.Issuer = chain[len(chain)-1].Subject.String()
+
+	return attestation, nil
+}
+
+func verifyAttestationSignature(publicKey crypto.PublicKey, message, signature []byte) error {
+	digest := sha256.Sum256(message)
+
+	var ok bool
+	switch publicKey := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(publicKey, digest[:], signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(publicKey, message, signature)
+	default:
+		return fmt.Errorf("%w: %T", ErrAttestationKeyType, publicKey)
+	}
+
+	if !ok {
+		return ErrAttestationSignature
+	}
+
+	return nil
+}
+
+func (a *NodeAttestation) Proto() *v1.NodeAttestation {
+	return &v1.NodeAttestation{
+		Format:         a.Format,
+		KeyFingerprint: a.KeyFingerprint,
+		Issuer:         a.Issuer,
+		Verified:       a.Verified,
+		AttestedAt:     timestamppb.New(a.AttestedAt),
+	}
+}
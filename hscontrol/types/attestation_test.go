@@ -0,0 +1,98 @@
+package types
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/types/key"
+)
+
+func TestVerifyAttestation(t *testing.T) {
+	now := time.Now()
+
+	newCert := func(subject string, pub, priv any, parent *x509.Certificate, isCA bool) *x509.Certificate {
+		t.Helper()
+
+		tmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(now.UnixNano()),
+			Subject:               pkix.Name{CommonName: subject},
+			NotBefore:             now.Add(-time.Hour),
+			NotAfter:              now.Add(time.Hour),
+			IsCA:                  isCA,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		if parent == nil {
+			parent = tmpl
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+		require.NoError(t, err)
+
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+
+		return cert
+	}
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	root := newCert("Hardware Vendor Root", &rootKey.PublicKey, rootKey, nil, true)
+
+	attKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leaf := newCert("attestation key", &attKey.PublicKey, rootKey, root, false)
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&attKey.PublicKey)
+	require.NoError(t, err)
+
+	nodeKey := key.NewNode().Public()
+	digest := sha256.Sum256([]byte(nodeKey.String()))
+	signature, err := ecdsa.SignASN1(rand.Reader, attKey, digest[:])
+	require.NoError(t, err)
+
+	stmt := AttestationStatement{
+		Format:       "tpm",
+		PublicKey:    publicKey,
+		Signature:    signature,
+		Certificates: [][]byte{leaf.Raw},
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+
+	got, err := VerifyAttestation(stmt, nodeKey, roots, now)
+	require.NoError(t, err)
+	assert.True(t, got.Verified)
+	assert.Equal(t, "CN=Hardware Vendor Root", got.Issuer)
+	assert.Equal(t, "tpm", got.Format)
+
+	// Without roots the attestation is recorded, but not verified.
+	got, err = VerifyAttestation(stmt, nodeKey, nil, now)
+	require.NoError(t, err)
+	assert.False(t, got.Verified)
+
+	// An unknown root does not verify the chain.
+	got, err = VerifyAttestation(stmt, nodeKey, x509.NewCertPool(), now)
+	require.NoError(t, err)
+	assert.False(t, got.Verified)
+
+	// The signature has to be of the node key registering.
+	_, err = VerifyAttestation(stmt, key.NewNode().Public(), roots, now)
+	require.ErrorIs(t, err, ErrAttestationSignature)
+
+	// The leaf certificate has to be of the attestation key.
+	otherLeaf := newCert("other key", &rootKey.PublicKey, rootKey, root, false)
+	stmt.Certificates = [][]byte{otherLeaf.Raw}
+	_, err = VerifyAttestation(stmt, nodeKey, roots, now)
+	require.ErrorIs(t, err, ErrAttestationKeyMismatch)
+}
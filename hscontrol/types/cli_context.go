@@ -0,0 +1,180 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// cliContextsFile is the file in the user configuration directory,
+	// like ~/.config/headscale/contexts.yaml, holding the CLI contexts.
+	cliContextsFile = "contexts.yaml"
+
+	fingerprintPrefix = "sha256:"
+)
+
+var ErrCLIContextNotFound = errors.New("context not found")
+
+// CLIContext is a headscale server the CLI connects to remotely, like
+// the cli section of the configuration.
+type CLIContext struct {
+	Address string `yaml:"address"`
+
+	// APIKey supports ${VAR} and file: references.
+	APIKey string `yaml:"api_key"`
+
+	// TLSFingerprint pins the certificate of the server, it is trusted
+	// even if it is self signed.
+	TLSFingerprint string `yaml:"tls_fingerprint,omitempty"`
+	Insecure       bool   `yaml:"insecure,omitempty"`
+	Plaintext      bool   `yaml:"plaintext,omitempty"`
+	Timeout        string `yaml:"timeout,omitempty"`
+}
+
+// CLIContexts are the contexts of an operator and the one in use.
+type CLIContexts struct {
+	Current  string                 `yaml:"current,omitempty"`
+	Contexts map[string]*CLIContext `yaml:"contexts"`
+}
+
+// CLIContextsPath returns the path of the contexts file.
+func CLIContextsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "headscale", cliContextsFile), nil
+}
+
+// LoadCLIContexts reads the contexts file, a missing file has no
+// contexts.
+func LoadCLIContexts(path string) (*CLIContexts, error) {
+	contexts := &CLIContexts{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		contexts.Contexts = map[string]*CLIContext{}
+
+		return contexts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, contexts); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	if contexts.Contexts == nil {
+		contexts.Contexts = map[string]*CLIContext{}
+	}
+
+	return contexts, nil
+}
+
+// Save writes the contexts file, readable only by the user as it holds
+// API keys.
+func (c *CLIContexts) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0o600)
+}
+
+// applyCLIContext overrides the cli configuration with the named
+// context. Without a name, the current context is used unless an
+// address is configured.
+func applyCLIContext(cfg *CLIConfig, name string) error {
+	if name == "" && cfg.Address != "" {
+		return nil
+	}
+
+	path, err := CLIContextsPath()
+	if err != nil {
+		// Without a configuration directory, there are no contexts.
+		if name == "" {
+			return nil
+		}
+
+		return err
+	}
+
+	contexts, err := LoadCLIContexts(path)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = contexts.Current
+	}
+	if name == "" {
+		return nil
+	}
+
+	cliContext, ok := contexts.Contexts[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrCLIContextNotFound, name)
+	}
+
+	apiKey, err := resolveSecret(cliContext.APIKey)
+	if err != nil {
+		return fmt.Errorf("context %q: api_key: %w", name, err)
+	}
+
+	cfg.Address = cliContext.Address
+	cfg.APIKey = apiKey
+	cfg.TLSFingerprint = cliContext.TLSFingerprint
+	cfg.Insecure = cliContext.Insecure
+	cfg.Plaintext = cliContext.Plaintext
+
+	if cliContext.Timeout != "" {
+		cfg.Timeout, err = time.ParseDuration(cliContext.Timeout)
+		if err != nil {
+			return fmt.Errorf("context %q: timeout: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a DER
+// encoded certificate, in the format used by tls_fingerprint.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	return fingerprintPrefix + hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint accepts fingerprints with or without the sha256:
+// prefix, in upper or lower case and with or without colons, like the
+// output of openssl x509 -fingerprint -sha256.
+func NormalizeFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.TrimSpace(fingerprint)
+	if len(fingerprint) >= len(fingerprintPrefix) &&
+		strings.EqualFold(fingerprint[:len(fingerprintPrefix)], fingerprintPrefix) {
+		fingerprint = fingerprint[len(fingerprintPrefix):]
+	}
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+
+	sum, err := hex.DecodeString(fingerprint)
+	if err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+	}
+
+	return fingerprintPrefix + fingerprint, nil
+}
@@ -105,6 +105,8 @@ func (su StateUpdateType) String() string {
 		return "StateSelfUpdate"
 	case StateDERPUpdated:
 		return "StateDERPUpdated"
+	case StatePingRequest:
+		return "StatePingRequest"
 	}
 
 	return "unknown state update type"
@@ -126,6 +128,9 @@ const (
 	// which should have a length of one.
 	StateSelfUpdate
 	StateDERPUpdated
+	// StatePingRequest asks the node to answer the ping request in
+	// the PingRequest field.
+	StatePingRequest
 )
 
 // StateUpdate is an internal message containing information about
@@ -153,6 +158,9 @@ type StateUpdate struct {
 	// contain the new DERP Map.
 	DERPMap *tailcfg.DERPMap
 
+	// PingRequest must be set when Type is StatePingRequest.
+	PingRequest *tailcfg.PingRequest
+
 	// Additional message for tracking origin or what being
 	// updated, useful for ambiguous updates like StatePeerChanged.
 	Message string
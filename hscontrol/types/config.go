@@ -1,13 +1,19 @@
 package types
 
 import (
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	stdlog "log"
 	"net/netip"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
@@ -17,6 +23,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"go4.org/netipx"
+	"google.golang.org/grpc/grpclog"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/dnstype"
@@ -39,13 +46,93 @@ const (
 	IPAllocationStrategyRandom     IPAllocationStrategy = "random"
 )
 
+// IPFamiliesConfig turns off an address family in the tailnet, for
+// environments with broken dual-stack handling. No addresses of a
+// disabled family are allocated, and the addresses and routes of the
+// family are removed from the map responses and the filter rules.
+type IPFamiliesConfig struct {
+	DisableIPv4 bool
+	DisableIPv6 bool
+}
+
+// Disabled reports if the address family of addr is disabled.
+func (c IPFamiliesConfig) Disabled(addr netip.Addr) bool {
+	return (c.DisableIPv4 && addr.Is4()) || (c.DisableIPv6 && addr.Is6())
+}
+
+// Enabled returns the prefix unless its address family is disabled.
+func (c IPFamiliesConfig) Enabled(prefix *netip.Prefix) *netip.Prefix {
+	if prefix == nil || c.Disabled(prefix.Addr()) {
+		return nil
+	}
+
+	return prefix
+}
+
 type PolicyMode string
 
+// NodeExpiryMode is how the peers of an expired node treat it.
+type NodeExpiryMode string
+
+const (
+	// NodeExpiryModeClient leaves it to the clients to stop talking to
+	// expired peers.
+	NodeExpiryModeClient NodeExpiryMode = "client"
+
+	// NodeExpiryModeSoft also takes expired nodes out of the packet
+	// filters, they stay in the netmaps of their peers marked expired.
+	NodeExpiryModeSoft NodeExpiryMode = "soft"
+)
+
+// MetricsCardinality is how finely the node, route and pre-auth key
+// gauges are broken down.
+type MetricsCardinality string
+
+const (
+	// MetricsCardinalityTailnet exports the totals of the tailnet.
+	MetricsCardinalityTailnet MetricsCardinality = "tailnet"
+
+	// MetricsCardinalityUser breaks the gauges down by user.
+	MetricsCardinalityUser MetricsCardinality = "user"
+
+	// MetricsCardinalityNode breaks the gauges down by node, the
+	// pre-auth key gauge by user.
+	MetricsCardinalityNode MetricsCardinality = "node"
+)
+
+// TracingProtocol is the OTLP transport the traces are exported with.
+type TracingProtocol string
+
+const (
+	TracingProtocolGRPC TracingProtocol = "grpc"
+	TracingProtocolHTTP TracingProtocol = "http"
+)
+
 const (
 	PolicyModeDB   = "database"
 	PolicyModeFile = "file"
 )
 
+// PolicyFeature is a construct of the policy that policy.disallow can
+// reject, for environments where it must never be used.
+type PolicyFeature string
+
+const (
+	// PolicyFeatureDangerAll is autogroup:danger-all in any rule.
+	PolicyFeatureDangerAll PolicyFeature = "danger-all"
+	// PolicyFeatureWildcard is an ACL from "*" to "*".
+	PolicyFeatureWildcard PolicyFeature = "wildcard"
+	// PolicyFeatureInternetAnyProtocol is an ACL to autogroup:internet
+	// without a protocol.
+	PolicyFeatureInternetAnyProtocol PolicyFeature = "internet-any-protocol"
+)
+
+var policyFeatures = []PolicyFeature{
+	PolicyFeatureDangerAll,
+	PolicyFeatureWildcard,
+	PolicyFeatureInternetAnyProtocol,
+}
+
 // Config contains the initial Headscale configuration.
 type Config struct {
 	ServerURL                      string
@@ -53,9 +140,29 @@ type Config struct {
 	MetricsAddr                    string
 	GRPCAddr                       string
 	GRPCAllowInsecure              bool
+	GRPCReflection                 bool
+	GRPCHealth                     bool
+	Listeners                      []ListenerConfig
+	ListenReusePort                bool
+	ProxyProtocol                  ProxyProtocolConfig
+	MapCompression                 MapCompressionConfig
+	MapSigning                     MapSigningConfig
+	NodeKeyRenewal                 NodeKeyRenewalConfig
+	NodeExpiry                     NodeExpiryConfig
+	OfflineNodes                   OfflineNodesConfig
+	Routes                         RoutesConfig
+	Metrics                        MetricsConfig
+	Tracing                        TracingConfig
+	ClientUpdates                  ClientUpdatesConfig
+	ClientPolicy                   ClientPolicyConfig
+	TailnetAdmin                   TailnetAdminConfig
+	Canary                         CanaryConfig
+	Replica                        ReplicaConfig
 	EphemeralNodeInactivityTimeout time.Duration
+	UserAliasExpiry                time.Duration
 	PrefixV4                       *netip.Prefix
 	PrefixV6                       *netip.Prefix
+	IPFamilies                     IPFamiliesConfig
 	IPAllocation                   IPAllocationStrategy
 	NoisePrivateKeyPath            string
 	BaseDomain                     string
@@ -73,20 +180,96 @@ type Config struct {
 
 	DNSConfig             *tailcfg.DNSConfig
 	DNSUserNameInMagicDNS bool
+	DNSHealthCheck        DNSHealthCheckConfig
+	ExitNodeDNSResolvers  []ExitNodeDNSResolvers
 
 	UnixSocket           string
 	UnixSocketPermission fs.FileMode
+	UnixSocketOwner      string
+	UnixSocketGroup      string
+	UnixSocketAccess     []UnixSocketAccessRule
 
 	OIDC OIDCConfig
 
 	LogTail             LogTailConfig
 	RandomizeClientPort bool
+	ClientTuning        []ClientTuning
 
 	CLI CLIConfig
 
 	Policy PolicyConfig
 
+	GeoIP GeoIPConfig
+
+	AnomalyDetection AnomalyDetectionConfig
+
+	Attestation AttestationConfig
+
+	RegistrationVerification RegistrationVerificationConfig
+
+	LocalAuth LocalAuthConfig
+
+	LDAP LDAPConfig
+
+	Portal PortalConfig
+
+	Backup BackupConfig
+
+	Debug DebugConfig
+
 	Tuning Tuning
+
+	// reloadable is the snapshot of the settings published by the last
+	// reload, nil until the first one.
+	reloadable atomic.Pointer[ReloadableConfig]
+}
+
+// ReloadableConfig holds the settings that can change while headscale
+// runs. A reload publishes a new ReloadableConfig instead of changing
+// the one in use, so readers never see half of a reload.
+type ReloadableConfig struct {
+	DNSConfig             *tailcfg.DNSConfig
+	BaseDomain            string
+	DNSUserNameInMagicDNS bool
+	ExitNodeDNSResolvers  []ExitNodeDNSResolvers
+
+	DERPURLs  []url.URL
+	DERPPaths []string
+
+	OIDCAllowedDomains []string
+	OIDCAllowedUsers   []string
+	OIDCAllowedGroups  []string
+}
+
+// NewReloadableConfig returns the settings of c that can be reloaded.
+func NewReloadableConfig(c *Config) *ReloadableConfig {
+	return &ReloadableConfig{
+		DNSConfig:             c.DNSConfig,
+		BaseDomain:            c.BaseDomain,
+		DNSUserNameInMagicDNS: c.DNSUserNameInMagicDNS,
+		ExitNodeDNSResolvers:  c.ExitNodeDNSResolvers,
+		DERPURLs:              c.DERP.URLs,
+		DERPPaths:             c.DERP.Paths,
+		OIDCAllowedDomains:    c.OIDC.AllowedDomains,
+		OIDCAllowedUsers:      c.OIDC.AllowedUsers,
+		OIDCAllowedGroups:     c.OIDC.AllowedGroups,
+	}
+}
+
+// Reloadable returns the settings that can be reloaded, as published by
+// the last reload, or as loaded at startup. The returned value must not
+// be modified.
+func (c *Config) Reloadable() *ReloadableConfig {
+	if r := c.reloadable.Load(); r != nil {
+		return r
+	}
+
+	return NewReloadableConfig(c)
+}
+
+// SetReloadable publishes r as the settings that can be reloaded.
+func (c *Config) SetReloadable(r *ReloadableConfig) {
+	c.reloadable.Store(r)
 }
 
 type DNSConfig struct {
@@ -99,15 +282,48 @@ type DNSConfig struct {
 }
 
 type Nameservers struct {
-	Global []string
-	Split  map[string][]string
+	Global      []string
+	Split       map[string][]string
+	HealthCheck DNSHealthCheckConfig
+}
+
+// DNSHealthCheckConfig configures the health checks of the nameservers.
+// Nameservers that do not answer are left out of the DNS configuration
+// of the clients until they answer again.
+type DNSHealthCheckConfig struct {
+	Interval time.Duration
+	Timeout  time.Duration
 }
 
 type SqliteConfig struct {
 	Path          string
 	WriteAheadLog bool
+
+	// JournalMode is the SQLite journal mode, when empty it is wal if
+	// WriteAheadLog is set.
+	JournalMode string
+
+	// BusyTimeout is how long a query waits for a lock held by another
+	// connection, like a backup tool reading the database.
+	BusyTimeout time.Duration
+
+	// WALAutocheckpoint is the number of WAL pages after which SQLite
+	// checkpoints on commit, 0 leaves checkpointing to CheckpointInterval
+	// or tools like litestream.
+	WALAutocheckpoint int
+
+	// CheckpointInterval is how often the WAL is checkpointed with
+	// CheckpointMode, 0 disables periodic checkpoints.
+	CheckpointInterval time.Duration
+	CheckpointMode     string
 }
 
+// SQLite journal and checkpoint modes headscale accepts.
+var (
+	sqliteJournalModes    = []string{"delete", "truncate", "persist", "memory", "wal", "off"}
+	sqliteCheckpointModes = []string{"passive", "full", "restart", "truncate"}
+)
+
 type PostgresConfig struct {
 	Host                string
 	Port                int
@@ -138,6 +354,25 @@ type DatabaseConfig struct {
 
 	Sqlite   SqliteConfig
 	Postgres PostgresConfig
+
+	GC DatabaseGCConfig
+
+	// ReadOnly rejects writes and skips the migrations, for replicas
+	// reading the database of the primary.
+	ReadOnly bool
+}
+
+// DatabaseGCConfig configures the periodic database cleanup. Orphaned
+// rows are always removed, the retentions limit how long expired records
+// are kept, 0 keeps them.
+type DatabaseGCConfig struct {
+	// Interval is how often the cleanup runs, 0 disables it.
+	Interval time.Duration
+
+	PreAuthKeyRetention     time.Duration
+	APIKeyRetention         time.Duration
+	NetcheckReportRetention time.Duration
+	RouteEventRetention     time.Duration
 }
 
 type TLSConfig struct {
@@ -147,6 +382,485 @@ type TLSConfig struct {
 	LetsEncrypt LetsEncryptConfig
 }
 
+// ListenerType is the service served on a listener.
+type ListenerType string
+
+const (
+	// ListenerHTTP serves the control protocol, the embedded DERP
+	// server and the REST API.
+	ListenerHTTP    ListenerType = "http"
+	ListenerGRPC    ListenerType = "grpc"
+	ListenerMetrics ListenerType = "metrics"
+	ListenerSTUN    ListenerType = "stun"
+)
+
+// ListenerConfig is an additional address headscale listens on, next
+// to listen_addr, grpc_listen_addr, metrics_listen_addr and
+// derp.server.stun_listen_addr.
+type ListenerConfig struct {
+	Type ListenerType `mapstructure:"type"`
+	Addr string       `mapstructure:"addr"`
+
+	// Network is one of tcp, tcp4 or tcp6 (udp, udp4 or udp6 for STUN).
+	// tcp6 and udp6 do not accept IPv4 connections on wildcard addresses.
+	Network string `mapstructure:"network"`
+
+	// TLSCertPath and TLSKeyPath override the TLS certificate
+	// of the listener, by default the global TLS settings are used.
+	TLSCertPath string `mapstructure:"tls_cert_path"`
+	TLSKeyPath  string `mapstructure:"tls_key_path"`
+
+	// Insecure disables TLS on the listener even if TLS is configured
+	// globally.
+	Insecure bool `mapstructure:"insecure"`
+}
+
+// APIScope limits the gRPC methods a client may call.
+type APIScope string
+
+const (
+	// APIScopeAdmin allows all methods.
+	APIScopeAdmin APIScope = "admin"
+
+	// APIScopeRead allows the methods that do not change anything.
+	APIScopeRead APIScope = "read"
+)
+
+// UnixSocketAccessRule gives the processes of some OS users and groups
+// a scope on the unix socket, based on the peer credentials of their
+// connections.
+type UnixSocketAccessRule struct {
+	Users  []string `mapstructure:"users"`
+	Groups []string `mapstructure:"groups"`
+	Scope  APIScope `mapstructure:"scope"`
+}
+
+// MapCompressionConfig tunes the compression of map responses, with the
+// method each client asks for. Tailscale clients ask for zstd, brotli
+// is offered to other clients.
+type MapCompressionConfig struct {
+	// ZstdLevel is fastest, default, better or best.
+	ZstdLevel string
+
+	// BrotliQuality is between 1 and 11.
+	BrotliQuality int
+}
+
+// MapSigningConfig signs the DERPMap and the DNS configuration sent to
+// the nodes, the signature and the public key are sent as capabilities
+// of the node.
+type MapSigningConfig struct {
+	Enabled bool
+
+	// PrivateKeyPath is the PKCS #8 encoded ed25519 key, created if
+	// missing.
+	PrivateKeyPath string
+}
+
+// NodeKeyRenewalConfig controls how nodes rotate their node key while
+// keeping their machine key, without authenticating again.
+type NodeKeyRenewalConfig struct {
+	// Seamless lets clients renew their node key without breaking
+	// their connections.
+	Seamless bool
+
+	// Expiry is the key expiry given to nodes renewing their key,
+	// counted from the renewal. Zero keeps the expiry of the node.
+	Expiry time.Duration
+}
+
+// NodeExpiryConfig controls what happens to nodes whose key expired.
+type NodeExpiryConfig struct {
+	Mode NodeExpiryMode
+}
+
+// Actions the offline node reaper takes on nodes.
+const (
+	OfflineNodeActionDelete = "delete"
+	OfflineNodeActionExpire = "expire"
+)
+
+// OfflineNodesConfig configures the reaper of nodes that have been
+// offline for a long time. Ephemeral nodes have their own, shorter,
+// inactivity timeout.
+type OfflineNodesConfig struct {
+	// After is how long a node must not have been seen to be reaped,
+	// 0 disables the reaper.
+	After time.Duration
+
+	// Interval is how often offline nodes are looked for.
+	Interval time.Duration
+
+	// Action is one of the OfflineNodeAction* actions.
+	Action string
+
+	// ExemptTags are the tags of nodes that are never reaped.
+	ExemptTags []string
+
+	// DryRun only reports the nodes that would be reaped.
+	DryRun bool
+
+	// WebhookURL receives a JSON POST for every node before it is
+	// reaped if set.
+	WebhookURL string
+}
+
+// RoutesConfig configures the subnet and exit routes of nodes.
+type RoutesConfig struct {
+	// StaleGracePeriod is how long a route stays enabled after its
+	// node stopped advertising it, before it is removed. 0 disables
+	// the route right away and keeps it.
+	StaleGracePeriod time.Duration
+}
+
+// MetricsConfig configures the metrics served on metrics_listen_addr.
+type MetricsConfig struct {
+	Cardinality MetricsCardinality
+}
+
+// TracingConfig configures the OpenTelemetry traces of the
+// registration, map and policy compile paths.
+type TracingConfig struct {
+	Enabled bool
+
+	// Endpoint is the OTLP collector, host:port for grpc, a URL or
+	// host:port for http. Empty uses the OTEL_EXPORTER_OTLP_ENDPOINT
+	// environment variable or the OTLP default on localhost.
+	Endpoint string
+
+	Protocol TracingProtocol
+
+	// Insecure exports without TLS.
+	Insecure bool
+
+	// SampleRatio is the share of traces kept, from 0 to 1.
+	SampleRatio float64
+}
+
+// ClientUpdatesConfig tells clients which Tailscale version they should
+// run.
+type ClientUpdatesConfig struct {
+	// LatestVersion is the version clients are told is the latest, like
+	// 1.72.1. Empty does not tell clients anything.
+	LatestVersion string
+
+	// Notify lets clients running an older version show a notification
+	// that an update is available.
+	Notify bool
+
+	// NotifyURL is opened when the notification is clicked.
+	NotifyURL string
+
+	// MinimumVersion is the oldest supported version. Clients running
+	// an older version are told they miss an urgent security update.
+	MinimumVersion string
+
+	// AutoUpdate turns on automatic updates on new nodes, unless their
+	// user turned them off.
+	AutoUpdate bool
+}
+
+// ClientPolicyConfig are the settings recommended for the Tailscale
+// clients, served as system policy keys for MDM and fleet management
+// tools together with the server URL.
+type ClientPolicyConfig struct {
+	// Settings maps the system policy keys of the clients, like
+	// UnattendedMode, to their value.
+	Settings map[string]string
+}
+
+// clientPolicyValues are the values accepted by the system policy keys
+// that only accept a few values, nil accepts any value.
+var clientPolicyValues = map[string][]string{
+	"Tailnet":                   nil,
+	"ExitNodeID":                nil,
+	"ExitNodeIP":                nil,
+	"AllowIncomingConnections":  {"always", "never", "user-decides"},
+	"UnattendedMode":            {"always", "never", "user-decides"},
+	"ExitNodeAllowLANAccess":    {"always", "never", "user-decides"},
+	"UseTailscaleDNSSettings":   {"always", "never", "user-decides"},
+	"UseTailscaleSubnets":       {"always", "never", "user-decides"},
+	"CheckUpdates":              {"always", "never", "user-decides"},
+	"InstallUpdates":            {"always", "never", "user-decides"},
+	"AdvertiseExitNode":         {"always", "never", "user-decides"},
+	"PostureChecking":           {"always", "never", "user-decides"},
+	"AdminConsole":              {"show", "hide"},
+	"NetworkDevices":            {"show", "hide"},
+	"TestMenu":                  {"show", "hide"},
+	"UpdateMenu":                {"show", "hide"},
+	"ResetToDefaults":           {"show", "hide"},
+	"RunExitNode":               {"show", "hide"},
+	"PreferencesMenu":           {"show", "hide"},
+	"ExitNodesPicker":           {"show", "hide"},
+	"ApplyUpdates":              {"show", "hide"},
+	"SuggestedExitNode":         {"show", "hide"},
+	"KeyExpirationNotice":       nil,
+	"ManagedByOrganizationName": nil,
+	"ManagedByCaption":          nil,
+	"ManagedByURL":              nil,
+}
+
+// ProxyProtocolConfig enables PROXY protocol (v1 and v2) headers on the
+// HTTP listeners, so the client address is preserved behind load balancers.
+type ProxyProtocolConfig struct {
+	Enabled bool
+
+	// TrustedProxies are the sources headers are accepted from,
+	// headers from other sources are ignored. It must not be empty
+	// when Enabled is set.
+	TrustedProxies []string
+}
+
+// TailnetAdminConfig configures an embedded Tailscale node that serves
+// the admin API and metrics on its tailnet address.
+type TailnetAdminConfig struct {
+	Enabled  bool
+	Hostname string
+	StateDir string
+	AuthKey  string
+
+	// ControlURL is the control server the node joins, by default this
+	// headscale.
+	ControlURL string
+	Ephemeral  bool
+
+	// GRPCPort, HTTPPort and MetricsPort are the tailnet ports the gRPC
+	// API, the REST API and the metrics are served on, 0 disables them.
+	GRPCPort    int
+	HTTPPort    int
+	MetricsPort int
+
+	// Exclusive stops serving the gRPC and REST API and the metrics on
+	// the regular listeners, they are only reachable over the tailnet.
+	Exclusive bool
+}
+
+// ReplicaConfig runs headscale as a disaster recovery standby reading
+// the database of a primary. It takes over serving map responses, read
+// only, when the primary stops answering its health checks.
+type ReplicaConfig struct {
+	Enabled bool
+
+	// PrimaryURL is the URL the /health of the primary is checked at.
+	PrimaryURL string
+
+	HealthCheckInterval time.Duration
+
+	// FailoverAfter is how long the primary has to fail its health
+	// checks before the replica takes over.
+	FailoverAfter time.Duration
+
+	// RefreshInterval is how often the replica sends the nodes the
+	// changes of the database while it serves them.
+	RefreshInterval time.Duration
+}
+
+// CanaryConfig configures a synthetic node that regularly registers,
+// fetches a map and pings a peer, to monitor headscale end to end.
+type CanaryConfig struct {
+	Enabled  bool
+	Hostname string
+	StateDir string
+
+	// AuthKey is a reusable pre auth key, every run registers a new
+	// ephemeral node.
+	AuthKey string
+
+	// ControlURL is the control server the canary joins, by default
+	// this headscale.
+	ControlURL string
+
+	Interval time.Duration
+	Timeout  time.Duration
+
+	// Peer is the hostname or the tailnet address of the node pinged,
+	// when empty the canary does not ping.
+	Peer string
+}
+
+// GeoIPConfig configures the offline MMDB database used to
+// geolocate the public addresses of nodes.
+type GeoIPConfig struct {
+	DatabasePath string
+}
+
+// AttestationConfig holds the roots the certificate chains of hardware
+// attestations sent by clients are verified with.
+type AttestationConfig struct {
+	RootsPath string
+
+	// Roots is nil when no roots are configured, attestations are then
+	// recorded but never verified.
+	Roots *x509.CertPool
+}
+
+// AnomalyDetectionConfig configures the detection of nodes connecting
+// from distant locations within a short time ("impossible travel").
+// It requires a geoip database with coordinates.
+type AnomalyDetectionConfig struct {
+	Enabled bool
+
+	// MaxSpeedKmh is the fastest a node is expected to travel between
+	// two connections.
+	MaxSpeedKmh float64
+
+	// MinDistanceKm is the distance below which movement is ignored,
+	// as geolocation of nearby addresses is imprecise.
+	MinDistanceKm float64
+
+	// WebhookURL receives a JSON POST for every alert if set.
+	WebhookURL string
+
+	// Quarantine expires the node when an alert is raised, requiring
+	// it to be re-authenticated.
+	Quarantine bool
+}
+
+// Methods users can verify the interactive registration of a new node
+// with.
+const (
+	RegistrationVerificationEmail   = "email"
+	RegistrationVerificationWebhook = "webhook"
+	RegistrationVerificationCode    = "code"
+)
+
+// RegistrationVerificationConfig configures an additional step before a
+// node is registered with OIDC, so a leaked registration URL cannot be
+// used to register nodes.
+type RegistrationVerificationConfig struct {
+	// Method is one of the RegistrationVerification* methods, or empty
+	// to register nodes without verification.
+	Method string
+
+	// Code is the code users have to enter, handed out by an admin.
+	Code string
+
+	// WebhookURL receives a JSON POST with the verification link.
+	WebhookURL string
+
+	// SMTP is the server the verification link is emailed with.
+	SMTP SMTPConfig
+}
+
+// Enabled reports if new nodes have to be verified.
+func (c *RegistrationVerificationConfig) Enabled() bool {
+	return c.Method != ""
+}
+
+// LocalAuthConfig configures the built-in username and password
+// authentication of the interactive registration flow.
+type LocalAuthConfig struct {
+	Enabled bool
+
+	// RequireTOTP rejects users without a TOTP secret.
+	RequireTOTP bool
+
+	// TOTPIssuer is the name authenticator apps show for the secret.
+	TOTPIssuer string
+}
+
+// PortalConfig configures the self-service portal, where users see
+// their own nodes, rename and expire them, and create personal
+// pre-auth keys within quotas.
+type PortalConfig struct {
+	Enabled bool
+
+	// SessionExpiration is how long a sign in to the portal lasts.
+	SessionExpiration time.Duration
+
+	// MaxPreAuthKeys is the number of usable personal pre-auth keys a
+	// user may have, 0 keeps users from creating them.
+	MaxPreAuthKeys int
+
+	// MaxPreAuthKeyExpiration is the longest expiration of the
+	// pre-auth keys users create.
+	MaxPreAuthKeyExpiration time.Duration
+
+	// AllowReusablePreAuthKeys lets users create reusable keys.
+	AllowReusablePreAuthKeys bool
+}
+
+// LDAPConfig configures authentication of the interactive registration
+// flow against an LDAP directory, and groups resolved from it.
+type LDAPConfig struct {
+	// URL of the server, ldap:// or ldaps://. LDAP is disabled if empty.
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+
+	// BindDN and BindPassword are the service account users and groups
+	// are searched with, the search is anonymous if BindDN is empty.
+	BindDN       string
+	BindPassword string
+
+	// UserFilter finds users, {username} is replaced with the escaped
+	// username entered at sign in.
+	UserBaseDN        string
+	UserFilter        string
+	UsernameAttribute string
+
+	GroupBaseDN          string
+	GroupFilter          string
+	GroupNameAttribute   string
+	GroupMemberAttribute string
+
+	// GroupSyncInterval is how often groups are read from the
+	// directory, 0 disables group resolution.
+	GroupSyncInterval time.Duration
+}
+
+// Enabled reports if a LDAP server is configured.
+func (c *LDAPConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// BackupConfig configures scheduled database backups.
+type BackupConfig struct {
+	// Schedule is a cron expression in the local time zone of the
+	// server, backups are disabled if empty.
+	Schedule string
+
+	// Directory is where backups are written, and kept when they are
+	// also uploaded to S3.
+	Directory string
+
+	// Keep is the number of backups kept, 0 keeps all of them.
+	Keep int
+
+	// MaxAge removes backups older than it, 0 keeps them forever.
+	MaxAge time.Duration
+
+	S3 BackupS3Config
+}
+
+// BackupS3Config is an S3 compatible bucket backups are uploaded to,
+// uploads are disabled if Bucket is empty.
+type BackupS3Config struct {
+	Bucket   string
+	Prefix   string
+	Endpoint string
+	Region   string
+
+	// AccessKeyID and SecretAccessKey are optional, the AWS environment
+	// variables and shared configuration are used if they are empty.
+	AccessKeyID     string
+	SecretAccessKey string
+	UsePathStyle    bool
+}
+
+// Enabled reports if scheduled backups are configured.
+func (c *BackupConfig) Enabled() bool {
+	return c.Schedule != ""
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
 type LetsEncryptConfig struct {
 	Listen        string
 	Hostname      string
@@ -156,21 +870,130 @@ type LetsEncryptConfig struct {
 
 type OIDCConfig struct {
 	OnlyStartIfOIDCIsAvailable bool
+	Name                       string
 	Issuer                     string
 	ClientID                   string
 	ClientSecret               string
 	Scope                      []string
 	ExtraParams                map[string]string
+	Domains                    []string
+	DeviceFlow                 bool
+	PKCE                       bool
+	ClientAuthMethod           string
+	ClientPrivateKeyPath       string
+	ClientKeyID                string
 	AllowedDomains             []string
 	AllowedUsers               []string
 	AllowedGroups              []string
 	StripEmaildomain           bool
 	Expiry                     time.Duration
 	UseExpiryFromToken         bool
+
+	// AdditionalProviders are used next to the provider configured
+	// above, users pick one or are routed by their email domain.
+	AdditionalProviders []OIDCProviderConfig
+
+	// ClaimTags force tags on the nodes registered by users with
+	// matching ID token claims.
+	ClaimTags []OIDCClaimTag
+
+	// AllowlistCheckInterval is how often the allowed domains, groups
+	// and users are checked again with the refresh tokens of the
+	// logins, zero only checks them at login.
+	AllowlistCheckInterval time.Duration
+
+	// AllowlistGracePeriod is how long a user the identity provider
+	// no longer allows keeps its nodes.
+	AllowlistGracePeriod time.Duration
+}
+
+// OIDCClaimTag forces tags on the nodes registered by users whose ID
+// token has the claim with the value, or the value in its list.
+type OIDCClaimTag struct {
+	Claim string   `mapstructure:"claim"`
+	Value string   `mapstructure:"value"`
+	Tags  []string `mapstructure:"tags"`
+}
+
+// OIDCProviderConfig is an OIDC issuer users can authenticate with.
+type OIDCProviderConfig struct {
+	Name             string            `mapstructure:"name"`
+	Issuer           string            `mapstructure:"issuer"`
+	ClientID         string            `mapstructure:"client_id"`
+	ClientSecret     string            `mapstructure:"client_secret"`
+	ClientSecretPath string            `mapstructure:"client_secret_path"`
+	Scope            []string          `mapstructure:"scope"`
+	ExtraParams      map[string]string `mapstructure:"extra_params"`
+
+	// Domains are the email domains routed to this provider.
+	Domains []string `mapstructure:"domains"`
+
+	// DeviceFlow registers nodes with the OAuth device authorization
+	// grant, nodes get a short code to approve on any device.
+	DeviceFlow bool `mapstructure:"device_flow"`
+
+	// PKCE adds a proof key to the authorization code flow, some
+	// providers require it for every client.
+	PKCE bool `mapstructure:"pkce"`
+
+	// ClientAuthMethod is how headscale authenticates at the token
+	// endpoint, one of the OIDCClientAuth* methods. When empty the
+	// method is detected from the provider.
+	ClientAuthMethod string `mapstructure:"client_auth_method"`
+
+	// ClientPrivateKeyPath is a PEM encoded RSA or EC private key used
+	// to sign client assertions with private_key_jwt.
+	ClientPrivateKeyPath string `mapstructure:"client_private_key_path"`
+
+	// ClientKeyID is sent as the kid header of client assertions.
+	ClientKeyID string `mapstructure:"client_key_id"`
+}
+
+// Methods headscale can authenticate at the token endpoint of an OIDC
+// provider with.
+const (
+	OIDCClientAuthSecretBasic   = "client_secret_basic"
+	OIDCClientAuthSecretPost    = "client_secret_post"
+	OIDCClientAuthSecretJWT     = "client_secret_jwt"
+	OIDCClientAuthPrivateKeyJWT = "private_key_jwt"
+)
+
+// Providers returns all configured OIDC providers, the provider
+// configured in oidc first.
+func (c *OIDCConfig) Providers() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	if c.Issuer != "" {
+		providers = append(providers, OIDCProviderConfig{
+			Name:         c.Name,
+			Issuer:       c.Issuer,
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Scope:        c.Scope,
+			ExtraParams:  c.ExtraParams,
+			Domains:      c.Domains,
+			DeviceFlow:   c.DeviceFlow,
+
+			PKCE:                 c.PKCE,
+			ClientAuthMethod:     c.ClientAuthMethod,
+			ClientPrivateKeyPath: c.ClientPrivateKeyPath,
+			ClientKeyID:          c.ClientKeyID,
+		})
+	}
+
+	return append(providers, c.AdditionalProviders...)
+}
+
+// HandlesDomain reports if users with the given email domain are
+// routed to the provider.
+func (p *OIDCProviderConfig) HandlesDomain(domain string) bool {
+	return slices.ContainsFunc(p.Domains, func(d string) bool {
+		return strings.EqualFold(d, domain)
+	})
 }
 
 type DERPConfig struct {
 	ServerEnabled                      bool
+	STUNOnly                           bool
 	AutomaticallyAddEmbeddedDerpRegion bool
 	ServerRegionID                     int
 	ServerRegionCode                   string
@@ -183,88 +1006,417 @@ type DERPConfig struct {
 	UpdateFrequency                    time.Duration
 	IPv4                               string
 	IPv6                               string
+	RateLimits                         []DERPRateLimit
+
+	// ServerNodeName, ServerHostname, ServerDERPPort and ServerSTUNPort
+	// override the values derived from server_url and stun_listen_addr
+	// in the DERP map entry of the embedded server.
+	ServerNodeName string
+	ServerHostname string
+	ServerDERPPort int
+	ServerSTUNPort int
+	CanPort80      bool
+	Latitude       float64
+	Longitude      float64
+
+	// RelaysEnabled lets standalone DERP servers register themselves
+	// with the API, they are added to the DERPMap as their own region
+	// until they miss their heartbeats for RelayExpiry.
+	RelaysEnabled bool
+	RelayExpiry   time.Duration
 }
 
-type LogTailConfig struct {
-	Enabled bool
+// DERPRateLimit caps the traffic the embedded DERP server relays for
+// a node. A node matches the limit if its node key, user or one of its
+// tags is listed.
+type DERPRateLimit struct {
+	NodeKeys      []string `mapstructure:"node_keys"`
+	Users         []string `mapstructure:"users"`
+	Tags          []string `mapstructure:"tags"`
+	BitsPerSecond int      `mapstructure:"bits_per_second"`
+	BurstBytes    int      `mapstructure:"burst_bytes"`
 }
 
-type CLIConfig struct {
-	Address  string
-	APIKey   string
-	Timeout  time.Duration
-	Insecure bool
-}
+// Matches reports if the limit applies to the node with the given tags.
+func (l *DERPRateLimit) Matches(node *Node, tags []string) bool {
+	if slices.Contains(l.NodeKeys, node.NodeKey.String()) {
+		return true
+	}
 
-type PolicyConfig struct {
-	Path string
-	Mode PolicyMode
-}
+	if slices.Contains(l.Users, node.User.Name) {
+		return true
+	}
 
-type LogConfig struct {
-	Format string
-	Level  zerolog.Level
-}
+	for _, tag := range tags {
+		if slices.Contains(l.Tags, tag) {
+			return true
+		}
+	}
 
-type Tuning struct {
-	NotifierSendTimeout            time.Duration
-	BatchChangeDelay               time.Duration
-	NodeMapSessionBufferedChanSize int
+	return false
 }
 
-// LoadConfig prepares and loads the Headscale configuration into Viper.
-// This means it sets the default values, reads the configuration file and
-// environment variables, and handles deprecated configuration options.
-// It has to be called before LoadServerConfig and LoadCLIConfig.
-// The configuration is not validated and the caller should check for errors
-// using a validation function.
-func LoadConfig(path string, isFile bool) error {
-	if isFile {
-		viper.SetConfigFile(path)
-	} else {
-		viper.SetConfigName("config")
-		if path == "" {
-			viper.AddConfigPath("/etc/headscale/")
-			viper.AddConfigPath("$HOME/.headscale")
-			viper.AddConfigPath(".")
-		} else {
-			// For testing
-			viper.AddConfigPath(path)
+// RateLimitFor returns the first configured DERP rate limit matching
+// the node, or nil if the node is not limited.
+func (d *DERPConfig) RateLimitFor(node *Node, tags []string) *DERPRateLimit {
+	for i := range d.RateLimits {
+		if d.RateLimits[i].Matches(node, tags) {
+			return &d.RateLimits[i]
 		}
 	}
 
-	envPrefix := "headscale"
-	viper.SetEnvPrefix(envPrefix)
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	return nil
+}
+
+// ClientTuning holds control plane knobs pushed to the nodes it
+// matches, to troubleshoot networks where the defaults perform poorly.
+// A node matches if its name or one of its tags is listed, or if it is
+// seen from one of the networks.
+type ClientTuning struct {
+	Nodes []string `mapstructure:"nodes"`
+	Tags  []string `mapstructure:"tags"`
+
+	// Networks are matched against the endpoints of the node and the
+	// address it last connected from, to tune the nodes of a site.
+	Networks []string       `mapstructure:"networks"`
+	Prefixes []netip.Prefix `mapstructure:"-"`
+
+	// KeepAliveInterval is how often headscale sends keep-alives on the
+	// map stream of the node, zero keeps the default.
+	KeepAliveInterval time.Duration `mapstructure:"keepalive_interval"`
+	OnlyTCP443        bool          `mapstructure:"only_tcp_443"`
+	PeerMTUDiscovery  bool          `mapstructure:"peer_mtu_discovery"`
+	DisableUPnP       bool          `mapstructure:"disable_upnp"`
+
+	// RandomizeClientPort makes the node use a random UDP port, which
+	// some NATs and firewalls handle better than the default one.
+	RandomizeClientPort bool `mapstructure:"randomize_client_port"`
+
+	// PreferDERPRegion makes the node pick this region as its home DERP
+	// region, the other regions are marked to be avoided in its DERP
+	// map. Nodes behind hard NATs relaying their traffic can then share
+	// a region close to them.
+	PreferDERPRegion int `mapstructure:"prefer_derp_region"`
+
+	// DisableSSH turns off the Tailscale SSH server of the node,
+	// whatever the SSH rules of the policy allow.
+	DisableSSH bool `mapstructure:"disable_ssh"`
+}
+
+// Matches reports if the tuning applies to the node with the given tags.
+func (t *ClientTuning) Matches(node *Node, tags []string) bool {
+	if slices.Contains(t.Nodes, node.GivenName) || slices.Contains(t.Nodes, node.Hostname) {
+		return true
+	}
+
+	for _, tag := range tags {
+		if slices.Contains(t.Tags, tag) {
+			return true
+		}
+	}
+
+	if len(t.Prefixes) > 0 {
+		addrs := make([]netip.Addr, 0, len(node.Endpoints)+1)
+		for _, endpoint := range node.Endpoints {
+			addrs = append(addrs, endpoint.Addr())
+		}
+		if node.LastSeenAddr != nil {
+			addrs = append(addrs, *node.LastSeenAddr)
+		}
+
+		for _, prefix := range t.Prefixes {
+			if slices.ContainsFunc(addrs, prefix.Contains) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NodeAttrs returns the node attributes sent to the node for the tuning.
+func (t *ClientTuning) NodeAttrs() []tailcfg.NodeCapability {
+	var attrs []tailcfg.NodeCapability
+	if t.OnlyTCP443 {
+		attrs = append(attrs, tailcfg.NodeAttrOnlyTCP443)
+	}
+	if t.PeerMTUDiscovery {
+		attrs = append(attrs, tailcfg.NodeAttrPeerMTUEnable)
+	}
+	if t.DisableUPnP {
+		attrs = append(attrs, tailcfg.NodeAttrDisableUPnP)
+	}
+	if t.RandomizeClientPort {
+		attrs = append(attrs, tailcfg.NodeAttrRandomizeClientPort)
+	}
+
+	return attrs
+}
+
+// ClientTuningFor returns the first configured client tuning matching
+// the node, or nil if the node uses the defaults.
+func (c *Config) ClientTuningFor(node *Node, tags []string) *ClientTuning {
+	for i := range c.ClientTuning {
+		if c.ClientTuning[i].Matches(node, tags) {
+			return &c.ClientTuning[i]
+		}
+	}
+
+	return nil
+}
+
+// ExitNodeDNSResolvers are the DNS resolvers clients use while an exit
+// node with one of the tags routes their traffic, like the internal
+// resolvers of the network of the exit node.
+type ExitNodeDNSResolvers struct {
+	Tags        []string `mapstructure:"tags"`
+	Nameservers []string `mapstructure:"nameservers"`
+
+	Resolvers []*dnstype.Resolver `mapstructure:"-"`
+}
+
+// ExitNodeDNSResolversFor returns the DNS resolvers of the first entry
+// of dns.exit_node_resolvers matching one of the tags of an exit node.
+func (c *Config) ExitNodeDNSResolversFor(tags []string) []*dnstype.Resolver {
+	for _, entry := range c.Reloadable().ExitNodeDNSResolvers {
+		for _, tag := range tags {
+			if slices.Contains(entry.Tags, tag) {
+				return entry.Resolvers
+			}
+		}
+	}
+
+	return nil
+}
+
+type LogTailConfig struct {
+	Enabled bool
+}
+
+type CLIConfig struct {
+	Address  string
+	APIKey   string
+	Timeout  time.Duration
+	Insecure bool
+
+	// Plaintext connects without TLS, for the tailnet admin node where
+	// WireGuard encrypts the connection.
+	Plaintext bool
+
+	// TLSFingerprint pins the certificate of the server.
+	TLSFingerprint string
+}
+
+type PolicyConfig struct {
+	Path string
+	Mode PolicyMode
+
+	// StatsInterval is how often the nodes each ACL rule applies to are
+	// counted, to report the rules that stay unused. Zero disables it.
+	StatsInterval time.Duration
+
+	// Disallow lists the features a policy must not use, loading a
+	// policy using one of them fails.
+	Disallow []PolicyFeature
+
+	// Strict rejects policies using deprecated forms of the policy
+	// format, instead of logging a warning for each of them.
+	Strict bool
+
+	Rollout PolicyRolloutConfig
+}
+
+// PolicyRolloutConfig configures how the canary nodes of a policy
+// rollout are watched.
+type PolicyRolloutConfig struct {
+	// CheckInterval is how often the canary nodes are checked, and the
+	// probes pinged.
+	CheckInterval time.Duration
+
+	// ProbeFailures is how many pings of a probe in a row a canary node
+	// can fail before the rollout is rolled back.
+	ProbeFailures int
+}
+
+type LogConfig struct {
+	Format string
+	Level  zerolog.Level
+
+	// Levels overrides Level for subsystems, it can be changed at
+	// runtime through the API.
+	Levels map[util.LogSubsystem]zerolog.Level
+
+	// Redact removes keys, auth keys and tokens from the logs. Turning
+	// it off logs everything, for development.
+	Redact bool
+}
+
+// DebugConfig enables endpoints to debug a running headscale.
+type DebugConfig struct {
+	// ProfilingEnabled serves pprof, trace and expvar behind API key
+	// authentication on the main listener and allows collecting
+	// profiles through the API.
+	ProfilingEnabled bool
+}
+
+type Tuning struct {
+	NotifierSendTimeout            time.Duration
+	BatchChangeDelay               time.Duration
+	NodeMapSessionBufferedChanSize int
+
+	// LastSeenPersistInterval is how often the last seen time of
+	// active nodes is written to the database.
+	LastSeenPersistInterval time.Duration
+
+	// PolicyCompileWorkers is the number of map responses compiling
+	// their policy at the same time, one per CPU if zero.
+	PolicyCompileWorkers int
+}
+
+// LoadConfig prepares and loads the Headscale configuration into Viper.
+// This means it sets the default values, reads the configuration file and
+// environment variables, and handles deprecated configuration options.
+// It has to be called before LoadServerConfig and LoadCLIConfig.
+// The configuration is not validated and the caller should check for errors
+// using a validation function.
+func LoadConfig(path string, isFile bool) error {
+	if isFile {
+		viper.SetConfigFile(path)
+	} else {
+		viper.SetConfigName("config")
+		if path == "" {
+			viper.AddConfigPath("/etc/headscale/")
+			viper.AddConfigPath("$HOME/.headscale")
+			viper.AddConfigPath(".")
+		} else {
+			// For testing
+			viper.AddConfigPath(path)
+		}
+	}
+
+	envPrefix := "headscale"
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	viper.SetDefault("policy.mode", "file")
+	viper.SetDefault("policy.stats_interval", "5m")
+	viper.SetDefault("policy.strict", false)
+	viper.SetDefault("policy.rollout.check_interval", "1m")
+	viper.SetDefault("policy.rollout.probe_failures", 3)
+
+	viper.SetDefault("strict_config", false)
 
 	viper.SetDefault("tls_letsencrypt_cache_dir", "/var/www/.cache")
 	viper.SetDefault("tls_letsencrypt_challenge_type", HTTP01ChallengeType)
 
 	viper.SetDefault("log.level", "info")
 	viper.SetDefault("log.format", TextLogFormat)
+	viper.SetDefault("log.redact", true)
 
 	viper.SetDefault("dns.magic_dns", true)
 	viper.SetDefault("dns.base_domain", "")
 	viper.SetDefault("dns.nameservers.global", []string{})
 	viper.SetDefault("dns.nameservers.split", map[string]string{})
+	viper.SetDefault("client_policy.settings", map[string]string{})
+	viper.SetDefault("dns.nameservers.health_check.interval", "0s")
+	viper.SetDefault("dns.nameservers.health_check.timeout", "2s")
 	viper.SetDefault("dns.search_domains", []string{})
 	viper.SetDefault("dns.extra_records", []tailcfg.DNSRecord{})
 
 	viper.SetDefault("derp.server.enabled", false)
 	viper.SetDefault("derp.server.stun.enabled", true)
+	viper.SetDefault("derp.server.stun_only", false)
 	viper.SetDefault("derp.server.automatically_add_embedded_derp_region", true)
+	viper.SetDefault("derp.relays.enabled", false)
+	viper.SetDefault("derp.relays.expiry", "5m")
 
 	viper.SetDefault("unix_socket", "/var/run/headscale/headscale.sock")
 	viper.SetDefault("unix_socket_permission", "0o770")
 
 	viper.SetDefault("grpc_listen_addr", ":50443")
 	viper.SetDefault("grpc_allow_insecure", false)
+	viper.SetDefault("grpc_reflection", false)
+	viper.SetDefault("grpc_health", false)
+
+	viper.SetDefault("listen_reuse_port", false)
+	viper.SetDefault("map_compression.zstd_level", "fastest")
+	viper.SetDefault("map_compression.brotli_quality", 4)
+	viper.SetDefault("map_signing.enabled", false)
+	viper.SetDefault("replica.enabled", false)
+	viper.SetDefault("replica.health_check_interval", "10s")
+	viper.SetDefault("replica.failover_after", "1m")
+	viper.SetDefault("replica.refresh_interval", "30s")
+
+	viper.SetDefault("node_key_renewal.seamless", true)
+	viper.SetDefault("node_key_renewal.expiry", "0s")
+
+	viper.SetDefault("node_expiry.mode", string(NodeExpiryModeClient))
+
+	viper.SetDefault("offline_nodes.after", "0s")
+	viper.SetDefault("offline_nodes.interval", "1h")
+	viper.SetDefault("offline_nodes.action", OfflineNodeActionDelete)
+	viper.SetDefault("offline_nodes.dry_run", false)
+
+	viper.SetDefault("routes.stale_grace_period", "0s")
+
+	viper.SetDefault("metrics.cardinality", string(MetricsCardinalityUser))
+
+	viper.SetDefault("tracing.enabled", false)
+	viper.SetDefault("tracing.protocol", string(TracingProtocolGRPC))
+	viper.SetDefault("tracing.insecure", false)
+	viper.SetDefault("tracing.sample_ratio", 1.0)
+
+	viper.SetDefault("client_updates.notify", true)
+
+	viper.SetDefault("proxy_protocol.enabled", false)
+	viper.SetDefault("proxy_protocol.trusted_proxies", []string{})
+
+	viper.SetDefault("tailnet_admin.enabled", false)
+	viper.SetDefault("tailnet_admin.hostname", "headscale-admin")
+	viper.SetDefault("tailnet_admin.state_dir", "/var/lib/headscale/tsnet")
+	viper.SetDefault("tailnet_admin.grpc_port", 50443)
+	viper.SetDefault("tailnet_admin.http_port", 80)
+	viper.SetDefault("tailnet_admin.metrics_port", 9090)
+	viper.SetDefault("tailnet_admin.exclusive", false)
+
+	viper.SetDefault("canary.enabled", false)
+	viper.SetDefault("canary.hostname", "headscale-canary")
+	viper.SetDefault("canary.state_dir", "/var/lib/headscale/canary")
+	viper.SetDefault("canary.interval", "5m")
+	viper.SetDefault("canary.timeout", "1m")
+
+	viper.SetDefault("anomaly_detection.enabled", false)
+	viper.SetDefault("anomaly_detection.max_speed_kmh", 1000)
+	viper.SetDefault("anomaly_detection.min_distance_km", 500)
+	viper.SetDefault("anomaly_detection.quarantine", false)
+
+	viper.SetDefault("registration_verification.smtp.port", 587)
+
+	viper.SetDefault("local_auth.enabled", false)
+	viper.SetDefault("local_auth.require_totp", false)
+	viper.SetDefault("local_auth.totp_issuer", "headscale")
+
+	viper.SetDefault("portal.enabled", false)
+	viper.SetDefault("portal.session_expiration", time.Hour)
+	viper.SetDefault("portal.preauth_keys.max_active", 3)
+	viper.SetDefault("portal.preauth_keys.max_expiration", 24*time.Hour)
+	viper.SetDefault("portal.preauth_keys.allow_reusable", false)
+
+	viper.SetDefault("ldap.user_filter", "(uid={username})")
+	viper.SetDefault("ldap.username_attribute", "uid")
+	viper.SetDefault("ldap.group_filter", "(objectClass=groupOfNames)")
+	viper.SetDefault("ldap.group_name_attribute", "cn")
+	viper.SetDefault("ldap.group_member_attribute", "member")
+	viper.SetDefault("ldap.group_sync_interval", "5m")
+
+	viper.SetDefault("backup.directory", "/var/lib/headscale/backups")
+	viper.SetDefault("backup.retention.keep", 7)
+	viper.SetDefault("backup.s3.prefix", "headscale/")
 
 	viper.SetDefault("cli.timeout", "5s")
 	viper.SetDefault("cli.insecure", false)
+	viper.SetDefault("cli.plaintext", false)
 
 	viper.SetDefault("database.postgres.ssl", false)
 	viper.SetDefault("database.postgres.max_open_conns", 10)
@@ -272,23 +1424,36 @@ func LoadConfig(path string, isFile bool) error {
 	viper.SetDefault("database.postgres.conn_max_idle_time_secs", 3600)
 
 	viper.SetDefault("database.sqlite.write_ahead_log", true)
+	viper.SetDefault("database.gc.interval", "24h")
+	viper.SetDefault("database.gc.netcheck_report_retention", "720h")
+	viper.SetDefault("database.gc.route_history_retention", "2160h")
+	viper.SetDefault("database.sqlite.busy_timeout", "10s")
+	viper.SetDefault("database.sqlite.wal_autocheckpoint", 0)
+	viper.SetDefault("database.sqlite.checkpoint_interval", "5m")
+	viper.SetDefault("database.sqlite.checkpoint_mode", "passive")
 
 	viper.SetDefault("oidc.scope", []string{oidc.ScopeOpenID, "profile", "email"})
 	viper.SetDefault("oidc.strip_email_domain", true)
 	viper.SetDefault("oidc.only_start_if_oidc_is_available", true)
 	viper.SetDefault("oidc.expiry", "180d")
 	viper.SetDefault("oidc.use_expiry_from_token", false)
+	viper.SetDefault("oidc.name", "default")
 
 	viper.SetDefault("logtail.enabled", false)
 	viper.SetDefault("randomize_client_port", false)
 
 	viper.SetDefault("ephemeral_node_inactivity_timeout", "120s")
+	viper.SetDefault("user_alias_expiry", "720h")
 
 	viper.SetDefault("tuning.notifier_send_timeout", "800ms")
 	viper.SetDefault("tuning.batch_change_delay", "800ms")
 	viper.SetDefault("tuning.node_mapsession_buffered_chan_size", 30)
+	viper.SetDefault("tuning.last_seen_persist_interval", "30s")
+	viper.SetDefault("tuning.policy_compile_workers", 0)
 
 	viper.SetDefault("prefixes.allocation", string(IPAllocationStrategySequential))
+	viper.SetDefault("prefixes.disable_v4", false)
+	viper.SetDefault("prefixes.disable_v6", false)
 
 	if err := viper.ReadInConfig(); err != nil {
 		return fmt.Errorf("fatal error reading config file: %w", err)
@@ -321,10 +1486,29 @@ func validateServerConfig() error {
 	depr.warn("dns_config.use_username_in_magic_dns")
 	depr.warn("dns.use_username_in_magic_dns")
 
-	depr.Log()
+	if err := depr.Log(); err != nil {
+		return err
+	}
 
 	// Collect any validation errors and return them all at once
 	var errorText string
+
+	unknownKeys, err := UnknownConfigKeys()
+	if err != nil {
+		return fmt.Errorf("reading configuration keys: %w", err)
+	}
+	if len(unknownKeys) > 0 {
+		if viper.GetBool("strict_config") {
+			errorText += fmt.Sprintf(
+				"Fatal config error: unknown configuration keys: %s\n",
+				strings.Join(unknownKeys, ", "),
+			)
+		} else {
+			log.Warn().
+				Strs("keys", unknownKeys).
+				Msg("Ignoring unknown configuration keys, set strict_config to refuse to start")
+		}
+	}
 	if (viper.GetString("tls_letsencrypt_hostname") != "") &&
 		((viper.GetString("tls_cert_path") != "") || (viper.GetString("tls_key_path") != "")) {
 		errorText += "Fatal config error: set either tls_letsencrypt_hostname or tls_cert_path/tls_key_path, not both\n"
@@ -390,8 +1574,9 @@ func tlsConfig() TLSConfig {
 	}
 }
 
-func derpConfig() DERPConfig {
+func derpConfig() (DERPConfig, error) {
 	serverEnabled := viper.GetBool("derp.server.enabled")
+	stunOnly := viper.GetBool("derp.server.stun_only")
 	serverRegionID := viper.GetInt("derp.server.region_id")
 	serverRegionCode := viper.GetString("derp.server.region_code")
 	serverRegionName := viper.GetString("derp.server.region_name")
@@ -401,12 +1586,26 @@ func derpConfig() DERPConfig {
 	)
 	ipv4 := viper.GetString("derp.server.ipv4")
 	ipv6 := viper.GetString("derp.server.ipv6")
+	nodeName := viper.GetString("derp.server.node_name")
+	hostname := viper.GetString("derp.server.hostname")
+	derpPort := viper.GetInt("derp.server.derp_port")
+	stunPort := viper.GetInt("derp.server.stun_port")
+	canPort80 := viper.GetBool("derp.server.can_port_80")
+	latitude := viper.GetFloat64("derp.server.latitude")
+	longitude := viper.GetFloat64("derp.server.longitude")
 	automaticallyAddEmbeddedDerpRegion := viper.GetBool(
 		"derp.server.automatically_add_embedded_derp_region",
 	)
 	if serverEnabled && stunAddr == "" {
-		log.Fatal().
-			Msg("derp.server.stun_listen_addr must be set if derp.server.enabled is true")
+		return DERPConfig{}, errors.New("derp.server.stun_listen_addr must be set if derp.server.enabled is true")
+	}
+
+	if derpPort < 0 || derpPort > 65535 || stunPort < 0 || stunPort > 65535 {
+		return DERPConfig{}, errors.New("derp.server.derp_port and derp.server.stun_port must be valid ports")
+	}
+
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+		return DERPConfig{}, errors.New("derp.server.latitude must be within [-90, 90] and derp.server.longitude within [-180, 180]")
 	}
 
 	urlStrs := viper.GetStringSlice("derp.urls")
@@ -415,10 +1614,7 @@ func derpConfig() DERPConfig {
 	for index, urlStr := range urlStrs {
 		urlAddr, err := url.Parse(urlStr)
 		if err != nil {
-			log.Error().
-				Str("url", urlStr).
-				Err(err).
-				Msg("Failed to parse url, ignoring...")
+			return DERPConfig{}, fmt.Errorf("parsing derp.urls: %w", err)
 		}
 
 		urls[index] = *urlAddr
@@ -427,15 +1623,40 @@ func derpConfig() DERPConfig {
 	paths := viper.GetStringSlice("derp.paths")
 
 	if serverEnabled && !automaticallyAddEmbeddedDerpRegion && len(paths) == 0 {
-		log.Fatal().
-			Msg("Disabling derp.server.automatically_add_embedded_derp_region requires to configure the derp server in derp.paths")
+		return DERPConfig{}, errors.New("Disabling derp.server.automatically_add_embedded_derp_region requires to configure the derp server in derp.paths")
 	}
 
 	autoUpdate := viper.GetBool("derp.auto_update_enabled")
 	updateFrequency := viper.GetDuration("derp.update_frequency")
 
+	relaysEnabled := viper.GetBool("derp.relays.enabled")
+	relayExpiry := viper.GetDuration("derp.relays.expiry")
+	if relaysEnabled && relayExpiry <= 0 {
+		return DERPConfig{}, errors.New("derp.relays.expiry must be positive if derp.relays.enabled is true")
+	}
+
+	var rateLimits []DERPRateLimit
+	if viper.IsSet("derp.server.rate_limits") {
+		err := viper.UnmarshalKey("derp.server.rate_limits", &rateLimits)
+		if err != nil {
+			return DERPConfig{}, fmt.Errorf("parsing derp.server.rate_limits: %w", err)
+		}
+
+		for _, limit := range rateLimits {
+			// Limits are applied in bytes, a lower rate would round
+			// down to no traffic at all.
+			if limit.BitsPerSecond < 8 {
+				return DERPConfig{}, fmt.Errorf(
+					"derp.server.rate_limits entries must have a bits_per_second of at least 8, got %d",
+					limit.BitsPerSecond,
+				)
+			}
+		}
+	}
+
 	return DERPConfig{
 		ServerEnabled:                      serverEnabled,
+		STUNOnly:                           stunOnly,
 		ServerRegionID:                     serverRegionID,
 		ServerRegionCode:                   serverRegionCode,
 		ServerRegionName:                   serverRegionName,
@@ -448,28 +1669,1027 @@ func derpConfig() DERPConfig {
 		IPv4:                               ipv4,
 		IPv6:                               ipv6,
 		AutomaticallyAddEmbeddedDerpRegion: automaticallyAddEmbeddedDerpRegion,
+		RateLimits:                         rateLimits,
+		ServerNodeName:                     nodeName,
+		ServerHostname:                     hostname,
+		ServerDERPPort:                     derpPort,
+		ServerSTUNPort:                     stunPort,
+		CanPort80:                          canPort80,
+		Latitude:                           latitude,
+		Longitude:                          longitude,
+		RelaysEnabled:                      relaysEnabled,
+		RelayExpiry:                        relayExpiry,
+	}, nil
+}
+
+// maxClientKeepAliveInterval stays below the two minutes after which
+// clients consider the map stream dead.
+const maxClientKeepAliveInterval = 110 * time.Second
+
+func clientTuningConfig() ([]ClientTuning, error) {
+	if !viper.IsSet("client_tuning") {
+		return nil, nil
+	}
+
+	var tunings []ClientTuning
+	err := viper.UnmarshalKey("client_tuning", &tunings)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling client_tuning: %w", err)
+	}
+
+	for index := range tunings {
+		tuning := &tunings[index]
+		if len(tuning.Nodes) == 0 && len(tuning.Tags) == 0 && len(tuning.Networks) == 0 {
+			return nil, fmt.Errorf("client_tuning[%d]: nodes, tags or networks must be set", index)
+		}
+
+		for _, network := range tuning.Networks {
+			prefix, err := netip.ParsePrefix(network)
+			if err != nil {
+				return nil, fmt.Errorf("client_tuning[%d].networks: %w", index, err)
+			}
+			tuning.Prefixes = append(tuning.Prefixes, prefix.Masked())
+		}
+
+		if tuning.PreferDERPRegion < 0 {
+			return nil, fmt.Errorf("client_tuning[%d]: prefer_derp_region must be a DERP region ID", index)
+		}
+
+		for _, tag := range tuning.Tags {
+			if !strings.HasPrefix(tag, "tag:") {
+				return nil, fmt.Errorf("client_tuning[%d]: %q does not start with \"tag:\"", index, tag)
+			}
+		}
+
+		if tuning.KeepAliveInterval < 0 || tuning.KeepAliveInterval > maxClientKeepAliveInterval {
+			return nil, fmt.Errorf(
+				"client_tuning[%d]: keepalive_interval must be between 0 and %s",
+				index,
+				maxClientKeepAliveInterval,
+			)
+		}
+	}
+
+	return tunings, nil
+}
+
+func exitNodeDNSResolversConfig() ([]ExitNodeDNSResolvers, error) {
+	if !viper.IsSet("dns.exit_node_resolvers") {
+		return nil, nil
+	}
+
+	var entries []ExitNodeDNSResolvers
+	err := viper.UnmarshalKey("dns.exit_node_resolvers", &entries)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling dns.exit_node_resolvers: %w", err)
+	}
+
+	for index := range entries {
+		entry := &entries[index]
+		if len(entry.Tags) == 0 {
+			return nil, fmt.Errorf("dns.exit_node_resolvers[%d]: tags must be set", index)
+		}
+
+		for _, tag := range entry.Tags {
+			if !strings.HasPrefix(tag, "tag:") {
+				return nil, fmt.Errorf("dns.exit_node_resolvers[%d]: %q does not start with \"tag:\"", index, tag)
+			}
+		}
+
+		if len(entry.Nameservers) == 0 {
+			return nil, fmt.Errorf("dns.exit_node_resolvers[%d]: nameservers must be set", index)
+		}
+
+		for _, ns := range entry.Nameservers {
+			resolver, err := parseResolver(ns)
+			if err != nil {
+				return nil, fmt.Errorf("dns.exit_node_resolvers[%d]: nameserver %q: %w", index, ns, err)
+			}
+
+			entry.Resolvers = append(entry.Resolvers, resolver)
+		}
+	}
+
+	return entries, nil
+}
+
+func oidcProvidersConfig() ([]OIDCProviderConfig, error) {
+	if !viper.IsSet("oidc.providers") {
+		return nil, nil
+	}
+
+	var providers []OIDCProviderConfig
+	err := viper.UnmarshalKey("oidc.providers", &providers)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling oidc.providers: %w", err)
+	}
+
+	names := map[string]bool{viper.GetString("oidc.name"): true}
+	for index, provider := range providers {
+		if provider.Name == "" || names[provider.Name] {
+			return nil, fmt.Errorf("oidc.providers[%d]: name must be set and unique", index)
+		}
+		names[provider.Name] = true
+
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("oidc.providers[%d]: issuer and client_id must be set", index)
+		}
+
+		if provider.ClientSecretPath != "" {
+			if provider.ClientSecret != "" {
+				return nil, fmt.Errorf("oidc.providers[%d]: %w", index, errOidcMutuallyExclusive)
+			}
+
+			secretBytes, err := os.ReadFile(os.ExpandEnv(provider.ClientSecretPath))
+			if err != nil {
+				return nil, err
+			}
+			provider.ClientSecret = strings.TrimSpace(string(secretBytes))
+		}
+
+		provider.ClientSecret, err = resolveSecret(provider.ClientSecret)
+		if err != nil {
+			return nil, fmt.Errorf("oidc.providers[%d].client_secret: %w", index, err)
+		}
+
+		if len(provider.Scope) == 0 {
+			provider.Scope = viper.GetStringSlice("oidc.scope")
+		}
+
+		provider.ClientPrivateKeyPath = util.AbsolutePathFromConfigPath(provider.ClientPrivateKeyPath)
+		if err := validateOIDCClientAuth(provider); err != nil {
+			return nil, fmt.Errorf("oidc.providers[%d]: %w", index, err)
+		}
+
+		providers[index] = provider
+	}
+
+	return providers, nil
+}
+
+func oidcClaimTagsConfig() ([]OIDCClaimTag, error) {
+	if !viper.IsSet("oidc.claim_tags") {
+		return nil, nil
+	}
+
+	var claimTags []OIDCClaimTag
+	err := viper.UnmarshalKey("oidc.claim_tags", &claimTags)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling oidc.claim_tags: %w", err)
+	}
+
+	for index, claimTag := range claimTags {
+		if claimTag.Claim == "" || len(claimTag.Tags) == 0 {
+			return nil, fmt.Errorf("oidc.claim_tags[%d]: claim and tags must be set", index)
+		}
+
+		for _, tag := range claimTag.Tags {
+			if !strings.HasPrefix(tag, "tag:") {
+				return nil, fmt.Errorf("oidc.claim_tags[%d]: tag %q must start with tag:", index, tag)
+			}
+		}
+	}
+
+	return claimTags, nil
+}
+
+// validateOIDCClientAuth checks that the credentials the client
+// authentication method of the provider needs are configured.
+func validateOIDCClientAuth(provider OIDCProviderConfig) error {
+	switch provider.ClientAuthMethod {
+	case "":
+	case OIDCClientAuthSecretBasic, OIDCClientAuthSecretPost, OIDCClientAuthSecretJWT:
+		if provider.ClientSecret == "" {
+			return fmt.Errorf("client_auth_method %q requires a client secret", provider.ClientAuthMethod)
+		}
+	case OIDCClientAuthPrivateKeyJWT:
+		if provider.ClientPrivateKeyPath == "" {
+			return fmt.Errorf("client_auth_method %q requires client_private_key_path", provider.ClientAuthMethod)
+		}
+	default:
+		return fmt.Errorf("unknown client_auth_method %q", provider.ClientAuthMethod)
+	}
+
+	return nil
+}
+
+func logtailConfig() LogTailConfig {
+	enabled := viper.GetBool("logtail.enabled")
+
+	return LogTailConfig{
+		Enabled: enabled,
+	}
+}
+
+func listenersConfig() ([]ListenerConfig, error) {
+	if !viper.IsSet("listeners") {
+		return nil, nil
+	}
+
+	var listeners []ListenerConfig
+	err := viper.UnmarshalKey("listeners", &listeners)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling listeners: %w", err)
+	}
+
+	for index, listener := range listeners {
+		if listener.Addr == "" {
+			return nil, fmt.Errorf("listeners[%d]: addr must be set", index)
+		}
+
+		if (listener.TLSCertPath == "") != (listener.TLSKeyPath == "") {
+			return nil, fmt.Errorf("listeners[%d]: tls_cert_path and tls_key_path must be set together", index)
+		}
+
+		switch listener.Type {
+		case ListenerHTTP, ListenerGRPC, ListenerMetrics:
+			if listener.Network == "" {
+				listener.Network = "tcp"
+			}
+			if !slices.Contains([]string{"tcp", "tcp4", "tcp6"}, listener.Network) {
+				return nil, fmt.Errorf("listeners[%d]: network %q is not one of tcp, tcp4, tcp6", index, listener.Network)
+			}
+		case ListenerSTUN:
+			if listener.Network == "" {
+				listener.Network = "udp"
+			}
+			if !slices.Contains([]string{"udp", "udp4", "udp6"}, listener.Network) {
+				return nil, fmt.Errorf("listeners[%d]: network %q is not one of udp, udp4, udp6", index, listener.Network)
+			}
+			if listener.TLSCertPath != "" {
+				return nil, fmt.Errorf("listeners[%d]: stun listeners do not support TLS", index)
+			}
+		default:
+			return nil, fmt.Errorf(
+				"listeners[%d]: type %q is not one of %s, %s, %s, %s",
+				index, listener.Type, ListenerHTTP, ListenerGRPC, ListenerMetrics, ListenerSTUN,
+			)
+		}
+
+		listener.TLSCertPath = util.AbsolutePathFromConfigPath(listener.TLSCertPath)
+		listener.TLSKeyPath = util.AbsolutePathFromConfigPath(listener.TLSKeyPath)
+		listeners[index] = listener
+	}
+
+	return listeners, nil
+}
+
+func mapCompressionConfig() (MapCompressionConfig, error) {
+	cfg := MapCompressionConfig{
+		ZstdLevel:     viper.GetString("map_compression.zstd_level"),
+		BrotliQuality: viper.GetInt("map_compression.brotli_quality"),
+	}
+
+	switch cfg.ZstdLevel {
+	case "fastest", "default", "better", "best":
+	default:
+		return MapCompressionConfig{}, fmt.Errorf(
+			"map_compression.zstd_level: %q is not one of fastest, default, better or best",
+			cfg.ZstdLevel,
+		)
+	}
+
+	if cfg.BrotliQuality < 1 || cfg.BrotliQuality > 11 {
+		return MapCompressionConfig{}, fmt.Errorf(
+			"map_compression.brotli_quality: %d is not between 1 and 11",
+			cfg.BrotliQuality,
+		)
+	}
+
+	return cfg, nil
+}
+
+func mapSigningConfig() (MapSigningConfig, error) {
+	cfg := MapSigningConfig{
+		Enabled: viper.GetBool("map_signing.enabled"),
+		PrivateKeyPath: util.AbsolutePathFromConfigPath(
+			viper.GetString("map_signing.private_key_path"),
+		),
+	}
+
+	if cfg.Enabled && viper.GetString("map_signing.private_key_path") == "" {
+		return MapSigningConfig{}, errors.New("map_signing.private_key_path must be set if map_signing.enabled is true")
+	}
+
+	return cfg, nil
+}
+
+func nodeKeyRenewalConfig() (NodeKeyRenewalConfig, error) {
+	cfg := NodeKeyRenewalConfig{
+		Seamless: viper.GetBool("node_key_renewal.seamless"),
+		Expiry:   viper.GetDuration("node_key_renewal.expiry"),
+	}
+
+	if cfg.Expiry < 0 {
+		return NodeKeyRenewalConfig{}, fmt.Errorf(
+			"node_key_renewal.expiry: %s must not be negative",
+			cfg.Expiry,
+		)
+	}
+
+	return cfg, nil
+}
+
+func nodeExpiryConfig() (NodeExpiryConfig, error) {
+	cfg := NodeExpiryConfig{
+		Mode: NodeExpiryMode(viper.GetString("node_expiry.mode")),
+	}
+
+	switch cfg.Mode {
+	case NodeExpiryModeClient, NodeExpiryModeSoft:
+	default:
+		return NodeExpiryConfig{}, fmt.Errorf(
+			"node_expiry.mode: %q is not %q or %q",
+			cfg.Mode,
+			NodeExpiryModeClient,
+			NodeExpiryModeSoft,
+		)
+	}
+
+	return cfg, nil
+}
+
+func offlineNodesConfig() (OfflineNodesConfig, error) {
+	cfg := OfflineNodesConfig{
+		After:      viper.GetDuration("offline_nodes.after"),
+		Interval:   viper.GetDuration("offline_nodes.interval"),
+		Action:     viper.GetString("offline_nodes.action"),
+		ExemptTags: viper.GetStringSlice("offline_nodes.exempt_tags"),
+		DryRun:     viper.GetBool("offline_nodes.dry_run"),
+		WebhookURL: viper.GetString("offline_nodes.webhook_url"),
+	}
+
+	if cfg.After < 0 {
+		return OfflineNodesConfig{}, fmt.Errorf(
+			"offline_nodes.after: %s must not be negative",
+			cfg.After,
+		)
+	}
+
+	if cfg.After == 0 {
+		return cfg, nil
+	}
+
+	if cfg.Interval <= 0 {
+		return OfflineNodesConfig{}, fmt.Errorf(
+			"offline_nodes.interval: %s must be greater than 0",
+			cfg.Interval,
+		)
+	}
+
+	switch cfg.Action {
+	case OfflineNodeActionDelete, OfflineNodeActionExpire:
+	default:
+		return OfflineNodesConfig{}, fmt.Errorf(
+			"offline_nodes.action: %q is not %q or %q",
+			cfg.Action,
+			OfflineNodeActionDelete,
+			OfflineNodeActionExpire,
+		)
+	}
+
+	for _, tag := range cfg.ExemptTags {
+		if !strings.HasPrefix(tag, "tag:") {
+			return OfflineNodesConfig{}, fmt.Errorf(
+				"offline_nodes.exempt_tags: %q does not start with \"tag:\"",
+				tag,
+			)
+		}
+	}
+
+	return cfg, nil
+}
+
+func metricsConfig() (MetricsConfig, error) {
+	cfg := MetricsConfig{
+		Cardinality: MetricsCardinality(viper.GetString("metrics.cardinality")),
+	}
+
+	switch cfg.Cardinality {
+	case MetricsCardinalityTailnet, MetricsCardinalityUser, MetricsCardinalityNode:
+	default:
+		return MetricsConfig{}, fmt.Errorf(
+			"metrics.cardinality: %q is not %q, %q or %q",
+			cfg.Cardinality,
+			MetricsCardinalityTailnet,
+			MetricsCardinalityUser,
+			MetricsCardinalityNode,
+		)
+	}
+
+	return cfg, nil
+}
+
+func tracingConfig() (TracingConfig, error) {
+	cfg := TracingConfig{
+		Enabled:     viper.GetBool("tracing.enabled"),
+		Endpoint:    viper.GetString("tracing.endpoint"),
+		Protocol:    TracingProtocol(viper.GetString("tracing.protocol")),
+		Insecure:    viper.GetBool("tracing.insecure"),
+		SampleRatio: viper.GetFloat64("tracing.sample_ratio"),
+	}
+
+	switch cfg.Protocol {
+	case TracingProtocolGRPC, TracingProtocolHTTP:
+	default:
+		return TracingConfig{}, fmt.Errorf(
+			"tracing.protocol: %q is not %q or %q",
+			cfg.Protocol,
+			TracingProtocolGRPC,
+			TracingProtocolHTTP,
+		)
+	}
+
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return TracingConfig{}, fmt.Errorf(
+			"tracing.sample_ratio: %v is not between 0 and 1",
+			cfg.SampleRatio,
+		)
+	}
+
+	return cfg, nil
+}
+
+func routesConfig() (RoutesConfig, error) {
+	cfg := RoutesConfig{
+		StaleGracePeriod: viper.GetDuration("routes.stale_grace_period"),
+	}
+
+	if cfg.StaleGracePeriod < 0 {
+		return RoutesConfig{}, fmt.Errorf(
+			"routes.stale_grace_period: %s must not be negative",
+			cfg.StaleGracePeriod,
+		)
+	}
+
+	return cfg, nil
+}
+
+// clientPolicyConfig returns the recommended client settings. The keys
+// are matched case insensitively, as the configuration lowercases them,
+// and returned as the clients spell them.
+func clientPolicyConfig() (ClientPolicyConfig, error) {
+	settings := map[string]string{}
+
+	for key, value := range viper.GetStringMapString("client_policy.settings") {
+		name := ""
+		for known := range clientPolicyValues {
+			if strings.EqualFold(known, key) {
+				name = known
+			}
+		}
+		if name == "" {
+			return ClientPolicyConfig{}, fmt.Errorf(
+				"client_policy.settings: %q is not a system policy key of the Tailscale clients, the server URL is set as LoginURL",
+				key,
+			)
+		}
+
+		if values := clientPolicyValues[name]; values != nil && !slices.Contains(values, value) {
+			return ClientPolicyConfig{}, fmt.Errorf(
+				"client_policy.settings.%s: %q is not one of %s",
+				name,
+				value,
+				strings.Join(values, ", "),
+			)
+		}
+
+		if name == "KeyExpirationNotice" {
+			if _, err := time.ParseDuration(value); err != nil {
+				return ClientPolicyConfig{}, fmt.Errorf("client_policy.settings.%s: %w", name, err)
+			}
+		}
+
+		settings[name] = value
+	}
+
+	return ClientPolicyConfig{Settings: settings}, nil
+}
+
+func clientUpdatesConfig() (ClientUpdatesConfig, error) {
+	cfg := ClientUpdatesConfig{
+		LatestVersion:  viper.GetString("client_updates.latest_version"),
+		Notify:         viper.GetBool("client_updates.notify"),
+		NotifyURL:      viper.GetString("client_updates.notify_url"),
+		MinimumVersion: viper.GetString("client_updates.minimum_version"),
+		AutoUpdate:     viper.GetBool("client_updates.auto_update"),
+	}
+
+	for key, version := range map[string]string{
+		"client_updates.latest_version":  cfg.LatestVersion,
+		"client_updates.minimum_version": cfg.MinimumVersion,
+	} {
+		if version != "" && !clientVersionRegex.MatchString(version) {
+			return ClientUpdatesConfig{}, fmt.Errorf(
+				"%s: %q is not a version like 1.72.1",
+				key,
+				version,
+			)
+		}
+	}
+
+	switch {
+	case cfg.MinimumVersion == "":
+	case cfg.LatestVersion == "":
+		// Clients are told to update to the minimum version.
+		cfg.LatestVersion = cfg.MinimumVersion
+	case !util.TailscaleVersionNewerOrEqual(cfg.MinimumVersion, cfg.LatestVersion):
+		return ClientUpdatesConfig{}, fmt.Errorf(
+			"client_updates.minimum_version: %s is newer than the latest version %s",
+			cfg.MinimumVersion,
+			cfg.LatestVersion,
+		)
+	}
+
+	return cfg, nil
+}
+
+func attestationConfig() (AttestationConfig, error) {
+	path := viper.GetString("attestation.roots_path")
+	if path == "" {
+		return AttestationConfig{}, nil
+	}
+
+	path = util.AbsolutePathFromConfigPath(path)
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return AttestationConfig{}, fmt.Errorf("attestation.roots_path: %w", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return AttestationConfig{}, fmt.Errorf("attestation.roots_path: no certificates in %s", path)
+	}
+
+	return AttestationConfig{
+		RootsPath: path,
+		Roots:     roots,
+	}, nil
+}
+
+func proxyProtocolConfig() (ProxyProtocolConfig, error) {
+	trusted := viper.GetStringSlice("proxy_protocol.trusted_proxies")
+	for _, proxy := range trusted {
+		if _, err := netip.ParsePrefix(proxy); err != nil {
+			if _, err := netip.ParseAddr(proxy); err != nil {
+				return ProxyProtocolConfig{}, fmt.Errorf(
+					"proxy_protocol.trusted_proxies: %q is not an IP address or prefix",
+					proxy,
+				)
+			}
+		}
+	}
+
+	// Accepting headers from any source would let every client claim
+	// any address.
+	enabled := viper.GetBool("proxy_protocol.enabled")
+	if enabled && len(trusted) == 0 {
+		return ProxyProtocolConfig{}, errors.New(
+			"proxy_protocol.enabled requires proxy_protocol.trusted_proxies, the addresses of the load balancers",
+		)
+	}
+
+	return ProxyProtocolConfig{
+		Enabled:        enabled,
+		TrustedProxies: trusted,
+	}, nil
+}
+
+func unixSocketAccessConfig() ([]UnixSocketAccessRule, error) {
+	if !viper.IsSet("unix_socket_access") {
+		return nil, nil
+	}
+
+	var rules []UnixSocketAccessRule
+	err := viper.UnmarshalKey("unix_socket_access", &rules)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshaling unix_socket_access: %w", err)
+	}
+
+	for index, rule := range rules {
+		if len(rule.Users) == 0 && len(rule.Groups) == 0 {
+			return nil, fmt.Errorf("unix_socket_access[%d]: users or groups must be set", index)
+		}
+
+		if rule.Scope != APIScopeAdmin && rule.Scope != APIScopeRead {
+			return nil, fmt.Errorf(
+				"unix_socket_access[%d]: scope %q is not one of %s, %s",
+				index, rule.Scope, APIScopeAdmin, APIScopeRead,
+			)
+		}
+	}
+
+	return rules, nil
+}
+
+func tailnetAdminConfig(serverURL string, listeners []ListenerConfig) (TailnetAdminConfig, error) {
+	authKey, err := secretString("tailnet_admin.auth_key")
+	if err != nil {
+		return TailnetAdminConfig{}, err
+	}
+
+	cfg := TailnetAdminConfig{
+		Enabled:     viper.GetBool("tailnet_admin.enabled"),
+		Hostname:    viper.GetString("tailnet_admin.hostname"),
+		StateDir:    util.AbsolutePathFromConfigPath(viper.GetString("tailnet_admin.state_dir")),
+		AuthKey:     authKey,
+		ControlURL:  viper.GetString("tailnet_admin.control_url"),
+		Ephemeral:   viper.GetBool("tailnet_admin.ephemeral"),
+		GRPCPort:    viper.GetInt("tailnet_admin.grpc_port"),
+		HTTPPort:    viper.GetInt("tailnet_admin.http_port"),
+		MetricsPort: viper.GetInt("tailnet_admin.metrics_port"),
+		Exclusive:   viper.GetBool("tailnet_admin.exclusive"),
+	}
+
+	if !cfg.Enabled {
+		if cfg.Exclusive {
+			return TailnetAdminConfig{}, errors.New(
+				"tailnet_admin.exclusive requires tailnet_admin.enabled",
+			)
+		}
+
+		return cfg, nil
+	}
+
+	if cfg.ControlURL == "" {
+		cfg.ControlURL = serverURL
+	}
+
+	if cfg.Hostname == "" || cfg.StateDir == "" {
+		return TailnetAdminConfig{}, errors.New(
+			"tailnet_admin.hostname and tailnet_admin.state_dir must be set",
+		)
+	}
+
+	for _, port := range []int{cfg.GRPCPort, cfg.HTTPPort, cfg.MetricsPort} {
+		if port < 0 || port > 65535 {
+			return TailnetAdminConfig{}, fmt.Errorf("tailnet_admin: invalid port %d", port)
+		}
+	}
+
+	if cfg.Exclusive {
+		for _, listener := range listeners {
+			if listener.Type == ListenerGRPC || listener.Type == ListenerMetrics {
+				return TailnetAdminConfig{}, fmt.Errorf(
+					"listener %s: %s listeners cannot be used with tailnet_admin.exclusive",
+					listener.Addr,
+					listener.Type,
+				)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func replicaConfig(database DatabaseConfig) (ReplicaConfig, error) {
+	cfg := ReplicaConfig{
+		Enabled:             viper.GetBool("replica.enabled"),
+		PrimaryURL:          strings.TrimSuffix(viper.GetString("replica.primary_url"), "/"),
+		HealthCheckInterval: viper.GetDuration("replica.health_check_interval"),
+		FailoverAfter:       viper.GetDuration("replica.failover_after"),
+		RefreshInterval:     viper.GetDuration("replica.refresh_interval"),
+	}
+
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if database.Type != DatabasePostgres {
+		return ReplicaConfig{}, errors.New("replica.enabled requires a postgres database, the database of the primary or a read replica of it")
+	}
+
+	primaryURL, err := url.Parse(cfg.PrimaryURL)
+	if err != nil || (primaryURL.Scheme != "http" && primaryURL.Scheme != "https") || primaryURL.Host == "" {
+		return ReplicaConfig{}, fmt.Errorf("replica.primary_url: %q is not an http or https URL", cfg.PrimaryURL)
+	}
+
+	if cfg.HealthCheckInterval <= 0 || cfg.RefreshInterval <= 0 {
+		return ReplicaConfig{}, errors.New("replica.health_check_interval and replica.refresh_interval must be positive")
+	}
+
+	if cfg.FailoverAfter < cfg.HealthCheckInterval {
+		return ReplicaConfig{}, fmt.Errorf(
+			"replica.failover_after: %s is shorter than replica.health_check_interval",
+			cfg.FailoverAfter,
+		)
+	}
+
+	return cfg, nil
+}
+
+func canaryConfig(serverURL string) (CanaryConfig, error) {
+	authKey, err := secretString("canary.auth_key")
+	if err != nil {
+		return CanaryConfig{}, err
+	}
+
+	cfg := CanaryConfig{
+		Enabled:    viper.GetBool("canary.enabled"),
+		Hostname:   viper.GetString("canary.hostname"),
+		StateDir:   util.AbsolutePathFromConfigPath(viper.GetString("canary.state_dir")),
+		AuthKey:    authKey,
+		ControlURL: viper.GetString("canary.control_url"),
+		Interval:   viper.GetDuration("canary.interval"),
+		Timeout:    viper.GetDuration("canary.timeout"),
+		Peer:       viper.GetString("canary.peer"),
+	}
+
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if cfg.ControlURL == "" {
+		cfg.ControlURL = serverURL
+	}
+
+	if cfg.Hostname == "" || cfg.StateDir == "" {
+		return CanaryConfig{}, errors.New("canary.hostname and canary.state_dir must be set")
+	}
+
+	if cfg.AuthKey == "" {
+		return CanaryConfig{}, errors.New("canary.auth_key must be set to a reusable pre auth key")
+	}
+
+	if cfg.Interval <= 0 {
+		return CanaryConfig{}, fmt.Errorf("canary.interval: %s is not positive", cfg.Interval)
+	}
+
+	if cfg.Timeout <= 0 || cfg.Timeout > cfg.Interval {
+		return CanaryConfig{}, fmt.Errorf("canary.timeout: %s is not between 0 and canary.interval", cfg.Timeout)
+	}
+
+	return cfg, nil
+}
+
+func anomalyDetectionConfig() (AnomalyDetectionConfig, error) {
+	cfg := AnomalyDetectionConfig{
+		Enabled:       viper.GetBool("anomaly_detection.enabled"),
+		MaxSpeedKmh:   viper.GetFloat64("anomaly_detection.max_speed_kmh"),
+		MinDistanceKm: viper.GetFloat64("anomaly_detection.min_distance_km"),
+		WebhookURL:    viper.GetString("anomaly_detection.webhook_url"),
+		Quarantine:    viper.GetBool("anomaly_detection.quarantine"),
+	}
+
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if viper.GetString("geoip.database_path") == "" {
+		return AnomalyDetectionConfig{}, errors.New(
+			"anomaly_detection requires geoip.database_path to be set",
+		)
+	}
+
+	if cfg.MaxSpeedKmh <= 0 {
+		return AnomalyDetectionConfig{}, errors.New(
+			"anomaly_detection.max_speed_kmh must be greater than 0",
+		)
+	}
+
+	return cfg, nil
+}
+
+func registrationVerificationConfig() (RegistrationVerificationConfig, error) {
+	cfg := RegistrationVerificationConfig{
+		Method:     viper.GetString("registration_verification.method"),
+		Code:       viper.GetString("registration_verification.code"),
+		WebhookURL: viper.GetString("registration_verification.webhook_url"),
+		SMTP: SMTPConfig{
+			Host:     viper.GetString("registration_verification.smtp.host"),
+			Port:     viper.GetInt("registration_verification.smtp.port"),
+			Username: viper.GetString("registration_verification.smtp.username"),
+			Password: viper.GetString("registration_verification.smtp.password"),
+			From:     viper.GetString("registration_verification.smtp.from"),
+		},
+	}
+
+	if codePath := viper.GetString("registration_verification.code_path"); codePath != "" {
+		if cfg.Code != "" {
+			return RegistrationVerificationConfig{}, errors.New(
+				"registration_verification.code and registration_verification.code_path are mutually exclusive",
+			)
+		}
+
+		codeBytes, err := os.ReadFile(os.ExpandEnv(codePath))
+		if err != nil {
+			return RegistrationVerificationConfig{}, err
+		}
+		cfg.Code = strings.TrimSpace(string(codeBytes))
+	}
+
+	if passwordPath := viper.GetString("registration_verification.smtp.password_path"); passwordPath != "" {
+		passwordBytes, err := os.ReadFile(os.ExpandEnv(passwordPath))
+		if err != nil {
+			return RegistrationVerificationConfig{}, err
+		}
+		cfg.SMTP.Password = strings.TrimSpace(string(passwordBytes))
+	}
+
+	smtpPassword, err := resolveSecret(cfg.SMTP.Password)
+	if err != nil {
+		return RegistrationVerificationConfig{}, fmt.Errorf("registration_verification.smtp.password: %w", err)
+	}
+	cfg.SMTP.Password = smtpPassword
+
+	switch cfg.Method {
+	case "":
+	case RegistrationVerificationEmail:
+		if cfg.SMTP.Host == "" || cfg.SMTP.From == "" {
+			return RegistrationVerificationConfig{}, errors.New(
+				"registration_verification method email requires smtp.host and smtp.from to be set",
+			)
+		}
+	case RegistrationVerificationWebhook:
+		if cfg.WebhookURL == "" {
+			return RegistrationVerificationConfig{}, errors.New(
+				"registration_verification method webhook requires webhook_url to be set",
+			)
+		}
+	case RegistrationVerificationCode:
+		if cfg.Code == "" {
+			return RegistrationVerificationConfig{}, errors.New(
+				"registration_verification method code requires code or code_path to be set",
+			)
+		}
+	default:
+		return RegistrationVerificationConfig{}, fmt.Errorf(
+			"unknown registration_verification method %q",
+			cfg.Method,
+		)
+	}
+
+	return cfg, nil
+}
+
+func portalConfig() (PortalConfig, error) {
+	cfg := PortalConfig{
+		Enabled:                  viper.GetBool("portal.enabled"),
+		SessionExpiration:        viper.GetDuration("portal.session_expiration"),
+		MaxPreAuthKeys:           viper.GetInt("portal.preauth_keys.max_active"),
+		MaxPreAuthKeyExpiration:  viper.GetDuration("portal.preauth_keys.max_expiration"),
+		AllowReusablePreAuthKeys: viper.GetBool("portal.preauth_keys.allow_reusable"),
+	}
+	if !cfg.Enabled {
+		return cfg, nil
+	}
+
+	if cfg.SessionExpiration <= 0 {
+		return PortalConfig{}, errors.New("portal.session_expiration must be positive")
+	}
+	if cfg.MaxPreAuthKeys < 0 {
+		return PortalConfig{}, errors.New("portal.preauth_keys.max_active cannot be negative")
+	}
+	if cfg.MaxPreAuthKeys > 0 && cfg.MaxPreAuthKeyExpiration <= 0 {
+		return PortalConfig{}, errors.New("portal.preauth_keys.max_expiration must be positive")
+	}
+
+	return cfg, nil
+}
+
+func backupConfig() (BackupConfig, error) {
+	secretAccessKey, err := secretString("backup.s3.secret_access_key")
+	if err != nil {
+		return BackupConfig{}, err
+	}
+
+	cfg := BackupConfig{
+		Schedule:  viper.GetString("backup.schedule"),
+		Directory: util.AbsolutePathFromConfigPath(viper.GetString("backup.directory")),
+		Keep:      viper.GetInt("backup.retention.keep"),
+		MaxAge:    viper.GetDuration("backup.retention.max_age"),
+		S3: BackupS3Config{
+			Bucket:          viper.GetString("backup.s3.bucket"),
+			Prefix:          viper.GetString("backup.s3.prefix"),
+			Endpoint:        viper.GetString("backup.s3.endpoint"),
+			Region:          viper.GetString("backup.s3.region"),
+			AccessKeyID:     viper.GetString("backup.s3.access_key_id"),
+			SecretAccessKey: secretAccessKey,
+			UsePathStyle:    viper.GetBool("backup.s3.use_path_style"),
+		},
+	}
+
+	if !cfg.Enabled() {
+		return cfg, nil
+	}
+
+	if _, err := util.ParseCronSchedule(cfg.Schedule); err != nil {
+		return BackupConfig{}, fmt.Errorf("backup.schedule: %w", err)
+	}
+
+	if cfg.Directory == "" {
+		return BackupConfig{}, errors.New("backup.directory must be set")
+	}
+
+	if cfg.Keep < 0 || cfg.MaxAge < 0 {
+		return BackupConfig{}, errors.New(
+			"backup.retention.keep and backup.retention.max_age must not be negative",
+		)
+	}
+
+	if (cfg.S3.AccessKeyID == "") != (cfg.S3.SecretAccessKey == "") {
+		return BackupConfig{}, errors.New(
+			"backup.s3.access_key_id and backup.s3.secret_access_key must be set together",
+		)
+	}
+
+	return cfg, nil
+}
+
+func ldapConfig() (LDAPConfig, error) {
+	cfg := LDAPConfig{
+		URL:                  viper.GetString("ldap.url"),
+		StartTLS:             viper.GetBool("ldap.start_tls"),
+		InsecureSkipVerify:   viper.GetBool("ldap.insecure_skip_verify"),
+		BindDN:               viper.GetString("ldap.bind_dn"),
+		BindPassword:         viper.GetString("ldap.bind_password"),
+		UserBaseDN:           viper.GetString("ldap.user_base_dn"),
+		UserFilter:           viper.GetString("ldap.user_filter"),
+		UsernameAttribute:    viper.GetString("ldap.username_attribute"),
+		GroupBaseDN:          viper.GetString("ldap.group_base_dn"),
+		GroupFilter:          viper.GetString("ldap.group_filter"),
+		GroupNameAttribute:   viper.GetString("ldap.group_name_attribute"),
+		GroupMemberAttribute: viper.GetString("ldap.group_member_attribute"),
+		GroupSyncInterval:    viper.GetDuration("ldap.group_sync_interval"),
+	}
+
+	if !cfg.Enabled() {
+		return cfg, nil
 	}
-}
 
-func logtailConfig() LogTailConfig {
-	enabled := viper.GetBool("logtail.enabled")
+	if passwordPath := viper.GetString("ldap.bind_password_path"); passwordPath != "" {
+		if cfg.BindPassword != "" {
+			return LDAPConfig{}, errors.New(
+				"ldap.bind_password and ldap.bind_password_path are mutually exclusive",
+			)
+		}
 
-	return LogTailConfig{
-		Enabled: enabled,
+		passwordBytes, err := os.ReadFile(os.ExpandEnv(passwordPath))
+		if err != nil {
+			return LDAPConfig{}, err
+		}
+		cfg.BindPassword = strings.TrimSpace(string(passwordBytes))
+	}
+
+	bindPassword, err := resolveSecret(cfg.BindPassword)
+	if err != nil {
+		return LDAPConfig{}, fmt.Errorf("ldap.bind_password: %w", err)
+	}
+	cfg.BindPassword = bindPassword
+
+	if cfg.UserBaseDN == "" {
+		return LDAPConfig{}, errors.New("ldap.user_base_dn must be set")
+	}
+
+	if !strings.Contains(cfg.UserFilter, "{username}") {
+		return LDAPConfig{}, errors.New("ldap.user_filter must contain {username}")
+	}
+
+	if cfg.GroupBaseDN == "" {
+		cfg.GroupBaseDN = cfg.UserBaseDN
 	}
+
+	return cfg, nil
 }
 
-func policyConfig() PolicyConfig {
+func policyConfig() (PolicyConfig, error) {
 	policyPath := viper.GetString("policy.path")
 	policyMode := viper.GetString("policy.mode")
 
-	return PolicyConfig{
-		Path: policyPath,
-		Mode: PolicyMode(policyMode),
+	var disallow []PolicyFeature
+	for _, feature := range viper.GetStringSlice("policy.disallow") {
+		if !slices.Contains(policyFeatures, PolicyFeature(feature)) {
+			return PolicyConfig{}, fmt.Errorf(
+				"policy.disallow: %q is not one of %s, %s, %s",
+				feature,
+				PolicyFeatureDangerAll,
+				PolicyFeatureWildcard,
+				PolicyFeatureInternetAnyProtocol,
+			)
+		}
+
+		disallow = append(disallow, PolicyFeature(feature))
+	}
+
+	rollout := PolicyRolloutConfig{
+		CheckInterval: viper.GetDuration("policy.rollout.check_interval"),
+		ProbeFailures: viper.GetInt("policy.rollout.probe_failures"),
+	}
+	if rollout.CheckInterval <= 0 || rollout.ProbeFailures <= 0 {
+		return PolicyConfig{}, errors.New("policy.rollout.check_interval and policy.rollout.probe_failures must be positive")
 	}
+
+	return PolicyConfig{
+		Path:          policyPath,
+		Mode:          PolicyMode(policyMode),
+		StatsInterval: viper.GetDuration("policy.stats_interval"),
+		Disallow:      disallow,
+		Strict:        viper.GetBool("policy.strict"),
+		Rollout:       rollout,
+	}, nil
 }
 
-func logConfig() LogConfig {
+func logConfig() (LogConfig, error) {
 	logLevelStr := viper.GetString("log.level")
 	logLevel, err := zerolog.ParseLevel(logLevelStr)
 	if err != nil {
@@ -491,13 +2711,51 @@ func logConfig() LogConfig {
 			Msgf("Could not parse log format: %s. Valid choices are 'json' or 'text'", logFormatOpt)
 	}
 
+	levels := make(map[util.LogSubsystem]zerolog.Level)
+	for _, subsystem := range util.LogSubsystems {
+		key := "log.levels." + string(subsystem)
+		if !viper.IsSet(key) {
+			continue
+		}
+
+		level, err := zerolog.ParseLevel(viper.GetString(key))
+		if err != nil {
+			return LogConfig{}, fmt.Errorf("%s: %w", key, err)
+		}
+		levels[subsystem] = level
+	}
+
 	return LogConfig{
 		Format: logFormat,
 		Level:  logLevel,
-	}
+		Levels: levels,
+		Redact: viper.GetBool("log.redact"),
+	}, nil
+}
+
+var logOutputOnce sync.Once
+
+// applyLogConfig sets the log levels and, once, the output. In the json
+// format, the logs of the standard library and of gRPC are written as
+// JSON events too so every line is valid JSON.
+func applyLogConfig(cfg LogConfig) {
+	util.SetLogLevels(cfg.Level, cfg.Levels)
+	util.SetLogRedaction(cfg.Redact)
+
+	logOutputOnce.Do(func() {
+		if cfg.Format == JSONLogFormat {
+			log.Logger = zerolog.New(util.NewRedactingWriter(os.Stderr)).With().Timestamp().Logger()
+
+			stdlog.SetFlags(0)
+			stdlog.SetOutput(log.Logger)
+			grpclog.SetLoggerV2(grpclog.NewLoggerV2(io.Discard, io.Discard, log.Logger))
+		}
+
+		log.Logger = log.Logger.Hook(util.LogLevelHook())
+	})
 }
 
-func databaseConfig() DatabaseConfig {
+func databaseConfig() (DatabaseConfig, error) {
 	debug := viper.GetBool("database.debug")
 
 	type_ := viper.GetString("database.type")
@@ -507,14 +2765,31 @@ func databaseConfig() DatabaseConfig {
 	parameterizedQueries := viper.GetBool("database.gorm.parameterized_queries")
 	prepareStmt := viper.GetBool("database.gorm.prepare_stmt")
 
+	journalMode := strings.ToLower(viper.GetString("database.sqlite.journal_mode"))
+	if journalMode == "" && viper.GetBool("database.sqlite.write_ahead_log") {
+		journalMode = "wal"
+	}
+	if journalMode != "" && !slices.Contains(sqliteJournalModes, journalMode) {
+		return DatabaseConfig{}, fmt.Errorf("invalid database.sqlite.journal_mode %q, must be one of %s", journalMode, strings.Join(sqliteJournalModes, ", "))
+	}
+
+	checkpointMode := strings.ToLower(viper.GetString("database.sqlite.checkpoint_mode"))
+	if !slices.Contains(sqliteCheckpointModes, checkpointMode) {
+		return DatabaseConfig{}, fmt.Errorf("invalid database.sqlite.checkpoint_mode %q, must be one of %s", checkpointMode, strings.Join(sqliteCheckpointModes, ", "))
+	}
+
+	postgresPass, err := secretString("database.postgres.pass")
+	if err != nil {
+		return DatabaseConfig{}, fmt.Errorf("resolving the database password: %w", err)
+	}
+
 	switch type_ {
 	case DatabaseSqlite, DatabasePostgres:
 		break
 	case "sqlite":
 		type_ = "sqlite3"
 	default:
-		log.Fatal().
-			Msgf("invalid database type %q, must be sqlite, sqlite3 or postgres", type_)
+		return DatabaseConfig{}, fmt.Errorf("invalid database type %q, must be sqlite, sqlite3 or postgres", type_)
 	}
 
 	return DatabaseConfig{
@@ -531,14 +2806,20 @@ func databaseConfig() DatabaseConfig {
 			Path: util.AbsolutePathFromConfigPath(
 				viper.GetString("database.sqlite.path"),
 			),
-			WriteAheadLog: viper.GetBool("database.sqlite.write_ahead_log"),
+			WriteAheadLog: journalMode == "wal",
+
+			JournalMode:        journalMode,
+			BusyTimeout:        viper.GetDuration("database.sqlite.busy_timeout"),
+			WALAutocheckpoint:  viper.GetInt("database.sqlite.wal_autocheckpoint"),
+			CheckpointInterval: viper.GetDuration("database.sqlite.checkpoint_interval"),
+			CheckpointMode:     checkpointMode,
 		},
 		Postgres: PostgresConfig{
 			Host:               viper.GetString("database.postgres.host"),
 			Port:               viper.GetInt("database.postgres.port"),
 			Name:               viper.GetString("database.postgres.name"),
 			User:               viper.GetString("database.postgres.user"),
-			Pass:               viper.GetString("database.postgres.pass"),
+			Pass:               postgresPass,
 			Ssl:                viper.GetString("database.postgres.ssl"),
 			MaxOpenConnections: viper.GetInt("database.postgres.max_open_conns"),
 			MaxIdleConnections: viper.GetInt("database.postgres.max_idle_conns"),
@@ -546,7 +2827,14 @@ func databaseConfig() DatabaseConfig {
 				"database.postgres.conn_max_idle_time_secs",
 			),
 		},
-	}
+		GC: DatabaseGCConfig{
+			Interval:                viper.GetDuration("database.gc.interval"),
+			PreAuthKeyRetention:     viper.GetDuration("database.gc.preauth_key_retention"),
+			APIKeyRetention:         viper.GetDuration("database.gc.api_key_retention"),
+			NetcheckReportRetention: viper.GetDuration("database.gc.netcheck_report_retention"),
+			RouteEventRetention:     viper.GetDuration("database.gc.route_history_retention"),
+		},
+	}, nil
 }
 
 func dns() (DNSConfig, error) {
@@ -563,6 +2851,16 @@ func dns() (DNSConfig, error) {
 	dns.BaseDomain = viper.GetString("dns.base_domain")
 	dns.Nameservers.Global = viper.GetStringSlice("dns.nameservers.global")
 	dns.Nameservers.Split = viper.GetStringMapStringSlice("dns.nameservers.split")
+	dns.Nameservers.HealthCheck = DNSHealthCheckConfig{
+		Interval: viper.GetDuration("dns.nameservers.health_check.interval"),
+		Timeout:  viper.GetDuration("dns.nameservers.health_check.timeout"),
+	}
+	if dns.Nameservers.HealthCheck.Interval < 0 {
+		return DNSConfig{}, errors.New("dns.nameservers.health_check.interval: must not be negative")
+	}
+	if dns.Nameservers.HealthCheck.Interval > 0 && dns.Nameservers.HealthCheck.Timeout <= 0 {
+		return DNSConfig{}, errors.New("dns.nameservers.health_check.timeout: must be positive")
+	}
 	dns.SearchDomains = viper.GetStringSlice("dns.search_domains")
 
 	if viper.IsSet("dns.extra_records") {
@@ -578,42 +2876,34 @@ func dns() (DNSConfig, error) {
 
 	dns.UserNameInMagicDNS = viper.GetBool("dns.use_username_in_magic_dns")
 
+	if dns.BaseDomain == "" && dns.MagicDNS {
+		return DNSConfig{}, errors.New("dns.base_domain must be set when using MagicDNS (dns.magic_dns)")
+	}
+
+	if err := dns.searchDomainsCollision(); err != nil {
+		return DNSConfig{}, err
+	}
+
 	return dns, nil
 }
 
 // globalResolvers returns the global DNS resolvers
 // defined in the config file.
 // If a nameserver is a valid IP, it will be used as a regular resolver.
-// If a nameserver is a valid URL, it will be used as a DoH resolver.
-// If a nameserver is neither a valid URL nor a valid IP, it will be ignored.
+// If a nameserver is a valid https URL, it will be used as a DoH resolver.
+// If a nameserver is neither, it will be ignored.
 func (d *DNSConfig) globalResolvers() []*dnstype.Resolver {
 	var resolvers []*dnstype.Resolver
 
 	for _, nsStr := range d.Nameservers.Global {
-		warn := ""
-		if _, err := netip.ParseAddr(nsStr); err == nil {
-			resolvers = append(resolvers, &dnstype.Resolver{
-				Addr: nsStr,
-			})
-
-			continue
-		} else {
-			warn = fmt.Sprintf("Invalid global nameserver %q. Parsing error: %s ignoring", nsStr, err)
-		}
-
-		if _, err := url.Parse(nsStr); err == nil {
-			resolvers = append(resolvers, &dnstype.Resolver{
-				Addr: nsStr,
-			})
+		resolver, err := parseResolver(nsStr)
+		if err != nil {
+			log.Warn().Msgf("Invalid global nameserver %q. Parsing error: %s ignoring", nsStr, err)
 
 			continue
-		} else {
-			warn = fmt.Sprintf("Invalid global nameserver %q. Parsing error: %s ignoring", nsStr, err)
 		}
 
-		if warn != "" {
-			log.Warn().Msg(warn)
-		}
+		resolvers = append(resolvers, resolver)
 	}
 
 	return resolvers
@@ -621,37 +2911,23 @@ func (d *DNSConfig) globalResolvers() []*dnstype.Resolver {
 
 // splitResolvers returns a map of domain to DNS resolvers.
 // If a nameserver is a valid IP, it will be used as a regular resolver.
-// If a nameserver is a valid URL, it will be used as a DoH resolver.
-// If a nameserver is neither a valid URL nor a valid IP, it will be ignored.
+// If a nameserver is a valid https URL, it will be used as a DoH resolver.
+// If a nameserver is neither, it will be ignored.
+// The resolvers of a domain keep their order, clients prefer the first
+// ones.
 func (d *DNSConfig) splitResolvers() map[string][]*dnstype.Resolver {
 	routes := make(map[string][]*dnstype.Resolver)
 	for domain, nameservers := range d.Nameservers.Split {
 		var resolvers []*dnstype.Resolver
 		for _, nsStr := range nameservers {
-			warn := ""
-			if _, err := netip.ParseAddr(nsStr); err == nil {
-				resolvers = append(resolvers, &dnstype.Resolver{
-					Addr: nsStr,
-				})
-
-				continue
-			} else {
-				warn = fmt.Sprintf("Invalid split dns nameserver %q. Parsing error: %s ignoring", nsStr, err)
-			}
-
-			if _, err := url.Parse(nsStr); err == nil {
-				resolvers = append(resolvers, &dnstype.Resolver{
-					Addr: nsStr,
-				})
+			resolver, err := parseResolver(nsStr)
+			if err != nil {
+				log.Warn().Msgf("Invalid split dns nameserver %q. Parsing error: %s ignoring", nsStr, err)
 
 				continue
-			} else {
-				warn = fmt.Sprintf("Invalid split dns nameserver %q. Parsing error: %s ignoring", nsStr, err)
 			}
 
-			if warn != "" {
-				log.Warn().Msg(warn)
-			}
+			resolvers = append(resolvers, resolver)
 		}
 		routes[domain] = resolvers
 	}
@@ -662,10 +2938,6 @@ func (d *DNSConfig) splitResolvers() map[string][]*dnstype.Resolver {
 func dnsToTailcfgDNS(dns DNSConfig) *tailcfg.DNSConfig {
 	cfg := tailcfg.DNSConfig{}
 
-	if dns.BaseDomain == "" && dns.MagicDNS {
-		log.Fatal().Msg("dns.base_domain must be set when using MagicDNS (dns.magic_dns)")
-	}
-
 	cfg.Proxied = dns.MagicDNS
 	cfg.ExtraRecords = dns.ExtraRecords
 	cfg.Resolvers = dns.globalResolvers()
@@ -729,34 +3001,87 @@ func prefixV6() (*netip.Prefix, error) {
 	return &prefixV6, nil
 }
 
+// ipFamiliesConfig returns the address families disabled in the
+// tailnet, a family can only be disabled if the other one has a prefix.
+func ipFamiliesConfig(prefix4, prefix6 *netip.Prefix) (IPFamiliesConfig, error) {
+	families := IPFamiliesConfig{
+		DisableIPv4: viper.GetBool("prefixes.disable_v4"),
+		DisableIPv6: viper.GetBool("prefixes.disable_v6"),
+	}
+
+	switch {
+	case families.DisableIPv4 && families.DisableIPv6:
+		return IPFamiliesConfig{}, errors.New("prefixes.disable_v4 and prefixes.disable_v6 cannot both be set")
+	case families.DisableIPv4 && prefix6 == nil:
+		return IPFamiliesConfig{}, errors.New("prefixes.disable_v4 requires an IPv6 prefix in prefixes.v6")
+	case families.DisableIPv6 && prefix4 == nil:
+		return IPFamiliesConfig{}, errors.New("prefixes.disable_v6 requires an IPv4 prefix in prefixes.v4")
+	}
+
+	return families, nil
+}
+
 // LoadCLIConfig returns the needed configuration for the CLI client
 // of Headscale to connect to a Headscale server.
 func LoadCLIConfig() (*Config, error) {
-	logConfig := logConfig()
-	zerolog.SetGlobalLevel(logConfig.Level)
+	logConfig, err := logConfig()
+	if err != nil {
+		return nil, err
+	}
+	applyLogConfig(logConfig)
+
+	apiKey, err := secretString("cli.api_key")
+	if err != nil {
+		return nil, err
+	}
+
+	cli := CLIConfig{
+		Address:  viper.GetString("cli.address"),
+		APIKey:   apiKey,
+		Timeout:  viper.GetDuration("cli.timeout"),
+		Insecure: viper.GetBool("cli.insecure"),
+
+		Plaintext:      viper.GetBool("cli.plaintext"),
+		TLSFingerprint: viper.GetString("cli.tls_fingerprint"),
+	}
+
+	if err := applyCLIContext(&cli, viper.GetString("cli.context")); err != nil {
+		return nil, err
+	}
+
+	if cli.TLSFingerprint != "" {
+		cli.TLSFingerprint, err = NormalizeFingerprint(cli.TLSFingerprint)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &Config{
 		DisableUpdateCheck: viper.GetBool("disable_check_updates"),
 		UnixSocket:         viper.GetString("unix_socket"),
-		CLI: CLIConfig{
-			Address:  viper.GetString("cli.address"),
-			APIKey:   viper.GetString("cli.api_key"),
-			Timeout:  viper.GetDuration("cli.timeout"),
-			Insecure: viper.GetBool("cli.insecure"),
-		},
-		Log: logConfig,
+		CLI:                cli,
+		Log:                logConfig,
 	}, nil
 }
 
 // LoadServerConfig returns the full Headscale configuration to
 // host a Headscale server. This is called as part of `headscale serve`.
 func LoadServerConfig() (*Config, error) {
+	// The CLI can run without a configuration file, the server cannot.
+	if viper.ConfigFileUsed() == "" {
+		return nil, errors.New(
+			"no configuration file found in /etc/headscale, $HOME/.headscale or the current directory",
+		)
+	}
+
 	if err := validateServerConfig(); err != nil {
 		return nil, err
 	}
 
-	logConfig := logConfig()
-	zerolog.SetGlobalLevel(logConfig.Level)
+	logConfig, err := logConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	prefix4, err := prefixV4()
 	if err != nil {
@@ -772,6 +3097,11 @@ func LoadServerConfig() (*Config, error) {
 		return nil, fmt.Errorf("no IPv4 or IPv6 prefix configured, minimum one prefix is required")
 	}
 
+	ipFamilies, err := ipFamiliesConfig(prefix4, prefix6)
+	if err != nil {
+		return nil, err
+	}
+
 	allocStr := viper.GetString("prefixes.allocation")
 	var alloc IPAllocationStrategy
 	switch allocStr {
@@ -788,11 +3118,99 @@ func LoadServerConfig() (*Config, error) {
 		return nil, err
 	}
 
-	derpConfig := derpConfig()
+	derpConfig, err := derpConfig()
+	if err != nil {
+		return nil, err
+	}
 	logTailConfig := logtailConfig()
+
+	listeners, err := listenersConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	proxyProtocol, err := proxyProtocolConfig()
+	if err != nil {
+		return nil, err
+	}
+	mapCompression, err := mapCompressionConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	mapSigning, err := mapSigningConfig()
+	if err != nil {
+		return nil, err
+	}
+	nodeKeyRenewal, err := nodeKeyRenewalConfig()
+	if err != nil {
+		return nil, err
+	}
+	nodeExpiry, err := nodeExpiryConfig()
+	if err != nil {
+		return nil, err
+	}
+	offlineNodes, err := offlineNodesConfig()
+	if err != nil {
+		return nil, err
+	}
+	routes, err := routesConfig()
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := metricsConfig()
+	if err != nil {
+		return nil, err
+	}
+	tracing, err := tracingConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientUpdates, err := clientUpdatesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientPolicy, err := clientPolicyConfig()
+	if err != nil {
+		return nil, err
+	}
+	anomalyDetection, err := anomalyDetectionConfig()
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := attestationConfig()
+	if err != nil {
+		return nil, err
+	}
+	registrationVerification, err := registrationVerificationConfig()
+	if err != nil {
+		return nil, err
+	}
+	ldap, err := ldapConfig()
+	if err != nil {
+		return nil, err
+	}
+	portal, err := portalConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := backupConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cliAPIKey, err := secretString("cli.api_key")
+	if err != nil {
+		return nil, err
+	}
 	randomizeClientPort := viper.GetBool("randomize_client_port")
 
-	oidcClientSecret := viper.GetString("oidc.client_secret")
+	oidcClientSecret, err := secretString("oidc.client_secret")
+	if err != nil {
+		return nil, err
+	}
 	oidcClientSecretPath := viper.GetString("oidc.client_secret_path")
 	if oidcClientSecretPath != "" && oidcClientSecret != "" {
 		return nil, errOidcMutuallyExclusive
@@ -805,6 +3223,42 @@ func LoadServerConfig() (*Config, error) {
 		oidcClientSecret = strings.TrimSpace(string(secretBytes))
 	}
 
+	if viper.GetString("oidc.issuer") != "" {
+		err := validateOIDCClientAuth(OIDCProviderConfig{
+			ClientSecret:         oidcClientSecret,
+			ClientAuthMethod:     viper.GetString("oidc.client_auth_method"),
+			ClientPrivateKeyPath: viper.GetString("oidc.client_private_key_path"),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("oidc: %w", err)
+		}
+	}
+
+	oidcProviders, err := oidcProvidersConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	oidcClaimTags, err := oidcClaimTagsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	clientTuning, err := clientTuningConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	exitNodeResolvers, err := exitNodeDNSResolversConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	policyCfg, err := policyConfig()
+	if err != nil {
+		return nil, err
+	}
+
 	serverURL := viper.GetString("server_url")
 
 	// BaseDomain cannot be the same as the server URL.
@@ -820,16 +3274,60 @@ func LoadServerConfig() (*Config, error) {
 		return nil, errors.New("server_url cannot contain the base_domain, this will cause the headscale server and embedded DERP to become unreachable from the Tailscale node.")
 	}
 
-	return &Config{
+	tailnetAdmin, err := tailnetAdminConfig(serverURL, listeners)
+	if err != nil {
+		return nil, err
+	}
+
+	canary, err := canaryConfig(serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := databaseConfig()
+	if err != nil {
+		return nil, err
+	}
+	replica, err := replicaConfig(database)
+	if err != nil {
+		return nil, err
+	}
+	database.ReadOnly = replica.Enabled
+
+	unixSocketAccess, err := unixSocketAccessConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
 		ServerURL:          serverURL,
 		Addr:               viper.GetString("listen_addr"),
 		MetricsAddr:        viper.GetString("metrics_listen_addr"),
 		GRPCAddr:           viper.GetString("grpc_listen_addr"),
 		GRPCAllowInsecure:  viper.GetBool("grpc_allow_insecure"),
+		GRPCReflection:     viper.GetBool("grpc_reflection"),
+		GRPCHealth:         viper.GetBool("grpc_health"),
+		Listeners:          listeners,
+		ListenReusePort:    viper.GetBool("listen_reuse_port"),
+		ProxyProtocol:      proxyProtocol,
+		MapCompression:     mapCompression,
+		MapSigning:         mapSigning,
+		NodeKeyRenewal:     nodeKeyRenewal,
+		NodeExpiry:         nodeExpiry,
+		OfflineNodes:       offlineNodes,
+		Routes:             routes,
+		Metrics:            metrics,
+		Tracing:            tracing,
+		ClientUpdates:      clientUpdates,
+		ClientPolicy:       clientPolicy,
+		TailnetAdmin:       tailnetAdmin,
+		Canary:             canary,
+		Replica:            replica,
 		DisableUpdateCheck: false,
 
 		PrefixV4:     prefix4,
 		PrefixV6:     prefix6,
+		IPFamilies:   ipFamilies,
 		IPAllocation: IPAllocationStrategy(alloc),
 
 		NoisePrivateKeyPath: util.AbsolutePathFromConfigPath(
@@ -842,29 +3340,44 @@ func LoadServerConfig() (*Config, error) {
 		EphemeralNodeInactivityTimeout: viper.GetDuration(
 			"ephemeral_node_inactivity_timeout",
 		),
+		UserAliasExpiry: viper.GetDuration("user_alias_expiry"),
 
-		Database: databaseConfig(),
+		Database: database,
 
 		TLS: tlsConfig(),
 
 		DNSConfig:             dnsToTailcfgDNS(dnsConfig),
 		DNSUserNameInMagicDNS: dnsConfig.UserNameInMagicDNS,
+		DNSHealthCheck:        dnsConfig.Nameservers.HealthCheck,
+		ExitNodeDNSResolvers:  exitNodeResolvers,
 
 		ACMEEmail: viper.GetString("acme_email"),
 		ACMEURL:   viper.GetString("acme_url"),
 
 		UnixSocket:           viper.GetString("unix_socket"),
 		UnixSocketPermission: util.GetFileMode("unix_socket_permission"),
+		UnixSocketOwner:      viper.GetString("unix_socket_owner"),
+		UnixSocketGroup:      viper.GetString("unix_socket_group"),
+		UnixSocketAccess:     unixSocketAccess,
 
 		OIDC: OIDCConfig{
 			OnlyStartIfOIDCIsAvailable: viper.GetBool(
 				"oidc.only_start_if_oidc_is_available",
 			),
+			Name:             viper.GetString("oidc.name"),
 			Issuer:           viper.GetString("oidc.issuer"),
 			ClientID:         viper.GetString("oidc.client_id"),
 			ClientSecret:     oidcClientSecret,
 			Scope:            viper.GetStringSlice("oidc.scope"),
 			ExtraParams:      viper.GetStringMapString("oidc.extra_params"),
+			Domains:          viper.GetStringSlice("oidc.domains"),
+			DeviceFlow:       viper.GetBool("oidc.device_flow"),
+			PKCE:             viper.GetBool("oidc.pkce"),
+			ClientAuthMethod: viper.GetString("oidc.client_auth_method"),
+			ClientPrivateKeyPath: util.AbsolutePathFromConfigPath(
+				viper.GetString("oidc.client_private_key_path"),
+			),
+			ClientKeyID:      viper.GetString("oidc.client_key_id"),
 			AllowedDomains:   viper.GetStringSlice("oidc.allowed_domains"),
 			AllowedUsers:     viper.GetStringSlice("oidc.allowed_users"),
 			AllowedGroups:    viper.GetStringSlice("oidc.allowed_groups"),
@@ -885,29 +3398,73 @@ func LoadServerConfig() (*Config, error) {
 				}
 			}(),
 			UseExpiryFromToken: viper.GetBool("oidc.use_expiry_from_token"),
+
+			AdditionalProviders: oidcProviders,
+			ClaimTags:           oidcClaimTags,
+
+			AllowlistCheckInterval: viper.GetDuration("oidc.allowlist_check_interval"),
+			AllowlistGracePeriod:   viper.GetDuration("oidc.allowlist_grace_period"),
 		},
 
 		LogTail:             logTailConfig,
 		RandomizeClientPort: randomizeClientPort,
+		ClientTuning:        clientTuning,
+
+		Policy: policyCfg,
+
+		GeoIP: GeoIPConfig{
+			DatabasePath: util.AbsolutePathFromConfigPath(
+				viper.GetString("geoip.database_path"),
+			),
+		},
+
+		AnomalyDetection: anomalyDetection,
+		Attestation:      attestation,
 
-		Policy: policyConfig(),
+		RegistrationVerification: registrationVerification,
+
+		LocalAuth: LocalAuthConfig{
+			Enabled:     viper.GetBool("local_auth.enabled"),
+			RequireTOTP: viper.GetBool("local_auth.require_totp"),
+			TOTPIssuer:  viper.GetString("local_auth.totp_issuer"),
+		},
+
+		LDAP: ldap,
+
+		Portal: portal,
+
+		Backup: backup,
 
 		CLI: CLIConfig{
 			Address:  viper.GetString("cli.address"),
-			APIKey:   viper.GetString("cli.api_key"),
+			APIKey:   cliAPIKey,
 			Timeout:  viper.GetDuration("cli.timeout"),
 			Insecure: viper.GetBool("cli.insecure"),
+
+			Plaintext: viper.GetBool("cli.plaintext"),
 		},
 
 		Log: logConfig,
 
+		Debug: DebugConfig{
+			ProfilingEnabled: viper.GetBool("debug.profiling_enabled"),
+		},
+
 		// TODO(kradalby): Document these settings when more stable
 		Tuning: Tuning{
 			NotifierSendTimeout:            viper.GetDuration("tuning.notifier_send_timeout"),
 			BatchChangeDelay:               viper.GetDuration("tuning.batch_change_delay"),
 			NodeMapSessionBufferedChanSize: viper.GetInt("tuning.node_mapsession_buffered_chan_size"),
+			LastSeenPersistInterval:        viper.GetDuration("tuning.last_seen_persist_interval"),
+			PolicyCompileWorkers:           viper.GetInt("tuning.policy_compile_workers"),
 		},
-	}, nil
+	}
+
+	// The log level is only applied once the whole configuration is
+	// valid, a reload of an invalid configuration keeps the current one.
+	applyLogConfig(logConfig)
+
+	return cfg, nil
 }
 
 type deprecator struct {
@@ -970,10 +3527,16 @@ func (d *deprecator) String() string {
 	return b.String()
 }
 
-func (d *deprecator) Log() {
+// Log logs the deprecation warnings, and returns them as an error if
+// any removed option is used.
+func (d *deprecator) Log() error {
 	if len(d.fatals) > 0 {
-		log.Fatal().Msg("\n" + d.String())
-	} else if len(d.warns) > 0 {
+		return errors.New("\n" + d.String())
+	}
+
+	if len(d.warns) > 0 {
 		log.Warn().Msg("\n" + d.String())
 	}
+
+	return nil
 }
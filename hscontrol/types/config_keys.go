@@ -0,0 +1,317 @@
+package types
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// knownConfigKeys are all the configuration keys headscale reads. Lists,
+// like listeners, are a single key. New configuration options have to be
+// added here, or they are reported as unknown.
+var knownConfigKeys = []string{
+	"acme_email",
+	"acme_url",
+	"anomaly_detection.enabled",
+	"anomaly_detection.max_speed_kmh",
+	"anomaly_detection.min_distance_km",
+	"anomaly_detection.quarantine",
+	"anomaly_detection.webhook_url",
+	"attestation.roots_path",
+	"backup.directory",
+	"backup.retention.keep",
+	"backup.retention.max_age",
+	"backup.s3.access_key_id",
+	"backup.s3.bucket",
+	"backup.s3.endpoint",
+	"backup.s3.prefix",
+	"backup.s3.region",
+	"backup.s3.secret_access_key",
+	"backup.s3.use_path_style",
+	"backup.schedule",
+	"canary.auth_key",
+	"canary.control_url",
+	"canary.enabled",
+	"canary.hostname",
+	"canary.interval",
+	"canary.peer",
+	"canary.state_dir",
+	"canary.timeout",
+	"cli.address",
+	"cli.api_key",
+	"cli.context",
+	"cli.insecure",
+	"cli.plaintext",
+	"cli.timeout",
+	"cli.tls_fingerprint",
+	"client_tuning",
+	"client_policy.settings",
+	"client_updates.auto_update",
+	"client_updates.latest_version",
+	"client_updates.minimum_version",
+	"client_updates.notify",
+	"client_updates.notify_url",
+	"database.debug",
+	"database.gc.api_key_retention",
+	"database.gc.interval",
+	"database.gc.netcheck_report_retention",
+	"database.gc.preauth_key_retention",
+	"database.gc.route_history_retention",
+	"database.gorm.parameterized_queries",
+	"database.gorm.prepare_stmt",
+	"database.gorm.skip_err_record_not_found",
+	"database.gorm.slow_threshold",
+	"database.postgres.conn_max_idle_time_secs",
+	"database.postgres.host",
+	"database.postgres.max_idle_conns",
+	"database.postgres.max_open_conns",
+	"database.postgres.name",
+	"database.postgres.pass",
+	"database.postgres.port",
+	"database.postgres.ssl",
+	"database.postgres.user",
+	"database.sqlite.busy_timeout",
+	"database.sqlite.checkpoint_interval",
+	"database.sqlite.checkpoint_mode",
+	"database.sqlite.journal_mode",
+	"database.sqlite.path",
+	"database.sqlite.wal_autocheckpoint",
+	"database.sqlite.write_ahead_log",
+	"database.type",
+	"debug.profiling_enabled",
+	"derp.auto_update_enabled",
+	"derp.paths",
+	"derp.relays.enabled",
+	"derp.relays.expiry",
+	"derp.server.automatically_add_embedded_derp_region",
+	"derp.server.can_port_80",
+	"derp.server.derp_port",
+	"derp.server.enabled",
+	"derp.server.hostname",
+	"derp.server.ipv4",
+	"derp.server.ipv6",
+	"derp.server.latitude",
+	"derp.server.longitude",
+	"derp.server.node_name",
+	"derp.server.private_key_path",
+	"derp.server.rate_limits",
+	"derp.server.region_code",
+	"derp.server.region_id",
+	"derp.server.region_name",
+	"derp.server.stun.enabled",
+	"derp.server.stun_listen_addr",
+	"derp.server.stun_only",
+	"derp.server.stun_port",
+	"derp.update_frequency",
+	"derp.urls",
+	"disable_check_updates",
+	"dns.base_domain",
+	"dns.exit_node_resolvers",
+	"dns.extra_records",
+	"dns.magic_dns",
+	"dns.nameservers.global",
+	"dns.nameservers.health_check.interval",
+	"dns.nameservers.health_check.timeout",
+	"dns.nameservers.split",
+	"dns.search_domains",
+	"dns.use_username_in_magic_dns",
+	"ephemeral_node_inactivity_timeout",
+	"geoip.database_path",
+	"grpc_allow_insecure",
+	"grpc_health",
+	"grpc_listen_addr",
+	"grpc_reflection",
+	"ldap.bind_dn",
+	"ldap.bind_password",
+	"ldap.bind_password_path",
+	"ldap.group_base_dn",
+	"ldap.group_filter",
+	"ldap.group_member_attribute",
+	"ldap.group_name_attribute",
+	"ldap.group_sync_interval",
+	"ldap.insecure_skip_verify",
+	"ldap.start_tls",
+	"ldap.url",
+	"ldap.user_base_dn",
+	"ldap.user_filter",
+	"ldap.username_attribute",
+	"listen_addr",
+	"listen_reuse_port",
+	"listeners",
+	"local_auth.enabled",
+	"local_auth.require_totp",
+	"local_auth.totp_issuer",
+	"log.format",
+	"log.level",
+	"log.levels.db",
+	"log.levels.derp",
+	"log.levels.mapper",
+	"log.levels.oidc",
+	"log.levels.policy",
+	"log.redact",
+	"logtail.enabled",
+	"map_compression.brotli_quality",
+	"map_compression.zstd_level",
+	"map_signing.enabled",
+	"map_signing.private_key_path",
+	"metrics.cardinality",
+	"metrics_listen_addr",
+	"node_expiry.mode",
+	"node_key_renewal.expiry",
+	"node_key_renewal.seamless",
+	"noise.private_key_path",
+	"offline_nodes.action",
+	"offline_nodes.after",
+	"offline_nodes.dry_run",
+	"offline_nodes.exempt_tags",
+	"offline_nodes.interval",
+	"offline_nodes.webhook_url",
+	"oidc.allowed_domains",
+	"oidc.allowed_groups",
+	"oidc.allowed_users",
+	"oidc.allowlist_check_interval",
+	"oidc.allowlist_grace_period",
+	"oidc.claim_tags",
+	"oidc.client_auth_method",
+	"oidc.client_id",
+	"oidc.client_key_id",
+	"oidc.client_private_key_path",
+	"oidc.client_secret",
+	"oidc.client_secret_path",
+	"oidc.device_flow",
+	"oidc.domains",
+	"oidc.expiry",
+	"oidc.extra_params",
+	"oidc.issuer",
+	"oidc.name",
+	"oidc.only_start_if_oidc_is_available",
+	"oidc.pkce",
+	"oidc.providers",
+	"oidc.scope",
+	"oidc.strip_email_domain",
+	"oidc.use_expiry_from_token",
+	"policy.disallow",
+	"policy.mode",
+	"policy.path",
+	"policy.rollout.check_interval",
+	"policy.rollout.probe_failures",
+	"policy.stats_interval",
+	"policy.strict",
+	"portal.enabled",
+	"portal.preauth_keys.allow_reusable",
+	"portal.preauth_keys.max_active",
+	"portal.preauth_keys.max_expiration",
+	"portal.session_expiration",
+	"prefixes.allocation",
+	"prefixes.disable_v4",
+	"prefixes.disable_v6",
+	"prefixes.v4",
+	"prefixes.v6",
+	"proxy_protocol.enabled",
+	"proxy_protocol.trusted_proxies",
+	"randomize_client_port",
+	"registration_verification.code",
+	"registration_verification.code_path",
+	"registration_verification.method",
+	"registration_verification.smtp.from",
+	"registration_verification.smtp.host",
+	"registration_verification.smtp.password",
+	"registration_verification.smtp.password_path",
+	"registration_verification.smtp.port",
+	"registration_verification.smtp.username",
+	"registration_verification.webhook_url",
+	"replica.enabled",
+	"replica.failover_after",
+	"replica.health_check_interval",
+	"replica.primary_url",
+	"replica.refresh_interval",
+	"routes.stale_grace_period",
+	"server_url",
+	"strict_config",
+	"tailnet_admin.auth_key",
+	"tailnet_admin.control_url",
+	"tailnet_admin.enabled",
+	"tailnet_admin.ephemeral",
+	"tailnet_admin.exclusive",
+	"tailnet_admin.grpc_port",
+	"tailnet_admin.hostname",
+	"tailnet_admin.http_port",
+	"tailnet_admin.metrics_port",
+	"tailnet_admin.state_dir",
+	"tls_cert_path",
+	"tls_key_path",
+	"tls_letsencrypt_cache_dir",
+	"tls_letsencrypt_challenge_type",
+	"tls_letsencrypt_hostname",
+	"tls_letsencrypt_listen",
+	"tracing.enabled",
+	"tracing.endpoint",
+	"tracing.insecure",
+	"tracing.protocol",
+	"tracing.sample_ratio",
+	"tuning.batch_change_delay",
+	"tuning.last_seen_persist_interval",
+	"tuning.node_mapsession_buffered_chan_size",
+	"tuning.notifier_send_timeout",
+	"tuning.policy_compile_workers",
+	"unix_socket",
+	"unix_socket_access",
+	"unix_socket_group",
+	"unix_socket_owner",
+	"unix_socket_permission",
+	"user_alias_expiry",
+}
+
+// mapConfigKeys are maps with user defined keys.
+var mapConfigKeys = []string{
+	"client_policy.settings",
+	"dns.nameservers.split",
+	"oidc.extra_params",
+}
+
+// deprecatedConfigKeys are reported by the deprecator instead.
+var deprecatedConfigKeys = []string{
+	"acl_policy_path",
+	"dns_config",
+}
+
+// UnknownConfigKeys returns the keys of the configuration file headscale
+// does not know, e.g. because of a typo. Environment variables are not
+// checked.
+func UnknownConfigKeys() ([]string, error) {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return nil, nil
+	}
+
+	file := viper.New()
+	file.SetConfigFile(path)
+	if err := file.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var unknown []string
+	for _, key := range file.AllKeys() {
+		if !isKnownConfigKey(key) {
+			unknown = append(unknown, key)
+		}
+	}
+	slices.Sort(unknown)
+
+	return unknown, nil
+}
+
+func isKnownConfigKey(key string) bool {
+	if slices.Contains(knownConfigKeys, key) {
+		return true
+	}
+
+	for _, parent := range append(slices.Clone(mapConfigKeys), deprecatedConfigKeys...) {
+		if key == parent || strings.HasPrefix(key, parent+".") {
+			return true
+		}
+	}
+
+	return false
+}
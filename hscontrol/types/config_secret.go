@@ -0,0 +1,60 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// secretFilePrefix marks a config value to be read from a file, like
+// the secrets mounted by Docker or systemd credentials.
+const secretFilePrefix = "file:"
+
+// secretEnvReference matches ${VAR} references. Plain $VAR is left
+// alone as secrets may contain a dollar sign.
+var secretEnvReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveSecret replaces ${VAR} references in value with the environment
+// variable VAR and then, when the value starts with "file:", with the
+// content of the file, trimmed of surrounding whitespace.
+func resolveSecret(value string) (string, error) {
+	var missing []string
+	value = secretEnvReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := secretEnvReference.FindStringSubmatch(ref)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return env
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	path, ok := strings.CutPrefix(value, secretFilePrefix)
+	if !ok {
+		return value, nil
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// secretString returns the config value of key with its secret
+// references resolved.
+func secretString(key string) (string, error) {
+	value, err := resolveSecret(viper.GetString(key))
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", key, err)
+	}
+
+	return value, nil
+}
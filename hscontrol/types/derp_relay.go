@@ -0,0 +1,92 @@
+package types
+
+import (
+	"strconv"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"tailscale.com/tailcfg"
+)
+
+// DERPRelay is a standalone DERP server that registered itself with
+// headscale, it is served to the nodes as its own region of the DERPMap
+// while it keeps sending heartbeats.
+type DERPRelay struct {
+	ID         uint64 `gorm:"primary_key"`
+	RegionID   int    `gorm:"uniqueIndex"`
+	RegionCode string
+	RegionName string
+	Hostname   string
+	IPv4       string
+	IPv6       string
+	DERPPort   int
+	STUNPort   int
+	Latitude   float64
+	Longitude  float64
+
+	LastSeen  time.Time
+	CreatedAt time.Time
+}
+
+// Online reports if the relay sent a heartbeat within expiry.
+func (r *DERPRelay) Online(expiry time.Duration) bool {
+	return time.Since(r.LastSeen) < expiry
+}
+
+// Region returns the DERPMap region of the relay, with the relay as its
+// only node.
+func (r *DERPRelay) Region() tailcfg.DERPRegion {
+	return tailcfg.DERPRegion{
+		RegionID:   r.RegionID,
+		RegionCode: r.RegionCode,
+		RegionName: r.RegionName,
+		Latitude:   r.Latitude,
+		Longitude:  r.Longitude,
+		Nodes: []*tailcfg.DERPNode{
+			{
+				Name:     strconv.Itoa(r.RegionID) + "a",
+				RegionID: r.RegionID,
+				HostName: r.Hostname,
+				IPv4:     r.IPv4,
+				IPv6:     r.IPv6,
+				DERPPort: r.DERPPort,
+				STUNPort: r.STUNPort,
+			},
+		},
+	}
+}
+
+func (r *DERPRelay) Proto(expiry time.Duration) *v1.DERPRelay {
+	return &v1.DERPRelay{
+		RegionId:   int32(r.RegionID),
+		RegionCode: r.RegionCode,
+		RegionName: r.RegionName,
+		Hostname:   r.Hostname,
+		Ipv4:       r.IPv4,
+		Ipv6:       r.IPv6,
+		DerpPort:   int32(r.DERPPort),
+		StunPort:   int32(r.STUNPort),
+		Latitude:   r.Latitude,
+		Longitude:  r.Longitude,
+		LastSeen:   timestamppb.New(r.LastSeen),
+		Online:     r.Online(expiry),
+	}
+}
+
+// DERPRelayFromProto returns the relay described by the registration of
+// a standalone DERP server.
+func DERPRelayFromProto(relay *v1.DERPRelay) DERPRelay {
+	return DERPRelay{
+		RegionID:   int(relay.GetRegionId()),
+		RegionCode: relay.GetRegionCode(),
+		RegionName: relay.GetRegionName(),
+		Hostname:   relay.GetHostname(),
+		IPv4:       relay.GetIpv4(),
+		IPv6:       relay.GetIpv6(),
+		DERPPort:   int(relay.GetDerpPort()),
+		STUNPort:   int(relay.GetStunPort()),
+		Latitude:   relay.GetLatitude(),
+		Longitude:  relay.GetLongitude(),
+	}
+}
@@ -0,0 +1,108 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"tailscale.com/types/dnstype"
+)
+
+var (
+	ErrFQDNCollision   = errors.New("name collides with the DNS configuration")
+	ErrInvalidResolver = errors.New("nameserver is neither an IP address nor a https URL")
+)
+
+// parseResolver parses a nameserver of the configuration, an IP address
+// of a regular resolver or the https URL of a DNS-over-HTTPS resolver.
+func parseResolver(ns string) (*dnstype.Resolver, error) {
+	if _, err := netip.ParseAddr(ns); err == nil {
+		return &dnstype.Resolver{Addr: ns}, nil
+	}
+
+	u, err := url.Parse(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, ErrInvalidResolver
+	}
+
+	return &dnstype.Resolver{Addr: ns}, nil
+}
+
+// FQDNCollision returns an error if the FQDN of a node is one of the
+// search domains or has an extra record, the clients would then resolve
+// it to the node instead of the upstream host or the record.
+func (cfg *Config) FQDNCollision(fqdn string) error {
+	return cfg.dnsCollision(fqdn, false)
+}
+
+// DomainCollision returns an error if domain, like the MagicDNS domain
+// of a user with dns.use_username_in_magic_dns, has an extra record or
+// is, or contains, a search domain. MagicDNS answers for all the names
+// below it, which hides the hosts of the search domain.
+func (cfg *Config) DomainCollision(domain string) error {
+	return cfg.dnsCollision(domain, true)
+}
+
+func (cfg *Config) dnsCollision(name string, subdomains bool) error {
+	reloadable := cfg.Reloadable()
+	if reloadable.DNSConfig == nil || name == "" {
+		return nil
+	}
+
+	for _, domain := range reloadable.DNSConfig.Domains {
+		if dnsNameEqual(domain, reloadable.BaseDomain) {
+			continue
+		}
+
+		if dnsNameEqual(name, domain) || (subdomains && dnsNameUnder(domain, name)) {
+			return fmt.Errorf("%w: %s is the search domain %s", ErrFQDNCollision, name, domain)
+		}
+	}
+
+	for _, record := range reloadable.DNSConfig.ExtraRecords {
+		if dnsNameEqual(name, record.Name) {
+			return fmt.Errorf("%w: %s has the extra record %s", ErrFQDNCollision, name, record.Name)
+		}
+	}
+
+	return nil
+}
+
+// searchDomainsCollision returns an error if a search domain is below
+// the base domain, MagicDNS answers for all of these names and the
+// upstream hosts of the search domain become unreachable.
+func (d *DNSConfig) searchDomainsCollision() error {
+	if !d.MagicDNS || d.BaseDomain == "" {
+		return nil
+	}
+
+	for _, domain := range d.SearchDomains {
+		if dnsNameUnder(domain, d.BaseDomain) {
+			return fmt.Errorf(
+				"dns.search_domains: %q is below dns.base_domain %q, MagicDNS would hide its hosts",
+				domain,
+				d.BaseDomain,
+			)
+		}
+	}
+
+	return nil
+}
+
+func dnsNameEqual(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
+
+// dnsNameUnder reports whether name is strictly below domain.
+func dnsNameUnder(name, domain string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	return domain != "" && strings.HasSuffix(name, "."+domain)
+}
@@ -0,0 +1,83 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+	"tailscale.com/tailcfg"
+)
+
+// NetcheckReport is the result of a netcheck a node sent with its
+// Hostinfo. The reports are kept to debug the connectivity of nodes
+// from the server, e.g. why a node relays all its traffic through DERP.
+type NetcheckReport struct {
+	ID        uint64 `gorm:"primary_key"`
+	NodeID    NodeID `gorm:"index"`
+	Node      Node   `gorm:"constraint:OnDelete:CASCADE;"`
+	CreatedAt time.Time
+
+	// NetInfoDatabaseField is the JSON representation of NetInfo,
+	// it is _only_ used for reading and writing the report to the
+	// database and should not be used.
+	// Use NetInfo instead.
+	NetInfoDatabaseField string           `gorm:"column:net_info"`
+	NetInfo              *tailcfg.NetInfo `gorm:"-"`
+}
+
+// BeforeSave serialises NetInfo to its database field.
+func (r *NetcheckReport) BeforeSave(tx *gorm.DB) error {
+	netInfo, err := json.Marshal(r.NetInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal NetInfo to store in db: %w", err)
+	}
+	r.NetInfoDatabaseField = string(netInfo)
+
+	return nil
+}
+
+// AfterFind restores NetInfo from its database field.
+func (r *NetcheckReport) AfterFind(tx *gorm.DB) error {
+	var netInfo tailcfg.NetInfo
+	if err := json.Unmarshal([]byte(r.NetInfoDatabaseField), &netInfo); err != nil {
+		return fmt.Errorf("failed to unmarshal NetInfo from db: %w", err)
+	}
+	r.NetInfo = &netInfo
+
+	return nil
+}
+
+func (r *NetcheckReport) Proto() *v1.NetcheckReport {
+	report := &v1.NetcheckReport{
+		CreatedAt: timestamppb.New(r.CreatedAt),
+	}
+
+	if r.NetInfo == nil {
+		return report
+	}
+
+	ni := r.NetInfo
+	report.PreferredDerp = int32(ni.PreferredDERP)
+	report.DerpLatency = ni.DERPLatency
+	report.WorkingUdp = string(ni.WorkingUDP)
+	report.WorkingIpv6 = string(ni.WorkingIPv6)
+	report.OsHasIpv6 = string(ni.OSHasIPv6)
+	report.HairPinning = string(ni.HairPinning)
+	report.HavePortMap = ni.HavePortMap
+	report.Upnp = string(ni.UPnP)
+	report.Pmp = string(ni.PMP)
+	report.Pcp = string(ni.PCP)
+	report.LinkType = ni.LinkType
+
+	if varies, ok := ni.MappingVariesByDestIP.Get(); ok {
+		report.NatType = "easy"
+		if varies {
+			report.NatType = "hard"
+		}
+	}
+
+	return report
+}
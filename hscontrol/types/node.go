@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -125,6 +127,40 @@ type Node struct {
 	LastSeen *time.Time
 	Expiry   *time.Time
 
+	// LastSeenAddrDatabaseField is the string representation of
+	// LastSeenAddr, it is _only_ used for reading and writing the
+	// address to the database and should not be used.
+	// Use LastSeenAddr instead.
+	LastSeenAddrDatabaseField sql.NullString `gorm:"column:last_seen_addr"`
+
+	// LastSeenAddr is the public address the node last connected
+	// to headscale from.
+	LastSeenAddr *netip.Addr `gorm:"-"`
+
+	// AttestationDatabaseField is the JSON representation of
+	// Attestation, it is _only_ used for reading and writing the
+	// attestation to the database and should not be used.
+	// Use Attestation instead.
+	AttestationDatabaseField sql.NullString `gorm:"column:attestation"`
+
+	// Attestation is the hardware attestation of the node key, if the
+	// client sent one.
+	Attestation *NodeAttestation `gorm:"-"`
+
+	// Note is a free text annotation set by an admin, like who borrowed
+	// the device and until when.
+	Note string
+
+	// DERPHomeRegion pins the node to this DERP region as its home, the
+	// other regions of its DERP map are marked to be avoided. 0 leaves
+	// the choice to the policy, client_tuning and the client.
+	DERPHomeRegion int
+
+	// Service is the service the node is attributed to instead of its
+	// user, set by the tagged pre auth key it registered with. The
+	// policy refers to it as svc:<service>.
+	Service string
+
 	Routes []Route `gorm:"constraint:OnDelete:CASCADE;"`
 
 	CreatedAt time.Time
@@ -138,6 +174,18 @@ type (
 	Nodes []*Node
 )
 
+// RevokedKey is a node or machine key headscale no longer accepts, like
+// the node key of a node forced to rotate it or the machine key of a
+// lost device. It stays after the node is deleted.
+type RevokedKey struct {
+	ID        uint64 `gorm:"primary_key"`
+	CreatedAt time.Time
+
+	// Key is the key with its type prefix, like nodekey: or mkey:.
+	Key    string `gorm:"uniqueIndex"`
+	NodeID uint64
+}
+
 // IsExpired returns whether the node registration has expired.
 func (node Node) IsExpired() bool {
 	// If Expiry is not set, the client has not indicated that
@@ -289,6 +337,22 @@ func (node *Node) BeforeSave(tx *gorm.DB) error {
 		node.IPv6DatabaseField.String, node.IPv6DatabaseField.Valid = "", false
 	}
 
+	if node.LastSeenAddr != nil {
+		node.LastSeenAddrDatabaseField.String, node.LastSeenAddrDatabaseField.Valid = node.LastSeenAddr.String(), true
+	} else {
+		node.LastSeenAddrDatabaseField.String, node.LastSeenAddrDatabaseField.Valid = "", false
+	}
+
+	if node.Attestation != nil {
+		attestation, err := json.Marshal(node.Attestation)
+		if err != nil {
+			return fmt.Errorf("marshalling Attestation to store in db: %w", err)
+		}
+		node.AttestationDatabaseField.String, node.AttestationDatabaseField.Valid = string(attestation), true
+	} else {
+		node.AttestationDatabaseField.String, node.AttestationDatabaseField.Valid = "", false
+	}
+
 	return nil
 }
 
@@ -355,6 +419,24 @@ func (node *Node) AfterFind(tx *gorm.DB) error {
 		node.IPv6 = &ip
 	}
 
+	if node.LastSeenAddrDatabaseField.Valid {
+		addr, err := netip.ParseAddr(node.LastSeenAddrDatabaseField.String)
+		if err != nil {
+			return fmt.Errorf("parsing last seen address from database: %w", err)
+		}
+
+		node.LastSeenAddr = &addr
+	}
+
+	if node.AttestationDatabaseField.Valid {
+		var attestation NodeAttestation
+		if err := json.Unmarshal([]byte(node.AttestationDatabaseField.String), &attestation); err != nil {
+			return fmt.Errorf("unmarshalling attestation from database: %w", err)
+		}
+
+		node.Attestation = &attestation
+	}
+
 	return nil
 }
 
@@ -372,6 +454,10 @@ func (node *Node) Proto() *v1.Node {
 		GivenName:   node.GivenName,
 		User:        node.User.Proto(),
 		ForcedTags:  node.ForcedTags,
+		Note:        node.Note,
+
+		DerpHomeRegion: int32(node.DERPHomeRegion),
+		Service:        node.Service,
 
 		RegisterMethod: node.RegisterMethodToV1Enum(),
 
@@ -390,9 +476,58 @@ func (node *Node) Proto() *v1.Node {
 		nodeProto.Expiry = timestamppb.New(*node.Expiry)
 	}
 
+	if node.LastSeenAddr != nil {
+		nodeProto.LastSeenAddr = node.LastSeenAddr.String()
+	}
+
+	for _, endpoint := range node.Endpoints {
+		nodeProto.Endpoints = append(nodeProto.Endpoints, endpoint.String())
+	}
+
+	if node.Attestation != nil {
+		nodeProto.Attestation = node.Attestation.Proto()
+	}
+
+	if hi := node.Hostinfo; hi != nil {
+		nodeProto.Hostinfo = &v1.NodeHostinfo{
+			Os:            hi.OS,
+			OsVersion:     hi.OSVersion,
+			ClientVersion: hi.IPNVersion,
+			DeviceModel:   hi.DeviceModel,
+			Distro:        hi.Distro,
+			DistroVersion: hi.DistroVersion,
+		}
+
+		if hi.NetInfo != nil {
+			nodeProto.DerpRegion = int32(hi.NetInfo.PreferredDERP)
+		}
+	}
+
 	return nodeProto
 }
 
+// clientVersionRegex matches the short form of Tailscale versions.
+var clientVersionRegex = regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+
+// ClientVersion returns the short form of the Tailscale version the
+// node reported, like 1.72.1, or an empty string if it did not report
+// one.
+func (node *Node) ClientVersion() string {
+	if node.Hostinfo == nil {
+		return ""
+	}
+
+	version, _, _ := strings.Cut(node.Hostinfo.IPNVersion, "-")
+
+	return version
+}
+
+// IsAttested reports whether the node key is attested by hardware
+// with a verified certificate chain.
+func (node *Node) IsAttested() bool {
+	return node.Attestation != nil && node.Attestation.Verified
+}
+
 func (node *Node) GetFQDN(cfg *Config, baseDomain string) (string, error) {
 	if node.GivenName == "" {
 		return "", fmt.Errorf("failed to create valid FQDN: %w", ErrNodeHasNoGivenName)
@@ -408,7 +543,7 @@ func (node *Node) GetFQDN(cfg *Config, baseDomain string) (string, error) {
 		)
 	}
 
-	if cfg.DNSUserNameInMagicDNS {
+	if cfg.Reloadable().DNSUserNameInMagicDNS {
 		if node.User.Name == "" {
 			return "", fmt.Errorf("failed to create valid FQDN: %w", ErrNodeUserHasNoName)
 		}
@@ -478,8 +613,10 @@ func (node *Node) PeerChangeFromMapRequest(req tailcfg.MapRequest) tailcfg.PeerC
 		}
 	}
 
-	// TODO(kradalby): Find a good way to compare updates
-	ret.Endpoints = req.Endpoints
+	if !slices.Equal(node.Endpoints, req.Endpoints) {
+		// An empty, non nil list removes the endpoints of the peer.
+		ret.Endpoints = append([]netip.AddrPort{}, req.Endpoints...)
+	}
 
 	now := time.Now()
 	ret.LastSeen = &now
@@ -495,6 +632,10 @@ func (node *Node) RegisterMethodToV1Enum() v1.RegisterMethod {
 		return v1.RegisterMethod_REGISTER_METHOD_OIDC
 	case "cli":
 		return v1.RegisterMethod_REGISTER_METHOD_CLI
+	case "password":
+		return v1.RegisterMethod_REGISTER_METHOD_PASSWORD
+	case "ldap":
+		return v1.RegisterMethod_REGISTER_METHOD_LDAP
 	default:
 		return v1.RegisterMethod_REGISTER_METHOD_UNSPECIFIED
 	}
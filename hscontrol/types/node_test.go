@@ -127,7 +127,7 @@ func TestNodeFQDN(t *testing.T) {
 	tests := []struct {
 		name    string
 		node    Node
-		cfg     Config
+		cfg     *Config
 		domain  string
 		want    string
 		wantErr string
@@ -140,7 +140,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: true,
 				},
@@ -156,7 +156,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: true,
 				},
@@ -171,7 +171,7 @@ func TestNodeFQDN(t *testing.T) {
 				GivenName: "test",
 				User:      User{},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: true,
 				},
@@ -188,7 +188,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: false,
 				},
@@ -205,6 +205,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
+			cfg:    &Config{},
 			domain: "example.com",
 			want:   "test.example.com",
 		},
@@ -216,7 +217,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: true,
 				},
@@ -232,7 +233,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: true,
 				},
@@ -247,7 +248,7 @@ func TestNodeFQDN(t *testing.T) {
 				GivenName: "test",
 				User:      User{},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: true,
 				},
@@ -264,7 +265,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
-			cfg: Config{
+			cfg: &Config{
 				DNSConfig: &tailcfg.DNSConfig{
 					Proxied: false,
 				},
@@ -281,6 +282,7 @@ func TestNodeFQDN(t *testing.T) {
 					Name: "user",
 				},
 			},
+			cfg:    &Config{},
 			domain: "example.com",
 			want:   "test.example.com",
 		},
@@ -288,7 +290,7 @@ func TestNodeFQDN(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := tc.node.GetFQDN(&tc.cfg, tc.domain)
+			got, err := tc.node.GetFQDN(tc.cfg, tc.domain)
 
 			if (err != nil) && (err.Error() != tc.wantErr) {
 				t.Errorf("GetFQDN() error = %s, wantErr %s", err, tc.wantErr)
@@ -322,6 +324,41 @@ func TestPeerChangeFromMapRequest(t *testing.T) {
 		mapReq tailcfg.MapRequest
 		want   tailcfg.PeerChange
 	}{
+		{
+			name: "endpoints-changed",
+			node: Node{
+				ID:        1,
+				NodeKey:   nKeys[0],
+				DiscoKey:  dKeys[0],
+				Endpoints: []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:41641")},
+			},
+			mapReq: tailcfg.MapRequest{
+				NodeKey:   nKeys[0],
+				DiscoKey:  dKeys[0],
+				Endpoints: []netip.AddrPort{netip.MustParseAddrPort("198.51.100.7:41641")},
+			},
+			want: tailcfg.PeerChange{
+				NodeID:    1,
+				Endpoints: []netip.AddrPort{netip.MustParseAddrPort("198.51.100.7:41641")},
+			},
+		},
+		{
+			name: "endpoints-unchanged",
+			node: Node{
+				ID:        1,
+				NodeKey:   nKeys[0],
+				DiscoKey:  dKeys[0],
+				Endpoints: []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:41641")},
+			},
+			mapReq: tailcfg.MapRequest{
+				NodeKey:   nKeys[0],
+				DiscoKey:  dKeys[0],
+				Endpoints: []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:41641")},
+			},
+			want: tailcfg.PeerChange{
+				NodeID: 1,
+			},
+		},
 		{
 			name: "preferred-derp-changed",
 			node: Node{
@@ -428,7 +465,7 @@ func TestPeerChangeFromMapRequest(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			got := tc.node.PeerChangeFromMapRequest(tc.mapReq)
 
-			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreFields(tailcfg.PeerChange{}, "LastSeen")); diff != "" {
+			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreFields(tailcfg.PeerChange{}, "LastSeen"), cmpopts.EquateComparable(netip.AddrPort{})); diff != "" {
 				t.Errorf("Patch unexpected result (-want +got):\n%s", diff)
 			}
 		})
@@ -591,3 +628,34 @@ func TestNodeRegisterMethodToV1Enum(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeProtoHostinfo(t *testing.T) {
+	node := Node{
+		Hostinfo: &tailcfg.Hostinfo{
+			OS:         "linux",
+			OSVersion:  "6.8.0",
+			IPNVersion: "1.72.1",
+			Distro:     "ubuntu",
+			NetInfo:    &tailcfg.NetInfo{PreferredDERP: 3},
+		},
+	}
+
+	got := node.Proto()
+
+	want := &v1.NodeHostinfo{
+		Os:            "linux",
+		OsVersion:     "6.8.0",
+		ClientVersion: "1.72.1",
+		Distro:        "ubuntu",
+	}
+	if diff := cmp.Diff(want, got.GetHostinfo(), cmpopts.IgnoreUnexported(v1.NodeHostinfo{})); diff != "" {
+		t.Errorf("Proto() hostinfo unexpected result (-want +got):\n%s", diff)
+	}
+	if got.GetDerpRegion() != 3 {
+		t.Errorf("Proto() DERP region = %d, want 3", got.GetDerpRegion())
+	}
+
+	if got := (&Node{}).Proto(); got.GetHostinfo() != nil || got.GetDerpRegion() != 0 {
+		t.Errorf("Proto() of node without hostinfo = %v, %d", got.GetHostinfo(), got.GetDerpRegion())
+	}
+}
@@ -0,0 +1,28 @@
+package types
+
+import "time"
+
+// OIDCSession is the refresh token of the last OIDC login of a user. It
+// is used to check the user is still allowed to use headscale after the
+// login, with the allowlists of the oidc configuration.
+type OIDCSession struct {
+	UserName string `gorm:"primary_key"`
+	Provider string
+	Email    string
+
+	// RefreshToken is encrypted with a key derived from the noise
+	// private key, see sealOIDCRefreshToken.
+	RefreshToken string `json:"-"`
+
+	// CheckedAt is when the identity provider was last asked about
+	// the user.
+	CheckedAt time.Time
+
+	// DeniedSince is set when the identity provider first reported the
+	// user is no longer allowed, the nodes of the user are expired once
+	// the grace period passed.
+	DeniedSince *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -2,13 +2,19 @@ package types
 
 import (
 	"errors"
+	"time"
 
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
 )
 
 var (
-	ErrPolicyNotFound         = errors.New("acl policy not found")
-	ErrPolicyUpdateIsDisabled = errors.New("update is disabled for modes other than 'database'")
+	ErrPolicyNotFound          = errors.New("acl policy not found")
+	ErrPolicyUpdateIsDisabled  = errors.New("update is disabled for modes other than 'database'")
+	ErrPolicyVersionMismatch   = errors.New("acl policy has been changed since the expected version")
+	ErrPolicyRolloutNotFound   = errors.New("no policy rollout in progress")
+	ErrPolicyRolloutInProgress = errors.New("a policy rollout is already in progress")
 )
 
 // Policy represents a policy in the database.
@@ -18,3 +24,57 @@ type Policy struct {
 	// Data contains the policy in HuJSON format.
 	Data string
 }
+
+const (
+	PolicyRolloutCanary     = "canary"
+	PolicyRolloutPromoted   = "promoted"
+	PolicyRolloutRolledBack = "rolled_back"
+)
+
+// PolicyRollout is a policy applied to the canary nodes, the nodes with
+// CanaryTag, before it is promoted to the whole tailnet.
+type PolicyRollout struct {
+	ID uint64 `gorm:"primary_key"`
+
+	// Data contains the policy in HuJSON format.
+	Data string
+
+	// BaseVersion is the version of the policy the rollout replaces,
+	// promoting fails if the policy was changed since.
+	BaseVersion uint
+
+	CanaryTag string
+
+	// Probes are the nodes, by name or tailnet address, the canary
+	// nodes must keep reaching.
+	Probes StringList
+
+	// PromoteAt is when the policy is promoted if the canary nodes
+	// stayed healthy.
+	PromoteAt time.Time
+
+	// State is one of PolicyRolloutCanary, PolicyRolloutPromoted and
+	// PolicyRolloutRolledBack.
+	State string
+
+	// Reason is why the rollout was rolled back.
+	Reason string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (r *PolicyRollout) Proto() *v1.PolicyRollout {
+	return &v1.PolicyRollout{
+		Id:          r.ID,
+		Policy:      r.Data,
+		BaseVersion: uint64(r.BaseVersion),
+		CanaryTag:   r.CanaryTag,
+		Probes:      r.Probes,
+		PromoteAt:   timestamppb.New(r.PromoteAt),
+		State:       r.State,
+		Reason:      r.Reason,
+		CreatedAt:   timestamppb.New(r.CreatedAt),
+		UpdatedAt:   timestamppb.New(r.UpdatedAt),
+	}
+}
@@ -1,11 +1,13 @@
 package types
 
 import (
+	"net/netip"
 	"strconv"
 	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/juanfont/headscale/hscontrol/util"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -20,6 +22,23 @@ type PreAuthKey struct {
 	Used      bool               `gorm:"default:false"`
 	ACLTags   []PreAuthKeyACLTag `gorm:"constraint:OnDelete:CASCADE;"`
 
+	// NodeNamePrefix is prepended to the hostname of the nodes
+	// registered with the key to make their given name.
+	NodeNamePrefix string
+
+	// NodeExpiry is the key expiry of the nodes registered with the
+	// key, counted from their registration. Zero keeps the expiry
+	// requested by the client.
+	NodeExpiry time.Duration
+
+	// ApprovedRoutes are the prefixes the nodes registered with the key
+	// may advertise without further approval.
+	ApprovedRoutes StringList
+
+	// Service attributes the nodes registered with the key to a service
+	// instead of the user of the key. Only tagged keys have one.
+	Service string
+
 	CreatedAt  *time.Time
 	Expiration *time.Time
 }
@@ -54,5 +73,52 @@ func (key *PreAuthKey) Proto() *v1.PreAuthKey {
 		protoKey.AclTags[idx] = key.ACLTags[idx].Tag
 	}
 
+	protoKey.NodeNamePrefix = key.NodeNamePrefix
+	if key.NodeExpiry > 0 {
+		protoKey.NodeExpiry = durationpb.New(key.NodeExpiry)
+	}
+	protoKey.ApprovedRoutes = append([]string{}, key.ApprovedRoutes...)
+	protoKey.Service = key.Service
+
 	return &protoKey
 }
+
+// ApprovesRoute reports whether the route is within one of the
+// prefixes approved by the key.
+func (key *PreAuthKey) ApprovesRoute(route netip.Prefix) bool {
+	for _, approved := range key.ApprovedRoutes {
+		prefix, err := netip.ParsePrefix(approved)
+		if err != nil {
+			continue
+		}
+
+		if prefix.Bits() <= route.Bits() && prefix.Contains(route.Addr()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NodeGivenName returns the name to give to a node with the given
+// hostname registered with the key.
+func (key *PreAuthKey) NodeGivenName(hostname string) string {
+	if key.NodeNamePrefix == "" {
+		return hostname
+	}
+
+	return key.NodeNamePrefix + "-" + hostname
+}
+
+// NodeExpiryAt returns the expiry of a node registered with the key at
+// the given time, or the expiry requested by the client if the key does
+// not fix it.
+func (key *PreAuthKey) NodeExpiryAt(now time.Time, requested time.Time) *time.Time {
+	if key.NodeExpiry <= 0 {
+		return &requested
+	}
+
+	expiry := now.Add(key.NodeExpiry)
+
+	return &expiry
+}
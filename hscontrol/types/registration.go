@@ -0,0 +1,46 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PendingRegistration is a node waiting to be registered, with
+// headscale nodes register or an auth callback. They are kept in the
+// database so the registration URL of a node stays valid when
+// headscale restarts.
+type PendingRegistration struct {
+	MachineKey string `gorm:"primary_key"`
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+
+	// NodeDatabaseField is the JSON representation of Node,
+	// it is _only_ used for reading and writing the registration to
+	// the database and should not be used.
+	// Use Node instead.
+	NodeDatabaseField string `gorm:"column:node"`
+	Node              Node   `gorm:"-"`
+}
+
+// BeforeSave serialises Node to its database field.
+func (r *PendingRegistration) BeforeSave(tx *gorm.DB) error {
+	node, err := json.Marshal(r.Node)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Node to store in db: %w", err)
+	}
+	r.NodeDatabaseField = string(node)
+
+	return nil
+}
+
+// AfterFind deserialises Node from its database field.
+func (r *PendingRegistration) AfterFind(tx *gorm.DB) error {
+	if err := json.Unmarshal([]byte(r.NodeDatabaseField), &r.Node); err != nil {
+		return fmt.Errorf("failed to unmarshal Node from db: %w", err)
+	}
+
+	return nil
+}
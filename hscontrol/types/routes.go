@@ -3,10 +3,12 @@ package types
 import (
 	"fmt"
 	"net/netip"
+	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"gorm.io/gorm"
+	"tailscale.com/util/ctxkey"
 )
 
 var (
@@ -26,6 +28,15 @@ type Route struct {
 	Advertised bool
 	Enabled    bool
 	IsPrimary  bool
+
+	// PrimaryChangedAt is when the route last became, or stopped being,
+	// the primary route of its prefix, PrimaryReason says why.
+	PrimaryChangedAt *time.Time
+	PrimaryReason    string
+
+	// WithdrawnAt is when the node stopped advertising the route, it is
+	// removed once routes.stale_grace_period passed.
+	WithdrawnAt *time.Time
 }
 
 type Routes []Route
@@ -96,8 +107,66 @@ func (rs Routes) Proto() []*v1.Route {
 			protoRoute.DeletedAt = timestamppb.New(route.DeletedAt.Time)
 		}
 
+		if route.PrimaryChangedAt != nil {
+			protoRoute.PrimaryChangedAt = timestamppb.New(*route.PrimaryChangedAt)
+		}
+		protoRoute.PrimaryReason = route.PrimaryReason
+
 		protoRoutes = append(protoRoutes, &protoRoute)
 	}
 
 	return protoRoutes
 }
+
+// Actions recorded in the route history.
+const (
+	RouteEventAdvertised = "advertised"
+	RouteEventWithdrawn  = "withdrawn"
+	RouteEventEnabled    = "enabled"
+	RouteEventDisabled   = "disabled"
+	RouteEventDeleted    = "deleted"
+	RouteEventPrimary    = "primary"
+)
+
+// Actors of route changes headscale makes on its own.
+const (
+	RouteActorNode          = "node"
+	RouteActorHeadscale     = "headscale"
+	RouteActorAutoApprovers = "autoApprovers"
+	RouteActorPreAuthKey    = "pre-auth key"
+)
+
+// RouteActorKey is the context key of who changes routes, the database
+// transactions changing routes record it in the route history.
+var RouteActorKey = ctxkey.New("route.actor", RouteActorHeadscale)
+
+// RouteEvent is a change of a route, kept in the route history. The
+// events stay after the route and its node are deleted.
+type RouteEvent struct {
+	ID        uint64    `gorm:"primary_key"`
+	CreatedAt time.Time `gorm:"index"`
+
+	NodeID   uint64 `gorm:"index"`
+	Hostname string
+	Prefix   IPPrefix `gorm:"index"`
+
+	// Action is one of the RouteEvent* actions.
+	Action string
+	// Actor is who made the change, like the API key or user of the
+	// CLI, or one of the RouteActor* actors.
+	Actor  string
+	Reason string
+}
+
+func (e *RouteEvent) Proto() *v1.RouteEvent {
+	return &v1.RouteEvent{
+		Id:        e.ID,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+		NodeId:    e.NodeID,
+		Hostname:  e.Hostname,
+		Prefix:    netip.Prefix(e.Prefix).String(),
+		Action:    e.Action,
+		Actor:     e.Actor,
+		Reason:    e.Reason,
+	}
+}
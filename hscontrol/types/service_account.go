@@ -0,0 +1,28 @@
+package types
+
+import (
+	"time"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ServiceAccount is a principal for nodes not owned by a human, like a
+// fleet of workload nodes. It cannot log in, it is managed with the API
+// and attributed to nodes by tagged pre auth keys or by an admin. The
+// policy refers to it as svc:<name>.
+type ServiceAccount struct {
+	ID          uint64 `gorm:"primary_key"`
+	Name        string `gorm:"unique"`
+	Description string
+	CreatedAt   time.Time
+}
+
+func (s *ServiceAccount) Proto() *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		Id:          s.ID,
+		Name:        s.Name,
+		Description: s.Description,
+		CreatedAt:   timestamppb.New(s.CreatedAt),
+	}
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"strconv"
+	"time"
 
 	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
 	"github.com/juanfont/headscale/hscontrol/util"
@@ -17,6 +18,47 @@ import (
 type User struct {
 	gorm.Model
 	Name string `gorm:"unique"`
+
+	// SuspendedAt is set while the user is suspended, the nodes of
+	// a suspended user are expired and cannot be registered again.
+	SuspendedAt *time.Time
+
+	// PasswordHash is the argon2id hash of the password used with the
+	// built-in authentication, empty if no password is set.
+	PasswordHash string `json:"-"`
+
+	// TOTPSecret is the base32 encoded secret of the second factor
+	// used with the built-in authentication.
+	TOTPSecret string `json:"-"`
+}
+
+// IsSuspended reports if the user is suspended.
+func (u *User) IsSuspended() bool {
+	return u.SuspendedAt != nil
+}
+
+// UserAlias is a previous name of a user. It is recorded when the user
+// is renamed, and resolves to the user in the policy until it expires.
+type UserAlias struct {
+	ID        uint64 `gorm:"primary_key"`
+	Name      string `gorm:"index"`
+	UserID    uint
+	User      User `gorm:"constraint:OnDelete:CASCADE;"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// IsActive reports if the alias still resolves to its user.
+func (a *UserAlias) IsActive(now time.Time) bool {
+	return now.Before(a.ExpiresAt)
+}
+
+func (a *UserAlias) Proto() *v1.UserAlias {
+	return &v1.UserAlias{
+		Name:      a.Name,
+		CreatedAt: timestamppb.New(a.CreatedAt),
+		ExpiresAt: timestamppb.New(a.ExpiresAt),
+	}
 }
 
 // TODO(kradalby): See if we can fill in Gravatar here
@@ -60,9 +102,18 @@ func (u *User) TailscaleUserProfile() tailcfg.UserProfile {
 }
 
 func (n *User) Proto() *v1.User {
-	return &v1.User{
+	user := &v1.User{
 		Id:        strconv.FormatUint(uint64(n.ID), util.Base10),
 		Name:      n.Name,
 		CreatedAt: timestamppb.New(n.CreatedAt),
+
+		HasPassword: n.PasswordHash != "",
+		TotpEnabled: n.TOTPSecret != "",
+	}
+
+	if n.SuspendedAt != nil {
+		user.SuspendedAt = timestamppb.New(*n.SuspendedAt)
 	}
+
+	return user
 }
@@ -0,0 +1,277 @@
+package hscontrol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var errPeerCredentialsUnsupported = errors.New(
+	"reading the credentials of unix socket peers is not supported on this platform",
+)
+
+// readMethodPrefixes are the prefixes of the gRPC methods that do not
+// change anything, allowed with types.APIScopeRead.
+var readMethodPrefixes = []string{"Get", "List"}
+
+// chownUnixSocket sets the owner and group of the socket if they are
+// configured.
+func (h *Headscale) chownUnixSocket() error {
+	uid, gid := -1, -1
+
+	if h.cfg.UnixSocketOwner != "" {
+		owner, err := lookupUser(h.cfg.UnixSocketOwner)
+		if err != nil {
+			return fmt.Errorf("unix_socket_owner: %w", err)
+		}
+
+		uid, _ = strconv.Atoi(owner.Uid)
+	}
+
+	if h.cfg.UnixSocketGroup != "" {
+		group, err := lookupGroup(h.cfg.UnixSocketGroup)
+		if err != nil {
+			return fmt.Errorf("unix_socket_group: %w", err)
+		}
+
+		gid, _ = strconv.Atoi(group.Gid)
+	}
+
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	return os.Chown(h.cfg.UnixSocket, uid, gid)
+}
+
+// lookupUser finds a user by name or numeric ID.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// lookupGroup finds a group by name or numeric ID.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+
+	return user.LookupGroup(name)
+}
+
+// socketAccessRule is a types.UnixSocketAccessRule with the users and
+// groups resolved to IDs.
+type socketAccessRule struct {
+	uids  []string
+	gids  []string
+	scope types.APIScope
+}
+
+// socketAccess maps the peer credentials of unix socket connections to
+// API scopes.
+type socketAccess struct {
+	// adminUIDs are root and the user headscale runs as, the gRPC
+	// gateway connects with it and its requests are already
+	// authenticated by API key.
+	adminUIDs []string
+	rules     []socketAccessRule
+}
+
+func newSocketAccess(rules []types.UnixSocketAccessRule) (*socketAccess, error) {
+	access := &socketAccess{
+		adminUIDs: []string{"0", strconv.Itoa(os.Getuid())},
+	}
+
+	for index, rule := range rules {
+		resolved := socketAccessRule{scope: rule.Scope}
+
+		for _, name := range rule.Users {
+			found, err := lookupUser(name)
+			if err != nil {
+				return nil, fmt.Errorf("unix_socket_access[%d]: %w", index, err)
+			}
+			resolved.uids = append(resolved.uids, found.Uid)
+		}
+
+		for _, name := range rule.Groups {
+			found, err := lookupGroup(name)
+			if err != nil {
+				return nil, fmt.Errorf("unix_socket_access[%d]: %w", index, err)
+			}
+			resolved.gids = append(resolved.gids, found.Gid)
+		}
+
+		access.rules = append(access.rules, resolved)
+	}
+
+	return access, nil
+}
+
+// scope returns the widest scope of the rules matching the peer, and
+// false if none match. root and the user headscale runs as are admins.
+func (a *socketAccess) scope(creds peerAuthInfo) (types.APIScope, bool) {
+	uid := strconv.FormatUint(uint64(creds.UID), 10)
+	if slices.Contains(a.adminUIDs, uid) {
+		return types.APIScopeAdmin, true
+	}
+
+	gids := make([]string, 0, len(creds.GIDs))
+	for _, gid := range creds.GIDs {
+		gids = append(gids, strconv.FormatUint(uint64(gid), 10))
+	}
+
+	// Peer credentials only carry the primary group on Linux.
+	if found, err := user.LookupId(uid); err == nil {
+		if groupIDs, err := found.GroupIds(); err == nil {
+			gids = append(gids, groupIDs...)
+		}
+	}
+
+	var scope types.APIScope
+	for _, rule := range a.rules {
+		matches := slices.Contains(rule.uids, uid) ||
+			slices.ContainsFunc(rule.gids, func(gid string) bool {
+				return slices.Contains(gids, gid)
+			})
+		if !matches {
+			continue
+		}
+
+		if rule.scope == types.APIScopeAdmin {
+			return types.APIScopeAdmin, true
+		}
+		scope = rule.scope
+	}
+
+	return scope, scope != ""
+}
+
+// scopeAllows reports if the scope allows calling the gRPC method, like
+// /headscale.v1.HeadscaleService/ListNodes.
+func scopeAllows(scope types.APIScope, fullMethod string) bool {
+	switch scope {
+	case types.APIScopeAdmin:
+		return true
+	case types.APIScopeRead:
+		method := path.Base(fullMethod)
+		for _, prefix := range readMethodPrefixes {
+			if strings.HasPrefix(method, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// unixSocketAuthorizationInterceptor checks the scope of the process
+// calling over the unix socket.
+func (a *socketAccess) unixSocketAuthorizationInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if isGRPCHealthMethod(info.FullMethod) {
+		return handler(ctx, req)
+	}
+
+	client, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "unknown unix socket peer")
+	}
+
+	creds, ok := client.AuthInfo.(peerAuthInfo)
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "unknown unix socket peer")
+	}
+
+	scope, ok := a.scope(creds)
+	if !ok || !scopeAllows(scope, info.FullMethod) {
+		log.Info().
+			Uint32("uid", creds.UID).
+			Str("scope", string(scope)).
+			Str("method", info.FullMethod).
+			Msg("Denied unix socket request")
+
+		return nil, status.Errorf(
+			codes.PermissionDenied,
+			"uid %d is not allowed to call %s",
+			creds.UID,
+			path.Base(info.FullMethod),
+		)
+	}
+
+	return handler(ctx, req)
+}
+
+// peerAuthInfo holds the credentials of the process at the other end of
+// a unix socket connection.
+type peerAuthInfo struct {
+	credentials.CommonAuthInfo
+
+	UID  uint32
+	GIDs []uint32
+}
+
+func (peerAuthInfo) AuthType() string {
+	return "peercred"
+}
+
+// peerCredentials are gRPC transport credentials for the unix socket
+// that read the credentials of connecting processes, the connections
+// themselves are not changed.
+type peerCredentials struct{}
+
+func (peerCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	uid, gids, err := readPeerCredentials(conn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading peer credentials: %w", err)
+	}
+
+	return conn, peerAuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{
+			SecurityLevel: credentials.PrivacyAndIntegrity,
+		},
+		UID:  uid,
+		GIDs: gids,
+	}, nil
+}
+
+func (peerCredentials) ClientHandshake(
+	_ context.Context,
+	_ string,
+	conn net.Conn,
+) (net.Conn, credentials.AuthInfo, error) {
+	return conn, peerAuthInfo{}, nil
+}
+
+func (peerCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "peercred"}
+}
+
+func (c peerCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (peerCredentials) OverrideServerName(string) error {
+	return nil
+}
@@ -0,0 +1,94 @@
+package hscontrol
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	v1 "github.com/juanfont/headscale/gen/go/headscale/v1"
+	"github.com/juanfont/headscale/hscontrol/types"
+	"github.com/juanfont/headscale/hscontrol/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestScopeAllows(t *testing.T) {
+	tests := []struct {
+		scope  types.APIScope
+		method string
+		want   bool
+	}{
+		{types.APIScopeAdmin, "/headscale.v1.HeadscaleService/DeleteNode", true},
+		{types.APIScopeRead, "/headscale.v1.HeadscaleService/ListNodes", true},
+		{types.APIScopeRead, "/headscale.v1.HeadscaleService/GetPolicy", true},
+		{types.APIScopeRead, "/headscale.v1.HeadscaleService/SetPolicy", false},
+		{types.APIScopeRead, "/headscale.v1.HeadscaleService/DebugCreateNode", false},
+		{"", "/headscale.v1.HeadscaleService/ListNodes", false},
+	}
+
+	for _, tt := range tests {
+		if got := scopeAllows(tt.scope, tt.method); got != tt.want {
+			t.Errorf("scopeAllows(%q, %q) = %t, want %t", tt.scope, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestUnixSocketAccess(t *testing.T) {
+	_, api := newTestAPIServer(t, &types.Config{})
+
+	// The test runs as an admin, drop that to check the rules.
+	access, err := newSocketAccess([]types.UnixSocketAccessRule{
+		{Groups: []string{strconv.Itoa(os.Getgid())}, Scope: types.APIScopeRead},
+	})
+	if err != nil {
+		t.Fatalf("newSocketAccess: %s", err)
+	}
+	access.adminUIDs = nil
+
+	socket := filepath.Join(t.TempDir(), "headscale.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listening: %s", err)
+	}
+
+	server := grpc.NewServer(
+		grpc.Creds(peerCredentials{}),
+		grpc.UnaryInterceptor(access.unixSocketAuthorizationInterceptor),
+	)
+	v1.RegisterHeadscaleServiceServer(server, api)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(
+		socket,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(util.GrpcSocketDialer),
+	)
+	if err != nil {
+		t.Fatalf("dialing: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	client := v1.NewHeadscaleServiceClient(conn)
+	ctx := context.Background()
+
+	if _, err := client.ListUsers(ctx, &v1.ListUsersRequest{}); err != nil {
+		t.Errorf("ListUsers with read scope: %s", err)
+	}
+
+	_, err = client.CreateUser(ctx, &v1.CreateUserRequest{Name: "denied"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateUser with read scope: got %v, want PermissionDenied", err)
+	}
+
+	access.rules = nil
+	_, err = client.ListUsers(ctx, &v1.ListUsersRequest{})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("ListUsers without rules: got %v, want PermissionDenied", err)
+	}
+}
@@ -1,7 +1,9 @@
 package util
 
 const (
-	RegisterMethodAuthKey = "authkey"
-	RegisterMethodOIDC    = "oidc"
-	RegisterMethodCLI     = "cli"
+	RegisterMethodAuthKey  = "authkey"
+	RegisterMethodOIDC     = "oidc"
+	RegisterMethodCLI      = "cli"
+	RegisterMethodPassword = "password"
+	RegisterMethodLDAP     = "ldap"
 )
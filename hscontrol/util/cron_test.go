@@ -0,0 +1,61 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// A Saturday.
+	from := time.Date(2026, time.October, 17, 10, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.October, 17, 10, 21, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2026, time.October, 18, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, time.October, 17, 11, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.October, 17, 10, 30, 0, 0, time.UTC)},
+		{"30 10-12/2 * * *", time.Date(2026, time.October, 17, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * 1-5", time.Date(2026, time.October, 19, 2, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted.
+		{"0 0 20 * 1", time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			sched, err := ParseCronSchedule(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCronSchedule() error = %s", err)
+			}
+
+			if got := sched.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		if _, err := ParseCronSchedule(expr); err == nil {
+			t.Errorf("ParseCronSchedule(%q) returned no error", expr)
+		}
+	}
+}
@@ -12,6 +12,7 @@ var (
 	NodePublicKeyRegex       = regexp.MustCompile("nodekey:[a-fA-F0-9]+")
 	ErrCannotDecryptResponse = errors.New("cannot decrypt response")
 	ZstdCompression          = "zstd"
+	BrotliCompression        = "br"
 )
 
 func DecodeAndUnmarshalNaCl(
@@ -0,0 +1,166 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// LogSubsystem is a part of headscale whose log level can be set on its
+// own.
+type LogSubsystem string
+
+const (
+	// LogSubsystemDefault is the level of everything not in a
+	// subsystem, and of the subsystems without a level of their own.
+	LogSubsystemDefault LogSubsystem = ""
+
+	LogSubsystemPolicy LogSubsystem = "policy"
+	LogSubsystemMapper LogSubsystem = "mapper"
+	LogSubsystemDERP   LogSubsystem = "derp"
+	LogSubsystemOIDC   LogSubsystem = "oidc"
+	LogSubsystemDB     LogSubsystem = "db"
+)
+
+// LogSubsystems are the subsystems with a log level of their own.
+var LogSubsystems = []LogSubsystem{
+	LogSubsystemPolicy,
+	LogSubsystemMapper,
+	LogSubsystemDERP,
+	LogSubsystemOIDC,
+	LogSubsystemDB,
+}
+
+// logLevels holds the levels set by log.level and log.levels, and
+// changed at runtime through the API.
+var logLevels = struct {
+	mu         sync.RWMutex
+	def        zerolog.Level
+	subsystems map[LogSubsystem]zerolog.Level
+}{
+	def: zerolog.InfoLevel,
+}
+
+// ParseLogSubsystem returns the subsystem named name, empty for the
+// default level.
+func ParseLogSubsystem(name string) (LogSubsystem, error) {
+	subsystem := LogSubsystem(name)
+	if subsystem == LogSubsystemDefault {
+		return subsystem, nil
+	}
+	for _, known := range LogSubsystems {
+		if subsystem == known {
+			return subsystem, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown log subsystem %q", name)
+}
+
+// SetLogLevels replaces the default level and the levels of the
+// subsystems.
+func SetLogLevels(def zerolog.Level, subsystems map[LogSubsystem]zerolog.Level) {
+	logLevels.mu.Lock()
+	defer logLevels.mu.Unlock()
+
+	logLevels.def = def
+	logLevels.subsystems = make(map[LogSubsystem]zerolog.Level, len(subsystems))
+	for subsystem, level := range subsystems {
+		logLevels.subsystems[subsystem] = level
+	}
+
+	setGlobalLogLevel()
+}
+
+// SetLogLevel sets the level of the subsystem, or the default level.
+func SetLogLevel(subsystem LogSubsystem, level zerolog.Level) {
+	logLevels.mu.Lock()
+	defer logLevels.mu.Unlock()
+
+	if subsystem == LogSubsystemDefault {
+		logLevels.def = level
+	} else {
+		if logLevels.subsystems == nil {
+			logLevels.subsystems = make(map[LogSubsystem]zerolog.Level)
+		}
+		logLevels.subsystems[subsystem] = level
+	}
+
+	setGlobalLogLevel()
+}
+
+// LogLevel returns the level the subsystem logs at.
+func LogLevel(subsystem LogSubsystem) zerolog.Level {
+	logLevels.mu.RLock()
+	defer logLevels.mu.RUnlock()
+
+	if level, ok := logLevels.subsystems[subsystem]; ok {
+		return level
+	}
+
+	return logLevels.def
+}
+
+// setGlobalLogLevel lowers the zerolog global level to the most verbose
+// level set, logLevelHook drops what the default level does not log.
+func setGlobalLogLevel() {
+	level := logLevels.def
+	for _, subsystemLevel := range logLevels.subsystems {
+		level = min(level, subsystemLevel)
+	}
+	zerolog.SetGlobalLevel(level)
+}
+
+type logSubsystemKey struct{}
+
+type logLevelHook struct{}
+
+// LogLevelHook drops the events below the level of their subsystem. It
+// has to be added to the global logger for the subsystem levels to work.
+func LogLevelHook() zerolog.Hook {
+	return logLevelHook{}
+}
+
+func (logLevelHook) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level == zerolog.NoLevel {
+		return
+	}
+
+	subsystem, _ := e.GetCtx().Value(logSubsystemKey{}).(LogSubsystem)
+	if level < LogLevel(subsystem) {
+		e.Discard()
+	}
+}
+
+// SubsystemLogger logs for a subsystem at its level, the events carry a
+// subsystem field.
+type SubsystemLogger struct {
+	subsystem LogSubsystem
+	ctx       context.Context
+}
+
+func NewSubsystemLogger(subsystem LogSubsystem) SubsystemLogger {
+	return SubsystemLogger{
+		subsystem: subsystem,
+		ctx:       context.WithValue(context.Background(), logSubsystemKey{}, subsystem),
+	}
+}
+
+func (l SubsystemLogger) event(e *zerolog.Event) *zerolog.Event {
+	return e.Str("subsystem", string(l.subsystem)).Ctx(l.ctx)
+}
+
+func (l SubsystemLogger) Trace() *zerolog.Event { return l.event(log.Trace()) }
+func (l SubsystemLogger) Debug() *zerolog.Event { return l.event(log.Debug()) }
+func (l SubsystemLogger) Info() *zerolog.Event  { return l.event(log.Info()) }
+func (l SubsystemLogger) Warn() *zerolog.Event  { return l.event(log.Warn()) }
+func (l SubsystemLogger) Error() *zerolog.Event { return l.event(log.Error()) }
+func (l SubsystemLogger) Fatal() *zerolog.Event { return l.event(log.Fatal()) }
+
+// Logger returns a logger of the subsystem, for libraries taking one.
+func (l SubsystemLogger) Logger() zerolog.Logger {
+	return log.Logger.With().Str("subsystem", string(l.subsystem)).Ctx(l.ctx).Logger()
+}
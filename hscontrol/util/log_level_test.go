@@ -0,0 +1,95 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestSubsystemLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	previous := log.Logger
+	previousLevel := zerolog.GlobalLevel()
+	log.Logger = zerolog.New(&buf).Hook(LogLevelHook())
+	t.Cleanup(func() {
+		log.Logger = previous
+		zerolog.SetGlobalLevel(previousLevel)
+		SetLogLevels(zerolog.InfoLevel, nil)
+	})
+
+	policyLog := NewSubsystemLogger(LogSubsystemPolicy)
+	dbLog := NewSubsystemLogger(LogSubsystemDB)
+
+	logged := func() []string {
+		t.Helper()
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line == "" {
+				continue
+			}
+
+			var event map[string]string
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("log line %q is not JSON: %s", line, err)
+			}
+			got = append(got, event["subsystem"]+" "+event["level"]+" "+event["message"])
+		}
+		buf.Reset()
+
+		return got
+	}
+
+	SetLogLevels(zerolog.InfoLevel, map[LogSubsystem]zerolog.Level{
+		LogSubsystemPolicy: zerolog.DebugLevel,
+	})
+
+	log.Debug().Msg("default debug")
+	log.Info().Msg("default info")
+	policyLog.Debug().Msg("policy debug")
+	policyLog.Trace().Msg("policy trace")
+	dbLog.Debug().Msg("db debug")
+	dbLog.Info().Msg("db info")
+
+	want := []string{
+		" info default info",
+		"policy debug policy debug",
+		"db info db info",
+	}
+	if diff := cmp.Diff(want, logged()); diff != "" {
+		t.Errorf("logged events mismatch (-want +got):\n%s", diff)
+	}
+
+	// Levels change at runtime.
+	SetLogLevel(LogSubsystemDB, zerolog.TraceLevel)
+	SetLogLevel(LogSubsystemDefault, zerolog.WarnLevel)
+
+	log.Info().Msg("default info")
+	dbLog.Trace().Msg("db trace")
+	policyLog.Debug().Msg("policy debug")
+
+	want = []string{
+		"db trace db trace",
+		"policy debug policy debug",
+	}
+	if diff := cmp.Diff(want, logged()); diff != "" {
+		t.Errorf("logged events after change mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseLogSubsystem(t *testing.T) {
+	for _, name := range []string{"", "policy", "mapper", "derp", "oidc", "db"} {
+		if _, err := ParseLogSubsystem(name); err != nil {
+			t.Errorf("ParseLogSubsystem(%q) error = %s", name, err)
+		}
+	}
+
+	if _, err := ParseLogSubsystem("ldap"); err == nil {
+		t.Errorf("ParseLogSubsystem(%q) error = nil, want error", "ldap")
+	}
+}
@@ -0,0 +1,82 @@
+package util
+
+import (
+	"io"
+	"regexp"
+	"sync/atomic"
+)
+
+const redacted = "[REDACTED]"
+
+// logRedaction is set by log.redact, it is on unless turned off for
+// development.
+var logRedaction atomic.Bool
+
+func init() {
+	logRedaction.Store(true)
+}
+
+// SetLogRedaction turns the redaction of the logs on or off.
+func SetLogRedaction(enabled bool) {
+	logRedaction.Store(enabled)
+}
+
+// LogRedaction reports whether sensitive values are redacted from the
+// logs. Code logging whole requests or files checks it to leave them out.
+func LogRedaction() bool {
+	return logRedaction.Load()
+}
+
+var (
+	// Node, machine and disco keys keep a short prefix to tell nodes
+	// apart, private keys are removed.
+	redactPublicKeyRegex  = regexp.MustCompile(`\b(nodekey|mkey|discokey):([0-9a-f]{8})[0-9a-f]{56}\b`)
+	redactPrivateKeyRegex = regexp.MustCompile(`\b(privkey|nlpriv):[0-9a-f]{64}\b`)
+
+	// Pre-auth keys are 48 hex characters.
+	redactAuthKeyRegex = regexp.MustCompile(`\b[0-9a-f]{48}\b`)
+
+	// OIDC tokens are JWTs, API keys are sent as bearer tokens.
+	redactJWTRegex    = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]{4,}\.[A-Za-z0-9_-]*`)
+	redactBearerRegex = regexp.MustCompile(`(?i)\b(bearer|basic) [A-Za-z0-9._~+/-]+=*`)
+
+	// Fields of logged requests and structs carrying secrets.
+	redactFieldRegex = regexp.MustCompile(
+		`(?i)("(?:authorization|cookie|set-cookie|authkey|auth_key|access_token|id_token|refresh_token|client_secret)"\s*:\s*)` +
+			`("(?:[^"\\]|\\.)*"|\[(?:[^\]"\\]|\\.|"(?:[^"\\]|\\.)*")*\])`,
+	)
+)
+
+// RedactLog replaces the keys, auth keys and tokens in a log line.
+func RedactLog(line []byte) []byte {
+	line = redactFieldRegex.ReplaceAll(line, []byte(`${1}"`+redacted+`"`))
+	line = redactPrivateKeyRegex.ReplaceAll(line, []byte(`${1}:`+redacted))
+	line = redactPublicKeyRegex.ReplaceAll(line, []byte(`${1}:${2}…`))
+	line = redactJWTRegex.ReplaceAll(line, []byte(redacted))
+	line = redactBearerRegex.ReplaceAll(line, []byte(`${1} `+redacted))
+	line = redactAuthKeyRegex.ReplaceAll(line, []byte(redacted))
+
+	return line
+}
+
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer redacting the log lines written
+// to w while LogRedaction is on.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w: w}
+}
+
+func (rw redactingWriter) Write(p []byte) (int, error) {
+	if !LogRedaction() {
+		return rw.w.Write(p)
+	}
+
+	if _, err := rw.w.Write(RedactLog(p)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}